@@ -0,0 +1,174 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bmt
+
+import (
+	"bytes"
+	"errors"
+)
+
+var errSegmentIndex = errors.New("segment index out of range")
+
+// Proof is an inclusion proof of a segment of a chunk in the BMT hash of the
+// chunk. The sister nodes are given from the bottom of the tree up, the
+// shape of the tree is determined by the length of the chunk data
+type Proof struct {
+	Index   int      // index of the segment in the chunk data
+	Segment []byte   // the segment, shorter than the segment size only at the end of the data
+	Sisters [][]byte // the sister nodes on the path of the segment to the root
+	Length  int      // length of the chunk data
+	Span    []byte   // the length prefix hashed together with the BMT root, nil if none
+}
+
+// Proof returns the inclusion proof of the segment at the index of the data.
+// The span is the length prefix of the chunk hash as given to ResetWithLength,
+// nil if the hash is the pure BMT root
+func (rh *RefHasher) Proof(d []byte, index int, span []byte) (*Proof, error) {
+	if len(d) > rh.cap {
+		d = d[:rh.cap]
+	}
+	size := rh.section / 2
+	if index < 0 || index*size >= len(d) {
+		return nil, errSegmentIndex
+	}
+	p := &Proof{
+		Index:  index,
+		Length: len(d),
+		Span:   span,
+	}
+	// collect the sisters from the root down the same way as hash builds the tree
+	var sisters [][]byte
+	s := rh.span
+	off := index * size
+	raw := false
+	for len(d) > rh.section {
+		for ; s >= len(d); s /= 2 {
+		}
+		if off < s {
+			right := d[s:]
+			if len(right) > size {
+				right = rh.hash(right, s)
+			}
+			sisters = append(sisters, right)
+			d = d[:s]
+			continue
+		}
+		sisters = append(sisters, rh.hash(d[:s], s))
+		d = d[s:]
+		off -= s
+		// a single segment on the right is not hashed on its own
+		if len(d) <= size {
+			raw = true
+			break
+		}
+	}
+	switch {
+	case raw:
+		p.Segment = d
+	case off < size:
+		end := size
+		if end > len(d) {
+			end = len(d)
+		}
+		p.Segment = d[:end]
+		sisters = append(sisters, d[end:])
+	default:
+		p.Segment = d[size:]
+		sisters = append(sisters, d[:size])
+	}
+	for i := len(sisters) - 1; i >= 0; i-- {
+		p.Sisters = append(p.Sisters, sisters[i])
+	}
+	return p, nil
+}
+
+// VerifyProof tells if the proof proves the inclusion of its segment in the
+// data under the hash
+func (rh *RefHasher) VerifyProof(hash []byte, p *Proof) bool {
+	root, ok := rh.proofRoot(p)
+	if !ok {
+		return false
+	}
+	if p.Span != nil {
+		defer rh.h.Reset()
+		rh.h.Write(p.Span)
+		rh.h.Write(root)
+		root = rh.h.Sum(nil)
+	}
+	return bytes.Equal(root, hash)
+}
+
+// proofRoot computes the BMT root from the segment and the sisters of the
+// proof following the path of the segment given by its index and the length
+func (rh *RefHasher) proofRoot(p *Proof) ([]byte, bool) {
+	size := rh.section / 2
+	if p.Length > rh.cap || p.Index < 0 || p.Index*size >= p.Length {
+		return nil, false
+	}
+	// the sides of the path from the root down, true if the path goes left
+	var lefts []bool
+	l := p.Length
+	s := rh.span
+	off := p.Index * size
+	raw := false
+	for l > rh.section {
+		for ; s >= l; s /= 2 {
+		}
+		if off < s {
+			lefts = append(lefts, true)
+			l = s
+			continue
+		}
+		lefts = append(lefts, false)
+		l -= s
+		off -= s
+		if l <= size {
+			raw = true
+			break
+		}
+	}
+	// the section of the segment is hashed unless it is a single segment on the right
+	if !raw {
+		lefts = append(lefts, off < size)
+	}
+	segmentSize := size
+	switch {
+	case raw:
+		segmentSize = l
+	case off >= size:
+		segmentSize = l - size
+	case l < size:
+		segmentSize = l
+	}
+	if len(p.Segment) != segmentSize || len(p.Sisters) != len(lefts) {
+		return nil, false
+	}
+	cur := p.Segment
+	for i, sister := range p.Sisters {
+		rh.h.Reset()
+		if lefts[len(lefts)-1-i] {
+			rh.h.Write(cur)
+			rh.h.Write(sister)
+		} else {
+			rh.h.Write(sister)
+			rh.h.Write(cur)
+		}
+		cur = rh.h.Sum(nil)
+	}
+	rh.h.Reset()
+	return cur, true
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bmt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+)
+
+// TestProof tests that the inclusion proofs of all segments are verified
+// against the chunk hash computed by the Hasher for all data lengths
+func TestProof(t *testing.T) {
+	hasher := sha3.NewKeccak256
+	pool := NewTreePool(hasher, DefaultSegmentCount, 1)
+	defer pool.Drain(0)
+	bmt := New(pool)
+	rbmt := NewRefHasher(hasher, DefaultSegmentCount)
+
+	data := make([]byte, 4096)
+	rand.Read(data)
+	for n := 1; n <= 4096; n += 31 {
+		d := data[:n]
+		span := make([]byte, 8)
+		binary.LittleEndian.PutUint64(span, uint64(n))
+		bmt.ResetWithLength(span)
+		bmt.Write(d)
+		hash := bmt.Sum(nil)
+
+		for i := 0; i*32 < n; i++ {
+			p, err := rbmt.Proof(d, i, span)
+			if err != nil {
+				t.Fatalf("length %d segment %d: %v", n, i, err)
+			}
+			if !rbmt.VerifyProof(hash, p) {
+				t.Fatalf("length %d segment %d: proof not verified", n, i)
+			}
+
+			// a proof of different content is not verified
+			p.Segment = append([]byte{p.Segment[0] + 1}, p.Segment[1:]...)
+			if rbmt.VerifyProof(hash, p) {
+				t.Fatalf("length %d segment %d: proof of wrong segment verified", n, i)
+			}
+		}
+		if _, err := rbmt.Proof(d, (n+31)/32, span); err != errSegmentIndex {
+			t.Fatalf("length %d: expected error for segment beyond the data, got %v", n, err)
+		}
+	}
+}
+
+// TestProofIndex tests that a proof is not verified for another segment index
+func TestProofIndex(t *testing.T) {
+	rbmt := NewRefHasher(sha3.NewKeccak256, DefaultSegmentCount)
+	data := make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(i / 32)
+	}
+	// make two segments equal
+	copy(data[5*32:6*32], data[9*32:10*32])
+	hash := rbmt.Hash(data)
+	p, err := rbmt.Proof(data, 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rbmt.VerifyProof(hash, p) {
+		t.Fatal("proof not verified")
+	}
+	p.Index = 9
+	if rbmt.VerifyProof(hash, p) {
+		t.Fatal("proof verified for another segment index")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// accessNewPass uploads an access manifest granting access to the reference to
+// anyone knowing the password, read from the --password file or the prompt
+func accessNewPass(ctx *cli.Context) {
+	ref := accessRef(ctx, "Usage: swarm [--password <file>] access new pass <ref>")
+	password := getPassPhrase("Password granting access", 0, utils.MakePasswordList(ctx))
+
+	m, err := api.NewPasswordAccess(ref, password, nil)
+	if err != nil {
+		utils.Fatalf("could not create access manifest: %v", err)
+	}
+	uploadAccess(ctx, m)
+}
+
+// accessNewPK uploads an access manifest granting access to the reference to
+// the owner of the grantee key, published with the key of the swarm account
+func accessNewPK(ctx *cli.Context) {
+	ref := accessRef(ctx, "Usage: swarm access new pk --grantee-key <public key> <ref>")
+	granteeHex := ctx.String(SwarmAccessGranteeKeyFlag.Name)
+	if granteeHex == "" {
+		utils.Fatalf("--%s is required", SwarmAccessGranteeKeyFlag.Name)
+	}
+	grantee, err := parsePubkey(granteeHex)
+	if err != nil {
+		utils.Fatalf("invalid grantee key %s: %v", granteeHex, err)
+	}
+
+	// the node is only used to access the keystore of the account
+	cfg := defaultNodeConfig
+	utils.SetNodeConfig(ctx, &cfg)
+	stack, err := node.New(&cfg)
+	if err != nil {
+		utils.Fatalf("can't create node: %v", err)
+	}
+	publisher := getAccount(ctx.GlobalString(SwarmAccountFlag.Name), ctx, stack)
+
+	m, err := api.NewPKAccess(ref, publisher, grantee)
+	if err != nil {
+		utils.Fatalf("could not create access manifest: %v", err)
+	}
+	uploadAccess(ctx, m)
+}
+
+// accessResolve prints the reference wrapped by an access manifest decrypted
+// with the key of the swarm account for pk access, or with the password read
+// from the --password file or the prompt for password access, so that the
+// key of the grantee is never sent to the gateway
+func accessResolve(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) != 1 {
+		utils.Fatalf("Usage: swarm [--bzzaccount <account>] [--password <file>] access resolve <hash>")
+	}
+	bzzapi := strings.TrimRight(ctx.GlobalString(SwarmApiFlag.Name), "/")
+	client := swarm.NewClient(bzzapi)
+	m, _, err := client.DownloadManifest(args[0])
+	if err != nil {
+		utils.Fatalf("could not download access manifest: %v", err)
+	}
+
+	creds := &api.Credentials{}
+	for _, entry := range m.Entries {
+		if entry.Path != "" || entry.Access == nil {
+			continue
+		}
+		switch entry.Access.Type {
+		case api.AccessTypePK:
+			// the node is only used to access the keystore of the account
+			cfg := defaultNodeConfig
+			utils.SetNodeConfig(ctx, &cfg)
+			stack, err := node.New(&cfg)
+			if err != nil {
+				utils.Fatalf("can't create node: %v", err)
+			}
+			creds.PrivateKey = getAccount(ctx.GlobalString(SwarmAccountFlag.Name), ctx, stack)
+		case api.AccessTypePass:
+			creds.Password = getPassPhrase("Password of the access manifest", 0, utils.MakePasswordList(ctx))
+		}
+	}
+	ref, err := api.ResolveAccessManifest(m, creds)
+	if err != nil {
+		utils.Fatalf("could not resolve access manifest: %v", err)
+	}
+	fmt.Println(ref.Hex())
+}
+
+func accessRef(ctx *cli.Context, usage string) storage.Key {
+	args := ctx.Args()
+	if len(args) != 1 {
+		utils.Fatalf(usage)
+	}
+	ref, err := hexutil.Decode("0x" + strings.TrimPrefix(args[0], "0x"))
+	if err != nil || (len(ref) != 32 && len(ref) != 64) {
+		utils.Fatalf("invalid reference %s", args[0])
+	}
+	return storage.Key(ref)
+}
+
+func uploadAccess(ctx *cli.Context, m *api.Manifest) {
+	bzzapi := strings.TrimRight(ctx.GlobalString(SwarmApiFlag.Name), "/")
+	client := swarm.NewClient(bzzapi)
+	hash, err := client.UploadManifest(m, false)
+	if err != nil {
+		utils.Fatalf("could not upload access manifest: %v", err)
+	}
+	fmt.Println(hash)
+}
+
+// parsePubkey parses a compressed or uncompressed public key in hex
+func parsePubkey(s string) (*ecdsa.PublicKey, error) {
+	b, err := hexutil.Decode("0x" + strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 33 {
+		return crypto.DecompressPubkey(b)
+	}
+	pub := crypto.ToECDSAPub(b)
+	if pub == nil || pub.X == nil {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return pub, nil
+}
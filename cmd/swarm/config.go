@@ -68,15 +68,40 @@ const (
 	SWARM_ENV_SWAP_API             = "SWARM_SWAP_API"
 	SWARM_ENV_SYNC_DISABLE         = "SWARM_SYNC_DISABLE"
 	SWARM_ENV_SYNC_UPDATE_DELAY    = "SWARM_ENV_SYNC_UPDATE_DELAY"
+	SWARM_ENV_SYNC_RECEIPTS        = "SWARM_SYNC_RECEIPTS"
 	SWARM_ENV_DELIVERY_SKIP_CHECK  = "SWARM_DELIVERY_SKIP_CHECK"
 	SWARM_ENV_ENS_API              = "SWARM_ENS_API"
 	SWARM_ENV_ENS_ADDR             = "SWARM_ENS_ADDR"
 	SWARM_ENV_CORS                 = "SWARM_CORS"
+	SWARM_ENV_UPLOAD_QUOTA         = "SWARM_UPLOAD_QUOTA"
+	SWARM_ENV_WRITE_TOKENS         = "SWARM_WRITE_TOKENS"
+	SWARM_ENV_DEBUG_ADDR           = "SWARM_DEBUG_ADDR"
+	SWARM_ENV_FRAME_OPTIONS        = "SWARM_FRAME_OPTIONS"
+	SWARM_ENV_CSP                  = "SWARM_CSP"
+	SWARM_ENV_IMMUTABLE            = "SWARM_IMMUTABLE"
+	SWARM_ENV_RATE_LIMIT           = "SWARM_RATE_LIMIT"
+	SWARM_ENV_MAX_UPLOAD_SIZE      = "SWARM_MAX_UPLOAD_SIZE"
+	SWARM_ENV_MAX_REQUESTS         = "SWARM_MAX_REQUESTS"
 	SWARM_ENV_BOOTNODES            = "SWARM_BOOTNODES"
+	SWARM_ENV_STATIC_NODES         = "SWARM_STATIC_NODES"
+	SWARM_ENV_TRUSTED_NODES        = "SWARM_TRUSTED_NODES"
 	SWARM_ENV_PSS_ENABLE           = "SWARM_PSS_ENABLE"
 	SWARM_ENV_STORE_PATH           = "SWARM_STORE_PATH"
+	SWARM_ENV_STORE_SHARDS         = "SWARM_STORE_SHARDS"
 	SWARM_ENV_STORE_CAPACITY       = "SWARM_STORE_CAPACITY"
 	SWARM_ENV_STORE_CACHE_CAPACITY = "SWARM_STORE_CACHE_CAPACITY"
+	SWARM_ENV_STORE_RETRIEVED      = "SWARM_STORE_RETRIEVED_SIZE"
+	SWARM_ENV_STORE_BYTES          = "SWARM_STORE_BYTES"
+	SWARM_ENV_STORE_CACHE_BYTES    = "SWARM_STORE_CACHE_BYTES"
+	SWARM_ENV_STORE_ENCRYPT        = "SWARM_STORE_ENCRYPT"
+	SWARM_ENV_STORE_COMPACTION     = "SWARM_STORE_COMPACTION_INTERVAL"
+	SWARM_ENV_STORE_SCRUB          = "SWARM_STORE_SCRUB_INTERVAL"
+	SWARM_ENV_STORE_GC_HIGH        = "SWARM_STORE_GC_HIGH"
+	SWARM_ENV_STORE_GC_LOW         = "SWARM_STORE_GC_LOW"
+	SWARM_ENV_STORE_GC_BATCH       = "SWARM_STORE_GC_BATCH"
+	SWARM_ENV_RETRIEVE_BACKOFF     = "SWARM_RETRIEVE_BACKOFF"
+	SWARM_ENV_RETRIEVE_BACKOFF_MAX = "SWARM_RETRIEVE_BACKOFF_MAX"
+	SWARM_ENV_RETRIEVE_JITTER      = "SWARM_RETRIEVE_JITTER"
 	GETH_ENV_DATADIR               = "GETH_DATADIR"
 )
 
@@ -204,11 +229,18 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.SyncUpdateDelay = d
 	}
 
+	if ctx.GlobalIsSet(SwarmSyncReceiptsFlag.Name) {
+		currentConfig.SyncReceipts = true
+	}
+
 	if ctx.GlobalIsSet(SwarmDeliverySkipCheckFlag.Name) {
 		currentConfig.DeliverySkipCheck = true
 	}
 
-	currentConfig.SwapApi = ctx.GlobalString(SwarmSwapAPIFlag.Name)
+	if swapapi := ctx.GlobalString(SwarmSwapAPIFlag.Name); swapapi != "" {
+		currentConfig.SwapApi = swapapi
+	}
+
 	if currentConfig.SwapEnabled && currentConfig.SwapApi == "" {
 		utils.Fatalf(SWARM_ERR_SWAP_SET_NO_API)
 	}
@@ -226,14 +258,67 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.Cors = cors
 	}
 
+	if frameOptions := ctx.GlobalString(SwarmFrameOptionsFlag.Name); frameOptions != "" {
+		currentConfig.FrameOptions = frameOptions
+	}
+
+	if csp := ctx.GlobalString(SwarmCSPFlag.Name); csp != "" {
+		currentConfig.CSP = csp
+	}
+
+	if ctx.GlobalIsSet(SwarmImmutableFlag.Name) {
+		currentConfig.Immutable = true
+	}
+
+	if rateLimit := ctx.GlobalFloat64(SwarmRateLimitFlag.Name); rateLimit != 0 {
+		currentConfig.RateLimit = rateLimit
+	}
+
+	if maxUploadSize := ctx.GlobalInt64(SwarmMaxUploadSizeFlag.Name); maxUploadSize != 0 {
+		currentConfig.MaxUploadSize = maxUploadSize
+	}
+
+	if maxRequests := ctx.GlobalInt(SwarmMaxRequestsFlag.Name); maxRequests != 0 {
+		currentConfig.MaxRequests = maxRequests
+	}
+
+	if uploadQuota := ctx.GlobalUint64(SwarmUploadQuotaFlag.Name); uploadQuota != 0 {
+		currentConfig.UploadQuota = uploadQuota
+	}
+
+	if writeTokens := ctx.GlobalString(SwarmWriteTokensFlag.Name); writeTokens != "" {
+		currentConfig.WriteTokens = strings.Split(writeTokens, ",")
+	}
+
+	if debugAddr := ctx.GlobalString(SwarmDebugAddrFlag.Name); debugAddr != "" {
+		currentConfig.DebugAddr = debugAddr
+	}
+
+	// the devp2p bootnodes are also used as overlay bootnodes, unless --bzzbootnodes is given
 	if ctx.GlobalIsSet(utils.BootnodesFlag.Name) {
 		currentConfig.BootNodes = ctx.GlobalString(utils.BootnodesFlag.Name)
 	}
 
+	if bootnodes := ctx.GlobalString(SwarmBootnodesFlag.Name); bootnodes != "" {
+		currentConfig.BootNodes = bootnodes
+	}
+
+	if staticNodes := ctx.GlobalString(SwarmStaticNodesFlag.Name); staticNodes != "" {
+		currentConfig.StaticNodes = staticNodes
+	}
+
+	if trustedNodes := ctx.GlobalString(SwarmTrustedNodesFlag.Name); trustedNodes != "" {
+		currentConfig.TrustedNodes = trustedNodes
+	}
+
 	if storePath := ctx.GlobalString(SwarmStorePath.Name); storePath != "" {
 		currentConfig.LocalStoreParams.ChunkDbPath = storePath
 	}
 
+	if storeShards := ctx.GlobalString(SwarmStoreShards.Name); storeShards != "" {
+		currentConfig.LocalStoreParams.ChunkDbShards = strings.Split(storeShards, ",")
+	}
+
 	if storeCapacity := ctx.GlobalUint64(SwarmStoreCapacity.Name); storeCapacity != 0 {
 		currentConfig.LocalStoreParams.DbCapacity = storeCapacity
 	}
@@ -242,6 +327,54 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.LocalStoreParams.CacheCapacity = storeCacheCapacity
 	}
 
+	if storeCachedCapacity := ctx.GlobalUint64(SwarmStoreCachedCapacity.Name); storeCachedCapacity != 0 {
+		currentConfig.LocalStoreParams.CachedCapacity = storeCachedCapacity
+	}
+
+	if storeBytes := ctx.GlobalUint64(SwarmStoreBytes.Name); storeBytes != 0 {
+		currentConfig.LocalStoreParams.DbSize = storeBytes
+	}
+
+	if storeCacheBytes := ctx.GlobalUint64(SwarmStoreCacheBytes.Name); storeCacheBytes != 0 {
+		currentConfig.LocalStoreParams.CacheSize = storeCacheBytes
+	}
+
+	if ctx.GlobalIsSet(SwarmStoreEncrypt.Name) {
+		currentConfig.LocalStoreParams.EncryptData = true
+	}
+
+	if d := ctx.GlobalDuration(SwarmStoreCompactionInterval.Name); d > 0 {
+		currentConfig.LocalStoreParams.CompactionInterval = d
+	}
+
+	if d := ctx.GlobalDuration(SwarmStoreScrubInterval.Name); d > 0 {
+		currentConfig.LocalStoreParams.ScrubInterval = d
+	}
+
+	if high := ctx.GlobalFloat64(SwarmStoreGCHighWatermark.Name); high > 0 {
+		currentConfig.LocalStoreParams.GCHighWatermark = high
+	}
+
+	if low := ctx.GlobalFloat64(SwarmStoreGCLowWatermark.Name); low > 0 {
+		currentConfig.LocalStoreParams.GCLowWatermark = low
+	}
+
+	if batch := ctx.GlobalInt(SwarmStoreGCBatchSize.Name); batch > 0 {
+		currentConfig.LocalStoreParams.GCBatchSize = batch
+	}
+
+	if d := ctx.GlobalDuration(SwarmRetrieveBackoff.Name); d > 0 {
+		currentConfig.RetrieveBackoff.Base = d
+	}
+
+	if d := ctx.GlobalDuration(SwarmRetrieveBackoffMax.Name); d > 0 {
+		currentConfig.RetrieveBackoff.Max = d
+	}
+
+	if ctx.GlobalIsSet(SwarmRetrieveJitter.Name) {
+		currentConfig.RetrieveBackoff.Jitter = ctx.GlobalFloat64(SwarmRetrieveJitter.Name)
+	}
+
 	return currentConfig
 
 }
@@ -278,29 +411,35 @@ func envVarsOverride(currentConfig *bzzapi.Config) (config *bzzapi.Config) {
 	}
 
 	if swapenable := os.Getenv(SWARM_ENV_SWAP_ENABLE); swapenable != "" {
-		if swap, err := strconv.ParseBool(swapenable); err != nil {
+		if swap, err := strconv.ParseBool(swapenable); err == nil {
 			currentConfig.SwapEnabled = swap
 		}
 	}
 
 	if syncdisable := os.Getenv(SWARM_ENV_SYNC_DISABLE); syncdisable != "" {
-		if sync, err := strconv.ParseBool(syncdisable); err != nil {
+		if sync, err := strconv.ParseBool(syncdisable); err == nil {
 			currentConfig.SyncEnabled = !sync
 		}
 	}
 
 	if v := os.Getenv(SWARM_ENV_DELIVERY_SKIP_CHECK); v != "" {
-		if skipCheck, err := strconv.ParseBool(v); err != nil {
+		if skipCheck, err := strconv.ParseBool(v); err == nil {
 			currentConfig.DeliverySkipCheck = skipCheck
 		}
 	}
 
 	if v := os.Getenv(SWARM_ENV_SYNC_UPDATE_DELAY); v != "" {
-		if d, err := time.ParseDuration(v); err != nil {
+		if d, err := time.ParseDuration(v); err == nil {
 			currentConfig.SyncUpdateDelay = d
 		}
 	}
 
+	if v := os.Getenv(SWARM_ENV_SYNC_RECEIPTS); v != "" {
+		if receipts, err := strconv.ParseBool(v); err == nil {
+			currentConfig.SyncReceipts = receipts
+		}
+	}
+
 	if swapapi := os.Getenv(SWARM_ENV_SWAP_API); swapapi != "" {
 		currentConfig.SwapApi = swapapi
 	}
@@ -325,6 +464,14 @@ func envVarsOverride(currentConfig *bzzapi.Config) (config *bzzapi.Config) {
 		currentConfig.BootNodes = bootnodes
 	}
 
+	if staticNodes := os.Getenv(SWARM_ENV_STATIC_NODES); staticNodes != "" {
+		currentConfig.StaticNodes = staticNodes
+	}
+
+	if trustedNodes := os.Getenv(SWARM_ENV_TRUSTED_NODES); trustedNodes != "" {
+		currentConfig.TrustedNodes = trustedNodes
+	}
+
 	return currentConfig
 }
 
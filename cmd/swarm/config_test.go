@@ -82,11 +82,21 @@ func TestConfigCmdLineOverrides(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	bootnode := "enode://ec8ae764f7cb0417bdfb009b9d0f18ab3818a3a4e8e7c67dd5f18971a93510a2e6f43cd0b69a27e439a9629457ea804104f37c85e41eed057d3faabbf7744cdf@127.0.0.1:30429"
 	flags := []string{
 		fmt.Sprintf("--%s", SwarmNetworkIdFlag.Name), "42",
 		fmt.Sprintf("--%s", SwarmPortFlag.Name), httpPort,
 		fmt.Sprintf("--%s", SwarmSyncDisabledFlag.Name),
 		fmt.Sprintf("--%s", CorsStringFlag.Name), "*",
+		fmt.Sprintf("--%s", SwarmFrameOptionsFlag.Name), "DENY",
+		fmt.Sprintf("--%s", SwarmCSPFlag.Name), "default-src 'self'",
+		fmt.Sprintf("--%s", SwarmImmutableFlag.Name),
+		fmt.Sprintf("--%s", SwarmRateLimitFlag.Name), "2.5",
+		fmt.Sprintf("--%s", SwarmMaxUploadSizeFlag.Name), "1000000",
+		fmt.Sprintf("--%s", SwarmMaxRequestsFlag.Name), "16",
+		fmt.Sprintf("--%s", SwarmBootnodesFlag.Name), bootnode,
+		fmt.Sprintf("--%s", SwarmStaticNodesFlag.Name), bootnode,
+		fmt.Sprintf("--%s", SwarmTrustedNodesFlag.Name), bootnode,
 		fmt.Sprintf("--%s", SwarmAccountFlag.Name), account.Address.String(),
 		fmt.Sprintf("--%s", SwarmDeliverySkipCheckFlag.Name),
 		fmt.Sprintf("--%s", EnsAPIFlag.Name), "",
@@ -137,6 +147,42 @@ func TestConfigCmdLineOverrides(t *testing.T) {
 		t.Fatalf("Expected Cors flag to be set to %s, got %s", "*", info.Cors)
 	}
 
+	if info.FrameOptions != "DENY" {
+		t.Fatalf("Expected FrameOptions flag to be set to %s, got %s", "DENY", info.FrameOptions)
+	}
+
+	if info.CSP != "default-src 'self'" {
+		t.Fatalf("Expected CSP flag to be set to %s, got %s", "default-src 'self'", info.CSP)
+	}
+
+	if !info.Immutable {
+		t.Fatal("Expected Immutable flag to be set")
+	}
+
+	if info.RateLimit != 2.5 {
+		t.Fatalf("Expected RateLimit flag to be set to %v, got %v", 2.5, info.RateLimit)
+	}
+
+	if info.MaxUploadSize != 1000000 {
+		t.Fatalf("Expected MaxUploadSize flag to be set to %d, got %d", 1000000, info.MaxUploadSize)
+	}
+
+	if info.MaxRequests != 16 {
+		t.Fatalf("Expected MaxRequests flag to be set to %d, got %d", 16, info.MaxRequests)
+	}
+
+	if info.BootNodes != bootnode {
+		t.Fatalf("Expected BootNodes flag to be set to %s, got %s", bootnode, info.BootNodes)
+	}
+
+	if info.StaticNodes != bootnode {
+		t.Fatalf("Expected StaticNodes flag to be set to %s, got %s", bootnode, info.StaticNodes)
+	}
+
+	if info.TrustedNodes != bootnode {
+		t.Fatalf("Expected TrustedNodes flag to be set to %s, got %s", bootnode, info.TrustedNodes)
+	}
+
 	node.Shutdown()
 }
 
@@ -465,6 +511,33 @@ func TestConfigCmdLineOverridesFile(t *testing.T) {
 	node.Shutdown()
 }
 
+func TestConfigEnvVarsOverride(t *testing.T) {
+	env := map[string]string{
+		SWARM_ENV_SYNC_DISABLE:        "true",
+		SWARM_ENV_DELIVERY_SKIP_CHECK: "true",
+		SWARM_ENV_SYNC_UPDATE_DELAY:   "3s",
+		SWARM_ENV_SYNC_RECEIPTS:       "true",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	conf := envVarsOverride(api.NewConfig())
+	if conf.SyncEnabled {
+		t.Fatal("Expected Sync to be disabled, but is true")
+	}
+	if !conf.DeliverySkipCheck {
+		t.Fatal("Expected DeliverySkipCheck to be enabled, but it is not")
+	}
+	if conf.SyncUpdateDelay != 3*time.Second {
+		t.Fatalf("Expected SyncUpdateDelay to be %v, got %v", 3*time.Second, conf.SyncUpdateDelay)
+	}
+	if !conf.SyncReceipts {
+		t.Fatal("Expected SyncReceipts to be enabled, but it is not")
+	}
+}
+
 func TestValidateConfig(t *testing.T) {
 	for _, c := range []struct {
 		cfg *api.Config
@@ -50,8 +50,13 @@ func download(ctx *cli.Context) {
 	var (
 		bzzapi      = strings.TrimRight(ctx.GlobalString(SwarmApiFlag.Name), "/")
 		isRecursive = ctx.Bool(SwarmRecursiveFlag.Name)
+		parallel    = ctx.Int(SwarmParallelFlag.Name)
 		client      = swarm.NewClient(bzzapi)
 	)
+	// password resolving password access manifests
+	if passwords := utils.MakePasswordList(ctx); len(passwords) > 0 {
+		client.Password = passwords[0]
+	}
 
 	if fi, err := os.Stat(dest); err == nil {
 		if isRecursive && !fi.Mode().IsDir() {
@@ -70,7 +75,12 @@ func download(ctx *cli.Context) {
 
 	// assume behaviour according to --recursive switch
 	if isRecursive {
-		if err := client.DownloadDirectory(uri.Addr, uri.Path, dest); err != nil {
+		if parallel > 1 {
+			err = client.DownloadDirectoryParallel(uri.Addr, uri.Path, dest, parallel)
+		} else {
+			err = client.DownloadDirectory(uri.Addr, uri.Path, dest)
+		}
+		if err != nil {
 			utils.Fatalf("encoutered an error while downloading directory: %v", err)
 		}
 	} else {
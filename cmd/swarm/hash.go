@@ -33,11 +33,19 @@ func hash(ctx *cli.Context) {
 	}
 	f, err := os.Open(args[0])
 	if err != nil {
-		utils.Fatalf("Error opening file " + args[1])
+		utils.Fatalf("Error opening file %s: %v", args[0], err)
 	}
 	defer f.Close()
 
-	stat, _ := f.Stat()
+	stat, err := f.Stat()
+	if err != nil {
+		utils.Fatalf("Error reading file %s: %v", args[0], err)
+	}
+	if stat.IsDir() {
+		utils.Fatalf("%s is a directory, swarm hash only works on files", args[0])
+	}
+	// the chunks are kept in memory only, no running node is needed to
+	// compute the same hash an upload of the raw file would return
 	dpa := storage.NewDPA(storage.NewMapChunkStore(), storage.NewDPAParams())
 	key, _, err := dpa.Store(f, stat.Size(), false)
 	if err != nil {
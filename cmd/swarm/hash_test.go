@@ -0,0 +1,59 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
+)
+
+// TestCLISwarmHash tests that 'swarm hash' prints the same hash without a
+// running node as uploading the raw file to one returns
+func TestCLISwarmHash(t *testing.T) {
+	cluster := newTestCluster(t, 1)
+	defer cluster.Shutdown()
+	client := swarm.NewClient(cluster.Nodes[0].URL)
+
+	for _, size := range []int{15, 4096, 4096*128 + 1} {
+		data := bytes.Repeat([]byte{'a'}, size)
+		tmp, err := ioutil.TempFile("", "swarm-hash-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		tmp.Close()
+
+		hash := runSwarm(t, "hash", tmp.Name())
+		_, matches := hash.ExpectRegexp(`[a-f\d]{64}`)
+		hash.ExpectExit()
+
+		expected, err := client.UploadRaw(bytes.NewReader(data), int64(size), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches[0] != expected {
+			t.Fatalf("size %d: expected hash %s, got %s", size, expected, matches[0])
+		}
+	}
+}
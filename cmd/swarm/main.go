@@ -37,8 +37,6 @@ import (
 	"github.com/ethereum/go-ethereum/internal/debug"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
-	"github.com/ethereum/go-ethereum/p2p"
-	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/swarm"
 	bzzapi "github.com/ethereum/go-ethereum/swarm/api"
@@ -122,6 +120,11 @@ var (
 		Usage:  "Duration for sync subscriptions update after no new peers are added (default 15s)",
 		EnvVar: SWARM_ENV_SYNC_UPDATE_DELAY,
 	}
+	SwarmSyncReceiptsFlag = cli.BoolFlag{
+		Name:   "sync-receipts",
+		Usage:  "Sign receipts for the synced chunks and wait for the receipts of the chunks sent (default false)",
+		EnvVar: SWARM_ENV_SYNC_RECEIPTS,
+	}
 	SwarmDeliverySkipCheckFlag = cli.BoolFlag{
 		Name:   "delivery-skip-check",
 		Usage:  "Skip chunk delivery check (default false)",
@@ -149,6 +152,14 @@ var (
 		Name:  "defaultpath",
 		Usage: "path to file served for empty url path (none)",
 	}
+	SwarmUploadIndexDocument = cli.StringFlag{
+		Name:  "index",
+		Usage: "name of the document served for the directories of an uploaded directory, e.g. index.html (none)",
+	}
+	SwarmUploadErrorDocument = cli.StringFlag{
+		Name:  "errordocument",
+		Usage: "path of the document served for the paths not found in an uploaded directory, e.g. 404.html (none)",
+	}
 	SwarmUpFromStdinFlag = cli.BoolFlag{
 		Name:  "stdin",
 		Usage: "reads data to be uploaded from stdin",
@@ -161,16 +172,90 @@ var (
 		Name:  "encrypt",
 		Usage: "use encrypted upload",
 	}
+	SwarmParallelFlag = cli.IntFlag{
+		Name:  "parallel",
+		Usage: "number of files downloaded at the same time with --recursive, 1 downloads them in a single stream",
+		Value: 1,
+	}
 	CorsStringFlag = cli.StringFlag{
 		Name:   "corsdomain",
 		Usage:  "Domain on which to send Access-Control-Allow-Origin header (multiple domains can be supplied separated by a ',')",
 		EnvVar: SWARM_ENV_CORS,
 	}
+	SwarmFrameOptionsFlag = cli.StringFlag{
+		Name:   "frameoptions",
+		Usage:  "X-Frame-Options header of the HTTP API responses, e.g. DENY or SAMEORIGIN (not sent if empty)",
+		EnvVar: SWARM_ENV_FRAME_OPTIONS,
+	}
+	SwarmCSPFlag = cli.StringFlag{
+		Name:   "csp",
+		Usage:  "Content-Security-Policy header of the HTTP API responses, e.g. \"default-src 'self'\" (not sent if empty)",
+		EnvVar: SWARM_ENV_CSP,
+	}
+	SwarmImmutableFlag = cli.BoolFlag{
+		Name:   "immutable",
+		Usage:  "Only serve content hashes from the HTTP API, as if all requests used the bzz-immutable scheme (default false)",
+		EnvVar: SWARM_ENV_IMMUTABLE,
+	}
+	SwarmRateLimitFlag = cli.Float64Flag{
+		Name:   "ratelimit",
+		Usage:  "Requests per second accepted by the HTTP API from each client IP, the burst is set in the config file (0 for no limit)",
+		EnvVar: SWARM_ENV_RATE_LIMIT,
+	}
+	SwarmMaxUploadSizeFlag = cli.Int64Flag{
+		Name:   "upload.maxsize",
+		Usage:  "Bytes accepted by the HTTP API in the body of an upload (0 for no limit)",
+		EnvVar: SWARM_ENV_MAX_UPLOAD_SIZE,
+	}
+	SwarmMaxRequestsFlag = cli.IntFlag{
+		Name:   "maxrequests",
+		Usage:  "Requests served by the HTTP API at the same time, requests over it are rejected (0 for no limit)",
+		EnvVar: SWARM_ENV_MAX_REQUESTS,
+	}
+	SwarmUploadQuotaFlag = cli.Uint64Flag{
+		Name:   "upload.quota",
+		Usage:  "Bytes accepted by the HTTP API from uploads without an API key, quotas of API keys are set in the config file (0 for no limit)",
+		EnvVar: SWARM_ENV_UPLOAD_QUOTA,
+	}
+	SwarmWriteTokensFlag = cli.StringFlag{
+		Name:   "write.tokens",
+		Usage:  "Comma separated API keys the HTTP API accepts uploads with, users with basic auth are set in the config file (anyone may upload if neither are set)",
+		EnvVar: SWARM_ENV_WRITE_TOKENS,
+	}
+	SwarmDebugAddrFlag = cli.StringFlag{
+		Name:   "debugaddr",
+		Usage:  "Address of the debug listener serving pprof, runtime stats and dumps of the kademlia table, streams and store (e.g. 127.0.0.1:8600, disabled if empty)",
+		EnvVar: SWARM_ENV_DEBUG_ADDR,
+	}
+	SwarmBootnodesFlag = cli.StringFlag{
+		Name:   "bzzbootnodes",
+		Usage:  "Comma separated enode URLs of the swarm overlay bootnodes, connected to one at a time (overrides --bootnodes, which sets both the devp2p and the overlay bootnodes)",
+		EnvVar: SWARM_ENV_BOOTNODES,
+	}
+	SwarmStaticNodesFlag = cli.StringFlag{
+		Name:   "bzzstaticnodes",
+		Usage:  "Comma separated enode URLs of the swarm overlay peers always kept connected, redialed when dropped",
+		EnvVar: SWARM_ENV_STATIC_NODES,
+	}
+	SwarmTrustedNodesFlag = cli.StringFlag{
+		Name:   "bzztrustednodes",
+		Usage:  "Comma separated enode URLs of the swarm overlay peers exempt from the connection limits and never removed from the overlay",
+		EnvVar: SWARM_ENV_TRUSTED_NODES,
+	}
+	SwarmAccessGranteeKeyFlag = cli.StringFlag{
+		Name:  "grantee-key",
+		Usage: "Public key in hex of the grantee of pk access",
+	}
 	SwarmStorePath = cli.StringFlag{
 		Name:   "store.path",
 		Usage:  "Path to leveldb chunk DB (default <$GETH_ENV_DIR>/swarm/bzz-<$BZZ_KEY>/chunks)",
 		EnvVar: SWARM_ENV_STORE_PATH,
 	}
+	SwarmStoreShards = cli.StringFlag{
+		Name:   "store.shards",
+		Usage:  "Comma separated paths of leveldb chunk DBs the chunks are spread across, overrides --store.path",
+		EnvVar: SWARM_ENV_STORE_SHARDS,
+	}
 	SwarmStoreCapacity = cli.Uint64Flag{
 		Name:   "store.size",
 		Usage:  "Number of chunks (5M is roughly 20-25GB) (default 5000000)",
@@ -181,6 +266,66 @@ var (
 		Usage:  "Number of recent chunks cached in memory (default 5000)",
 		EnvVar: SWARM_ENV_STORE_CACHE_CAPACITY,
 	}
+	SwarmStoreCachedCapacity = cli.Uint64Flag{
+		Name:   "store.retrieved.size",
+		Usage:  "Number of chunks retrieved for requests kept in the chunk DB, which are evicted before the chunks the node is responsible for (0 for no separate limit)",
+		EnvVar: SWARM_ENV_STORE_RETRIEVED,
+	}
+	SwarmStoreBytes = cli.Uint64Flag{
+		Name:   "store.bytes",
+		Usage:  "Capacity of the chunk DB in bytes, overrides --store.size",
+		EnvVar: SWARM_ENV_STORE_BYTES,
+	}
+	SwarmStoreCacheBytes = cli.Uint64Flag{
+		Name:   "store.cache.bytes",
+		Usage:  "Capacity of the in-memory chunk cache in bytes, overrides --store.cache.size",
+		EnvVar: SWARM_ENV_STORE_CACHE_BYTES,
+	}
+	SwarmStoreEncrypt = cli.BoolFlag{
+		Name:   "store.encrypt",
+		Usage:  "Encrypt the chunk data in the chunk DB with a key derived from the node key, only for a new chunk DB",
+		EnvVar: SWARM_ENV_STORE_ENCRYPT,
+	}
+	SwarmStoreCompactionInterval = cli.DurationFlag{
+		Name:   "store.compaction.interval",
+		Usage:  "Interval of the chunk DB compactions, which reclaim the space of garbage collected chunks (0 for none)",
+		EnvVar: SWARM_ENV_STORE_COMPACTION,
+	}
+	SwarmStoreScrubInterval = cli.DurationFlag{
+		Name:   "store.scrub.interval",
+		Usage:  "Interval of the integrity scans of the local store, which delete corrupted chunks (0 for none)",
+		EnvVar: SWARM_ENV_STORE_SCRUB,
+	}
+	SwarmStoreGCHighWatermark = cli.Float64Flag{
+		Name:   "store.gc.high",
+		Usage:  "Share of the chunk DB capacity above which chunks are garbage collected in the background (default 1)",
+		EnvVar: SWARM_ENV_STORE_GC_HIGH,
+	}
+	SwarmStoreGCLowWatermark = cli.Float64Flag{
+		Name:   "store.gc.low",
+		Usage:  "Share of the chunk DB capacity the background garbage collection collects down to (default 0.9 of --store.gc.high)",
+		EnvVar: SWARM_ENV_STORE_GC_LOW,
+	}
+	SwarmStoreGCBatchSize = cli.IntFlag{
+		Name:   "store.gc.batch",
+		Usage:  "Number of chunks garbage collected in one round (default 500)",
+		EnvVar: SWARM_ENV_STORE_GC_BATCH,
+	}
+	SwarmRetrieveBackoff = cli.DurationFlag{
+		Name:   "retrieve.backoff",
+		Usage:  "Delay before a failed chunk retrieval is retried, doubled on every further failure (default 3s)",
+		EnvVar: SWARM_ENV_RETRIEVE_BACKOFF,
+	}
+	SwarmRetrieveBackoffMax = cli.DurationFlag{
+		Name:   "retrieve.backoff.max",
+		Usage:  "Upper limit of the delay between chunk retrieval retries (default 1m)",
+		EnvVar: SWARM_ENV_RETRIEVE_BACKOFF_MAX,
+	}
+	SwarmRetrieveJitter = cli.Float64Flag{
+		Name:   "retrieve.jitter",
+		Usage:  "Fraction by which the chunk retrieval retry delays are randomized (default 0.2)",
+		EnvVar: SWARM_ENV_RETRIEVE_JITTER,
+	}
 )
 
 //declare a few constant error messages, useful for later error check comparisons in test
@@ -237,51 +382,106 @@ func init() {
 			Action:             hash,
 			CustomHelpTemplate: helpTemplate,
 			Name:               "hash",
-			Usage:              "print the swarm hash of a file or directory",
+			Usage:              "print the swarm hash of a file",
 			ArgsUsage:          "<file>",
-			Description:        "Prints the swarm hash of file or directory",
+			Description:        "Computes the swarm hash of a file locally, without a running node, and prints it. It is the same hash 'swarm --manifest=false up' returns for the file",
 		},
 		{
 			Action:    download,
 			Name:      "down",
-			Flags:     []cli.Flag{SwarmRecursiveFlag},
+			Flags:     []cli.Flag{SwarmRecursiveFlag, SwarmParallelFlag},
 			Usage:     "downloads a swarm manifest or a file inside a manifest",
 			ArgsUsage: " <uri> [<dir>]",
 			Description: `
-Downloads a swarm bzz uri to the given dir. When no dir is provided, working directory is assumed. --recursive flag is expected when downloading a manifest with multiple entries.
+Downloads a swarm bzz uri to the given dir. When no dir is provided, working directory is assumed. --recursive flag is expected when downloading a manifest with multiple entries, --parallel sets how many of its files are downloaded at the same time, --password reads the password of a password access manifest from a file. Interrupted downloads of a single file are resumed when run again, their progress is kept in <file>.swarm-progress.
 `,
 		},
+		{
+			Name:               "access",
+			CustomHelpTemplate: helpTemplate,
+			Usage:              "manage access to encrypted content",
+			ArgsUsage:          "access COMMAND",
+			Description:        "Creates access manifests wrapping the reference of content, usually uploaded with --encrypt, so that it can only be downloaded with the credentials granted access",
+			Subcommands: []cli.Command{
+				{
+					Name:               "new",
+					CustomHelpTemplate: helpTemplate,
+					Usage:              "create a new access manifest",
+					ArgsUsage:          "access new COMMAND",
+					Description:        "Creates and uploads an access manifest and prints its hash",
+					Subcommands: []cli.Command{
+						{
+							Action:             accessNewPass,
+							CustomHelpTemplate: helpTemplate,
+							Name:               "pass",
+							Usage:              "grant access to anyone knowing a password",
+							ArgsUsage:          "swarm [--password <file>] access new pass <ref>",
+							Description:        "Uploads an access manifest granting access to <ref> to anyone knowing the password, read from the --password file or prompted for. Downloads resolve it with the password in basic auth, or with swarm --password <file> down",
+						},
+						{
+							Action:             accessNewPK,
+							CustomHelpTemplate: helpTemplate,
+							Name:               "pk",
+							Flags:              []cli.Flag{SwarmAccessGranteeKeyFlag},
+							Usage:              "grant access to the owner of a public key",
+							ArgsUsage:          "swarm --bzzaccount <account> access new pk --grantee-key <public key> <ref>",
+							Description:        "Uploads an access manifest granting access to <ref> to the owner of the grantee key, published with the key of the --bzzaccount account. The grantee resolves it with swarm access resolve",
+						},
+					},
+				},
+				{
+					Action:             accessResolve,
+					CustomHelpTemplate: helpTemplate,
+					Name:               "resolve",
+					Usage:              "print the reference wrapped by an access manifest",
+					ArgsUsage:          "swarm [--bzzaccount <account>] [--password <file>] access resolve <hash>",
+					Description:        "Downloads the access manifest at <hash> and prints the reference it wraps, decrypted with the key of the --bzzaccount account for pk access or with the password for password access. The key never leaves the client, the content is then downloaded with swarm down bzz:/<reference>",
+				},
+			},
+		},
 
 		{
 			Name:               "manifest",
 			CustomHelpTemplate: helpTemplate,
 			Usage:              "perform operations on swarm manifests",
 			ArgsUsage:          "COMMAND",
-			Description:        "Updates a MANIFEST by adding/removing/updating the hash of a path.\nCOMMAND could be: add, update, remove",
+			Description:        "Updates a MANIFEST by adding/removing/updating the hash of a path, or prints the hash of a path, through the API of the node. This assumes you already have a Swarm node running locally. For all operations you must reference the correct path to bzzd.ipc in order to communicate with the node.\nCOMMAND could be: add, update, remove, hash",
 			Subcommands: []cli.Command{
 				{
 					Action:             add,
 					CustomHelpTemplate: helpTemplate,
 					Name:               "add",
+					Flags:              []cli.Flag{utils.IPCPathFlag},
 					Usage:              "add a new path to the manifest",
-					ArgsUsage:          "<MANIFEST> <path> <hash> [<content-type>]",
-					Description:        "Adds a new path to the manifest",
+					ArgsUsage:          "swarm manifest add --ipcpath <path to bzzd.ipc> <MANIFEST> <path> <hash> [<content-type>]",
+					Description:        "Adds a new path to the manifest and prints the hash of the new manifest",
 				},
 				{
 					Action:             update,
 					CustomHelpTemplate: helpTemplate,
 					Name:               "update",
+					Flags:              []cli.Flag{utils.IPCPathFlag},
 					Usage:              "update the hash for an already existing path in the manifest",
-					ArgsUsage:          "<MANIFEST> <path> <newhash> [<newcontent-type>]",
-					Description:        "Update the hash for an already existing path in the manifest",
+					ArgsUsage:          "swarm manifest update --ipcpath <path to bzzd.ipc> <MANIFEST> <path> <newhash> [<newcontent-type>]",
+					Description:        "Update the hash for an already existing path in the manifest and prints the hash of the new manifest",
 				},
 				{
 					Action:             remove,
 					CustomHelpTemplate: helpTemplate,
 					Name:               "remove",
+					Flags:              []cli.Flag{utils.IPCPathFlag},
 					Usage:              "removes a path from the manifest",
-					ArgsUsage:          "<MANIFEST> <path>",
-					Description:        "Removes a path from the manifest",
+					ArgsUsage:          "swarm manifest remove --ipcpath <path to bzzd.ipc> <MANIFEST> <path>",
+					Description:        "Removes a path from the manifest and prints the hash of the new manifest",
+				},
+				{
+					Action:             manifestHash,
+					CustomHelpTemplate: helpTemplate,
+					Name:               "hash",
+					Flags:              []cli.Flag{utils.IPCPathFlag},
+					Usage:              "print the hash of a path in the manifest",
+					ArgsUsage:          "swarm manifest hash --ipcpath <path to bzzd.ipc> <MANIFEST> <path>",
+					Description:        "Prints the hash of the content at the path in the manifest, looking it up in its submanifests",
 				},
 			},
 		},
@@ -321,6 +521,51 @@ Downloads a swarm bzz uri to the given dir. When no dir is provided, working dir
 				},
 			},
 		},
+		{
+			Name:               "resource",
+			CustomHelpTemplate: helpTemplate,
+			Usage:              "manage mutable resources",
+			ArgsUsage:          "resource COMMAND",
+			Description:        "Creates, updates and inspects mutable resources. This assumes you already have a Swarm node running locally. For all operations you must reference the correct path to bzzd.ipc in order to communicate with the node",
+			Subcommands: []cli.Command{
+				{
+					Action:             resourceCreate,
+					CustomHelpTemplate: helpTemplate,
+					Name:               "create",
+					Flags:              []cli.Flag{utils.IPCPathFlag},
+					Usage:              "create a new mutable resource",
+					ArgsUsage:          "swarm resource create --ipcpath <path to bzzd.ipc> <name> <frequency>",
+					Description:        "Creates a mutable resource for the ENS name <name>, updated every <frequency> blocks, and prints the key of its metadata chunk",
+				},
+				{
+					Action:             resourceUpdate,
+					CustomHelpTemplate: helpTemplate,
+					Name:               "update",
+					Flags:              []cli.Flag{utils.IPCPathFlag, SwarmUpFromStdinFlag},
+					Usage:              "add an update to a mutable resource",
+					ArgsUsage:          "swarm resource update --ipcpath <path to bzzd.ipc> [--stdin] <name> [<data>]",
+					Description:        "Adds <data> as a new update of the mutable resource <name>, signed with the node's key. With --stdin the update data is read from stdin",
+				},
+				{
+					Action:             resourceInfo,
+					CustomHelpTemplate: helpTemplate,
+					Name:               "info",
+					Flags:              []cli.Flag{utils.IPCPathFlag},
+					Usage:              "show the latest update of a mutable resource",
+					ArgsUsage:          "swarm resource info --ipcpath <path to bzzd.ipc> <name>",
+					Description:        "Looks up the latest update of the mutable resource <name> and prints its period, version, last block and data",
+				},
+				{
+					Action:             resourceList,
+					CustomHelpTemplate: helpTemplate,
+					Name:               "list",
+					Flags:              []cli.Flag{utils.IPCPathFlag},
+					Usage:              "list the mutable resources tracked by the node",
+					ArgsUsage:          "swarm resource list --ipcpath <path to bzzd.ipc>",
+					Description:        "Prints the name, owner, frequency, last period and version of every mutable resource the node follows or publishes",
+				},
+			},
+		},
 		{
 			Name:               "db",
 			CustomHelpTemplate: helpTemplate,
@@ -396,12 +641,25 @@ pv(1) tool to get a progress bar:
 		utils.PasswordFileFlag,
 		// bzzd-specific flags
 		CorsStringFlag,
+		SwarmFrameOptionsFlag,
+		SwarmCSPFlag,
+		SwarmImmutableFlag,
+		SwarmRateLimitFlag,
+		SwarmMaxUploadSizeFlag,
+		SwarmMaxRequestsFlag,
+		SwarmUploadQuotaFlag,
+		SwarmWriteTokensFlag,
+		SwarmDebugAddrFlag,
+		SwarmBootnodesFlag,
+		SwarmStaticNodesFlag,
+		SwarmTrustedNodesFlag,
 		EnsAPIFlag,
 		SwarmTomlConfigPathFlag,
 		SwarmSwapEnabledFlag,
 		SwarmSwapAPIFlag,
 		SwarmSyncDisabledFlag,
 		SwarmSyncUpdateDelay,
+		SwarmSyncReceiptsFlag,
 		SwarmDeliverySkipCheckFlag,
 		SwarmListenAddrFlag,
 		SwarmPortFlag,
@@ -413,12 +671,27 @@ pv(1) tool to get a progress bar:
 		SwarmRecursiveFlag,
 		SwarmWantManifestFlag,
 		SwarmUploadDefaultPath,
+		SwarmUploadIndexDocument,
+		SwarmUploadErrorDocument,
 		SwarmUpFromStdinFlag,
 		SwarmUploadMimeType,
 		// storage flags
 		SwarmStorePath,
+		SwarmStoreShards,
 		SwarmStoreCapacity,
 		SwarmStoreCacheCapacity,
+		SwarmStoreCachedCapacity,
+		SwarmStoreBytes,
+		SwarmStoreCacheBytes,
+		SwarmStoreEncrypt,
+		SwarmStoreCompactionInterval,
+		SwarmStoreScrubInterval,
+		SwarmStoreGCHighWatermark,
+		SwarmStoreGCLowWatermark,
+		SwarmStoreGCBatchSize,
+		SwarmRetrieveBackoff,
+		SwarmRetrieveBackoffMax,
+		SwarmRetrieveJitter,
 	}
 	rpcFlags := []cli.Flag{
 		utils.WSEnabledFlag,
@@ -486,6 +759,10 @@ func bzzd(ctx *cli.Context) error {
 	}
 	//setup the ethereum node
 	utils.SetNodeConfig(ctx, &cfg)
+	//the trusted overlay peers are also exempt from the devp2p peer limit
+	if trusted := swarm.ParseNodes(bzzconfig.TrustedNodes, "trusted node"); len(trusted) > 0 {
+		cfg.P2P.TrustedNodes = append(cfg.TrustedNodes(), trusted...)
+	}
 	stack, err := node.New(&cfg)
 	if err != nil {
 		utils.Fatalf("can't create node: %v", err)
@@ -493,6 +770,10 @@ func bzzd(ctx *cli.Context) error {
 	//a few steps need to be done after the config phase is completed,
 	//due to overriding behavior
 	initSwarmNode(bzzconfig, stack, ctx)
+	//the testnet bootnodes are used if no bootnodes are given
+	if bzzconfig.BootNodes == "" && bzzconfig.NetworkId == 3 {
+		bzzconfig.BootNodes = strings.Join(testbetBootNodes, ",")
+	}
 	//register BZZ as node.Service in the ethereum node
 	registerBzzService(bzzconfig, stack)
 	//start the node
@@ -507,16 +788,6 @@ func bzzd(ctx *cli.Context) error {
 		stack.Stop()
 	}()
 
-	// Add bootnodes as initial peers.
-	if bzzconfig.BootNodes != "" {
-		bootnodes := strings.Split(bzzconfig.BootNodes, ",")
-		injectBootnodes(stack.Server(), bootnodes)
-	} else {
-		if bzzconfig.NetworkId == 3 {
-			injectBootnodes(stack.Server(), testbetBootNodes)
-		}
-	}
-
 	stack.Wait()
 	return nil
 }
@@ -603,14 +874,3 @@ func getPassPhrase(prompt string, i int, passwords []string) string {
 	}
 	return password
 }
-
-func injectBootnodes(srv *p2p.Server, nodes []string) {
-	for _, url := range nodes {
-		n, err := discover.ParseNode(url)
-		if err != nil {
-			log.Error("Invalid swarm bootnode", "err", err)
-			continue
-		}
-		srv.AddPeer(n)
-	}
-}
@@ -18,84 +18,33 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"mime"
 	"path/filepath"
-	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/swarm/api"
-	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
 	"gopkg.in/urfave/cli.v1"
 )
 
-const bzzManifestJSON = "application/bzz-manifest+json"
-
 func add(ctx *cli.Context) {
 	args := ctx.Args()
 	if len(args) < 3 {
 		utils.Fatalf("Need at least three arguments <MHASH> <path> <HASH> [<content-type>]")
 	}
-
-	var (
-		mhash = args[0]
-		path  = args[1]
-		hash  = args[2]
-
-		ctype        string
-		wantManifest = ctx.GlobalBoolT(SwarmWantManifestFlag.Name)
-		mroot        api.Manifest
-	)
-
-	if len(args) > 3 {
-		ctype = args[3]
-	} else {
-		ctype = mime.TypeByExtension(filepath.Ext(path))
-	}
-
-	newManifest := addEntryToManifest(ctx, mhash, path, hash, ctype)
-	fmt.Println(newManifest)
-
-	if !wantManifest {
-		// Print the manifest. This is the only output to stdout.
-		mrootJSON, _ := json.MarshalIndent(mroot, "", "  ")
-		fmt.Println(string(mrootJSON))
-		return
-	}
+	entry := manifestEntry(args)
+	fmt.Println(callManifests(ctx, "bzz_addEntry", args[0], entry))
 }
 
 func update(ctx *cli.Context) {
-
 	args := ctx.Args()
 	if len(args) < 3 {
-		utils.Fatalf("Need at least three arguments <MHASH> <path> <HASH>")
-	}
-
-	var (
-		mhash = args[0]
-		path  = args[1]
-		hash  = args[2]
-
-		ctype        string
-		wantManifest = ctx.GlobalBoolT(SwarmWantManifestFlag.Name)
-		mroot        api.Manifest
-	)
-	if len(args) > 3 {
-		ctype = args[3]
-	} else {
-		ctype = mime.TypeByExtension(filepath.Ext(path))
-	}
-
-	newManifest := updateEntryInManifest(ctx, mhash, path, hash, ctype)
-	fmt.Println(newManifest)
-
-	if !wantManifest {
-		// Print the manifest. This is the only output to stdout.
-		mrootJSON, _ := json.MarshalIndent(mroot, "", "  ")
-		fmt.Println(string(mrootJSON))
-		return
+		utils.Fatalf("Need at least three arguments <MHASH> <path> <HASH> [<content-type>]")
 	}
+	entry := manifestEntry(args)
+	fmt.Println(callManifests(ctx, "bzz_updateEntry", args[0], entry))
 }
 
 func remove(ctx *cli.Context) {
@@ -103,229 +52,55 @@ func remove(ctx *cli.Context) {
 	if len(args) < 2 {
 		utils.Fatalf("Need at least two arguments <MHASH> <path>")
 	}
-
-	var (
-		mhash = args[0]
-		path  = args[1]
-
-		wantManifest = ctx.GlobalBoolT(SwarmWantManifestFlag.Name)
-		mroot        api.Manifest
-	)
-
-	newManifest := removeEntryFromManifest(ctx, mhash, path)
-	fmt.Println(newManifest)
-
-	if !wantManifest {
-		// Print the manifest. This is the only output to stdout.
-		mrootJSON, _ := json.MarshalIndent(mroot, "", "  ")
-		fmt.Println(string(mrootJSON))
-		return
-	}
+	fmt.Println(callManifests(ctx, "bzz_removeEntry", args[0], args[1]))
 }
 
-func addEntryToManifest(ctx *cli.Context, mhash, path, hash, ctype string) string {
-
-	var (
-		bzzapi           = strings.TrimRight(ctx.GlobalString(SwarmApiFlag.Name), "/")
-		client           = swarm.NewClient(bzzapi)
-		longestPathEntry = api.ManifestEntry{}
-	)
-
-	mroot, isEncrypted, err := client.DownloadManifest(mhash)
-	if err != nil {
-		utils.Fatalf("Manifest download failed: %v", err)
-	}
-
-	//TODO: check if the "hash" to add is valid and present in swarm
-	_, _, err = client.DownloadManifest(hash)
-	if err != nil {
-		utils.Fatalf("Hash to add is not present: %v", err)
+// manifestHash prints the hash of the content at the path of the manifest
+func manifestHash(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) < 2 {
+		utils.Fatalf("Need at least two arguments <MHASH> <path>")
 	}
+	var entry api.ManifestEntry
+	callManifestsResult(ctx, &entry, "bzz_getEntry", args[0], args[1])
+	fmt.Println(entry.Hash)
+}
 
-	// See if we path is in this Manifest or do we have to dig deeper
-	for _, entry := range mroot.Entries {
-		if path == entry.Path {
-			utils.Fatalf("Path %s already present, not adding anything", path)
-		} else {
-			if entry.ContentType == bzzManifestJSON {
-				prfxlen := strings.HasPrefix(path, entry.Path)
-				if prfxlen && len(path) > len(longestPathEntry.Path) {
-					longestPathEntry = entry
-				}
-			}
-		}
+// manifestEntry returns the entry of the <MHASH> <path> <HASH> [<content-type>]
+// arguments, the content type is guessed from the extension of the path if
+// it is not given
+func manifestEntry(args cli.Args) api.ManifestEntry {
+	entry := api.ManifestEntry{
+		Path: args[1],
+		Hash: args[2],
 	}
-
-	if longestPathEntry.Path != "" {
-		// Load the child Manifest add the entry there
-		newPath := path[len(longestPathEntry.Path):]
-		newHash := addEntryToManifest(ctx, longestPathEntry.Hash, newPath, hash, ctype)
-
-		// Replace the hash for parent Manifests
-		newMRoot := &api.Manifest{}
-		for _, entry := range mroot.Entries {
-			if longestPathEntry.Path == entry.Path {
-				entry.Hash = newHash
-			}
-			newMRoot.Entries = append(newMRoot.Entries, entry)
-		}
-		mroot = newMRoot
+	if len(args) > 3 {
+		entry.ContentType = args[3]
 	} else {
-		// Add the entry in the leaf Manifest
-		newEntry := api.ManifestEntry{
-			Hash:        hash,
-			Path:        path,
-			ContentType: ctype,
-		}
-		mroot.Entries = append(mroot.Entries, newEntry)
-	}
-
-	newManifestHash, err := client.UploadManifest(mroot, isEncrypted)
-	if err != nil {
-		utils.Fatalf("Manifest upload failed: %v", err)
+		entry.ContentType = mime.TypeByExtension(filepath.Ext(entry.Path))
 	}
-	return newManifestHash
-
+	return entry
 }
 
-func updateEntryInManifest(ctx *cli.Context, mhash, path, hash, ctype string) string {
-
-	var (
-		bzzapi           = strings.TrimRight(ctx.GlobalString(SwarmApiFlag.Name), "/")
-		client           = swarm.NewClient(bzzapi)
-		newEntry         = api.ManifestEntry{}
-		longestPathEntry = api.ManifestEntry{}
-	)
-
-	mroot, isEncrypted, err := client.DownloadManifest(mhash)
-	if err != nil {
-		utils.Fatalf("Manifest download failed: %v", err)
-	}
-
-	//TODO: check if the "hash" with which to update is valid and present in swarm
-
-	// See if we path is in this Manifest or do we have to dig deeper
-	for _, entry := range mroot.Entries {
-		if path == entry.Path {
-			newEntry = entry
-		} else {
-			if entry.ContentType == bzzManifestJSON {
-				prfxlen := strings.HasPrefix(path, entry.Path)
-				if prfxlen && len(path) > len(longestPathEntry.Path) {
-					longestPathEntry = entry
-				}
-			}
-		}
-	}
-
-	if longestPathEntry.Path == "" && newEntry.Path == "" {
-		utils.Fatalf("Path %s not present in the Manifest, not setting anything", path)
-	}
-
-	if longestPathEntry.Path != "" {
-		// Load the child Manifest add the entry there
-		newPath := path[len(longestPathEntry.Path):]
-		newHash := updateEntryInManifest(ctx, longestPathEntry.Hash, newPath, hash, ctype)
-
-		// Replace the hash for parent Manifests
-		newMRoot := &api.Manifest{}
-		for _, entry := range mroot.Entries {
-			if longestPathEntry.Path == entry.Path {
-				entry.Hash = newHash
-			}
-			newMRoot.Entries = append(newMRoot.Entries, entry)
-
-		}
-		mroot = newMRoot
-	}
-
-	if newEntry.Path != "" {
-		// Replace the hash for leaf Manifest
-		newMRoot := &api.Manifest{}
-		for _, entry := range mroot.Entries {
-			if newEntry.Path == entry.Path {
-				myEntry := api.ManifestEntry{
-					Hash:        hash,
-					Path:        entry.Path,
-					ContentType: ctype,
-				}
-				newMRoot.Entries = append(newMRoot.Entries, myEntry)
-			} else {
-				newMRoot.Entries = append(newMRoot.Entries, entry)
-			}
-		}
-		mroot = newMRoot
-	}
-
-	newManifestHash, err := client.UploadManifest(mroot, isEncrypted)
-	if err != nil {
-		utils.Fatalf("Manifest upload failed: %v", err)
-	}
-	return newManifestHash
+// callManifests edits the manifest with the method of the manifests API of
+// the node and returns the hash of the new manifest
+func callManifests(ctx *cli.Context, method string, args ...interface{}) string {
+	var hash string
+	callManifestsResult(ctx, &hash, method, args...)
+	return hash
 }
 
-func removeEntryFromManifest(ctx *cli.Context, mhash, path string) string {
-
-	var (
-		bzzapi           = strings.TrimRight(ctx.GlobalString(SwarmApiFlag.Name), "/")
-		client           = swarm.NewClient(bzzapi)
-		entryToRemove    = api.ManifestEntry{}
-		longestPathEntry = api.ManifestEntry{}
-	)
-
-	mroot, isEncrypted, err := client.DownloadManifest(mhash)
+func callManifestsResult(ctx *cli.Context, result interface{}, method string, args ...interface{}) {
+	client, err := dialRPC(ctx)
 	if err != nil {
-		utils.Fatalf("Manifest download failed: %v", err)
+		utils.Fatalf("had an error dailing to RPC endpoint: %v", err)
 	}
+	defer client.Close()
 
-	// See if we path is in this Manifest or do we have to dig deeper
-	for _, entry := range mroot.Entries {
-		if path == entry.Path {
-			entryToRemove = entry
-		} else {
-			if entry.ContentType == bzzManifestJSON {
-				prfxlen := strings.HasPrefix(path, entry.Path)
-				if prfxlen && len(path) > len(longestPathEntry.Path) {
-					longestPathEntry = entry
-				}
-			}
-		}
-	}
+	rctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	if longestPathEntry.Path == "" && entryToRemove.Path == "" {
-		utils.Fatalf("Path %s not present in the Manifest, not removing anything", path)
-	}
-
-	if longestPathEntry.Path != "" {
-		// Load the child Manifest remove the entry there
-		newPath := path[len(longestPathEntry.Path):]
-		newHash := removeEntryFromManifest(ctx, longestPathEntry.Hash, newPath)
-
-		// Replace the hash for parent Manifests
-		newMRoot := &api.Manifest{}
-		for _, entry := range mroot.Entries {
-			if longestPathEntry.Path == entry.Path {
-				entry.Hash = newHash
-			}
-			newMRoot.Entries = append(newMRoot.Entries, entry)
-		}
-		mroot = newMRoot
-	}
-
-	if entryToRemove.Path != "" {
-		// remove the entry in this Manifest
-		newMRoot := &api.Manifest{}
-		for _, entry := range mroot.Entries {
-			if entryToRemove.Path != entry.Path {
-				newMRoot.Entries = append(newMRoot.Entries, entry)
-			}
-		}
-		mroot = newMRoot
-	}
-
-	newManifestHash, err := client.UploadManifest(mroot, isEncrypted)
-	if err != nil {
-		utils.Fatalf("Manifest upload failed: %v", err)
+	if err := client.CallContext(rctx, result, method, args...); err != nil {
+		utils.Fatalf("had an error calling the RPC endpoint: %v", err)
 	}
-	return newManifestHash
 }
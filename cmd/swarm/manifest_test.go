@@ -0,0 +1,103 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/api"
+	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
+)
+
+// TestCLISwarmManifest tests that 'swarm manifest' adds, updates and removes
+// the paths of a manifest and prints their hashes through the node's API
+func TestCLISwarmManifest(t *testing.T) {
+	cluster := newTestCluster(t, 1)
+	defer cluster.Shutdown()
+
+	node := cluster.Nodes[0]
+	client := swarm.NewClient(node.URL)
+	ipcPath := filepath.Join(node.Dir, node.IpcPath)
+
+	upload := func(content string) string {
+		hash, err := client.UploadRaw(strings.NewReader(content), int64(len(content)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	hashA, hashB := upload("content a"), upload("content b")
+
+	mhash, err := client.UploadManifest(&api.Manifest{
+		Entries: []api.ManifestEntry{
+			{Hash: hashA, Path: "dir/a.txt", ContentType: "text/plain"},
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashRegexp := `[a-f\d]{64}`
+	run := func(args ...string) string {
+		cmd := runSwarm(t, append([]string{"manifest", args[0], "--ipcpath", ipcPath}, args[1:]...)...)
+		_, matches := cmd.ExpectRegexp(hashRegexp)
+		cmd.ExpectExit()
+		return matches[0]
+	}
+	download := func(mhash, path string) string {
+		file, err := client.Download(mhash, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	mhash = run("add", mhash, "dir/b.txt", hashB)
+	if content := download(mhash, "dir/b.txt"); content != "content b" {
+		t.Fatalf("expected added content %q, got %q", "content b", content)
+	}
+	if hash := run("hash", mhash, "dir/b.txt"); hash != hashB {
+		t.Fatalf("expected hash %s, got %s", hashB, hash)
+	}
+
+	mhash = run("update", mhash, "dir/a.txt", hashB)
+	if content := download(mhash, "dir/a.txt"); content != "content b" {
+		t.Fatalf("expected updated content %q, got %q", "content b", content)
+	}
+
+	mhash = run("remove", mhash, "dir/b.txt")
+	list, err := client.List(mhash, "dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths bytes.Buffer
+	for _, entry := range list.Entries {
+		paths.WriteString(entry.Path + " ")
+	}
+	if len(list.Entries) != 1 || list.Entries[0].Path != "dir/a.txt" {
+		t.Fatalf("expected only dir/a.txt left, got %s", paths.String())
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func resourceCreate(cliContext *cli.Context) {
+	args := cliContext.Args()
+	if len(args) < 2 {
+		utils.Fatalf("Usage: swarm resource create --ipcpath <path to bzzd.ipc> <name> <frequency>")
+	}
+	frequency, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		utils.Fatalf("invalid frequency %q: %v", args[1], err)
+	}
+
+	client, err := dialRPC(cliContext)
+	if err != nil {
+		utils.Fatalf("had an error dailing to RPC endpoint: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var key storage.Key
+	err = client.CallContext(ctx, &key, "bzz_resourceCreate", args[0], frequency)
+	if err != nil {
+		utils.Fatalf("had an error calling the RPC endpoint while creating resource: %v", err)
+	}
+	fmt.Println(key)
+}
+
+func resourceUpdate(cliContext *cli.Context) {
+	args := cliContext.Args()
+	fromStdin := cliContext.Bool(SwarmUpFromStdinFlag.Name) || cliContext.GlobalBool(SwarmUpFromStdinFlag.Name)
+	if len(args) < 1 || (len(args) < 2 && !fromStdin) {
+		utils.Fatalf("Usage: swarm resource update --ipcpath <path to bzzd.ipc> [--stdin] <name> [<data>]")
+	}
+	var data []byte
+	if fromStdin {
+		var err error
+		data, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			utils.Fatalf("error reading update data from stdin: %v", err)
+		}
+	} else {
+		data = []byte(args[1])
+	}
+
+	client, err := dialRPC(cliContext)
+	if err != nil {
+		utils.Fatalf("had an error dailing to RPC endpoint: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res := &api.ResourceUpdateResult{}
+	err = client.CallContext(ctx, res, "bzz_resourceUpdate", args[0], hexutil.Bytes(data))
+	if err != nil {
+		utils.Fatalf("had an error calling the RPC endpoint while updating resource: %v", err)
+	}
+	fmt.Printf("Key: %s\n", res.Key)
+	fmt.Printf("Period: %d\n", res.Period)
+	fmt.Printf("Version: %d\n", res.Version)
+}
+
+func resourceInfo(cliContext *cli.Context) {
+	args := cliContext.Args()
+	if len(args) < 1 {
+		utils.Fatalf("Usage: swarm resource info --ipcpath <path to bzzd.ipc> <name>")
+	}
+
+	client, err := dialRPC(cliContext)
+	if err != nil {
+		utils.Fatalf("had an error dailing to RPC endpoint: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	info := &api.ResourceInfo{}
+	err = client.CallContext(ctx, info, "bzz_resourceInfo", args[0])
+	if err != nil {
+		utils.Fatalf("had an error calling the RPC endpoint while looking up resource: %v", err)
+	}
+	var data hexutil.Bytes
+	err = client.CallContext(ctx, &data, "bzz_resourceLookup", args[0], 0, 0)
+	if err != nil {
+		utils.Fatalf("had an error calling the RPC endpoint while looking up resource: %v", err)
+	}
+	fmt.Printf("Name: %s\n", info.Name)
+	fmt.Printf("Name hash: %s\n", info.NameHash.Hex())
+	fmt.Printf("Start block: %d\n", info.StartBlock)
+	fmt.Printf("Frequency: %d\n", info.Frequency)
+	fmt.Printf("Period: %d\n", info.Period)
+	fmt.Printf("Version: %d\n", info.Version)
+	fmt.Printf("Last block: %d\n", info.LastBlock)
+	fmt.Printf("Data: %s\n", data)
+}
+
+func resourceList(cliContext *cli.Context) {
+	client, err := dialRPC(cliContext)
+	if err != nil {
+		utils.Fatalf("had an error dailing to RPC endpoint: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var list []*storage.ResourceListEntry
+	err = client.CallContext(ctx, &list, "bzz_resourceList")
+	if err != nil {
+		utils.Fatalf("had an error calling the RPC endpoint while listing resources: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "NAME\tOWNER\tFREQUENCY\tPERIOD\tVERSION\tLAST BLOCK")
+	for _, entry := range list {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\n", entry.Name, entry.Owner.Hex(), entry.Frequency, entry.LastPeriod, entry.Version, entry.LastBlock)
+	}
+}
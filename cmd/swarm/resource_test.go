@@ -0,0 +1,59 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// TestCLISwarmResource tests that 'swarm resource' creates a mutable resource,
+// updates it with the data given as argument or read from stdin and looks up
+// its latest update through the node's API
+func TestCLISwarmResource(t *testing.T) {
+	cluster := newTestCluster(t, 1)
+	defer cluster.Shutdown()
+
+	ipcPath := filepath.Join(cluster.Nodes[0].Dir, cluster.Nodes[0].IpcPath)
+	name := "foo.eth"
+
+	create := runSwarm(t, "resource", "create", "--ipcpath", ipcPath, name, "10")
+	create.ExpectRegexp(`[a-f\d]{64}`)
+	create.ExpectExit()
+
+	update := runSwarm(t, "resource", "update", "--ipcpath", ipcPath, name, "bar")
+	update.ExpectRegexp(`Version: 1`)
+	update.ExpectExit()
+
+	update = runSwarm(t, "resource", "update", "--ipcpath", ipcPath, "--stdin", name)
+	update.InputLine("baz")
+	update.CloseStdin()
+	update.ExpectRegexp(`Version: 2`)
+	update.ExpectExit()
+
+	info := runSwarm(t, "resource", "info", "--ipcpath", ipcPath, name)
+	_, matches := info.ExpectRegexp(`Version: (\d+)[\s\S]*Data: (0x[a-f\d]*)`)
+	info.ExpectExit()
+	if matches[1] != "2" {
+		t.Fatalf("expected version 2, got %s", matches[1])
+	}
+	if exp := hexutil.Encode([]byte("baz\n")); matches[2] != exp {
+		t.Fatalf("expected data %s, got %s", exp, matches[2])
+	}
+}
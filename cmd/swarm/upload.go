@@ -43,6 +43,8 @@ func upload(ctx *cli.Context) {
 		recursive    = ctx.GlobalBool(SwarmRecursiveFlag.Name)
 		wantManifest = ctx.GlobalBoolT(SwarmWantManifestFlag.Name)
 		defaultPath  = ctx.GlobalString(SwarmUploadDefaultPath.Name)
+		index        = ctx.GlobalString(SwarmUploadIndexDocument.Name)
+		errorDoc     = ctx.GlobalString(SwarmUploadErrorDocument.Name)
 		fromStdin    = ctx.GlobalBool(SwarmUpFromStdinFlag.Name)
 		mimeType     = ctx.GlobalString(SwarmUploadMimeType.Name)
 		client       = swarm.NewClient(bzzapi)
@@ -52,6 +54,15 @@ func upload(ctx *cli.Context) {
 
 	if len(args) != 1 {
 		if fromStdin {
+			if !wantManifest {
+				// stream stdin to swarm as its size is not needed
+				hash, err := client.UploadRaw(os.Stdin, -1, toEncrypt)
+				if err != nil {
+					utils.Fatalf("Upload failed: %s", err)
+				}
+				fmt.Println(hash)
+				return
+			}
 			tmp, err := ioutil.TempFile("", "swarm-stdin")
 			if err != nil {
 				utils.Fatalf("error create tempfile: %s", err)
@@ -98,7 +109,11 @@ func upload(ctx *cli.Context) {
 			if !recursive {
 				return "", errors.New("Argument is a directory and recursive upload is disabled")
 			}
-			return client.UploadDirectory(file, defaultPath, "", toEncrypt)
+			hash, err := client.UploadDirectory(file, defaultPath, "", toEncrypt)
+			if err != nil || (index == "" && errorDoc == "") {
+				return hash, err
+			}
+			return client.SetDocuments(hash, index, errorDoc)
 		}
 	} else {
 		doUpload = func() (string, error) {
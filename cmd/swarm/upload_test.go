@@ -26,6 +26,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -222,7 +223,7 @@ func testCLISwarmUpRecursive(toEncrypt bool, t *testing.T) {
 	log.Info("dir uploaded", "hash", hash)
 
 	// get the file from the HTTP API of each node
-	for _, node := range cluster.Nodes {
+	for i, node := range cluster.Nodes {
 		log.Info("getting file from node", "node", node.Name)
 		//try to get the content with `swarm down`
 		tmpDownload, err := ioutil.TempDir("", "swarm-test")
@@ -236,6 +237,8 @@ func testCLISwarmUpRecursive(toEncrypt bool, t *testing.T) {
 			"--bzzapi", cluster.Nodes[0].URL,
 			"down",
 			"--recursive",
+			// the first download is a single stream, the others are parallel
+			"--parallel", strconv.Itoa(i + 1),
 			bzzLocator,
 			tmpDownload,
 		}
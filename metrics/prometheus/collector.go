@@ -0,0 +1,108 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var pv = []float64{0.5, 0.75, 0.95, 0.99, 0.999, 0.9999}
+
+// collector is a collection of byte buffers that aggregate Prometheus reports
+// for different metric types.
+type collector struct {
+	buff      *bytes.Buffer
+	namespace string
+}
+
+// newCollector creates a new Prometheus metric aggregator.
+func newCollector(namespace string) *collector {
+	return &collector{
+		buff:      new(bytes.Buffer),
+		namespace: namespace,
+	}
+}
+
+func (c *collector) addCounter(name string, m metrics.Counter) {
+	// go-metrics counters may be decremented, so they are reported as gauges
+	c.writeGauge(name, strconv.FormatInt(m.Count(), 10))
+}
+
+func (c *collector) addGauge(name string, m metrics.Gauge) {
+	c.writeGauge(name, strconv.FormatInt(m.Value(), 10))
+}
+
+func (c *collector) addGaugeFloat64(name string, m metrics.GaugeFloat64) {
+	c.writeGauge(name, formatFloat(m.Value()))
+}
+
+func (c *collector) addHistogram(name string, m metrics.Histogram) {
+	values := make([]string, len(pv))
+	for i, p := range m.Percentiles(pv) {
+		values[i] = formatFloat(p)
+	}
+	c.writeSummary(name, pv, values, m.Count(), strconv.FormatInt(m.Sum(), 10))
+}
+
+func (c *collector) addMeter(name string, m metrics.Meter) {
+	c.writeGauge(name, strconv.FormatInt(m.Count(), 10))
+}
+
+func (c *collector) addTimer(name string, m metrics.Timer) {
+	values := make([]string, len(pv))
+	for i, p := range m.Percentiles(pv) {
+		values[i] = formatFloat(p)
+	}
+	c.writeSummary(name, pv, values, m.Count(), strconv.FormatInt(m.Sum(), 10))
+}
+
+func (c *collector) writeGauge(name string, value string) {
+	name = c.mangle(name)
+	fmt.Fprintf(c.buff, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(c.buff, "%s %s\n\n", name, value)
+}
+
+func (c *collector) writeSummary(name string, quantiles []float64, values []string, count int64, sum string) {
+	name = c.mangle(name)
+	fmt.Fprintf(c.buff, "# TYPE %s summary\n", name)
+	for i, q := range quantiles {
+		fmt.Fprintf(c.buff, "%s{quantile=\"%s\"} %s\n", name, formatFloat(q), values[i])
+	}
+	fmt.Fprintf(c.buff, "%s_sum %s\n", name, sum)
+	fmt.Fprintf(c.buff, "%s_count %d\n\n", name, count)
+}
+
+// mangle prefixes the name with the namespace and replaces all characters
+// which are not allowed in Prometheus metric names with underscores.
+func (c *collector) mangle(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		}
+		return '_'
+	}, c.namespace+name)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package prometheus
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func init() {
+	metrics.Enabled = true
+}
+
+func TestHandler(t *testing.T) {
+	reg := metrics.NewRegistry()
+	metrics.NewRegisteredCounter("stream.peer.failures", reg).Inc(3)
+	metrics.NewRegisteredGauge("network.kademlia.depth", reg).Update(2)
+	timer := metrics.NewRegisteredResettingTimer("peer.sendpriority_t.0", reg)
+	timer.Update(10 * time.Millisecond)
+	timer.Update(30 * time.Millisecond)
+
+	srv := httptest.NewServer(Handler(reg, "swarm_"))
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# TYPE swarm_network_kademlia_depth gauge
+swarm_network_kademlia_depth 2
+
+# TYPE swarm_stream_peer_failures gauge
+swarm_stream_peer_failures 3
+
+`
+	if string(body) != expected {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", body, expected)
+	}
+	// the resetting timer is left to the reporter which flushes it
+	if n := len(timer.Snapshot().Values()); n != 2 {
+		t.Fatalf("expected 2 resetting timer values, got %d", n)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+}
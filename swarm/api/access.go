@@ -0,0 +1,281 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"github.com/ethereum/go-ethereum/swarm/storage/encryption"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	AccessTypePass = "pass" // access granted to anyone knowing a password
+	AccessTypePK   = "pk"   // access granted to the owner of a public key
+)
+
+var (
+	// ErrNoCredentials is returned when the content is behind an access
+	// manifest and no credentials of its type are given
+	ErrNoCredentials = errors.New("no credentials for access manifest")
+	// ErrAccessDenied is returned when the credentials given do not grant
+	// access to the content
+	ErrAccessDenied = errors.New("access denied")
+	// ErrKdfBusy is returned when a password is not checked because as many
+	// session keys as allowed are being derived already
+	ErrKdfBusy = errors.New("too many password access resolutions in progress")
+	// ErrNotAccessManifest is returned when resolving a manifest which does
+	// not wrap its reference with an access entry
+	ErrNotAccessManifest = errors.New("not an access manifest")
+)
+
+// maxKdfs is the number of session keys of password access manifests derived
+// at the same time, the resolutions over it fail with ErrKdfBusy
+const maxKdfs = 2
+
+// kdfs holds a slot for each session key being derived
+var kdfs = make(chan struct{}, maxKdfs)
+
+// DefaultKdfParams are the scrypt parameters used to derive the session key
+// of password access manifests
+var DefaultKdfParams = &KdfParams{
+	N: 262144,
+	P: 1,
+	R: 8,
+}
+
+// MaxKdfParams are the highest scrypt parameters accepted in password access
+// manifests, so that a manifest can not make the node derive a key with more
+// memory and time than the default parameters
+var MaxKdfParams = &KdfParams{
+	N: DefaultKdfParams.N,
+	P: DefaultKdfParams.P,
+	R: DefaultKdfParams.R,
+}
+
+// KdfParams are the scrypt parameters of a password access manifest
+type KdfParams struct {
+	N int `json:"n"`
+	P int `json:"p"`
+	R int `json:"r"`
+}
+
+// AccessEntry describes how the reference wrapped by an access manifest is
+// encrypted and how to derive the session key decrypting it
+type AccessEntry struct {
+	Type      string        `json:"type"`
+	Publisher string        `json:"publisher,omitempty"` // compressed public key of the publisher of pk access
+	Salt      hexutil.Bytes `json:"salt"`
+	KdfParams *KdfParams    `json:"kdf_params,omitempty"`
+	Check     hexutil.Bytes `json:"check"` // tells if a session key is the right one
+}
+
+// Credentials are the secrets used to resolve access manifests, the password
+// for password access and the private key of the grantee for pk access
+type Credentials struct {
+	Password   string
+	PrivateKey *ecdsa.PrivateKey
+}
+
+// NewPasswordAccess returns an access manifest wrapping ref which can only be
+// resolved with the password, kdf defaults to DefaultKdfParams if nil
+func NewPasswordAccess(ref storage.Key, password string, kdf *KdfParams) (*Manifest, error) {
+	if kdf == nil {
+		kdf = DefaultKdfParams
+	}
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	ae := &AccessEntry{
+		Type:      AccessTypePass,
+		Salt:      salt,
+		KdfParams: kdf,
+	}
+	sessionKey, err := passwordSessionKey(password, ae)
+	if err != nil {
+		return nil, err
+	}
+	return newAccessManifest(ref, ae, sessionKey)
+}
+
+// NewPKAccess returns an access manifest wrapping ref which can only be
+// resolved with the private key of the grantee
+//
+// The session key is derived from the ECDH shared secret of the publisher and
+// the grantee keys, so the grantee needs the public key of the publisher which
+// is included in the manifest.
+func NewPKAccess(ref storage.Key, publisher *ecdsa.PrivateKey, grantee *ecdsa.PublicKey) (*Manifest, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	ae := &AccessEntry{
+		Type:      AccessTypePK,
+		Publisher: hexutil.Encode(crypto.CompressPubkey(&publisher.PublicKey)),
+		Salt:      salt,
+	}
+	sessionKey, err := pkSessionKey(publisher, grantee, ae.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return newAccessManifest(ref, ae, sessionKey)
+}
+
+// ResolveAccess returns the reference wrapped by the access manifest at key
+// decrypted with the credentials, the key is returned as is if it is not an
+// access manifest
+func (self *Api) ResolveAccess(key storage.Key, creds *Credentials) (storage.Key, error) {
+	trie, err := loadManifest(self.dpa, key, nil)
+	if err != nil {
+		// not a manifest, so not an access manifest either
+		return key, nil
+	}
+	entry := trie.entries[256]
+	if entry == nil || entry.Access == nil {
+		return key, nil
+	}
+	return resolveAccessEntry(&entry.ManifestEntry, creds)
+}
+
+// ResolveAccessManifest returns the reference wrapped by the access manifest m
+// decrypted with the credentials, so that clients can resolve access manifests
+// downloaded raw without giving their credentials to the node
+func ResolveAccessManifest(m *Manifest, creds *Credentials) (storage.Key, error) {
+	for i := range m.Entries {
+		if m.Entries[i].Path == "" && m.Entries[i].Access != nil {
+			return resolveAccessEntry(&m.Entries[i], creds)
+		}
+	}
+	return nil, ErrNotAccessManifest
+}
+
+func resolveAccessEntry(entry *ManifestEntry, creds *Credentials) (storage.Key, error) {
+	ae := entry.Access
+
+	var sessionKey []byte
+	var err error
+	switch ae.Type {
+	case AccessTypePass:
+		if creds == nil || creds.Password == "" {
+			return nil, ErrNoCredentials
+		}
+		select {
+		case kdfs <- struct{}{}:
+		default:
+			return nil, ErrKdfBusy
+		}
+		sessionKey, err = passwordSessionKey(creds.Password, ae)
+		<-kdfs
+	case AccessTypePK:
+		if creds == nil || creds.PrivateKey == nil {
+			return nil, ErrNoCredentials
+		}
+		publisher, perr := decodePublisher(ae.Publisher)
+		if perr != nil {
+			return nil, fmt.Errorf("invalid publisher key in access manifest: %v", perr)
+		}
+		sessionKey, err = pkSessionKey(creds.PrivateKey, publisher, ae.Salt)
+	default:
+		return nil, fmt.Errorf("unknown access type: %q", ae.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(accessCheck(sessionKey), ae.Check) {
+		return nil, ErrAccessDenied
+	}
+	return decryptRef(common.FromHex(entry.Hash), sessionKey)
+}
+
+func newAccessManifest(ref storage.Key, ae *AccessEntry, sessionKey []byte) (*Manifest, error) {
+	encRef, err := encryptRef(ref, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	ae.Check = accessCheck(sessionKey)
+	return &Manifest{
+		Entries: []ManifestEntry{{
+			Hash:        common.Bytes2Hex(encRef),
+			ContentType: ManifestType,
+			Access:      ae,
+		}},
+	}, nil
+}
+
+func decodePublisher(publisher string) (*ecdsa.PublicKey, error) {
+	b, err := hexutil.Decode(publisher)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.DecompressPubkey(b)
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func passwordSessionKey(password string, ae *AccessEntry) ([]byte, error) {
+	if ae.KdfParams == nil {
+		return nil, errors.New("missing kdf parameters in access manifest")
+	}
+	kdf := ae.KdfParams
+	if kdf.N <= 0 || kdf.N > MaxKdfParams.N || kdf.R <= 0 || kdf.R > MaxKdfParams.R || kdf.P <= 0 || kdf.P > MaxKdfParams.P {
+		return nil, fmt.Errorf("kdf parameters out of range in access manifest: n=%d r=%d p=%d", kdf.N, kdf.R, kdf.P)
+	}
+	return scrypt.Key([]byte(password), ae.Salt, ae.KdfParams.N, ae.KdfParams.R, ae.KdfParams.P, encryption.KeyLength)
+}
+
+func pkSessionKey(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, salt []byte) ([]byte, error) {
+	shared, err := ecies.ImportECDSA(priv).GenerateShared(ecies.ImportECDSAPublic(pub), 16, 16)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256(shared, salt), nil
+}
+
+// accessCheck is stored in the access manifest to tell wrong credentials
+// apart without revealing the session key
+func accessCheck(sessionKey []byte) []byte {
+	return crypto.Keccak256(sessionKey, []byte("check"))
+}
+
+func encryptRef(ref storage.Key, sessionKey []byte) ([]byte, error) {
+	return encryption.New(0, 0, sha3.NewKeccak256).Encrypt(ref, sessionKey)
+}
+
+func decryptRef(encRef []byte, sessionKey []byte) (storage.Key, error) {
+	ref, err := encryption.New(0, 0, sha3.NewKeccak256).Decrypt(encRef, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Key(ref), nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// testKdfParams are light scrypt parameters so that the tests are fast
+var testKdfParams = &KdfParams{N: 16, P: 1, R: 8}
+
+func storeManifest(t *testing.T, api *Api, m *Manifest) storage.Key {
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, wait, err := api.Store(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	return key
+}
+
+// TestAccessPassword tests that the reference wrapped by a password access
+// manifest is only resolved with the right password
+func TestAccessPassword(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		ref, wait, err := api.Put("secret", "text/plain", toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+
+		m, err := NewPasswordAccess(ref, "password", testKdfParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := storeManifest(t, api, m)
+
+		if _, err := api.ResolveAccess(key, nil); err != ErrNoCredentials {
+			t.Fatalf("expected %v without credentials, got %v", ErrNoCredentials, err)
+		}
+		if _, err := api.ResolveAccess(key, &Credentials{Password: "wrong"}); err != ErrAccessDenied {
+			t.Fatalf("expected %v with wrong password, got %v", ErrAccessDenied, err)
+		}
+		resolved, err := api.ResolveAccess(key, &Credentials{Password: "password"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(resolved, ref) {
+			t.Fatalf("expected %x, got %x", ref, resolved)
+		}
+		resp := testGet(t, api, resolved.Hex(), "")
+		checkResponse(t, resp, expResponse("secret", "text/plain", 0))
+
+		// content not behind an access manifest is resolved as is
+		resolved, err = api.ResolveAccess(ref, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(resolved, ref) {
+			t.Fatalf("expected %x, got %x", ref, resolved)
+		}
+	})
+}
+
+// TestAccessKdfParamsLimit tests that password access manifests asking for
+// scrypt parameters over the limit are rejected
+func TestAccessKdfParamsLimit(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		ref, wait, err := api.Put("secret", "text/plain", toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+
+		m, err := NewPasswordAccess(ref, "password", testKdfParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kdf := range []*KdfParams{
+			{N: MaxKdfParams.N * 2, P: 1, R: 8},
+			{N: 16, P: MaxKdfParams.P + 1, R: 8},
+			{N: 16, P: 1, R: MaxKdfParams.R + 1},
+			{N: 0, P: 1, R: 8},
+		} {
+			m.Entries[0].Access.KdfParams = kdf
+			key := storeManifest(t, api, m)
+			if _, err := api.ResolveAccess(key, &Credentials{Password: "password"}); err == nil {
+				t.Fatalf("expected error with kdf parameters %+v", kdf)
+			}
+		}
+	})
+}
+
+// TestAccessKdfBusy tests that passwords are not checked while as many
+// session keys as allowed are being derived
+func TestAccessKdfBusy(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		ref, wait, err := api.Put("secret", "text/plain", toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+
+		m, err := NewPasswordAccess(ref, "password", testKdfParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := storeManifest(t, api, m)
+
+		for i := 0; i < maxKdfs; i++ {
+			kdfs <- struct{}{}
+		}
+		_, err = api.ResolveAccess(key, &Credentials{Password: "password"})
+		for i := 0; i < maxKdfs; i++ {
+			<-kdfs
+		}
+		if err != ErrKdfBusy {
+			t.Fatalf("expected %v, got %v", ErrKdfBusy, err)
+		}
+		if _, err := api.ResolveAccess(key, &Credentials{Password: "password"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestAccessPK tests that the reference wrapped by a pk access manifest is
+// only resolved with the key of the grantee
+func TestAccessPK(t *testing.T) {
+	publisher, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	grantee, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testApi(t, func(api *Api, toEncrypt bool) {
+		ref, wait, err := api.Put("secret", "text/plain", toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+
+		m, err := NewPKAccess(ref, publisher, &grantee.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := storeManifest(t, api, m)
+
+		if _, err := api.ResolveAccess(key, &Credentials{Password: "password"}); err != ErrNoCredentials {
+			t.Fatalf("expected %v without key, got %v", ErrNoCredentials, err)
+		}
+		if _, err := api.ResolveAccess(key, &Credentials{PrivateKey: other}); err != ErrAccessDenied {
+			t.Fatalf("expected %v with other key, got %v", ErrAccessDenied, err)
+		}
+		resolved, err := api.ResolveAccess(key, &Credentials{PrivateKey: grantee})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(resolved, ref) {
+			t.Fatalf("expected %x, got %x", ref, resolved)
+		}
+
+		// resolved by the grantee from the manifest itself
+		resolved, err = ResolveAccessManifest(m, &Credentials{PrivateKey: grantee})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(resolved, ref) {
+			t.Fatalf("expected %x, got %x", ref, resolved)
+		}
+	})
+}
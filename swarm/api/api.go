@@ -18,6 +18,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -70,6 +71,10 @@ var (
 	apiGetInvalid      = metrics.NewRegisteredCounter("api.get.invalid", nil)
 )
 
+// ErrMutableContent is returned when the content of an immutable address is a
+// mutable resource
+var ErrMutableContent = errors.New("mutable resource cannot be served from an immutable address")
+
 type Resolver interface {
 	Resolve(string) (common.Hash, error)
 }
@@ -80,6 +85,12 @@ type ResolveValidator interface {
 	HeaderByNumber(context.Context, *big.Int) (*types.Header, error)
 }
 
+// OwnerValidator is implemented by the resolvers which tell if an address
+// may update the resources of a name
+type OwnerValidator interface {
+	ValidateOwner(name string, address common.Address) (bool, error)
+}
+
 // NoResolverError is returned by MultiResolver.Resolve if no resolver
 // can be found for the address.
 type NoResolverError struct {
@@ -155,36 +166,67 @@ func (m *MultiResolver) Resolve(addr string) (h common.Hash, err error) {
 	return
 }
 
+// ValidateOwner tells if the address may update the resources of the name,
+// the resolvers which validate owners themselves are asked first
 func (m *MultiResolver) ValidateOwner(name string, address common.Address) (bool, error) {
 	rs, err := m.getResolveValidator(name)
 	if err != nil {
 		return false, err
 	}
+	for _, r := range rs {
+		v, ok := r.(OwnerValidator)
+		if !ok {
+			continue
+		}
+		var valid bool
+		valid, err = v.ValidateOwner(name, address)
+		// we hide the error if it is not for the last resolver we check
+		if err == nil {
+			return valid, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	addr, err := m.OwnerOf(name)
+	if err != nil {
+		return false, err
+	}
+	return addr == address, nil
+}
+
+// OwnerOf returns the owner of the name
+func (m *MultiResolver) OwnerOf(name string) (common.Address, error) {
+	rs, err := m.getResolveValidator(name)
+	if err != nil {
+		return common.Address{}, err
+	}
 	var addr common.Address
 	for _, r := range rs {
 		addr, err = r.Owner(m.nameHash(name))
 		// we hide the error if it is not for the last resolver we check
 		if err == nil {
-			return addr == address, nil
+			return addr, nil
 		}
 	}
-	return false, err
+	return common.Address{}, err
 }
 
-func (m *MultiResolver) HeaderByNumber(ctx context.Context, name string, blockNr *big.Int) (*types.Header, error) {
+// BlockNumber returns the latest block height of the chain the name is registered on
+func (m *MultiResolver) BlockNumber(ctx context.Context, name string) (uint64, error) {
 	rs, err := m.getResolveValidator(name)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	for _, r := range rs {
 		var header *types.Header
-		header, err = r.HeaderByNumber(ctx, blockNr)
+		header, err = r.HeaderByNumber(ctx, nil)
 		// we hide the error if it is not for the last resolver we check
 		if err == nil {
-			return header, nil
+			return header.Number.Uint64(), nil
 		}
 	}
-	return nil, err
+	return 0, err
 }
 
 func (m *MultiResolver) getResolveValidator(name string) ([]ResolveValidator, error) {
@@ -240,11 +282,46 @@ func (self *Api) Retrieve(key storage.Key) (reader storage.LazySectionReader, is
 	return self.dpa.Retrieve(key)
 }
 
+// RetrieveWithContext is like Retrieve, but the reads of the reader fail once
+// the context is done
+func (self *Api) RetrieveWithContext(ctx context.Context, key storage.Key) (reader storage.LazySectionReader, isEncrypted bool) {
+	return self.dpa.RetrieveWithContext(ctx, key)
+}
+
+// WithTag returns an Api which counts the chunks of the content and the
+// manifests it stores in the tag
+func (self *Api) WithTag(tag *storage.Tag) *Api {
+	a := *self
+	a.dpa = self.dpa.WithTag(tag)
+	return &a
+}
+
 func (self *Api) Store(data io.Reader, size int64, toEncrypt bool) (key storage.Key, wait func(), err error) {
 	log.Debug("api.store", "size", size)
 	return self.dpa.Store(data, size, toEncrypt)
 }
 
+// StoreStream stores the content read from data until EOF, used for uploads
+// of unknown size
+func (self *Api) StoreStream(data io.Reader, toEncrypt bool) (key storage.Key, wait func(), err error) {
+	log.Debug("api.store.stream")
+	return self.dpa.StoreStream(data, toEncrypt)
+}
+
+// HasChunks tells for each chunk key if the chunk is stored locally, so that
+// clients can skip uploading content whose chunks are all stored already
+func (self *Api) HasChunks(keys []storage.Key) ([]bool, error) {
+	has := make([]bool, len(keys))
+	for i, key := range keys {
+		ok, err := self.dpa.Has(key)
+		if err != nil {
+			return nil, err
+		}
+		has[i] = ok
+	}
+	return has, nil
+}
+
 type ErrResolve error
 
 // DNS Resolver
@@ -311,10 +388,40 @@ func (self *Api) Put(content, contentType string, toEncrypt bool) (k storage.Key
 // to resolve basePath to content using dpa retrieve
 // it returns a section reader, mimeType, status, the key of the actual content and an error
 func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
-	log.Debug("api.get", "key", manifestKey, "path", path)
+	return self.GetVersion(manifestKey, path, 0, 0)
+}
+
+// GetVersion is like Get, but if the content is a mutable resource, its update
+// at the period and version is served, the latest update if both are 0
+//
+// The manifest key can also be the root key of a mutable resource, in which
+// case its update is served as if the resource was the entry of a manifest.
+func (self *Api) GetVersion(manifestKey storage.Key, path string, period, version uint32) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
+	return self.get(manifestKey, path, period, version, false)
+}
+
+// GetImmutable is like Get, but mutable resources are not followed, it fails
+// with ErrMutableContent instead so that the content served at the key and
+// path can never change
+func (self *Api) GetImmutable(manifestKey storage.Key, path string) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
+	return self.get(manifestKey, path, 0, 0, true)
+}
+
+func (self *Api) get(manifestKey storage.Key, path string, period, version uint32, immutable bool) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
+	log.Debug("api.get", "key", manifestKey, "path", path, "period", period, "version", version, "immutable", immutable)
 	apiGetCount.Inc(1)
 	trie, err := loadManifest(self.dpa, manifestKey, nil)
 	if err != nil {
+		// the key is not a manifest, check if it is the root key of a resource
+		if self.resource != nil {
+			if _, rerr := self.resource.LoadResource(context.TODO(), manifestKey); rerr == nil {
+				log.Trace("resource root key", "key", manifestKey)
+				if immutable {
+					return nil, "", http.StatusBadRequest, nil, ErrMutableContent
+				}
+				return self.getResource(manifestKey, path, period, version)
+			}
+		}
 		apiGetNotFound.Inc(1)
 		status = http.StatusNotFound
 		log.Warn(fmt.Sprintf("loadManifestTrie error: %v", err))
@@ -328,102 +435,125 @@ func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazyS
 		log.Debug("trie got entry", "key", manifestKey, "path", path, "entry.Hash", entry.Hash)
 		// we need to do some extra work if this is a mutable resource manifest
 		if entry.ContentType == ResourceContentType {
-
 			// get the resource root chunk key
 			log.Trace("resource type", "key", manifestKey, "hash", entry.Hash)
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-			rsrc, err := self.resource.LoadResource(storage.Key(common.FromHex(entry.Hash)))
-			if err != nil {
-				apiGetNotFound.Inc(1)
-				status = http.StatusNotFound
-				log.Debug(fmt.Sprintf("get resource content error: %v", err))
-				return reader, mimeType, status, nil, err
+			if immutable {
+				return nil, "", http.StatusBadRequest, nil, ErrMutableContent
 			}
+			return self.getResource(storage.Key(common.FromHex(entry.Hash)), path, period, version)
+		}
+		return self.getEntry(entry)
+	}
+	// no entry found
+	status = http.StatusNotFound
+	apiGetNotFound.Inc(1)
+	err = fmt.Errorf("manifest entry for '%s' not found", path)
+	log.Trace("manifest entry not found", "key", contentKey, "path", path)
+	return
+}
 
-			// use this key to retrieve the latest update
-			rsrc, err = self.resource.LookupLatest(ctx, rsrc.NameHash(), true, &storage.ResourceLookupParams{})
-			if err != nil {
-				apiGetNotFound.Inc(1)
-				status = http.StatusNotFound
-				log.Debug(fmt.Sprintf("get resource content error: %v", err))
-				return reader, mimeType, status, nil, err
-			}
+// getResource looks up the update of the resource with the root key at the
+// period and version, or the latest update if both are 0, and serves it
+//
+// If the update is a multihash, the entry of the path in the manifest the
+// multihash points to is served, otherwise the data of the update.
+func (self *Api) getResource(key storage.Key, path string, period, version uint32) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rsrc, err := self.resource.LoadResource(ctx, key)
+	if err != nil {
+		apiGetNotFound.Inc(1)
+		status = http.StatusNotFound
+		log.Debug(fmt.Sprintf("get resource content error: %v", err))
+		return reader, mimeType, status, nil, err
+	}
 
-			// if it's multihash, we will transparently serve the content this multihash points to
-			// \TODO this resolve is rather expensive all in all, review to see if it can be achieved cheaper
-			if rsrc.Multihash {
-
-				// get the data of the update
-				_, rsrcData, err := self.resource.GetContent(rsrc.NameHash().Hex())
-				if err != nil {
-					apiGetNotFound.Inc(1)
-					status = http.StatusNotFound
-					log.Warn(fmt.Sprintf("get resource content error: %v", err))
-					return reader, mimeType, status, nil, err
-				}
+	// use this key to retrieve the update
+	switch {
+	case version != 0:
+		if period == 0 {
+			apiGetInvalid.Inc(1)
+			return nil, "", http.StatusBadRequest, nil, storage.NewResourceError(storage.ErrInvalidValue, "Period can't be 0")
+		}
+		rsrc, err = self.resource.LookupVersion(ctx, rsrc.NameHash(), period, version, true, &storage.ResourceLookupParams{})
+	case period != 0:
+		rsrc, err = self.resource.LookupHistorical(ctx, rsrc.NameHash(), period, true, &storage.ResourceLookupParams{})
+	default:
+		rsrc, err = self.resource.LookupLatest(ctx, rsrc.NameHash(), true, &storage.ResourceLookupParams{})
+	}
+	if err != nil {
+		apiGetNotFound.Inc(1)
+		status = http.StatusNotFound
+		log.Debug(fmt.Sprintf("get resource content error: %v", err))
+		return reader, mimeType, status, nil, err
+	}
 
-				// validate that data as multihash
-				decodedMultihash, err := multihash.Decode(rsrcData)
-				if err != nil {
-					apiGetInvalid.Inc(1)
-					status = http.StatusInternalServerError
-					log.Warn(fmt.Sprintf("could not decode resource multihash: %v", err))
-					return reader, mimeType, status, nil, err
-				} else if decodedMultihash.Code != multihash.KECCAK_256 {
-					apiGetInvalid.Inc(1)
-					status = http.StatusUnprocessableEntity
-					log.Warn(fmt.Sprintf("invalid resource multihash code: %x", decodedMultihash.Code))
-					return reader, mimeType, status, nil, err
-				}
-				manifestKey = storage.Key(decodedMultihash.Digest)
-				log.Trace("resource is multihash", "key", manifestKey)
-
-				// get the manifest the multihash digest points to
-				trie, err := loadManifest(self.dpa, manifestKey, nil)
-				if err != nil {
-					apiGetNotFound.Inc(1)
-					status = http.StatusNotFound
-					log.Warn(fmt.Sprintf("loadManifestTrie (resource multihash) error: %v", err))
-					return reader, mimeType, status, nil, err
-				}
+	// if it's multihash, we will transparently serve the content this multihash points to
+	// \TODO this resolve is rather expensive all in all, review to see if it can be achieved cheaper
+	if !rsrc.Multihash {
+		// data is returned verbatim since it's not a multihash
+		return rsrc, "application/octet-stream", http.StatusOK, nil, nil
+	}
 
-				// finally, get the manifest entry
-				// it will always be the entry on path ""
-				entry, _ = trie.getEntry(path)
-				if entry == nil {
-					status = http.StatusNotFound
-					apiGetNotFound.Inc(1)
-					err = fmt.Errorf("manifest (resource multihash) entry for '%s' not found", path)
-					log.Trace("manifest (resource multihash) entry not found", "key", manifestKey, "path", path)
-					return reader, mimeType, status, nil, err
-				}
+	// get the data of the update
+	_, rsrcData, err := self.resource.GetContent(rsrc.NameHash().Hex())
+	if err != nil {
+		apiGetNotFound.Inc(1)
+		status = http.StatusNotFound
+		log.Warn(fmt.Sprintf("get resource content error: %v", err))
+		return reader, mimeType, status, nil, err
+	}
 
-			} else {
-				// data is returned verbatim since it's not a multihash
-				return rsrc, "application/octet-stream", http.StatusOK, nil, nil
-			}
-		}
+	// validate that data as multihash
+	decodedMultihash, err := multihash.Decode(rsrcData)
+	if err != nil {
+		apiGetInvalid.Inc(1)
+		status = http.StatusInternalServerError
+		log.Warn(fmt.Sprintf("could not decode resource multihash: %v", err))
+		return reader, mimeType, status, nil, err
+	} else if decodedMultihash.Code != multihash.KECCAK_256 {
+		apiGetInvalid.Inc(1)
+		status = http.StatusUnprocessableEntity
+		log.Warn(fmt.Sprintf("invalid resource multihash code: %x", decodedMultihash.Code))
+		return reader, mimeType, status, nil, err
+	}
+	manifestKey := storage.Key(decodedMultihash.Digest)
+	log.Trace("resource is multihash", "key", manifestKey)
+
+	// get the manifest the multihash digest points to
+	trie, err := loadManifest(self.dpa, manifestKey, nil)
+	if err != nil {
+		apiGetNotFound.Inc(1)
+		status = http.StatusNotFound
+		log.Warn(fmt.Sprintf("loadManifestTrie (resource multihash) error: %v", err))
+		return reader, mimeType, status, nil, err
+	}
 
-		// regardless of resource update manifests or normal manifests we will converge at this point
-		// get the key the manifest entry points to and serve it if it's unambiguous
-		contentKey = common.Hex2Bytes(entry.Hash)
-		status = entry.Status
-		if status == http.StatusMultipleChoices {
-			apiGetHttp300.Inc(1)
-			return nil, entry.ContentType, status, contentKey, err
-		} else {
-			mimeType = entry.ContentType
-			log.Debug("content lookup key", "key", contentKey, "mimetype", mimeType)
-			reader, _ = self.dpa.Retrieve(contentKey)
-		}
-	} else {
-		// no entry found
+	// finally, get the manifest entry
+	// it will always be the entry on path ""
+	entry, _ := trie.getEntry(path)
+	if entry == nil {
 		status = http.StatusNotFound
 		apiGetNotFound.Inc(1)
-		err = fmt.Errorf("manifest entry for '%s' not found", path)
-		log.Trace("manifest entry not found", "key", contentKey, "path", path)
+		err = fmt.Errorf("manifest (resource multihash) entry for '%s' not found", path)
+		log.Trace("manifest (resource multihash) entry not found", "key", manifestKey, "path", path)
+		return reader, mimeType, status, nil, err
 	}
+	return self.getEntry(entry)
+}
+
+// getEntry gets the key the manifest entry points to and serves it if it's
+// unambiguous, regardless of resource update manifests or normal manifests
+func (self *Api) getEntry(entry *manifestTrieEntry) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
+	contentKey = common.Hex2Bytes(entry.Hash)
+	status = entry.Status
+	if status == http.StatusMultipleChoices {
+		apiGetHttp300.Inc(1)
+		return nil, entry.ContentType, status, contentKey, err
+	}
+	mimeType = entry.ContentType
+	log.Debug("content lookup key", "key", contentKey, "mimetype", mimeType)
+	reader, _ = self.dpa.Retrieve(contentKey)
 	return
 }
 
@@ -472,9 +602,10 @@ func (self *Api) AddFile(mhash, path, fname string, content []byte, nameresolver
 		path = path[1:]
 	}
 
+	contentType, reader := DetectContentType(fname, bytes.NewReader(content))
 	entry := &ManifestEntry{
 		Path:        filepath.Join(path, fname),
-		ContentType: mime.TypeByExtension(filepath.Ext(fname)),
+		ContentType: contentType,
 		Mode:        0700,
 		Size:        int64(len(content)),
 		ModTime:     time.Now(),
@@ -486,7 +617,7 @@ func (self *Api) AddFile(mhash, path, fname string, content []byte, nameresolver
 		return nil, "", err
 	}
 
-	fkey, err := mw.AddEntry(bytes.NewReader(content), entry)
+	fkey, err := mw.AddEntry(reader, entry)
 	if err != nil {
 		apiAddFileFail.Inc(1)
 		return nil, "", err
@@ -547,30 +678,46 @@ func (self *Api) RemoveFile(mhash, path, fname string, nameresolver bool) (strin
 func (self *Api) AppendFile(mhash, path, fname string, existingSize int64, content []byte, oldKey storage.Key, offset int64, addSize int64, nameresolver bool) (storage.Key, string, error) {
 	apiAppendFileCount.Inc(1)
 
-	buffSize := offset + addSize
-	if buffSize < existingSize {
-		buffSize = existingSize
-	}
-
-	buf := make([]byte, buffSize)
+	// content added at the end is appended with the pyramid chunker, only
+	// the right edge of the tree of the existing content is recomputed
+	var appendedKey storage.Key
+	var combinedReader io.Reader
+	var totalSize int64
+	if offset == existingSize && oldKey != nil {
+		// the content may be shorter than addSize
+		appended := int64(len(content))
+		if addSize < appended {
+			appended = addSize
+		}
+		key, wait, err := self.dpa.Append(oldKey, io.LimitReader(bytes.NewReader(content), appended))
+		if err != nil {
+			apiAppendFileFail.Inc(1)
+			return nil, "", err
+		}
+		wait()
+		appendedKey = key
+		totalSize = existingSize + appended
+	} else {
+		buffSize := offset + addSize
+		if buffSize < existingSize {
+			buffSize = existingSize
+		}
 
-	oldReader, _ := self.Retrieve(oldKey)
-	io.ReadAtLeast(oldReader, buf, int(offset))
+		buf := make([]byte, buffSize)
 
-	newReader := bytes.NewReader(content)
-	io.ReadAtLeast(newReader, buf[offset:], int(addSize))
+		oldReader, _ := self.Retrieve(oldKey)
+		io.ReadAtLeast(oldReader, buf, int(offset))
 
-	if buffSize < existingSize {
-		io.ReadAtLeast(oldReader, buf[addSize:], int(buffSize))
-	}
+		newReader := bytes.NewReader(content)
+		io.ReadAtLeast(newReader, buf[offset:], int(addSize))
 
-	combinedReader := bytes.NewReader(buf)
-	totalSize := int64(len(buf))
+		if buffSize < existingSize {
+			io.ReadAtLeast(oldReader, buf[addSize:], int(buffSize))
+		}
 
-	// TODO(jmozah): to append using pyramid chunker when it is ready
-	//oldReader := self.Retrieve(oldKey)
-	//newReader := bytes.NewReader(content)
-	//combinedReader := io.MultiReader(oldReader, newReader)
+		combinedReader = bytes.NewReader(buf)
+		totalSize = int64(len(buf))
+	}
 
 	uri, err := Parse("bzz:/" + mhash)
 	if err != nil {
@@ -608,10 +755,15 @@ func (self *Api) AppendFile(mhash, path, fname string, existingSize int64, conte
 		ModTime:     time.Now(),
 	}
 
-	fkey, err := mw.AddEntry(io.Reader(combinedReader), entry)
-	if err != nil {
-		apiAppendFileFail.Inc(1)
-		return nil, "", err
+	fkey := appendedKey
+	if fkey != nil {
+		mw.addStoredEntry(fkey, entry)
+	} else {
+		fkey, err = mw.AddEntry(combinedReader, entry)
+		if err != nil {
+			apiAppendFileFail.Inc(1)
+			return nil, "", err
+		}
 	}
 
 	newMkey, err := mw.Store()
@@ -656,7 +808,7 @@ func (self *Api) BuildDirectoryTree(mhash string, nameresolver bool) (key storag
 // Look up mutable resource updates at specific periods and versions
 func (self *Api) ResourceLookup(ctx context.Context, key storage.Key, period uint32, version uint32, maxLookup *storage.ResourceLookupParams) (string, []byte, error) {
 	var err error
-	rsrc, err := self.resource.LoadResource(key)
+	rsrc, err := self.resource.LoadResource(ctx, key)
 	if err != nil {
 		return "", nil, err
 	}
@@ -676,6 +828,36 @@ func (self *Api) ResourceLookup(ctx context.Context, key storage.Key, period uin
 	return self.resource.GetContent(rsrc.NameHash().Hex())
 }
 
+// Look up mutable resource updates by name at specific block heights and versions
+//
+// The resource must be loaded in the resource index, either by creating it
+// on this node or by a previous lookup of its metadata chunk
+func (self *Api) ResourceLookupByName(ctx context.Context, name string, block uint64, version uint32, maxLookup *storage.ResourceLookupParams) (string, []byte, error) {
+	var period uint32
+	var err error
+	nameHash := storage.ResourceNameHash(name)
+	if block != 0 {
+		period, err = self.resource.BlockToPeriod(nameHash.Hex(), block)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if version != 0 {
+		if period == 0 {
+			return "", nil, storage.NewResourceError(storage.ErrInvalidValue, "Block can't be 0")
+		}
+		_, err = self.resource.LookupVersion(ctx, nameHash, period, version, true, maxLookup)
+	} else if period != 0 {
+		_, err = self.resource.LookupHistorical(ctx, nameHash, period, true, maxLookup)
+	} else {
+		_, err = self.resource.LookupLatest(ctx, nameHash, false, maxLookup)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return self.resource.GetContent(nameHash.Hex())
+}
+
 func (self *Api) ResourceCreate(ctx context.Context, name string, frequency uint64) (storage.Key, error) {
 	key, _, err := self.resource.NewResource(ctx, name, frequency)
 	if err != nil {
@@ -699,8 +881,9 @@ func (self *Api) resourceUpdate(ctx context.Context, name string, data []byte, m
 	} else {
 		key, err = self.resource.Update(ctx, name, data)
 	}
-	period, _ := self.resource.GetLastPeriod(name)
-	version, _ := self.resource.GetVersion(name)
+	nameHash := storage.ResourceNameHash(name).Hex()
+	period, _ := self.resource.GetLastPeriod(nameHash)
+	version, _ := self.resource.GetVersion(nameHash)
 	return key, period, version, err
 }
 
@@ -371,3 +371,46 @@ func TestMultiResolver(t *testing.T) {
 		})
 	}
 }
+
+// testOwnerValidator is a resolver which allows a single address to update
+// the resources of any name
+type testOwnerValidator struct {
+	*testResolveValidator
+	approved common.Address
+}
+
+func (t *testOwnerValidator) ValidateOwner(name string, address common.Address) (bool, error) {
+	return address == t.approved, nil
+}
+
+// TestMultiResolverValidateOwner tests that the resolvers validating owners
+// themselves are used to validate them, others by the name owner
+func TestMultiResolverValidateOwner(t *testing.T) {
+	approved := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	r := NewMultiResolver(
+		MultiResolverOptionWithResolver(newTestResolveValidator(""), "test"),
+		MultiResolverOptionWithResolver(&testOwnerValidator{newTestResolveValidator(""), approved}, "eth"),
+	)
+	for _, c := range []struct {
+		name    string
+		address common.Address
+		valid   bool
+	}{
+		{"swarm.eth", approved, true},
+		{"swarm.eth", common.Address{}, false},
+		// the owner of the names without an owner validator is the zero address
+		{"swarm.test", approved, false},
+		{"swarm.test", common.Address{}, true},
+	} {
+		valid, err := r.ValidateOwner(c.name, c.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != c.valid {
+			t.Fatalf("expected %x to be valid for %s: %v, got %v", c.address, c.name, c.valid, valid)
+		}
+	}
+	if _, err := r.ValidateOwner("swarm.bzz", approved); err == nil {
+		t.Fatal("expected an error for a name without a resolver")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"crypto/subtle"
+)
+
+// WriteAuth authorizes the requests of the HTTP API that change content, such
+// as uploads, so that a public gateway does not accept uploads from anyone
+//
+// Requests are authorized either by an API token in the Authorization header
+// with the Bearer scheme, or by a user and password with the Basic scheme.
+type WriteAuth struct {
+	tokens []string          // API tokens allowed to write
+	users  map[string]string // password of each user allowed to write
+}
+
+// NewWriteAuth returns the authorization of the given API tokens and users
+// with their passwords
+func NewWriteAuth(tokens []string, users map[string]string) *WriteAuth {
+	a := &WriteAuth{users: make(map[string]string)}
+	for _, token := range tokens {
+		if token != "" {
+			a.tokens = append(a.tokens, token)
+		}
+	}
+	for user, password := range users {
+		a.users[user] = password
+	}
+	return a
+}
+
+// Token tells if the API token is allowed to write
+func (a *WriteAuth) Token(token string) bool {
+	ok := false
+	for _, t := range a.tokens {
+		// compare all tokens in constant time so as not to leak them
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// User tells if the user with the password is allowed to write
+func (a *WriteAuth) User(user, password string) bool {
+	p, ok := a.users[user]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+}
+
+// Basic tells if users can be authorized with the Basic scheme
+func (a *WriteAuth) Basic() bool {
+	return len(a.users) > 0
+}
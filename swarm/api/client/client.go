@@ -35,6 +35,7 @@ import (
 	"strings"
 
 	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/storage"
 )
 
 var (
@@ -51,12 +52,34 @@ func NewClient(gateway string) *Client {
 // Client wraps interaction with a swarm HTTP gateway.
 type Client struct {
 	Gateway string
+
+	// Password is sent with the downloads to resolve password access
+	// manifests, see api.NewPasswordAccess
+	Password string
+}
+
+// get sends a GET request to the uri with the password of the client
+func (c *Client) get(uri string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.doGet(req)
+}
+
+// doGet sends the GET request with the password of the client, if any, in the
+// Authorization header
+func (c *Client) doGet(req *http.Request) (*http.Response, error) {
+	if c.Password != "" {
+		req.SetBasicAuth("", c.Password)
+	}
+	return http.DefaultClient.Do(req)
 }
 
 // UploadRaw uploads raw data to swarm and returns the resulting hash. If toEncrypt is true it
-// uploads encrypted data
+// uploads encrypted data. If the size is negative, the data is streamed until EOF
 func (c *Client) UploadRaw(r io.Reader, size int64, toEncrypt bool) (string, error) {
-	if size <= 0 {
+	if size == 0 {
 		return "", errors.New("data size must be greater than zero")
 	}
 	addr := ""
@@ -67,7 +90,9 @@ func (c *Client) UploadRaw(r io.Reader, size int64, toEncrypt bool) (string, err
 	if err != nil {
 		return "", err
 	}
-	req.ContentLength = size
+	if size > 0 {
+		req.ContentLength = size
+	}
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
@@ -144,7 +169,7 @@ func (c *Client) Upload(file *File, manifest string, toEncrypt bool) (string, er
 // the given hash (i.e. it gets bzz:/<hash>/<path>)
 func (c *Client) Download(hash, path string) (*File, error) {
 	uri := c.Gateway + "/bzz:/" + hash + "/" + path
-	res, err := http.DefaultClient.Get(uri)
+	res, err := c.get(uri)
 	if err != nil {
 		return nil, err
 	}
@@ -193,7 +218,7 @@ func (c *Client) DownloadDirectory(hash, path, destDir string) error {
 		return err
 	}
 	req.Header.Set("Accept", "application/x-tar")
-	res, err := http.DefaultClient.Do(req)
+	res, err := c.doGet(req)
 	if err != nil {
 		return err
 	}
@@ -220,7 +245,7 @@ func (c *Client) DownloadDirectory(hash, path, destDir string) error {
 		}
 		var mode os.FileMode = 0644
 		if hdr.Mode > 0 {
-			mode = os.FileMode(hdr.Mode)
+			mode = os.FileMode(hdr.Mode) & os.ModePerm
 		}
 		dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 		if err != nil {
@@ -236,6 +261,118 @@ func (c *Client) DownloadDirectory(hash, path, destDir string) error {
 	}
 }
 
+// DownloadDirectoryParallel is like DownloadDirectory but downloads up to
+// parallel files at the same time, each with its own request, which is faster
+// than a single stream when the files have to be retrieved from the network
+func (c *Client) DownloadDirectoryParallel(hash, path, destDir string, parallel int) error {
+	stat, err := os.Stat(destDir)
+	if err != nil {
+		return err
+	} else if !stat.IsDir() {
+		return fmt.Errorf("not a directory: %s", destDir)
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	entries, err := c.listRecursive(hash, path)
+	if err != nil {
+		return err
+	} else if len(entries) == 0 {
+		return fmt.Errorf("no files found under %q", path)
+	}
+	entryC := make(chan *api.ManifestEntry)
+	errC := make(chan error, parallel)
+	quitC := make(chan struct{})
+	for i := 0; i < parallel; i++ {
+		go func() {
+			for entry := range entryC {
+				dstPath := filepath.Join(destDir, filepath.Clean(strings.TrimPrefix(entry.Path, path)))
+				if err := c.downloadEntry(hash, entry, dstPath); err != nil {
+					errC <- err
+					return
+				}
+			}
+			errC <- nil
+		}()
+	}
+	go func() {
+		defer close(entryC)
+		for _, entry := range entries {
+			select {
+			case entryC <- entry:
+			case <-quitC:
+				return
+			}
+		}
+	}()
+	var firstErr error
+	for i := 0; i < parallel; i++ {
+		if err := <-errC; err != nil && firstErr == nil {
+			// stop the other downloads
+			firstErr = err
+			close(quitC)
+		}
+	}
+	return firstErr
+}
+
+// listRecursive returns the entries of all files under the path in the
+// manifest with the hash, except the default path
+func (c *Client) listRecursive(hash, path string) ([]*api.ManifestEntry, error) {
+	list, err := c.List(hash, path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*api.ManifestEntry
+	for _, entry := range list.Entries {
+		// the default path is listed as "/"
+		if entry.Path != "/" {
+			entries = append(entries, entry)
+		}
+	}
+	for _, prefix := range list.CommonPrefixes {
+		sub, err := c.listRecursive(hash, prefix)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, sub...)
+	}
+	return entries, nil
+}
+
+// downloadEntry downloads the file of the manifest entry to the destination
+// path, with the mode and modification time of the entry
+func (c *Client) downloadEntry(hash string, entry *api.ManifestEntry, dstPath string) error {
+	file, err := c.Download(hash, entry.Path)
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %s", entry.Path, err)
+	}
+	defer file.Close()
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	var mode os.FileMode = 0644
+	if entry.Mode > 0 {
+		mode = os.FileMode(entry.Mode) & os.ModePerm
+	}
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(dst, file)
+	dst.Close()
+	if err != nil {
+		return err
+	} else if entry.Size > 0 && n != entry.Size {
+		return fmt.Errorf("expected %s to be %d bytes but got %d", entry.Path, entry.Size, n)
+	}
+	if !entry.ModTime.IsZero() {
+		return os.Chtimes(dstPath, entry.ModTime, entry.ModTime)
+	}
+	return nil
+}
+
 // DownloadFile downloads a single file into the destination directory
 // if the manifest entry does not specify a file name - it will fallback
 // to the hash of the file as a filename
@@ -266,20 +403,6 @@ func (c *Client) DownloadFile(hash, path, dest string) error {
 		return fmt.Errorf("got too many matches for this path")
 	}
 
-	uri := c.Gateway + "/bzz:/" + hash + "/" + path
-	req, err := http.NewRequest("GET", uri, nil)
-	if err != nil {
-		return err
-	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status: expected 200 OK, got %d", res.StatusCode)
-	}
 	filename := ""
 	if hasDestinationFilename {
 		filename = dest
@@ -308,14 +431,119 @@ func (c *Client) DownloadFile(hash, path, dest string) error {
 		return err
 	}
 
-	dst, err := os.Create(filename)
+	return c.downloadResumable(c.Gateway+"/bzz:/"+hash+"/"+path, filePath)
+}
+
+// progressSuffix is appended to the name of the file being downloaded to get
+// the name of its progress file
+const progressSuffix = ".swarm-progress"
+
+// progressInterval is the number of bytes downloaded between the updates of
+// the progress file, a whole number of chunks
+const progressInterval = 256 * 4096
+
+// downloadProgress is saved in the progress file of a download which is not
+// complete, so that it can be resumed
+type downloadProgress struct {
+	ETag   string `json:"etag"`   // ETag of the content, the key of its root chunk
+	Offset int64  `json:"offset"` // bytes of the content written to the file
+}
+
+// downloadResumable downloads the content at the uri to the file, resuming
+// the download recorded in the progress file of the file if there is one
+//
+// The rest of the content is requested with a Range header conditional on the
+// ETag of the content with If-Range, so the download is only resumed if the
+// content has not changed, eg. an ENS name was not updated, otherwise it is
+// downloaded from the start.
+func (c *Client) downloadResumable(uri, filename string) error {
+	progressFile := filename + progressSuffix
+	var progress downloadProgress
+	if data, err := ioutil.ReadFile(progressFile); err == nil {
+		if err := json.Unmarshal(data, &progress); err != nil {
+			progress = downloadProgress{}
+		}
+	}
+
+	req, err := http.NewRequest("GET", uri, nil)
 	if err != nil {
 		return err
 	}
+	if progress.Offset > 0 && progress.ETag != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", progress.Offset))
+		req.Header.Set("If-Range", progress.ETag)
+	}
+	res, err := c.doGet(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var dst *os.File
+	switch res.StatusCode {
+	case http.StatusOK:
+		progress = downloadProgress{ETag: res.Header.Get("ETag")}
+		if dst, err = os.Create(filename); err != nil {
+			return err
+		}
+	case http.StatusPartialContent:
+		if dst, err = os.OpenFile(filename, os.O_WRONLY, 0666); err != nil {
+			return err
+		}
+		if err := dst.Truncate(progress.Offset); err != nil {
+			dst.Close()
+			return err
+		}
+		if _, err := dst.Seek(progress.Offset, io.SeekStart); err != nil {
+			dst.Close()
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the whole of the content was written before the download was
+		// interrupted
+		if stat, err := os.Stat(filename); err == nil && stat.Size() == progress.Offset {
+			return os.Remove(progressFile)
+		}
+		os.Remove(progressFile)
+		return fmt.Errorf("could not resume download of %s, try again", filename)
+	default:
+		return fmt.Errorf("unexpected HTTP status: expected 200 OK, got %d", res.StatusCode)
+	}
 	defer dst.Close()
 
-	_, err = io.Copy(dst, res.Body)
-	return err
+	// save the progress of the bytes synced to the file, only content with an
+	// ETag can be resumed
+	saveProgress := func() error {
+		if progress.ETag == "" {
+			return nil
+		}
+		if err := dst.Sync(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(&progress)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(progressFile, data, 0644)
+	}
+	for {
+		n, err := io.CopyN(dst, res.Body, progressInterval)
+		progress.Offset += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			saveProgress()
+			return err
+		}
+		if err := saveProgress(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(progressFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 // UploadManifest uploads the given manifest to swarm
@@ -341,6 +569,77 @@ func (c *Client) DownloadManifest(hash string) (*api.Manifest, bool, error) {
 	return &manifest, isEncrypted, nil
 }
 
+// ResolveAccess downloads the access manifest at hash and returns the hex
+// reference wrapped by it decrypted with the credentials, so that pk access
+// can be resolved without giving the private key to the gateway
+func (c *Client) ResolveAccess(hash string, creds *api.Credentials) (string, error) {
+	manifest, _, err := c.DownloadManifest(hash)
+	if err != nil {
+		return "", err
+	}
+	ref, err := api.ResolveAccessManifest(manifest, creds)
+	if err != nil {
+		return "", err
+	}
+	return ref.Hex(), nil
+}
+
+// SetDocuments sets the index and error documents of the manifest served by
+// the gateway for its directories and for the paths not found in it, and
+// returns the hash of the new manifest, empty documents are removed
+func (c *Client) SetDocuments(hash, index, errorDocument string) (string, error) {
+	manifest, isEncrypted, err := c.DownloadManifest(hash)
+	if err != nil {
+		return "", err
+	}
+	manifest.IndexDocument = index
+	manifest.ErrorDocument = errorDocument
+	return c.UploadManifest(manifest, isEncrypted)
+}
+
+// HasChunks tells for each of the hex chunk keys if the chunk is stored by
+// the swarm node, see ChunkKeys for the keys of the chunks of a file
+func (c *Client) HasChunks(keys []string) ([]bool, error) {
+	body, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.Post(c.Gateway+"/bzz-has:/", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", res.Status)
+	}
+	var has []bool
+	if err := json.NewDecoder(res.Body).Decode(&has); err != nil {
+		return nil, err
+	}
+	if len(has) != len(keys) {
+		return nil, fmt.Errorf("expected %d results, got %d", len(keys), len(has))
+	}
+	return has, nil
+}
+
+// ChunkKeys computes the hex keys of the chunks the content is split into
+// when it is uploaded without encryption, without uploading it, and returns
+// them with the key of the root chunk which is the key of the content
+//
+// Content whose chunks are all stored already by a node, as told by
+// HasChunks, need not be uploaded again.
+func ChunkKeys(r io.Reader, size int64) (string, []string, error) {
+	root, keys, err := storage.ChunkKeys(r, size)
+	if err != nil {
+		return "", nil, err
+	}
+	hexKeys := make([]string, len(keys))
+	for i, key := range keys {
+		hexKeys[i] = key.Hex()
+	}
+	return root.Hex(), hexKeys, nil
+}
+
 // List list files in a swarm manifest which have the given prefix, grouping
 // common prefixes using "/" as a delimiter.
 //
@@ -359,7 +658,7 @@ func (c *Client) DownloadManifest(hash string) (*api.Manifest, bool, error) {
 //
 // where entries ending with "/" are common prefixes.
 func (c *Client) List(hash, prefix string) (*api.ManifestList, error) {
-	res, err := http.DefaultClient.Get(c.Gateway + "/bzz-list:/" + hash + "/" + prefix)
+	res, err := c.get(c.Gateway + "/bzz-list:/" + hash + "/" + prefix)
 	if err != nil {
 		return nil, err
 	}
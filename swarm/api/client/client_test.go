@@ -18,15 +18,23 @@ package client
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/swarm/api"
 	swarmhttp "github.com/ethereum/go-ethereum/swarm/api/http"
+	"github.com/ethereum/go-ethereum/swarm/storage"
 	"github.com/ethereum/go-ethereum/swarm/testutil"
 )
 
@@ -73,6 +81,27 @@ func testClientUploadDownloadRaw(toEncrypt bool, t *testing.T) {
 	}
 }
 
+// TestClientUploadRawStream tests streaming raw data of unknown size
+func TestClientUploadRawStream(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	client := NewClient(srv.URL)
+
+	data := []byte("streamed foo123")
+	expHash, err := client.UploadRaw(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := client.UploadRaw(ioutil.NopCloser(bytes.NewReader(data)), -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != expHash {
+		t.Fatalf("expected hash %s, got %s", expHash, hash)
+	}
+}
+
 // TestClientUploadDownloadFiles test uploading and downloading files to swarm
 // manifests
 func TestClientUploadDownloadFiles(t *testing.T) {
@@ -238,6 +267,222 @@ func TestClientUploadDownloadDirectory(t *testing.T) {
 	}
 }
 
+// TestClientSetDocuments tests that the index and error documents set on a
+// manifest are declared by the new manifest and served by the gateway
+func TestClientSetDocuments(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	dir := newTestDirectory(t)
+	defer os.RemoveAll(dir)
+
+	client := NewClient(srv.URL)
+	hash, err := client.UploadDirectory(dir, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err = client.SetDocuments(hash, "file3.txt", "file1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, _, err := client.DownloadManifest(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.IndexDocument != "file3.txt" || manifest.ErrorDocument != "file1.txt" {
+		t.Fatalf("unexpected documents %q and %q", manifest.IndexDocument, manifest.ErrorDocument)
+	}
+
+	file, err := client.Download(hash, "dir1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "dir1/file3.txt" {
+		t.Fatalf("expected the index document of dir1, got %q", data)
+	}
+}
+
+// TestClientResolveAccess tests that a pk access manifest is resolved by the
+// client with the key of the grantee, and the content downloaded with the
+// resolved reference
+func TestClientResolveAccess(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	publisher, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	grantee, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(srv.URL)
+	data := []byte("secret")
+	ref, err := client.UploadRaw(bytes.NewReader(data), int64(len(data)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := api.NewPKAccess(storage.Key(common.Hex2Bytes(ref)), publisher, &grantee.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := client.UploadManifest(m, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.ResolveAccess(hash, &api.Credentials{PrivateKey: publisher}); err != api.ErrAccessDenied {
+		t.Fatalf("expected %v with the publisher key, got %v", api.ErrAccessDenied, err)
+	}
+	resolved, err := client.ResolveAccess(hash, &api.Credentials{PrivateKey: grantee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != ref {
+		t.Fatalf("expected reference %s, got %s", ref, resolved)
+	}
+	res, _, err := client.DownloadRaw(resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	got, err := ioutil.ReadAll(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("expected %q, got %q", data, got)
+	}
+
+	if _, err := client.ResolveAccess(ref, &api.Credentials{PrivateKey: grantee}); err == nil {
+		t.Fatal("expected error resolving content which is not an access manifest")
+	}
+}
+
+// TestClientDownloadDirectoryParallel tests downloading a directory with
+// several files downloaded at the same time
+func TestClientDownloadDirectoryParallel(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	dir := newTestDirectory(t)
+	defer os.RemoveAll(dir)
+	modTime := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, file := range testDirFiles {
+		if err := os.Chtimes(filepath.Join(dir, file), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := NewClient(srv.URL)
+	hash, err := client.UploadDirectory(dir, filepath.Join(dir, testDirFiles[0]), "", false)
+	if err != nil {
+		t.Fatalf("error uploading directory: %s", err)
+	}
+
+	for _, c := range []struct {
+		path     string
+		parallel int
+		files    []string
+	}{
+		{"", 3, testDirFiles},
+		{"", 1, testDirFiles},
+		{"dir2/", 10, []string{"file5.txt", "dir3/file6.txt", "dir4/file7.txt", "dir4/file8.txt"}},
+	} {
+		tmp, err := ioutil.TempDir("", "swarm-client-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		if err := client.DownloadDirectoryParallel(hash, c.path, tmp, c.parallel); err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		err = filepath.Walk(tmp, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			name, err := filepath.Rel(tmp, path)
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if expected := filepath.ToSlash(filepath.Join(c.path, name)); string(data) != expected {
+				t.Fatalf("expected data of %s to be %q, got %q", name, expected, data)
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Fatalf("expected modification time of %s to be %v, got %v", name, modTime, info.ModTime())
+			}
+			files = append(files, filepath.ToSlash(name))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := append([]string{}, c.files...)
+		sort.Strings(expected)
+		sort.Strings(files)
+		if !reflect.DeepEqual(files, expected) {
+			t.Fatalf("%q: expected files %v, got %v", c.path, expected, files)
+		}
+	}
+
+	if err := client.DownloadDirectoryParallel(hash, "missing/", os.TempDir(), 3); err == nil {
+		t.Fatal("expected error downloading a missing directory")
+	}
+}
+
+// TestClientDownloadFileMode tests that only the permission bits of the mode
+// of the manifest entries are set on the files downloaded
+func TestClientDownloadFileMode(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	client := NewClient(srv.URL)
+	data, err := client.UploadRaw(strings.NewReader("data"), 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := client.UploadManifest(&api.Manifest{
+		Entries: []api.ManifestEntry{{
+			Hash:        data,
+			Path:        "file.txt",
+			ContentType: "text/plain",
+			Mode:        int64(os.ModeSetuid | os.ModeSticky | 0640),
+			Size:        4,
+		}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "swarm-client-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := client.DownloadDirectoryParallel(hash, "", tmp, 1); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(tmp, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode(); mode != 0640 {
+		t.Fatalf("expected mode %v, got %v", os.FileMode(0640), mode)
+	}
+}
+
 // TestClientFileList tests listing files in a swarm manifest
 func TestClientFileList(t *testing.T) {
 	testClientFileList(false, t)
@@ -354,3 +599,131 @@ func TestClientMultipartUpload(t *testing.T) {
 		checkDownloadFile(file)
 	}
 }
+
+// TestClientHasChunks tests that the chunks of content computed by ChunkKeys
+// are only found once the content is uploaded
+func TestClientHasChunks(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	client := NewClient(srv.URL)
+
+	data := make([]byte, 10*4096)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	root, keys, err := ChunkKeys(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10 data chunks and the root chunk
+	if len(keys) != 11 {
+		t.Fatalf("expected 11 chunk keys, got %d", len(keys))
+	}
+
+	has, err := client.HasChunks(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range has {
+		if ok {
+			t.Fatalf("expected chunk %s not to be stored before the upload", keys[i])
+		}
+	}
+
+	hash, err := client.UploadRaw(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != root {
+		t.Fatalf("expected the uploaded content to have key %s, got %s", root, hash)
+	}
+	has, err = client.HasChunks(append(keys, strings.Repeat("00", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range has[:len(keys)] {
+		if !ok {
+			t.Fatalf("expected chunk %s to be stored after the upload", keys[i])
+		}
+	}
+	if has[len(keys)] {
+		t.Fatal("expected unknown chunk not to be stored")
+	}
+}
+
+// TestClientDownloadFileResume tests that an interrupted download recorded in
+// a progress file is resumed from its offset, unless the content has changed
+func TestClientDownloadFileResume(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	client := NewClient(srv.URL)
+
+	data := make([]byte, 3*progressInterval+100)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := client.Upload(&File{
+		ReadCloser: ioutil.NopCloser(bytes.NewReader(data)),
+		ManifestEntry: api.ManifestEntry{
+			Path:        "file",
+			ContentType: "application/octet-stream",
+			Size:        int64(len(data)),
+		},
+	}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Head(srv.URL + "/bzz:/" + hash + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	etag := res.Header.Get("ETag")
+
+	dir, err := ioutil.TempDir("", "swarm-client-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "file")
+
+	// the bytes written before the interruption are marked so that they
+	// tell if the download was resumed
+	offset := int64(progressInterval)
+	interrupt := func(etag string) {
+		if err := ioutil.WriteFile(dest, bytes.Repeat([]byte{1}, int(offset)+10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		progress, _ := json.Marshal(&downloadProgress{ETag: etag, Offset: offset})
+		if err := ioutil.WriteFile(dest+progressSuffix, progress, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(expected []byte) {
+		got, err := ioutil.ReadFile(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Fatal("unexpected content of the downloaded file")
+		}
+		if _, err := os.Stat(dest + progressSuffix); !os.IsNotExist(err) {
+			t.Fatalf("expected the progress file to be removed, got %v", err)
+		}
+	}
+
+	interrupt(etag)
+	if err := client.DownloadFile(hash, "file", dest); err != nil {
+		t.Fatal(err)
+	}
+	check(append(bytes.Repeat([]byte{1}, int(offset)), data[offset:]...))
+
+	// other content is downloaded from the start
+	interrupt(`"abcd"`)
+	if err := client.DownloadFile(hash, "file", dest); err != nil {
+		t.Fatal(err)
+	}
+	check(data)
+}
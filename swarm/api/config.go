@@ -64,10 +64,26 @@ type Config struct {
 	SyncEnabled       bool
 	DeliverySkipCheck bool
 	SyncUpdateDelay   time.Duration
+	SyncReceipts      bool // the synced chunk batches are receipted, signed with the node's key
 	SwapApi           string
 	Cors              string
+	FrameOptions      string  // X-Frame-Options header of the HTTP API responses, not sent if empty
+	CSP               string  // Content-Security-Policy header of the HTTP API responses, not sent if empty
+	Immutable         bool    // the HTTP API only serves content hashes, names and mutable resources are not resolved
+	RateLimit         float64 // requests per second the HTTP API accepts from each client IP, no limit if 0
+	RateBurst         int     // requests the HTTP API accepts at once from each client IP, the rate limit rounded up if 0
+	MaxUploadSize     int64   // bytes the HTTP API accepts in the body of an upload, no limit if 0
+	MaxRequests       int     // requests the HTTP API serves at the same time, no limit if 0
 	BzzAccount        string
-	BootNodes         string
+	BootNodes         string            // comma separated enode URLs of the overlay bootnodes
+	StaticNodes       string            // comma separated enode URLs of the overlay peers always kept connected
+	TrustedNodes      string            // comma separated enode URLs of the overlay peers exempt from the connection limits
+	UploadQuota       uint64            // bytes the HTTP API accepts without an API key, no limit if 0
+	UploadQuotas      map[string]uint64 // bytes the HTTP API accepts with each API key, no limit if 0
+	WriteTokens       []string          // API keys the HTTP API accepts uploads with, anyone may upload unless tokens or users are set
+	WriteUsers        map[string]string // users and their passwords the HTTP API accepts uploads from with basic auth
+	RetrieveBackoff   *storage.RetryBackoff
+	DebugAddr         string // address of the debug listener serving pprof, runtime stats and the node state, disabled if empty
 	privateKey        *ecdsa.PrivateKey
 }
 
@@ -91,6 +107,7 @@ func NewConfig() (self *Config) {
 		SyncEnabled:       true,
 		DeliverySkipCheck: false,
 		SyncUpdateDelay:   15 * time.Second,
+		RetrieveBackoff:   storage.NewRetryBackoff(),
 		SwapApi:           "",
 		BootNodes:         "",
 	}
@@ -125,6 +142,10 @@ func (self *Config) Init(prvKey *ecdsa.PrivateKey) {
 	self.privateKey = prvKey
 	self.LocalStoreParams.Init(self.Path)
 	self.LocalStoreParams.BaseKey = common.FromHex(keyhex)
+	if self.LocalStoreParams.EncryptData {
+		// derived from the private key, so that the key itself is never stored
+		self.LocalStoreParams.EncryptionKey = crypto.Keccak256([]byte("swarm chunk encryption"), crypto.FromECDSA(prvKey))
+	}
 
 	self.Pss = self.Pss.WithPrivateKey(self.privateKey)
 }
@@ -0,0 +1,188 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/network/stream"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// eventBufferSize is the capacity of the channels the events are received on,
+// so that slow subscribers do not block the node
+const eventBufferSize = 64
+
+// implements rpc subscriptions to the changes of the state of the node,
+// so that they can be followed over websocket or ipc without polling
+type Events struct {
+	lstore   *storage.LocalStore
+	kad      *network.Kademlia
+	streamer *stream.Registry
+	resource *storage.ResourceHandler
+}
+
+func NewEvents(lstore *storage.LocalStore, kad *network.Kademlia, streamer *stream.Registry, resource *storage.ResourceHandler) *Events {
+	return &Events{
+		lstore:   lstore,
+		kad:      kad,
+		streamer: streamer,
+		resource: resource,
+	}
+}
+
+// ChunkArrival notifies the subscriber with the key once the chunk is stored
+// in the local store, immediately if it is already stored
+func (self *Events) ChunkArrival(ctx context.Context, key storage.Key) (*rpc.Subscription, error) {
+	storedC := make(chan storage.Key, eventBufferSize)
+	sub := self.lstore.SubscribeStored(storedC)
+	if _, err := self.lstore.Get(key); err == nil {
+		storedC <- key
+	}
+	return subscribe(ctx, sub, func(notify func(interface{})) {
+		for {
+			select {
+			case stored := <-storedC:
+				if stored.String() == key.String() {
+					notify(stored)
+					sub.Unsubscribe()
+					return
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	})
+}
+
+// Peers notifies the subscriber of every peer added to the kademlia table,
+// connecting to or disconnecting from the node or becoming a nearest neighbour
+func (self *Events) Peers(ctx context.Context) (*rpc.Subscription, error) {
+	peerC := make(chan *network.PeerEvent, eventBufferSize)
+	sub := self.kad.SubscribePeers(peerC)
+	return subscribe(ctx, sub, func(notify func(interface{})) {
+		for {
+			select {
+			case e := <-peerC:
+				notify(e)
+			case <-sub.Err():
+				return
+			}
+		}
+	})
+}
+
+// Depth notifies the subscriber of the new neighbourhood depth of the node on
+// each change
+func (self *Events) Depth(ctx context.Context) (*rpc.Subscription, error) {
+	depthC := make(chan int, eventBufferSize)
+	sub := self.kad.SubscribeDepth(depthC)
+	return subscribe(ctx, sub, func(notify func(interface{})) {
+		for {
+			select {
+			case depth := <-depthC:
+				notify(depth)
+			case <-sub.Err():
+				return
+			}
+		}
+	})
+}
+
+// SyncProgress notifies the subscriber of every batch synced from the peers,
+// only the batches of the bin are sent if bin is given
+func (self *Events) SyncProgress(ctx context.Context, bin *uint8) (*rpc.Subscription, error) {
+	progressC := make(chan *stream.SyncProgress, eventBufferSize)
+	sub := self.streamer.SubscribeSyncProgress(progressC)
+	return subscribe(ctx, sub, func(notify func(interface{})) {
+		for {
+			select {
+			case p := <-progressC:
+				if bin == nil || p.Bin == *bin {
+					notify(p)
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	})
+}
+
+// ResourceUpdates notifies the subscriber of the updates of the mutable
+// resources published through the node, only the updates of the resource
+// with the name are sent if name is not empty
+func (self *Events) ResourceUpdates(ctx context.Context, name string) (*rpc.Subscription, error) {
+	updateC := make(chan *storage.ResourceUpdate, eventBufferSize)
+	sub := self.resource.SubscribeUpdates(updateC)
+	return subscribe(ctx, sub, func(notify func(interface{})) {
+		for {
+			select {
+			case u := <-updateC:
+				if name == "" || u.Name == name {
+					notify(u)
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	})
+}
+
+// subscribe creates an rpc subscription and runs forward with a function
+// notifying the subscriber; the event subscription is closed when the rpc
+// subscription ends
+// The notify function never blocks, the notifications are dropped while
+// eventBufferSize of them wait to be sent to a slow subscriber, so that the
+// senders of the events are not blocked by the forwarding
+func subscribe(ctx context.Context, sub event.Subscription, forward func(notify func(interface{}))) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		sub.Unsubscribe()
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+	queue := make(chan interface{}, eventBufferSize)
+	notify := func(data interface{}) {
+		select {
+		case queue <- data:
+		default:
+			log.Warn(fmt.Sprintf("rpc subscription %v is too slow, notification dropped", rpcSub.ID))
+		}
+	}
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case data := <-queue:
+				if err := notifier.Notify(rpcSub.ID, data); err != nil {
+					log.Warn(fmt.Sprintf("notification on rpc subscription %v failed: %v", rpcSub.ID, err))
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	go forward(notify)
+	return rpcSub, nil
+}
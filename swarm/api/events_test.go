@@ -0,0 +1,98 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// TestEventsChunkArrival checks that the subscribers are notified of the
+// chunks already stored and of the chunks stored after subscribing
+func TestEventsChunkArrival(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "bzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	params := storage.NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	lstore, err := storage.NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstore.DbStore.Close()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("bzz", NewEvents(lstore, nil, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	stored := storage.GenerateRandomChunk(storage.DefaultChunkSize)
+	lstore.Put(stored)
+	if err := stored.WaitToStore(); err != nil {
+		t.Fatal(err)
+	}
+	pending := storage.GenerateRandomChunk(storage.DefaultChunkSize)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	storedC := make(chan storage.Key, 1)
+	storedSub, err := client.Subscribe(ctx, "bzz", storedC, "chunkArrival", stored.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storedSub.Unsubscribe()
+	pendingC := make(chan storage.Key, 1)
+	pendingSub, err := client.Subscribe(ctx, "bzz", pendingC, "chunkArrival", pending.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pendingSub.Unsubscribe()
+
+	select {
+	case key := <-storedC:
+		if key.String() != stored.Key.String() {
+			t.Fatalf("expected key %v, got %v", stored.Key, key)
+		}
+	case <-ctx.Done():
+		t.Fatal("no notification of the stored chunk")
+	}
+
+	select {
+	case key := <-pendingC:
+		t.Fatalf("unexpected notification of %v before the chunk is stored", key)
+	case <-time.After(100 * time.Millisecond):
+	}
+	lstore.Put(pending)
+	select {
+	case key := <-pendingC:
+		if key.String() != pending.Key.String() {
+			t.Fatalf("expected key %v, got %v", pending.Key, key)
+		}
+	case <-ctx.Done():
+		t.Fatal("no notification of the pending chunk")
+	}
+}
@@ -20,11 +20,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
@@ -112,24 +112,20 @@ func (self *FileSystem) Upload(lpath, index string, toEncrypt bool) (string, err
 			f, err := os.Open(entry.Path)
 			if err == nil {
 				stat, _ := f.Stat()
+				contentType, reader := DetectContentType(entry.Path, f)
 				var hash storage.Key
 				var wait func()
-				hash, wait, err = self.api.dpa.Store(f, stat.Size(), toEncrypt)
+				hash, wait, err = self.api.dpa.Store(reader, stat.Size(), toEncrypt)
 				if hash != nil {
 					list[i].Hash = hash.Hex()
 				}
 				wait()
 				awg.Done()
 				if err == nil {
-					first512 := make([]byte, 512)
-					fread, _ := f.ReadAt(first512, 0)
-					if fread > 0 {
-						mimeType := http.DetectContentType(first512[:fread])
-						if filepath.Ext(entry.Path) == ".css" {
-							mimeType = "text/css"
-						}
-						list[i].ContentType = mimeType
-					}
+					list[i].ContentType = contentType
+					list[i].Mode = int64(stat.Mode())
+					list[i].Size = stat.Size()
+					list[i].ModTime = stat.ModTime()
 				}
 				f.Close()
 			}
@@ -207,8 +203,10 @@ func (self *FileSystem) Download(bzzpath, localpath string) error {
 	}
 
 	type downloadListEntry struct {
-		key  storage.Key
-		path string
+		key     storage.Key
+		path    string
+		mode    os.FileMode
+		modTime time.Time
 	}
 
 	var list []*downloadListEntry
@@ -226,7 +224,7 @@ func (self *FileSystem) Download(bzzpath, localpath string) error {
 			prevPath = dir
 		}
 		if (mde == nil) && (path != dir+"/") {
-			list = append(list, &downloadListEntry{key: key, path: path})
+			list = append(list, &downloadListEntry{key: key, path: path, mode: os.FileMode(entry.Mode), modTime: entry.ModTime})
 		}
 	})
 	if err != nil {
@@ -246,6 +244,14 @@ func (self *FileSystem) Download(bzzpath, localpath string) error {
 		go func(i int, entry *downloadListEntry) {
 			defer wg.Done()
 			err := retrieveToFile(quitC, self.api.dpa, entry.key, entry.path)
+			// restore the metadata of the file so that uploading it again
+			// results in the same manifest
+			if err == nil && entry.mode != 0 {
+				err = os.Chmod(entry.path, entry.mode&os.ModePerm)
+			}
+			if err == nil && !entry.modTime.IsZero() {
+				err = os.Chtimes(entry.path, entry.modTime, entry.modTime)
+			}
 			if err != nil {
 				select {
 				case errC <- err:
@@ -85,6 +85,49 @@ func TestApiDirUpload0(t *testing.T) {
 	})
 }
 
+// TestApiDirUploadMetadata tests that the entries of uploaded files have the
+// size, mode and modification time of the files
+func TestApiDirUploadMetadata(t *testing.T) {
+	testFileSystem(t, func(fs *FileSystem, toEncrypt bool) {
+		dir := filepath.Join("testdata", "test0")
+		bzzhash, err := fs.Upload(dir, "", toEncrypt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		walker, err := fs.api.NewManifestWalker(storage.Key(common.Hex2Bytes(bzzhash)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		err = walker.Walk(func(entry *ManifestEntry) error {
+			if entry.ContentType == ManifestType {
+				return nil
+			}
+			count++
+			stat, err := os.Stat(filepath.Join(dir, entry.Path))
+			if err != nil {
+				return err
+			}
+			if entry.Size != stat.Size() {
+				t.Errorf("%s: expected size %d, got %d", entry.Path, stat.Size(), entry.Size)
+			}
+			if os.FileMode(entry.Mode) != stat.Mode() {
+				t.Errorf("%s: expected mode %v, got %v", entry.Path, stat.Mode(), os.FileMode(entry.Mode))
+			}
+			if !entry.ModTime.Equal(stat.ModTime()) {
+				t.Errorf("%s: expected modification time %v, got %v", entry.Path, stat.ModTime(), entry.ModTime)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 {
+			t.Fatalf("expected 3 files, got %d", count)
+		}
+	})
+}
+
 func TestApiDirUploadModify(t *testing.T) {
 	testFileSystem(t, func(fs *FileSystem, toEncrypt bool) {
 		api := fs.api
@@ -0,0 +1,74 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter tests that the rate limiter allows a burst of requests from
+// each IP and refills the bucket at the rate
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !l.allow("1.2.3.4", now) {
+			t.Fatalf("expected request %d of the burst to be allowed", i)
+		}
+	}
+	if l.allow("1.2.3.4", now) {
+		t.Fatal("expected request over the burst to be limited")
+	}
+	if !l.allow("5.6.7.8", now) {
+		t.Fatal("expected requests of another IP not to be limited")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !l.allow("1.2.3.4", now) {
+		t.Fatal("expected request to be allowed after the bucket is refilled")
+	}
+	if l.allow("1.2.3.4", now) {
+		t.Fatal("expected the refilled bucket to be empty")
+	}
+
+	// the burst defaults to the rate rounded up
+	if l := newRateLimiter(0.5, 0); l.burst != 1 {
+		t.Fatalf("expected default burst 1, got %v", l.burst)
+	}
+}
+
+// TestRateLimiterPrune tests that the rate limiter drops the full buckets when
+// it keeps too many of them
+func TestRateLimiterPrune(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	now := time.Now()
+	for i := 0; i < maxRateBuckets; i++ {
+		l.allow(fmt.Sprintf("ip%d", i), now)
+	}
+	// the buckets are not full yet, none of them can be dropped
+	l.allow("ip", now.Add(500*time.Millisecond))
+	if len(l.buckets) != maxRateBuckets+1 {
+		t.Fatalf("expected %d buckets, got %d", maxRateBuckets+1, len(l.buckets))
+	}
+	l.allow("new", now.Add(time.Second))
+	if len(l.buckets) != 2 {
+		t.Fatalf("expected the full buckets to be pruned, %d are kept", len(l.buckets))
+	}
+}
@@ -76,6 +76,18 @@ var (
 type ServerConfig struct {
 	Addr       string
 	CorsString string
+	Quotas     *api.UploadQuotas // accounting of the uploads, uploads are not limited if nil
+	Tags       *storage.Tags     // progress of the uploads, uploads are not tracked if nil
+	Auth       *api.WriteAuth    // authorization of the requests changing content, anyone may change it if nil
+	Immutable  bool              // only content hashes are served, names and mutable resources are not resolved
+
+	FrameOptions string // X-Frame-Options header of the responses, not sent if empty
+	CSP          string // Content-Security-Policy header of the responses, not sent if empty
+
+	RateLimit     float64 // requests per second accepted from each client IP, not limited if 0
+	RateBurst     int     // requests accepted at once from each client IP, the rate limit rounded up if 0
+	MaxUploadSize int64   // bytes accepted in the body of an upload, not limited if 0
+	MaxRequests   int     // requests served at the same time, not limited if 0
 }
 
 // browser API for registering bzz url scheme handlers:
@@ -95,38 +107,80 @@ func StartHttpServer(api *api.Api, config *ServerConfig) {
 		MaxAge:         600,
 		AllowedHeaders: []string{"*"},
 	})
-	hdlr := c.Handler(NewServer(api))
+	server := NewServer(api)
+	server.quotas = config.Quotas
+	server.tags = config.Tags
+	server.auth = config.Auth
+	server.immutable = config.Immutable
+	server.frameOptions = config.FrameOptions
+	server.csp = config.CSP
+	if config.RateLimit > 0 {
+		server.limiter = newRateLimiter(config.RateLimit, config.RateBurst)
+	}
+	server.maxUploadSize = config.MaxUploadSize
+	if config.MaxRequests > 0 {
+		server.requests = make(chan struct{}, config.MaxRequests)
+	}
+	hdlr := c.Handler(server)
 
 	go http.ListenAndServe(config.Addr, hdlr)
 }
 
 func NewServer(api *api.Api) *Server {
-	return &Server{api}
+	return &Server{api: api}
 }
 
 type Server struct {
-	api *api.Api
+	api       *api.Api
+	quotas    *api.UploadQuotas
+	tags      *storage.Tags
+	auth      *api.WriteAuth
+	immutable bool
+
+	frameOptions string
+	csp          string
+
+	limiter       *rateLimiter  // limits the requests of each client IP, not limited if nil
+	maxUploadSize int64         // bytes accepted in the body of an upload, not limited if 0
+	requests      chan struct{} // slots of the requests served at the same time, not limited if nil
 }
 
 // Request wraps http.Request and also includes the parsed bzz URI
 type Request struct {
 	http.Request
 
-	uri  *api.URI
-	ruid string // request unique id
+	uri     *api.URI
+	ruid    string // request unique id
+	account string // account the uploads of the request are accounted to
+
+	// tag counting the chunks of the upload, nil if it is not tracked
+	tag *storage.Tag
+}
+
+// isEncryptRequest tells if the content of an upload request is to be
+// encrypted, requested either by the encrypt address or the encrypt query
+// parameter, eg. bzz-raw:/?encrypt=true
+func isEncryptRequest(r *Request) bool {
+	if r.uri.Addr == "encrypt" {
+		return true
+	}
+	encrypt, _ := strconv.ParseBool(r.URL.Query().Get("encrypt"))
+	return encrypt
 }
 
-// HandlePostRaw handles a POST request to a raw bzz-raw:/ URI, stores the request
-// body in swarm and returns the resulting storage key as a text/plain response
+// HandlePostRaw handles a POST or PUT request to a raw bzz-raw:/ URI, stores the
+// request body in swarm and returns the resulting storage key as a text/plain
+// response
+//
+// Requests without a Content-Length header, eg. with a chunked body, are
+// streamed to swarm until the body is exhausted, so content of unknown size
+// can be uploaded with eg. curl -T - http://localhost:8500/bzz-raw:/
 func (s *Server) HandlePostRaw(w http.ResponseWriter, r *Request) {
 	log.Debug("handle.post.raw", "ruid", r.ruid)
 
 	postRawCount.Inc(1)
 
-	toEncrypt := false
-	if r.uri.Addr == "encrypt" {
-		toEncrypt = true
-	}
+	toEncrypt := isEncryptRequest(r)
 
 	if r.uri.Path != "" {
 		postRawFail.Inc(1)
@@ -140,12 +194,13 @@ func (s *Server) HandlePostRaw(w http.ResponseWriter, r *Request) {
 		return
 	}
 
+	var key storage.Key
+	var err error
 	if r.Header.Get("Content-Length") == "" {
-		postRawFail.Inc(1)
-		Respond(w, r, "missing Content-Length header in request", http.StatusBadRequest)
-		return
+		key, _, err = s.api.WithTag(r.tag).StoreStream(r.Body, toEncrypt)
+	} else {
+		key, _, err = s.api.WithTag(r.tag).Store(r.Body, r.ContentLength, toEncrypt)
 	}
-	key, _, err := s.api.Store(r.Body, r.ContentLength, toEncrypt)
 	if err != nil {
 		postRawFail.Inc(1)
 		Respond(w, r, err.Error(), http.StatusInternalServerError)
@@ -159,11 +214,60 @@ func (s *Server) HandlePostRaw(w http.ResponseWriter, r *Request) {
 	fmt.Fprint(w, key)
 }
 
+// maxHasChunks is the number of chunks a bzz-has:/ request may check
+const maxHasChunks = 100000
+
+// maxHasBodySize is the length of a bzz-has:/ request body checking
+// maxHasChunks encrypted chunk keys, the rest of longer bodies is not read
+const maxHasBodySize = maxHasChunks * (2*64 + 8)
+
+// HandlePostHas handles a POST request to bzz-has:/ with a JSON array of hex
+// chunk keys in the body and responds with a JSON array telling for each key if
+// the node stores the chunk, so that clients can skip uploading content which
+// is stored already, eg. backup tools
+//
+//     curl -d '["<key>", ...]' http://localhost:8500/bzz-has:/
+//
+// The keys of the chunks of a file are computed without uploading it with
+// client.ChunkKeys.
+func (s *Server) HandlePostHas(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.post.has", "ruid", r.ruid)
+	var hexKeys []string
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxHasBodySize)).Decode(&hexKeys); err != nil {
+		Respond(w, r, fmt.Sprintf("invalid chunk keys: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(hexKeys) > maxHasChunks {
+		Respond(w, r, fmt.Sprintf("too many chunk keys: %d, at most %d may be checked", len(hexKeys), maxHasChunks), http.StatusRequestEntityTooLarge)
+		return
+	}
+	keys := make([]storage.Key, len(hexKeys))
+	for i, hexKey := range hexKeys {
+		key, err := hexutil.Decode("0x" + strings.TrimPrefix(hexKey, "0x"))
+		if err != nil || len(key) == 0 {
+			Respond(w, r, fmt.Sprintf("invalid chunk key %q", hexKey), http.StatusBadRequest)
+			return
+		}
+		keys[i] = key
+	}
+	has, err := s.api.HasChunks(keys)
+	if err != nil {
+		Respond(w, r, fmt.Sprintf("cannot check chunks: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(has)
+}
+
 // HandlePostFiles handles a POST request to
 // bzz:/<hash>/<path> which contains either a single file or multiple files
 // (either a tar archive or multipart form), adds those files either to an
 // existing manifest or to a new manifest under <path> and returns the
 // resulting manifest hash as a text/plain response
+//
+// The content type of a file is the one of the request, of its multipart part
+// or the user.swarm.content-type extended attribute of its tar entry, and is
+// detected from its name or content if not given.
 func (s *Server) HandlePostFiles(w http.ResponseWriter, r *Request) {
 	log.Debug("handle.post.files", "ruid", r.ruid)
 
@@ -175,10 +279,7 @@ func (s *Server) HandlePostFiles(w http.ResponseWriter, r *Request) {
 		return
 	}
 
-	toEncrypt := false
-	if r.uri.Addr == "encrypt" {
-		toEncrypt = true
-	}
+	toEncrypt := isEncryptRequest(r)
 
 	var key storage.Key
 	if r.uri.Addr != "" && r.uri.Addr != "encrypt" {
@@ -190,7 +291,7 @@ func (s *Server) HandlePostFiles(w http.ResponseWriter, r *Request) {
 		}
 		log.Debug("resolved key", "ruid", r.ruid, "key", key)
 	} else {
-		key, err = s.api.NewManifest(toEncrypt)
+		key, err = s.api.WithTag(r.tag).NewManifest(toEncrypt)
 		if err != nil {
 			postFilesFail.Inc(1)
 			Respond(w, r, err.Error(), http.StatusInternalServerError)
@@ -199,7 +300,7 @@ func (s *Server) HandlePostFiles(w http.ResponseWriter, r *Request) {
 		log.Debug("new manifest", "ruid", r.ruid, "key", key)
 	}
 
-	newKey, err := s.updateManifest(key, func(mw *api.ManifestWriter) error {
+	newKey, err := s.updateManifest(key, r.tag, func(mw *api.ManifestWriter) error {
 		switch contentType {
 
 		case "application/x-tar":
@@ -243,15 +344,20 @@ func (s *Server) handleTarUpload(req *Request, mw *api.ManifestWriter) error {
 
 		// add the entry under the path from the request
 		path := path.Join(req.uri.Path, hdr.Name)
+		var reader io.Reader = tr
+		contentType := hdr.Xattrs["user.swarm.content-type"]
+		if contentType == "" {
+			contentType, reader = api.DetectContentType(path, tr)
+		}
 		entry := &api.ManifestEntry{
 			Path:        path,
-			ContentType: hdr.Xattrs["user.swarm.content-type"],
+			ContentType: contentType,
 			Mode:        hdr.Mode,
 			Size:        hdr.Size,
 			ModTime:     hdr.ModTime,
 		}
-		log.Debug("adding path to new manifest", "ruid", req.ruid, "bytes", entry.Size, "path", entry.Path)
-		contentKey, err := mw.AddEntry(tr, entry)
+		log.Debug("adding path to new manifest", "ruid", req.ruid, "bytes", entry.Size, "path", entry.Path, "type", entry.ContentType)
+		contentKey, err := mw.AddEntry(reader, entry)
 		if err != nil {
 			return fmt.Errorf("error adding manifest entry from tar stream: %s", err)
 		}
@@ -297,18 +403,19 @@ func (s *Server) handleMultipartUpload(req *Request, boundary string, mw *api.Ma
 		}
 
 		// add the entry under the path from the request
-		name := part.FileName()
-		if name == "" {
-			name = part.FormName()
+		path := path.Join(req.uri.Path, partName(part))
+		// clients send parts of unknown type as application/octet-stream
+		contentType := part.Header.Get("Content-Type")
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType, reader = api.DetectContentType(path, reader)
 		}
-		path := path.Join(req.uri.Path, name)
 		entry := &api.ManifestEntry{
 			Path:        path,
-			ContentType: part.Header.Get("Content-Type"),
+			ContentType: contentType,
 			Size:        size,
 			ModTime:     time.Now(),
 		}
-		log.Debug("adding path to new manifest", "ruid", req.ruid, "bytes", entry.Size, "path", entry.Path)
+		log.Debug("adding path to new manifest", "ruid", req.ruid, "bytes", entry.Size, "path", entry.Path, "type", entry.ContentType)
 		contentKey, err := mw.AddEntry(reader, entry)
 		if err != nil {
 			return fmt.Errorf("error adding manifest entry from multipart form: %s", err)
@@ -317,11 +424,31 @@ func (s *Server) handleMultipartUpload(req *Request, boundary string, mw *api.Ma
 	}
 }
 
+// partName returns the path of the file in the multipart form part, which
+// is its file name including directories so that directories can be uploaded
+// as forms, or the form name if the part has no file name
+func partName(part *multipart.Part) string {
+	// part.FileName drops the directories of the file name
+	_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	name := params["filename"]
+	if name == "" {
+		name = part.FormName()
+	}
+	// the path must not leave the directory uploaded to
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
 func (s *Server) handleDirectUpload(req *Request, mw *api.ManifestWriter) error {
 	log.Debug("handle.direct.upload", "ruid", req.ruid)
-	key, err := mw.AddEntry(req.Body, &api.ManifestEntry{
+	var reader io.Reader = req.Body
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "application/octet-stream" {
+		contentType, reader = api.DetectContentType(req.uri.Path, reader)
+	}
+	// the size of uploads without a Content-Length is counted when stored
+	key, err := mw.AddEntry(reader, &api.ManifestEntry{
 		Path:        req.uri.Path,
-		ContentType: req.Header.Get("Content-Type"),
+		ContentType: contentType,
 		Mode:        0644,
 		Size:        req.ContentLength,
 		ModTime:     time.Now(),
@@ -347,7 +474,7 @@ func (s *Server) HandleDelete(w http.ResponseWriter, r *Request) {
 		return
 	}
 
-	newKey, err := s.updateManifest(key, func(mw *api.ManifestWriter) error {
+	newKey, err := s.updateManifest(key, nil, func(mw *api.ManifestWriter) error {
 		log.Debug(fmt.Sprintf("removing %s from manifest %s", r.uri.Path, key.Log()), "ruid", r.ruid)
 		return mw.RemoveEntry(r.uri.Path)
 	})
@@ -612,6 +739,8 @@ func (s *Server) translateResourceError(w http.ResponseWriter, r *Request, supEr
 		return http.StatusUnauthorized, defaultErr
 	case storage.ErrDataOverflow:
 		return http.StatusRequestEntityTooLarge, defaultErr
+	case storage.ErrResourceRevoked:
+		return http.StatusGone, defaultErr
 	}
 
 	return http.StatusInternalServerError, defaultErr
@@ -635,7 +764,7 @@ func (s *Server) HandleGet(w http.ResponseWriter, r *Request) {
 			return
 		}
 	} else {
-		w.Header().Set("Cache-Control", "max-age=2147483648, immutable") // url was of type bzz://<hex key>/path, so we are sure it is immutable.
+		w.Header().Set("Cache-Control", immutableCacheControl) // url was of type bzz://<hex key>/path, so we are sure it is immutable.
 	}
 
 	log.Debug("handle.get: resolved", "ruid", r.ruid, "key", key)
@@ -680,18 +809,14 @@ func (s *Server) HandleGet(w http.ResponseWriter, r *Request) {
 		}
 		key = storage.Key(common.Hex2Bytes(entry.Hash))
 	}
-	etag := common.Bytes2Hex(key)
-	noneMatchEtag := r.Header.Get("If-None-Match")
-	w.Header().Set("ETag", etag) // set etag to manifest key or raw entry key.
-	if noneMatchEtag != "" {
-		if bytes.Equal(storage.Key(common.Hex2Bytes(noneMatchEtag)), key) {
-			Respond(w, r, "Not Modified", http.StatusNotModified)
-			return
-		}
+	// set etag to manifest key or raw entry key.
+	if setETag(w, r, key, r.uri.Key() != nil) {
+		Respond(w, r, "Not Modified", http.StatusNotModified)
+		return
 	}
 
 	// check the root chunk exists by retrieving the file's size
-	reader, isEncrypted := s.api.Retrieve(key)
+	reader, isEncrypted := s.api.RetrieveWithContext(r.Context(), key)
 	if _, err := reader.Size(nil); err != nil {
 		getFail.Inc(1)
 		Respond(w, r, fmt.Sprintf("root chunk not found %s: %s", key, err), http.StatusNotFound)
@@ -709,7 +834,7 @@ func (s *Server) HandleGet(w http.ResponseWriter, r *Request) {
 			contentType = typ
 		}
 		w.Header().Set("Content-Type", contentType)
-		http.ServeContent(w, &r.Request, "", time.Now(), reader)
+		serveContent(w, r, reader)
 	case r.uri.Hash():
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusOK)
@@ -717,6 +842,35 @@ func (s *Server) HandleGet(w http.ResponseWriter, r *Request) {
 	}
 }
 
+// HandleGetTag handles a GET request to bzz-tag:/<uid> and responds with the
+// counters of the chunks of the upload with the tag uid, or to bzz-tag:/ and
+// responds with the counters of all uploads, as JSON
+func (s *Server) HandleGetTag(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.get.tag", "ruid", r.ruid)
+
+	if s.tags == nil {
+		Respond(w, r, "uploads are not tracked", http.StatusNotFound)
+		return
+	}
+	var status interface{}
+	if r.uri.Addr == "" {
+		status = api.NewTags(s.tags).Tags()
+	} else {
+		uid, err := strconv.ParseUint(r.uri.Addr, 10, 32)
+		if err != nil {
+			Respond(w, r, fmt.Sprintf("invalid tag uid %q", r.uri.Addr), http.StatusBadRequest)
+			return
+		}
+		status, err = api.NewTags(s.tags).Tag(uint32(uid))
+		if err != nil {
+			Respond(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
 // HandleGetFiles handles a GET request to bzz:/<manifest> with an Accept
 // header of "application/x-tar" and returns a tar stream of all files
 // contained in the manifest
@@ -736,6 +890,21 @@ func (s *Server) HandleGetFiles(w http.ResponseWriter, r *Request) {
 		return
 	}
 	log.Debug("handle.get.files: resolved", "ruid", r.ruid, "key", key)
+	if r.uri.Key() != nil {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	}
+
+	key, ok := s.resolveAccess(w, r, key)
+	if !ok {
+		getFilesFail.Inc(1)
+		return
+	}
+
+	// the tar stream of the manifest is identified by its key
+	if setETag(w, r, key, r.uri.Key() != nil) {
+		Respond(w, r, "Not Modified", http.StatusNotModified)
+		return
+	}
 
 	walker, err := s.api.NewManifestWalker(key, nil)
 	if err != nil {
@@ -756,7 +925,7 @@ func (s *Server) HandleGetFiles(w http.ResponseWriter, r *Request) {
 		}
 
 		// retrieve the entry's key and size
-		reader, isEncrypted := s.api.Retrieve(storage.Key(common.Hex2Bytes(entry.Hash)))
+		reader, isEncrypted := s.api.RetrieveWithContext(r.Context(), storage.Key(common.Hex2Bytes(entry.Hash)))
 		size, err := reader.Size(nil)
 		if err != nil {
 			return err
@@ -812,6 +981,17 @@ func (s *Server) HandleGetList(w http.ResponseWriter, r *Request) {
 		return
 	}
 	log.Debug("handle.get.list: resolved", "ruid", r.ruid, "key", key)
+	if r.uri.Key() != nil {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	}
+	// the list is served as HTML or JSON depending on the Accept header
+	w.Header().Set("Vary", "Accept")
+
+	key, ok := s.resolveAccess(w, r, key)
+	if !ok {
+		getListFail.Inc(1)
+		return
+	}
 
 	list, err := s.getManifestList(key, r.uri.Path)
 
@@ -821,6 +1001,12 @@ func (s *Server) HandleGetList(w http.ResponseWriter, r *Request) {
 		return
 	}
 
+	respondList(w, r, list)
+}
+
+// respondList responds with the list as a HTML index if the client wants HTML
+// (e.g. a browser), otherwise as JSON
+func respondList(w http.ResponseWriter, r *Request, list api.ManifestList) {
 	// if the client wants HTML (e.g. a browser) then render the list as a
 	// HTML index with relative URLs
 	if strings.Contains(r.Header.Get("Accept"), "text/html") {
@@ -844,6 +1030,68 @@ func (s *Server) HandleGetList(w http.ResponseWriter, r *Request) {
 	json.NewEncoder(w).Encode(&list)
 }
 
+// serveDirectory responds with the list of the entries under the path of the
+// request if the path is a directory in the manifest with the key, and tells
+// if it did so
+//
+// Directories are listed with a trailing slash so that the relative URLs of
+// the HTML index work, requests without one are redirected if the directory
+// is the only match of the path.
+func (s *Server) serveDirectory(w http.ResponseWriter, r *Request, key storage.Key) bool {
+	list, err := s.getManifestList(key, r.uri.Path)
+	if err != nil {
+		return false
+	}
+	if r.uri.Path != "" && !strings.HasSuffix(r.uri.Path, "/") {
+		if len(list.Entries) != 0 || len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0] != r.uri.Path+"/" {
+			return false
+		}
+		target := r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, &r.Request, target, http.StatusMovedPermanently)
+		return true
+	}
+	if len(list.Entries) == 0 && len(list.CommonPrefixes) == 0 {
+		return false
+	}
+	log.Debug("handle.get.file: listing directory", "ruid", r.ruid, "key", key, "path", r.uri.Path)
+	respondList(w, r, list)
+	return true
+}
+
+// serveErrorDocument responds with the error document of the manifest with
+// the key and 404 Not Found if the manifest declares one, and tells if it
+// did so
+func (s *Server) serveErrorDocument(w http.ResponseWriter, r *Request, key storage.Key) bool {
+	_, errorDocument, err := s.api.ManifestDocuments(key)
+	if err != nil || errorDocument == "" {
+		return false
+	}
+	entry, err := s.api.GetManifestEntry(key, errorDocument)
+	if err != nil {
+		return false
+	}
+	reader, _ := s.api.Retrieve(storage.Key(common.Hex2Bytes(entry.Hash)))
+	size, err := reader.Size(nil)
+	if err != nil {
+		return false
+	}
+	log.Debug("handle.get.file: serving error document", "ruid", r.ruid, "key", key, "path", errorDocument)
+	w.Header().Del("Cache-Control")
+	w.Header().Del("ETag")
+	if entry.ContentType != "" {
+		w.Header().Set("Content-Type", entry.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != "HEAD" {
+		io.Copy(w, io.NewSectionReader(reader, 0, size))
+	}
+	return true
+}
+
 func (s *Server) getManifestList(key storage.Key, prefix string) (list api.ManifestList, err error) {
 	walker, err := s.api.NewManifestWalker(key, nil)
 	if err != nil {
@@ -902,15 +1150,31 @@ func (s *Server) getManifestList(key storage.Key, prefix string) (list api.Manif
 
 // HandleGetFile handles a GET request to bzz://<manifest>/<path> and responds
 // with the content of the file at <path> from the given <manifest>
+//
+// If the content is a mutable resource, the update selected by the period and
+// version query parameters is served, eg. bzz://<manifest>/?period=3&version=1,
+// or the latest update if they are not given.
+//
+// If <path> is a directory in the manifest rather than a file, the entries
+// under it are listed like with bzz-list://<manifest>/<path>/.
 func (s *Server) HandleGetFile(w http.ResponseWriter, r *Request) {
 	log.Debug("handle.get.file", "ruid", r.ruid)
 	getFileCount.Inc(1)
 	// ensure the root path has a trailing slash so that relative URLs work
 	if r.uri.Path == "" && !strings.HasSuffix(r.URL.Path, "/") {
-		http.Redirect(w, &r.Request, r.URL.Path+"/", http.StatusMovedPermanently)
+		target := r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, &r.Request, target, http.StatusMovedPermanently)
+		return
+	}
+	period, version, err := resourceVersion(r)
+	if err != nil {
+		getFileFail.Inc(1)
+		Respond(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
-	var err error
 	manifestKey := r.uri.Key()
 
 	if manifestKey == nil {
@@ -921,28 +1185,63 @@ func (s *Server) HandleGetFile(w http.ResponseWriter, r *Request) {
 			return
 		}
 	} else {
-		w.Header().Set("Cache-Control", "max-age=2147483648, immutable") // url was of type bzz://<hex key>/path, so we are sure it is immutable.
+		w.Header().Set("Cache-Control", immutableCacheControl) // url was of type bzz://<hex key>/path, so we are sure it is immutable.
 	}
 
 	log.Debug("handle.get.file: resolved", "ruid", r.ruid, "key", manifestKey)
 
-	reader, contentType, status, contentKey, err := s.api.Get(manifestKey, r.uri.Path)
+	manifestKey, ok := s.resolveAccess(w, r, manifestKey)
+	if !ok {
+		getFileFail.Inc(1)
+		return
+	}
 
-	etag := common.Bytes2Hex(contentKey)
-	noneMatchEtag := r.Header.Get("If-None-Match")
-	w.Header().Set("ETag", etag) // set etag to actual content key.
-	if noneMatchEtag != "" {
-		if bytes.Equal(storage.Key(common.Hex2Bytes(noneMatchEtag)), contentKey) {
-			Respond(w, r, "Not Modified", http.StatusNotModified)
-			return
+	// directories are served by their index document if the manifest
+	// declares one and the directory has it
+	path := r.uri.Path
+	if path == "" || strings.HasSuffix(path, "/") {
+		if index, _, err := s.api.ManifestDocuments(manifestKey); err == nil && index != "" {
+			if _, err := s.api.GetManifestEntry(manifestKey, path+index); err == nil {
+				path += index
+			}
 		}
 	}
 
+	var reader storage.LazySectionReader
+	var contentType string
+	var status int
+	var contentKey storage.Key
+	immutable := r.uri.Key() != nil
+	reader, contentType, status, contentKey, err = s.api.GetImmutable(manifestKey, path)
+	if err == api.ErrMutableContent && !r.uri.Immutable() && !s.immutable {
+		// the content of a mutable resource changes with its updates even
+		// if the url is of type bzz://<hex key>/path
+		immutable = false
+		w.Header().Del("Cache-Control")
+		reader, contentType, status, contentKey, err = s.api.GetVersion(manifestKey, path, period, version)
+	}
+
+	// set etag to actual content key.
+	if setETag(w, r, contentKey, immutable) {
+		Respond(w, r, "Not Modified", http.StatusNotModified)
+		return
+	}
+
 	if err != nil {
 		switch status {
 		case http.StatusNotFound:
+			// the path is not a file but it may be a directory
+			if s.serveDirectory(w, r, manifestKey) {
+				return
+			}
 			getFileNotFound.Inc(1)
+			if s.serveErrorDocument(w, r, manifestKey) {
+				return
+			}
 			Respond(w, r, err.Error(), http.StatusNotFound)
+		case http.StatusBadRequest:
+			getFileFail.Inc(1)
+			Respond(w, r, err.Error(), http.StatusBadRequest)
 		default:
 			getFileFail.Inc(1)
 			Respond(w, r, err.Error(), http.StatusInternalServerError)
@@ -953,6 +1252,10 @@ func (s *Server) HandleGetFile(w http.ResponseWriter, r *Request) {
 	//the request results in ambiguous files
 	//e.g. /read with readme.md and readinglist.txt available in manifest
 	if status == http.StatusMultipleChoices {
+		// unless the path is a directory
+		if s.serveDirectory(w, r, manifestKey) {
+			return
+		}
 		list, err := s.getManifestList(manifestKey, r.uri.Path)
 
 		if err != nil {
@@ -974,8 +1277,75 @@ func (s *Server) HandleGetFile(w http.ResponseWriter, r *Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", contentType)
-	http.ServeContent(w, &r.Request, "", time.Now(), reader)
+	// entries without a content type get one sniffed from their content
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	serveContent(w, r, reader)
+}
+
+// resourceVersion parses the period and version query parameters selecting
+// the update of a mutable resource, they are 0 if not given
+func resourceVersion(r *Request) (period, version uint32, err error) {
+	query := r.URL.Query()
+	for _, p := range []struct {
+		name  string
+		value *uint32
+	}{
+		{"period", &period},
+		{"version", &version},
+	} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %s %q", p.name, v)
+		}
+		*p.value = uint32(n)
+	}
+	return period, version, nil
+}
+
+// immutableCacheControl is the Cache-Control header of the responses to
+// immutable URLs, eg. bzz:/<hex key>/path, as their content never changes
+const immutableCacheControl = "max-age=2147483648, immutable"
+
+// setETag sets the strong ETag header of the response to the quoted hex of
+// the content key and tells if the content need not be served as the request
+// is conditional on it, in which case 304 Not Modified is to be responded
+//
+// The key matching the If-None-Match header makes the request unmodified, as
+// does any If-Modified-Since header without If-None-Match if the content is
+// immutable, since it has not changed since it was first served. The content
+// of mutable resources is only compared by the ETag.
+func setETag(w http.ResponseWriter, r *Request, key storage.Key, immutable bool) bool {
+	if key == nil {
+		return false
+	}
+	w.Header().Set("ETag", fmt.Sprintf("%q", common.Bytes2Hex(key)))
+	noneMatch := r.Header.Get("If-None-Match")
+	if noneMatch == "" {
+		return immutable && r.Header.Get("If-Modified-Since") != ""
+	}
+	for _, etag := range strings.Split(noneMatch, ",") {
+		etag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), `"`)
+		if etag == "*" || bytes.Equal(storage.Key(common.Hex2Bytes(etag)), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveContent serves the content from the reader, it honors Range and
+// If-Range headers so that byte ranges of the content are served with 206
+// Partial Content, eg. for media streaming and resuming downloads
+//
+// The content is immutable and its modification time is unknown, so no
+// Last-Modified header is sent and If-Range only matches the ETag.
+func serveContent(w http.ResponseWriter, r *Request, reader io.ReadSeeker) {
+	http.ServeContent(w, &r.Request, "", time.Time{}, reader)
 }
 
 func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
@@ -987,6 +1357,35 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	// wrapping the ResponseWriter, so that we get the response code set by http.ServeContent
 	w := newLoggingResponseWriter(rw)
 
+	// security headers restricting how browsers may embed the responses and
+	// what the served pages may load
+	if s.frameOptions != "" {
+		w.Header().Set("X-Frame-Options", s.frameOptions)
+	}
+	if s.csp != "" {
+		w.Header().Set("Content-Security-Policy", s.csp)
+	}
+
+	// limits protecting public gateways from abusive clients, requests over
+	// them are rejected straight away rather than queued
+	if s.limiter != nil && !s.limiter.allow(clientIP(r), time.Now()) {
+		metrics.GetOrRegisterCounter("api.http.ratelimit.reject", nil).Inc(1)
+		w.Header().Set("Retry-After", "1")
+		Respond(w, req, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if s.requests != nil {
+		select {
+		case s.requests <- struct{}{}:
+			defer func() { <-s.requests }()
+		default:
+			metrics.GetOrRegisterCounter("api.http.maxrequests.reject", nil).Inc(1)
+			w.Header().Set("Retry-After", "1")
+			Respond(w, req, "too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
 	if r.RequestURI == "/" && strings.Contains(r.Header.Get("Accept"), "text/html") {
 
 		err := landingPageTemplate.Execute(w, nil)
@@ -1019,34 +1418,71 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	log.Debug("parsed request path", "ruid", req.ruid, "method", req.Method, "uri.Addr", req.uri.Addr, "uri.Path", req.uri.Path, "uri.Scheme", req.uri.Scheme)
 
+	// requests changing content must be authorized, reading it is public
+	if s.auth != nil && (r.Method == "POST" || r.Method == "PUT" || r.Method == "DELETE") && !s.checkWriteAuth(w, req) {
+		return
+	}
+
 	switch r.Method {
-	case "POST":
+	case "POST", "PUT":
+		if r.Method == "PUT" && !uri.Raw() {
+			Respond(w, req, fmt.Sprintf("PUT method to %s not allowed", uri), http.StatusBadRequest)
+			return
+		}
+		if s.maxUploadSize > 0 {
+			if r.ContentLength > s.maxUploadSize {
+				metrics.GetOrRegisterCounter("api.http.post.toolarge", nil).Inc(1)
+				Respond(w, req, fmt.Sprintf("request body larger than %d bytes", s.maxUploadSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			// bodies of unknown size fail to be read past the limit
+			req.Body = http.MaxBytesReader(w, req.Body, s.maxUploadSize)
+		}
+		// checking chunks does not upload anything, so it is neither
+		// accounted nor tracked
+		if uri.Has() {
+			s.HandlePostHas(w, req)
+			return
+		}
+		if s.quotas != nil {
+			body, reserved, ok := s.reserveUploadQuota(w, req)
+			if !ok {
+				return
+			}
+			defer func() { s.quotas.Settle(req.account, reserved, body.count) }()
+		}
+		if s.tags != nil && !uri.Resource() && !uri.Tag() {
+			req.tag = s.tags.New(uri.String())
+			w.Header().Set("X-Swarm-Tag", strconv.FormatUint(uint64(req.tag.Uid), 10))
+		}
 		if uri.Raw() {
 			log.Debug("handlePostRaw")
 			s.HandlePostRaw(w, req)
 		} else if uri.Resource() {
 			log.Debug("handlePostResource")
 			s.HandlePostResource(w, req)
-		} else if uri.Immutable() || uri.List() || uri.Hash() {
-			log.Debug("POST not allowed on immutable, list or hash")
+		} else if uri.Immutable() || uri.List() || uri.Hash() || uri.Tag() {
+			log.Debug("POST not allowed on immutable, list, hash or tag")
 			Respond(w, req, fmt.Sprintf("POST method on scheme %s not allowed", uri.Scheme), http.StatusMethodNotAllowed)
 		} else {
 			log.Debug("handlePostFiles")
 			s.HandlePostFiles(w, req)
 		}
 
-	case "PUT":
-		Respond(w, req, fmt.Sprintf("PUT method to %s not allowed", uri), http.StatusBadRequest)
-		return
-
 	case "DELETE":
-		if uri.Raw() {
+		if uri.Raw() || uri.Tag() {
 			Respond(w, req, fmt.Sprintf("DELETE method to %s not allowed", uri), http.StatusBadRequest)
 			return
 		}
 		s.HandleDelete(w, req)
 
-	case "GET":
+	case "GET", "HEAD":
+		// an immutable gateway only serves content by its hash, the content
+		// of names and mutable resources could change underneath the clients
+		if s.immutable && (uri.Resource() || (!uri.Tag() && !uri.Has() && uri.Key() == nil)) {
+			Respond(w, req, fmt.Sprintf("only content hashes are served, cannot serve %s", uri), http.StatusForbidden)
+			return
+		}
 
 		if uri.Resource() {
 			s.HandleGetResource(w, req)
@@ -1058,6 +1494,16 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if uri.Tag() {
+			s.HandleGetTag(w, req)
+			return
+		}
+
+		if uri.Has() {
+			Respond(w, req, "chunks to check must be posted to bzz-has:/", http.StatusMethodNotAllowed)
+			return
+		}
+
 		if uri.List() {
 			s.HandleGetList(w, req)
 			return
@@ -1077,8 +1523,113 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	log.Info("served response", "ruid", req.ruid, "code", w.statusCode)
 }
 
-func (s *Server) updateManifest(key storage.Key, update func(mw *api.ManifestWriter) error) (storage.Key, error) {
-	mw, err := s.api.NewManifestWriter(key, nil)
+// checkWriteAuth checks that the request is authorized to change content by an
+// API token with the Bearer scheme or a user and password with the Basic scheme
+// in the Authorization header, it responds with 401 Unauthorized and returns
+// false if it is not
+func (s *Server) checkWriteAuth(w http.ResponseWriter, r *Request) bool {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		if s.auth.Token(strings.TrimPrefix(auth, "Bearer ")) {
+			return true
+		}
+	} else if user, password, ok := r.BasicAuth(); ok && s.auth.User(user, password) {
+		return true
+	}
+	metrics.GetOrRegisterCounter("api.http.write.unauthorized", nil).Inc(1)
+	// let browsers ask for a user and password
+	if s.auth.Basic() {
+		w.Header().Set("WWW-Authenticate", `Basic realm="swarm"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="swarm"`)
+	}
+	Respond(w, r, fmt.Sprintf("%s method to %s not authorized", r.Method, r.uri), http.StatusUnauthorized)
+	return false
+}
+
+// resolveAccess resolves the access manifest at key with the password of the
+// Basic scheme in the Authorization header, it responds with 401 Unauthorized
+// and returns false if access is not granted
+//
+// pk access manifests are never resolved with the key of the node, as anyone
+// reaching the gateway would get the content granted to it, the grantees
+// resolve them with their own key, see api.ResolveAccessManifest
+func (s *Server) resolveAccess(w http.ResponseWriter, r *Request, key storage.Key) (storage.Key, bool) {
+	creds := &api.Credentials{}
+	if _, password, ok := r.BasicAuth(); ok {
+		creds.Password = password
+	}
+	ref, err := s.api.ResolveAccess(key, creds)
+	switch err {
+	case nil:
+		// content behind access manifests must not be kept in shared caches
+		if !bytes.Equal(ref, key) && w.Header().Get("Cache-Control") != "" {
+			w.Header().Set("Cache-Control", "private, "+immutableCacheControl)
+		}
+		return ref, true
+	case api.ErrNoCredentials, api.ErrAccessDenied:
+		metrics.GetOrRegisterCounter("api.http.access.denied", nil).Inc(1)
+		w.Header().Set("WWW-Authenticate", `Basic realm="swarm access"`)
+		Respond(w, r, fmt.Sprintf("access to %s denied: %s", r.uri, err), http.StatusUnauthorized)
+	case api.ErrKdfBusy:
+		metrics.GetOrRegisterCounter("api.http.access.busy", nil).Inc(1)
+		w.Header().Set("Retry-After", "1")
+		Respond(w, r, fmt.Sprintf("cannot resolve access to %s: %s", r.uri, err), http.StatusServiceUnavailable)
+	default:
+		Respond(w, r, fmt.Sprintf("cannot resolve access to %s: %s", r.uri, err), http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// reserveUploadQuota reserves the quota of the account of the request for its
+// body and counts the bytes read from it, it responds with an error and
+// returns false if the upload is rejected
+//
+// The account is identified by the API key in the Authorization header with
+// the Bearer scheme, requests without one are accounted to the anonymous
+// account. The body fails to be read past the bytes reserved, so that bodies
+// of unknown size can not exceed the quota either.
+func (s *Server) reserveUploadQuota(w http.ResponseWriter, r *Request) (*countingReader, uint64, bool) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		r.account = strings.TrimPrefix(auth, "Bearer ")
+	}
+	var size uint64
+	if r.ContentLength > 0 {
+		size = uint64(r.ContentLength)
+	}
+	reserved, err := s.quotas.Reserve(r.account, size)
+	if err != nil {
+		metrics.GetOrRegisterCounter("api.http.post.quota.reject", nil).Inc(1)
+		status := http.StatusForbidden
+		if err == api.ErrUnknownAccount {
+			status = http.StatusUnauthorized
+		}
+		Respond(w, r, err.Error(), status)
+		return nil, 0, false
+	}
+	if reserved > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(reserved))
+	}
+	body := &countingReader{ReadCloser: r.Body}
+	r.Body = body
+	return body, reserved, true
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	count uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count += uint64(n)
+	return n, err
+}
+
+// updateManifest updates the manifest with the key and stores it, the chunks
+// stored are counted in the tag unless it is nil
+func (s *Server) updateManifest(key storage.Key, tag *storage.Tag, update func(mw *api.ManifestWriter) error) (storage.Key, error) {
+	mw, err := s.api.WithTag(tag).NewManifestWriter(key, nil)
 	if err != nil {
 		return nil, err
 	}
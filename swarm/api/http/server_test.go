@@ -17,24 +17,31 @@
 package http
 
 import (
+	"archive/tar"
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/swarm/api"
 	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
 	"github.com/ethereum/go-ethereum/swarm/multihash"
+	"github.com/ethereum/go-ethereum/swarm/state"
 	"github.com/ethereum/go-ethereum/swarm/storage"
 	"github.com/ethereum/go-ethereum/swarm/testutil"
 )
@@ -170,6 +177,129 @@ func TestBzzResourceMultihash(t *testing.T) {
 	if !bytes.Equal(b, []byte(databytes)) {
 		t.Fatalf("retrieved data mismatch, expected %x, got %x", databytes, b)
 	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag of the content of the update")
+	}
+
+	// the content of the resource can change, so it is only unmodified if
+	// its ETag matches
+	for _, c := range []struct {
+		header string
+		value  string
+		code   int
+	}{
+		{"If-Modified-Since", time.Now().Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+		{"If-None-Match", etag, http.StatusNotModified},
+	} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(c.header, c.value)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != c.code {
+			t.Fatalf("%s: expected status %d, got %d", c.header, c.code, res.StatusCode)
+		}
+	}
+}
+
+// TestBzzResourceVersion tests that bzz:// URLs of a resource manifest or of
+// the root key of a resource serve the latest update of the resource, or the
+// update selected by the period and version query parameters
+func TestBzzResourceVersion(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	post := func(url string, data []byte) []byte {
+		resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s: %s", url, resp.Status)
+		}
+		return b
+	}
+	get := func(url string) (int, []byte) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, b
+	}
+
+	// creates the resource and sets update 1.1, then update 1.2
+	data1, data2 := []byte("update 1"), []byte("update 2")
+	var manifestKey storage.Key
+	if err := json.Unmarshal(post(fmt.Sprintf("%s/bzz-resource:/foo.eth/raw/13", srv.URL), data1), &manifestKey); err != nil {
+		t.Fatal(err)
+	}
+	post(fmt.Sprintf("%s/bzz-resource:/%s/raw", srv.URL, manifestKey), data2)
+
+	var manifest api.Manifest
+	_, b := get(fmt.Sprintf("%s/bzz-raw:/%s", srv.URL, manifestKey))
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	rootKey := manifest.Entries[0].Hash
+
+	for _, key := range []string{manifestKey.Hex(), rootKey} {
+		for _, c := range []struct {
+			query string
+			code  int
+			data  []byte
+		}{
+			{"", http.StatusOK, data2},
+			{"?period=1", http.StatusOK, data2},
+			{"?period=1&version=1", http.StatusOK, data1},
+			{"?period=1&version=2", http.StatusOK, data2},
+			{"?version=1", http.StatusBadRequest, nil},
+			{"?period=x", http.StatusBadRequest, nil},
+		} {
+			code, b := get(fmt.Sprintf("%s/bzz:/%s/%s", srv.URL, key, c.query))
+			if code != c.code {
+				t.Fatalf("%s%s: expected status %d, got %d", key, c.query, c.code, code)
+			}
+			if c.data != nil && !bytes.Equal(b, c.data) {
+				t.Fatalf("%s%s: expected %q, got %q", key, c.query, c.data, b)
+			}
+		}
+	}
+
+	// the query is kept when redirecting to the root path
+	code, b := get(fmt.Sprintf("%s/bzz:/%s?period=1&version=1", srv.URL, rootKey))
+	if code != http.StatusOK || !bytes.Equal(b, data1) {
+		t.Fatalf("expected %q after redirect, got %d %q", data1, code, b)
+	}
+
+	// the content of the resource changes, so it must not be cached as
+	// immutable content even though the urls have the key
+	for _, key := range []string{manifestKey.Hex(), rootKey} {
+		resp, err := http.Get(fmt.Sprintf("%s/bzz:/%s/", srv.URL, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if cc := resp.Header.Get("Cache-Control"); cc != "" {
+			t.Fatalf("%s: expected no Cache-Control, got %q", key, cc)
+		}
+	}
 }
 
 // Test resource updates using the raw update methods
@@ -655,6 +785,254 @@ func testBzzRootRedirect(toEncrypt bool, t *testing.T) {
 	}
 }
 
+// TestBzzRawStream tests that a raw upload without Content-Length is streamed
+// to swarm, both with POST and with PUT as eg. curl -T - does
+func TestBzzRawStream(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	data := make([]byte, 3*4096+17)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	upload := func(method string, chunked bool) string {
+		var body io.Reader = bytes.NewReader(data)
+		if chunked {
+			// hide the length of the body so it is sent chunked
+			body = ioutil.NopCloser(body)
+		}
+		req, err := http.NewRequest(method, srv.URL+"/bzz-raw:/", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		key, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d (%s)", method, http.StatusOK, res.StatusCode, key)
+		}
+		return string(key)
+	}
+	expKey := upload("POST", false)
+	if key := upload("POST", true); key != expKey {
+		t.Fatalf("expected key %s of chunked POST, got %s", expKey, key)
+	}
+	if key := upload("PUT", true); key != expKey {
+		t.Fatalf("expected key %s of chunked PUT, got %s", expKey, key)
+	}
+
+	res, err := http.Get(srv.URL + "/bzz-raw:/" + expKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data) {
+		t.Fatal("expected the streamed content to be served")
+	}
+
+	req, err := http.NewRequest("PUT", srv.URL+"/bzz:/", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status %d for PUT to bzz:/, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+// TestBzzRawRange tests that a byte range of raw content is served
+func TestBzzRawRange(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	data := make([]byte, 100000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	key, wait, err := srv.Dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	for _, c := range []struct {
+		header string
+		start  int
+		end    int
+	}{
+		{"bytes=0-9", 0, 10},
+		{"bytes=4090-8200", 4090, 8201},
+		{"bytes=99990-", 99990, 100000},
+		{"bytes=-5", 99995, 100000},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+"/bzz-raw:/"+key.Hex(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", c.header)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusPartialContent {
+			t.Fatalf("expected status %d for range %s, got %d", http.StatusPartialContent, c.header, res.StatusCode)
+		}
+		if !bytes.Equal(body, data[c.start:c.end]) {
+			t.Fatalf("unexpected content for range %s", c.header)
+		}
+	}
+}
+
+// TestBzzGetRange tests that Range requests for a file in a manifest are
+// served with 206 Partial Content, honoring If-Range with the ETag
+func TestBzzGetRange(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	data := make([]byte, 10000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	client := swarm.NewClient(srv.URL)
+	hash, err := client.Upload(&swarm.File{
+		ReadCloser: ioutil.NopCloser(bytes.NewReader(data)),
+		ManifestEntry: api.ManifestEntry{
+			Path:        "video.mp4",
+			ContentType: "video/mp4",
+			Size:        int64(len(data)),
+		},
+	}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := srv.URL + "/bzz:/" + hash + "/video.mp4"
+
+	get := func(method string, header map[string]string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, body
+	}
+
+	res, _ := get("HEAD", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d for HEAD, got %d", http.StatusOK, res.StatusCode)
+	}
+	if res.Header.Get("Accept-Ranges") != "bytes" || res.ContentLength != int64(len(data)) {
+		t.Fatalf("expected byte ranges of %d bytes accepted, got %q of %d bytes", len(data), res.Header.Get("Accept-Ranges"), res.ContentLength)
+	}
+	etag := res.Header.Get("ETag")
+
+	res, body := get("GET", map[string]string{"Range": "bytes=4000-4999"})
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected status %d, got %d", http.StatusPartialContent, res.StatusCode)
+	}
+	if cr := res.Header.Get("Content-Range"); cr != "bytes 4000-4999/10000" {
+		t.Fatalf("expected Content-Range bytes 4000-4999/10000, got %q", cr)
+	}
+	if !bytes.Equal(body, data[4000:5000]) {
+		t.Fatal("unexpected content of the range")
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "video/mp4" {
+		t.Fatalf("expected Content-Type video/mp4, got %q", ct)
+	}
+
+	// resuming the download of the same content
+	res, body = get("GET", map[string]string{"Range": "bytes=9000-", "If-Range": etag})
+	if res.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[9000:]) {
+		t.Fatalf("expected the range served for matching If-Range, got status %d", res.StatusCode)
+	}
+	// the content changed, the whole of it is served
+	res, body = get("GET", map[string]string{"Range": "bytes=9000-", "If-Range": `"abcd"`})
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("expected the whole content served for other If-Range, got status %d", res.StatusCode)
+	}
+
+	res, _ = get("GET", map[string]string{"Range": "bytes=20000-"})
+	if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+	}
+
+	res, _ = get("GET", map[string]string{"If-None-Match": etag})
+	if res.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected status %d, got %d", http.StatusNotModified, res.StatusCode)
+	}
+}
+
+// TestBzzRawEncryptFlag tests that raw content uploaded with the encrypt
+// query parameter is encrypted and can be downloaded with the returned
+// reference which includes the decryption key
+func TestBzzRawEncryptFlag(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	data := []byte("encrypted content")
+	res, err := http.Post(srv.URL+"/bzz-raw:/?encrypt=true", "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	// the reference is the content hash and the decryption key
+	if len(ref) != 128 {
+		t.Fatalf("expected a reference of 128 hex characters, got %q", ref)
+	}
+
+	res, err = http.Get(srv.URL + "/bzz-raw:/" + string(ref))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data) {
+		t.Fatalf("expected %q, got %q", data, body)
+	}
+	if res.Header.Get("X-Decrypted") != "true" {
+		t.Fatalf("expected X-Decrypted header true, got %q", res.Header.Get("X-Decrypted"))
+	}
+}
+
 func TestMethodsNotAllowed(t *testing.T) {
 	srv := testutil.NewTestSwarmServer(t, serverFunc)
 	defer srv.Close()
@@ -682,3 +1060,1098 @@ func TestMethodsNotAllowed(t *testing.T) {
 	}
 
 }
+
+// TestBzzUploadQuota tests that uploads are accounted to the API key of the
+// request and rejected once the quota of the key is exceeded
+func TestBzzUploadQuota(t *testing.T) {
+	store := state.NewInmemoryStore()
+	quotas, err := api.NewUploadQuotas(10, map[string]uint64{"key": 20, "chunked": 10}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		server := NewServer(a)
+		server.quotas = quotas
+		return server
+	})
+	defer srv.Close()
+
+	post := func(key string, data io.Reader) int {
+		req, err := http.NewRequest("POST", srv.URL+"/bzz-raw:/", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	for i, c := range []struct {
+		key  string
+		data string
+		code int
+	}{
+		{"", "0123456789", http.StatusOK},
+		{"", "0", http.StatusForbidden},
+		{"key", "0123456789", http.StatusOK},
+		{"key", "0123456789abc", http.StatusForbidden},
+		{"key", "0123456789", http.StatusOK},
+		{"key", "0", http.StatusForbidden},
+		{"unknown", "0", http.StatusUnauthorized},
+	} {
+		if code := post(c.key, strings.NewReader(c.data)); code != c.code {
+			t.Fatalf("upload %d: expected status %d, got %d", i, c.code, code)
+		}
+	}
+	if used, quota := quotas.Usage("key"); used != 20 || quota != 20 {
+		t.Fatalf("expected 20 of 20 bytes used, got %d of %d", used, quota)
+	}
+
+	// bodies of unknown size are cut at the remaining quota
+	if code := post("chunked", ioutil.NopCloser(strings.NewReader("0123456789abc"))); code == http.StatusOK {
+		t.Fatal("expected upload over the quota to fail")
+	}
+	if used, _ := quotas.Usage("chunked"); used > 10 {
+		t.Fatalf("expected at most 10 bytes used, got %d", used)
+	}
+
+	// the usage is kept in the state store
+	quotas, err = api.NewUploadQuotas(10, map[string]uint64{"key": 20}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := quotas.Usage("key"); used != 20 {
+		t.Fatalf("expected 20 bytes used after reloading, got %d", used)
+	}
+}
+
+// TestBzzWriteAuth tests that only requests authorized with an API token or a
+// user and password can change content, while anyone can read it
+func TestBzzWriteAuth(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		server := NewServer(a)
+		server.auth = api.NewWriteAuth([]string{"token"}, map[string]string{"user": "password"})
+		return server
+	})
+	defer srv.Close()
+
+	do := func(method, url string, auth func(*http.Request)) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+url, strings.NewReader("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		if auth != nil {
+			auth(req)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	basic := func(user, password string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.SetBasicAuth(user, password)
+		}
+	}
+
+	for i, c := range []struct {
+		method string
+		auth   func(*http.Request)
+		code   int
+	}{
+		{"POST", nil, http.StatusUnauthorized},
+		{"PUT", nil, http.StatusUnauthorized},
+		{"POST", bearer("unknown"), http.StatusUnauthorized},
+		{"POST", bearer(""), http.StatusUnauthorized},
+		{"POST", basic("user", "wrong"), http.StatusUnauthorized},
+		{"POST", basic("unknown", "password"), http.StatusUnauthorized},
+		{"POST", bearer("token"), http.StatusOK},
+		{"PUT", bearer("token"), http.StatusOK},
+		{"POST", basic("user", "password"), http.StatusOK},
+	} {
+		if code, _ := do(c.method, "/bzz-raw:/", c.auth); code != c.code {
+			t.Fatalf("request %d: expected status %d, got %d", i, c.code, code)
+		}
+	}
+
+	code, key := do("POST", "/bzz:/", basic("user", "password"))
+	if code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	if code, _ := do("DELETE", "/bzz:/"+key+"/", nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d for DELETE, got %d", http.StatusUnauthorized, code)
+	}
+	if code, _ := do("DELETE", "/bzz:/"+key+"/", bearer("token")); code != http.StatusOK {
+		t.Fatalf("expected status %d for DELETE, got %d", http.StatusOK, code)
+	}
+
+	// reading is public
+	res, err := http.Get(srv.URL + "/bzz:/" + key + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(data) != "data" {
+		t.Fatalf("expected %q, got %d %q", "data", res.StatusCode, data)
+	}
+
+	// browsers are asked for a user and password
+	res, err = http.Post(srv.URL+"/bzz-raw:/", "text/plain", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if auth := res.Header.Get("WWW-Authenticate"); auth != `Basic realm="swarm"` {
+		t.Fatalf("expected WWW-Authenticate %q, got %q", `Basic realm="swarm"`, auth)
+	}
+}
+
+// TestBzzTag tests that the chunks of an upload are counted in the tag
+// returned in the X-Swarm-Tag header, and that the tag can be queried
+func TestBzzTag(t *testing.T) {
+	tags := storage.NewTags()
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		server := NewServer(a)
+		server.tags = tags
+		return server
+	})
+	defer srv.Close()
+
+	// 3 data chunks and the root chunk
+	data := make([]byte, 10000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(srv.URL+"/bzz-raw:/", "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	uid := res.Header.Get("X-Swarm-Tag")
+	if uid != "1" {
+		t.Fatalf("expected tag 1, got %q", uid)
+	}
+
+	getTag := func(path string, v interface{}) int {
+		res, err := http.Get(srv.URL + "/bzz-tag:/" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode
+	}
+	// the chunks are stored asynchronously
+	var status storage.TagStatus
+	for i := 0; ; i++ {
+		if code := getTag(uid, &status); code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+		}
+		if status.Stored == status.Split || i == 100 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Uid != 1 || status.Split != 4 || status.Stored != 4 {
+		t.Fatalf("expected 4 chunks split and stored for tag 1, got %+v", status)
+	}
+	if status.Sent != 0 || status.Synced != 0 {
+		t.Fatalf("expected no chunks sent or synced, got %+v", status)
+	}
+
+	var all []storage.TagStatus
+	if code := getTag("", &all); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	if len(all) != 1 || all[0].Uid != 1 {
+		t.Fatalf("expected tag 1, got %+v", all)
+	}
+	if code := getTag("2", &status); code != http.StatusNotFound {
+		t.Fatalf("expected status %d for unknown tag, got %d", http.StatusNotFound, code)
+	}
+	if code := getTag("x", &status); code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for invalid tag, got %d", http.StatusBadRequest, code)
+	}
+}
+
+// TestBzzUploadContentType tests that the files of tar and multipart uploads
+// get their content type from their extension or content unless it is given
+func TestBzzUploadContentType(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	files := []struct {
+		name        string
+		data        string
+		contentType string
+		expected    string
+	}{
+		{"index.html", "<h1>swarm</h1>", "", "text/html; charset=utf-8"},
+		{"dir/style.css", "h1 { color: red }", "", "text/css"},
+		{"noext", "<html><body>swarm</body></html>", "", "text/html; charset=utf-8"},
+		{"empty", "", "", "text/plain; charset=utf-8"},
+		{"given.html", "<h1>swarm</h1>", "application/x-swarm", "application/x-swarm"},
+	}
+
+	tarBody := &bytes.Buffer{}
+	tw := tar.NewWriter(tarBody)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name: f.name,
+			Mode: 0644,
+			Size: int64(len(f.data)),
+		}
+		if f.contentType != "" {
+			hdr.Xattrs = map[string]string{"user.swarm.content-type": f.contentType}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	multipartBody := &bytes.Buffer{}
+	mw := multipart.NewWriter(multipartBody)
+	for _, f := range files {
+		var w io.Writer
+		var err error
+		if f.contentType != "" {
+			w, err = mw.CreatePart(map[string][]string{
+				"Content-Disposition": {fmt.Sprintf(`form-data; name="%s"; filename="%s"`, f.name, f.name)},
+				"Content-Type":        {f.contentType},
+			})
+		} else {
+			// parts created as files are sent as application/octet-stream
+			w, err = mw.CreateFormFile(f.name, f.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, upload := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"application/x-tar", tarBody.Bytes()},
+		{mw.FormDataContentType(), multipartBody.Bytes()},
+	} {
+		res, err := http.Post(srv.URL+"/bzz:/", upload.contentType, bytes.NewReader(upload.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", upload.contentType, http.StatusOK, res.StatusCode)
+		}
+
+		for _, f := range files {
+			res, err := http.Get(fmt.Sprintf("%s/bzz:/%s/%s", srv.URL, key, f.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != f.data {
+				t.Fatalf("%s %s: expected %q, got %q", upload.contentType, f.name, f.data, data)
+			}
+			if contentType := res.Header.Get("Content-Type"); contentType != f.expected {
+				t.Fatalf("%s %s: expected content type %q, got %q", upload.contentType, f.name, f.expected, contentType)
+			}
+		}
+	}
+}
+
+// TestBzzGetDirectory tests that GET requests to bzz:// paths of directories
+// in a manifest respond with the list of the entries under the directory
+func TestBzzGetDirectory(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	files := map[string]string{
+		"a.txt":         "a",
+		"dir/b.txt":     "b",
+		"dir/sub/c.txt": "c",
+		"dir/sub/d.txt": "d",
+	}
+	body := &bytes.Buffer{}
+	tw := tar.NewWriter(body)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(srv.URL+"/bzz:/", "application/x-tar", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path, accept string) (*http.Response, []byte) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/bzz:/%s/%s", srv.URL, key, path), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, data
+	}
+
+	for _, c := range []struct {
+		path     string
+		entries  []string
+		prefixes []string
+	}{
+		{"", []string{"a.txt"}, []string{"dir/"}},
+		{"dir/", []string{"dir/b.txt"}, []string{"dir/sub/"}},
+		{"dir", []string{"dir/b.txt"}, []string{"dir/sub/"}},
+		{"dir/sub/", []string{"dir/sub/c.txt", "dir/sub/d.txt"}, nil},
+	} {
+		res, data := get(c.path, "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%q: expected status %d, got %d", c.path, http.StatusOK, res.StatusCode)
+		}
+		if !strings.HasSuffix(res.Request.URL.Path, "/") {
+			t.Fatalf("%q: expected redirect to a path with a trailing slash, got %s", c.path, res.Request.URL.Path)
+		}
+		if contentType := res.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("%q: expected content type application/json, got %q", c.path, contentType)
+		}
+		var list api.ManifestList
+		if err := json.Unmarshal(data, &list); err != nil {
+			t.Fatal(err)
+		}
+		var entries []string
+		for _, entry := range list.Entries {
+			entries = append(entries, entry.Path)
+		}
+		if fmt.Sprint(entries) != fmt.Sprint(c.entries) {
+			t.Fatalf("%q: expected entries %v, got %v", c.path, c.entries, entries)
+		}
+		if fmt.Sprint(list.CommonPrefixes) != fmt.Sprint(c.prefixes) {
+			t.Fatalf("%q: expected common prefixes %v, got %v", c.path, c.prefixes, list.CommonPrefixes)
+		}
+	}
+
+	res, data := get("dir/", "text/html")
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/html" {
+		t.Fatalf("expected content type text/html, got %q", contentType)
+	}
+	for _, link := range []string{`<a href="b.txt">`, `<a href="sub/">`} {
+		if !bytes.Contains(data, []byte(link)) {
+			t.Fatalf("expected HTML list to contain %s, got %s", link, data)
+		}
+	}
+
+	if res, data := get("dir/b.txt", ""); res.StatusCode != http.StatusOK || string(data) != "b" {
+		t.Fatalf("expected file content %q, got %d %q", "b", res.StatusCode, data)
+	}
+	for _, path := range []string{"missing", "missing/", "dir/missing/"} {
+		if res, _ := get(path, ""); res.StatusCode != http.StatusNotFound {
+			t.Fatalf("%q: expected status %d, got %d", path, http.StatusNotFound, res.StatusCode)
+		}
+	}
+}
+
+// TestBzzDirectUploadMetadata tests that files uploaded without a size or
+// content type get them counted and detected, and are served with them
+func TestBzzDirectUploadMetadata(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	upload := func(path string, body io.Reader) []byte {
+		req, err := http.NewRequest("POST", srv.URL+"/bzz:/"+path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		key, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+		}
+		return key
+	}
+	key := upload("", strings.NewReader("root"))
+
+	// the upload is streamed as the size of the reader is unknown
+	data := strings.Repeat("<h1>swarm</h1>", 1000)
+	key = upload(fmt.Sprintf("%s/dir/page", key), ioutil.NopCloser(strings.NewReader(data)))
+
+	res, err := http.Get(fmt.Sprintf("%s/bzz-list:/%s/dir/", srv.URL, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list api.ManifestList
+	err = json.NewDecoder(res.Body).Decode(&list)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(list.Entries))
+	}
+	entry := list.Entries[0]
+	if entry.Size != int64(len(data)) {
+		t.Fatalf("expected size %d, got %d", len(data), entry.Size)
+	}
+	if entry.ContentType != "text/html; charset=utf-8" {
+		t.Fatalf("expected content type %q, got %q", "text/html; charset=utf-8", entry.ContentType)
+	}
+	if entry.ModTime.IsZero() {
+		t.Fatal("expected modification time to be set")
+	}
+
+	res, err = http.Get(fmt.Sprintf("%s/bzz:/%s/dir/page", srv.URL, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Fatal("downloaded data differs from uploaded data")
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != entry.ContentType {
+		t.Fatalf("expected Content-Type %q, got %q", entry.ContentType, contentType)
+	}
+	if contentLength := res.Header.Get("Content-Length"); contentLength != strconv.Itoa(len(data)) {
+		t.Fatalf("expected Content-Length %d, got %s", len(data), contentLength)
+	}
+}
+
+// TestBzzAccess tests that content behind access manifests is only served
+// with the password in basic auth, and that pk access manifests are not
+// resolved by the gateway
+func TestBzzAccess(t *testing.T) {
+	grantee, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/bzz:/", "text/plain", strings.NewReader("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := storage.Key(common.Hex2Bytes(string(body)))
+
+	store := func(m *api.Manifest) string {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, wait, err := srv.Dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		return key.Hex()
+	}
+	passManifest, err := api.NewPasswordAccess(ref, "password", &api.KdfParams{N: 16, P: 1, R: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passKey := store(passManifest)
+	pkManifest, err := api.NewPKAccess(ref, publisher, &grantee.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkKey := store(pkManifest)
+
+	get := func(url, password string) (int, string) {
+		req, err := http.NewRequest("GET", srv.URL+url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	for i, c := range []struct {
+		url      string
+		password string
+		code     int
+	}{
+		{"/bzz:/" + passKey + "/", "", http.StatusUnauthorized},
+		{"/bzz:/" + passKey + "/", "wrong", http.StatusUnauthorized},
+		{"/bzz:/" + passKey + "/", "password", http.StatusOK},
+		{"/bzz-list:/" + passKey + "/", "", http.StatusUnauthorized},
+		{"/bzz-list:/" + passKey + "/", "password", http.StatusOK},
+		{"/bzz:/" + pkKey + "/", "", http.StatusUnauthorized},
+	} {
+		code, body := get(c.url, c.password)
+		if code != c.code {
+			t.Fatalf("request %d: expected status %d, got %d", i, c.code, code)
+		}
+		if code == http.StatusOK && strings.HasPrefix(c.url, "/bzz:/") && body != "secret" {
+			t.Fatalf("request %d: expected body %q, got %q", i, "secret", body)
+		}
+	}
+}
+
+// TestBzzConditionalGet tests that content is served with a strong ETag and
+// long-lived Cache-Control for immutable URLs and that conditional requests
+// for unchanged content are responded with 304 Not Modified
+func TestBzzConditionalGet(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	client := swarm.NewClient(srv.URL)
+	hash, err := client.Upload(&swarm.File{
+		ReadCloser: ioutil.NopCloser(strings.NewReader("content")),
+		ManifestEntry: api.ManifestEntry{
+			Path:        "file.txt",
+			ContentType: "text/plain",
+			Size:        7,
+		},
+	}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(url string, header map[string]string) *http.Response {
+		req, err := http.NewRequest("GET", srv.URL+url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	for _, url := range []string{
+		"/bzz:/" + hash + "/file.txt",
+		"/bzz-raw:/" + hash + "/",
+		"/bzz-list:/" + hash + "/",
+	} {
+		res := get(url, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", url, http.StatusOK, res.StatusCode)
+		}
+		if cc := res.Header.Get("Cache-Control"); cc != immutableCacheControl {
+			t.Fatalf("%s: expected Cache-Control %q, got %q", url, immutableCacheControl, cc)
+		}
+		if url == "/bzz-list:/"+hash+"/" {
+			continue
+		}
+		etag := res.Header.Get("ETag")
+		if etag == "" || strings.HasPrefix(etag, "W/") {
+			t.Fatalf("%s: expected strong ETag, got %q", url, etag)
+		}
+
+		for _, c := range []struct {
+			header map[string]string
+			code   int
+		}{
+			{map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+			{map[string]string{"If-None-Match": `"abcd", ` + etag}, http.StatusNotModified},
+			{map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+			{map[string]string{"If-None-Match": `"abcd"`}, http.StatusOK},
+			{map[string]string{"If-Modified-Since": time.Now().Add(-time.Hour).Format(http.TimeFormat)}, http.StatusNotModified},
+			// If-None-Match takes precedence over If-Modified-Since
+			{map[string]string{"If-None-Match": `"abcd"`, "If-Modified-Since": time.Now().Format(http.TimeFormat)}, http.StatusOK},
+		} {
+			res := get(url, c.header)
+			if res.StatusCode != c.code {
+				t.Fatalf("%s %v: expected status %d, got %d", url, c.header, c.code, res.StatusCode)
+			}
+			if res.StatusCode == http.StatusNotModified && res.Header.Get("ETag") != etag {
+				t.Fatalf("%s %v: expected ETag %s, got %q", url, c.header, etag, res.Header.Get("ETag"))
+			}
+		}
+	}
+}
+
+// TestBzzSecurityHeaders tests that the configured frame options and content
+// security policy are sent with the responses
+func TestBzzSecurityHeaders(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		server := NewServer(a)
+		server.frameOptions = "DENY"
+		server.csp = "default-src 'self'"
+		return server
+	})
+	defer srv.Close()
+
+	for _, url := range []string{"/", "/bzz:/nonexistent.eth/"} {
+		res, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if fo := res.Header.Get("X-Frame-Options"); fo != "DENY" {
+			t.Fatalf("%s: expected X-Frame-Options DENY, got %q", url, fo)
+		}
+		if csp := res.Header.Get("Content-Security-Policy"); csp != "default-src 'self'" {
+			t.Fatalf("%s: expected Content-Security-Policy %q, got %q", url, "default-src 'self'", csp)
+		}
+	}
+
+	srv2 := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv2.Close()
+	res, err := http.Get(srv2.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get("X-Frame-Options") != "" || res.Header.Get("Content-Security-Policy") != "" {
+		t.Fatal("expected no security headers by default")
+	}
+}
+
+// TestBzzHas tests that bzz-has:/ tells which of the posted chunk keys are
+// stored and rejects malformed requests
+func TestBzzHas(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	key, wait, err := srv.Dpa.Store(strings.NewReader("data"), 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	for _, c := range []struct {
+		body string
+		code int
+		has  string
+	}{
+		{`["` + key.Hex() + `", "` + strings.Repeat("00", 32) + `"]`, http.StatusOK, "[true,false]"},
+		{`["0x` + key.Hex() + `"]`, http.StatusOK, "[true]"},
+		{`[]`, http.StatusOK, "[]"},
+		{`["zz"]`, http.StatusBadRequest, ""},
+		{`{}`, http.StatusBadRequest, ""},
+		// the body is not read beyond the size limit
+		{`["` + strings.Repeat("00", maxHasBodySize/2) + `"]`, http.StatusBadRequest, ""},
+	} {
+		res, err := http.Post(srv.URL+"/bzz-has:/", "application/json", strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != c.code {
+			t.Fatalf("%s: expected status %d, got %d", c.body, c.code, res.StatusCode)
+		}
+		if c.code == http.StatusOK && strings.TrimSpace(string(body)) != c.has {
+			t.Fatalf("%s: expected %s, got %s", c.body, c.has, body)
+		}
+	}
+
+	res, err := http.Get(srv.URL + "/bzz-has:/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d for GET, got %d", http.StatusMethodNotAllowed, res.StatusCode)
+	}
+}
+
+// TestBzzSchemeForms tests that bzz-raw and bzz-list serve the raw manifest
+// and its listing, and bzz the resolved content, for both the bzz:/<addr> and
+// the bzz://<addr> forms of the URLs
+func TestBzzSchemeForms(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/bzz:/", "text/plain", strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := string(body)
+
+	get := func(url string) (*http.Response, []byte) {
+		res, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", url, http.StatusOK, res.StatusCode)
+		}
+		return res, body
+	}
+
+	for _, sep := range []string{":/", "://"} {
+		// the raw manifest without resolving the path
+		res, body := get("/bzz-raw" + sep + hash)
+		var manifest api.Manifest
+		if err := json.Unmarshal(body, &manifest); err != nil {
+			t.Fatalf("bzz-raw%s: expected the manifest, got %q: %v", sep, body, err)
+		}
+		if len(manifest.Entries) != 1 || manifest.Entries[0].ContentType != "text/plain" {
+			t.Fatalf("bzz-raw%s: unexpected manifest entries %v", sep, manifest.Entries)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/octet-stream" {
+			t.Fatalf("bzz-raw%s: expected Content-Type application/octet-stream, got %q", sep, ct)
+		}
+
+		// the listing of the manifest
+		res, body = get("/bzz-list" + sep + hash + "/")
+		var list api.ManifestList
+		if err := json.Unmarshal(body, &list); err != nil {
+			t.Fatalf("bzz-list%s: expected the list, got %q: %v", sep, body, err)
+		}
+		if len(list.Entries) != 1 || list.Entries[0].Hash != manifest.Entries[0].Hash {
+			t.Fatalf("bzz-list%s: unexpected list entries %v", sep, list.Entries)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("bzz-list%s: expected Content-Type application/json, got %q", sep, ct)
+		}
+
+		// the resolved content
+		if _, body = get("/bzz" + sep + hash + "/"); string(body) != "content" {
+			t.Fatalf("bzz%s: expected content %q, got %q", sep, "content", body)
+		}
+	}
+}
+
+// TestBzzLimits tests that the HTTP API rejects the requests over the rate
+// limit of a client, uploads over the maximum size and requests over the
+// maximum number of concurrent requests
+func TestBzzLimits(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		server := NewServer(a)
+		server.limiter = newRateLimiter(0.001, 2)
+		return server
+	})
+	defer srv.Close()
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		res, err := http.Get(srv.URL + "/robots.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Fatalf("request %d: expected status %d, got %s", i, expected, res.Status)
+		}
+	}
+
+	srv2 := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		server := NewServer(a)
+		server.maxUploadSize = 10
+		server.requests = make(chan struct{}, 1)
+		return server
+	})
+	defer srv2.Close()
+
+	for data, expected := range map[string]int{
+		"small":                 http.StatusOK,
+		"larger than the limit": http.StatusRequestEntityTooLarge,
+	} {
+		res, err := http.Post(srv2.URL+"/bzz-raw:/", "text/plain", strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Fatalf("upload %q: expected status %d, got %s", data, expected, res.Status)
+		}
+	}
+
+	// keep a request in progress with a body which is not written yet
+	body, bodyW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		res, err := http.Post(srv2.URL+"/bzz-raw:/", "text/plain", body)
+		if err == nil {
+			res.Body.Close()
+		}
+		done <- err
+	}()
+	defer func() {
+		bodyW.Close()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := http.Get(srv2.URL + "/robots.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected status %d while a request is in progress, got %s", http.StatusServiceUnavailable, res.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestBzzImmutable tests that bzz-immutable:/ only serves content by hash
+// without following mutable resources, and that an immutable server serves
+// all requests that way
+func TestBzzImmutable(t *testing.T) {
+	for _, immutableServer := range []bool{false, true} {
+		srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+			server := NewServer(a)
+			server.immutable = immutableServer
+			return server
+		})
+		defer srv.Close()
+
+		post := func(url string, data string) string {
+			res, err := http.Post(srv.URL+url, "text/plain", strings.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("POST %s: unexpected status %s", url, res.Status)
+			}
+			return string(body)
+		}
+		hash := post("/bzz:/", "immutable content")
+		var rsrcKey storage.Key
+		if err := json.Unmarshal([]byte(post("/bzz-resource:/foo.eth/raw/13", "mutable content")), &rsrcKey); err != nil {
+			t.Fatal(err)
+		}
+
+		// names and mutable content are only served by a mutable server
+		mutableStatus := func(status int) int {
+			if immutableServer {
+				return http.StatusForbidden
+			}
+			return status
+		}
+		for url, expected := range map[string]int{
+			"/bzz-immutable:/" + hash:          http.StatusOK,
+			"/bzz:/" + hash:                    http.StatusOK,
+			"/bzz-immutable:/" + rsrcKey.Hex(): http.StatusBadRequest,
+			"/bzz-immutable:/foo.eth":          mutableStatus(http.StatusNotFound),
+			"/bzz:/foo.eth":                    mutableStatus(http.StatusNotFound),
+			"/bzz-resource:/" + rsrcKey.Hex():  mutableStatus(http.StatusOK),
+		} {
+			res, err := http.Get(srv.URL + url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != expected {
+				t.Fatalf("immutable server %v, GET %s: expected status %d, got %s", immutableServer, url, expected, res.Status)
+			}
+		}
+
+		// the hash of a resource manifest is not resolved by an immutable server
+		expected := http.StatusOK
+		if immutableServer {
+			expected = http.StatusBadRequest
+		}
+		url := "/bzz:/" + rsrcKey.Hex()
+		res, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Fatalf("immutable server %v, GET %s: expected status %d, got %s", immutableServer, url, expected, res.Status)
+		}
+	}
+}
+
+// TestBzzDocuments tests that the index document of a manifest is served for
+// its directories and the error document for the paths not found in it
+func TestBzzDocuments(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	upload := func(m *api.Manifest) string {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(srv.URL+"/bzz-raw:/", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		hash, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(hash)
+	}
+	manifest := &api.Manifest{IndexDocument: "index.html", ErrorDocument: "404.html"}
+	for _, path := range []string{"index.html", "docs/index.html", "docs/a.txt", "404.html", "empty/a.txt"} {
+		res, err := http.Post(srv.URL+"/bzz-raw:/", "text/plain", strings.NewReader("content of "+path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Entries = append(manifest.Entries, api.ManifestEntry{Hash: string(hash), Path: path, ContentType: "text/html"})
+	}
+	hash := upload(manifest)
+
+	get := func(url string) (int, string) {
+		res, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+	for path, expected := range map[string]struct {
+		status  int
+		content string
+	}{
+		"":           {http.StatusOK, "content of index.html"},
+		"docs/":      {http.StatusOK, "content of docs/index.html"},
+		"docs":       {http.StatusOK, "content of docs/index.html"},
+		"docs/a.txt": {http.StatusOK, "content of docs/a.txt"},
+		"missing":    {http.StatusNotFound, "content of 404.html"},
+		"docs/b.txt": {http.StatusNotFound, "content of 404.html"},
+	} {
+		status, content := get("/bzz:/" + hash + "/" + path)
+		if status != expected.status || content != expected.content {
+			t.Fatalf("%s: expected %d %q, got %d %q", path, expected.status, expected.content, status, content)
+		}
+	}
+	// directories without the index document are listed
+	if status, content := get("/bzz:/" + hash + "/empty/"); status != http.StatusOK || !strings.Contains(content, "a.txt") {
+		t.Fatalf("expected directory listing, got %d %q", status, content)
+	}
+
+	// manifests without documents are not served them
+	manifest.IndexDocument, manifest.ErrorDocument = "", ""
+	hash = upload(manifest)
+	if status, content := get("/bzz:/" + hash + "/docs/"); status != http.StatusOK || content == "content of docs/index.html" {
+		t.Fatalf("expected directory listing, got %d %q", status, content)
+	}
+	if status, content := get("/bzz:/" + hash + "/missing"); status != http.StatusNotFound || content == "content of 404.html" {
+		t.Fatalf("expected not found, got %d %q", status, content)
+	}
+}
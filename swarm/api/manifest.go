@@ -17,12 +17,14 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
@@ -31,6 +33,11 @@ import (
 	"github.com/ethereum/go-ethereum/swarm/storage"
 )
 
+var (
+	ErrEntryExists   = errors.New("manifest entry already exists")
+	ErrEntryNotFound = errors.New("manifest entry not found")
+)
+
 const (
 	ManifestType        = "application/bzz-manifest+json"
 	ResourceContentType = "application/bzz-resource"
@@ -41,17 +48,69 @@ const (
 // Manifest represents a swarm manifest
 type Manifest struct {
 	Entries []ManifestEntry `json:"entries,omitempty"`
+
+	// IndexDocument is the name of the document served for the directories
+	// of the manifest, eg. index.html, and ErrorDocument the path of the one
+	// served for the paths not found in it
+	IndexDocument string `json:"indexDocument,omitempty"`
+	ErrorDocument string `json:"errorDocument,omitempty"`
 }
 
 // ManifestEntry represents an entry in a swarm manifest
 type ManifestEntry struct {
-	Hash        string    `json:"hash,omitempty"`
-	Path        string    `json:"path,omitempty"`
-	ContentType string    `json:"contentType,omitempty"`
-	Mode        int64     `json:"mode,omitempty"`
-	Size        int64     `json:"size,omitempty"`
-	ModTime     time.Time `json:"mod_time,omitempty"`
-	Status      int       `json:"status,omitempty"`
+	Hash        string       `json:"hash,omitempty"`
+	Path        string       `json:"path,omitempty"`
+	ContentType string       `json:"contentType,omitempty"`
+	Mode        int64        `json:"mode,omitempty"`
+	Size        int64        `json:"size,omitempty"`
+	ModTime     time.Time    `json:"mod_time,omitempty"`
+	Status      int          `json:"status,omitempty"`
+	Access      *AccessEntry `json:"access,omitempty"` // set on the root entry of access manifests
+}
+
+// contentTypes are the content types of the common file extensions, they are
+// built in instead of looked up in the mime tables of the host so that the
+// same files are uploaded with the same manifest on every host
+var contentTypes = map[string]string{
+	".css":   "text/css",
+	".csv":   "text/csv",
+	".gif":   "image/gif",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "application/javascript",
+	".json":  "application/json",
+	".md":    "text/markdown; charset=utf-8",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".otf":   "font/otf",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".tar":   "application/x-tar",
+	".ttf":   "font/ttf",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".webm":  "video/webm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "text/xml; charset=utf-8",
+	".zip":   "application/zip",
+}
+
+// DetectContentType returns the content type of the file with the name from
+// its extension, or if that is unknown, by sniffing the start of its content
+// which is why the reader returned must be read instead of r
+func DetectContentType(name string, r io.Reader) (string, io.Reader) {
+	if contentType, ok := contentTypes[strings.ToLower(path.Ext(name))]; ok {
+		return contentType, r
+	}
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	return http.DetectContentType(head), br
 }
 
 // ManifestList represents the result of listing files in a manifest
@@ -105,16 +164,45 @@ func (a *Api) NewManifestWriter(key storage.Key, quitC chan bool) (*ManifestWrit
 }
 
 // AddEntry stores the given data and adds the resulting key to the manifest
+//
+// If the size of the entry is negative, the data is read until EOF and the
+// size of the entry is set to the number of bytes read.
 func (m *ManifestWriter) AddEntry(data io.Reader, e *ManifestEntry) (storage.Key, error) {
-
+	if e.Size < 0 {
+		counter := &sizeCounter{Reader: data}
+		key, _, err := m.api.StoreStream(counter, m.trie.encrypted)
+		if err != nil {
+			return nil, err
+		}
+		e.Size = counter.size
+		m.addStoredEntry(key, e)
+		return key, nil
+	}
 	key, _, err := m.api.Store(data, e.Size, m.trie.encrypted)
 	if err != nil {
 		return nil, err
 	}
+	m.addStoredEntry(key, e)
+	return key, nil
+}
+
+// sizeCounter counts the bytes read from a reader
+type sizeCounter struct {
+	io.Reader
+	size int64
+}
+
+func (c *sizeCounter) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.size += int64(n)
+	return n, err
+}
+
+// addStoredEntry adds an entry for content already stored under the key
+func (m *ManifestWriter) addStoredEntry(key storage.Key, e *ManifestEntry) {
 	entry := newManifestTrieEntry(e, nil)
 	entry.Hash = key.Hex()
 	m.trie.addEntry(entry, m.quitC)
-	return key, nil
 }
 
 // RemoveEntry removes the given path from the manifest
@@ -123,11 +211,112 @@ func (m *ManifestWriter) RemoveEntry(path string) error {
 	return nil
 }
 
+// SetDocuments sets the index and error documents of the manifest, see
+// Manifest, empty ones are removed
+func (m *ManifestWriter) SetDocuments(index, errorDocument string) {
+	m.trie.indexDocument = index
+	m.trie.errorDocument = errorDocument
+	m.trie.ref = nil
+}
+
 // Store stores the manifest, returning the resulting storage key
 func (m *ManifestWriter) Store() (storage.Key, error) {
 	return m.trie.ref, m.trie.recalcAndStore()
 }
 
+// AddManifestEntry adds the entry of content already stored in swarm under
+// the hash of the entry to the manifest and returns the key of the new
+// manifest, it fails with ErrEntryExists if the manifest has an entry
+// with the path
+//
+// Only the submanifests on the path of the entry are stored again, all
+// other entries are reused as they are.
+func (a *Api) AddManifestEntry(key storage.Key, entry *ManifestEntry) (storage.Key, error) {
+	return a.editManifestEntry(key, entry.Path, false, func(mw *ManifestWriter) error {
+		return mw.addEntryRef(entry)
+	})
+}
+
+// UpdateManifestEntry replaces the entry of the manifest with the path of the
+// entry and returns the key of the new manifest, it fails with
+// ErrEntryNotFound if the manifest has no entry with the path
+func (a *Api) UpdateManifestEntry(key storage.Key, entry *ManifestEntry) (storage.Key, error) {
+	return a.editManifestEntry(key, entry.Path, true, func(mw *ManifestWriter) error {
+		return mw.addEntryRef(entry)
+	})
+}
+
+// RemoveManifestEntry removes the entry with the path from the manifest and
+// returns the key of the new manifest, it fails with ErrEntryNotFound if the
+// manifest has no entry with the path
+func (a *Api) RemoveManifestEntry(key storage.Key, path string) (storage.Key, error) {
+	return a.editManifestEntry(key, path, true, func(mw *ManifestWriter) error {
+		return mw.RemoveEntry(path)
+	})
+}
+
+// GetManifestEntry returns the entry of the manifest with the path, looking it
+// up in the submanifests, it fails with ErrEntryNotFound if there is none
+func (a *Api) GetManifestEntry(key storage.Key, path string) (*ManifestEntry, error) {
+	trie, err := loadManifest(a.dpa, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := trie.lookup(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrEntryNotFound
+	}
+	e := entry.ManifestEntry
+	e.Path = path
+	return &e, nil
+}
+
+// ManifestDocuments returns the index and error documents the manifest with
+// the key declares, they are empty if it declares none
+func (a *Api) ManifestDocuments(key storage.Key) (index, errorDocument string, err error) {
+	trie, err := loadManifest(a.dpa, key, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return trie.indexDocument, trie.errorDocument, nil
+}
+
+// editManifestEntry edits and stores the manifest if it has an entry with
+// the path and it must exist, or if it has none and it must not
+func (a *Api) editManifestEntry(key storage.Key, path string, mustExist bool, edit func(mw *ManifestWriter) error) (storage.Key, error) {
+	mw, err := a.NewManifestWriter(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := mw.trie.lookup(path, mw.quitC)
+	if err != nil {
+		return nil, err
+	}
+	exists := entry != nil
+	if mustExist && !exists {
+		return nil, ErrEntryNotFound
+	}
+	if !mustExist && exists {
+		return nil, ErrEntryExists
+	}
+	if err := edit(mw); err != nil {
+		return nil, err
+	}
+	return mw.Store()
+}
+
+// addEntryRef adds the entry referring to the content stored under its hash
+func (m *ManifestWriter) addEntryRef(e *ManifestEntry) error {
+	if !hashMatcher.MatchString(e.Hash) {
+		return fmt.Errorf("invalid manifest entry hash %q", e.Hash)
+	}
+	m.addStoredEntry(storage.Key(common.Hex2Bytes(e.Hash)), e)
+	return nil
+}
+
 // ManifestWalker is used to recursively walk the entries in the manifest and
 // all of its submanifests
 type ManifestWalker struct {
@@ -189,6 +378,10 @@ type manifestTrie struct {
 	entries   [257]*manifestTrieEntry // indexed by first character of basePath, entries[256] is the empty basePath entry
 	ref       storage.Key             // if ref != nil, it is stored
 	encrypted bool
+
+	// documents declared by the manifest, only used in the root trie
+	indexDocument string
+	errorDocument string
 }
 
 func newManifestTrieEntry(entry *ManifestEntry, subtrie *manifestTrie) *manifestTrieEntry {
@@ -239,7 +432,9 @@ func readManifest(manifestReader storage.LazySectionReader, hash storage.Key, dp
 
 	log.Debug("manifest retrieved", "key", hash)
 	var man struct {
-		Entries []*manifestTrieEntry `json:"entries"`
+		Entries       []*manifestTrieEntry `json:"entries"`
+		IndexDocument string               `json:"indexDocument"`
+		ErrorDocument string               `json:"errorDocument"`
 	}
 	err = json.Unmarshal(manifestData, &man)
 	if err != nil {
@@ -251,8 +446,10 @@ func readManifest(manifestReader storage.LazySectionReader, hash storage.Key, dp
 	log.Trace("manifest entries", "key", hash, "len", len(man.Entries))
 
 	trie = &manifestTrie{
-		dpa:       dpa,
-		encrypted: isEncrypted,
+		dpa:           dpa,
+		encrypted:     isEncrypted,
+		indexDocument: man.IndexDocument,
+		errorDocument: man.ErrorDocument,
 	}
 	for _, entry := range man.Entries {
 		trie.addEntry(entry, quitC)
@@ -307,6 +504,28 @@ func (self *manifestTrie) addEntry(entry *manifestTrieEntry, quitC chan bool) {
 	}, subtrie)
 }
 
+// lookup returns the entry with exactly the path, nil if there is none
+func (self *manifestTrie) lookup(path string, quitC chan bool) (*manifestTrieEntry, error) {
+	if len(path) == 0 {
+		return self.entries[256], nil
+	}
+	entry := self.entries[path[0]]
+	if entry == nil {
+		return nil, nil
+	}
+	if entry.Path == path && entry.ContentType != ManifestType {
+		return entry, nil
+	}
+	epl := len(entry.Path)
+	if entry.ContentType == ManifestType && len(path) >= epl && path[:epl] == entry.Path {
+		if err := self.loadSubTrie(entry, quitC); err != nil {
+			return nil, err
+		}
+		return entry.subtrie.lookup(path[epl:], quitC)
+	}
+	return nil, nil
+}
+
 func (self *manifestTrie) getCountLast() (cnt int, entry *manifestTrieEntry) {
 	for _, e := range self.entries {
 		if e != nil {
@@ -361,7 +580,10 @@ func (self *manifestTrie) recalcAndStore() error {
 	var buffer bytes.Buffer
 	buffer.WriteString(`{"entries":[`)
 
-	list := &Manifest{}
+	list := &Manifest{
+		IndexDocument: self.indexDocument,
+		ErrorDocument: self.errorDocument,
+	}
 	for _, entry := range self.entries {
 		if entry != nil {
 			if entry.Hash == "" { // TODO: paralellize
@@ -531,3 +753,69 @@ func (self *manifestTrie) getEntry(spath string) (entry *manifestTrieEntry, full
 	entry, pos = self.findPrefixOf(path, quitC)
 	return entry, path[:pos]
 }
+
+// implements a service for editing the entries of manifests over rpc without
+// uploading their content again
+type Manifests struct {
+	api *Api
+}
+
+func NewManifests(api *Api) *Manifests {
+	return &Manifests{api}
+}
+
+// AddEntry adds the entry of content already stored in swarm to the manifest
+// and returns the hash of the new manifest
+func (self *Manifests) AddEntry(manifest string, entry ManifestEntry) (string, error) {
+	return self.edit(manifest, func(key storage.Key) (storage.Key, error) {
+		return self.api.AddManifestEntry(key, &entry)
+	})
+}
+
+// UpdateEntry replaces the entry of the manifest with the path of the entry
+// and returns the hash of the new manifest
+func (self *Manifests) UpdateEntry(manifest string, entry ManifestEntry) (string, error) {
+	return self.edit(manifest, func(key storage.Key) (storage.Key, error) {
+		return self.api.UpdateManifestEntry(key, &entry)
+	})
+}
+
+// GetEntry returns the entry of the manifest with the path, its hash is the
+// hash of the content at the path
+func (self *Manifests) GetEntry(manifest, path string) (*ManifestEntry, error) {
+	key, err := self.resolve(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return self.api.GetManifestEntry(key, path)
+}
+
+// RemoveEntry removes the entry with the path from the manifest and returns
+// the hash of the new manifest
+func (self *Manifests) RemoveEntry(manifest, path string) (string, error) {
+	return self.edit(manifest, func(key storage.Key) (storage.Key, error) {
+		return self.api.RemoveManifestEntry(key, path)
+	})
+}
+
+// resolve resolves the manifest, which is either a hash or a name
+func (self *Manifests) resolve(manifest string) (storage.Key, error) {
+	uri, err := Parse("bzz:/" + manifest)
+	if err != nil {
+		return nil, err
+	}
+	return self.api.Resolve(uri)
+}
+
+// edit resolves the manifest and edits it
+func (self *Manifests) edit(manifest string, edit func(storage.Key) (storage.Key, error)) (string, error) {
+	key, err := self.resolve(manifest)
+	if err != nil {
+		return "", err
+	}
+	key, err = edit(key)
+	if err != nil {
+		return "", err
+	}
+	return key.Hex(), nil
+}
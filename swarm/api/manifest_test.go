@@ -21,10 +21,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/swarm/storage"
 )
 
@@ -173,3 +175,171 @@ func TestReadManifestOverSizeLimit(t *testing.T) {
 		t.Fatalf("got error mesage %q, expected %q", got, want)
 	}
 }
+
+// TestEditManifestEntry tests adding, updating and removing single entries
+// of a manifest referring to content already stored
+func TestEditManifestEntry(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		store := func(content string) string {
+			key, wait, err := api.Store(strings.NewReader(content), int64(len(content)), toEncrypt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wait()
+			return key.Hex()
+		}
+		hashA, hashB := store("content a"), store("content b")
+
+		key, err := api.NewManifest(toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{"dir/a.txt", "dir/b.txt", "c.txt"} {
+			key, err = api.AddManifestEntry(key, &ManifestEntry{Hash: hashA, Path: path, ContentType: "text/plain"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := api.AddManifestEntry(key, &ManifestEntry{Hash: hashB, Path: "c.txt"}); err != ErrEntryExists {
+			t.Fatalf("expected %v, got %v", ErrEntryExists, err)
+		}
+		if _, err := api.AddManifestEntry(key, &ManifestEntry{Hash: "1234", Path: "d.txt"}); err == nil {
+			t.Fatal("expected error for invalid hash")
+		}
+
+		// the submanifest of dir is reused when c.txt is updated
+		dirHash := func(key storage.Key) string {
+			trie, err := loadManifest(api.dpa, key, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return trie.entries['d'].Hash
+		}
+		oldDirHash := dirHash(key)
+		key, err = api.UpdateManifestEntry(key, &ManifestEntry{Hash: hashB, Path: "c.txt", ContentType: "text/csv"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash := dirHash(key); hash != oldDirHash {
+			t.Fatalf("expected submanifest %s reused, got %s", oldDirHash, hash)
+		}
+		checkResponse(t, testGet(t, api, key.Hex(), "c.txt"), expResponse("content b", "text/csv", 0))
+		entry, err := api.GetManifestEntry(key, "c.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Hash != hashB || entry.Path != "c.txt" || entry.ContentType != "text/csv" {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+		if entry, err = api.GetManifestEntry(key, "dir/a.txt"); err != nil || entry.Hash != hashA {
+			t.Fatalf("expected entry of dir/a.txt with hash %s, got %+v, %v", hashA, entry, err)
+		}
+		if _, err := api.GetManifestEntry(key, "dir/x.txt"); err != ErrEntryNotFound {
+			t.Fatalf("expected %v, got %v", ErrEntryNotFound, err)
+		}
+		if _, err := api.UpdateManifestEntry(key, &ManifestEntry{Hash: hashB, Path: "dir/x.txt"}); err != ErrEntryNotFound {
+			t.Fatalf("expected %v, got %v", ErrEntryNotFound, err)
+		}
+
+		key, err = api.RemoveManifestEntry(key, "dir/b.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.RemoveManifestEntry(key, "dir/b.txt"); err != ErrEntryNotFound {
+			t.Fatalf("expected %v, got %v", ErrEntryNotFound, err)
+		}
+		if _, _, _, _, err := api.Get(key, "dir/b.txt"); err == nil {
+			t.Fatal("expected removed entry not found")
+		}
+		checkResponse(t, testGet(t, api, key.Hex(), "dir/a.txt"), expResponse("content a", "text/plain", 0))
+
+		// a submanifest which cannot be loaded is an error, not a missing entry
+		missing := strings.Repeat("00", 32)
+		key = storage.Key(common.Hex2Bytes(store(fmt.Sprintf(`{"entries":[{"hash":"%s","path":"sub/","contentType":"%s"}]}`, missing, ManifestType))))
+		if _, err := api.GetManifestEntry(key, "sub/a.txt"); err == nil || err == ErrEntryNotFound {
+			t.Fatalf("expected error loading the submanifest, got %v", err)
+		}
+		if _, err := api.AddManifestEntry(key, &ManifestEntry{Hash: hashA, Path: "sub/a.txt"}); err == nil || err == ErrEntryExists {
+			t.Fatalf("expected error loading the submanifest, got %v", err)
+		}
+	})
+}
+
+func TestDetectContentType(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{"index.html", "hello", "text/html; charset=utf-8"},
+		{"style.css", "", "text/css"},
+		{"IMAGE.PNG", "", "image/png"},
+		{"dir/noext", "<!DOCTYPE html><html></html>", "text/html; charset=utf-8"},
+		{"data.unknown", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"empty", "", "text/plain; charset=utf-8"},
+		{"large", strings.Repeat("swarm ", 1000), "text/plain; charset=utf-8"},
+	} {
+		contentType, reader := DetectContentType(c.name, strings.NewReader(c.data))
+		if contentType != c.expected {
+			t.Errorf("%s: expected content type %q, got %q", c.name, c.expected, contentType)
+		}
+		// the content sniffed is not lost
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.data {
+			t.Errorf("%s: expected content %q, got %q", c.name, c.data, data)
+		}
+	}
+}
+
+// TestManifestDocuments tests that the index and error documents of a manifest
+// are kept when its entries are edited and removed when set to empty
+func TestManifestDocuments(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		key, err := api.NewManifest(toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mw, err := api.NewManifestWriter(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mw.SetDocuments("index.html", "404.html")
+		if key, err = mw.Store(); err != nil {
+			t.Fatal(err)
+		}
+
+		checkDocuments := func(expectedIndex, expectedError string) {
+			index, errorDocument, err := api.ManifestDocuments(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index != expectedIndex || errorDocument != expectedError {
+				t.Fatalf("expected documents %q and %q, got %q and %q", expectedIndex, expectedError, index, errorDocument)
+			}
+		}
+		checkDocuments("index.html", "404.html")
+
+		for _, path := range []string{"index.html", "dir/index.html", "dir/a.txt"} {
+			key, err = api.AddManifestEntry(key, &ManifestEntry{Hash: storage.ZeroKey.Hex(), Path: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if key, err = api.RemoveManifestEntry(key, "dir/a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		checkDocuments("index.html", "404.html")
+
+		if mw, err = api.NewManifestWriter(key, nil); err != nil {
+			t.Fatal(err)
+		}
+		mw.SetDocuments("", "")
+		if key, err = mw.Store(); err != nil {
+			t.Fatal(err)
+		}
+		checkDocuments("", "")
+	})
+}
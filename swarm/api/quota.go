@@ -0,0 +1,141 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/state"
+)
+
+var (
+	ErrUnknownAccount = errors.New("unknown upload account")
+	ErrQuotaExceeded  = errors.New("upload quota exceeded")
+)
+
+// UploadQuotas accounts the bytes uploaded through the API by each account and
+// rejects the uploads of accounts over their quota
+//
+// Accounts are identified by an API key, uploads without a key are accounted
+// to the anonymous account "". The usage is kept in the state store if one is
+// given, so that it is not reset when the node is restarted. The bytes reserved
+// by uploads in progress are only kept in memory, so that they are released if
+// the node stops before the uploads are settled.
+type UploadQuotas struct {
+	mu       sync.Mutex
+	quotas   map[string]uint64 // quota of each account in bytes, no limit if 0
+	used     map[string]uint64 // bytes uploaded by each account
+	reserved map[string]uint64 // bytes reserved by the uploads in progress of each account
+	store    state.Store       // persists used, may be nil
+}
+
+// quotaUsageKey is the key of the usage of the accounts in the state store
+const quotaUsageKey = "upload_quota_usage"
+
+// NewUploadQuotas returns the accounting of the uploads of the accounts with
+// the given quotas in bytes and of the anonymous account, a quota of 0 does not
+// limit the uploads of the account
+//
+// The usage of the accounts is loaded from and saved to the store, it is only
+// kept in memory if the store is nil.
+func NewUploadQuotas(anonymous uint64, quotas map[string]uint64, store state.Store) (*UploadQuotas, error) {
+	q := &UploadQuotas{
+		quotas:   map[string]uint64{"": anonymous},
+		used:     make(map[string]uint64),
+		reserved: make(map[string]uint64),
+		store:    store,
+	}
+	for account, quota := range quotas {
+		if account != "" {
+			q.quotas[account] = quota
+		}
+	}
+	if store != nil {
+		if err := store.Get(quotaUsageKey, &q.used); err != nil && err != state.ErrNotFound {
+			return nil, fmt.Errorf("cannot load upload quota usage: %v", err)
+		}
+	}
+	return q, nil
+}
+
+// Reserve checks that the account is known and can upload size more bytes,
+// and reserves them until Settle is called, so that concurrent uploads can
+// not exceed the quota together
+//
+// If size is 0, as it is not known in advance, the whole remaining quota of
+// the account is reserved. Reserve returns the bytes reserved, which the
+// upload must be limited to, or 0 if the account is not limited.
+func (q *UploadQuotas) Reserve(account string, size uint64) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	quota, ok := q.quotas[account]
+	if !ok {
+		return 0, ErrUnknownAccount
+	}
+	if quota == 0 {
+		return 0, nil
+	}
+	used := q.used[account] + q.reserved[account]
+	if used >= quota || size > quota-used {
+		return 0, ErrQuotaExceeded
+	}
+	if size == 0 {
+		size = quota - used
+	}
+	q.reserved[account] += size
+	return size, nil
+}
+
+// Settle accounts the bytes uploaded by the account after Reserve returned
+// the bytes reserved, releasing the reserved bytes not uploaded
+func (q *UploadQuotas) Settle(account string, reserved, uploaded uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.quotas[account]; !ok {
+		return
+	}
+	q.reserved[account] -= reserved
+	if q.reserved[account] == 0 {
+		delete(q.reserved, account)
+	}
+	q.used[account] += uploaded
+	q.save()
+}
+
+// save persists the usage of the accounts; must be called with the lock held
+func (q *UploadQuotas) save() {
+	if q.store == nil {
+		return
+	}
+	if err := q.store.Put(quotaUsageKey, q.used); err != nil {
+		log.Error(fmt.Sprintf("cannot save upload quota usage: %v", err))
+	}
+}
+
+// Usage returns the bytes uploaded by the account and its quota, the bytes
+// reserved by uploads in progress are not included
+func (q *UploadQuotas) Usage(account string) (used uint64, quota uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.used[account], q.quotas[account]
+}
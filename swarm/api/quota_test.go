@@ -0,0 +1,75 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/state"
+)
+
+// TestUploadQuotasReservation tests that the bytes reserved by uploads in
+// progress are not saved as used, so that a node stopped before the uploads
+// are settled does not lock the account out of its quota
+func TestUploadQuotasReservation(t *testing.T) {
+	store := state.NewInmemoryStore()
+	quotas, err := NewUploadQuotas(0, map[string]uint64{"key": 20}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole remaining quota is reserved for uploads of unknown size
+	reserved, err := quotas.Reserve("key", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved != 20 {
+		t.Fatalf("expected 20 bytes reserved, got %d", reserved)
+	}
+	if _, err := quotas.Reserve("key", 1); err != ErrQuotaExceeded {
+		t.Fatalf("expected %v while the quota is reserved, got %v", ErrQuotaExceeded, err)
+	}
+
+	// the node restarts before the upload is settled
+	quotas, err = NewUploadQuotas(0, map[string]uint64{"key": 20}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := quotas.Usage("key"); used != 0 {
+		t.Fatalf("expected no bytes used after reloading, got %d", used)
+	}
+	reserved, err = quotas.Reserve("key", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotas.Settle("key", reserved, 8)
+	if used, _ := quotas.Usage("key"); used != 8 {
+		t.Fatalf("expected 8 bytes used, got %d", used)
+	}
+
+	// only the settled usage is saved
+	quotas, err = NewUploadQuotas(0, map[string]uint64{"key": 20}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := quotas.Usage("key"); used != 8 {
+		t.Fatalf("expected 8 bytes used after reloading, got %d", used)
+	}
+	if _, err := quotas.Reserve("key", 12); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// ResourceUpdateResult is returned by the resourceUpdate rpc call
+type ResourceUpdateResult struct {
+	Key     storage.Key `json:"key"`
+	Period  uint32      `json:"period"`
+	Version uint32      `json:"version"`
+}
+
+// ResourceInfo is returned by the resourceInfo rpc call
+type ResourceInfo struct {
+	Name       string      `json:"name"`
+	NameHash   common.Hash `json:"nameHash"`
+	StartBlock uint64      `json:"startBlock"`
+	Frequency  uint64      `json:"frequency"`
+	Period     uint32      `json:"period"`
+	Version    uint32      `json:"version"`
+	LastBlock  uint64      `json:"lastBlock"` // first block of the period of the latest update
+	TimeBased  bool        `json:"timeBased"` // blocks are unix times and the frequency is in seconds
+}
+
+// implements a service for managing mutable resources over rpc
+//
+// updates are signed with the node's key
+type Resource struct {
+	api *Api
+}
+
+func NewResource(api *Api) *Resource {
+	return &Resource{api}
+}
+
+// ResourceCreate creates a new mutable resource with the given update frequency in blocks
+// and returns the key of its metadata chunk
+func (self *Resource) ResourceCreate(ctx context.Context, name string, frequency uint64) (storage.Key, error) {
+	return self.api.ResourceCreate(ctx, name, frequency)
+}
+
+// ResourceUpdate adds an update with the given data to a mutable resource
+func (self *Resource) ResourceUpdate(ctx context.Context, name string, data hexutil.Bytes) (*ResourceUpdateResult, error) {
+	key, period, version, err := self.api.ResourceUpdate(ctx, name, data)
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceUpdateResult{
+		Key:     key,
+		Period:  period,
+		Version: version,
+	}, nil
+}
+
+// ResourceLookup returns the data of a mutable resource update
+//
+// If block is 0 the latest update is returned. If version is 0 the
+// latest version of the period that block belongs to is returned.
+func (self *Resource) ResourceLookup(ctx context.Context, name string, block uint64, version uint32) (hexutil.Bytes, error) {
+	_, data, err := self.api.ResourceLookupByName(ctx, name, block, version, nil)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ResourceProof looks up the latest update of a mutable resource and returns a proof of it
+//
+// The proof can be verified without access to swarm with storage.VerifyResourceUpdate
+func (self *Resource) ResourceProof(ctx context.Context, name string) (*storage.ResourceProof, error) {
+	rsrc, err := self.api.resource.LookupLatestByName(ctx, name, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	return self.api.resource.Proof(ctx, rsrc.NameHash())
+}
+
+// ResourceList returns the mutable resources tracked by the node
+func (self *Resource) ResourceList(ctx context.Context) []*storage.ResourceListEntry {
+	return self.api.resource.List()
+}
+
+// ResourceInfo looks up the latest update of a mutable resource and returns its metadata
+func (self *Resource) ResourceInfo(ctx context.Context, name string) (*ResourceInfo, error) {
+	rsrc, err := self.api.resource.LookupLatestByName(ctx, name, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceInfo{
+		Name:       rsrc.Name(),
+		NameHash:   rsrc.NameHash(),
+		StartBlock: rsrc.StartBlock(),
+		Frequency:  rsrc.Frequency(),
+		Period:     rsrc.LastPeriod(),
+		Version:    rsrc.Version(),
+		LastBlock:  self.api.resource.PeriodToBlock(rsrc.NameHash().Hex(), rsrc.LastPeriod()-1),
+		TimeBased:  rsrc.TimeBased(),
+	}, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+func TestResourceRPC(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "bzz-resource-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	blocknumber := uint64(42)
+	rh, err := storage.NewTestResourceHandler(datadir, &storage.ResourceHandlerParams{
+		HeaderGetter: storage.BlockNumberFunc(func(context.Context, string) (uint64, error) {
+			return blocknumber, nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+	r := NewResource(NewApi(nil, nil, rh))
+
+	ctx := context.Background()
+	if _, err := r.ResourceCreate(ctx, "foo.eth", 10); err != nil {
+		t.Fatal(err)
+	}
+	res, err := r.ResourceUpdate(ctx, "foo.eth", []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Period != 1 || res.Version != 1 {
+		t.Fatalf("expected period 1 version 1, got period %d version %d", res.Period, res.Version)
+	}
+
+	blocknumber += 10
+	if _, err := r.ResourceUpdate(ctx, "foo.eth", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := r.ResourceLookup(ctx, "foo.eth", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("second")) {
+		t.Fatalf("expected latest update 'second', got '%s'", data)
+	}
+	data, err = r.ResourceLookup(ctx, "foo.eth", 42, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("first")) {
+		t.Fatalf("expected first update 'first', got '%s'", data)
+	}
+	info, err := r.ResourceInfo(ctx, "foo.eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.StartBlock != 42 || info.Frequency != 10 || info.Period != 2 || info.Version != 1 || info.LastBlock != 52 {
+		t.Fatalf("unexpected resource info %+v", info)
+	}
+	list := r.ResourceList(ctx)
+	if len(list) != 1 || list[0].Name != "foo.eth" || list[0].LastPeriod != 2 || list[0].Version != 1 {
+		t.Fatalf("unexpected resource list %v", list)
+	}
+	if _, err := r.ResourceLookup(ctx, "bar.eth", 0, 0); err == nil {
+		t.Fatal("expected error for unknown resource")
+	}
+}
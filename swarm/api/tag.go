@@ -0,0 +1,49 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// implements a service for querying the progress of the uploads over rpc
+type Tags struct {
+	tags *storage.Tags
+}
+
+func NewTags(tags *storage.Tags) *Tags {
+	return &Tags{tags}
+}
+
+// Tag returns the counters of the chunks of the upload with the tag uid
+func (self *Tags) Tag(uid uint32) (*storage.TagStatus, error) {
+	t, err := self.tags.Get(uid)
+	if err != nil {
+		return nil, err
+	}
+	return t.Status(), nil
+}
+
+// Tags returns the counters of the chunks of all uploads
+func (self *Tags) Tags() []*storage.TagStatus {
+	tags := self.tags.All()
+	status := make([]*storage.TagStatus, len(tags))
+	for i, t := range tags {
+		status[i] = t.Status()
+	}
+	return status
+}
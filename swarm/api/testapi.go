@@ -17,16 +17,20 @@
 package api
 
 import (
+	"errors"
+
 	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/storage"
 )
 
 type Control struct {
-	api  *Api
-	hive *network.Hive
+	api    *Api
+	hive   *network.Hive
+	lstore *storage.LocalStore
 }
 
-func NewControl(api *Api, hive *network.Hive) *Control {
-	return &Control{api, hive}
+func NewControl(api *Api, hive *network.Hive, lstore *storage.LocalStore) *Control {
+	return &Control{api, hive, lstore}
 }
 
 //func (self *Control) BlockNetworkRead(on bool) {
@@ -44,3 +48,108 @@ func NewControl(api *Api, hive *network.Hive) *Control {
 func (self *Control) Hive() string {
 	return self.hive.String()
 }
+
+// Healthy reports the health of the kademlia connectivity of the node given
+// the nearest neighbours and empty bins expected from all the nodes of the
+// network, see network.NewPeerPotMap
+func (self *Control) Healthy(pp *network.PeerPot) *network.Health {
+	return self.hive.Healthy(pp)
+}
+
+// Saturation reports the saturation of the kademlia connectivity of the
+// node, which tells if the node is in the network without knowing the others
+func (self *Control) Saturation() *network.Saturation {
+	return self.hive.Saturation()
+}
+
+// Table dumps the kademlia table of the node, all the known peers by
+// proximity bin with their connection state, as well as the ascii display of
+// the table returned by Hive
+func (self *Control) Table() *network.Table {
+	return self.hive.Table()
+}
+
+// SetStoreCapacity changes the number of chunks kept in the local store,
+// the chunks over the new capacity are garbage collected gradually
+func (self *Control) SetStoreCapacity(capacity uint64) {
+	self.lstore.SetDbCapacity(capacity)
+}
+
+// SetStoreSize changes the capacity of the local store in bytes
+func (self *Control) SetStoreSize(size uint64) {
+	self.lstore.SetDbCapacity(storage.SizeToChunks(size))
+}
+
+// SetStoreCacheCapacity changes the number of chunks cached in memory
+func (self *Control) SetStoreCacheCapacity(capacity uint) {
+	self.lstore.SetCacheCapacity(capacity)
+}
+
+// CompactStore compacts the chunk database to reclaim the disk space of
+// garbage collected chunks, it returns when the compaction is done
+func (self *Control) CompactStore() error {
+	return self.lstore.DbStore.Compact()
+}
+
+// ScrubStore checks the integrity of the stored chunks and deletes the
+// corrupted ones, if refetch is set they are retrieved again from the network
+// it returns when the scan is done
+func (self *Control) ScrubStore(refetch bool) (*storage.ScrubResult, error) {
+	if !refetch {
+		return self.lstore.Scrub()
+	}
+	netStore, ok := self.api.dpa.ChunkStore.(*storage.NetStore)
+	if !ok {
+		return nil, errors.New("chunks can only be refetched through a network store")
+	}
+	return netStore.Scrub()
+}
+
+// StoreStats returns the state of the local store and its storage metrics
+func (self *Control) StoreStats() (*storage.StoreStats, error) {
+	return self.lstore.Stats()
+}
+
+// CacheStats returns the hit rates of the memory cache and the chunk database
+// and the number of lookups that had to be requested from the network
+func (self *Control) CacheStats() *storage.CacheStats {
+	return self.lstore.CacheStats()
+}
+
+// ResetCacheStats zeroes the counters returned by CacheStats
+func (self *Control) ResetCacheStats() {
+	self.lstore.ResetCacheStats()
+}
+
+// ListLocal lists the chunks held in the local store selected by params,
+// a page at a time
+func (self *Control) ListLocal(params *storage.ListParams) (*storage.ListResult, error) {
+	if params == nil {
+		params = &storage.ListParams{}
+	}
+	return self.lstore.DbStore.List(params)
+}
+
+// DeleteLocal removes the chunk with the given key from the local store, so
+// that unwanted content is no longer held or served by the node
+func (self *Control) DeleteLocal(key storage.Key) error {
+	return self.lstore.Delete(key)
+}
+
+// CollectGarbage runs a round of garbage collection of the local store, even
+// if it is paused or the store is under its watermarks, and returns the number
+// of chunks collected
+func (self *Control) CollectGarbage() int {
+	return self.lstore.DbStore.CollectGarbage()
+}
+
+// PauseGC stops the garbage collection of the local store until ResumeGC is
+// called, the store may grow over its capacity meanwhile
+func (self *Control) PauseGC() {
+	self.lstore.DbStore.PauseGC()
+}
+
+// ResumeGC restarts the garbage collection of the local store
+func (self *Control) ResumeGC() {
+	self.lstore.DbStore.ResumeGC()
+}
@@ -37,8 +37,10 @@ type URI struct {
 	// * bzz           - an entry in a swarm manifest
 	// * bzz-raw       - raw swarm content
 	// * bzz-immutable - immutable URI of an entry in a swarm manifest
-	//                   (address is not resolved)
+	//                   (address is not resolved, mutable resources are not followed)
 	// * bzz-list      -  list of all files contained in a swarm manifest
+	// * bzz-tag       - progress of the uploads, addressed by the tag uid
+	// * bzz-has       - which of the chunks posted are stored by the node
 	//
 	Scheme string
 
@@ -63,7 +65,8 @@ type URI struct {
 // * <scheme>://<addr>
 // * <scheme>://<addr>/<path>
 //
-// with scheme one of bzz, bzz-raw, bzz-immutable, bzz-list or bzz-hash
+// with scheme one of bzz, bzz-raw, bzz-immutable, bzz-list, bzz-hash, bzz-tag
+// or bzz-has
 func Parse(rawuri string) (*URI, error) {
 	u, err := url.Parse(rawuri)
 	if err != nil {
@@ -73,7 +76,7 @@ func Parse(rawuri string) (*URI, error) {
 
 	// check the scheme is valid
 	switch uri.Scheme {
-	case "bzz", "bzz-raw", "bzz-immutable", "bzz-list", "bzz-hash", "bzz-resource":
+	case "bzz", "bzz-raw", "bzz-immutable", "bzz-list", "bzz-hash", "bzz-resource", "bzz-tag", "bzz-has":
 	default:
 		return nil, fmt.Errorf("unknown scheme %q", u.Scheme)
 	}
@@ -115,6 +118,14 @@ func (u *URI) Hash() bool {
 	return u.Scheme == "bzz-hash"
 }
 
+func (u *URI) Tag() bool {
+	return u.Scheme == "bzz-tag"
+}
+
+func (u *URI) Has() bool {
+	return u.Scheme == "bzz-has"
+}
+
 func (u *URI) String() string {
 	return u.Scheme + ":/" + u.Addr + "/" + u.Path
 }
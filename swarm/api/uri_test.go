@@ -33,6 +33,7 @@ func TestParseURI(t *testing.T) {
 		expectImmutable           bool
 		expectList                bool
 		expectHash                bool
+		expectTag                 bool
 		expectDeprecatedRaw       bool
 		expectDeprecatedImmutable bool
 		expectValidKey            bool
@@ -124,6 +125,16 @@ func TestParseURI(t *testing.T) {
 			expectURI:  &URI{Scheme: "bzz-list"},
 			expectList: true,
 		},
+		{
+			uri:       "bzz-tag:/",
+			expectURI: &URI{Scheme: "bzz-tag"},
+			expectTag: true,
+		},
+		{
+			uri:       "bzz-tag:/12",
+			expectURI: &URI{Scheme: "bzz-tag", Addr: "12"},
+			expectTag: true,
+		},
 		{
 			uri: "bzz-raw://4378d19c26590f1a818ed7d6a62c3809e149b0999cab5ce5f26233b3b423bf8c",
 			expectURI: &URI{Scheme: "bzz-raw",
@@ -164,6 +175,9 @@ func TestParseURI(t *testing.T) {
 		if actual.Hash() != x.expectHash {
 			t.Fatalf("expected %s hash to be %t, got %t", x.uri, x.expectHash, actual.Hash())
 		}
+		if actual.Tag() != x.expectTag {
+			t.Fatalf("expected %s tag to be %t, got %t", x.uri, x.expectTag, actual.Tag())
+		}
 		if x.expectValidKey {
 			if actual.Key() == nil {
 				t.Fatalf("expected %s to return a valid key, got nil", x.uri)
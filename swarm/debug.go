@@ -0,0 +1,106 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarm
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/exp"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// debugHandler serves the endpoints of the debug listener: the Go profiles
+// under /debug/pprof/, the runtime stats and metrics under /debug/vars and
+// /debug/metrics and the state of the node under /debug/swarm/
+func (self *Swarm) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/debug/metrics", exp.ExpHandler(metrics.DefaultRegistry))
+	mux.HandleFunc("/debug/swarm/", handleDebugIndex)
+	mux.HandleFunc("/debug/swarm/kademlia", self.handleDebugKademlia)
+	mux.HandleFunc("/debug/swarm/streams", self.handleDebugStreams)
+	mux.HandleFunc("/debug/swarm/store", self.handleDebugStore)
+	return mux
+}
+
+// debugIndex lists the endpoints of the debug listener
+var debugIndex = []string{
+	"/debug/pprof/",
+	"/debug/vars",
+	"/debug/metrics",
+	"/debug/swarm/kademlia",
+	"/debug/swarm/streams",
+	"/debug/swarm/store",
+}
+
+// handleDebugIndex links the endpoints of the debug listener
+func handleDebugIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/debug/swarm/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<html><head><title>swarm debug</title></head><body>")
+	for _, path := range debugIndex {
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a><br>\n", path, path)
+	}
+	fmt.Fprintln(w, "</body></html>")
+}
+
+// handleDebugKademlia dumps the kademlia table of the node
+func (self *Swarm) handleDebugKademlia(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, self.bzz.Hive.String())
+}
+
+// handleDebugStreams lists the streams of the connected peers
+func (self *Swarm) handleDebugStreams(w http.ResponseWriter, r *http.Request) {
+	respondDebugJSON(w, self.streamer.Streams())
+}
+
+// handleDebugStore reports the state of the local store and its cache
+func (self *Swarm) handleDebugStore(w http.ResponseWriter, r *http.Request) {
+	stats, err := self.lstore.Stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondDebugJSON(w, &struct {
+		Store *storage.StoreStats `json:"store"`
+		Cache *storage.CacheStats `json:"cache"`
+	}{stats, self.lstore.CacheStats()})
+}
+
+func respondDebugJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Warn("debug response encoding failed", "err", err)
+	}
+}
@@ -52,7 +52,6 @@ type SwarmFile struct {
 	path     string
 	key      storage.Key
 	fileSize int64
-	reader   storage.LazySectionReader
 
 	mountInfo *MountInfo
 	lock      *sync.RWMutex
@@ -65,7 +64,6 @@ func NewSwarmFile(path, fname string, minfo *MountInfo) *SwarmFile {
 		path:     path,
 		key:      nil,
 		fileSize: -1, // -1 means , file already exists in swarm and you need to just get the size from swarm
-		reader:   nil,
 
 		mountInfo: minfo,
 		lock:      &sync.RWMutex{},
@@ -82,7 +80,7 @@ func (sf *SwarmFile) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Gid = uint32(os.Getegid())
 
 	if sf.fileSize == -1 {
-		reader, _ := sf.mountInfo.swarmApi.Retrieve(sf.key)
+		reader, _ := sf.mountInfo.swarmApi.RetrieveWithContext(ctx, sf.key)
 		quitC := make(chan bool)
 		size, err := reader.Size(quitC)
 		if err != nil {
@@ -98,16 +96,14 @@ func (sf *SwarmFile) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse
 	log.Debug("swarmfs Read", "path", sf.path, "req.String", req.String())
 	sf.lock.RLock()
 	defer sf.lock.RUnlock()
-	if sf.reader == nil {
-		sf.reader, _ = sf.mountInfo.swarmApi.Retrieve(sf.key)
-	}
+	// only the chunks covering the requested range are retrieved
+	reader, _ := sf.mountInfo.swarmApi.RetrieveWithContext(ctx, sf.key)
 	buf := make([]byte, req.Size)
-	n, err := sf.reader.ReadAt(buf, req.Offset)
+	n, err := reader.ReadAt(buf, req.Offset)
 	if err == io.ErrUnexpectedEOF || err == io.EOF {
 		err = nil
 	}
 	resp.Data = buf[:n]
-	sf.reader = nil
 
 	return err
 }
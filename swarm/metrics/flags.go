@@ -17,12 +17,14 @@
 package metrics
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/log"
 	gethmetrics "github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/metrics/influxdb"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -60,6 +62,10 @@ var (
 		Usage: "Metrics InfluxDB `host` tag attached to all measurements",
 		Value: "localhost",
 	}
+	metricsPrometheusAddrFlag = cli.StringFlag{
+		Name:  "metrics.prometheus.addr",
+		Usage: "Serve metrics for Prometheus scraping on the given HTTP address under /metrics (e.g. 127.0.0.1:6061)",
+	}
 )
 
 // Flags holds all command-line flags required for metrics collection.
@@ -67,18 +73,20 @@ var Flags = []cli.Flag{
 	utils.MetricsEnabledFlag,
 	metricsEnableInfluxDBExportFlag,
 	metricsInfluxDBEndpointFlag, metricsInfluxDBDatabaseFlag, metricsInfluxDBUsernameFlag, metricsInfluxDBPasswordFlag, metricsInfluxDBHostTagFlag,
+	metricsPrometheusAddrFlag,
 }
 
 func Setup(ctx *cli.Context) {
 	if gethmetrics.Enabled {
 		log.Info("Enabling swarm metrics collection")
 		var (
-			enableExport = ctx.GlobalBool(metricsEnableInfluxDBExportFlag.Name)
-			endpoint     = ctx.GlobalString(metricsInfluxDBEndpointFlag.Name)
-			database     = ctx.GlobalString(metricsInfluxDBDatabaseFlag.Name)
-			username     = ctx.GlobalString(metricsInfluxDBUsernameFlag.Name)
-			password     = ctx.GlobalString(metricsInfluxDBPasswordFlag.Name)
-			hosttag      = ctx.GlobalString(metricsInfluxDBHostTagFlag.Name)
+			enableExport   = ctx.GlobalBool(metricsEnableInfluxDBExportFlag.Name)
+			endpoint       = ctx.GlobalString(metricsInfluxDBEndpointFlag.Name)
+			database       = ctx.GlobalString(metricsInfluxDBDatabaseFlag.Name)
+			username       = ctx.GlobalString(metricsInfluxDBUsernameFlag.Name)
+			password       = ctx.GlobalString(metricsInfluxDBPasswordFlag.Name)
+			hosttag        = ctx.GlobalString(metricsInfluxDBHostTagFlag.Name)
+			prometheusAddr = ctx.GlobalString(metricsPrometheusAddrFlag.Name)
 		)
 
 		if enableExport {
@@ -87,5 +95,16 @@ func Setup(ctx *cli.Context) {
 				"host": hosttag,
 			})
 		}
+
+		if prometheusAddr != "" {
+			log.Info("Enabling swarm metrics scraping by Prometheus", "addr", prometheusAddr)
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", prometheus.Handler(gethmetrics.DefaultRegistry, "swarm_"))
+			go func() {
+				if err := http.ListenAndServe(prometheusAddr, mux); err != nil {
+					log.Error("Failure in running Prometheus metrics server", "err", err)
+				}
+			}()
+		}
 	}
 }
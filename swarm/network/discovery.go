@@ -167,10 +167,9 @@ func (d *discPeer) handleSubPeersMsg(msg *subPeersMsg) error {
 	if !d.sentPeers {
 		d.setDepth(msg.Depth)
 		var peers []*BzzAddr
-		d.overlay.EachConn(d.Over(), 255, func(p OverlayConn, po int, isproxbin bool) bool {
-			if pob, _ := pof(d, d.localAddr, 0); pob > po {
-				return false
-			}
+		// only the peers at least as close to the recipient as the local node
+		pob, _ := pof(d, d.localAddr, 0)
+		d.overlay.EachConnInRange(d.Over(), pob, 255, 0, func(p OverlayConn, po int, isproxbin bool) bool {
 			if !d.seen(p) {
 				peers = append(peers, ToAddr(p.Off()))
 			}
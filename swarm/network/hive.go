@@ -45,24 +45,42 @@ type Overlay interface {
 	Off(OverlayConn)
 	// register peer addresses
 	Register([]OverlayAddr) error
+	// register peer addresses last seen at the given times
+	RegisterSeen([]OverlayAddr, []time.Time) error
+	// remove the peer addresses not seen for long unless kept
+	Prune(func(OverlayAddr) bool) int
 	// iterate over connected peers
 	EachConn([]byte, int, func(OverlayConn, int, bool) bool)
 	// iterate over known peers (address records)
 	EachAddr([]byte, int, func(OverlayAddr, int, bool) bool)
+	// iterate over connected peers in a proximity order range, at most a number of them
+	EachConnInRange([]byte, int, int, int, func(OverlayConn, int, bool) bool)
+	// iterate over known peers in a proximity order range, at most a number of them
+	EachAddrInRange([]byte, int, int, int, func(OverlayAddr, int, bool) bool)
 	// pretty print the connectivity
 	String() string
 	// base Overlay address of the node itself
 	BaseAddr() []byte
-	// connectivity health check used for testing
+	// connectivity health check given the nodes of the network
 	Healthy(*PeerPot) *Health
+	// saturation of the connectivity
+	Saturation() *Saturation
+	// dump of the known peers and their connection state
+	Table() *Table
 }
 
 // HiveParams holds the config options to hive
 type HiveParams struct {
-	Discovery             bool  // if want discovery of not
-	PeersBroadcastSetSize uint8 // how many peers to use when relaying
-	MaxPeersPerRequest    uint8 // max size for peer address batches
-	KeepAliveInterval     time.Duration
+	Discovery             bool             // if want discovery of not
+	PeersBroadcastSetSize uint8            // how many peers to use when relaying
+	MaxPeersPerRequest    uint8            // max size for peer address batches
+	KeepAliveInterval     time.Duration    // interval of the hive loop suggesting peers while the overlay is not saturated
+	MaxKeepAliveInterval  time.Duration    // the hive loop interval doubles up to this while the overlay is saturated
+	PeerExpiry            time.Duration    // peers not seen for longer are not persisted, kept forever if 0
+	BootstrapNodes        []*discover.Node `toml:"-"` // bootnodes of the overlay, connected to one at a time
+	BootnodeInterval      time.Duration    // how often the bootnode connection is checked, rotating to the next bootnode if it is down
+	StaticNodes           []*discover.Node `toml:"-"` // peers always kept connected, redialed when dropped
+	TrustedNodes          []*discover.Node `toml:"-"` // peers exempt from the connection limits, never removed from the overlay
 }
 
 // NewHiveParams returns hive config with only the
@@ -72,6 +90,9 @@ func NewHiveParams() *HiveParams {
 		PeersBroadcastSetSize: 3,
 		MaxPeersPerRequest:    5,
 		KeepAliveInterval:     500 * time.Millisecond,
+		MaxKeepAliveInterval:  30 * time.Second,
+		PeerExpiry:            7 * 24 * time.Hour,
+		BootnodeInterval:      30 * time.Second,
 	}
 }
 
@@ -81,9 +102,16 @@ type Hive struct {
 	Overlay                          // the overlay connectiviy driver
 	Store       state.Store          // storage interface to save peers across sessions
 	addPeer     func(*discover.Node) // server callback to connect to a peer
+	removePeer  func(*discover.Node) // server callback to disconnect from a peer
 	// bookkeeping
-	lock   sync.Mutex
-	ticker *time.Ticker
+	lock       sync.Mutex
+	quit       chan struct{}
+	seen       map[string]time.Time     // when the known peers were last seen, by overlay address
+	closed     bool                     // the store is closed, peers are not saved any more
+	peers      map[discover.NodeID]bool // connected peers, to check the bootnode connection
+	kept       map[discover.NodeID]bool // static and trusted peers, never removed from the overlay
+	bootnode   int                      // index of the bootnode in BootstrapNodes connected to
+	bootTicker *time.Ticker
 }
 
 // NewHive constructs a new hive
@@ -91,10 +119,20 @@ type Hive struct {
 // Overlay: connectivity driver using a network topology
 // StateStore: to save peers across sessions
 func NewHive(params *HiveParams, overlay Overlay, store state.Store) *Hive {
+	kept := make(map[discover.NodeID]bool)
+	for _, n := range params.StaticNodes {
+		kept[n.ID] = true
+	}
+	for _, n := range params.TrustedNodes {
+		kept[n.ID] = true
+	}
 	return &Hive{
 		HiveParams: params,
 		Overlay:    overlay,
 		Store:      store,
+		seen:       make(map[string]time.Time),
+		peers:      make(map[discover.NodeID]bool),
+		kept:       kept,
 	}
 }
 
@@ -113,21 +151,37 @@ func (h *Hive) Start(server *p2p.Server) error {
 	}
 	// assigns the p2p.Server#AddPeer function to connect to peers
 	h.addPeer = server.AddPeer
-	// ticker to keep the hive alive
-	h.ticker = time.NewTicker(h.KeepAliveInterval)
+	h.removePeer = server.RemovePeer
+	// the server keeps the static peers connected, redialing them when dropped
+	for _, n := range h.StaticNodes {
+		log.Info(fmt.Sprintf("%08x hive connecting to static peer %v", h.BaseAddr()[:4], n))
+		h.addPeer(n)
+	}
+	// closed to stop the loop keeping the hive alive
+	h.quit = make(chan struct{})
 	// this loop is doing bootstrapping and maintains a healthy table
 	go h.connect()
+	if len(h.BootstrapNodes) > 0 {
+		h.bootTicker = time.NewTicker(h.BootnodeInterval)
+		go h.bootstrap()
+	}
 	return nil
 }
 
 // Stop terminates the updateloop and saves the peers
 func (h *Hive) Stop() error {
 	log.Info(fmt.Sprintf("%08x hive stopping, saving peers", h.BaseAddr()[:4]))
-	h.ticker.Stop()
+	close(h.quit)
+	if h.bootTicker != nil {
+		h.bootTicker.Stop()
+	}
 	if h.Store != nil {
 		if err := h.savePeers(); err != nil {
 			return fmt.Errorf("could not save peers to persistence store: %v", err)
 		}
+		h.lock.Lock()
+		h.closed = true
+		h.lock.Unlock()
 		if err := h.Store.Close(); err != nil {
 			return fmt.Errorf("could not close file handle to persistence store: %v", err)
 		}
@@ -146,28 +200,130 @@ func (h *Hive) Stop() error {
 // connect is a forever loop
 // at each iteration, ask the overlay driver to suggest the most preferred peer to connect to
 // as well as advertises saturation depth if needed
+// the loop runs every KeepAliveInterval while bootstrapping, and slows down
+// while the overlay is saturated, see nextInterval
 func (h *Hive) connect() {
-	for range h.ticker.C {
-
-		addr, depth, changed := h.SuggestPeer()
-		if h.Discovery && changed {
-			NotifyDepth(uint8(depth), h)
+	interval := h.KeepAliveInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-timer.C:
 		}
-		if addr == nil {
-			continue
+		if n := h.Prune(h.isKept); n > 0 {
+			log.Debug(fmt.Sprintf("%08x hive pruned %d stale peers", h.BaseAddr()[:4], n))
+			h.forgetPruned()
 		}
+		suggested := h.suggestPeer()
+		interval = h.nextInterval(interval, suggested || !h.Saturation().Saturated)
+		timer.Reset(interval)
+	}
+}
 
-		log.Trace(fmt.Sprintf("%08x hive connect() suggested %08x", h.BaseAddr()[:4], addr.Address()[:4]))
-		under, err := discover.ParseNode(string(addr.(Addr).Under()))
-		if err != nil {
-			log.Warn(fmt.Sprintf("%08x unable to connect to bee %08x: invalid node URL: %v", h.BaseAddr()[:4], addr.Address()[:4], err))
-			continue
+// suggestPeer asks the overlay driver to suggest a peer and connects to it,
+// it returns if a peer was suggested
+func (h *Hive) suggestPeer() bool {
+	addr, depth, changed := h.SuggestPeer()
+	if h.Discovery && changed {
+		NotifyDepth(uint8(depth), h)
+	}
+	if addr == nil {
+		return false
+	}
+
+	log.Trace(fmt.Sprintf("%08x hive connect() suggested %08x", h.BaseAddr()[:4], addr.Address()[:4]))
+	under, err := discover.ParseNode(string(addr.(Addr).Under()))
+	if err != nil {
+		log.Warn(fmt.Sprintf("%08x unable to connect to bee %08x: invalid node URL: %v", h.BaseAddr()[:4], addr.Address()[:4], err))
+		return true
+	}
+	log.Trace(fmt.Sprintf("%08x attempt to connect to bee %08x", h.BaseAddr()[:4], addr.Address()[:4]))
+	h.addPeer(under)
+	return true
+}
+
+// nextInterval returns the interval of the hive loop after the interval
+// elapsed: KeepAliveInterval if the hive is busy connecting, otherwise the
+// interval doubled up to MaxKeepAliveInterval
+func (h *Hive) nextInterval(interval time.Duration, busy bool) time.Duration {
+	if busy || h.MaxKeepAliveInterval <= h.KeepAliveInterval {
+		return h.KeepAliveInterval
+	}
+	interval *= 2
+	if interval > h.MaxKeepAliveInterval {
+		return h.MaxKeepAliveInterval
+	}
+	return interval
+}
+
+// isKept tells if the peer is a static or trusted peer, which is never
+// removed from the overlay
+func (h *Hive) isKept(addr OverlayAddr) bool {
+	if len(h.kept) == 0 {
+		return false
+	}
+	a, ok := addr.(Addr)
+	if !ok {
+		return false
+	}
+	n, err := discover.ParseNode(string(a.Under()))
+	if err != nil {
+		return false
+	}
+	return h.kept[n.ID]
+}
+
+// bootstrap connects to the first bootnode and checks the connection
+// periodically, so that a bootnode being down does not leave a fresh node
+// without peers
+func (h *Hive) bootstrap() {
+	h.addPeer(h.BootstrapNodes[0])
+	for {
+		select {
+		case <-h.bootTicker.C:
+			h.checkBootnode()
+		case <-h.quit:
+			return
 		}
-		log.Trace(fmt.Sprintf("%08x attempt to connect to bee %08x", h.BaseAddr()[:4], addr.Address()[:4]))
-		h.addPeer(under)
 	}
 }
 
+// checkBootnode disconnects from the bootnode and connects to the next one if
+// the bootnode is not connected
+func (h *Hive) checkBootnode() {
+	h.lock.Lock()
+	current := h.BootstrapNodes[h.bootnode]
+	if h.peers[current.ID] {
+		h.lock.Unlock()
+		return
+	}
+	h.bootnode = (h.bootnode + 1) % len(h.BootstrapNodes)
+	next := h.BootstrapNodes[h.bootnode]
+	static := h.isStatic(current.ID)
+	h.lock.Unlock()
+	if next == current {
+		// the server keeps dialing the only bootnode
+		return
+	}
+	log.Warn(fmt.Sprintf("%08x bootnode %v not connected, trying %v", h.BaseAddr()[:4], current, next))
+	if !static {
+		h.removePeer(current)
+	}
+	h.addPeer(next)
+}
+
+// isStatic tells if the peer with the id is a static peer
+func (h *Hive) isStatic(id discover.NodeID) bool {
+	for _, n := range h.StaticNodes {
+		if n.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 // Run protocol run function
 func (h *Hive) Run(p *BzzPeer) error {
 	dp := newDiscovery(p, h)
@@ -183,10 +339,68 @@ func (h *Hive) Run(p *BzzPeer) error {
 		}
 	}
 	NotifyPeer(p.Off(), h)
+	h.lock.Lock()
+	h.peers[p.ID()] = true
+	h.lock.Unlock()
+	defer func() {
+		h.lock.Lock()
+		delete(h.peers, p.ID())
+		h.lock.Unlock()
+	}()
+	h.peerSeen(p.Address())
+	defer h.peerSeen(p.Address())
 	defer h.Off(dp)
 	return dp.Run(dp.HandleMsg)
 }
 
+// Register records the time the peer addresses not known yet were first seen
+// and registers them with the overlay
+func (h *Hive) Register(peers []OverlayAddr) error {
+	h.lock.Lock()
+	now := time.Now()
+	for _, p := range peers {
+		if _, ok := h.seen[string(p.Address())]; !ok {
+			h.seen[string(p.Address())] = now
+		}
+	}
+	h.lock.Unlock()
+	return h.Overlay.Register(peers)
+}
+
+// forgetPruned removes the times the peers were last seen for the addresses
+// no longer known to the overlay, so that pruned peers are not kept forever
+func (h *Hive) forgetPruned() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	known := make(map[string]bool)
+	h.Overlay.EachAddr(nil, 256, func(pa OverlayAddr, _ int, _ bool) bool {
+		if pa != nil {
+			known[string(pa.Address())] = true
+		}
+		return true
+	})
+	for addr := range h.seen {
+		if !known[addr] {
+			delete(h.seen, addr)
+		}
+	}
+}
+
+// peerSeen records the peer with the address as seen now and, as the
+// connectivity changed, saves the known peers so that they are not lost if
+// the node is not stopped cleanly
+func (h *Hive) peerSeen(addr []byte) {
+	h.lock.Lock()
+	h.seen[string(addr)] = time.Now()
+	h.lock.Unlock()
+	if h.Store == nil {
+		return
+	}
+	if err := h.savePeers(); err != nil {
+		log.Warn(fmt.Sprintf("%08x hive could not save peers: %v", h.BaseAddr()[:4], err))
+	}
+}
+
 // NodeInfo function is used by the p2p.server RPC interface to display
 // protocol specific node information
 func (h *Hive) NodeInfo() interface{} {
@@ -217,10 +431,23 @@ func ToAddr(pa OverlayPeer) *BzzAddr {
 	return pa.(*BzzPeer).BzzAddr
 }
 
+// peerRecord is the persisted record of a known peer, the address is
+// embedded so that the records saved before the time they were last seen was
+// recorded are still loaded
+type peerRecord struct {
+	*BzzAddr
+	SeenAt time.Time `json:"seenAt"`
+}
+
+// expired tells if the peer last seen at seenAt is to be retired at the time now
+func (h *Hive) expired(seenAt, now time.Time) bool {
+	return h.PeerExpiry > 0 && now.Sub(seenAt) > h.PeerExpiry
+}
+
 // loadPeers, savePeer implement persistence callback/
 func (h *Hive) loadPeers() error {
-	var as []*BzzAddr
-	err := h.Store.Get("peers", &as)
+	var records []*peerRecord
+	err := h.Store.Get("peers", &records)
 	if err != nil {
 		if err == state.ErrNotFound {
 			log.Info(fmt.Sprintf("hive %08x: no persisted peers found", h.BaseAddr()[:4]))
@@ -228,9 +455,32 @@ func (h *Hive) loadPeers() error {
 		}
 		return err
 	}
-	log.Info(fmt.Sprintf("hive %08x: peers loaded", h.BaseAddr()[:4]))
 
-	return h.Register(toOverlayAddrs(as...))
+	now := time.Now()
+	var as []*BzzAddr
+	var seenAt []time.Time
+	h.lock.Lock()
+	for _, r := range records {
+		if r.BzzAddr == nil {
+			continue
+		}
+		// records without the time are taken as seen now
+		seen := r.SeenAt
+		if seen.IsZero() {
+			seen = now
+		}
+		if h.expired(seen, now) {
+			continue
+		}
+		h.seen[string(r.Address())] = seen
+		as = append(as, r.BzzAddr)
+		seenAt = append(seenAt, seen)
+	}
+	h.lock.Unlock()
+	log.Info(fmt.Sprintf("hive %08x: %d peers loaded, %d retired", h.BaseAddr()[:4], len(as), len(records)-len(as)))
+
+	// the peers are pruned by the time they were last seen, not loaded
+	return h.Overlay.RegisterSeen(toOverlayAddrs(as...), seenAt)
 }
 
 // toOverlayAddrs transforms an array of BzzAddr to OverlayAddr
@@ -242,16 +492,33 @@ func toOverlayAddrs(as ...*BzzAddr) (oas []OverlayAddr) {
 }
 
 // savePeers, savePeer implement persistence callback/
+//
+// The connected peers are saved as seen now, the peers not seen for longer
+// than the expiry are retired.
 func (h *Hive) savePeers() error {
-	var peers []*BzzAddr
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return nil
+	}
+	now := time.Now()
+	var peers []*peerRecord
 	h.Overlay.EachAddr(nil, 256, func(pa OverlayAddr, i int, _ bool) bool {
 		if pa == nil {
 			log.Warn(fmt.Sprintf("empty addr: %v", i))
 			return true
 		}
 		apa := ToAddr(pa)
+		seenAt, ok := h.seen[string(apa.Address())]
+		if _, connected := pa.(OverlayConn); connected || !ok {
+			seenAt = now
+		}
+		if h.expired(seenAt, now) {
+			log.Trace("retiring peer", "peer", apa, "seen", seenAt)
+			return true
+		}
 		log.Trace("saving peer", "peer", apa)
-		peers = append(peers, apa)
+		peers = append(peers, &peerRecord{BzzAddr: apa, SeenAt: seenAt})
 		return true
 	})
 	if err := h.Store.Put("peers", peers); err != nil {
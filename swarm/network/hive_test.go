@@ -17,11 +17,15 @@
 package network
 
 import (
+	"bytes"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
 	"github.com/ethereum/go-ethereum/swarm/state"
 )
@@ -106,3 +110,181 @@ func TestHiveStatePersistance(t *testing.T) {
 		t.Fatalf("invalid peers loaded")
 	}
 }
+
+// TestHivePeerExpiry tests that the hive saves the known peers with the time
+// they were last seen when the connectivity changes, and retires the peers
+// not seen for longer than the expiry when saving and loading them
+func TestHivePeerExpiry(t *testing.T) {
+	store := state.NewInmemoryStore()
+	params := NewHiveParams()
+	params.PeerExpiry = time.Hour
+	_, pp := newHiveTester(t, params, 0, store)
+
+	fresh, stale := RandomAddr(), RandomAddr()
+	if err := pp.Register([]OverlayAddr{fresh, stale}); err != nil {
+		t.Fatal(err)
+	}
+	pp.seen[string(stale.Address())] = time.Now().Add(-2 * time.Hour)
+	pp.peerSeen(fresh.Address())
+
+	var records []*peerRecord
+	if err := store.Get("peers", &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !bytes.Equal(records[0].Address(), fresh.Address()) {
+		t.Fatalf("expected only the fresh peer to be saved, got %v", records)
+	}
+	if time.Since(records[0].SeenAt) > time.Minute {
+		t.Fatalf("expected the fresh peer to be seen now, got %v", records[0].SeenAt)
+	}
+
+	// records saved without the time are loaded, expired ones are not
+	old := RandomAddr()
+	records = append(records,
+		&peerRecord{BzzAddr: stale, SeenAt: time.Now().Add(-2 * time.Hour)},
+		&peerRecord{BzzAddr: old},
+	)
+	if err := store.Put("peers", records); err != nil {
+		t.Fatal(err)
+	}
+	_, pp = newHiveTester(t, params, 0, store)
+	if err := pp.loadPeers(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := make(map[string]bool)
+	pp.EachAddr(nil, 256, func(addr OverlayAddr, _ int, _ bool) bool {
+		loaded[string(addr.Address())] = true
+		return true
+	})
+	if len(loaded) != 2 || !loaded[string(fresh.Address())] || !loaded[string(old.Address())] {
+		t.Fatalf("expected the fresh and the old format peers to be loaded, got %d peers", len(loaded))
+	}
+
+	// the loaded peers are pruned by the time they were last seen
+	seenAt := time.Now().Add(-30 * time.Minute)
+	aged := RandomAddr()
+	store.Put("peers", []*peerRecord{{BzzAddr: aged, SeenAt: seenAt}})
+	_, pp = newHiveTester(t, params, 0, store)
+	if err := pp.loadPeers(); err != nil {
+		t.Fatal(err)
+	}
+	kad := pp.Overlay.(*Kademlia)
+	kad.PruneAge = 10 * time.Minute
+	kad.MinBinSize = 0
+	kad.MinBinSizes = nil
+	if n := pp.Prune(pp.isKept); n != 1 {
+		t.Fatalf("expected the peer last seen %v to be pruned, got %d pruned", seenAt, n)
+	}
+}
+
+func TestHiveBootnodeRotation(t *testing.T) {
+	params := NewHiveParams()
+	for i := byte(1); i <= 3; i++ {
+		params.BootstrapNodes = append(params.BootstrapNodes, discover.NewNode(discover.NodeID{i}, net.IP{127, 0, 0, 1}, 30303, 30303))
+	}
+	_, pp := newHiveTester(t, params, 1, nil)
+
+	var added, removed []*discover.Node
+	pp.addPeer = func(n *discover.Node) { added = append(added, n) }
+	pp.removePeer = func(n *discover.Node) { removed = append(removed, n) }
+
+	// the connected bootnode is kept
+	pp.peers[params.BootstrapNodes[0].ID] = true
+	pp.checkBootnode()
+	if len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("expected the connected bootnode to be kept, got %v added and %v removed", added, removed)
+	}
+
+	// the bootnodes not connected are rotated
+	delete(pp.peers, params.BootstrapNodes[0].ID)
+	for _, exp := range []int{1, 2, 0} {
+		prev := params.BootstrapNodes[pp.bootnode]
+		pp.checkBootnode()
+		if removed[len(removed)-1] != prev {
+			t.Fatalf("expected bootnode %v to be removed, got %v", prev, removed[len(removed)-1])
+		}
+		if added[len(added)-1] != params.BootstrapNodes[exp] {
+			t.Fatalf("expected bootnode %d to be added, got %v", exp, added[len(added)-1])
+		}
+	}
+}
+
+// TestHiveNextInterval tests that the hive loop slows down while it is idle
+// and runs fast again as soon as it needs to connect
+func TestHiveNextInterval(t *testing.T) {
+	params := NewHiveParams()
+	params.KeepAliveInterval = time.Second
+	params.MaxKeepAliveInterval = 5 * time.Second
+	_, pp := newHiveTester(t, params, 0, nil)
+
+	interval := params.KeepAliveInterval
+	for _, exp := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		interval = pp.nextInterval(interval, false)
+		if interval != exp {
+			t.Fatalf("expected idle interval %v, got %v", exp, interval)
+		}
+	}
+	if interval = pp.nextInterval(interval, true); interval != params.KeepAliveInterval {
+		t.Fatalf("expected busy interval %v, got %v", params.KeepAliveInterval, interval)
+	}
+
+	// the interval is fixed if the maximum is not greater
+	params.MaxKeepAliveInterval = 0
+	if interval = pp.nextInterval(interval, false); interval != params.KeepAliveInterval {
+		t.Fatalf("expected fixed interval %v, got %v", params.KeepAliveInterval, interval)
+	}
+}
+
+// TestHiveKeptPeers tests that the static and trusted peers are never removed
+// from the overlay and the static bootnodes are not disconnected
+func TestHiveKeptPeers(t *testing.T) {
+	params := NewHiveParams()
+	static := discover.NewNode(discover.NodeID{1}, net.IP{127, 0, 0, 1}, 30303, 30303)
+	trusted := discover.NewNode(discover.NodeID{2}, net.IP{127, 0, 0, 1}, 30303, 30303)
+	params.StaticNodes = []*discover.Node{static}
+	params.TrustedNodes = []*discover.Node{trusted}
+	params.BootstrapNodes = []*discover.Node{static, trusted}
+	_, pp := newHiveTester(t, params, 0, nil)
+
+	var removed []*discover.Node
+	pp.addPeer = func(n *discover.Node) {}
+	pp.removePeer = func(n *discover.Node) { removed = append(removed, n) }
+
+	other := NewAddrFromNodeID(discover.NodeID{3})
+	addrs := []OverlayAddr{NewAddrFromNodeID(static.ID), NewAddrFromNodeID(trusted.ID), other}
+	if err := pp.Register(addrs); err != nil {
+		t.Fatal(err)
+	}
+	// all peers fail to connect
+	kad := pp.Overlay.(*Kademlia)
+	kad.MaxDialFailures = 1
+	kad.RetryInterval = 0
+	for i := 0; i < len(addrs); i++ {
+		kad.SuggestPeer()
+	}
+	if n := pp.Prune(pp.isKept); n != 1 {
+		t.Fatalf("expected 1 peer to be pruned, got %d", n)
+	}
+	known := make(map[string]bool)
+	pp.EachAddr(nil, 256, func(a OverlayAddr, _ int, _ bool) bool {
+		known[string(a.Address())] = true
+		return true
+	})
+	if len(known) != 2 || known[string(other.Address())] {
+		t.Fatalf("expected only the static and trusted peers to be kept, got %d peers", len(known))
+	}
+	pp.forgetPruned()
+	if _, ok := pp.seen[string(other.Address())]; ok || len(pp.seen) != 2 {
+		t.Fatalf("expected the pruned peer to be forgotten, got %d seen peers", len(pp.seen))
+	}
+
+	// the static bootnode is not disconnected when rotating the bootnodes
+	pp.checkBootnode()
+	if len(removed) != 0 {
+		t.Fatalf("expected the static bootnode not to be removed, got %v", removed)
+	}
+	pp.checkBootnode()
+	if len(removed) != 1 || removed[0] != trusted {
+		t.Fatalf("expected the trusted bootnode to be removed, got %v", removed)
+	}
+}
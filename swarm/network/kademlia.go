@@ -20,15 +20,25 @@ import (
 	"bytes"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/pot"
 )
 
+var (
+	kademliaPeersGauge = metrics.NewRegisteredGauge("network.kademlia.peers", nil)
+	kademliaAddrsGauge = metrics.NewRegisteredGauge("network.kademlia.addrs", nil)
+	kademliaDepthGauge = metrics.NewRegisteredGauge("network.kademlia.depth", nil)
+)
+
 /*
 
 Taking the proximity order relative to a fix point x classifies the points in
@@ -58,9 +68,17 @@ type KadParams struct {
 	MinProxBinSize int   // nearest neighbour core minimum cardinality
 	MinBinSize     int   // minimum number of peers in a row
 	MaxBinSize     int   // maximum number of peers in a row before pruning
+	MinBinSizes    []int // minimum number of peers in each row by proximity order, MinBinSize for the rows not listed
+	MaxBinSizes    []int // maximum number of peers in each row by proximity order, MaxBinSize for the rows not listed
 	RetryInterval  int64 // initial interval before a peer is first redialed
 	RetryExponent  int   // exponent to multiply retry intervals with
 	MaxRetries     int   // maximum number of redial attempts
+	// known peers failing to connect as many times in a row are not dialed any
+	// more and removed by Prune; never if 0
+	MaxDialFailures int
+	// known peers not connected or seen for longer are pruned, unless their
+	// bin would have less than its minimum number of peers left; never if 0
+	PruneAge time.Duration
 	// function to sanction or prevent suggesting a peer
 	Reachable func(OverlayAddr) bool
 }
@@ -68,27 +86,79 @@ type KadParams struct {
 // NewKadParams returns a params struct with default values
 func NewKadParams() *KadParams {
 	return &KadParams{
-		MaxProxDisplay: 16,
-		MinProxBinSize: 2,
-		MinBinSize:     2,
-		MaxBinSize:     4,
-		RetryInterval:  4200000000, // 4.2 sec
-		MaxRetries:     42,
-		RetryExponent:  2,
+		MaxProxDisplay:  16,
+		MinProxBinSize:  2,
+		MinBinSize:      2,
+		MaxBinSize:      4,
+		RetryInterval:   4200000000, // 4.2 sec
+		MaxRetries:      42,
+		RetryExponent:   2,
+		MaxDialFailures: 10,
+		PruneAge:        24 * time.Hour,
 	}
 }
 
+// MinBinSizeAt returns the minimum number of peers in the row of the
+// proximity order
+func (p *KadParams) MinBinSizeAt(po int) int {
+	if po < len(p.MinBinSizes) {
+		return p.MinBinSizes[po]
+	}
+	return p.MinBinSize
+}
+
+// MaxBinSizeAt returns the maximum number of peers in the row of the
+// proximity order
+func (p *KadParams) MaxBinSizeAt(po int) int {
+	if po < len(p.MaxBinSizes) {
+		return p.MaxBinSizes[po]
+	}
+	return p.MaxBinSize
+}
+
 // Kademlia is a table of live peers and a db of known peers (node records)
 type Kademlia struct {
 	lock       sync.RWMutex
-	*KadParams          // Kademlia configuration parameters
-	base       []byte   // immutable baseaddress of the table
-	addrs      *pot.Pot // pots container for known peer addresses
-	conns      *pot.Pot // pots container for live peer connections
-	depth      uint8    // stores the last current depth of saturation
-	nDepth     int      // stores the last neighbourhood depth
-	nDepthC    chan int // returned by DepthC function to signal neighbourhood depth change
-	addrCountC chan int // returned by AddrCountC function to signal peer count change
+	*KadParams                 // Kademlia configuration parameters
+	base       []byte          // immutable baseaddress of the table
+	addrs      *pot.Pot        // pots container for known peer addresses
+	conns      *pot.Pot        // pots container for live peer connections
+	depth      uint8           // stores the last current depth of saturation
+	nDepth     int             // stores the last neighbourhood depth
+	nDepthC    chan int        // returned by DepthC function to signal neighbourhood depth change
+	addrCountC chan int        // returned by AddrCountC function to signal peer count change
+	peerFeed   event.Feed      // peer lifecycle events, see SubscribePeers
+	nns        map[string]bool // addresses of the connected nearest neighbours
+	depthFeed  event.Feed      // neighbourhood depth changes, see SubscribeDepth
+	feedDepth  int             // the last neighbourhood depth sent to depthFeed
+	pending    []func()        // events to send once the lock is released, see flushEvents
+	feedLock   sync.Mutex      // keeps the events in order while they are sent
+}
+
+// PeerEventType is the type of a peer event of the kademlia table
+type PeerEventType string
+
+const (
+	// PeerEventTypeAdd is the type of the event when a new peer address is
+	// added to the table
+	PeerEventTypeAdd PeerEventType = "add"
+	// PeerEventTypeConnect is the type of the event when a peer connects
+	PeerEventTypeConnect PeerEventType = "connect"
+	// PeerEventTypeDrop is the type of the event when a peer disconnects
+	PeerEventTypeDrop PeerEventType = "drop"
+	// PeerEventTypeNN is the type of the event when a connected peer becomes
+	// a nearest neighbour, either by connecting within the neighbourhood
+	// depth or by the depth decreasing
+	PeerEventTypeNN PeerEventType = "nn"
+)
+
+// PeerEvent is sent to the subscribers of the kademlia table on the
+// lifecycle events of a peer
+type PeerEvent struct {
+	Type  PeerEventType `json:"type"`
+	Addr  hexutil.Bytes `json:"addr"`  // overlay address of the peer
+	Peers int           `json:"peers"` // number of connected peers after the event
+	Depth int           `json:"depth"` // neighbourhood depth after the event
 }
 
 // NewKademlia creates a Kademlia table for base address addr
@@ -103,6 +173,7 @@ func NewKademlia(addr []byte, params *KadParams) *Kademlia {
 		KadParams: params,
 		addrs:     pot.NewPot(nil, 0),
 		conns:     pot.NewPot(nil, 0),
+		nns:       make(map[string]bool),
 	}
 }
 
@@ -129,7 +200,8 @@ type OverlayAddr interface {
 type entry struct {
 	OverlayPeer
 	seenAt  time.Time
-	retries int
+	retries int  // dials since the peer was last connected, all but the latest one failed
+	dialed  bool // the peer was connected to by dialing its underlay address
 }
 
 // newEntry creates a kademlia peer from an OverlayPeer interface
@@ -175,10 +247,26 @@ func (e *entry) conn() OverlayConn {
 // Register enters each OverlayAddr as kademlia peer record into the
 // database of known peer addresses
 func (k *Kademlia) Register(peers []OverlayAddr) error {
+	return k.register(peers, nil)
+}
+
+// RegisterSeen is like Register, but the peers not known yet are entered as
+// last seen at the time of the same index in seenAt instead of now, so that
+// peers loaded from a previous run keep their age for Prune
+func (k *Kademlia) RegisterSeen(peers []OverlayAddr, seenAt []time.Time) error {
+	if len(seenAt) != len(peers) {
+		return fmt.Errorf("register %d peers with %d seen times", len(peers), len(seenAt))
+	}
+	return k.register(peers, seenAt)
+}
+
+func (k *Kademlia) register(peers []OverlayAddr, seenAt []time.Time) error {
+	defer k.flushEvents()
 	k.lock.Lock()
 	defer k.lock.Unlock()
 	var known, size int
-	for _, p := range peers {
+	var added []OverlayPeer
+	for i, p := range peers {
 		// error if self received, peer should know better
 		// and should be punished for this
 		if bytes.Equal(p.Address(), k.base) {
@@ -189,13 +277,19 @@ func (k *Kademlia) Register(peers []OverlayAddr) error {
 			// if not found
 			if v == nil {
 				// insert new offline peer into conns
-				return newEntry(p)
+				e := newEntry(p)
+				if seenAt != nil && !seenAt[i].IsZero() {
+					e.seenAt = seenAt[i]
+				}
+				return e
 			}
 			// found among known peers, do nothing
 			return v
 		})
 		if found {
 			known++
+		} else {
+			added = append(added, p)
 		}
 		size++
 	}
@@ -205,28 +299,92 @@ func (k *Kademlia) Register(peers []OverlayAddr) error {
 	}
 	// log.Trace(fmt.Sprintf("%x registered %v peers, %v known, total: %v", k.BaseAddr()[:4], size, known, k.addrs.Size()))
 
+	k.updateMetrics()
 	k.sendNeighbourhoodDepthChange()
+	for _, p := range added {
+		k.sendPeerEvent(p, PeerEventTypeAdd)
+	}
 	return nil
 }
 
+// Prune removes the known peers not connected or seen for longer than
+// PruneAge from the database of known peer addresses, the longest not seen
+// first, leaving at least the minimum number of known peers in each bin
+// the peers which failed to connect MaxDialFailures times in a row are
+// removed regardless of the minimum once their last dial had its time
+// the peers for which keep returns true are never removed, keep may be nil
+// it returns the number of peers removed
+func (k *Kademlia) Prune(keep func(OverlayAddr) bool) int {
+	if k.PruneAge == 0 && k.MaxDialFailures == 0 {
+		return 0
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	now := time.Now()
+	var stale []*entry
+	k.addrs.EachBin(k.base, pof, 0, func(po, size int, f func(func(val pot.Val, i int) bool) bool) bool {
+		var bin []*entry
+		f(func(val pot.Val, _ int) bool {
+			e := val.(*entry)
+			if e.conn() != nil || (keep != nil && keep(e.addr())) {
+				return true
+			}
+			if k.failed(e) {
+				log.Trace(fmt.Sprintf("%08x: removing peer %v after %d failed dials", k.BaseAddr()[:4], e, e.retries))
+				stale = append(stale, e)
+				size--
+			} else if k.PruneAge > 0 && now.Sub(e.seenAt) > k.PruneAge {
+				bin = append(bin, e)
+			}
+			return true
+		})
+		n := size - k.MinBinSizeAt(po)
+		if n > len(bin) {
+			n = len(bin)
+		}
+		if n <= 0 {
+			return true
+		}
+		sort.Slice(bin, func(i, j int) bool {
+			return bin[i].seenAt.Before(bin[j].seenAt)
+		})
+		stale = append(stale, bin[:n]...)
+		return true
+	})
+	if len(stale) == 0 {
+		return 0
+	}
+	for _, e := range stale {
+		log.Trace(fmt.Sprintf("%08x: pruning peer %v not seen since %v", k.BaseAddr()[:4], e, e.seenAt))
+		k.addrs, _, _, _ = pot.Swap(k.addrs, e, pof, func(_ pot.Val) pot.Val {
+			return nil
+		})
+	}
+	if k.addrCountC != nil {
+		k.addrCountC <- k.addrs.Size()
+	}
+	k.updateMetrics()
+	return len(stale)
+}
+
 // SuggestPeer returns a known peer for the lowest proximity bin for the
 // lowest bincount below depth
 // naturally if there is an empty row it returns a peer for that
 func (k *Kademlia) SuggestPeer() (a OverlayAddr, o int, want bool) {
 	k.lock.Lock()
 	defer k.lock.Unlock()
-	minsize := k.MinBinSize
 	depth := k.neighbourhoodDepth()
 	// if there is a callable neighbour within the current proxBin, connect
 	// this makes sure nearest neighbour set is fully connected
 	var ppo int
-	k.addrs.EachNeighbour(k.base, pof, func(val pot.Val, po int) bool {
-		if po < depth {
-			return false
-		}
-		a = k.callable(val)
-		ppo = po
-		return a == nil
+	a = k.suggest(func(f func(pot.Val, int) bool) bool {
+		return k.addrs.EachNeighbour(k.base, pof, func(val pot.Val, po int) bool {
+			if po < depth {
+				return false
+			}
+			ppo = po
+			return f(val, po)
+		})
 	})
 	if a != nil {
 		log.Trace(fmt.Sprintf("%08x candidate nearest neighbour found: %v (%v)", k.BaseAddr()[:4], a, ppo))
@@ -234,21 +392,23 @@ func (k *Kademlia) SuggestPeer() (a OverlayAddr, o int, want bool) {
 	}
 	// log.Trace(fmt.Sprintf("%08x no candidate nearest neighbours to connect to (Depth: %v, minProxSize: %v) %#v", k.BaseAddr()[:4], depth, k.MinProxBinSize, a))
 
+	// bins with the largest number of peers missing to their minimum size
 	var bpo []int
+	var missing int
 	prev := -1
 	k.conns.EachBin(k.base, pof, 0, func(po, size int, f func(func(val pot.Val, i int) bool) bool) bool {
 		prev++
 		for ; prev < po; prev++ {
 			bpo = append(bpo, prev)
-			minsize = 0
+			missing = k.MinBinSizeAt(prev)
 		}
-		if size < minsize {
+		if m := k.MinBinSizeAt(po) - size; m > missing {
 			bpo = append(bpo, po)
-			minsize = size
+			missing = m
 		}
 		return size > 0 && po < depth
 	})
-	// all buckets are full, ie., minsize == k.MinBinSize
+	// all buckets are full, ie., each has its minimum size
 	if len(bpo) == 0 {
 		// log.Debug(fmt.Sprintf("%08x: all bins saturated", k.BaseAddr()[:4]))
 		return nil, 0, false
@@ -263,10 +423,8 @@ func (k *Kademlia) SuggestPeer() (a OverlayAddr, o int, want bool) {
 		if po >= depth {
 			return false
 		}
-		return f(func(val pot.Val, _ int) bool {
-			a = k.callable(val)
-			return a == nil
-		})
+		a = k.suggest(f)
+		return a == nil
 	})
 	// found a candidate
 	if a != nil {
@@ -281,12 +439,35 @@ func (k *Kademlia) SuggestPeer() (a OverlayAddr, o int, want bool) {
 	return a, nxt, changed
 }
 
+// suggest returns the first callable peer visited by the iterator each,
+// preferring the peers whose underlay address was dialed successfully as the
+// others may be behind a NAT and only able to dial in
+func (k *Kademlia) suggest(each func(func(pot.Val, int) bool) bool) (a OverlayAddr) {
+	for _, dialedOnly := range []bool{true, false} {
+		each(func(val pot.Val, _ int) bool {
+			if dialedOnly && !val.(*entry).dialed {
+				return true
+			}
+			a = k.callable(val)
+			return a == nil
+		})
+		if a != nil {
+			return a
+		}
+	}
+	return nil
+}
+
 // On inserts the peer as a kademlia peer into the live peers
 func (k *Kademlia) On(p OverlayConn) (uint8, bool) {
+	defer k.flushEvents()
 	k.lock.Lock()
 	defer k.lock.Unlock()
 	e := newEntry(p)
-	var ins bool
+	if c, ok := p.(interface{ Dialed() bool }); ok {
+		e.dialed = c.Dialed()
+	}
+	var ins, known bool
 	k.conns, _, _, _ = pot.Swap(k.conns, p, pof, func(v pot.Val) pot.Val {
 		// if not found live
 		if v == nil {
@@ -299,7 +480,10 @@ func (k *Kademlia) On(p OverlayConn) (uint8, bool) {
 	})
 	if ins {
 		// insert new online peer into addrs
-		k.addrs, _, _, _ = pot.Swap(k.addrs, p, pof, func(v pot.Val) pot.Val {
+		k.addrs, _, known, _ = pot.Swap(k.addrs, p, pof, func(v pot.Val) pot.Val {
+			if v != nil && v.(*entry).dialed {
+				e.dialed = true
+			}
 			return e
 		})
 		// send new address count value only if the peer is inserted
@@ -309,13 +493,21 @@ func (k *Kademlia) On(p OverlayConn) (uint8, bool) {
 	}
 	log.Trace(k.string())
 	// calculate if depth of saturation changed
-	depth := uint8(k.saturation(k.MinBinSize))
+	depth := uint8(k.saturation())
 	var changed bool
 	if depth != k.depth {
 		changed = true
 		k.depth = depth
 	}
+	k.updateMetrics()
 	k.sendNeighbourhoodDepthChange()
+	if ins {
+		if !known {
+			k.sendPeerEvent(p, PeerEventTypeAdd)
+		}
+		k.sendPeerEvent(p, PeerEventTypeConnect)
+		k.sendNNEvents()
+	}
 	return k.depth, changed
 }
 
@@ -336,13 +528,26 @@ func (k *Kademlia) sendNeighbourhoodDepthChange() {
 	// nDepthC is initialized when NeighbourhoodDepthC is called and returned by it.
 	// It provides signaling of neighbourhood depth change.
 	// This part of the code is sending new neighbourhood depth to nDepthC if that condition is met.
+	nDepth := k.neighbourhoodDepth()
 	if k.nDepthC != nil {
-		nDepth := k.neighbourhoodDepth()
 		if nDepth != k.nDepth {
 			k.nDepth = nDepth
 			k.nDepthC <- nDepth
 		}
 	}
+	if nDepth != k.feedDepth {
+		k.feedDepth = nDepth
+		k.queueEvent(func() { k.depthFeed.Send(nDepth) })
+	}
+}
+
+// SubscribeDepth notifies the channel of the new neighbourhood depth on each
+// change, so that the subsystems depending on the nearest neighbours, like
+// syncing, can follow it immediately
+// Unlike NeighbourhoodDepthC, it can have any number of subscribers
+// The subscribers must not call the kademlia table while receiving the depth
+func (k *Kademlia) SubscribeDepth(ch chan<- int) event.Subscription {
+	return k.depthFeed.Subscribe(ch)
 }
 
 // AddrCountC returns the channel that sends a new
@@ -358,6 +563,7 @@ func (k *Kademlia) AddrCountC() <-chan int {
 
 // Off removes a peer from among live peers
 func (k *Kademlia) Off(p OverlayConn) {
+	defer k.flushEvents()
 	k.lock.Lock()
 	defer k.lock.Unlock()
 	var del bool
@@ -367,7 +573,9 @@ func (k *Kademlia) Off(p OverlayConn) {
 			panic(fmt.Sprintf("connected peer not found %v", p))
 		}
 		del = true
-		return newEntry(p.Off())
+		e := newEntry(p.Off())
+		e.dialed = v.(*entry).dialed
+		return e
 	})
 
 	if del {
@@ -379,10 +587,85 @@ func (k *Kademlia) Off(p OverlayConn) {
 		if k.addrCountC != nil {
 			k.addrCountC <- k.addrs.Size()
 		}
+		k.updateMetrics()
 		k.sendNeighbourhoodDepthChange()
+		k.sendPeerEvent(p, PeerEventTypeDrop)
+		k.sendNNEvents()
 	}
 }
 
+// SubscribePeers notifies the channel of every peer added to the kademlia
+// table, connecting to it, disconnecting from it or becoming a nearest
+// neighbour
+// The subscribers must not call the kademlia table while receiving the events
+func (k *Kademlia) SubscribePeers(ch chan<- *PeerEvent) event.Subscription {
+	return k.peerFeed.Subscribe(ch)
+}
+
+// sendPeerEvent notifies the subscribers of the peer lifecycle event once the
+// lock is released; must be called with the lock held
+func (k *Kademlia) sendPeerEvent(p OverlayPeer, t PeerEventType) {
+	e := &PeerEvent{
+		Type:  t,
+		Addr:  p.Address(),
+		Peers: k.conns.Size(),
+		Depth: k.neighbourhoodDepth(),
+	}
+	k.queueEvent(func() { k.peerFeed.Send(e) })
+}
+
+// queueEvent queues sending an event until flushEvents is called; must be
+// called with the lock held
+func (k *Kademlia) queueEvent(send func()) {
+	k.pending = append(k.pending, send)
+}
+
+// flushEvents sends the queued events in the order they were queued; it must
+// be called without the lock held, so that a subscriber slow to receive the
+// events does not block the kademlia table
+func (k *Kademlia) flushEvents() {
+	k.feedLock.Lock()
+	defer k.feedLock.Unlock()
+	k.lock.Lock()
+	pending := k.pending
+	k.pending = nil
+	k.lock.Unlock()
+	for _, send := range pending {
+		send()
+	}
+}
+
+// sendNNEvents notifies the subscribers of the connected peers that became
+// nearest neighbours since the last call; must be called with the lock held
+func (k *Kademlia) sendNNEvents() {
+	depth := k.neighbourhoodDepth()
+	nns := make(map[string]bool)
+	var promoted []OverlayPeer
+	k.conns.EachNeighbour(k.base, pof, func(val pot.Val, po int) bool {
+		if po < depth {
+			return false
+		}
+		e := val.(*entry)
+		nns[string(e.Address())] = true
+		if !k.nns[string(e.Address())] {
+			promoted = append(promoted, e)
+		}
+		return true
+	})
+	k.nns = nns
+	for _, p := range promoted {
+		k.sendPeerEvent(p, PeerEventTypeNN)
+	}
+}
+
+// updateMetrics reports the number of live peers, known addresses and the
+// saturation depth; must be called with the lock held
+func (k *Kademlia) updateMetrics() {
+	kademliaPeersGauge.Update(int64(k.conns.Size()))
+	kademliaAddrsGauge.Update(int64(k.addrs.Size()))
+	kademliaDepthGauge.Update(int64(k.depth))
+}
+
 func (k *Kademlia) EachBin(base []byte, pof pot.Pof, o int, eachBinFunc func(conn OverlayConn, po int) bool) {
 	k.lock.RLock()
 	defer k.lock.RUnlock()
@@ -432,6 +715,83 @@ func (k *Kademlia) eachConn(base []byte, o int, f func(OverlayConn, int, bool) b
 	})
 }
 
+// EachConnInRange is an iterator like EachConn restricted to the live peers
+// that have proximity order between minPo and maxPo inclusive as measured from
+// the base, visiting the closest first and at most max of them unless max is 0
+// if base is nil, kademlia base address is used
+func (k *Kademlia) EachConnInRange(base []byte, minPo, maxPo, max int, f func(OverlayConn, int, bool) bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	if len(base) == 0 {
+		base = k.base
+	}
+	depth := k.neighbourhoodDepth()
+	eachInRange(k.conns, base, minPo, maxPo, max, func(e *entry, po int) bool {
+		return f(e.conn(), po, po >= depth)
+	})
+}
+
+// EachAddrInRange is an iterator like EachAddr restricted to the known peers
+// that have proximity order between minPo and maxPo inclusive as measured from
+// the base, visiting the closest first and at most max of them unless max is 0
+// if base is nil, kademlia base address is used
+func (k *Kademlia) EachAddrInRange(base []byte, minPo, maxPo, max int, f func(OverlayAddr, int, bool) bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	if len(base) == 0 {
+		base = k.base
+	}
+	depth := k.neighbourhoodDepth()
+	eachInRange(k.addrs, base, minPo, maxPo, max, func(e *entry, po int) bool {
+		return f(e.addr(), po, po >= depth)
+	})
+}
+
+// eachInRange applies f to the entries of the pot with proximity order to the
+// base between minPo and maxPo, at most max of them unless max is 0
+func eachInRange(p *pot.Pot, base []byte, minPo, maxPo, max int, f func(*entry, int) bool) {
+	var n int
+	p.EachNeighbour(base, pof, func(val pot.Val, po int) bool {
+		if po > maxPo {
+			return true
+		}
+		if po < minPo {
+			return false
+		}
+		n++
+		return f(val.(*entry), po) && (max == 0 || n < max)
+	})
+}
+
+// EachConnByLatency is an iterator like the EachConn of the overlay except
+// that the peers with the same proximity order are visited in the order of
+// their latency, lowest first
+// peers with unknown (0) latency are visited first so that they get measured
+func EachConnByLatency(o Overlay, base []byte, po int, latency func(OverlayConn) time.Duration, f func(OverlayConn, int, bool) bool) {
+	type conn struct {
+		conn    OverlayConn
+		po      int
+		nn      bool
+		latency time.Duration
+	}
+	var conns []conn
+	o.EachConn(base, po, func(p OverlayConn, po int, nn bool) bool {
+		conns = append(conns, conn{p, po, nn, latency(p)})
+		return true
+	})
+	sort.SliceStable(conns, func(i, j int) bool {
+		if conns[i].po != conns[j].po {
+			return conns[i].po > conns[j].po
+		}
+		return conns[i].latency < conns[j].latency
+	})
+	for _, c := range conns {
+		if !f(c.conn, c.po, c.nn) {
+			return
+		}
+	}
+}
+
 // EachAddr called with (base, po, f) is an iterator applying f to each known peer
 // that has proximity order po or less as measured from the base
 // if base is nil, kademlia base address is used
@@ -479,18 +839,13 @@ func (k *Kademlia) callable(val pot.Val) OverlayAddr {
 	if e.conn() != nil || e.retries > k.MaxRetries {
 		return nil
 	}
-	// calculate the allowed number of retries based on time lapsed since last seen
-	timeAgo := int64(time.Since(e.seenAt))
-	div := int64(k.RetryExponent)
-	div += (150000 - rand.Int63n(300000)) * div / 1000000
-	var retries int
-	for delta := timeAgo; delta > k.RetryInterval; delta /= div {
-		retries++
-	}
-	// this is never called concurrently, so safe to increment
 	// peer can be retried again
-	if retries < e.retries {
-		log.Trace(fmt.Sprintf("%08x: %v long time since last try (at %v) needed before retry %v, wait only warrants %v", k.BaseAddr()[:4], e, timeAgo, e.retries, retries))
+	if !k.retryDue(e) {
+		return nil
+	}
+	// the peer failed to connect too many times, it is removed by Prune
+	if k.MaxDialFailures > 0 && e.retries >= k.MaxDialFailures {
+		log.Trace(fmt.Sprintf("%08x: peer %v failed to connect %d times", k.BaseAddr()[:4], e, e.retries))
 		return nil
 	}
 	// function to sanction or prevent suggesting a peer
@@ -498,12 +853,36 @@ func (k *Kademlia) callable(val pot.Val) OverlayAddr {
 		log.Trace(fmt.Sprintf("%08x: peer %v is temporarily not callable", k.BaseAddr()[:4], e))
 		return nil
 	}
+	// this is never called concurrently, so safe to increment
 	e.retries++
 	log.Trace(fmt.Sprintf("%08x: peer %v is callable", k.BaseAddr()[:4], e))
 
 	return e.addr()
 }
 
+// retryDue tells if the time lapsed since the peer was last seen warrants
+// another dial, the interval grows exponentially with the number of retries
+func (k *Kademlia) retryDue(e *entry) bool {
+	timeAgo := int64(time.Since(e.seenAt))
+	div := int64(k.RetryExponent)
+	div += (150000 - rand.Int63n(300000)) * div / 1000000
+	var retries int
+	for delta := timeAgo; delta > k.RetryInterval; delta /= div {
+		retries++
+	}
+	if retries < e.retries {
+		log.Trace(fmt.Sprintf("%08x: %v long time since last try (at %v) needed before retry %v, wait only warrants %v", k.BaseAddr()[:4], e, timeAgo, e.retries, retries))
+		return false
+	}
+	return true
+}
+
+// failed tells if the peer failed to connect MaxDialFailures times in a row,
+// the latest dial is only counted once the next one would be due
+func (k *Kademlia) failed(e *entry) bool {
+	return k.MaxDialFailures > 0 && e.retries >= k.MaxDialFailures && k.retryDue(e)
+}
+
 // BaseAddr return the kademlia base address
 func (k *Kademlia) BaseAddr() []byte {
 	return k.base
@@ -587,8 +966,8 @@ func (k *Kademlia) string() string {
 	return "\n" + strings.Join(rows, "\n")
 }
 
-// PeerPot keeps info about expected nearest neighbours and empty bins
-// used for testing only
+// PeerPot keeps info about expected nearest neighbours and empty bins of a
+// node given all the nodes of the network, see NewPeerPotMap
 type PeerPot struct {
 	NNSet     [][]byte
 	EmptyBins []int
@@ -639,12 +1018,12 @@ func NewPeerPotMap(kadMinProxSize int, addrs [][]byte) map[string]*PeerPot {
 }
 
 // saturation returns the lowest proximity order that the bin for that order
-// has less than n peers
-func (k *Kademlia) saturation(n int) int {
+// has less than its minimum number of peers
+func (k *Kademlia) saturation() int {
 	prev := -1
 	k.addrs.EachBin(k.base, pof, 0, func(po, size int, f func(func(val pot.Val, i int) bool) bool) bool {
 		prev++
-		return prev == po && size >= n
+		return prev == po && size >= k.MinBinSizeAt(po)
 	})
 	depth := k.neighbourhoodDepth()
 	if depth < prev {
@@ -741,9 +1120,17 @@ type Health struct {
 	CountNN    int      // amount of nearest neighbors connected to
 	CulpritsNN [][]byte // which known NNs are missing
 	Full       bool     // whether node has a peer in each kademlia bin (where there is such a peer)
+	Depth      int      // neighbourhood depth
+	Saturation int      // saturation depth, see Saturation
 	Hive       string
 }
 
+// Healthy tells if the node knows and is connected to all its nearest
+// neighbours and has a peer in each bin, ie. it is in the network
+func (h *Health) Healthy() bool {
+	return h.KnowNN && h.GotNN && h.Full
+}
+
 // Healthy reports the health state of the kademlia connectivity
 // returns a Health struct
 func (k *Kademlia) Healthy(pp *PeerPot) *Health {
@@ -753,7 +1140,57 @@ func (k *Kademlia) Healthy(pp *PeerPot) *Health {
 	knownn := k.knowNearestNeighbours(pp.NNSet)
 	full := k.full(pp.EmptyBins)
 	log.Trace(fmt.Sprintf("%08x: healthy: knowNNs: %v, gotNNs: %v, full: %v\n", k.BaseAddr()[:4], knownn, gotnn, full))
-	return &Health{knownn, gotnn, countnn, culpritsnn, full, k.string()}
+	return &Health{
+		KnowNN:     knownn,
+		GotNN:      gotnn,
+		CountNN:    countnn,
+		CulpritsNN: culpritsnn,
+		Full:       full,
+		Depth:      k.neighbourhoodDepth(),
+		Saturation: k.saturation(),
+		Hive:       k.string(),
+	}
+}
+
+// Saturation of the Kademlia, it can be told without knowing the other nodes
+// of the network unlike its Health
+type Saturation struct {
+	Depth      int   `json:"depth"`      // neighbourhood depth
+	Saturation int   `json:"saturation"` // proximity order up to which each bin has its minimum number of known peers, at most the depth
+	Bins       []int `json:"bins"`       // number of peers connected in each bin shallower than the depth
+	CountNN    int   `json:"countNN"`    // number of nearest neighbours connected
+	Peers      int   `json:"peers"`      // number of peers connected
+	Addrs      int   `json:"addrs"`      // number of peer addresses known
+	Saturated  bool  `json:"saturated"`  // whether each bin shallower than the depth has its minimum number of peers connected and MinProxBinSize nearest neighbours are connected
+}
+
+// Saturation reports the saturation of the kademlia connectivity
+func (k *Kademlia) Saturation() *Saturation {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	depth := k.neighbourhoodDepth()
+	s := &Saturation{
+		Depth:      depth,
+		Saturation: k.saturation(),
+		Bins:       make([]int, depth),
+		Peers:      k.conns.Size(),
+		Addrs:      k.addrs.Size(),
+	}
+	k.conns.EachBin(k.base, pof, 0, func(po, size int, _ func(func(val pot.Val, i int) bool) bool) bool {
+		if po < depth {
+			s.Bins[po] = size
+		} else {
+			s.CountNN += size
+		}
+		return true
+	})
+	s.Saturated = s.CountNN >= k.MinProxBinSize
+	for po, size := range s.Bins {
+		if size < k.MinBinSizeAt(po) {
+			s.Saturated = false
+		}
+	}
+	return s
 }
 
 func logEmptyBins(ebs []int) string {
@@ -763,3 +1200,86 @@ func logEmptyBins(ebs []int) string {
 	}
 	return strings.Join(ebss, ", ")
 }
+
+// TablePeer is a peer in the dump of the kademlia table
+type TablePeer struct {
+	Addr         hexutil.Bytes `json:"addr"`                   // overlay address
+	Underlay     string        `json:"underlay,omitempty"`     // underlay address if known
+	Connected    bool          `json:"connected"`              // whether the peer is connected
+	NN           bool          `json:"nn"`                     // whether the peer is within the neighbourhood depth
+	SeenAt       time.Time     `json:"seenAt"`                 // when the peer was registered, connected or dropped
+	Retries      int           `json:"retries"`                // number of times the peer was suggested to connect to
+	Dialed       bool          `json:"dialed"`                 // whether the peer was connected to by dialing it
+	Capabilities string        `json:"capabilities,omitempty"` // capabilities advertised by a connected peer
+}
+
+// TableBin is a proximity order bin in the dump of the kademlia table
+type TableBin struct {
+	PO      int          `json:"po"`      // proximity order of the peers to the base address
+	Conns   int          `json:"conns"`   // number of connected peers
+	MinSize int          `json:"minSize"` // minimum number of peers in the bin
+	MaxSize int          `json:"maxSize"` // maximum number of peers in the bin
+	Peers   []*TablePeer `json:"peers"`   // known peers, connected or not
+}
+
+// Table is the dump of the kademlia table, all the known peers by bin
+// together with their connection state
+type Table struct {
+	Base  hexutil.Bytes `json:"base"`  // base address of the table
+	Depth int           `json:"depth"` // neighbourhood depth
+	Conns int           `json:"conns"` // number of peers connected
+	Addrs int           `json:"addrs"` // number of peer addresses known
+	Bins  []*TableBin   `json:"bins"`  // the non empty bins, shallowest first
+	Hive  string        `json:"hive"`  // the table displayed with ascii as by String
+}
+
+// Table dumps the kademlia table with all the known peers
+func (k *Kademlia) Table() *Table {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	depth := k.neighbourhoodDepth()
+	t := &Table{
+		Base:  k.base,
+		Depth: depth,
+		Conns: k.conns.Size(),
+		Addrs: k.addrs.Size(),
+		Hive:  k.string(),
+	}
+	k.addrs.EachBin(k.base, pof, 0, func(po, _ int, f func(func(val pot.Val, i int) bool) bool) bool {
+		bin := &TableBin{
+			PO:      po,
+			MinSize: k.MinBinSizeAt(po),
+			MaxSize: k.MaxBinSizeAt(po),
+		}
+		f(func(val pot.Val, _ int) bool {
+			p := newTablePeer(val.(*entry))
+			p.NN = po >= depth
+			if p.Connected {
+				bin.Conns++
+			}
+			bin.Peers = append(bin.Peers, p)
+			return true
+		})
+		t.Bins = append(t.Bins, bin)
+		return true
+	})
+	return t
+}
+
+// newTablePeer returns the dump of the kademlia table entry
+func newTablePeer(e *entry) *TablePeer {
+	p := &TablePeer{
+		Addr:      e.Address(),
+		Connected: e.conn() != nil,
+		SeenAt:    e.seenAt,
+		Retries:   e.retries,
+		Dialed:    e.dialed,
+	}
+	if a, ok := e.OverlayPeer.(interface{ Under() []byte }); ok {
+		p.Underlay = string(a.Under())
+	}
+	if c, ok := e.OverlayPeer.(interface{ Capabilities() Capabilities }); ok {
+		p.Capabilities = c.Capabilities().String()
+	}
+	return p
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sort"
 	"testing"
 	"time"
 
@@ -123,6 +124,153 @@ func binStr(a OverlayPeer) string {
 	return pot.ToBin(a.Address())[:8]
 }
 
+// TestKademliaSaturation tests that the saturation of the kademlia reports
+// the peers connected in each bin and whether all bins have enough of them
+func TestKademliaSaturation(t *testing.T) {
+	k := newTestKademlia("00000000").On(
+		"10000000",
+		"01000000",
+		"00010000", "00011000",
+	)
+	s := k.Saturation()
+	if s.Depth != 3 || s.CountNN != 2 || s.Peers != 4 || s.Addrs != 4 {
+		t.Fatalf("unexpected saturation %+v", s)
+	}
+	if fmt.Sprint(s.Bins) != "[1 1 0]" || s.Saturated {
+		t.Fatalf("expected bin 2 to be empty and the kademlia not saturated, got %+v", s)
+	}
+
+	k.On("00100000")
+	s = k.Saturation()
+	if fmt.Sprint(s.Bins) != "[1 1 1]" || !s.Saturated {
+		t.Fatalf("expected the kademlia to be saturated, got %+v", s)
+	}
+}
+
+// TestKademliaBinSizes tests that the minimum number of peers can be set for
+// each bin
+func TestKademliaBinSizes(t *testing.T) {
+	k := newTestKademlia("00000000").On(
+		"10000000",
+		"01000000",
+		"00100000", "00010000",
+	).Register("11000000")
+	k.MinBinSizes = []int{2}
+	k.MaxBinSizes = []int{8, 6}
+
+	if k.MinBinSizeAt(0) != 2 || k.MinBinSizeAt(1) != 1 || k.MaxBinSizeAt(1) != 6 || k.MaxBinSizeAt(2) != 4 {
+		t.Fatalf("incorrect bin sizes %v, %v", k.MinBinSizes, k.MaxBinSizes)
+	}
+	if s := k.Saturation(); s.Saturated {
+		t.Fatalf("expected bin 0 not to be saturated, got %+v", s)
+	}
+	if err := testSuggestPeer(t, k, "11000000", 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	k.On("11000000")
+	if s := k.Saturation(); !s.Saturated {
+		t.Fatalf("expected the kademlia to be saturated, got %+v", s)
+	}
+	if err := testSuggestPeer(t, k, "<nil>", 0, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestEachConnByLatency tests that the peers with the same proximity order
+// are iterated lowest latency first, the ones not measured yet before them
+func TestEachConnByLatency(t *testing.T) {
+	k := newTestKademlia("00000000").On(
+		"10000000", "11000000", "10100000",
+		"00010000",
+	)
+	latencies := map[string]time.Duration{
+		"10000000": 30 * time.Millisecond,
+		"11000000": 10 * time.Millisecond,
+		"00010000": 50 * time.Millisecond,
+	}
+	latency := func(p OverlayConn) time.Duration {
+		return latencies[binStr(p)]
+	}
+	var peers []string
+	EachConnByLatency(k.Kademlia, nil, 255, latency, func(p OverlayConn, po int, _ bool) bool {
+		peers = append(peers, fmt.Sprintf("%v:%d", binStr(p), po))
+		return true
+	})
+	exp := "[00010000:3 10100000:0 11000000:0 10000000:0]"
+	if fmt.Sprint(peers) != exp {
+		t.Fatalf("incorrect order of peers. expected %v, got %v", exp, peers)
+	}
+}
+
+// TestEachInRange tests that the iteration over the connected and the known
+// peers can be restricted to a range of proximity orders and a number of peers
+func TestEachInRange(t *testing.T) {
+	k := newTestKademlia("00000000").On(
+		"10000000", "11000000",
+		"01000000",
+		"00100000",
+		"00010000",
+	).Register("01100000", "00001000")
+
+	for _, c := range []struct {
+		minPo, maxPo, max int
+		conns, addrs      string
+	}{
+		{0, 255, 0, "[00010000:3 00100000:2 01000000:1 10000000:0 11000000:0]", "[00001000:4 00010000:3 00100000:2 01000000:1 01100000:1 10000000:0 11000000:0]"},
+		{1, 2, 0, "[00100000:2 01000000:1]", "[00100000:2 01000000:1 01100000:1]"},
+		{0, 3, 2, "[00010000:3 00100000:2]", "[00010000:3 00100000:2]"},
+		{4, 255, 0, "[]", "[00001000:4]"},
+	} {
+		var conns, addrs []string
+		k.EachConnInRange(nil, c.minPo, c.maxPo, c.max, func(p OverlayConn, po int, _ bool) bool {
+			conns = append(conns, fmt.Sprintf("%v:%d", binStr(p), po))
+			return true
+		})
+		k.EachAddrInRange(nil, c.minPo, c.maxPo, c.max, func(p OverlayAddr, po int, _ bool) bool {
+			addrs = append(addrs, fmt.Sprintf("%v:%d", binStr(p), po))
+			return true
+		})
+		if fmt.Sprint(conns) != c.conns {
+			t.Fatalf("range %d-%d max %d: expected connected peers %v, got %v", c.minPo, c.maxPo, c.max, c.conns, conns)
+		}
+		if fmt.Sprint(addrs) != c.addrs {
+			t.Fatalf("range %d-%d max %d: expected known peers %v, got %v", c.minPo, c.maxPo, c.max, c.addrs, addrs)
+		}
+	}
+}
+
+// TestKademliaTable tests that the dump of the kademlia table lists the known
+// peers by bin with their connection state
+func TestKademliaTable(t *testing.T) {
+	k := newTestKademlia("00000000").On(
+		"10000000",
+		"00010000", "00011000",
+	).Register("11000000", "01000000")
+
+	tab := k.Table()
+	if tab.Depth != 3 || tab.Conns != 3 || tab.Addrs != 5 || tab.Hive == "" {
+		t.Fatalf("unexpected table %+v", tab)
+	}
+	if len(tab.Bins) != 3 {
+		t.Fatalf("expected 3 bins, got %d", len(tab.Bins))
+	}
+	for i, exp := range []struct{ po, peers, conns int }{{0, 2, 1}, {1, 1, 0}, {3, 2, 2}} {
+		bin := tab.Bins[i]
+		if bin.PO != exp.po || len(bin.Peers) != exp.peers || bin.Conns != exp.conns {
+			t.Fatalf("bin %d: expected po %d with %d peers %d connected, got po %d with %d peers %d connected", i, exp.po, exp.peers, exp.conns, bin.PO, len(bin.Peers), bin.Conns)
+		}
+		for _, p := range bin.Peers {
+			if p.NN != (exp.po >= 3) {
+				t.Fatalf("expected peer %x nearest neighbour: %v", p.Addr, exp.po >= 3)
+			}
+			if len(p.Underlay) == 0 {
+				t.Fatalf("expected the underlay address of peer %x", p.Addr)
+			}
+		}
+	}
+}
+
 func TestSuggestPeerBug(t *testing.T) {
 	// 2 row gap, unsaturated proxbin, no callables -> want PO 0
 	k := newTestKademlia("00000000").On(
@@ -348,6 +496,124 @@ func TestSuggestPeerRetries(t *testing.T) {
 
 }
 
+// testDialedPeer is a test peer connected either by dialing it or by the peer
+// dialing in
+type testDialedPeer struct {
+	Peer
+	dialed bool
+}
+
+func (p *testDialedPeer) Dialed() bool {
+	return p.dialed
+}
+
+// TestSuggestPeerDialed tests that the peers connected to by dialing them are
+// suggested before the ones only connected inbound or never connected
+func TestSuggestPeerDialed(t *testing.T) {
+	k := newTestKademlia("00000000").Register("10000000")
+	for _, p := range []*testDialedPeer{
+		{k.newTestKadPeer("10100000"), false},
+		{k.newTestKadPeer("11000000"), true},
+	} {
+		k.Kademlia.On(p)
+		k.Kademlia.Off(p)
+	}
+
+	dialed := make(map[string]bool)
+	for _, bin := range k.Table().Bins {
+		for _, p := range bin.Peers {
+			dialed[pot.ToBin(p.Addr)[:8]] = p.Dialed
+		}
+	}
+	if !dialed["11000000"] || dialed["10100000"] || dialed["10000000"] {
+		t.Fatalf("expected only the outbound peer to be dialed, got %v", dialed)
+	}
+
+	if err := testSuggestPeer(t, k, "11000000", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	addr, _, _ := k.SuggestPeer()
+	if addr == nil || binStr(addr) == "11000000" {
+		t.Fatalf("expected a peer not dialed to be suggested, got %v", binStr(addr))
+	}
+}
+
+// TestKademliaPrune tests that the known peers not seen for long are pruned
+// unless connected or too few peers are known in their bin
+func TestKademliaPrune(t *testing.T) {
+	k := newTestKademlia("00000000").Register(
+		"10000000", "11000000", "10100000",
+		"01000000",
+	).On("00100000")
+	k.PruneAge = time.Hour
+
+	age := func(addrs ...string) {
+		k.addrs.Each(func(val pot.Val, _ int) bool {
+			e := val.(*entry)
+			for _, a := range addrs {
+				if binStr(e) == a {
+					e.seenAt = time.Now().Add(-2 * time.Hour)
+				}
+			}
+			return true
+		})
+	}
+	known := func() string {
+		var addrs []string
+		k.EachAddr(nil, 255, func(a OverlayAddr, _ int, _ bool) bool {
+			addrs = append(addrs, binStr(a))
+			return true
+		})
+		sort.Strings(addrs)
+		return fmt.Sprint(addrs)
+	}
+
+	if n := k.Prune(nil); n != 0 {
+		t.Fatalf("expected no peers to be pruned, got %d", n)
+	}
+	age("10000000", "10100000", "01000000", "00100000")
+	if n := k.Prune(nil); n != 2 {
+		t.Fatalf("expected 2 peers to be pruned, got %d", n)
+	}
+	exp := "[00100000 01000000 11000000]"
+	if known() != exp {
+		t.Fatalf("expected known peers %v, got %v", exp, known())
+	}
+	age("11000000")
+	if n := k.Prune(nil); n != 0 || known() != exp {
+		t.Fatalf("expected the last peer of each bin to be kept, got %d pruned and known peers %v", n, known())
+	}
+}
+
+// TestKademliaDialFailures tests that a peer failing to connect too many
+// times is no longer suggested and is removed by Prune
+func TestKademliaDialFailures(t *testing.T) {
+	k := newTestKademlia("00000000").Register("10000000")
+	k.MaxDialFailures = 3
+	k.RetryInterval = 0
+
+	for i := 0; i < k.MaxDialFailures; i++ {
+		if err := testSuggestPeer(t, k, "10000000", 0, false); err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+	}
+	if err := testSuggestPeer(t, k, "<nil>", 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// kept peers are not removed
+	keep := func(OverlayAddr) bool { return true }
+	if n := k.Prune(keep); n != 0 {
+		t.Fatalf("expected no kept peers to be pruned, got %d", n)
+	}
+	if n := k.Prune(nil); n != 1 {
+		t.Fatalf("expected the failed peer to be pruned, got %d", n)
+	}
+	if k.addrs.Size() != 0 {
+		t.Fatalf("expected no known peers, got %d", k.addrs.Size())
+	}
+}
+
 func TestKademliaHiveString(t *testing.T) {
 	k := newTestKademlia("00000000").On("01000000", "00100000").Register("10000000", "10000001")
 	k.MaxProxDisplay = 8
@@ -358,6 +624,124 @@ func TestKademliaHiveString(t *testing.T) {
 	}
 }
 
+func TestKademliaSubscribePeers(t *testing.T) {
+	k := newTestKademlia("00000000")
+	peerC := make(chan *PeerEvent, 16)
+	sub := k.SubscribePeers(peerC)
+	defer sub.Unsubscribe()
+
+	k.Register("10000000")
+	k.On("01000000", "00100000", "00010000").Off("00010000")
+
+	for i, exp := range []struct {
+		typ   PeerEventType
+		addr  string
+		peers int
+	}{
+		{PeerEventTypeAdd, "10000000", 0},
+		{PeerEventTypeAdd, "01000000", 1},
+		{PeerEventTypeConnect, "01000000", 1},
+		{PeerEventTypeNN, "01000000", 1},
+		{PeerEventTypeAdd, "00100000", 2},
+		{PeerEventTypeConnect, "00100000", 2},
+		{PeerEventTypeNN, "00100000", 2},
+		{PeerEventTypeAdd, "00010000", 3},
+		{PeerEventTypeConnect, "00010000", 3},
+		{PeerEventTypeNN, "00010000", 3},
+		{PeerEventTypeDrop, "00010000", 2},
+		// the depth decreases, bringing back the peer into the neighbourhood
+		{PeerEventTypeNN, "01000000", 2},
+	} {
+		select {
+		case e := <-peerC:
+			if e.Type != exp.typ {
+				t.Fatalf("event %d: expected type %v, got %v", i, exp.typ, e.Type)
+			}
+			if !bytes.Equal(e.Addr, testKadPeerAddr(exp.addr).Address()) {
+				t.Fatalf("event %d: expected address of %s, got %x", i, exp.addr, e.Addr)
+			}
+			if e.Peers != exp.peers {
+				t.Fatalf("event %d: expected %d peers, got %d", i, exp.peers, e.Peers)
+			}
+		default:
+			t.Fatalf("event %d: not sent", i)
+		}
+	}
+	select {
+	case e := <-peerC:
+		t.Fatalf("unexpected event %+v", e)
+	default:
+	}
+}
+
+func TestKademliaSubscribeDepth(t *testing.T) {
+	k := newTestKademlia("00000000")
+	depthC := make(chan int, 8)
+	sub := k.SubscribeDepth(depthC)
+	defer sub.Unsubscribe()
+
+	// the depth does not change with the peers in the shallowest bins
+	k.On("10000000", "01000000")
+	k.On("00100000", "00010000").Off("00010000", "00100000")
+
+	for i, exp := range []int{1, 2, 1, 0} {
+		select {
+		case depth := <-depthC:
+			if depth != exp {
+				t.Fatalf("change %d: expected depth %d, got %d", i, exp, depth)
+			}
+		default:
+			t.Fatalf("change %d: not sent", i)
+		}
+	}
+	select {
+	case depth := <-depthC:
+		t.Fatalf("unexpected depth change to %d", depth)
+	default:
+	}
+}
+
+// TestKademliaSlowSubscriber tests that the table is not locked while a
+// subscriber is slow to receive the events
+func TestKademliaSlowSubscriber(t *testing.T) {
+	k := newTestKademlia("00000000")
+	peerC := make(chan *PeerEvent)
+	sub := k.SubscribePeers(peerC)
+	defer sub.Unsubscribe()
+
+	onC := make(chan struct{})
+	go func() {
+		k.On("01000000")
+		close(onC)
+	}()
+
+	// the event is not received yet, but the peer is on
+	done := make(chan struct{})
+	go func() {
+		for k.Saturation().Peers != 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("kademlia blocked by the subscriber")
+	}
+
+	for _, exp := range []PeerEventType{PeerEventTypeAdd, PeerEventTypeConnect, PeerEventTypeNN} {
+		select {
+		case e := <-peerC:
+			if e.Type != exp {
+				t.Fatalf("expected type %v, got %v", exp, e.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %v not sent", exp)
+		}
+	}
+	<-onC
+}
+
 // testKademliaCase constructs the kademlia and PeerPot map to validate
 // the SuggestPeer and Healthy methods for provided hex-encoded addresses.
 // Argument pivotAddr is the address of the kademlia.
@@ -395,7 +779,7 @@ func testKademliaCase(t *testing.T, pivotAddr string, addrs ...string) {
 	}
 
 	h := k.Healthy(pp)
-	if !(h.GotNN && h.KnowNN && h.Full) {
+	if !h.Healthy() {
 		t.Error("not healthy")
 	}
 }
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -44,7 +45,7 @@ const (
 // BzzSpec is the spec of the generic swarm handshake
 var BzzSpec = &protocols.Spec{
 	Name:       "bzz",
-	Version:    3,
+	Version:    4,
 	MaxMsgSize: 10 * 1024 * 1024,
 	Messages: []interface{}{
 		HandshakeMsg{},
@@ -62,6 +63,38 @@ var DiscoverySpec = &protocols.Spec{
 	},
 }
 
+// Capabilities is the bitfield of the services a node offers to its peers,
+// advertised in the bzz handshake
+type Capabilities uint64
+
+const (
+	CapabilityFull     Capabilities = 1 << iota // stores the chunks in its neighbourhood, unlike a light node
+	CapabilityRetrieve                          // serves retrieve requests
+	CapabilityPushSync                          // offers the SYNC streams of its chunks
+	CapabilityPss                               // relays pss messages
+
+	// DefaultCapabilities are the capabilities of a full node
+	DefaultCapabilities = CapabilityFull | CapabilityRetrieve | CapabilityPushSync | CapabilityPss
+)
+
+var capabilityNames = []string{"full", "retrieve", "pushsync", "pss"}
+
+// Has tells if all the capabilities in c are offered
+func (caps Capabilities) Has(c Capabilities) bool {
+	return caps&c == c
+}
+
+// String lists the names of the capabilities
+func (caps Capabilities) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if caps.Has(1 << uint(i)) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
 // Addr interface that peerPool needs
 type Addr interface {
 	OverlayPeer
@@ -73,9 +106,10 @@ type Addr interface {
 
 // Peer interface represents an live peer connection
 type Peer interface {
-	Addr                   // the address of a peer
-	Conn                   // the live connection (protocols.Peer)
-	LastActive() time.Time // last time active
+	Addr                        // the address of a peer
+	Conn                        // the live connection (protocols.Peer)
+	LastActive() time.Time      // last time active
+	Capabilities() Capabilities // the services the peer offers
 }
 
 // Conn interface represents an live peer connection
@@ -94,12 +128,14 @@ type BzzConfig struct {
 	UnderlayAddr []byte // node's underlay address
 	HiveParams   *HiveParams
 	NetworkID    uint64
+	Capabilities Capabilities // services the node offers to its peers, DefaultCapabilities if 0
 }
 
 // Bzz is the swarm protocol bundle
 type Bzz struct {
 	*Hive
 	NetworkID    uint64
+	capabilities Capabilities
 	localAddr    *BzzAddr
 	mtx          sync.Mutex
 	handshakes   map[discover.NodeID]*HandshakeMsg
@@ -113,9 +149,14 @@ type Bzz struct {
 // * overlay driver
 // * peer store
 func NewBzz(config *BzzConfig, kad Overlay, store state.Store, streamerSpec *protocols.Spec, streamerRun func(*BzzPeer) error) *Bzz {
+	capabilities := config.Capabilities
+	if capabilities == 0 {
+		capabilities = DefaultCapabilities
+	}
 	return &Bzz{
 		Hive:         NewHive(config.HiveParams, kad, store),
 		NetworkID:    config.NetworkID,
+		capabilities: capabilities,
 		localAddr:    &BzzAddr{config.OverlayAddr, config.UnderlayAddr},
 		handshakes:   make(map[discover.NodeID]*HandshakeMsg),
 		streamerRun:  streamerRun,
@@ -205,10 +246,11 @@ func (b *Bzz) RunProtocol(spec *protocols.Spec, run func(*BzzPeer) error) func(*
 		}
 		// the handshake has succeeded so construct the BzzPeer and run the protocol
 		peer := &BzzPeer{
-			Peer:       protocols.NewPeer(p, rw, spec),
-			localAddr:  b.localAddr,
-			BzzAddr:    handshake.peerAddr,
-			lastActive: time.Now(),
+			Peer:         protocols.NewPeer(p, rw, spec),
+			localAddr:    b.localAddr,
+			BzzAddr:      handshake.peerAddr,
+			lastActive:   time.Now(),
+			capabilities: handshake.peerCapabilities,
 		}
 		return run(peer)
 	}
@@ -228,6 +270,7 @@ func (b *Bzz) performHandshake(p *protocols.Peer, handshake *HandshakeMsg) error
 		return err
 	}
 	handshake.peerAddr = rsh.(*HandshakeMsg).Addr
+	handshake.peerCapabilities = rsh.(*HandshakeMsg).Capabilities
 	return nil
 }
 
@@ -259,17 +302,21 @@ func (b *Bzz) runBzz(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 // BzzPeer is the bzz protocol view of a protocols.Peer (itself an extension of p2p.Peer)
 // implements the Peer interface and all interfaces Peer implements: Addr, OverlayPeer
 type BzzPeer struct {
-	*protocols.Peer           // represents the connection for online peers
-	localAddr       *BzzAddr  // local Peers address
-	*BzzAddr                  // remote address -> implements Addr interface = protocols.Peer
-	lastActive      time.Time // time is updated whenever mutexes are releasing
+	*protocols.Peer              // represents the connection for online peers
+	localAddr       *BzzAddr     // local Peers address
+	*BzzAddr                     // remote address -> implements Addr interface = protocols.Peer
+	lastActive      time.Time    // time is updated whenever mutexes are releasing
+	capabilities    Capabilities // capabilities advertised in the handshake
 }
 
+// NewBzzTestPeer returns a peer without the bzz handshake, offering the
+// default capabilities
 func NewBzzTestPeer(p *protocols.Peer, addr *BzzAddr) *BzzPeer {
 	return &BzzPeer{
-		Peer:      p,
-		localAddr: addr,
-		BzzAddr:   NewAddrFromNodeID(p.ID()),
+		Peer:         p,
+		localAddr:    addr,
+		BzzAddr:      NewAddrFromNodeID(p.ID()),
+		capabilities: DefaultCapabilities,
 	}
 }
 
@@ -283,20 +330,35 @@ func (p *BzzPeer) LastActive() time.Time {
 	return p.lastActive
 }
 
+// Dialed tells if the peer was connected to by dialing its underlay address,
+// as opposed to the peer dialing in
+func (p *BzzPeer) Dialed() bool {
+	return p.Peer != nil && !p.Inbound()
+}
+
+// Capabilities returns the services the peer advertised in the handshake
+func (p *BzzPeer) Capabilities() Capabilities {
+	return p.capabilities
+}
+
 /*
  Handshake
 
 * Version: 8 byte integer version of the protocol
 * NetworkID: 8 byte integer network identifier
 * Addr: the address advertised by the node including underlay and overlay connecctions
+* Capabilities: bitfield of the services the node offers
 */
 type HandshakeMsg struct {
-	Version   uint64
-	NetworkID uint64
-	Addr      *BzzAddr
+	Version      uint64
+	NetworkID    uint64
+	Addr         *BzzAddr
+	Capabilities Capabilities
 
 	// peerAddr is the address received in the peer handshake
 	peerAddr *BzzAddr
+	// peerCapabilities are the capabilities received in the peer handshake
+	peerCapabilities Capabilities
 
 	init chan bool
 	done chan struct{}
@@ -305,7 +367,7 @@ type HandshakeMsg struct {
 
 // String pretty prints the handshake
 func (bh *HandshakeMsg) String() string {
-	return fmt.Sprintf("Handshake: Version: %v, NetworkID: %v, Addr: %v", bh.Version, bh.NetworkID, bh.Addr)
+	return fmt.Sprintf("Handshake: Version: %v, NetworkID: %v, Addr: %v, Capabilities: %v", bh.Version, bh.NetworkID, bh.Addr, bh.Capabilities)
 }
 
 // Perform initiates the handshake and validates the remote handshake message
@@ -335,11 +397,12 @@ func (b *Bzz) GetHandshake(peerID discover.NodeID) (*HandshakeMsg, bool) {
 	handshake, found := b.handshakes[peerID]
 	if !found {
 		handshake = &HandshakeMsg{
-			Version:   uint64(BzzSpec.Version),
-			NetworkID: b.NetworkID,
-			Addr:      b.localAddr,
-			init:      make(chan bool, 1),
-			done:      make(chan struct{}),
+			Version:      uint64(BzzSpec.Version),
+			NetworkID:    b.NetworkID,
+			Addr:         b.localAddr,
+			Capabilities: b.capabilities,
+			init:         make(chan bool, 1),
+			done:         make(chan struct{}),
 		}
 		// when handhsake is first created for a remote peer
 		// it is initialised with the init
@@ -127,6 +127,7 @@ type bzzTester struct {
 	*p2ptest.ProtocolTester
 	addr *BzzAddr
 	cs   map[string]chan bool
+	bzz  *Bzz
 }
 
 func newBzzHandshakeTester(t *testing.T, n int, addr *BzzAddr) *bzzTester {
@@ -135,6 +136,7 @@ func newBzzHandshakeTester(t *testing.T, n int, addr *BzzAddr) *bzzTester {
 		UnderlayAddr: addr.Under(),
 		HiveParams:   NewHiveParams(),
 		NetworkID:    DefaultNetworkID,
+		Capabilities: DefaultCapabilities,
 	}
 	kad := NewKademlia(addr.OAddr, NewKadParams())
 	bzz := NewBzz(config, kad, nil, nil, nil)
@@ -144,6 +146,7 @@ func newBzzHandshakeTester(t *testing.T, n int, addr *BzzAddr) *bzzTester {
 	return &bzzTester{
 		addr:           addr,
 		ProtocolTester: s,
+		bzz:            bzz,
 	}
 }
 
@@ -186,9 +189,10 @@ func (s *bzzTester) testHandshake(lhs, rhs *HandshakeMsg, disconnects ...*p2ptes
 
 func correctBzzHandshake(addr *BzzAddr) *HandshakeMsg {
 	return &HandshakeMsg{
-		Version:   3,
-		NetworkID: DefaultNetworkID,
-		Addr:      addr,
+		Version:      4,
+		NetworkID:    DefaultNetworkID,
+		Addr:         addr,
+		Capabilities: DefaultCapabilities,
 	}
 }
 
@@ -199,7 +203,7 @@ func TestBzzHandshakeNetworkIDMismatch(t *testing.T) {
 
 	err := s.testHandshake(
 		correctBzzHandshake(addr),
-		&HandshakeMsg{Version: 3, NetworkID: 321, Addr: NewAddrFromNodeID(id)},
+		&HandshakeMsg{Version: 4, NetworkID: 321, Addr: NewAddrFromNodeID(id)},
 		&p2ptest.Disconnect{Peer: id, Error: fmt.Errorf("Handshake error: Message handler error: (msg code 0): network id mismatch 321 (!= 3)")},
 	)
 
@@ -216,7 +220,7 @@ func TestBzzHandshakeVersionMismatch(t *testing.T) {
 	err := s.testHandshake(
 		correctBzzHandshake(addr),
 		&HandshakeMsg{Version: 0, NetworkID: 3, Addr: NewAddrFromNodeID(id)},
-		&p2ptest.Disconnect{Peer: id, Error: fmt.Errorf("Handshake error: Message handler error: (msg code 0): version mismatch 0 (!= 3)")},
+		&p2ptest.Disconnect{Peer: id, Error: fmt.Errorf("Handshake error: Message handler error: (msg code 0): version mismatch 0 (!= 4)")},
 	)
 
 	if err != nil {
@@ -231,10 +235,53 @@ func TestBzzHandshakeSuccess(t *testing.T) {
 
 	err := s.testHandshake(
 		correctBzzHandshake(addr),
-		&HandshakeMsg{Version: 3, NetworkID: 3, Addr: NewAddrFromNodeID(id)},
+		&HandshakeMsg{Version: 4, NetworkID: 3, Addr: NewAddrFromNodeID(id)},
 	)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestBzzHandshakeCapabilities(t *testing.T) {
+	addr := RandomAddr()
+	s := newBzzHandshakeTester(t, 1, addr)
+	id := s.IDs[0]
+
+	caps := CapabilityRetrieve | CapabilityPss
+	err := s.testHandshake(
+		correctBzzHandshake(addr),
+		&HandshakeMsg{Version: 4, NetworkID: 3, Addr: NewAddrFromNodeID(id), Capabilities: caps},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs, found := s.bzz.GetHandshake(id)
+	if !found {
+		t.Fatal("expected the handshake of the peer to be kept")
+	}
+	if hs.peerCapabilities != caps {
+		t.Fatalf("expected peer capabilities %v, got %v", caps, hs.peerCapabilities)
+	}
+	if !caps.Has(CapabilityPss) || caps.Has(CapabilityFull) || caps.Has(CapabilityRetrieve|CapabilityPushSync) {
+		t.Fatalf("incorrect capabilities %v", caps)
+	}
+	if caps.String() != "retrieve,pss" {
+		t.Fatalf("expected capabilities retrieve,pss, got %v", caps)
+	}
+}
+
+func TestBzzDefaultCapabilities(t *testing.T) {
+	addr := RandomAddr()
+	config := &BzzConfig{
+		OverlayAddr:  addr.Over(),
+		UnderlayAddr: addr.Under(),
+		HiveParams:   NewHiveParams(),
+		NetworkID:    DefaultNetworkID,
+	}
+	bzz := NewBzz(config, NewKademlia(addr.OAddr, NewKadParams()), nil, nil, nil)
+	if bzz.capabilities != DefaultCapabilities {
+		t.Fatalf("expected default capabilities %v without configured ones, got %v", DefaultCapabilities, bzz.capabilities)
+	}
+}
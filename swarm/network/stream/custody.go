@@ -0,0 +1,213 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const custodyNonceSize = 32
+
+var (
+	// the time a challenged peer has to send the proof of custody
+	custodyTimeout = 10 * time.Second
+	// the maximum number of chunks in a custody challenge
+	maxCustodyHashes = 128
+	// the minimum time between the custody challenges answered for a peer
+	custodyChallengeInterval = time.Second
+
+	errCustodyTimeout = errors.New("timeout waiting for proof of custody")
+)
+
+// CustodyChallengeMsg is the protocol msg sent to challenge a peer to prove
+// that it still stores the chunks with the given hashes
+type CustodyChallengeMsg struct {
+	Hashes []byte
+	Nonce  []byte
+}
+
+// String pretty prints CustodyChallengeMsg
+func (m CustodyChallengeMsg) String() string {
+	return fmt.Sprintf("Hashes: %d, Nonce: %x", len(m.Hashes)/HashSize, m.Nonce)
+}
+
+// CustodyProofMsg is the protocol msg answering a CustodyChallengeMsg
+// an empty proof means the peer does not store all challenged chunks
+type CustodyProofMsg struct {
+	Nonce []byte
+	Proof []byte // custodyProof(Nonce, chunk data...)
+}
+
+// String pretty prints CustodyProofMsg
+func (m CustodyProofMsg) String() string {
+	return fmt.Sprintf("Nonce: %x, Proof: %x", m.Nonce, m.Proof)
+}
+
+// custodyChallenge is a challenge sent to a peer waiting for the proof
+type custodyChallenge struct {
+	expected []byte
+	errC     chan error
+}
+
+// custodyProof is the hash of the nonce and the data of the chunks
+// it can only be computed by a node storing all the chunks
+func custodyProof(db *storage.DBAPI, hashes []byte, nonce []byte) ([]byte, error) {
+	data := [][]byte{nonce}
+	for i := 0; i+HashSize <= len(hashes); i += HashSize {
+		chunk, err := db.Get(storage.Key(hashes[i : i+HashSize]))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk.SData)
+	}
+	return crypto.Keccak256(data...), nil
+}
+
+// ChallengeCustody challenges the peer to prove that it stores the chunks
+// with the given keys, the chunks have to be in the local store as well
+// it returns an error if the peer fails to send a valid proof in time
+func (r *Registry) ChallengeCustody(peerId discover.NodeID, keys []storage.Key) error {
+	peer := r.getPeer(peerId)
+	if peer == nil {
+		return fmt.Errorf("peer not found %v", peerId)
+	}
+	if len(keys) == 0 || len(keys) > maxCustodyHashes {
+		return fmt.Errorf("cannot challenge custody of %d chunks", len(keys))
+	}
+	hashes := make([]byte, 0, len(keys)*HashSize)
+	for _, key := range keys {
+		hashes = append(hashes, key...)
+	}
+	nonce := make([]byte, custodyNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	expected, err := custodyProof(r.delivery.db, hashes, nonce)
+	if err != nil {
+		return fmt.Errorf("cannot challenge custody: %v", err)
+	}
+
+	errC := peer.addCustodyChallenge(nonce, expected)
+	defer peer.removeCustodyChallenge(nonce)
+
+	metrics.GetOrRegisterCounter("peer.sendcustodychallenge", nil).Inc(1)
+	if err := peer.SendPriority(&CustodyChallengeMsg{Hashes: hashes, Nonce: nonce}, Top); err != nil {
+		return err
+	}
+	select {
+	case err := <-errC:
+		return err
+	case <-time.After(custodyTimeout):
+		r.peerFailed(peerId, failureTimeout)
+		return errCustodyTimeout
+	case <-peer.quit:
+		return fmt.Errorf("peer %v disconnected", peerId)
+	}
+}
+
+// handleCustodyChallengeMsg protocol msg handler computes the proof of
+// custody for the challenged chunks from the local store and sends it back
+// challenges arriving within custodyChallengeInterval of the previous one are not answered
+func (p *Peer) handleCustodyChallengeMsg(req *CustodyChallengeMsg) error {
+	metrics.GetOrRegisterCounter("peer.handlecustodychallengemsg", nil).Inc(1)
+
+	if len(req.Hashes) == 0 || len(req.Hashes)%HashSize != 0 {
+		return fmt.Errorf("invalid custody challenge hashes length %d", len(req.Hashes))
+	}
+	if n := len(req.Hashes) / HashSize; n > maxCustodyHashes {
+		return fmt.Errorf("custody challenge for %d chunks exceeds the limit of %d", n, maxCustodyHashes)
+	}
+	p.custodyMu.Lock()
+	now := time.Now()
+	limited := now.Sub(p.lastCustodyChallenge) < custodyChallengeInterval
+	if !limited {
+		p.lastCustodyChallenge = now
+	}
+	p.custodyMu.Unlock()
+	if limited {
+		metrics.GetOrRegisterCounter("peer.handlecustodychallengemsg.limited", nil).Inc(1)
+		log.Debug("custody challenge rate limited", "peer", p.ID())
+		return nil
+	}
+
+	proof, err := custodyProof(p.streamer.delivery.db, req.Hashes, req.Nonce)
+	if err != nil {
+		log.Debug("cannot prove custody", "peer", p.ID(), "err", err)
+		proof = nil
+	}
+	go func() {
+		if err := p.SendPriority(&CustodyProofMsg{Nonce: req.Nonce, Proof: proof}, Top); err != nil {
+			log.Warn("failed to send proof of custody", "peer", p.ID(), "err", err)
+		}
+	}()
+	return nil
+}
+
+// handleCustodyProofMsg protocol msg handler checks the proof against
+// the one expected for the pending challenge with the same nonce
+func (p *Peer) handleCustodyProofMsg(req *CustodyProofMsg) error {
+	metrics.GetOrRegisterCounter("peer.handlecustodyproofmsg", nil).Inc(1)
+
+	p.custodyMu.Lock()
+	c, ok := p.custody[string(req.Nonce)]
+	p.custodyMu.Unlock()
+	if !ok {
+		return fmt.Errorf("proof of custody for unknown challenge %x", req.Nonce)
+	}
+	var err error
+	if !bytes.Equal(req.Proof, c.expected) {
+		p.streamer.peerFailed(p.ID(), failureInvalidProof)
+		err = fmt.Errorf("invalid proof of custody from peer %v", p.ID())
+	}
+	log.Trace("received proof of custody", "peer", p.ID(), "nonce", fmt.Sprintf("%x", req.Nonce), "valid", err == nil)
+	select {
+	case c.errC <- err:
+	default:
+	}
+	return nil
+}
+
+// addCustodyChallenge records a pending challenge and returns the channel
+// the result of the verification of the proof is sent on
+func (p *Peer) addCustodyChallenge(nonce []byte, expected []byte) chan error {
+	p.custodyMu.Lock()
+	defer p.custodyMu.Unlock()
+
+	c := &custodyChallenge{
+		expected: expected,
+		errC:     make(chan error, 1),
+	}
+	p.custody[string(nonce)] = c
+	return c.errC
+}
+
+func (p *Peer) removeCustodyChallenge(nonce []byte) {
+	p.custodyMu.Lock()
+	defer p.custodyMu.Unlock()
+
+	delete(p.custody, string(nonce))
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+func TestCustodyChallengeMsgExchange(t *testing.T) {
+	defer func(interval time.Duration) { custodyChallengeInterval = interval }(custodyChallengeInterval)
+	custodyChallengeInterval = 0
+
+	tester, streamer, localStore, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerID := tester.IDs[0]
+
+	chunk := storage.NewChunk(storage.Key(hash0[:]), nil)
+	chunk.SData = hash0[:]
+	localStore.Put(chunk)
+	chunk.WaitToStore()
+
+	nonce := []byte("nonce")
+	expected, err := custodyProof(streamer.delivery.db, hash0[:], nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := crypto.Keccak256(nonce, hash0[:]); string(expected) != string(want) {
+		t.Fatalf("expected proof %x, got %x", want, expected)
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "CustodyChallengeMsg",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 11,
+				Msg: &CustodyChallengeMsg{
+					Hashes: hash0[:],
+					Nonce:  nonce,
+				},
+				Peer: peerID,
+			},
+		},
+		Expects: []p2ptest.Expect{
+			{
+				Code: 12,
+				Msg: &CustodyProofMsg{
+					Nonce: nonce,
+					Proof: expected,
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a missing chunk is answered with an empty proof
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "CustodyChallengeMsg missing chunk",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 11,
+				Msg: &CustodyChallengeMsg{
+					Hashes: hashes,
+					Nonce:  nonce,
+				},
+				Peer: peerID,
+			},
+		},
+		Expects: []p2ptest.Expect{
+			{
+				Code: 12,
+				Msg: &CustodyProofMsg{
+					Nonce: nonce,
+					Proof: []byte{},
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCustodyProofVerification(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerID := tester.IDs[0]
+	peer := streamer.getPeer(peerID)
+
+	if err := peer.handleCustodyProofMsg(&CustodyProofMsg{Nonce: []byte("unknown")}); err == nil {
+		t.Fatal("expected error for proof of unknown challenge")
+	}
+
+	nonce := []byte("nonce")
+	errC := peer.addCustodyChallenge(nonce, []byte("proof"))
+	if err := peer.handleCustodyProofMsg(&CustodyProofMsg{Nonce: nonce, Proof: []byte("proof")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errC; err != nil {
+		t.Fatalf("expected valid proof, got %v", err)
+	}
+
+	if err := peer.handleCustodyProofMsg(&CustodyProofMsg{Nonce: nonce, Proof: []byte("other")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errC; err == nil {
+		t.Fatal("expected error for invalid proof")
+	}
+	if score := streamer.scores.get(peerID); score == nil || score.InvalidProofs != 1 {
+		t.Fatalf("expected one invalid proof recorded, got %+v", score)
+	}
+	peer.removeCustodyChallenge(nonce)
+
+	if err := streamer.ChallengeCustody(peerID, []storage.Key{storage.Key(hash0[:])}); err == nil {
+		t.Fatal("expected error for chunk missing from the local store")
+	}
+}
+
+func TestCustodyChallengeLimits(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerID := tester.IDs[0]
+	peer := streamer.getPeer(peerID)
+	nonce := []byte("nonce")
+
+	if err := peer.handleCustodyChallengeMsg(&CustodyChallengeMsg{Hashes: hash0[:HashSize-1], Nonce: nonce}); err == nil {
+		t.Fatal("expected error for hashes not a multiple of the hash size")
+	}
+	if err := peer.handleCustodyChallengeMsg(&CustodyChallengeMsg{Nonce: nonce}); err == nil {
+		t.Fatal("expected error for challenge without hashes")
+	}
+	hashes := make([]byte, (maxCustodyHashes+1)*HashSize)
+	if err := peer.handleCustodyChallengeMsg(&CustodyChallengeMsg{Hashes: hashes, Nonce: nonce}); err == nil {
+		t.Fatal("expected error for too many hashes")
+	}
+	keys := make([]storage.Key, maxCustodyHashes+1)
+	for i := range keys {
+		keys[i] = storage.Key(hash0[:])
+	}
+	if err := streamer.ChallengeCustody(peerID, keys); err == nil {
+		t.Fatal("expected error for challenging too many chunks")
+	}
+
+	// only the first of two challenges in quick succession is answered
+	lastChallenge := func() time.Time {
+		peer.custodyMu.Lock()
+		defer peer.custodyMu.Unlock()
+		return peer.lastCustodyChallenge
+	}
+	if err := peer.handleCustodyChallengeMsg(&CustodyChallengeMsg{Hashes: hash0[:], Nonce: nonce}); err != nil {
+		t.Fatal(err)
+	}
+	first := lastChallenge()
+	if first.IsZero() {
+		t.Fatal("expected first challenge to be answered")
+	}
+	if err := peer.handleCustodyChallengeMsg(&CustodyChallengeMsg{Hashes: hash0[:], Nonce: nonce}); err != nil {
+		t.Fatal(err)
+	}
+	if !lastChallenge().Equal(first) {
+		t.Fatal("expected second challenge to be rate limited")
+	}
+}
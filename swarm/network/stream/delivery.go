@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -33,6 +34,9 @@ import (
 const (
 	swarmChunkServerStreamName = "RETRIEVE_REQUEST"
 	deliveryCap                = 32
+	// outstanding requests are forgotten after this time even if no
+	// delivery arrived for them
+	requestedPeersTTL = 60 * time.Second
 )
 
 var (
@@ -41,6 +45,9 @@ var (
 
 	requestFromPeersCount     = metrics.NewRegisteredCounter("network.stream.request_from_peers.count", nil)
 	requestFromPeersEachCount = metrics.NewRegisteredCounter("network.stream.request_from_peers_each.count", nil)
+
+	cancelRetrieveRequestCount       = metrics.NewRegisteredCounter("network.stream.cancel_retrieve_request.count", nil)
+	handleCancelRetrieveRequestCount = metrics.NewRegisteredCounter("network.stream.handle_cancel_retrieve_request.count", nil)
 )
 
 type Delivery struct {
@@ -48,13 +55,44 @@ type Delivery struct {
 	overlay  network.Overlay
 	receiveC chan *ChunkDeliveryMsg
 	getPeer  func(discover.NodeID) *Peer
+
+	requestedMu sync.Mutex
+	requested   map[string]*requestedPeers       // outstanding retrieve requests by chunk key
+	backoffs    map[discover.NodeID]*peerBackoff // peers that failed to deliver requested chunks
+	backoff     *storage.RetryBackoff
+
+	// equally close peers are requested from the fastest if set
+	latencyOrdering bool
+	latencies       *peerLatencies
+
+	traces *RetrievalTraces
+}
+
+// requestedPeers are the peers a chunk was requested from and that have not
+// delivered it yet
+type requestedPeers struct {
+	peers  []discover.NodeID
+	sent   []time.Time // when the chunk was requested from each of the peers
+	failed int         // number of peers already backed off for not delivering
+}
+
+// peerBackoff is the number of consecutive requests a peer failed to deliver
+// and the time until which it is only requested as a last resort
+type peerBackoff struct {
+	failures int
+	until    time.Time
 }
 
 func NewDelivery(overlay network.Overlay, db *storage.DBAPI) *Delivery {
 	d := &Delivery{
-		db:       db,
-		overlay:  overlay,
-		receiveC: make(chan *ChunkDeliveryMsg, deliveryCap),
+		db:        db,
+		overlay:   overlay,
+		receiveC:  make(chan *ChunkDeliveryMsg, deliveryCap),
+		requested: make(map[string]*requestedPeers),
+		backoffs:  make(map[discover.NodeID]*peerBackoff),
+		backoff:   storage.NewRetryBackoff(),
+		latencies: newPeerLatencies(),
+		traces:    newRetrievalTraces(retrievalTracesCap),
 	}
 
 	go d.processReceivedChunks()
@@ -72,6 +110,10 @@ type SwarmChunkServer struct {
 
 // NewSwarmChunkServer is SwarmChunkServer constructor
 func NewSwarmChunkServer(db *storage.DBAPI) *SwarmChunkServer {
+	return newSwarmChunkServer(db, deliveryCap)
+}
+
+func newSwarmChunkServer(db *storage.DBAPI, deliveryCap int) *SwarmChunkServer {
 	s := &SwarmChunkServer{
 		deliveryC: make(chan []byte, deliveryCap),
 		batchC:    make(chan []byte),
@@ -136,6 +178,12 @@ type RetrieveRequestMsg struct {
 	SkipCheck bool
 }
 
+// CancelRetrieveRequestMsg is the protocol msg telling a peer that a chunk
+// it was asked for has been delivered by someone else and need not be sent
+type CancelRetrieveRequestMsg struct {
+	Key storage.Key
+}
+
 func (d *Delivery) handleRetrieveRequestMsg(sp *Peer, req *RetrieveRequestMsg) error {
 	log.Trace("received request", "peer", sp.ID(), "hash", req.Key)
 	handleRetrieveRequestMsgCount.Inc(1)
@@ -154,9 +202,11 @@ func (d *Delivery) handleRetrieveRequestMsg(sp *Peer, req *RetrieveRequestMsg) e
 				return nil
 			}
 		}
+		cancelC := sp.addPendingRetrieve(req.Key)
 		go func() {
-			t := time.NewTimer(10 * time.Minute)
+			t := time.NewTimer(sp.streamer.params.RetrieveRequestHoldTime)
 			defer t.Stop()
+			defer sp.removePendingRetrieve(req.Key, cancelC)
 
 			log.Debug("waiting delivery", "peer", sp.ID(), "hash", req.Key, "node", common.Bytes2Hex(d.overlay.BaseAddr()), "created", created)
 			start := time.Now()
@@ -167,6 +217,15 @@ func (d *Delivery) handleRetrieveRequestMsg(sp *Peer, req *RetrieveRequestMsg) e
 				log.Debug("retrieve request timeout", "peer", sp.ID(), "hash", req.Key)
 				chunk.SetErrored(storage.ErrChunkTimeout)
 				return
+			case <-sp.quit:
+				// the requester is gone, nobody to deliver to; the chunk request
+				// itself stays open for other requesters and local fetchers
+				log.Debug("retrieve request cancelled, requester disconnected", "peer", sp.ID(), "hash", req.Key)
+				return
+			case <-cancelC:
+				// the requester got the chunk from another peer
+				log.Debug("retrieve request cancelled by requester", "peer", sp.ID(), "hash", req.Key)
+				return
 			}
 			chunk.SetErrored(nil)
 
@@ -190,6 +249,47 @@ func (d *Delivery) handleRetrieveRequestMsg(sp *Peer, req *RetrieveRequestMsg) e
 	return nil
 }
 
+func (d *Delivery) handleCancelRetrieveRequestMsg(sp *Peer, req *CancelRetrieveRequestMsg) error {
+	log.Trace("received request cancel", "peer", sp.ID(), "hash", req.Key)
+	handleCancelRetrieveRequestCount.Inc(1)
+	sp.cancelPendingRetrieve(req.Key)
+	return nil
+}
+
+// addPendingRetrieve registers a retrieve request of the peer waiting for the
+// chunk to arrive and returns the channel closed when the peer cancels it.
+// A repeated request for the same chunk supersedes the earlier one so the
+// chunk is delivered only once.
+func (p *Peer) addPendingRetrieve(key storage.Key) chan struct{} {
+	p.retrieveMu.Lock()
+	defer p.retrieveMu.Unlock()
+	if c, ok := p.retrieves[string(key)]; ok {
+		close(c)
+	}
+	c := make(chan struct{})
+	p.retrieves[string(key)] = c
+	return c
+}
+
+// removePendingRetrieve forgets the pending retrieve request once it is done
+func (p *Peer) removePendingRetrieve(key storage.Key, c chan struct{}) {
+	p.retrieveMu.Lock()
+	defer p.retrieveMu.Unlock()
+	if p.retrieves[string(key)] == c {
+		delete(p.retrieves, string(key))
+	}
+}
+
+// cancelPendingRetrieve stops waiting for the chunk on behalf of the peer
+func (p *Peer) cancelPendingRetrieve(key storage.Key) {
+	p.retrieveMu.Lock()
+	defer p.retrieveMu.Unlock()
+	if c, ok := p.retrieves[string(key)]; ok {
+		close(c)
+		delete(p.retrieves, string(key))
+	}
+}
+
 type ChunkDeliveryMsg struct {
 	Key   storage.Key
 	SData []byte // the stored chunk Data (incl size)
@@ -203,8 +303,33 @@ func (d *Delivery) handleChunkDeliveryMsg(sp *Peer, req *ChunkDeliveryMsg) error
 }
 
 func (d *Delivery) processReceivedChunks() {
-R:
 	for req := range d.receiveC {
+		// collect the deliveries already waiting to store them in one batch
+		reqs := []*ChunkDeliveryMsg{req}
+	B:
+		for len(reqs) < deliveryCap {
+			select {
+			case req, ok := <-d.receiveC:
+				if !ok {
+					break B
+				}
+				reqs = append(reqs, req)
+			default:
+				break B
+			}
+		}
+		d.storeReceivedChunks(reqs)
+	}
+}
+
+// storeReceivedChunks puts the delivered chunks that are still requested in the
+// local store with a single batch write
+func (d *Delivery) storeReceivedChunks(reqs []*ChunkDeliveryMsg) {
+	var chunks []*storage.Chunk
+	var senders []*ChunkDeliveryMsg
+	keys := make(map[string]bool)
+R:
+	for _, req := range reqs {
 		processReceivedChunksCount.Inc(1)
 
 		// this should be has locally
@@ -224,15 +349,144 @@ R:
 			continue R
 		default:
 		}
+		if keys[string(req.Key)] {
+			log.Trace("chunk delivered twice in batch", "hash", chunk.Key.Hex())
+			continue R
+		}
+		keys[string(req.Key)] = true
 		chunk.SData = req.SData
-		d.db.Put(chunk)
+		chunks = append(chunks, chunk)
+		senders = append(senders, req)
+	}
+	if len(chunks) == 0 {
+		return
+	}
+	d.db.PutBatch(chunks)
 
-		go func(req *ChunkDeliveryMsg) {
+	for i, chunk := range chunks {
+		go func(chunk *storage.Chunk, req *ChunkDeliveryMsg) {
 			err := chunk.WaitToStore()
 			if err == storage.ErrChunkInvalid {
+				req.peer.streamer.peerFailed(req.peer.ID(), failureInvalidChunk)
 				req.peer.Drop(err)
+				return
+			}
+			if err == nil {
+				d.traces.delivered(chunk.Key, req.peer.ID())
+				d.peerDelivered(chunk.Key, req.peer.ID())
+				d.cancelRequests(chunk.Key, req.peer.ID())
 			}
-		}(req)
+		}(chunk, senders[i])
+	}
+}
+
+// addRequested records that the chunk was requested from the peer
+func (d *Delivery) addRequested(key storage.Key, id discover.NodeID) {
+	d.traces.requested(key, id)
+	d.requestedMu.Lock()
+	defer d.requestedMu.Unlock()
+	r, ok := d.requested[string(key)]
+	if !ok {
+		r = &requestedPeers{}
+		d.requested[string(key)] = r
+		time.AfterFunc(requestedPeersTTL, func() {
+			d.requestedMu.Lock()
+			defer d.requestedMu.Unlock()
+			if d.requested[string(key)] == r {
+				delete(d.requested, string(key))
+			}
+		})
+	}
+	for _, p := range r.peers {
+		if p == id {
+			return
+		}
+	}
+	r.peers = append(r.peers, id)
+	r.sent = append(r.sent, time.Now())
+}
+
+// retryRequested is called when the chunk is requested again, the peers
+// that were requested earlier and did not deliver are backed off. They are
+// still sent a cancellation once the chunk is delivered.
+func (d *Delivery) retryRequested(key storage.Key) {
+	d.requestedMu.Lock()
+	defer d.requestedMu.Unlock()
+	r, ok := d.requested[string(key)]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for _, id := range r.peers[r.failed:] {
+		b := d.backoffs[id]
+		if b == nil {
+			b = &peerBackoff{}
+			d.backoffs[id] = b
+		}
+		b.failures++
+		b.until = now.Add(d.backoff.Delay(b.failures))
+		log.Trace("peer backed off", "peer", id, "hash", key, "failures", b.failures, "until", b.until)
+	}
+	r.failed = len(r.peers)
+}
+
+// peerDelivered resets the backoff of the peer after a valid delivery of the
+// chunk and measures the round trip time of the request
+func (d *Delivery) peerDelivered(key storage.Key, id discover.NodeID) {
+	d.requestedMu.Lock()
+	defer d.requestedMu.Unlock()
+	delete(d.backoffs, id)
+	if r, ok := d.requested[string(key)]; ok {
+		for i, p := range r.peers {
+			if p == id {
+				d.latencies.add(id, time.Since(r.sent[i]))
+				break
+			}
+		}
+	}
+}
+
+// latency returns the average round trip time of the retrieve requests
+// delivered by the peer, 0 if it is not known yet
+func (d *Delivery) latency(p network.OverlayConn) time.Duration {
+	return d.latencies.get(p.(network.Peer).ID())
+}
+
+// backedOff returns true if the peer failed to deliver recently and should
+// only be requested if no other peer is available
+func (d *Delivery) backedOff(id discover.NodeID) bool {
+	d.requestedMu.Lock()
+	defer d.requestedMu.Unlock()
+	b := d.backoffs[id]
+	return b != nil && time.Now().Before(b.until)
+}
+
+// cancelRequests is called once the first valid delivery of the chunk is
+// stored or the retrieval is abandoned, it tells all peers the chunk was
+// requested from except the deliverer that they need not deliver it any more
+func (d *Delivery) cancelRequests(key storage.Key, deliverer discover.NodeID) {
+	d.requestedMu.Lock()
+	r, ok := d.requested[string(key)]
+	delete(d.requested, string(key))
+	d.requestedMu.Unlock()
+	if !ok {
+		return
+	}
+	msg := &CancelRetrieveRequestMsg{Key: key}
+	for _, id := range r.peers {
+		if id == deliverer {
+			continue
+		}
+		sp := d.getPeer(id)
+		if sp == nil {
+			continue
+		}
+		log.Trace("cancel retrieve request", "peer", id, "hash", key)
+		if err := sp.SendPriority(msg, Top); err != nil {
+			log.Debug("unable to cancel retrieve request", "peer", id, "hash", key, "err", err)
+			continue
+		}
+		cancelRetrieveRequestCount.Inc(1)
 	}
 }
 
@@ -240,8 +494,25 @@ R:
 func (d *Delivery) RequestFromPeers(hash []byte, skipCheck bool, peersToSkip ...discover.NodeID) error {
 	var success bool
 	var err error
+	// deprioritized and backed off peers are only requested if no other peer
+	// accepts the request
+	var fallback []*Peer
 	requestFromPeersCount.Inc(1)
-	d.overlay.EachConn(hash, 255, func(p network.OverlayConn, po int, nn bool) bool {
+	d.retryRequested(hash)
+	req := &RetrieveRequestMsg{
+		Key:       hash,
+		SkipCheck: skipCheck,
+	}
+	eachConn := d.overlay.EachConn
+	if d.latencyOrdering {
+		eachConn = func(base []byte, o int, f func(network.OverlayConn, int, bool) bool) {
+			network.EachConnByLatency(d.overlay, base, o, d.latency, f)
+		}
+	}
+	eachConn(hash, 255, func(p network.OverlayConn, po int, nn bool) bool {
+		if !p.(network.Peer).Capabilities().Has(network.CapabilityRetrieve) {
+			return true
+		}
 		spId := p.(network.Peer).ID()
 		for _, p := range peersToSkip {
 			if p == spId {
@@ -254,18 +525,32 @@ func (d *Delivery) RequestFromPeers(hash []byte, skipCheck bool, peersToSkip ...
 			log.Warn("Delivery.RequestFromPeers: peer not found", "id", spId)
 			return true
 		}
-		// TODO: skip light nodes that do not accept retrieve requests
-		err = sp.SendPriority(&RetrieveRequestMsg{
-			Key:       hash,
-			SkipCheck: skipCheck,
-		}, Top)
+		if sp.streamer.scores.deprioritized(spId) || d.backedOff(spId) {
+			fallback = append(fallback, sp)
+			return true
+		}
+		// a full queue is not a timeout of the peer, SendPriority drops it
+		// if the queue stays full, so it is not scored
+		err = sp.SendPriority(req, Top)
 		if err != nil {
 			return true
 		}
+		d.addRequested(hash, spId)
 		requestFromPeersEachCount.Inc(1)
 		success = true
 		return false
 	})
+	for _, sp := range fallback {
+		if success {
+			break
+		}
+		if err = sp.SendPriority(req, Top); err != nil {
+			continue
+		}
+		d.addRequested(hash, sp.ID())
+		requestFromPeersEachCount.Inc(1)
+		success = true
+	}
 	if success {
 		return nil
 	}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	crand "crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
@@ -211,6 +212,401 @@ func TestStreamerUpstreamRetrieveRequestMsgExchange(t *testing.T) {
 	}
 }
 
+// a retrieve request cancelled by the requester is not delivered when the
+// chunk arrives
+func TestStreamerUpstreamCancelRetrieveRequestMsg(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerID := tester.IDs[0]
+	peer := streamer.getPeer(peerID)
+
+	peer.handleSubscribeMsg(&SubscribeMsg{
+		Stream:   NewStream(swarmChunkServerStreamName, "", false),
+		History:  nil,
+		Priority: Top,
+	})
+
+	hash := storage.Key(hash0[:])
+	chunk, created := localStore.GetOrCreateRequest(hash)
+	if !created {
+		t.Fatal("chunk already exists")
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "RetrieveRequestMsg",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:       hash,
+					SkipCheck: true,
+				},
+				Peer: peerID,
+			},
+			{
+				Code: 13,
+				Msg: &CancelRetrieveRequestMsg{
+					Key: hash,
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the cancel to be handled before the chunk arrives
+	deadline := time.Now().Add(time.Second)
+	for {
+		peer.retrieveMu.Lock()
+		n := len(peer.retrieves)
+		peer.retrieveMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("retrieve request was not cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	chunk.SData = hash1[:]
+	localStore.Put(chunk)
+	chunk.WaitToStore()
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "ChunkDeliveryMsg",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 6,
+				Msg: &ChunkDeliveryMsg{
+					Key:   hash,
+					SData: hash1[:],
+				},
+				Peer: peerID,
+			},
+		},
+	})
+
+	expectedError := `exchange #0 "ChunkDeliveryMsg": timed out`
+	if err == nil || err.Error() != expectedError {
+		t.Fatalf("Expected error %v, got %v", expectedError, err)
+	}
+}
+
+// an upstream retrieve request waiting for a chunk gives up after the hold time
+func TestStreamerUpstreamRetrieveRequestHoldTime(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer.params.RetrieveRequestHoldTime = 100 * time.Millisecond
+
+	peerID := tester.IDs[0]
+	peer := streamer.getPeer(peerID)
+	peer.handleSubscribeMsg(&SubscribeMsg{
+		Stream:   NewStream(swarmChunkServerStreamName, "", false),
+		History:  nil,
+		Priority: Top,
+	})
+
+	hash := storage.Key(hash0[:])
+	chunk, _ := localStore.GetOrCreateRequest(hash)
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "RetrieveRequestMsg",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:       hash,
+					SkipCheck: true,
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for chunk.GetErrored() != storage.ErrChunkTimeout {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the retrieve request to time out, got %v", chunk.GetErrored())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	peer.retrieveMu.Lock()
+	n := len(peer.retrieves)
+	peer.retrieveMu.Unlock()
+	if n != 0 {
+		t.Fatalf("expected no pending retrieve requests after the timeout, got %d", n)
+	}
+}
+
+// an upstream retrieve request waiting for a chunk is dropped when the
+// requester disconnects, without failing the chunk request
+func TestStreamerUpstreamRetrieveRequestDisconnect(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer.params.RetrieveRequestHoldTime = 200 * time.Millisecond
+
+	peerID := tester.IDs[0]
+	peer := streamer.getPeer(peerID)
+	peer.handleSubscribeMsg(&SubscribeMsg{
+		Stream:   NewStream(swarmChunkServerStreamName, "", false),
+		History:  nil,
+		Priority: Top,
+	})
+
+	hash := storage.Key(hash0[:])
+	chunk, _ := localStore.GetOrCreateRequest(hash)
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "RetrieveRequestMsg",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:       hash,
+					SkipCheck: true,
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer.Drop(errors.New("requester disconnected"))
+	deadline := time.Now().Add(time.Second)
+	for {
+		peer.retrieveMu.Lock()
+		n := len(peer.retrieves)
+		peer.retrieveMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the retrieve request to be dropped with the requester")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the chunk request stays open for other requesters after the hold time
+	time.Sleep(2 * streamer.params.RetrieveRequestHoldTime)
+	if err := chunk.GetErrored(); err != nil {
+		t.Fatalf("expected the chunk request not to fail, got %v", err)
+	}
+}
+
+// once the first delivery of a chunk requested from several peers is stored,
+// the request is cancelled at the other peers
+func TestStreamerDownstreamCancelRetrieveRequests(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTesterWithPeers(t, 2)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := storage.Key(hash0[:])
+	chunk, created := localStore.GetOrCreateRequest(hash)
+	if !created {
+		t.Fatal("chunk already exists")
+	}
+
+	// request the chunk from both peers
+	first, second := tester.IDs[0], tester.IDs[1]
+	if err := streamer.delivery.RequestFromPeers(hash, true, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := streamer.delivery.RequestFromPeers(hash, true, first); err != nil {
+		t.Fatal(err)
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "RetrieveRequestMsg",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:       hash,
+					SkipCheck: true,
+				},
+				Peer: first,
+			},
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:       hash,
+					SkipCheck: true,
+				},
+				Peer: second,
+			},
+		},
+	},
+		p2ptest.Exchange{
+			Label: "ChunkDeliveryMsg",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 6,
+					Msg: &ChunkDeliveryMsg{
+						Key:   hash,
+						SData: hash1[:],
+					},
+					Peer: first,
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 13,
+					Msg: &CancelRetrieveRequestMsg{
+						Key: hash,
+					},
+					Peer: second,
+				},
+			},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-chunk.ReqC:
+	case <-time.After(time.Second):
+		t.Fatal("timeout receiving chunk")
+	}
+
+	// the retrieval is traced
+	var trace *RetrievalTrace
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		trace, err = streamer.RetrievalTraces().TraceRetrieval(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trace.Finished != nil {
+			break
+		}
+	}
+	if len(trace.Requests) != 2 || trace.Deliverer == nil || *trace.Deliverer != first {
+		t.Fatalf("expected two requests and the delivery by the first peer traced, got %+v", trace)
+	}
+}
+
+// an abandoned retrieval cancels the request at the peers
+func TestStreamerRetrieveCancelled(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerID := tester.IDs[0]
+
+	hash := storage.Key(hash0[:])
+	chunk, created := localStore.GetOrCreateRequest(hash)
+	if !created {
+		t.Fatal("chunk already exists")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := streamer.Retrieve(ctx, chunk); err != nil {
+		t.Fatal(err)
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "RetrieveRequestMsg",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:       hash,
+					SkipCheck: defaultSkipCheck,
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "CancelRetrieveRequestMsg",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 13,
+				Msg: &CancelRetrieveRequestMsg{
+					Key: hash,
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a peer that did not deliver a requested chunk is backed off and the
+// retry is sent to another peer
+func TestStreamerRetrieveRequestBackoff(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTesterWithPeers(t, 2)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer.delivery.backoff = &storage.RetryBackoff{Base: time.Minute}
+
+	hash := storage.Key(hash0[:])
+	first, second := tester.IDs[0], tester.IDs[1]
+	if err := streamer.delivery.RequestFromPeers(hash, true, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := streamer.delivery.RequestFromPeers(hash, true); err != nil {
+		t.Fatal(err)
+	}
+	if !streamer.delivery.backedOff(first) {
+		t.Fatal("expected the peer that did not deliver to be backed off")
+	}
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "RetrieveRequestMsg",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:       hash,
+					SkipCheck: true,
+				},
+				Peer: first,
+			},
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:       hash,
+					SkipCheck: true,
+				},
+				Peer: second,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestStreamerDownstreamChunkDeliveryMsgExchange(t *testing.T) {
 	tester, streamer, localStore, teardown, err := newStreamerTester(t)
 	defer teardown()
@@ -397,7 +793,7 @@ func testDeliveryFromNodes(t *testing.T, nodes, conns, chunkCount int, skipCheck
 		}
 		// create a retriever dpa for the pivot node
 		delivery := deliveries[sim.IDs[0]]
-		retrieveFunc := func(chunk *storage.Chunk) error {
+		retrieveFunc := func(ctx context.Context, chunk *storage.Chunk) error {
 			return delivery.RequestFromPeers(chunk.Key[:], skipCheck)
 		}
 		netStore := storage.NewNetStore(sim.Stores[0].(*storage.LocalStore), retrieveFunc)
@@ -613,7 +1009,7 @@ func benchmarkDeliveryFromNodes(b *testing.B, nodes, conns, chunkCount int, skip
 	// create a retriever dpa for the pivot node
 	// by now deliveries are set for each node by the streamer service
 	delivery := deliveries[sim.IDs[0]]
-	retrieveFunc := func(chunk *storage.Chunk) error {
+	retrieveFunc := func(ctx context.Context, chunk *storage.Chunk) error {
 		return delivery.RequestFromPeers(chunk.Key[:], skipCheck)
 	}
 	netStore := storage.NewNetStore(sim.Stores[0].(*storage.LocalStore), retrieveFunc)
@@ -0,0 +1,69 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// weight of a new round trip time in the moving average of a peer, the same
+// as the smoothing of TCP round trip times
+const latencyWeight = 8
+
+// peerLatencies keeps the moving average of the round trip times of the
+// retrieve requests delivered by each peer, from the request to the delivery
+type peerLatencies struct {
+	mu   sync.Mutex
+	rtts map[discover.NodeID]time.Duration
+}
+
+func newPeerLatencies() *peerLatencies {
+	return &peerLatencies{
+		rtts: make(map[discover.NodeID]time.Duration),
+	}
+}
+
+// add adds a round trip time of the peer to its average
+func (l *peerLatencies) add(id discover.NodeID, rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if avg, ok := l.rtts[id]; ok {
+		rtt = avg + (rtt-avg)/latencyWeight
+	}
+	if rtt <= 0 {
+		// 0 is kept for the peers not measured yet
+		rtt = 1
+	}
+	l.rtts[id] = rtt
+}
+
+// get returns the average round trip time of the peer, 0 if it is not known
+func (l *peerLatencies) get(id discover.NodeID) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rtts[id]
+}
+
+// remove forgets the round trip times of the peer
+func (l *peerLatencies) remove(id discover.NodeID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.rtts, id)
+}
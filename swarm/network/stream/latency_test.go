@@ -0,0 +1,44 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+func TestPeerLatencies(t *testing.T) {
+	l := newPeerLatencies()
+	id := discover.NodeID{1}
+	if rtt := l.get(id); rtt != 0 {
+		t.Fatalf("expected unknown latency, got %v", rtt)
+	}
+	l.add(id, 100*time.Millisecond)
+	if rtt := l.get(id); rtt != 100*time.Millisecond {
+		t.Fatalf("expected latency 100ms, got %v", rtt)
+	}
+	l.add(id, 20*time.Millisecond)
+	if rtt := l.get(id); rtt != 90*time.Millisecond {
+		t.Fatalf("expected latency 90ms, got %v", rtt)
+	}
+	l.remove(id)
+	if rtt := l.get(id); rtt != 0 {
+		t.Fatalf("expected latency to be removed, got %v", rtt)
+	}
+}
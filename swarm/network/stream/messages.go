@@ -203,6 +203,7 @@ func (p *Peer) handleOfferedHashesMsg(req *OfferedHashesMsg) error {
 			// create request and wait until the chunk data arrives and is stored
 			go func(w func()) {
 				w()
+				c.stats.addChunk(0)
 				wg.Done()
 			}(wait)
 		}
@@ -232,6 +233,7 @@ func (p *Peer) handleOfferedHashesMsg(req *OfferedHashesMsg) error {
 	if c.stream.Live {
 		c.sessionAt = req.From
 	}
+	c.stats.setOffset(req.To)
 	from, to := c.nextBatch(req.To + 1)
 	log.Trace("received offered batch", "peer", p.ID(), "stream", req.Stream, "from", req.From, "to", req.To)
 	if from == to {
@@ -246,13 +248,15 @@ func (p *Peer) handleOfferedHashesMsg(req *OfferedHashesMsg) error {
 	}
 	go func() {
 		select {
-		case <-time.After(120 * time.Second):
+		case <-time.After(p.streamer.params.OfferedHashesTimeout):
 			log.Warn("handleOfferedHashesMsg timeout, so dropping peer")
+			p.streamer.peerFailed(p.ID(), failureMissingData)
 			p.Drop(errors.New("handle offered hashes timeout"))
 			return
 		case err := <-c.next:
 			if err != nil {
 				log.Warn("c.next dropping peer", "err", err)
+				p.streamer.peerFailed(p.ID(), failureInvalidProof)
 				p.Drop(err)
 				return
 			}
@@ -293,6 +297,11 @@ func (p *Peer) handleWantedHashesMsg(req *WantedHashesMsg) error {
 	if err != nil {
 		return err
 	}
+	if p.streamer.receiptKey != nil {
+		if err := s.checkReceipts(); err != nil {
+			return err
+		}
+	}
 	hashes := s.currentBatch
 	// launch in go routine since GetBatch blocks until new hashes arrive
 	go func() {
@@ -309,20 +318,30 @@ func (p *Peer) handleWantedHashesMsg(req *WantedHashesMsg) error {
 	if err != nil {
 		return fmt.Errorf("error initiaising bitvector of length %v: %v", l, err)
 	}
+	tags := p.streamer.tags
 	for i := 0; i < l; i++ {
-		if want.Get(i) {
-			metrics.GetOrRegisterCounter("peer.handlewantedhashesmsg.actualget", nil).Inc(1)
+		hash := hashes[i*HashSize : (i+1)*HashSize]
+		if !want.Get(i) {
+			// the peer already has the chunk
+			tags.Synced(hash)
+			continue
+		}
+		metrics.GetOrRegisterCounter("peer.handlewantedhashesmsg.actualget", nil).Inc(1)
 
-			hash := hashes[i*HashSize : (i+1)*HashSize]
-			data, err := s.GetData(hash)
-			if err != nil {
-				return fmt.Errorf("handleWantedHashesMsg get data %x: %v", hash, err)
-			}
-			chunk := storage.NewChunk(hash, nil)
-			chunk.SData = data
-			if err := p.Deliver(chunk, s.priority); err != nil {
-				return err
-			}
+		data, err := s.GetData(hash)
+		if err != nil {
+			return fmt.Errorf("handleWantedHashesMsg get data %x: %v", hash, err)
+		}
+		chunk := storage.NewChunk(hash, nil)
+		chunk.SData = data
+		if err := p.Deliver(chunk, s.priority); err != nil {
+			return err
+		}
+		s.stats.addChunk(len(data))
+		tags.Sent(hash)
+		// without receipts the delivered chunk is not confirmed any further
+		if p.streamer.receiptKey == nil {
+			tags.Synced(hash)
 		}
 	}
 	return nil
@@ -18,6 +18,7 @@ package stream
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -31,7 +32,7 @@ import (
 	"github.com/ethereum/go-ethereum/swarm/storage"
 )
 
-var sendTimeout = 30 * time.Second
+var errPeerQuit = errors.New("peer quit")
 
 type notFoundError struct {
 	t string
@@ -60,21 +61,35 @@ type Peer struct {
 	// on creating a new client in offered hashes handler.
 	clientParams map[Stream]*clientParams
 	quit         chan struct{}
+
+	custodyMu            sync.Mutex
+	custody              map[string]*custodyChallenge // pending custody challenges by nonce
+	lastCustodyChallenge time.Time                    // time the last custody challenge of the peer was answered
+
+	retrieveMu sync.Mutex
+	retrieves  map[string]chan struct{} // cancel channels of retrieve requests waiting for delivery
 }
 
 // NewPeer is the constructor for Peer
 func NewPeer(peer *protocols.Peer, streamer *Registry) *Peer {
 	p := &Peer{
 		Peer:         peer,
-		pq:           pq.New(int(PriorityQueue), PriorityQueueCap),
+		pq:           pq.New(int(PriorityQueue), streamer.params.PriorityQueueCap),
 		streamer:     streamer,
 		servers:      make(map[Stream]*server),
 		clients:      make(map[Stream]*client),
 		clientParams: make(map[Stream]*clientParams),
 		quit:         make(chan struct{}),
+		custody:      make(map[string]*custodyChallenge),
+		retrieves:    make(map[string]chan struct{}),
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	go p.pq.Run(ctx, func(i interface{}) { p.Send(i) })
+	go p.pq.Run(ctx, func(i interface{}) {
+		if err := p.Send(i); err != nil {
+			log.Warn("stream send failed, dropping peer", "peer", p.ID(), "err", err)
+			p.Drop(err)
+		}
+	})
 	go func() {
 		<-p.quit
 		cancel()
@@ -91,13 +106,33 @@ func (p *Peer) Deliver(chunk *storage.Chunk, priority uint8) error {
 	return p.SendPriority(msg, priority)
 }
 
-// SendPriority sends message to the peer using the outgoing priority queue
+// SendPriority sends message to the peer using the outgoing priority queue.
+// If the queue stays full, pushing is retried with exponential backoff and
+// the peer is dropped once all retries fail.
 func (p *Peer) SendPriority(msg interface{}, priority uint8) error {
 	defer metrics.GetOrRegisterResettingTimer(fmt.Sprintf("peer.sendpriority_t.%d", priority), nil).UpdateSince(time.Now())
 	metrics.GetOrRegisterCounter(fmt.Sprintf("peer.sendpriority.%d", priority), nil).Inc(1)
-	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
-	defer cancel()
-	return p.pq.Push(ctx, msg, int(priority))
+	backoff := p.streamer.params.SendRetryBackoff
+	for i := 0; ; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), p.streamer.params.SendTimeout)
+		err := p.pq.Push(ctx, msg, int(priority))
+		cancel()
+		if err != context.DeadlineExceeded {
+			return err
+		}
+		if i >= p.streamer.params.SendRetries {
+			err = fmt.Errorf("priority queue %d full after %d retries: %v", priority, i, err)
+			p.Drop(err)
+			return err
+		}
+		metrics.GetOrRegisterCounter(fmt.Sprintf("peer.sendpriority.retry.%d", priority), nil).Inc(1)
+		select {
+		case <-time.After(backoff):
+		case <-p.quit:
+			return errPeerQuit
+		}
+		backoff *= 2
+	}
 }
 
 // SendOfferedHashes sends OfferedHashesMsg protocol msg
@@ -116,6 +151,10 @@ func (p *Peer) SendOfferedHashes(s *server, f, t uint64) error {
 		}
 	}
 	s.currentBatch = hashes
+	s.stats.setOffset(to)
+	if p.streamer.receiptKey != nil {
+		s.offered(from, hashes)
+	}
 	msg := &OfferedHashesMsg{
 		HandoverProof: proof,
 		Hashes:        hashes,
@@ -146,9 +185,11 @@ func (p *Peer) setServer(s Stream, o Server, priority uint8) (*server, error) {
 		return nil, fmt.Errorf("server %s already registered", s)
 	}
 	os := &server{
-		Server:   o,
-		stream:   s,
-		priority: priority,
+		Server:      o,
+		stream:      s,
+		priority:    priority,
+		unreceipted: make(map[uint64][]byte),
+		stats:       newStreamStats(),
 	}
 	p.servers[s] = os
 	return os, nil
@@ -271,6 +312,7 @@ func (p *Peer) getOrSetClient(s Stream, from, to uint64) (c *client, created boo
 		quit:           make(chan struct{}),
 		intervalsStore: p.streamer.intervalsStore,
 		intervalsKey:   intervalsKey,
+		stats:          newStreamStats(),
 	}
 	p.clients[s] = c
 	cp.clientCreated() // unblock all possible getClient calls that are waiting
@@ -0,0 +1,118 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// ReceiptMsg is the protocol msg sent by the downstream peer confirming
+// that it stored all chunks of an offered batch
+// it is only exchanged if the registries are set up with a ReceiptKey
+type ReceiptMsg struct {
+	Stream   Stream
+	From, To uint64
+	Sig      []byte // Sign(receiptDigest(Stream, From, To, Hashes))
+}
+
+// String pretty prints ReceiptMsg
+func (m ReceiptMsg) String() string {
+	return fmt.Sprintf("Stream '%v' [%v-%v], Sig: %x", m.Stream, m.From, m.To, m.Sig)
+}
+
+// receiptDigest is the hash signed by the downstream peer
+func receiptDigest(s Stream, from, to uint64, hashes []byte) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, from)
+	binary.BigEndian.PutUint64(b[8:], to)
+	return crypto.Keccak256([]byte(s.String()), b, hashes)
+}
+
+// sendReceipt signs and sends a receipt for the batch to the upstream peer
+func (p *Peer) sendReceipt(req *OfferedHashesMsg, priority uint8) error {
+	sig, err := crypto.Sign(receiptDigest(req.Stream, req.From, req.To, req.Hashes), p.streamer.receiptKey)
+	if err != nil {
+		return err
+	}
+	metrics.GetOrRegisterCounter("peer.sendreceipt", nil).Inc(1)
+	return p.SendPriority(&ReceiptMsg{
+		Stream: req.Stream,
+		From:   req.From,
+		To:     req.To,
+		Sig:    sig,
+	}, priority)
+}
+
+// handleReceiptMsg protocol msg handler checks that the receipt is signed by
+// the peer and marks the batch as stored downstream
+func (p *Peer) handleReceiptMsg(req *ReceiptMsg) error {
+	metrics.GetOrRegisterCounter("peer.handlereceiptmsg", nil).Inc(1)
+
+	s, err := p.getServer(req.Stream)
+	if err != nil {
+		return err
+	}
+	s.receiptMu.Lock()
+	defer s.receiptMu.Unlock()
+
+	hashes, ok := s.unreceipted[req.From]
+	if !ok {
+		return fmt.Errorf("receipt for unknown batch %v-%v of stream %v", req.From, req.To, req.Stream)
+	}
+	pub, err := crypto.SigToPub(receiptDigest(req.Stream, req.From, req.To, hashes), req.Sig)
+	if err != nil {
+		p.streamer.peerFailed(p.ID(), failureInvalidProof)
+		return fmt.Errorf("invalid receipt signature: %v", err)
+	}
+	if discover.PubkeyID(pub) != p.ID() {
+		p.streamer.peerFailed(p.ID(), failureInvalidProof)
+		return fmt.Errorf("receipt for stream %v not signed by peer", req.Stream)
+	}
+	delete(s.unreceipted, req.From)
+	for i := 0; i+HashSize <= len(hashes); i += HashSize {
+		p.streamer.tags.Synced(hashes[i : i+HashSize])
+	}
+	log.Trace("received receipt", "peer", p.ID(), "stream", req.Stream, "from", req.From, "to", req.To)
+	return nil
+}
+
+// offered records a batch offered to a peer that requires a receipt
+func (s *server) offered(from uint64, hashes []byte) {
+	s.receiptMu.Lock()
+	defer s.receiptMu.Unlock()
+
+	s.unreceipted[from] = hashes
+}
+
+// checkReceipts returns an error if any batch older than the
+// one currently offered was not receipted by the downstream peer
+func (s *server) checkReceipts() error {
+	s.receiptMu.Lock()
+	defer s.receiptMu.Unlock()
+
+	// only the current batch is allowed to be pending
+	if len(s.unreceipted) > 1 {
+		return fmt.Errorf("stream %v: %d batches without receipt", s.stream, len(s.unreceipted)-1)
+	}
+	return nil
+}
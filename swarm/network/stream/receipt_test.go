@@ -0,0 +1,82 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+)
+
+func TestReceipts(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Registry{
+		params:     NewStreamerParams(),
+		scores:     newPeerScores(),
+		receiptKey: key,
+	}
+	// the peer is its own upstream, so receipts are signed with the peer key
+	pp := p2p.NewPeer(discover.PubkeyID(&key.PublicKey), "receipt test", nil)
+	p := NewPeer(protocols.NewPeer(pp, nil, Spec), r)
+	defer close(p.quit)
+
+	stream := NewStream("foo", "", false)
+	s, err := p.setServer(stream, newTestServer(""), Top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.offered(0, hashes[:HashSize])
+	s.offered(1, hashes[HashSize:])
+	if err := s.checkReceipts(); err == nil {
+		t.Fatal("expected error for missing receipt")
+	}
+
+	receipt := func(from, to uint64, batch []byte) *ReceiptMsg {
+		sig, err := crypto.Sign(receiptDigest(stream, from, to, batch), r.receiptKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &ReceiptMsg{Stream: stream, From: from, To: to, Sig: sig}
+	}
+
+	// receipt signed by another key is rejected
+	r.receiptKey = otherKey
+	if err := p.handleReceiptMsg(receipt(0, 1, hashes[:HashSize])); err == nil {
+		t.Fatal("expected error for receipt not signed by peer")
+	}
+	r.receiptKey = key
+	// receipt for unknown batch is rejected
+	if err := p.handleReceiptMsg(receipt(5, 6, hashes[:HashSize])); err == nil {
+		t.Fatal("expected error for receipt of unknown batch")
+	}
+	if err := p.handleReceiptMsg(receipt(0, 1, hashes[:HashSize])); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.checkReceipts(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
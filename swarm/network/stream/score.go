@@ -0,0 +1,206 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// failure is the kind of misbehaviour recorded against a peer
+type failure uint8
+
+const (
+	failureInvalidChunk failure = iota // delivered chunk did not validate
+	failureMissingData                 // offered hashes were wanted but never delivered
+	failureInvalidProof                // handover could not be taken over
+	failureTimeout                     // peer did not consume messages in time
+)
+
+var (
+	// failureWeights is the score penalty for each failure kind
+	failureWeights = map[failure]int{
+		failureInvalidChunk: 10,
+		failureMissingData:  5,
+		failureInvalidProof: 10,
+		failureTimeout:      1,
+	}
+
+	// peers with at least this score are only used as a last resort
+	deprioritizeScore = 10
+	// peers with at least this score are disconnected and refused
+	blacklistScore = 30
+	// the time a blacklisted peer is refused for
+	blacklistDuration = 10 * time.Minute
+	// scores decrease by one point each interval, so that only peers
+	// failing constantly reach the thresholds
+	scoreDecayInterval = time.Minute
+
+	errBlacklisted = errors.New("peer is blacklisted")
+)
+
+// PeerScore holds the failure counters and the resulting score of a peer.
+// It is returned by Registry.PeerInfo.
+type PeerScore struct {
+	InvalidChunks    uint64    `json:"invalidChunks"`
+	MissingData      uint64    `json:"missingData"`
+	InvalidProofs    uint64    `json:"invalidProofs"`
+	Timeouts         uint64    `json:"timeouts"`
+	Score            int       `json:"score"`
+	BlacklistedUntil time.Time `json:"blacklistedUntil"`
+
+	decayedAt time.Time // time the score was last decreased, or zero if it is 0
+}
+
+// decay decreases the score by the points of the decay intervals passed since
+// it was last decreased
+func (score *PeerScore) decay(now time.Time) {
+	if score.Score <= 0 {
+		score.Score = 0
+		score.decayedAt = now
+		return
+	}
+	points := int(now.Sub(score.decayedAt) / scoreDecayInterval)
+	if points <= 0 {
+		return
+	}
+	score.Score -= points
+	if score.Score < 0 {
+		score.Score = 0
+	}
+	score.decayedAt = score.decayedAt.Add(time.Duration(points) * scoreDecayInterval)
+}
+
+// peerScores keeps the scores of the connected peers and of the
+// blacklisted ones, so that blacklisting survives reconnects
+type peerScores struct {
+	mu     sync.Mutex
+	scores map[discover.NodeID]*PeerScore
+}
+
+func newPeerScores() *peerScores {
+	return &peerScores{
+		scores: make(map[discover.NodeID]*PeerScore),
+	}
+}
+
+// fail records a failure of kind f for the peer and returns true
+// if the peer got blacklisted as a result
+func (s *peerScores) fail(id discover.NodeID, f failure) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := s.scores[id]
+	if score == nil {
+		score = &PeerScore{}
+		s.scores[id] = score
+	}
+	score.decay(time.Now())
+	switch f {
+	case failureInvalidChunk:
+		score.InvalidChunks++
+	case failureMissingData:
+		score.MissingData++
+	case failureInvalidProof:
+		score.InvalidProofs++
+	case failureTimeout:
+		score.Timeouts++
+	}
+	score.Score += failureWeights[f]
+	metrics.GetOrRegisterCounter("stream.peer.failures", nil).Inc(1)
+	if score.Score >= blacklistScore && score.BlacklistedUntil.IsZero() {
+		score.BlacklistedUntil = time.Now().Add(blacklistDuration)
+		metrics.GetOrRegisterCounter("stream.peer.blacklisted", nil).Inc(1)
+		return true
+	}
+	return false
+}
+
+// blacklisted returns true if the peer is currently blacklisted.
+// An expired blacklisting resets the peer score.
+func (s *peerScores) blacklisted(id discover.NodeID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := s.scores[id]
+	if score == nil || score.BlacklistedUntil.IsZero() {
+		return false
+	}
+	if time.Now().Before(score.BlacklistedUntil) {
+		return true
+	}
+	delete(s.scores, id)
+	return false
+}
+
+// forget drops the score of a removed peer unless it is still blacklisted
+func (s *peerScores) forget(id discover.NodeID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := s.scores[id]
+	if score == nil {
+		return
+	}
+	if !score.BlacklistedUntil.IsZero() && time.Now().Before(score.BlacklistedUntil) {
+		return
+	}
+	delete(s.scores, id)
+}
+
+// deprioritized returns true if the peer should only be used
+// when no better peer is available
+func (s *peerScores) deprioritized(id discover.NodeID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := s.scores[id]
+	if score == nil {
+		return false
+	}
+	score.decay(time.Now())
+	return score.Score >= deprioritizeScore
+}
+
+// get returns a copy of the peer score, or nil if nothing is recorded
+func (s *peerScores) get(id discover.NodeID) *PeerScore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := s.scores[id]
+	if score == nil {
+		return nil
+	}
+	score.decay(time.Now())
+	c := *score
+	return &c
+}
+
+// peerFailed records a failure for the peer and drops it
+// if it exceeds the blacklisting threshold
+func (r *Registry) peerFailed(id discover.NodeID, f failure) {
+	if !r.scores.fail(id, f) {
+		return
+	}
+	if p := r.getPeer(id); p != nil {
+		p.Drop(errBlacklisted)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+)
+
+func TestPeerScores(t *testing.T) {
+	s := newPeerScores()
+	id := discover.NodeID{1}
+
+	if s.get(id) != nil {
+		t.Fatal("expected no score for unknown peer")
+	}
+	if s.fail(id, failureInvalidChunk) {
+		t.Fatal("expected peer not to be blacklisted after a single failure")
+	}
+	if !s.deprioritized(id) {
+		t.Fatal("expected peer to be deprioritized")
+	}
+	if s.blacklisted(id) {
+		t.Fatal("expected peer not to be blacklisted")
+	}
+	if s.fail(id, failureInvalidProof) {
+		t.Fatal("expected peer not to be blacklisted below threshold")
+	}
+	if s.fail(id, failureMissingData) {
+		t.Fatal("expected peer not to be blacklisted below threshold")
+	}
+	if !s.fail(id, failureMissingData) {
+		t.Fatal("expected peer to be blacklisted on reaching threshold")
+	}
+	if !s.blacklisted(id) {
+		t.Fatal("expected peer to be blacklisted")
+	}
+	score := s.get(id)
+	if score.InvalidChunks != 1 || score.InvalidProofs != 1 || score.MissingData != 2 {
+		t.Fatalf("unexpected failure counters %+v", score)
+	}
+
+	// expire the blacklisting
+	s.scores[id].BlacklistedUntil = time.Now().Add(-time.Second)
+	if s.blacklisted(id) {
+		t.Fatal("expected blacklisting to expire")
+	}
+	if s.get(id) != nil {
+		t.Fatal("expected score to be reset after blacklisting expired")
+	}
+}
+
+func TestPeerScoresDecay(t *testing.T) {
+	s := newPeerScores()
+	id := discover.NodeID{1}
+
+	// timeouts spread over a long time do not add up to a blacklisting
+	for i := 0; i < blacklistScore*2; i++ {
+		if s.fail(id, failureTimeout) {
+			t.Fatalf("expected peer not to be blacklisted after %d spread timeouts", i+1)
+		}
+		s.scores[id].decayedAt = s.scores[id].decayedAt.Add(-scoreDecayInterval)
+	}
+	if score := s.get(id); score.Score != 0 || score.Timeouts != uint64(blacklistScore*2) {
+		t.Fatalf("expected decayed score with all timeouts counted, got %+v", score)
+	}
+
+	// the score decreases a point each interval, and does not go below 0
+	s.fail(id, failureInvalidChunk)
+	if !s.deprioritized(id) {
+		t.Fatal("expected peer to be deprioritized")
+	}
+	s.scores[id].decayedAt = s.scores[id].decayedAt.Add(-3 * scoreDecayInterval)
+	if score := s.get(id); score.Score != failureWeights[failureInvalidChunk]-3 {
+		t.Fatalf("expected score %d, got %d", failureWeights[failureInvalidChunk]-3, score.Score)
+	}
+	if s.deprioritized(id) {
+		t.Fatal("expected peer not to be deprioritized after the score decreased")
+	}
+	s.scores[id].decayedAt = s.scores[id].decayedAt.Add(-100 * scoreDecayInterval)
+	if score := s.get(id); score.Score != 0 {
+		t.Fatalf("expected score 0, got %d", score.Score)
+	}
+}
+
+func TestPeerScoresForget(t *testing.T) {
+	r := &Registry{
+		peers:    make(map[discover.NodeID]*Peer),
+		delivery: &Delivery{latencies: newPeerLatencies()},
+		scores:   newPeerScores(),
+	}
+	dropped := &Peer{Peer: protocols.NewPeer(p2p.NewPeer(discover.NodeID{1}, "dropped", nil), nil, nil)}
+	blacklisted := &Peer{Peer: protocols.NewPeer(p2p.NewPeer(discover.NodeID{2}, "blacklisted", nil), nil, nil)}
+	r.setPeer(dropped)
+	r.setPeer(blacklisted)
+
+	r.peerFailed(dropped.ID(), failureTimeout)
+	for i := 0; i < blacklistScore/failureWeights[failureInvalidChunk]; i++ {
+		r.scores.fail(blacklisted.ID(), failureInvalidChunk)
+	}
+	if len(r.scores.scores) != 2 {
+		t.Fatalf("expected 2 scores, got %d", len(r.scores.scores))
+	}
+
+	// the score of a dropped peer is forgotten, the blacklisting is kept
+	r.deletePeer(dropped)
+	r.deletePeer(blacklisted)
+	if len(r.scores.scores) != 1 || r.scores.get(dropped.ID()) != nil {
+		t.Fatalf("expected only the blacklisted score to be kept, got %d scores", len(r.scores.scores))
+	}
+	if !r.scores.blacklisted(blacklisted.ID()) {
+		t.Fatal("expected peer to stay blacklisted after it dropped")
+	}
+}
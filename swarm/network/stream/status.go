@@ -0,0 +1,143 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// streamStats counts the progress of a server or a client
+//
+// The counters are accessed atomically, they come first so that they are 64-bit
+// aligned on 32-bit platforms, and so must the stats in the structs embedding them.
+type streamStats struct {
+	offset uint64 // end of the last batch offered or received
+	chunks uint64 // chunks delivered or received
+	bytes  uint64 // bytes of the chunks delivered, clients only count the chunks
+	start  time.Time
+}
+
+func newStreamStats() streamStats {
+	return streamStats{start: time.Now()}
+}
+
+func (s *streamStats) setOffset(offset uint64) {
+	atomic.StoreUint64(&s.offset, offset)
+}
+
+func (s *streamStats) addChunk(size int) {
+	atomic.AddUint64(&s.chunks, 1)
+	atomic.AddUint64(&s.bytes, uint64(size))
+}
+
+// status reports the counters of the stream
+func (s *streamStats) status(stream Stream, priority uint8) *StreamStatus {
+	status := &StreamStatus{
+		Stream:   stream.String(),
+		Priority: priority,
+		Offset:   atomic.LoadUint64(&s.offset),
+		Chunks:   atomic.LoadUint64(&s.chunks),
+		Bytes:    atomic.LoadUint64(&s.bytes),
+		Since:    s.start,
+	}
+	if elapsed := time.Since(s.start).Seconds(); elapsed > 0 {
+		status.ChunkRate = float64(status.Chunks) / elapsed
+		status.ByteRate = float64(status.Bytes) / elapsed
+	}
+	return status
+}
+
+// StreamStatus is the state of a stream served to or requested from a peer
+type StreamStatus struct {
+	Stream    string    `json:"stream"`
+	Priority  uint8     `json:"priority"`
+	Offset    uint64    `json:"offset"`    // end of the last batch offered by the server or received by the client
+	Chunks    uint64    `json:"chunks"`    // chunks delivered by the server or received by the client
+	Bytes     uint64    `json:"bytes"`     // bytes of the chunks delivered by the server, zero for clients
+	ChunkRate float64   `json:"chunkRate"` // chunks per second since the stream started
+	ByteRate  float64   `json:"byteRate"`  // bytes per second since the stream started, zero for clients
+	Since     time.Time `json:"since"`     // start of the stream
+}
+
+// PeerStreams lists the streams a peer is served and the streams requested
+// from it
+type PeerStreams struct {
+	Peer    discover.NodeID `json:"peer"`
+	Servers []*StreamStatus `json:"servers"`
+	Clients []*StreamStatus `json:"clients"`
+}
+
+// Streams returns the active streams of all connected peers ordered by peer
+func (r *Registry) Streams() []*PeerStreams {
+	r.peersMu.RLock()
+	peers := make([]*Peer, 0, len(r.peers))
+	for _, p := range r.peers {
+		peers = append(peers, p)
+	}
+	r.peersMu.RUnlock()
+
+	streams := make([]*PeerStreams, 0, len(peers))
+	for _, p := range peers {
+		streams = append(streams, p.streams())
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Peer.String() < streams[j].Peer.String()
+	})
+	return streams
+}
+
+// streams returns the active streams of the peer ordered by stream
+func (p *Peer) streams() *PeerStreams {
+	ps := &PeerStreams{
+		Peer:    p.ID(),
+		Servers: []*StreamStatus{},
+		Clients: []*StreamStatus{},
+	}
+	p.serverMu.RLock()
+	for _, s := range p.servers {
+		ps.Servers = append(ps.Servers, s.stats.status(s.stream, s.priority))
+	}
+	p.serverMu.RUnlock()
+	p.clientMu.RLock()
+	for _, c := range p.clients {
+		select {
+		case <-c.quit:
+			// closed clients are kept until the peer disconnects
+			continue
+		default:
+		}
+		ps.Clients = append(ps.Clients, c.stats.status(c.stream, c.priority))
+	}
+	p.clientMu.RUnlock()
+	sort.Slice(ps.Servers, func(i, j int) bool {
+		return ps.Servers[i].Stream < ps.Servers[j].Stream
+	})
+	sort.Slice(ps.Clients, func(i, j int) bool {
+		return ps.Clients[i].Stream < ps.Clients[j].Stream
+	})
+	return ps
+}
+
+// Streams returns the active incoming and outgoing streams of all peers with
+// their offsets and throughput
+func (api *API) Streams() []*PeerStreams {
+	return api.streamer.Streams()
+}
@@ -18,11 +18,13 @@ package stream
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"fmt"
 	"math"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
@@ -60,14 +62,112 @@ type Registry struct {
 	delivery       *Delivery
 	intervalsStore state.Store
 	doRetrieve     bool
+	scores         *peerScores
+	params         *StreamerParams
+	receiptKey     *ecdsa.PrivateKey
+	tags           *storage.Tags
+	syncFeed       event.Feed // progress of the syncing streams, see SubscribeSyncProgress
+	peersSub       event.Subscription
+	depthSub       event.Subscription
+}
+
+// SyncProgress is sent to the subscribers of the registry when a batch of a
+// syncing stream is received from a peer
+type SyncProgress struct {
+	Peer discover.NodeID `json:"peer"`
+	Bin  uint8           `json:"bin"`  // proximity order bin of the stream
+	Live bool            `json:"live"` // true for the live stream, false for the history
+	From uint64          `json:"from"` // start of the interval synced by the batch
+	To   uint64          `json:"to"`   // end of the interval synced by the batch
+}
+
+// StreamerParams holds the tunable limits and timeouts of the streamer.
+type StreamerParams struct {
+	PriorityQueueCap        int           // capacity of each outgoing priority queue
+	DeliveryCap             int           // buffer size of the chunk delivery channel of retrieve request servers
+	BatchSize               int           // maximal number of hashes offered in a sync batch
+	SendTimeout             time.Duration // time to wait for a free slot in the outgoing priority queue
+	SendRetries             int           // number of retries if the outgoing priority queue stays full, 0 for none, negative for the default
+	SendRetryBackoff        time.Duration // initial wait between retries, doubled on every retry
+	OfferedHashesTimeout    time.Duration // time to wait for the wanted chunks of an offered batch
+	RetrieveRequestHoldTime time.Duration // time an incoming retrieve request waits for the chunk
+	SyncUpdateMaxDelay      time.Duration // hard limit on delaying sync subscription updates
+
+	// RetrieveBackoff delays requests to the peers that failed to deliver a requested chunk
+	RetrieveBackoff *storage.RetryBackoff
+	// LatencyOrdering requests chunks from the peers with the lowest round
+	// trip time first among the peers with the same proximity order
+	LatencyOrdering bool
+}
+
+// NewStreamerParams returns StreamerParams with default values
+func NewStreamerParams() *StreamerParams {
+	return &StreamerParams{
+		PriorityQueueCap:        PriorityQueueCap,
+		DeliveryCap:             deliveryCap,
+		BatchSize:               BatchSize,
+		SendTimeout:             30 * time.Second,
+		SendRetries:             2,
+		SendRetryBackoff:        100 * time.Millisecond,
+		OfferedHashesTimeout:    120 * time.Second,
+		RetrieveRequestHoldTime: 10 * time.Minute,
+		SyncUpdateMaxDelay:      3 * time.Minute,
+		RetrieveBackoff:         storage.NewRetryBackoff(),
+		LatencyOrdering:         true,
+	}
+}
+
+// withDefaults returns a copy of the params with the unset limits and
+// timeouts set to their default values
+func (p *StreamerParams) withDefaults() *StreamerParams {
+	d := NewStreamerParams()
+	if p == nil {
+		return d
+	}
+	params := *p
+	if params.PriorityQueueCap <= 0 {
+		params.PriorityQueueCap = d.PriorityQueueCap
+	}
+	if params.DeliveryCap <= 0 {
+		params.DeliveryCap = d.DeliveryCap
+	}
+	if params.BatchSize <= 0 {
+		params.BatchSize = d.BatchSize
+	}
+	if params.SendTimeout <= 0 {
+		params.SendTimeout = d.SendTimeout
+	}
+	if params.SendRetries < 0 {
+		params.SendRetries = d.SendRetries
+	}
+	if params.SendRetryBackoff <= 0 {
+		params.SendRetryBackoff = d.SendRetryBackoff
+	}
+	if params.OfferedHashesTimeout <= 0 {
+		params.OfferedHashesTimeout = d.OfferedHashesTimeout
+	}
+	if params.RetrieveRequestHoldTime <= 0 {
+		params.RetrieveRequestHoldTime = d.RetrieveRequestHoldTime
+	}
+	if params.SyncUpdateMaxDelay <= 0 {
+		params.SyncUpdateMaxDelay = d.SyncUpdateMaxDelay
+	}
+	return &params
 }
 
 // RegistryOptions holds optional values for NewRegistry constructor.
 type RegistryOptions struct {
+	*StreamerParams // the unset limits and timeouts are set to their defaults
 	SkipCheck       bool
 	DoSync          bool
 	DoRetrieve      bool
 	SyncUpdateDelay time.Duration
+	// ReceiptKey enables chunk receipts if set. Receipts for stored batches
+	// are signed with it, and upstream batches are not advanced
+	// until the downstream peer receipts the previous batch.
+	ReceiptKey *ecdsa.PrivateKey
+	// Tags counts the chunks of tracked uploads sent and synced to peers
+	Tags *storage.Tags
 }
 
 // NewRegistry is Streamer constructor
@@ -78,6 +178,7 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 	if options.SyncUpdateDelay <= 0 {
 		options.SyncUpdateDelay = 15 * time.Second
 	}
+	params := options.StreamerParams.withDefaults()
 	streamer := &Registry{
 		addr:           addr,
 		skipCheck:      options.SkipCheck,
@@ -87,11 +188,19 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 		delivery:       delivery,
 		intervalsStore: intervalsStore,
 		doRetrieve:     options.DoRetrieve,
+		scores:         newPeerScores(),
+		params:         params,
+		receiptKey:     options.ReceiptKey,
+		tags:           options.Tags,
 	}
 	streamer.api = NewAPI(streamer)
 	delivery.getPeer = streamer.getPeer
+	if params.RetrieveBackoff != nil {
+		delivery.backoff = params.RetrieveBackoff
+	}
+	delivery.latencyOrdering = params.LatencyOrdering
 	streamer.RegisterServerFunc(swarmChunkServerStreamName, func(_ *Peer, _ string, _ bool) (Server, error) {
-		return NewSwarmChunkServer(delivery.db), nil
+		return newSwarmChunkServer(delivery.db, params.DeliveryCap), nil
 	})
 	streamer.RegisterClientFunc(swarmChunkServerStreamName, func(p *Peer, t string, live bool) (Client, error) {
 		return NewSwarmSyncerClient(p, delivery.db, false, NewStream(swarmChunkServerStreamName, t, live))
@@ -124,14 +233,40 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 			return out
 		}
 
+		// the events are received from the kademlia table here as the
+		// subscribers must not call the table while receiving them
+		kad := streamer.delivery.overlay.(*network.Kademlia)
+		peerC := make(chan *network.PeerEvent)
+		streamer.peersSub = kad.SubscribePeers(peerC)
+		depthEventC := make(chan int)
+		streamer.depthSub = kad.SubscribeDepth(depthEventC)
+		nDepthC := make(chan int)
+		addrCountC := make(chan int)
+		go func() {
+			defer close(nDepthC)
+			defer close(addrCountC)
+			for {
+				select {
+				case e := <-peerC:
+					if e.Type == network.PeerEventTypeAdd {
+						addrCountC <- e.Peers
+					}
+				case depth := <-depthEventC:
+					nDepthC <- depth
+				case <-streamer.peersSub.Err():
+					return
+				case <-streamer.depthSub.Err():
+					return
+				}
+			}
+		}()
+		depthC := latestIntC(nDepthC)
+		addressBookSizeC := latestIntC(addrCountC)
+
 		go func() {
 			// wait for kademlia table to be healthy
 			time.Sleep(options.SyncUpdateDelay)
 
-			kad := streamer.delivery.overlay.(*network.Kademlia)
-			depthC := latestIntC(kad.NeighbourhoodDepthC())
-			addressBookSizeC := latestIntC(kad.AddrCountC())
-
 			// initial requests for syncing subscription to peers
 			streamer.updateSyncing()
 
@@ -144,7 +279,7 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 				timer := time.NewTimer(options.SyncUpdateDelay)
 				// Hard limit to sync update delay, preventing long delays
 				// on a very dynamic network
-				maxTimer := time.NewTimer(3 * time.Minute)
+				maxTimer := time.NewTimer(params.SyncUpdateMaxDelay)
 			loop:
 				for {
 					select {
@@ -161,8 +296,11 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 						// request for syncing subscription to new peers
 						streamer.updateSyncing()
 						break loop
-					case size := <-addressBookSizeC:
-						log.Trace("Kademlia address book size changed on depth change", "size", size)
+					case peers, ok := <-addressBookSizeC:
+						if !ok {
+							break loop
+						}
+						log.Trace("Kademlia address book changed on depth change", "peers", peers)
 						// new peers has been added to kademlia,
 						// reset the timer to prevent early sync subscriptions
 						if !timer.Stop() {
@@ -323,19 +461,63 @@ func (r *Registry) Quit(peerId discover.NodeID, s Stream) error {
 	return peer.Send(msg)
 }
 
-func (r *Registry) Retrieve(chunk *storage.Chunk) error {
-	return r.delivery.RequestFromPeers(chunk.Key[:], r.skipCheck)
+// Retrieve requests the chunk from the peers, unless the context of the
+// retrieval is already done. If the context is done before the chunk is
+// delivered, eg. the HTTP client disconnected, the request is cancelled at
+// the peers.
+func (r *Registry) Retrieve(ctx context.Context, chunk *storage.Chunk) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := r.delivery.RequestFromPeers(chunk.Key[:], r.skipCheck); err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-chunk.ReqC:
+		case <-ctx.Done():
+			select {
+			case <-chunk.ReqC:
+				// delivered just before the context was done
+				return
+			default:
+			}
+			log.Debug("retrieval abandoned, cancelling request", "hash", chunk.Key, "err", ctx.Err())
+			r.delivery.traces.cancelled(chunk.Key)
+			r.delivery.cancelRequests(chunk.Key, discover.NodeID{})
+		}
+	}()
+	return nil
+}
+
+// RetrievalTraces returns the traces of the latest chunk retrievals
+func (r *Registry) RetrievalTraces() *RetrievalTraces {
+	return r.delivery.traces
+}
+
+// SubscribeSyncProgress notifies the channel of every batch of the syncing
+// streams received from the peers
+func (r *Registry) SubscribeSyncProgress(ch chan<- *SyncProgress) event.Subscription {
+	return r.syncFeed.Subscribe(ch)
 }
 
 func (r *Registry) NodeInfo() interface{} {
 	return nil
 }
 
+// PeerInfo returns the failure score of the peer
 func (r *Registry) PeerInfo(id discover.NodeID) interface{} {
+	if score := r.scores.get(id); score != nil {
+		return score
+	}
 	return nil
 }
 
 func (r *Registry) Close() error {
+	if r.peersSub != nil {
+		r.peersSub.Unsubscribe()
+		r.depthSub.Unsubscribe()
+	}
 	return r.intervalsStore.Close()
 }
 
@@ -358,6 +540,8 @@ func (r *Registry) deletePeer(peer *Peer) {
 	delete(r.peers, peer.ID())
 	metrics.GetOrRegisterGauge("registry.peers", nil).Update(int64(len(r.peers)))
 	r.peersMu.Unlock()
+	r.delivery.latencies.remove(peer.ID())
+	r.scores.forget(peer.ID())
 }
 
 func (r *Registry) peersCount() (c int) {
@@ -369,6 +553,10 @@ func (r *Registry) peersCount() (c int) {
 
 // Run protocol run function
 func (r *Registry) Run(p *network.BzzPeer) error {
+	if r.scores.blacklisted(p.ID()) {
+		log.Debug("refusing blacklisted peer", "peer", p.ID())
+		return errBlacklisted
+	}
 	sp := NewPeer(p.Peer, r)
 	r.setPeer(sp)
 	defer r.deletePeer(sp)
@@ -415,6 +603,9 @@ func (r *Registry) updateSyncing() {
 	// request subscriptions for all nodes and bins
 	kad.EachBin(r.addr.Over(), pot.DefaultPof(256), 0, func(conn network.OverlayConn, bin int) bool {
 		p := conn.(network.Peer)
+		if !p.Capabilities().Has(network.CapabilityPushSync) {
+			return true
+		}
 		log.Debug(fmt.Sprintf("Requesting subscription by: registry %s from peer %s for bin: %d", r.addr.ID(), p.ID(), bin))
 
 		// bin is always less then 256 and it is safe to convert it to type uint8
@@ -487,22 +678,38 @@ func (p *Peer) HandleMsg(msg interface{}) error {
 	case *RetrieveRequestMsg:
 		return p.streamer.delivery.handleRetrieveRequestMsg(p, msg)
 
+	case *CancelRetrieveRequestMsg:
+		return p.streamer.delivery.handleCancelRetrieveRequestMsg(p, msg)
+
 	case *RequestSubscriptionMsg:
 		return p.handleRequestSubscription(msg)
 
 	case *QuitMsg:
 		return p.handleQuitMsg(msg)
 
+	case *ReceiptMsg:
+		return p.handleReceiptMsg(msg)
+
+	case *CustodyChallengeMsg:
+		return p.handleCustodyChallengeMsg(msg)
+
+	case *CustodyProofMsg:
+		return p.handleCustodyProofMsg(msg)
+
 	default:
 		return fmt.Errorf("unknown message type: %T", msg)
 	}
 }
 
 type server struct {
+	stats streamStats // first for the alignment of its atomic counters
 	Server
 	stream       Stream
 	priority     uint8
 	currentBatch []byte
+	// batches offered, but not receipted yet, keyed by their From index
+	unreceipted map[uint64][]byte
+	receiptMu   sync.Mutex
 }
 
 // Server interface for outgoing peer Streamer
@@ -513,6 +720,7 @@ type Server interface {
 }
 
 type client struct {
+	stats streamStats // first for the alignment of its atomic counters
 	Client
 	stream    Stream
 	priority  uint8
@@ -583,6 +791,11 @@ func (c *client) nextBatch(from uint64) (nextFrom uint64, nextTo uint64) {
 }
 
 func (c *client) batchDone(p *Peer, req *OfferedHashesMsg, hashes []byte) error {
+	if p.streamer.receiptKey != nil {
+		if err := p.sendReceipt(req, c.priority); err != nil {
+			return err
+		}
+	}
 	if tf := c.BatchDone(req.Stream, req.From, hashes, req.Root); tf != nil {
 		tp, err := tf()
 		if err != nil {
@@ -600,6 +813,17 @@ func (c *client) batchDone(p *Peer, req *OfferedHashesMsg, hashes []byte) error
 	if err := c.AddInterval(req.From, req.To); err != nil {
 		return err
 	}
+	if c.stream.Name == "SYNC" {
+		if bin, err := ParseSyncBinKey(c.stream.Key); err == nil {
+			p.streamer.syncFeed.Send(&SyncProgress{
+				Peer: p.ID(),
+				Bin:  bin,
+				Live: c.stream.Live,
+				From: req.From,
+				To:   req.To,
+			})
+		}
+	}
 	return nil
 }
 
@@ -645,7 +869,7 @@ func (c *clientParams) clientCreated() {
 // Spec is the spec of the streamer protocol
 var Spec = &protocols.Spec{
 	Name:       "stream",
-	Version:    3,
+	Version:    6,
 	MaxMsgSize: 10 * 1024 * 1024,
 	Messages: []interface{}{
 		UnsubscribeMsg{},
@@ -658,6 +882,10 @@ var Spec = &protocols.Spec{
 		SubscribeErrorMsg{},
 		RequestSubscriptionMsg{},
 		QuitMsg{},
+		ReceiptMsg{},
+		CustodyChallengeMsg{},
+		CustodyProofMsg{},
+		CancelRetrieveRequestMsg{},
 	},
 }
 
@@ -669,7 +897,7 @@ func (r *Registry) Protocols() []p2p.Protocol {
 			Length:  Spec.Length(),
 			Run:     r.runProtocol,
 			// NodeInfo: ,
-			// PeerInfo: ,
+			PeerInfo: r.PeerInfo,
 		},
 	}
 }
@@ -737,3 +965,7 @@ func (api *API) SubscribeStream(peerId discover.NodeID, s Stream, history *Range
 func (api *API) UnsubscribeStream(peerId discover.NodeID, s Stream) error {
 	return api.streamer.Unsubscribe(peerId, s)
 }
+
+func (api *API) ChallengeCustody(peerId discover.NodeID, keys []storage.Key) error {
+	return api.streamer.ChallengeCustody(peerId, keys)
+}
@@ -22,7 +22,11 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
 	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
+	pq "github.com/ethereum/go-ethereum/swarm/network/priorityqueue"
 )
 
 func TestStreamerSubscribe(t *testing.T) {
@@ -553,6 +557,26 @@ func TestStreamerDownstreamOfferedHashesMsgExchange(t *testing.T) {
 		t.Fatal("timeout waiting batchdone call")
 	}
 
+	streams := streamer.Streams()
+	if len(streams) != 1 || streams[0].Peer != peerID {
+		t.Fatalf("expected streams of peer %v, got %v", peerID, streams)
+	}
+	if len(streams[0].Servers) != 0 || len(streams[0].Clients) != 1 {
+		t.Fatalf("expected one client and no servers, got %d clients and %d servers", len(streams[0].Clients), len(streams[0].Servers))
+	}
+	status := streams[0].Clients[0]
+	if status.Stream != stream.String() {
+		t.Fatalf("expected stream %v, got %v", stream, status.Stream)
+	}
+	if status.Priority != Top {
+		t.Fatalf("expected priority %v, got %v", Top, status.Priority)
+	}
+	if status.Offset != 8 {
+		t.Fatalf("expected offset %v, got %v", 8, status.Offset)
+	}
+	if status.Chunks != 2 {
+		t.Fatalf("expected %v received chunks, got %v", 2, status.Chunks)
+	}
 }
 
 func TestStreamerRequestSubscriptionQuitMsgExchange(t *testing.T) {
@@ -682,3 +706,60 @@ func TestStreamerRequestSubscriptionQuitMsgExchange(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestStreamerParamsDefaults tests that only the unset streamer params are
+// set to their defaults
+func TestStreamerParamsDefaults(t *testing.T) {
+	params := (&StreamerParams{
+		BatchSize:   8,
+		SendTimeout: time.Second,
+		SendRetries: -1,
+	}).withDefaults()
+	if params.BatchSize != 8 || params.SendTimeout != time.Second {
+		t.Fatalf("expected the set params to be kept, got batch size %d and send timeout %v", params.BatchSize, params.SendTimeout)
+	}
+	defaults := NewStreamerParams()
+	if params.PriorityQueueCap != defaults.PriorityQueueCap || params.OfferedHashesTimeout != defaults.OfferedHashesTimeout || params.SendRetries != defaults.SendRetries {
+		t.Fatalf("expected the unset params to be set to the defaults, got %+v", params)
+	}
+
+	// no retries is a valid setting, only negative retries are unset
+	params = (&StreamerParams{SendRetries: 0}).withDefaults()
+	if params.SendRetries != 0 {
+		t.Fatalf("expected no send retries to be kept, got %d", params.SendRetries)
+	}
+}
+
+// TestSendPriorityRetries tests that pushing to a full priority queue is
+// retried with backoff, fails once the retries are used up and stops
+// when the peer quits
+func TestSendPriorityRetries(t *testing.T) {
+	params := NewStreamerParams()
+	params.SendTimeout = 20 * time.Millisecond
+	params.SendRetries = 2
+	params.SendRetryBackoff = 10 * time.Millisecond
+	p := &Peer{
+		Peer:     protocols.NewPeer(p2p.NewPeer(discover.NodeID{}, "test", nil), nil, nil),
+		pq:       pq.New(int(PriorityQueue), 1),
+		streamer: &Registry{params: params},
+		quit:     make(chan struct{}),
+	}
+
+	// nothing takes the messages off the queue, so the first one fills it
+	if err := p.SendPriority("first", Top); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := p.SendPriority("second", Top); err == nil {
+		t.Fatal("expected an error pushing to the full queue")
+	}
+	// three pushes timing out, with two backoffs between them
+	if elapsed, min := time.Since(start), 3*params.SendTimeout+3*params.SendRetryBackoff; elapsed < min {
+		t.Fatalf("expected the push to be retried for at least %v, gave up after %v", min, elapsed)
+	}
+
+	close(p.quit)
+	if err := p.SendPriority("third", Top); err != errPeerQuit {
+		t.Fatalf("expected %v once the peer quit, got %v", errPeerQuit, err)
+	}
+}
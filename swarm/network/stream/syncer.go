@@ -40,6 +40,7 @@ type SwarmSyncerServer struct {
 	db        *storage.DBAPI
 	sessionAt uint64
 	start     uint64
+	batchSize int
 	quit      chan struct{}
 }
 
@@ -55,6 +56,7 @@ func NewSwarmSyncerServer(live bool, po uint8, db *storage.DBAPI) (*SwarmSyncerS
 		db:        db,
 		sessionAt: sessionAt,
 		start:     start,
+		batchSize: BatchSize,
 		quit:      make(chan struct{}),
 	}, nil
 }
@@ -65,7 +67,12 @@ func RegisterSwarmSyncerServer(streamer *Registry, db *storage.DBAPI) {
 		if err != nil {
 			return nil, err
 		}
-		return NewSwarmSyncerServer(live, po, db)
+		s, err := NewSwarmSyncerServer(live, po, db)
+		if err != nil {
+			return nil, err
+		}
+		s.batchSize = streamer.params.BatchSize
+		return s, nil
 	})
 	// streamer.RegisterServerFunc(stream, func(p *Peer) (Server, error) {
 	// 	return NewOutgoingProvableSwarmSyncer(po, db)
@@ -122,7 +129,7 @@ func (s *SwarmSyncerServer) SetNextBatch(from, to uint64) ([]byte, uint64, uint6
 			batch = append(batch, key[:]...)
 			i++
 			to = idx
-			return i < BatchSize
+			return i < s.batchSize
 		})
 		if err != nil {
 			return nil, 0, 0, nil, err
@@ -211,7 +218,7 @@ func RegisterSwarmSyncerClient(streamer *Registry, db *storage.DBAPI) {
 
 // NeedData
 func (s *SwarmSyncerClient) NeedData(key []byte) (wait func()) {
-	chunk, _ := s.db.GetOrCreateRequest(key)
+	chunk, _ := s.db.GetOrCreateSyncRequest(key)
 	// TODO: we may want to request from this peer anyway even if the request exists
 
 	// ignoreExistingRequest is temporary commented out until its functionality is verified.
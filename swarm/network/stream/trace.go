@@ -0,0 +1,167 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// number of retrievals the traces are kept for
+const retrievalTracesCap = 1000
+
+var errNoRetrievalTrace = errors.New("no retrieval traced for the chunk")
+
+// RetrievalRequest is a retrieve request sent to a peer
+type RetrievalRequest struct {
+	Peer discover.NodeID `json:"peer"`
+	Sent time.Time       `json:"sent"`
+}
+
+// RetrievalTrace records the retrieval of a chunk from the network: the peers
+// it was requested from and when, and which peer delivered it
+type RetrievalTrace struct {
+	Key       storage.Key         `json:"key"`
+	Started   time.Time           `json:"started"`
+	Requests  []*RetrievalRequest `json:"requests"`
+	Deliverer *discover.NodeID    `json:"deliverer,omitempty"`
+	Finished  *time.Time          `json:"finished,omitempty"`
+	Duration  time.Duration       `json:"duration"` // time from the first request to the end of the retrieval
+	Cancelled bool                `json:"cancelled"`
+}
+
+// RetrievalStats are the totals of all retrievals since the node started
+type RetrievalStats struct {
+	Retrievals     uint64        `json:"retrievals"`
+	Delivered      uint64        `json:"delivered"`
+	Cancelled      uint64        `json:"cancelled"`
+	Requests       uint64        `json:"requests"`       // retrieve requests sent to peers
+	AverageLatency time.Duration `json:"averageLatency"` // of the delivered retrievals
+}
+
+// RetrievalTraces keeps the traces of the latest retrievals and the
+// retrieval statistics. Its methods are exposed as admin RPC in the bzz
+// namespace.
+type RetrievalTraces struct {
+	mu      sync.Mutex
+	traces  map[string]*RetrievalTrace // latest trace by chunk key
+	order   []*RetrievalTrace          // traces from the oldest
+	cap     int
+	stats   RetrievalStats
+	latency time.Duration // total latency of the delivered retrievals
+}
+
+func newRetrievalTraces(cap int) *RetrievalTraces {
+	return &RetrievalTraces{
+		traces: make(map[string]*RetrievalTrace),
+		cap:    cap,
+	}
+}
+
+// requested records a retrieve request of the chunk sent to the peer, it
+// starts a new trace unless the retrieval of the chunk is in progress
+func (t *RetrievalTraces) requested(key storage.Key, id discover.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	trace := t.traces[string(key)]
+	if trace == nil || trace.Finished != nil {
+		trace = &RetrievalTrace{
+			Key:     key,
+			Started: now,
+		}
+		t.traces[string(key)] = trace
+		t.order = append(t.order, trace)
+		for len(t.order) > t.cap {
+			if old := t.order[0]; t.traces[string(old.Key)] == old {
+				delete(t.traces, string(old.Key))
+			}
+			t.order = t.order[1:]
+		}
+		t.stats.Retrievals++
+	}
+	trace.Requests = append(trace.Requests, &RetrievalRequest{Peer: id, Sent: now})
+	t.stats.Requests++
+}
+
+// delivered records that the peer delivered the chunk
+func (t *RetrievalTraces) delivered(key storage.Key, id discover.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace := t.finish(key)
+	if trace == nil {
+		return
+	}
+	trace.Deliverer = &id
+	t.stats.Delivered++
+	t.latency += trace.Duration
+	t.stats.AverageLatency = t.latency / time.Duration(t.stats.Delivered)
+}
+
+// cancelled records that the retrieval of the chunk was abandoned
+func (t *RetrievalTraces) cancelled(key storage.Key) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace := t.finish(key)
+	if trace == nil {
+		return
+	}
+	trace.Cancelled = true
+	t.stats.Cancelled++
+}
+
+// finish ends the retrieval of the chunk in progress, it returns nil
+// if there is none
+func (t *RetrievalTraces) finish(key storage.Key) *RetrievalTrace {
+	trace := t.traces[string(key)]
+	if trace == nil || trace.Finished != nil {
+		return nil
+	}
+	now := time.Now()
+	trace.Finished = &now
+	trace.Duration = now.Sub(trace.Started)
+	return trace
+}
+
+// TraceRetrieval returns the trace of the latest retrieval of the chunk
+func (t *RetrievalTraces) TraceRetrieval(key storage.Key) (*RetrievalTrace, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace := t.traces[string(key)]
+	if trace == nil {
+		return nil, errNoRetrievalTrace
+	}
+	c := *trace
+	c.Requests = make([]*RetrievalRequest, len(trace.Requests))
+	for i, r := range trace.Requests {
+		rc := *r
+		c.Requests[i] = &rc
+	}
+	return &c, nil
+}
+
+// RetrievalStats returns the retrieval statistics
+func (t *RetrievalTraces) RetrievalStats() *RetrievalStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := t.stats
+	return &stats
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+func TestRetrievalTraces(t *testing.T) {
+	traces := newRetrievalTraces(2)
+	peer1, peer2 := discover.NodeID{1}, discover.NodeID{2}
+	key1, key2, key3 := storage.Key(hash0[:]), storage.Key(hash1[:]), storage.Key(hash2[:])
+
+	traces.requested(key1, peer1)
+	traces.requested(key1, peer2)
+	traces.delivered(key1, peer2)
+	traces.requested(key2, peer1)
+	traces.cancelled(key2)
+
+	trace, err := traces.TraceRetrieval(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Requests) != 2 || trace.Requests[0].Peer != peer1 || trace.Requests[1].Peer != peer2 {
+		t.Fatalf("expected requests to peer1 and peer2, got %v", trace.Requests)
+	}
+	if trace.Deliverer == nil || *trace.Deliverer != peer2 || trace.Finished == nil || trace.Cancelled {
+		t.Fatalf("expected the retrieval to be delivered by peer2, got %+v", trace)
+	}
+	trace, err = traces.TraceRetrieval(key2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trace.Cancelled || trace.Deliverer != nil {
+		t.Fatalf("expected the retrieval to be cancelled, got %+v", trace)
+	}
+
+	// a delivery after the retrieval finished is not recorded
+	traces.delivered(key2, peer1)
+	stats := traces.RetrievalStats()
+	if stats.Retrievals != 2 || stats.Requests != 3 || stats.Delivered != 1 || stats.Cancelled != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// a new retrieval evicts the oldest trace
+	traces.requested(key3, peer1)
+	if _, err := traces.TraceRetrieval(key1); err != errNoRetrievalTrace {
+		t.Fatalf("expected the oldest trace to be evicted, got %v", err)
+	}
+	if _, err := traces.TraceRetrieval(key3); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/protocols"
@@ -42,6 +43,18 @@ var (
 	addressLength = len(pot.Address{})
 )
 
+var (
+	receivedMsgCount      = metrics.NewRegisteredCounter("pss.msg.received", nil)
+	expiredMsgCount       = metrics.NewRegisteredCounter("pss.msg.expired", nil)
+	cachedMsgCount        = metrics.NewRegisteredCounter("pss.msg.cached", nil)
+	processedMsgCount     = metrics.NewRegisteredCounter("pss.msg.processed", nil)
+	processFailedMsgCount = metrics.NewRegisteredCounter("pss.msg.process.failed", nil)
+	sentMsgCount          = metrics.NewRegisteredCounter("pss.msg.sent", nil)
+	forwardedMsgCount     = metrics.NewRegisteredCounter("pss.msg.forwarded", nil)
+	forwardFailedMsgCount = metrics.NewRegisteredCounter("pss.msg.forward.failed", nil)
+	outboxFullCount       = metrics.NewRegisteredCounter("pss.outbox.full", nil)
+)
+
 // cache is used for preventing backwards routing
 // will also be instrumental in flood guard mechanism
 // and mailbox implementation
@@ -329,11 +342,14 @@ func (self *Pss) handlePssMsg(msg interface{}) error {
 	if !ok {
 		return fmt.Errorf("invalid message type. Expected *PssMsg, got %T ", msg)
 	}
+	receivedMsgCount.Inc(1)
 	if int64(pssmsg.Expire) < time.Now().Unix() {
+		expiredMsgCount.Inc(1)
 		log.Trace(fmt.Sprintf("pss filtered expired message FROM %x TO %x", self.Overlay.BaseAddr(), common.ToHex(pssmsg.To)))
 		return nil
 	}
 	if self.checkFwdCache(pssmsg) {
+		cachedMsgCount.Inc(1)
 		log.Trace(fmt.Sprintf("pss relay block-cache match (process): FROM %x TO %x", self.Overlay.BaseAddr(), common.ToHex(pssmsg.To)))
 		return nil
 	}
@@ -346,6 +362,7 @@ func (self *Pss) handlePssMsg(msg interface{}) error {
 
 	log.Trace("pss for us, yay! ... let's process!", "pss", common.ToHex(self.BaseAddr()))
 	if err := self.process(pssmsg); err != nil {
+		processFailedMsgCount.Inc(1)
 		qerr := self.enqueue(pssmsg)
 		if qerr != nil {
 			return fmt.Errorf("process fail: processerr %v, queueerr: %v", err, qerr)
@@ -391,6 +408,7 @@ func (self *Pss) process(pssmsg *PssMsg) error {
 		}
 	}
 	self.executeHandlers(psstopic, recvmsg.Payload, from, asymmetric, keyid)
+	processedMsgCount.Inc(1)
 
 	return nil
 
@@ -643,6 +661,7 @@ func (self *Pss) enqueue(msg *PssMsg) error {
 	default:
 	}
 
+	outboxFullCount.Inc(1)
 	return errors.New("outbox full")
 }
 
@@ -755,7 +774,11 @@ func (self *Pss) send(to []byte, topic Topic, msg []byte, asymmetric bool, key [
 		Expire:  uint32(time.Now().Add(self.msgTTL).Unix()),
 		Payload: envelope,
 	}
-	return self.enqueue(pssmsg)
+	if err := self.enqueue(pssmsg); err != nil {
+		return err
+	}
+	sentMsgCount.Inc(1)
+	return nil
 }
 
 // Forwards a pss message to the peer(s) closest to the to recipient address in the PssMsg struct
@@ -803,6 +826,7 @@ func (self *Pss) forward(msg *PssMsg) error {
 			return true
 		}
 		sent++
+		forwardedMsgCount.Inc(1)
 		log.Trace(fmt.Sprintf("%v: successfully forwarded", sendMsg))
 
 		// continue forwarding if:
@@ -824,6 +848,7 @@ func (self *Pss) forward(msg *PssMsg) error {
 	})
 
 	if sent == 0 {
+		forwardFailedMsgCount.Inc(1)
 		log.Debug("unable to forward to any peers")
 		time.Sleep(time.Millisecond)
 		if err := self.enqueue(msg); err != nil {
@@ -0,0 +1,228 @@
+package pss
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const (
+	defaultResourceUpdateCapacity = 32 // buffered resource updates waiting to be sent to subscribers
+)
+
+var (
+	// topic used for requesting (un)subscription to resource updates
+	resourceSubscribeTopic = BytesToTopic([]byte("resource-subscribe"))
+)
+
+// Returns the pss topic used for update notifications of a resource
+//
+// The topic is derived from the ENS namehash of the resource name
+func ResourceTopic(nameHash common.Hash) Topic {
+	return BytesToTopic(nameHash[:])
+}
+
+// resource (un)subscription request payload
+type resourceSubscribeMsg struct {
+	NameHash    common.Hash
+	Address     []byte // overlay address of the subscriber
+	Unsubscribe bool
+}
+
+// ResourceNotification is sent to subscribers when the publishing node
+// adds a new update to a resource
+//
+// The update itself can be retrieved with ResourceHandler.LookupVersion
+type ResourceNotification struct {
+	NameHash  common.Hash
+	Period    uint32
+	Version   uint32
+	Key       []byte
+	Multihash bool
+}
+
+// Pushes notifications of resource updates to subscribed nodes over pss
+//
+// On the publishing node updates made through the ResourceHandler are sent to all
+// nodes that subscribed to the resource. Other nodes use Subscribe to receive them
+// instead of polling with LookupLatest.
+type ResourceNotifier struct {
+	pss         *Pss
+	rh          *storage.ResourceHandler
+	lock        sync.Mutex
+	subscribers map[common.Hash]map[string]bool // subscribed public key ids by resource namehash
+	deregister  func()
+	sub         event.Subscription
+	quitC       chan struct{}
+}
+
+// Create a new notifier
+//
+// rh may be nil if the node only subscribes to resources of other nodes
+func NewResourceNotifier(pss *Pss, rh *storage.ResourceHandler) *ResourceNotifier {
+	return &ResourceNotifier{
+		pss:         pss,
+		rh:          rh,
+		subscribers: make(map[common.Hash]map[string]bool),
+		quitC:       make(chan struct{}),
+	}
+}
+
+// Start accepting subscriptions and sending notifications for local updates
+func (self *ResourceNotifier) Start() {
+	self.deregister = self.pss.Register(&resourceSubscribeTopic, self.handleSubscribe)
+	if self.rh == nil {
+		return
+	}
+	updateC := make(chan *storage.ResourceUpdate, defaultResourceUpdateCapacity)
+	self.sub = self.rh.SubscribeUpdates(updateC)
+	go func() {
+		for {
+			select {
+			case update := <-updateC:
+				self.notify(update)
+			case <-self.sub.Err():
+				return
+			case <-self.quitC:
+				return
+			}
+		}
+	}()
+}
+
+// Stop sending notifications
+func (self *ResourceNotifier) Stop() {
+	close(self.quitC)
+	if self.sub != nil {
+		self.sub.Unsubscribe()
+	}
+	if self.deregister != nil {
+		self.deregister()
+	}
+}
+
+// Subscribe to the updates of a resource published by the node with the given public key and overlay address
+//
+// Notifications are delivered on the channel, and dropped if the channel is not ready to receive.
+// The returned function cancels the subscription.
+func (self *ResourceNotifier) Subscribe(nameHash common.Hash, pubkey *ecdsa.PublicKey, address PssAddress, notifyC chan<- *ResourceNotification) (func(), error) {
+	topic := ResourceTopic(nameHash)
+	pubkeyid := common.ToHex(crypto.FromECDSAPub(pubkey))
+	if err := self.pss.SetPeerPublicKey(pubkey, resourceSubscribeTopic, &address); err != nil {
+		return nil, err
+	}
+	if err := self.pss.SetPeerPublicKey(pubkey, topic, &address); err != nil {
+		return nil, err
+	}
+	deregister := self.pss.Register(&topic, func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		if !asymmetric || keyid != pubkeyid {
+			return fmt.Errorf("resource notification for %x not from publisher", nameHash)
+		}
+		var notification ResourceNotification
+		if err := rlp.DecodeBytes(msg, &notification); err != nil {
+			return fmt.Errorf("invalid resource notification: %v", err)
+		}
+		if notification.NameHash != nameHash {
+			return fmt.Errorf("resource notification for %x on topic of %x", notification.NameHash, nameHash)
+		}
+		select {
+		case notifyC <- &notification:
+		default:
+			log.Warn("resource notification dropped", "namehash", nameHash, "period", notification.Period, "version", notification.Version)
+		}
+		return nil
+	})
+	if err := self.sendSubscribe(pubkeyid, nameHash, false); err != nil {
+		deregister()
+		return nil, err
+	}
+	return func() {
+		deregister()
+		if err := self.sendSubscribe(pubkeyid, nameHash, true); err != nil {
+			log.Warn("resource unsubscribe failed", "namehash", nameHash, "err", err)
+		}
+	}, nil
+}
+
+func (self *ResourceNotifier) sendSubscribe(pubkeyid string, nameHash common.Hash, unsubscribe bool) error {
+	msg, err := rlp.EncodeToBytes(&resourceSubscribeMsg{
+		NameHash:    nameHash,
+		Address:     self.pss.BaseAddr(),
+		Unsubscribe: unsubscribe,
+	})
+	if err != nil {
+		return err
+	}
+	return self.pss.SendAsym(pubkeyid, resourceSubscribeTopic, msg)
+}
+
+// handles subscription requests from other nodes
+func (self *ResourceNotifier) handleSubscribe(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+	if !asymmetric {
+		return errors.New("resource subscription must be asymmetrically encrypted")
+	}
+	var req resourceSubscribeMsg
+	if err := rlp.DecodeBytes(msg, &req); err != nil {
+		return fmt.Errorf("invalid resource subscription: %v", err)
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if req.Unsubscribe {
+		delete(self.subscribers[req.NameHash], keyid)
+		if len(self.subscribers[req.NameHash]) == 0 {
+			delete(self.subscribers, req.NameHash)
+		}
+		log.Debug("resource unsubscribed", "namehash", req.NameHash, "pubkey", keyid)
+		return nil
+	}
+	pubkey := crypto.ToECDSAPub(common.FromHex(keyid))
+	if pubkey == nil {
+		return fmt.Errorf("invalid public key id %s", keyid)
+	}
+	address := PssAddress(req.Address)
+	if err := self.pss.SetPeerPublicKey(pubkey, ResourceTopic(req.NameHash), &address); err != nil {
+		return err
+	}
+	if self.subscribers[req.NameHash] == nil {
+		self.subscribers[req.NameHash] = make(map[string]bool)
+	}
+	self.subscribers[req.NameHash][keyid] = true
+	log.Debug("resource subscribed", "namehash", req.NameHash, "pubkey", keyid)
+	return nil
+}
+
+// sends a notification of the update to all subscribers of the resource
+func (self *ResourceNotifier) notify(update *storage.ResourceUpdate) {
+	msg, err := rlp.EncodeToBytes(&ResourceNotification{
+		NameHash:  update.NameHash,
+		Period:    update.Period,
+		Version:   update.Version,
+		Key:       update.Key,
+		Multihash: update.Multihash,
+	})
+	if err != nil {
+		log.Error("resource notification encode fail", "err", err)
+		return
+	}
+	topic := ResourceTopic(update.NameHash)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for pubkeyid := range self.subscribers[update.NameHash] {
+		if err := self.pss.SendAsym(pubkeyid, topic, msg); err != nil {
+			log.Warn("resource notification send fail", "namehash", update.NameHash, "pubkey", pubkeyid, "err", err)
+		}
+	}
+}
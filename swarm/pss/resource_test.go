@@ -0,0 +1,86 @@
+package pss
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// pss node which is not started, so outgoing messages stay in the outbox
+func newResourceTestPss(t *testing.T) *Pss {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nid discover.NodeID
+	copy(nid[:], crypto.FromECDSAPub(&privkey.PublicKey))
+	addr := network.NewAddrFromNodeID(nid)
+	ps, err := NewPss(network.NewKademlia(addr.Over(), network.NewKadParams()), NewPssParams().WithPrivateKey(privkey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+// relay the next message in the outbox of the sender to the recipient
+func relayResourceTestMsg(t *testing.T, from *Pss, to *Pss) {
+	select {
+	case msg := <-from.outbox:
+		if err := to.process(msg); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for pss message")
+	}
+}
+
+func TestResourceNotifier(t *testing.T) {
+	pubPss := newResourceTestPss(t)
+	subPss := newResourceTestPss(t)
+	pub := NewResourceNotifier(pubPss, nil)
+	pub.Start()
+	defer pub.Stop()
+	sub := NewResourceNotifier(subPss, nil)
+	sub.Start()
+	defer sub.Stop()
+
+	nameHash := common.HexToHash("0x2a")
+	notifyC := make(chan *ResourceNotification, 1)
+	cancel, err := sub.Subscribe(nameHash, pubPss.PublicKey(), pubPss.BaseAddr(), notifyC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayResourceTestMsg(t, subPss, pubPss)
+	if len(pub.subscribers[nameHash]) != 1 {
+		t.Fatalf("expected 1 subscriber, got %d", len(pub.subscribers[nameHash]))
+	}
+
+	key := storage.Key(common.HexToHash("0x01").Bytes())
+	pub.notify(&storage.ResourceUpdate{
+		NameHash: nameHash,
+		Period:   3,
+		Version:  2,
+		Key:      key,
+	})
+	relayResourceTestMsg(t, pubPss, subPss)
+	select {
+	case n := <-notifyC:
+		if n.NameHash != nameHash || n.Period != 3 || n.Version != 2 || !bytes.Equal(n.Key, key) {
+			t.Fatalf("unexpected notification %v", n)
+		}
+	default:
+		t.Fatal("expected notification")
+	}
+
+	cancel()
+	relayResourceTestMsg(t, subPss, pubPss)
+	if len(pub.subscribers) != 0 {
+		t.Fatalf("expected no subscribers, got %d", len(pub.subscribers))
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryBackoff is the exponential backoff with jitter applied between
+// retrieval attempts of a chunk, and between requests sent to a peer that
+// failed to deliver
+type RetryBackoff struct {
+	Base   time.Duration // delay after the first failed attempt, doubled on every further one
+	Max    time.Duration // upper limit of the delay before the jitter is applied
+	Jitter float64       // the delay is randomly changed by up to this fraction in either direction
+}
+
+// NewRetryBackoff returns the default RetryBackoff
+func NewRetryBackoff() *RetryBackoff {
+	return &RetryBackoff{
+		Base:   netStoreMinRetryDelay,
+		Max:    time.Minute,
+		Jitter: 0.2,
+	}
+}
+
+// Delay returns the time to wait after the given number of consecutive
+// failed attempts
+func (b *RetryBackoff) Delay(failures int) time.Duration {
+	if failures <= 0 || b.Base <= 0 {
+		return 0
+	}
+	d := b.Base
+	for i := 1; i < failures && (b.Max <= 0 || d < b.Max); i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		d += time.Duration((2*rand.Float64() - 1) * b.Jitter * float64(d))
+	}
+	return d
+}
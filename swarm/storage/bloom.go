@@ -0,0 +1,168 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	bloomCountersPerKey = 10 // about 1% false positives at capacity
+	bloomHashes         = 7
+	bloomCounterMax     = 0xf
+)
+
+// keyFilter is a counting bloom filter over the keys of the stored chunks
+//
+// It answers most lookups of chunks the store does not have without reading the
+// database. Each position has a 4 bit counter instead of a bit, so that keys can
+// be removed when their chunks are deleted. A saturated counter is never
+// decremented, which can only cause false positives.
+//
+// The filter is built from the index in the background when the store is opened,
+// until then every key may be contained.
+type keyFilter struct {
+	lock     sync.Mutex
+	counters []byte // two counters per byte
+	size     uint64 // number of counters
+	ready    bool
+	built    chan struct{} // closed when the filter is built
+}
+
+func newKeyFilter(capacity uint64) *keyFilter {
+	size := capacity * bloomCountersPerKey
+	if size < 64 {
+		size = 64
+	}
+	return &keyFilter{
+		counters: make([]byte, (size+1)/2),
+		size:     size,
+		built:    make(chan struct{}),
+	}
+}
+
+// calls f with the positions of the key, derived from the key itself, which is a hash
+func (f *keyFilter) positions(key Key, fn func(uint64)) {
+	var h1, h2 uint64
+	if len(key) >= 16 {
+		h1 = binary.BigEndian.Uint64(key[:8])
+		h2 = binary.BigEndian.Uint64(key[8:16])
+	}
+	h2 |= 1
+	for i := uint64(0); i < bloomHashes; i++ {
+		fn((h1 + i*h2) % f.size)
+	}
+}
+
+func (f *keyFilter) counter(i uint64) byte {
+	return f.counters[i/2] >> (4 * (i % 2)) & 0xf
+}
+
+func (f *keyFilter) setCounter(i uint64, c byte) {
+	shift := 4 * (i % 2)
+	f.counters[i/2] = f.counters[i/2]&^(0xf<<shift) | c<<shift
+}
+
+// add records the key of a stored chunk
+func (f *keyFilter) add(key Key) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.positions(key, func(i uint64) {
+		if c := f.counter(i); c < bloomCounterMax {
+			f.setCounter(i, c+1)
+		}
+	})
+}
+
+// remove forgets the key of a deleted chunk
+//
+// Removals are ignored while the filter is built, a key may not have been added
+// yet. The filter then gives a false positive for the deleted chunk.
+func (f *keyFilter) remove(key Key) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.ready {
+		return
+	}
+	f.positions(key, func(i uint64) {
+		if c := f.counter(i); c > 0 && c < bloomCounterMax {
+			f.setCounter(i, c-1)
+		}
+	})
+}
+
+// mayContain reports false if the key is certainly not stored
+func (f *keyFilter) mayContain(key Key) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.ready {
+		return true
+	}
+	contains := true
+	f.positions(key, func(i uint64) {
+		if f.counter(i) == 0 {
+			contains = false
+		}
+	})
+	return contains
+}
+
+// adds the keys of the index to the filter of the store
+//
+// Chunks put meanwhile are added by the store, a chunk added twice only causes
+// a counter to stay up after its deletion.
+func (s *LDBStore) buildKeyFilter() {
+	f := s.keyFilter
+	defer close(f.built)
+
+	it := s.db.NewIterator()
+	defer it.Release()
+
+	var count int
+	for ok := it.Seek([]byte{keyIndex}); ok; ok = it.Next() {
+		key := it.Key()
+		if key[0] != keyIndex {
+			break
+		}
+		f.add(Key(key[1:]))
+		count++
+	}
+	if err := it.Error(); err == leveldb.ErrClosed {
+		return
+	} else if err != nil {
+		log.Warn("ldbstore: key filter not built, all lookups read the database", "err", err)
+		return
+	}
+	f.lock.Lock()
+	f.ready = true
+	f.lock.Unlock()
+	log.Debug("ldbstore: key filter built", "keys", count)
+}
+
+// reads the index entry of a chunk, unless the key filter tells it is not stored
+func (s *LDBStore) getIndexData(ikey []byte) ([]byte, error) {
+	if !s.keyFilter.mayContain(Key(ikey[1:])) {
+		metrics.GetOrRegisterCounter("ldbstore.keyfilter.miss", nil).Inc(1)
+		return nil, ErrChunkNotFound
+	}
+	return s.db.Get(ikey)
+}
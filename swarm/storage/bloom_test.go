@@ -0,0 +1,117 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestKeyFilter(t *testing.T) {
+	n := 1000
+	f := newKeyFilter(uint64(n))
+	f.ready = true
+
+	var keys []Key
+	for i := 0; i < n; i++ {
+		key := NewRandomChunk(chunkSize).Key
+		keys = append(keys, key)
+		f.add(key)
+	}
+	for _, key := range keys {
+		if !f.mayContain(key) {
+			t.Fatalf("expected added key %v to be contained", key)
+		}
+	}
+	var positives int
+	for i := 0; i < n; i++ {
+		if f.mayContain(NewRandomChunk(chunkSize).Key) {
+			positives++
+		}
+	}
+	if positives > n/20 {
+		t.Fatalf("too many false positives: %d of %d", positives, n)
+	}
+
+	// removed keys are not contained, the others still are
+	for _, key := range keys[:n/2] {
+		f.remove(key)
+	}
+	var removed int
+	for _, key := range keys[:n/2] {
+		if !f.mayContain(key) {
+			removed++
+		}
+	}
+	if removed < n/4 {
+		t.Fatalf("expected most removed keys not to be contained, %d of %d are not", removed, n/2)
+	}
+	for _, key := range keys[n/2:] {
+		if !f.mayContain(key) {
+			t.Fatalf("expected key %v to be contained after removals", key)
+		}
+	}
+}
+
+// TestLDBStoreKeyFilter tests that the key filter is built from the stored chunks
+// when the store is opened
+func TestLDBStoreKeyFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	params := NewLDBStoreParams(NewDefaultStoreParams(), dir)
+	params.Po = testPoFunc
+	ldb, err := NewLDBStore(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := GenerateRandomChunks(chunkSize, 100)
+	for _, c := range chunks {
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+	ldb.Close()
+
+	ldb, err = NewLDBStore(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	<-ldb.keyFilter.built
+	if !ldb.keyFilter.ready {
+		t.Fatal("expected key filter to be built")
+	}
+
+	for _, c := range chunks {
+		if _, err := ldb.Get(c.Key); err != nil {
+			t.Fatalf("chunk %v: %v", c.Key, err)
+		}
+	}
+	missing := NewRandomChunk(chunkSize)
+	if _, err := ldb.Get(missing.Key); err != ErrChunkNotFound {
+		t.Fatalf("expected ErrChunkNotFound, got %v", err)
+	}
+	ldb.Put(missing)
+	<-missing.dbStoredC
+	if _, err := ldb.Get(missing.Key); err != nil {
+		t.Fatalf("expected chunk put after the filter was built to be found: %v", err)
+	}
+}
@@ -76,9 +76,10 @@ type ChunkerParams struct {
 
 type SplitterParams struct {
 	ChunkerParams
-	reader io.Reader
-	putter Putter
-	key    Key
+	reader  io.Reader
+	putter  Putter
+	key     Key
+	workers int64 // maximum number of hashing workers
 }
 
 type TreeSplitterParams struct {
@@ -104,6 +105,7 @@ type TreeChunker struct {
 	depth       int
 	hashSize    int64        // self.hashFunc.New().Size()
 	chunkSize   int64        // hashSize* branches
+	workers     int64        // the maximum number of worker routines
 	workerCount int64        // the number of worker routines used
 	workerLock  sync.RWMutex // lock for the worker count
 	jobC        chan *hashJob
@@ -176,8 +178,9 @@ func NewTreeSplitterParams(reader io.Reader, putter Putter, size int64, branches
 				chunkSize: chunkSize,
 				hashSize:  hashSize,
 			},
-			reader: reader,
-			putter: putter,
+			reader:  reader,
+			putter:  putter,
+			workers: int64(defaultWorkers()),
 		},
 		size: size,
 	}
@@ -192,8 +195,9 @@ func NewTreeSplitter(params *TreeSplitterParams) *TreeChunker {
 	self.key = params.key
 	self.chunkSize = self.hashSize * self.branches
 	self.putter = params.putter
+	self.workers = params.workers
 	self.workerCount = 0
-	self.jobC = make(chan *hashJob, 2*ChunkProcessors)
+	self.jobC = make(chan *hashJob, 2*self.workers)
 	self.wg = &sync.WaitGroup{}
 	self.errC = make(chan error)
 	self.quitC = make(chan bool)
@@ -335,7 +339,7 @@ func (self *TreeChunker) split(depth int, treeSize int64, key Key, size int64, p
 	childrenWg.Wait()
 
 	worker := self.getWorkerCount()
-	if int64(len(self.jobC)) > worker && worker < ChunkProcessors {
+	if int64(len(self.jobC)) > worker && worker < self.workers {
 		self.runWorker()
 
 	}
@@ -424,6 +428,7 @@ func (self *LazyChunkReader) Size(quitC chan bool) (n int64, err error) {
 // read at can be called numerous times
 // concurrent reads are allowed
 // Size() needs to be called synchronously on the LazyChunkReader first
+// only the chunks covering the requested range are retrieved
 func (self *LazyChunkReader) ReadAt(b []byte, off int64) (read int, err error) {
 	metrics.GetOrRegisterCounter("lazychunkreader.readat", nil).Inc(1)
 
@@ -431,12 +436,22 @@ func (self *LazyChunkReader) ReadAt(b []byte, off int64) (read int, err error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
+	if off < 0 {
+		return 0, errOffset
+	}
 	quitC := make(chan bool)
 	size, err := self.Size(quitC)
 	if err != nil {
 		log.Error("lazychunkreader.readat.size", "size", size, "err", err)
 		return 0, err
 	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	// do not retrieve chunks beyond the end of the content
+	if off+int64(len(b)) > size {
+		b = b[:size-off]
+	}
 
 	errC := make(chan error)
 
@@ -177,8 +177,8 @@ func TestSha3ForCorrectness(t *testing.T) {
 }
 
 func TestDataAppend(t *testing.T) {
-	sizes := []int{1, 1, 1, 4095, 4096, 4097, 1, 1, 1, 123456, 2345678, 2345678}
-	appendSizes := []int{4095, 4096, 4097, 1, 1, 1, 8191, 8192, 8193, 9000, 3000, 5000}
+	sizes := []int{1, 1, 1, 4095, 4096, 4097, 1, 1, 1, 123456, 2345678, 2345678, 524288, 524289}
+	appendSizes := []int{4095, 4096, 4097, 1, 1, 1, 8191, 8192, 8193, 9000, 3000, 5000, 1, 524288}
 
 	tester := &chunkerTester{t: t}
 	for i := range sizes {
@@ -238,7 +238,7 @@ func TestDataAppend(t *testing.T) {
 }
 
 func TestRandomData(t *testing.T) {
-	sizes := []int{1, 60, 83, 179, 253, 1024, 4095, 4096, 4097, 8191, 8192, 8193, 12287, 12288, 12289, 123456, 2345678}
+	sizes := []int{1, 60, 83, 179, 253, 1024, 4095, 4096, 4097, 8191, 8192, 8193, 12287, 12288, 12289, 123456, 524288, 524289, 528384, 2345678}
 	tester := &chunkerTester{t: t}
 
 	for _, s := range sizes {
@@ -258,6 +258,79 @@ func TestRandomData(t *testing.T) {
 	}
 }
 
+// TestLazyChunkReaderRandomAccess tests that ranges of the content can be
+// read at any offset and only the chunks covering the range are retrieved
+func TestLazyChunkReaderRandomAccess(t *testing.T) {
+	store := &countingChunkStore{ChunkStore: NewMapChunkStore()}
+	putGetter := newTestHasherStore(store, SHA3Hash)
+	_, data := generateRandomData(2345678)
+	key, wait, err := PyramidSplit(bytes.NewReader(data), putGetter, putGetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	reader := TreeJoin(key, putGetter, 0)
+	size, err := reader.Size(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		off, length int64
+	}{
+		{0, 1},
+		{4095, 2},
+		{524287, 2},
+		{1234567, 10000},
+		{2345670, 100},
+		{2345678, 10},
+		{2345679, 1},
+	} {
+		store.gets = 0
+		b := make([]byte, c.length)
+		n, err := reader.ReadAt(b, c.off)
+		end := c.off + c.length
+		if end > size {
+			end = size
+		}
+		var expected []byte
+		if c.off < size {
+			expected = data[c.off:end]
+		}
+		if c.off+c.length >= size && err != io.EOF {
+			t.Fatalf("expected EOF reading %d bytes at %d, got %v", c.length, c.off, err)
+		}
+		if c.off+c.length < size && err != nil {
+			t.Fatalf("reading %d bytes at %d: %v", c.length, c.off, err)
+		}
+		if !bytes.Equal(b[:n], expected) {
+			t.Fatalf("unexpected content reading %d bytes at %d", c.length, c.off)
+		}
+		// the root chunk is kept by the reader, the data chunks of the range
+		// and the tree chunks above them are retrieved
+		chunks := 0
+		if c.off < size {
+			chunks = int((end-1)/4096-c.off/4096+1) + int((end-1)/524288-c.off/524288+1)
+		}
+		if store.gets != chunks {
+			t.Fatalf("expected %d chunks retrieved reading %d bytes at %d, got %d", chunks, c.length, c.off, store.gets)
+		}
+	}
+
+	// read the end of the content after seeking
+	if _, err := reader.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 20)
+	n, err := reader.Read(b)
+	if err != io.EOF || !bytes.Equal(b[:n], data[len(data)-10:]) {
+		t.Fatalf("unexpected read after seek: %d bytes, %v", n, err)
+	}
+	if n, err := reader.Read(b); n != 0 || err != io.EOF {
+		t.Fatalf("expected EOF at the end of the content, got %d bytes, %v", n, err)
+	}
+}
+
 func TestRandomBrokenData(t *testing.T) {
 	sizes := []int{1, 60, 83, 179, 253, 1024, 4095, 4096, 4097, 8191, 8192, 8193, 12287, 12288, 12289, 123456, 2345678}
 	tester := &chunkerTester{t: t}
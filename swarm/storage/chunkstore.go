@@ -16,7 +16,11 @@
 
 package storage
 
-import "sync"
+import (
+	"context"
+	"io"
+	"sync"
+)
 
 /*
 ChunkStore interface is implemented by :
@@ -34,6 +38,36 @@ type ChunkStore interface {
 	Close()
 }
 
+// ContextGetter is implemented by the chunk stores whose retrievals can be
+// given a deadline or be cancelled with a context
+type ContextGetter interface {
+	GetWithContext(ctx context.Context, key Key) (*Chunk, error)
+}
+
+// Checker is implemented by the chunk stores which can tell if they store a
+// chunk locally without retrieving it from the network
+type Checker interface {
+	Has(key Key) bool
+}
+
+// contextChunkStore gets the chunks of a ChunkStore with a context, the stores
+// that do not implement ContextGetter are only checked for the context before
+// each get
+type contextChunkStore struct {
+	ChunkStore
+	ctx context.Context
+}
+
+func (c *contextChunkStore) Get(key Key) (*Chunk, error) {
+	if getter, ok := c.ChunkStore.(ContextGetter); ok {
+		return getter.GetWithContext(c.ctx, key)
+	}
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.ChunkStore.Get(key)
+}
+
 // MapChunkStore is a very simple ChunkStore implementation to store chunks in a map in memory.
 type MapChunkStore struct {
 	chunks map[string]*Chunk
@@ -63,5 +97,46 @@ func (m *MapChunkStore) Get(key Key) (*Chunk, error) {
 	return chunk, nil
 }
 
+// Has tells if the chunk is in the map
+func (m *MapChunkStore) Has(key Key) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.chunks[key.Hex()]
+	return ok
+}
+
 func (m *MapChunkStore) Close() {
 }
+
+// ChunkKeys returns the key of the content and the keys of the chunks it is
+// split into when it is stored without encryption, without storing it
+func ChunkKeys(data io.Reader, size int64) (Key, []Key, error) {
+	store := &keyStore{}
+	key, wait, err := NewDPA(store, NewDPAParams()).Store(data, size, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	wait()
+	return key, store.keys, nil
+}
+
+// keyStore is a ChunkStore recording the keys of the chunks put in it and
+// dropping their data
+type keyStore struct {
+	mu   sync.Mutex
+	keys []Key
+}
+
+func (s *keyStore) Put(chunk *Chunk) {
+	s.mu.Lock()
+	s.keys = append(s.keys, chunk.Key)
+	s.mu.Unlock()
+	chunk.markAsStored()
+}
+
+func (s *keyStore) Get(key Key) (*Chunk, error) {
+	return nil, ErrChunkNotFound
+}
+
+func (s *keyStore) Close() {
+}
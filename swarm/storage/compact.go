@@ -0,0 +1,87 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// returns the key ranges the database is compacted in, one for each proximity
+// order bin of the chunk data, which takes most of the space, and one for the
+// keys before and after the data
+func compactionRanges() [][2][]byte {
+	ranges := [][2][]byte{{nil, []byte{keyData}}}
+	for po := 0; po < 0x100; po++ {
+		start := []byte{keyData, byte(po)}
+		limit := []byte{keyData + 1}
+		if po < 0xff {
+			limit = []byte{keyData, byte(po + 1)}
+		}
+		ranges = append(ranges, [2][]byte{start, limit})
+	}
+	return append(ranges, [2][]byte{{keyData + 1}, nil})
+}
+
+// Compact reclaims the disk space of the garbage collected chunks
+//
+// The database is compacted range by range, and the progress is logged and
+// reported by the ldbstore.compact.progress gauge in percent. The store remains
+// available while it is compacted, concurrent calls wait for each other.
+func (s *LDBStore) Compact() error {
+	s.compactLock.Lock()
+	defer s.compactLock.Unlock()
+
+	metrics.GetOrRegisterCounter("ldbstore.compact", nil).Inc(1)
+	progress := metrics.GetOrRegisterGauge("ldbstore.compact.progress", nil)
+	start := time.Now()
+	log.Info("ldbstore: compaction started")
+
+	ranges := compactionRanges()
+	lastLog := start
+	for i, r := range ranges {
+		if err := s.db.Compact(r[0], r[1]); err != nil {
+			log.Error("ldbstore: compaction failed", "err", err)
+			return err
+		}
+		percent := int64((i + 1) * 100 / len(ranges))
+		progress.Update(percent)
+		if time.Since(lastLog) > 10*time.Second {
+			log.Info("ldbstore: compacting", "progress", percent, "elapsed", time.Since(start))
+			lastLog = time.Now()
+		}
+	}
+	metrics.GetOrRegisterTimer("ldbstore.compact.time", nil).UpdateSince(start)
+	log.Info("ldbstore: compaction done", "elapsed", time.Since(start))
+	return nil
+}
+
+// compacts the database every interval until the store is closed
+func (s *LDBStore) compactPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.Compact()
+		}
+	}
+}
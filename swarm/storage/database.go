@@ -24,8 +24,9 @@ import (
 
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	ldbstorage "github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const openFileLimit = 128
@@ -46,6 +47,16 @@ func NewLDBDatabase(file string) (*LDBDatabase, error) {
 	return database, nil
 }
 
+// NewMemDatabase returns a database that is only kept in memory, which is
+// useful for tests
+func NewMemDatabase() (*LDBDatabase, error) {
+	db, err := leveldb.Open(ldbstorage.NewMemStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LDBDatabase{db: db}, nil
+}
+
 func (self *LDBDatabase) Put(key []byte, value []byte) {
 	metrics.GetOrRegisterCounter("ldbdatabase.put", nil).Inc(1)
 
@@ -79,16 +90,37 @@ func (self *LDBDatabase) LastKnownTD() []byte {
 	return data
 }
 
-func (self *LDBDatabase) NewIterator() iterator.Iterator {
+func (self *LDBDatabase) NewIterator() KVIterator {
 	metrics.GetOrRegisterCounter("ldbdatabase.newiterator", nil).Inc(1)
 
 	return self.db.NewIterator(nil, nil)
 }
 
-func (self *LDBDatabase) Write(batch *leveldb.Batch) error {
+func (self *LDBDatabase) NewBatch() KVBatch {
+	return new(leveldb.Batch)
+}
+
+func (self *LDBDatabase) Write(batch KVBatch) error {
 	metrics.GetOrRegisterCounter("ldbdatabase.write", nil).Inc(1)
 
-	return self.db.Write(batch, nil)
+	b, ok := batch.(*leveldb.Batch)
+	if !ok {
+		return fmt.Errorf("unsupported batch type %T", batch)
+	}
+	return self.db.Write(b, nil)
+}
+
+// Size returns the approximate size of the database files in bytes
+func (self *LDBDatabase) Size() (uint64, error) {
+	sizes, err := self.db.SizeOf([]util.Range{{Start: nil, Limit: []byte{0xff}}})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(sizes.Sum()), nil
+}
+
+func (self *LDBDatabase) Compact(start, limit []byte) error {
+	return self.db.CompactRange(util.Range{Start: start, Limit: limit})
 }
 
 func (self *LDBDatabase) Close() {
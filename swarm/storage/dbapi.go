@@ -46,7 +46,17 @@ func (self *DBAPI) GetOrCreateRequest(key Key) (*Chunk, bool) {
 	return self.loc.GetOrCreateRequest(key)
 }
 
+// to obtain the chunks to be synced, which are not only cached
+func (self *DBAPI) GetOrCreateSyncRequest(key Key) (*Chunk, bool) {
+	return self.loc.GetOrCreateSyncRequest(key)
+}
+
 // to obtain the chunks from key or request db entry only
 func (self *DBAPI) Put(chunk *Chunk) {
 	self.loc.Put(chunk)
 }
+
+// to store several received chunks with a single database write
+func (self *DBAPI) PutBatch(chunks []*Chunk) {
+	self.loc.PutBatch(chunks)
+}
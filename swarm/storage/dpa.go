@@ -17,7 +17,9 @@
 package storage
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
 )
 
 /*
@@ -41,10 +43,18 @@ const (
 type DPA struct {
 	ChunkStore
 	hashFunc SwarmHasher
+
+	hashWorkers  int
+	storeWorkers int
+	tag          *Tag // tag counting the chunks stored, nil if not tracked
 }
 
 type DPAParams struct {
 	Hash string
+	// number of workers hashing and storing the chunks of an upload,
+	// the defaults scale with GOMAXPROCS if 0
+	HashWorkers  int
+	StoreWorkers int
 }
 
 func NewDPAParams() *DPAParams {
@@ -67,10 +77,38 @@ func NewLocalDPA(datadir string, basekey []byte) (*DPA, error) {
 
 func NewDPA(store ChunkStore, params *DPAParams) *DPA {
 	hashFunc := MakeHashFunc(params.Hash)
+	hashWorkers := params.HashWorkers
+	if hashWorkers <= 0 {
+		hashWorkers = defaultWorkers()
+	}
+	storeWorkers := params.StoreWorkers
+	if storeWorkers <= 0 {
+		storeWorkers = defaultWorkers()
+	}
 	return &DPA{
-		ChunkStore: store,
-		hashFunc:   hashFunc,
+		ChunkStore:   store,
+		hashFunc:     hashFunc,
+		hashWorkers:  hashWorkers,
+		storeWorkers: storeWorkers,
+	}
+}
+
+// WithTag returns a DPA which counts the chunks of the content it stores
+// in the tag
+func (self *DPA) WithTag(tag *Tag) *DPA {
+	dpa := *self
+	dpa.tag = tag
+	return &dpa
+}
+
+// Has tells if the chunk with the key is stored locally without retrieving
+// it, it returns ErrNotChecker if the chunk store of the DPA cannot tell
+func (self *DPA) Has(key Key) (bool, error) {
+	checker, ok := self.ChunkStore.(Checker)
+	if !ok {
+		return false, ErrNotChecker
 	}
+	return checker.Has(key), nil
 }
 
 // Public API. Main entry point for document retrieval directly. Used by the
@@ -79,8 +117,14 @@ func NewDPA(store ChunkStore, params *DPAParams) *DPA {
 // report error if retrieval of chunks within requested range time out.
 // It returns a reader with the chunk data and whether the content was encrypted
 func (self *DPA) Retrieve(key Key) (reader *LazyChunkReader, isEncrypted bool) {
+	return self.RetrieveWithContext(context.Background(), key)
+}
+
+// RetrieveWithContext is like Retrieve, but the chunks read by the reader are
+// retrieved with the context, so the reads fail once it is done
+func (self *DPA) RetrieveWithContext(ctx context.Context, key Key) (reader *LazyChunkReader, isEncrypted bool) {
 	isEncrypted = len(key) > self.hashFunc().Size()
-	getter := NewHasherStore(self.ChunkStore, self.hashFunc, isEncrypted)
+	getter := NewHasherStore(&contextChunkStore{self.ChunkStore, ctx}, self.hashFunc, isEncrypted)
 	reader = TreeJoin(key, getter, 0)
 	return
 }
@@ -88,8 +132,94 @@ func (self *DPA) Retrieve(key Key) (reader *LazyChunkReader, isEncrypted bool) {
 // Public API. Main entry point for document storage directly. Used by the
 // FS-aware API and httpaccess
 func (self *DPA) Store(data io.Reader, size int64, toEncrypt bool) (key Key, wait func(), err error) {
+	return self.StoreStream(data, toEncrypt)
+}
+
+// StoreStream stores the content read from data until EOF, its size need
+// not be known in advance. The tree of the content is built while it is
+// read and the root is only computed once data is exhausted.
+func (self *DPA) StoreStream(data io.Reader, toEncrypt bool) (key Key, wait func(), err error) {
+	putter := self.newPutter(toEncrypt)
+	params := NewPyramidSplitterParams(nil, data, putter, putter, DefaultChunkSize)
+	params.workers = int64(self.hashWorkers)
+	return NewPyramidSplitter(params).Split()
+}
+
+// Append appends the data to the content stored under the key and returns
+// the key of the new content. Only the chunks on the right edge of the tree
+// of the existing content are loaded and recomputed. The new content is
+// encrypted if the existing one is.
+func (self *DPA) Append(key Key, data io.Reader) (newKey Key, wait func(), err error) {
+	isEncrypted := len(key) > self.hashFunc().Size()
+	putter := self.newPutter(isEncrypted)
+	return PyramidAppend(key, data, putter, putter)
+}
+
+// StoreResumable stores the content read from data like StoreStream, and
+// persists the partial tree of the content every checkpointInterval bytes.
+// The checkpoint function is called with the key and size of the content
+// stored so far, an interrupted upload can be continued from the last one
+// with Resume. The chunks of the checkpoints are stored by the time the
+// upload fails.
+func (self *DPA) StoreResumable(data io.Reader, toEncrypt bool, checkpoint func(key Key, size int64)) (key Key, wait func(), err error) {
+	putter := self.newPutter(toEncrypt)
+	params := NewPyramidSplitterParams(nil, data, putter, putter, DefaultChunkSize)
+	params.workers = int64(self.hashWorkers)
+	params.checkpoint = checkpoint
+	return NewPyramidSplitter(params).Append()
+}
+
+// Resume continues an interrupted upload of the data. The key is the root of
+// the partial tree stored so far, eg. reported by StoreResumable or returned
+// by an earlier Append, and data is the complete content of which the part
+// already stored is skipped. Further checkpoints are reported to checkpoint
+// if it is not nil.
+func (self *DPA) Resume(key Key, data io.Reader, checkpoint func(key Key, size int64)) (newKey Key, wait func(), err error) {
+	reader, _ := self.Retrieve(key)
+	size, err := reader.Size(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := skipData(data, size); err != nil {
+		return nil, nil, err
+	}
+	isEncrypted := len(key) > self.hashFunc().Size()
+	putter := self.newPutter(isEncrypted)
+	params := NewPyramidSplitterParams(key, data, putter, putter, DefaultChunkSize)
+	params.workers = int64(self.hashWorkers)
+	params.checkpoint = checkpoint
+	return NewPyramidSplitter(params).Append()
+}
+
+// skipData skips the size bytes at the start of the data, it fails if the
+// data is shorter
+func skipData(data io.Reader, size int64) error {
+	seeker, ok := data.(io.Seeker)
+	if !ok {
+		_, err := io.CopyN(ioutil.Discard, data, size)
+		return err
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if end-offset < size {
+		return io.ErrUnexpectedEOF
+	}
+	_, err = seeker.Seek(offset+size, io.SeekStart)
+	return err
+}
+
+// newPutter returns the hasherStore storing the chunks of an upload
+func (self *DPA) newPutter(toEncrypt bool) *hasherStore {
 	putter := NewHasherStore(self.ChunkStore, self.hashFunc, toEncrypt)
-	return PyramidSplit(data, putter, putter)
+	putter.storeC = make(chan struct{}, self.storeWorkers)
+	putter.tag = self.tag
+	return putter
 }
 
 func (self *DPA) HashSize() int {
@@ -18,10 +18,13 @@ package storage
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 const testDataSize = 0x1000000
@@ -131,7 +134,7 @@ func testDPA_capacity(toEncrypt bool, t *testing.T) {
 		t.Errorf("Comparison error.")
 	}
 	// Clear memStore
-	memStore.setCapacity(0)
+	memStore.SetCapacity(0)
 	// check whether it is, indeed, empty
 	dpa.ChunkStore = memStore
 	resultReader, isEncrypted = dpa.Retrieve(key)
@@ -162,3 +165,209 @@ func testDPA_capacity(toEncrypt bool, t *testing.T) {
 		t.Errorf("Comparison error after clearing memStore.")
 	}
 }
+
+// countingChunkStore counts the chunks retrieved from the chunk store
+type countingChunkStore struct {
+	ChunkStore
+	gets int
+}
+
+func (s *countingChunkStore) Get(key Key) (*Chunk, error) {
+	s.gets++
+	return s.ChunkStore.Get(key)
+}
+
+func TestDPAAppend(t *testing.T) {
+	testDPAAppend(false, t)
+	testDPAAppend(true, t)
+}
+
+func testDPAAppend(toEncrypt bool, t *testing.T) {
+	store := &countingChunkStore{ChunkStore: NewMapChunkStore()}
+	dpa := NewDPA(store, NewDPAParams())
+
+	_, data := generateRandomData(2345678)
+	fullKey, wait, err := dpa.Store(bytes.NewReader(data), int64(len(data)), toEncrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	// append the rest of the data to the first part
+	split := 1234567
+	key, wait, err := dpa.Store(bytes.NewReader(data[:split]), int64(split), toEncrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	store.gets = 0
+	appendedKey, wait, err := dpa.Append(key, bytes.NewReader(data[split:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	// the root, the right most tree chunk and the unfinished data chunk
+	if store.gets > 3 {
+		t.Fatalf("expected only the right edge of the tree to be loaded, got %d chunks", store.gets)
+	}
+	checkDPAContent(t, dpa, appendedKey, data, toEncrypt)
+	if !toEncrypt && !bytes.Equal(appendedKey, fullKey) {
+		t.Fatalf("expected the key of the appended content %v to be the key of the whole content %v", appendedKey, fullKey)
+	}
+
+	// resume the upload of the whole data from the first part
+	resumedKey, wait, err := dpa.Resume(key, bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	checkDPAContent(t, dpa, resumedKey, data, toEncrypt)
+	if !toEncrypt && !bytes.Equal(resumedKey, fullKey) {
+		t.Fatalf("expected the key of the resumed content %v to be the key of the whole content %v", resumedKey, fullKey)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection lost")
+}
+
+func TestDPAResumeCheckpoint(t *testing.T) {
+	defer func(interval uint64) { checkpointInterval = interval }(checkpointInterval)
+	checkpointInterval = uint64(8 * DefaultChunkSize)
+
+	for _, toEncrypt := range []bool{false, true} {
+		dpa := NewDPA(NewMapChunkStore(), NewDPAParams())
+		_, data := generateRandomData(2345678)
+		fullKey, wait, err := dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+
+		// the upload is interrupted after some checkpoints
+		var lastKey Key
+		var lastSize int64
+		checkpoint := func(key Key, size int64) {
+			if size <= lastSize || size%int64(checkpointInterval) != 0 {
+				t.Fatalf("unexpected checkpoint at %d after %d", size, lastSize)
+			}
+			lastKey, lastSize = key, size
+		}
+		interrupted := io.MultiReader(bytes.NewReader(data[:1234567]), failingReader{})
+		if _, _, err := dpa.StoreResumable(interrupted, toEncrypt, checkpoint); err == nil {
+			t.Fatal("expected error for interrupted upload")
+		}
+		if lastSize != 1234567/int64(checkpointInterval)*int64(checkpointInterval) {
+			t.Fatalf("expected last checkpoint before 1234567, got %d", lastSize)
+		}
+		checkDPAContent(t, dpa, lastKey, data[:lastSize], toEncrypt)
+
+		// it is resumed from the partial tree of the last checkpoint
+		resumedKey, wait, err := dpa.Resume(lastKey, bytes.NewReader(data), checkpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		checkDPAContent(t, dpa, resumedKey, data, toEncrypt)
+		if !toEncrypt && !bytes.Equal(resumedKey, fullKey) {
+			t.Fatalf("expected the key of the resumed content %v to be the key of the whole content %v", resumedKey, fullKey)
+		}
+
+		// the data must contain the part already stored
+		if _, _, err := dpa.Resume(lastKey, bytes.NewReader(data[:lastSize-1]), nil); err == nil {
+			t.Fatal("expected error for data shorter than the stored part")
+		}
+	}
+}
+
+func checkDPAContent(t *testing.T, dpa *DPA, key Key, data []byte, toEncrypt bool) {
+	reader, isEncrypted := dpa.Retrieve(key)
+	if isEncrypted != toEncrypt {
+		t.Fatalf("isEncrypted expected %v got %v", toEncrypt, isEncrypted)
+	}
+	result := make([]byte, len(data))
+	n, err := reader.ReadAt(result, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Retrieve error: %v", err)
+	}
+	if n != len(data) || !bytes.Equal(result, data) {
+		t.Fatalf("expected the retrieved content to be the data, got %d bytes", n)
+	}
+}
+
+// concurrentChunkStore records the maximum number of chunks put concurrently
+type concurrentChunkStore struct {
+	ChunkStore
+	mu      sync.Mutex
+	puts    int
+	maxPuts int
+}
+
+func (s *concurrentChunkStore) Put(chunk *Chunk) {
+	s.mu.Lock()
+	s.puts++
+	if s.puts > s.maxPuts {
+		s.maxPuts = s.puts
+	}
+	s.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	s.ChunkStore.Put(chunk)
+	s.mu.Lock()
+	s.puts--
+	s.mu.Unlock()
+}
+
+// TestDPAWorkers tests that the content is split to the same key with any
+// number of workers and the number of chunks stored concurrently is limited
+func TestDPAWorkers(t *testing.T) {
+	_, data := generateRandomData(300 * 4096)
+	var expKey Key
+	for _, workers := range []int{1, 3, 0} {
+		store := &concurrentChunkStore{ChunkStore: NewMapChunkStore()}
+		dpa := NewDPA(store, &DPAParams{Hash: DefaultHash, HashWorkers: workers, StoreWorkers: workers})
+		key, wait, err := dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		if expKey == nil {
+			expKey = key
+		} else if !bytes.Equal(key, expKey) {
+			t.Fatalf("%d workers: expected key %v, got %v", workers, expKey, key)
+		}
+		max := workers
+		if max == 0 {
+			max = defaultWorkers()
+		}
+		if store.maxPuts > max {
+			t.Fatalf("%d workers: expected at most %d concurrent puts, got %d", workers, max, store.maxPuts)
+		}
+		checkDPAContent(t, dpa, key, data, false)
+	}
+}
+
+// TestDPAStoreStream tests that content of unknown size is split to the same
+// key as with the tree chunker which needs the size in advance
+func TestDPAStoreStream(t *testing.T) {
+	for _, n := range []int{0, 1, 4096, 4097, 128 * 4096, 128*4096 + 1, 300*4096 + 123} {
+		_, data := generateRandomData(n)
+		putter := NewHasherStore(NewMapChunkStore(), MakeHashFunc(DefaultHash), false)
+		expKey, _, err := TreeSplit(bytes.NewReader(data), int64(n), putter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dpa := NewDPA(NewMapChunkStore(), NewDPAParams())
+		// hide the size of the data
+		key, wait, err := dpa.StoreStream(ioutil.NopCloser(bytes.NewReader(data)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		if !bytes.Equal(key, expKey) {
+			t.Fatalf("%d bytes: expected key %v, got %v", n, expKey, key)
+		}
+		checkDPAContent(t, dpa, key, data, false)
+	}
+}
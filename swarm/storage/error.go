@@ -2,6 +2,7 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 )
 
 const (
@@ -16,6 +17,7 @@ const (
 	ErrInvalidSignature
 	ErrNotSynced
 	ErrPeriodDepth
+	ErrResourceRevoked
 	ErrCnt
 )
 
@@ -26,4 +28,17 @@ var (
 	ErrChunkForward     = errors.New("cannot forward")
 	ErrChunkUnavailable = errors.New("chunk unavailable")
 	ErrChunkTimeout     = errors.New("timeout")
+	ErrNotChecker       = errors.New("chunk store cannot tell which chunks it stores")
 )
+
+// RetrievalError is returned if a chunk could not be retrieved from the
+// network, it records how many times the chunk was requested
+type RetrievalError struct {
+	Key      Key
+	Attempts int
+	Err      error
+}
+
+func (e *RetrievalError) Error() string {
+	return fmt.Sprintf("retrieving chunk %s: %v after %d attempts", e.Key.Log(), e.Err, e.Attempts)
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// Chunks with an expiry are removed by a background sweeper once they expire
+//
+// The expiry is kept in the index entry of the chunk and, for the sweeper to find
+// expired chunks without reading the whole index, in an entry keyed by the expiry
+// and the chunk key. These entries are not removed when the chunk is garbage
+// collected or its expiry changes, the sweeper skips them if they do not match
+// the index.
+
+// interval of the sweeps removing expired chunks
+var expirySweepInterval = time.Minute
+
+func getExpiryKey(expires uint64, hash Key) []byte {
+	key := make([]byte, 9+len(hash))
+	key[0] = keyExpiry
+	binary.BigEndian.PutUint64(key[1:9], expires)
+	copy(key[9:], hash)
+	return key
+}
+
+// returns the expiry of the chunk as stored in the index
+func expiryOf(chunk *Chunk) uint64 {
+	if chunk.Expires.IsZero() {
+		return 0
+	}
+	return uint64(chunk.Expires.Unix())
+}
+
+// returns the expiry of a chunk stored again, which only expires if both
+// puts had an expiry, and then with the later one
+func mergeExpiry(stored, put uint64) uint64 {
+	if stored == 0 || put == 0 {
+		return 0
+	}
+	if put > stored {
+		return put
+	}
+	return stored
+}
+
+func isExpired(index *dpaDBIndex, now uint64) bool {
+	return index.Expires != 0 && index.Expires <= now
+}
+
+// removes the expired chunks every expirySweepInterval until the store is closed
+func (s *LDBStore) sweep() {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-ticker.C:
+			// a full round may have left more expired chunks
+			for s.sweepExpired(now) == maxGCitems {
+				log.Trace("ldbstore.sweep: more expired chunks")
+			}
+		}
+	}
+}
+
+// removes up to maxGCitems chunks expired by now and returns the number of
+// expiry entries processed
+func (s *LDBStore) sweepExpired(now time.Time) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the pending batch may update the index entries being checked
+	s.flushBatch()
+
+	it := s.db.NewIterator()
+	defer it.Release()
+
+	var keys [][]byte
+	limit := getExpiryKey(uint64(now.Unix())+1, nil)
+	for ok := it.Seek([]byte{keyExpiry}); ok && len(keys) < maxGCitems; ok = it.Next() {
+		key := it.Key()
+		if key[0] != keyExpiry || string(key[:9]) >= string(limit) {
+			break
+		}
+		keys = append(keys, append([]byte{}, key...))
+	}
+	it.Release()
+
+	var removed int
+	batch := s.db.NewBatch()
+	for _, key := range keys {
+		batch.Delete(key)
+		hash := Key(key[9:])
+		ikey := getIndexKey(hash)
+		idata, err := s.db.Get(ikey)
+		if err != nil {
+			continue
+		}
+		var index dpaDBIndex
+		decodeIndex(idata, &index)
+		if index.Expires != binary.BigEndian.Uint64(key[1:9]) {
+			continue
+		}
+		s.delete(index.Idx, ikey, s.po(hash), index.Cached > 0)
+		removed++
+	}
+	if err := s.db.Write(batch); err != nil {
+		log.Error("ldbstore.sweep: unable to remove expiry entries", "err", err)
+	}
+	if removed > 0 {
+		metrics.GetOrRegisterCounter("ldbstore.sweep.delete", nil).Inc(int64(removed))
+		log.Debug("ldbstore.sweep", "expired", removed)
+	}
+	return len(keys)
+}
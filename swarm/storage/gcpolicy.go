@@ -0,0 +1,82 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+// GCPolicy determines the order in which the chunks of an LDBStore are garbage
+// collected when the store exceeds its capacity
+//
+// Value is called for every chunk considered for collection with its key, its
+// proximity order to the base key of the store and its usage recorded in the
+// index. Chunks with the smallest values are collected first, ties are broken by
+// the last access.
+type GCPolicy interface {
+	Value(key Key, po uint8, usage *ChunkUsage) uint64
+}
+
+// ChunkUsage is the usage of a chunk recorded in its index entry
+//
+// The number of accesses is approximate, repeated accesses before the index of
+// the chunk is written to the database are counted once.
+type ChunkUsage struct {
+	Access uint64 // access counter value of the last access, by a put or a retrieval
+	Hits   uint64 // number of accesses since the chunk was stored, halved regularly as the store is accessed
+	Served uint64 // unix time of the last retrieval, 0 if the chunk was never retrieved
+}
+
+// LRUGCPolicy collects the least recently accessed chunks first
+//
+// This is the default policy of LDBStore.
+type LRUGCPolicy struct{}
+
+func (LRUGCPolicy) Value(key Key, po uint8, usage *ChunkUsage) uint64 {
+	return usage.Access
+}
+
+// LRSGCPolicy collects the least recently served chunks first
+//
+// Unlike with LRUGCPolicy, storing a chunk again, as syncing does, does not
+// retain it, so the chunks retrieved from the node stay longest. Chunks never
+// retrieved are collected first, in the order they were last stored.
+type LRSGCPolicy struct{}
+
+func (LRSGCPolicy) Value(key Key, po uint8, usage *ChunkUsage) uint64 {
+	return usage.Served
+}
+
+// ProximityGCPolicy collects the least recently accessed chunks first, but weights
+// their last access by proximity, so that chunks nearest to the base key of the
+// store are retained longer
+//
+// A chunk with proximity order po is valued as if it was last accessed po*Weight
+// accesses later than it actually was.
+type ProximityGCPolicy struct {
+	Weight uint64
+}
+
+func (self ProximityGCPolicy) Value(key Key, po uint8, usage *ChunkUsage) uint64 {
+	return usage.Access + uint64(po)*self.Weight
+}
+
+// MostAccessedGCPolicy retains the most frequently accessed chunks and collects
+// the chunks with the fewest accesses first
+//
+// The accesses decay, so that chunks no longer accessed are eventually collected.
+type MostAccessedGCPolicy struct{}
+
+func (MostAccessedGCPolicy) Value(key Key, po uint8, usage *ChunkUsage) uint64 {
+	return usage.Hits
+}
@@ -17,6 +17,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 
@@ -24,6 +25,8 @@ import (
 	"github.com/ethereum/go-ethereum/swarm/storage/encryption"
 )
 
+var errInvalidFileKey = errors.New("invalid file encryption key")
+
 type chunkEncryption struct {
 	spanEncryption encryption.Encryption
 	dataEncryption encryption.Encryption
@@ -37,6 +40,14 @@ type hasherStore struct {
 	refSize         int64 // reference size (content hash + possibly encryption key)
 	wg              *sync.WaitGroup
 	closed          chan struct{}
+
+	// the encryption keys of the chunks are derived from the file key
+	fileKey encryption.Key
+
+	// tag counting the chunks put, nil if the upload is not tracked
+	tag *Tag
+	// limits the number of chunks stored concurrently
+	storeC chan struct{}
 }
 
 func newChunkEncryption(chunkSize, refSize int64) *chunkEncryption {
@@ -49,7 +60,20 @@ func newChunkEncryption(chunkSize, refSize int64) *chunkEncryption {
 // NewHasherStore creates a hasherStore object, which implements Putter and Getter interfaces.
 // With the HasherStore you can put and get chunk data (which is just []byte) into a ChunkStore
 // and the hasherStore will take core of encryption/decryption of data if necessary
+// The encryption keys of the chunks are derived from a random file key.
 func NewHasherStore(chunkStore ChunkStore, hashFunc SwarmHasher, toEncrypt bool) *hasherStore {
+	var fileKey encryption.Key
+	if toEncrypt {
+		var err error
+		// Put fails without a file key
+		if fileKey, err = encryption.GenerateRandomKey(); err != nil {
+			fileKey = nil
+		}
+	}
+	return newHasherStore(chunkStore, hashFunc, toEncrypt, fileKey)
+}
+
+func newHasherStore(chunkStore ChunkStore, hashFunc SwarmHasher, toEncrypt bool, fileKey encryption.Key) *hasherStore {
 	var chunkEncryption *chunkEncryption
 
 	hashSize := hashFunc().Size()
@@ -67,6 +91,8 @@ func NewHasherStore(chunkStore ChunkStore, hashFunc SwarmHasher, toEncrypt bool)
 		refSize:         refSize,
 		wg:              &sync.WaitGroup{},
 		closed:          make(chan struct{}),
+		fileKey:         fileKey,
+		storeC:          make(chan struct{}, defaultWorkers()),
 	}
 }
 
@@ -85,6 +111,9 @@ func (h *hasherStore) Put(chunkData ChunkData) (Reference, error) {
 		}
 	}
 	chunk := h.createChunk(c, size)
+	if h.tag != nil {
+		h.tag.chunkSplit(chunk.Key)
+	}
 
 	h.storeChunk(chunk)
 
@@ -152,7 +181,7 @@ func (p *hasherStore) encryptChunkData(chunkData ChunkData) (ChunkData, encrypti
 		return nil, nil, fmt.Errorf("Invalid ChunkData, min length 8 got %v", len(chunkData))
 	}
 
-	encryptionKey, err := encryption.GenerateRandomKey()
+	encryptionKey, err := p.chunkKey(chunkData)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -171,6 +200,19 @@ func (p *hasherStore) encryptChunkData(chunkData ChunkData) (ChunkData, encrypti
 	return c, encryptionKey, nil
 }
 
+// chunkKey derives the encryption key of the chunk from the file key and the
+// hash of the chunk data, so the same content is encrypted the same way with
+// the same file key
+func (h *hasherStore) chunkKey(chunkData ChunkData) (encryption.Key, error) {
+	if len(h.fileKey) != encryption.KeyLength {
+		return nil, errInvalidFileKey
+	}
+	hasher := sha3.NewKeccak256()
+	hasher.Write(h.fileKey)
+	hasher.Write(h.createHash(chunkData))
+	return hasher.Sum(nil), nil
+}
+
 func (h *hasherStore) decryptChunkData(chunkData ChunkData, encryptionKey encryption.Key) (ChunkData, error) {
 	if len(chunkData) < 8 {
 		return nil, fmt.Errorf("Invalid ChunkData, min length 8 got %v", len(chunkData))
@@ -205,13 +247,20 @@ func (h *hasherStore) RefSize() int64 {
 	return h.refSize
 }
 
+// storeChunk stores the chunk asynchronously, it blocks while the maximum
+// number of chunks are being stored
 func (h *hasherStore) storeChunk(chunk *Chunk) {
 	h.wg.Add(1)
+	h.storeC <- struct{}{}
 	go func() {
+		h.store.Put(chunk)
+		<-h.storeC
 		<-chunk.dbStoredC
+		if h.tag != nil {
+			h.tag.Inc(StateStored)
+		}
 		h.wg.Done()
 	}()
-	h.store.Put(chunk)
 }
 
 func parseReference(ref Reference, hashSize int) (Key, encryption.Key, error) {
@@ -116,3 +116,50 @@ func TestHasherStore(t *testing.T) {
 		}
 	}
 }
+
+// TestHasherStoreFileKey tests that the encryption keys of the chunks are
+// derived from the file key
+func TestHasherStoreFileKey(t *testing.T) {
+	chunkStore := NewMapChunkStore()
+	fileKey := make(encryption.Key, encryption.KeyLength)
+	otherFileKey := make(encryption.Key, encryption.KeyLength)
+	otherFileKey[0] = 1
+
+	chunkData1 := GenerateRandomChunk(4096).SData
+	chunkData2 := GenerateRandomChunk(4096).SData
+	put := func(fileKey encryption.Key, chunkData ChunkData) Reference {
+		hasherStore := newHasherStore(chunkStore, MakeHashFunc(DefaultHash), true, fileKey)
+		ref, err := hasherStore.Put(chunkData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hasherStore.Close()
+		hasherStore.Wait()
+		retrieved, err := hasherStore.Get(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(retrieved, chunkData) {
+			t.Fatal("retrieved chunk data differs from the original")
+		}
+		return ref
+	}
+
+	ref := put(fileKey, chunkData1)
+	if !bytes.Equal(ref, put(fileKey, chunkData1)) {
+		t.Fatal("expected the same reference for the same content and file key")
+	}
+	if bytes.Equal(ref, put(otherFileKey, chunkData1)) {
+		t.Fatal("expected a different reference for another file key")
+	}
+	_, key1, _ := parseReference(ref, 32)
+	_, key2, _ := parseReference(put(fileKey, chunkData2), 32)
+	if bytes.Equal(key1, key2) {
+		t.Fatal("expected different encryption keys for different chunks")
+	}
+
+	hasherStore := newHasherStore(chunkStore, MakeHashFunc(DefaultHash), true, nil)
+	if _, err := hasherStore.Put(chunkData1); err != errInvalidFileKey {
+		t.Fatalf("expected error %v without a file key, got %v", errInvalidFileKey, err)
+	}
+}
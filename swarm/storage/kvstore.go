@@ -0,0 +1,66 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+// KVStore is the key-value database the LDBStore keeps the chunk data and the
+// chunk index in
+//
+// Keys are ordered bytewise, the LDBStore relies on iterating them in order.
+// LDBDatabase is the default implementation, backed by leveldb.
+type KVStore interface {
+	// Get returns the value stored under key or an error if there is none
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, value []byte)
+	Delete(key []byte) error
+	// NewIterator returns an iterator over a consistent snapshot of the store
+	NewIterator() KVIterator
+	// NewBatch returns an empty batch to be written by Write
+	NewBatch() KVBatch
+	// Write applies the operations of the batch atomically
+	Write(batch KVBatch) error
+	// Size returns the approximate size of the stored data in bytes
+	Size() (uint64, error)
+	// Compact reclaims the space of deleted and overwritten entries with keys
+	// from start up to limit, a nil limit is the end of the key space
+	Compact(start, limit []byte) error
+	Close()
+}
+
+// KVIterator iterates over the entries of a KVStore in key order
+//
+// The slices returned by Key and Value are only valid until the next move of
+// the iterator.
+type KVIterator interface {
+	// Seek moves the iterator to the first key not less than key and reports
+	// whether there is one
+	Seek(key []byte) bool
+	Next() bool
+	// Valid reports whether the iterator is positioned at an entry
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
+
+// KVBatch collects writes to be applied to a KVStore at once
+type KVBatch interface {
+	Put(key []byte, value []byte)
+	Delete(key []byte)
+	// Len returns the number of operations in the batch
+	Len() int
+}
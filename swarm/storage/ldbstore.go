@@ -32,10 +32,12 @@ import (
 	"io/ioutil"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/swarm/storage/encryption"
 	"github.com/ethereum/go-ethereum/swarm/storage/mock"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -46,6 +48,10 @@ const (
 	maxGCitems       = 5000 // max number of items to be gc'd per call to collectGarbage()
 )
 
+// the hits of a chunk are halved every hitsHalfLife accesses of the store,
+// so chunks accessed often long ago do not outlive the ones accessed often lately
+var hitsHalfLife = uint64(1 << 16)
+
 var (
 	keyIndex       = byte(0)
 	keyOldData     = byte(1)
@@ -54,19 +60,28 @@ var (
 	keyDataIdx     = []byte{4}
 	keyData        = byte(6)
 	keyDistanceCnt = byte(7)
+	keyExpiry      = byte(8)
+	keyEncryption  = []byte{9}
+	keySchema      = []byte{10}
+	keyCachedCnt   = []byte{11}
 )
 
 type gcItem struct {
 	idx    uint64
 	value  uint64
+	access uint64
 	idxKey []byte
 	po     uint8
+	cached bool
 }
 
 type LDBStoreParams struct {
 	*StoreParams
 	Path string
 	Po   func(Key) uint8
+	// Db is the key-value backend of the store, if nil the leveldb
+	// database at Path is used. The store closes it on Close.
+	Db KVStore
 }
 
 // NewLDBStoreParams constructs LDBStoreParams with the specified values.
@@ -79,7 +94,7 @@ func NewLDBStoreParams(storeparams *StoreParams, path string) *LDBStoreParams {
 }
 
 type LDBStore struct {
-	db *LDBDatabase
+	db KVStore
 
 	// this should be stored in db, accessed transactionally
 	entryCnt  uint64 // number of items in the LevelDB
@@ -88,14 +103,33 @@ type LDBStore struct {
 	capacity  uint64
 	bucketCnt []uint64
 
+	cachedCnt      uint64 // number of entries only cached after retrieval
+	cachedCapacity uint64 // limit of cachedCnt, no separate limit if 0
+
 	hashfunc SwarmHasher
 	po       func(Key) uint8
+	gcPolicy GCPolicy
+
+	keyFilter *keyFilter // avoids database reads for chunks not stored
+
+	dataKey        []byte // node-local key the chunk data is encrypted with, nil if not encrypted
+	dataEncryption encryption.Encryption
 
 	batchC   chan bool
 	batchesC chan struct{}
-	batch    *leveldb.Batch
+	gcC      chan struct{} // triggers the background garbage collection
+	quit     chan struct{}
+	quitOnce sync.Once
+	batch    KVBatch
 	lock     sync.RWMutex
 
+	compactLock sync.Mutex // serializes compactions
+
+	gcHigh      float64 // share of the capacity above which the background garbage collection starts
+	gcLow       float64 // share of the capacity the background garbage collection collects down to
+	gcBatchSize int     // chunks collected in one round of garbage collection
+	gcPaused    bool    // no chunks are garbage collected while set
+
 	// Functions encodeDataFunc is used to bypass
 	// the default functionality of DbStore with
 	// mock.NodeStore for testing purposes.
@@ -115,18 +149,28 @@ func NewLDBStore(params *LDBStoreParams) (s *LDBStore, err error) {
 
 	s.batchC = make(chan bool)
 	s.batchesC = make(chan struct{}, 1)
-	go s.writeBatches()
-	s.batch = new(leveldb.Batch)
+	s.quit = make(chan struct{})
+	s.gcC = make(chan struct{}, 1)
 	// associate encodeData with default functionality
 	s.encodeDataFunc = encodeData
 
-	s.db, err = NewLDBDatabase(params.Path)
-	if err != nil {
-		return nil, err
+	s.db = params.Db
+	if s.db == nil {
+		s.db, err = NewLDBDatabase(params.Path)
+		if err != nil {
+			return nil, err
+		}
 	}
+	s.batch = s.db.NewBatch()
+	s.keyFilter = newKeyFilter(params.ChunkDbCapacity())
 
 	s.po = params.Po
-	s.setCapacity(params.DbCapacity)
+	s.gcPolicy = params.GCPolicy
+	if s.gcPolicy == nil {
+		s.gcPolicy = LRUGCPolicy{}
+	}
+	s.initGC(params.StoreParams)
+	s.setCapacity(params.ChunkDbCapacity())
 
 	s.bucketCnt = make([]uint64, 0x100)
 	for i := 0; i < 0x100; i++ {
@@ -140,6 +184,9 @@ func NewLDBStore(params *LDBStoreParams) (s *LDBStore, err error) {
 	data, _ := s.db.Get(keyEntryCnt)
 	s.entryCnt = BytesToU64(data)
 	s.entryCnt++
+	data, _ = s.db.Get(keyCachedCnt)
+	s.cachedCnt = BytesToU64(data)
+	s.cachedCapacity = params.CachedCapacity
 	data, _ = s.db.Get(keyAccessCnt)
 	s.accessCnt = BytesToU64(data)
 	s.accessCnt++
@@ -147,6 +194,24 @@ func NewLDBStore(params *LDBStoreParams) (s *LDBStore, err error) {
 	s.dataIdx = BytesToU64(data)
 	s.dataIdx++
 
+	if err := s.initDataEncryption(params.EncryptionKey); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	if err := s.migrate(); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+
+	// the goroutines are only started once the store is opened, as nothing
+	// closes quit if it fails
+	go s.writeBatches()
+	go s.collectInBackground()
+	go s.buildKeyFilter()
+	go s.sweep()
+	if params.CompactionInterval > 0 {
+		go s.compactPeriodically(params.CompactionInterval)
+	}
 	return s, nil
 }
 
@@ -167,9 +232,18 @@ func NewMockDbStore(params *LDBStoreParams, mockStore *mock.NodeStore) (s *LDBSt
 	return
 }
 
+// index entry of a chunk
+//
+// Fields are only ever appended, entries written before a field was added are
+// decoded with the field set to zero.
 type dpaDBIndex struct {
-	Idx    uint64
-	Access uint64
+	Idx     uint64
+	Access  uint64
+	Hits    uint64 // number of accesses since the chunk was stored, decayed at the last access
+	Expires uint64 // unix time after which the chunk may be removed, 0 if it does not expire
+	Served  uint64 // unix time the chunk was last retrieved, 0 if it never was
+	Cached  uint64 // 1 if the chunk was only cached after retrieval, 0 if the node is responsible for it
+	Stored  uint64 // unix time the chunk was stored, 0 if it was stored before the time was recorded
 }
 
 func BytesToU64(data []byte) uint64 {
@@ -185,6 +259,19 @@ func U64ToBytes(val uint64) []byte {
 	return data
 }
 
+// decayHits returns the hits of the index halved for every hitsHalfLife
+// accesses of the store since the last access of the chunk
+func (s *LDBStore) decayHits(index *dpaDBIndex) uint64 {
+	if s.accessCnt <= index.Access {
+		return index.Hits
+	}
+	halvings := (s.accessCnt - index.Access) / hitsHalfLife
+	if halvings >= 64 {
+		return 0
+	}
+	return index.Hits >> halvings
+}
+
 func (s *LDBStore) updateIndexAccess(index *dpaDBIndex) {
 	index.Access = s.accessCnt
 }
@@ -228,8 +315,26 @@ func encodeData(chunk *Chunk) []byte {
 }
 
 func decodeIndex(data []byte, index *dpaDBIndex) error {
+	// all fields are integers, so entries of any version decode as a list of them
+	var fields []uint64
 	dec := rlp.NewStream(bytes.NewReader(data), 0)
-	return dec.Decode(index)
+	if err := dec.Decode(&fields); err != nil {
+		return err
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid index entry with %d fields", len(fields))
+	}
+	fields = append(fields, make([]uint64, 7)...)
+	*index = dpaDBIndex{
+		Idx:     fields[0],
+		Access:  fields[1],
+		Hits:    fields[2],
+		Expires: fields[3],
+		Served:  fields[4],
+		Cached:  fields[5],
+		Stored:  fields[6],
+	}
+	return nil
 }
 
 func decodeData(data []byte, chunk *Chunk) {
@@ -243,6 +348,14 @@ func decodeOldData(data []byte, chunk *Chunk) {
 }
 
 func (s *LDBStore) collectGarbage(ratio float32) {
+	s.collect(ratio, 0, false)
+}
+
+// collects the ratio of the least valuable chunks among the first maxGCitems
+// chunks of the index, or among the cached ones only if cachedOnly is set, but
+// no more than limit chunks if it is not 0, and returns the number collected,
+// the caller must hold the lock
+func (s *LDBStore) collect(ratio float32, limit int, cachedOnly bool) int {
 	metrics.GetOrRegisterCounter("ldbstore.collectgarbage", nil).Inc(1)
 
 	it := s.db.NewIterator()
@@ -250,6 +363,7 @@ func (s *LDBStore) collectGarbage(ratio float32) {
 
 	garbage := []*gcItem{}
 	gcnt := 0
+	now := uint64(time.Now().Unix())
 
 	for ok := it.Seek([]byte{keyIndex}); ok && (gcnt < maxGCitems) && (uint64(gcnt) < s.entryCnt); ok = it.Next() {
 		itkey := it.Key()
@@ -268,27 +382,49 @@ func (s *LDBStore) collectGarbage(ratio float32) {
 
 		hash := key[1:]
 		decodeIndex(val, &index)
+		if cachedOnly && index.Cached == 0 {
+			continue
+		}
 		po := s.po(hash)
 
 		gci := &gcItem{
 			idxKey: key,
 			idx:    index.Idx,
-			value:  index.Access, // the smaller, the more likely to be gc'd. see sort comparator below.
+			access: index.Access,
 			po:     po,
+			cached: index.Cached > 0,
+		}
+		// expired chunks are collected first, regardless of the policy, and so
+		// are cached ones if they have a capacity of their own
+		if !isExpired(&index, now) && (index.Cached == 0 || s.cachedCapacity == 0) {
+			gci.value = s.gcPolicy.Value(Key(hash), po, &ChunkUsage{Access: index.Access, Hits: s.decayHits(&index), Served: index.Served}) // the smaller, the more likely to be gc'd. see sort comparator below.
 		}
 
 		garbage = append(garbage, gci)
 		gcnt++
 	}
 
-	sort.Slice(garbage[:gcnt], func(i, j int) bool { return garbage[i].value < garbage[j].value })
+	sort.Slice(garbage[:gcnt], func(i, j int) bool {
+		if garbage[i].value == garbage[j].value {
+			return garbage[i].access < garbage[j].access
+		}
+		return garbage[i].value < garbage[j].value
+	})
 
 	cutoff := int(float32(gcnt) * ratio)
+	// always make progress, even if the ratio of a small store rounds down to zero
+	if cutoff == 0 && gcnt > 0 {
+		cutoff = 1
+	}
+	if limit > 0 && cutoff > limit {
+		cutoff = limit
+	}
 	metrics.GetOrRegisterCounter("ldbstore.collectgarbage.delete", nil).Inc(int64(cutoff))
 
 	for i := 0; i < cutoff; i++ {
-		s.delete(garbage[i].idx, garbage[i].idxKey, garbage[i].po)
+		s.delete(garbage[i].idx, garbage[i].idxKey, garbage[i].po, garbage[i].cached)
 	}
+	return cutoff
 }
 
 // Export writes all chunks from the store to a tar archive, returning the
@@ -313,7 +449,14 @@ func (s *LDBStore) Export(out io.Writer) (int64, error) {
 		po := s.po(hash)
 		datakey := getDataKey(index.Idx, po)
 		log.Trace("store.export", "dkey", fmt.Sprintf("%x", datakey), "dataidx", index.Idx, "po", po)
-		data, err := s.db.Get(datakey)
+		var data []byte
+		var err error
+		if s.getDataFunc != nil {
+			// the chunk data is in the mock store, the database only holds the key
+			data, err = s.getDataFunc(Key(hash))
+		} else {
+			data, err = s.getData(datakey)
+		}
 		if err != nil {
 			log.Warn(fmt.Sprintf("Chunk %x found but could not be accessed: %v", key[:], err))
 			continue
@@ -336,7 +479,11 @@ func (s *LDBStore) Export(out io.Writer) (int64, error) {
 	return count, nil
 }
 
+// Import reads chunks into the store from a tar archive, returning the number
 // of chunks read.
+//
+// The archive is streamed, each entry is named by the hex encoded chunk key and
+// holds the key and the data of the chunk as written by Export.
 func (s *LDBStore) Import(in io.Reader) (int64, error) {
 	tr := tar.NewReader(in)
 
@@ -365,6 +512,10 @@ func (s *LDBStore) Import(in io.Reader) (int64, error) {
 		if err != nil {
 			return count, err
 		}
+		if len(data) < len(keybytes)+8 {
+			log.Warn("ignoring truncated chunk file", "name", hdr.Name, "size", len(data))
+			continue
+		}
 		key := Key(keybytes)
 		chunk := NewChunk(key, nil)
 		chunk.SData = data[32:]
@@ -399,10 +550,10 @@ func (s *LDBStore) Cleanup() {
 			it.Next()
 			continue
 		}
-		data, err := s.db.Get(getDataKey(index.Idx, s.po(Key(key[1:]))))
+		data, err := s.getData(getDataKey(index.Idx, s.po(Key(key[1:]))))
 		if err != nil {
 			log.Warn(fmt.Sprintf("Chunk %x found but could not be accessed: %v", key[:], err))
-			s.delete(index.Idx, getIndexKey(key[1:]), s.po(Key(key[1:])))
+			s.delete(index.Idx, getIndexKey(key[1:]), s.po(Key(key[1:])), index.Cached > 0)
 			errorsFound++
 		} else {
 			hasher := s.hashfunc()
@@ -410,7 +561,7 @@ func (s *LDBStore) Cleanup() {
 			hash := hasher.Sum(nil)
 			if !bytes.Equal(hash, key[1:]) {
 				log.Warn(fmt.Sprintf("Found invalid chunk. Hash mismatch. hash=%x, key=%x", hash, key[:]))
-				s.delete(index.Idx, getIndexKey(key[1:]), s.po(Key(key[1:])))
+				s.delete(index.Idx, getIndexKey(key[1:]), s.po(Key(key[1:])), index.Cached > 0)
 			}
 		}
 		it.Next()
@@ -448,7 +599,7 @@ func (s *LDBStore) ReIndex() {
 		copy(newKey[2:], key[1:])
 		newValue := append(hash, data...)
 
-		batch := new(leveldb.Batch)
+		batch := s.db.NewBatch()
 		batch.Delete(key)
 		s.bucketCnt[oldCntKey[1]]--
 		batch.Put(oldCntKey, U64ToBytes(s.bucketCnt[oldCntKey[1]]))
@@ -462,19 +613,50 @@ func (s *LDBStore) ReIndex() {
 	log.Warn(fmt.Sprintf("Found %v errors out of %v entries", errorsFound, total))
 }
 
-func (s *LDBStore) delete(idx uint64, idxKey []byte, po uint8) {
+// Delete removes the chunk and its index entries from the store, it returns
+// ErrChunkNotFound if the chunk is not stored
+func (s *LDBStore) Delete(key Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the pending batch may hold the chunk or its index
+	s.flushBatch()
+
+	ikey := getIndexKey(key)
+	idata, err := s.db.Get(ikey)
+	if err != nil {
+		return ErrChunkNotFound
+	}
+	var index dpaDBIndex
+	if err := decodeIndex(idata, &index); err != nil {
+		return err
+	}
+	if index.Expires != 0 {
+		s.db.Delete(getExpiryKey(index.Expires, key))
+	}
+	s.delete(index.Idx, ikey, s.po(key), index.Cached > 0)
+	return nil
+}
+
+func (s *LDBStore) delete(idx uint64, idxKey []byte, po uint8, cached bool) {
 	metrics.GetOrRegisterCounter("ldbstore.delete", nil).Inc(1)
 
-	batch := new(leveldb.Batch)
+	batch := s.db.NewBatch()
 	batch.Delete(idxKey)
 	batch.Delete(getDataKey(idx, po))
+	s.keyFilter.remove(Key(idxKey[1:]))
 	s.entryCnt--
+	metrics.GetOrRegisterGauge("ldbstore.entrycnt", nil).Update(int64(s.entryCnt))
 	s.bucketCnt[po]--
 	cntKey := make([]byte, 2)
 	cntKey[0] = keyDistanceCnt
 	cntKey[1] = po
 	batch.Put(keyEntryCnt, U64ToBytes(s.entryCnt))
 	batch.Put(cntKey, U64ToBytes(s.bucketCnt[po]))
+	if cached && s.cachedCnt > 0 {
+		s.cachedCnt--
+		batch.Put(keyCachedCnt, U64ToBytes(s.cachedCnt))
+	}
 	s.db.Write(batch)
 }
 
@@ -491,6 +673,13 @@ func (s *LDBStore) Size() uint64 {
 	return s.entryCnt
 }
 
+// CachedSize returns the number of chunks only cached after retrieval
+func (s *LDBStore) CachedSize() uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.cachedCnt
+}
+
 func (s *LDBStore) CurrentStorageIndex() uint64 {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -499,19 +688,61 @@ func (s *LDBStore) CurrentStorageIndex() uint64 {
 
 func (s *LDBStore) Put(chunk *Chunk) {
 	metrics.GetOrRegisterCounter("ldbstore.put", nil).Inc(1)
+	defer metrics.GetOrRegisterTimer("ldbstore.put.time", nil).UpdateSince(time.Now())
 	log.Trace("ldbstore.put", "key", chunk.Key)
 
-	ikey := getIndexKey(chunk.Key)
-	var index dpaDBIndex
-
 	po := s.po(chunk.Key)
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	s.put(chunk, po)
+	s.triggerBatch()
+}
+
+// PutBatch stores the chunks in a single database batch, which saves the
+// overhead of a write for each chunk when many chunks arrive at once
+func (s *LDBStore) PutBatch(chunks []*Chunk) {
+	if len(chunks) == 0 {
+		return
+	}
+	metrics.GetOrRegisterCounter("ldbstore.putbatch", nil).Inc(1)
+	metrics.GetOrRegisterCounter("ldbstore.put", nil).Inc(int64(len(chunks)))
+	defer metrics.GetOrRegisterTimer("ldbstore.putbatch.time", nil).UpdateSince(time.Now())
+	log.Trace("ldbstore.putbatch", "chunks", len(chunks))
+
+	pos := make([]uint8, len(chunks))
+	for i, chunk := range chunks {
+		pos[i] = s.po(chunk.Key)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, chunk := range chunks {
+		s.put(chunk, pos[i])
+	}
+	s.triggerBatch()
+}
+
+// adds the chunk to the current batch, the caller must hold the lock
+func (s *LDBStore) put(chunk *Chunk, po uint8) {
+	ikey := getIndexKey(chunk.Key)
+	var index dpaDBIndex
+
 	log.Trace("ldbstore.put: s.db.Get", "key", chunk.Key, "ikey", fmt.Sprintf("%x", ikey))
-	idata, err := s.db.Get(ikey)
+	idata, err := s.getIndexData(ikey)
+	expires := expiryOf(chunk)
 	if err != nil {
-		s.doPut(chunk, &index, po)
+		if err := s.doPut(chunk, &index, po); err != nil {
+			log.Error("ldbstore.put: cannot encrypt chunk data", "key", chunk.Key, "err", err)
+			chunk.SetErrored(err)
+			chunk.markAsStored()
+			return
+		}
+		index.Stored = uint64(time.Now().Unix())
+		if chunk.Cached {
+			index.Cached = 1
+			s.cachedCnt++
+		}
 		batchC := s.batchC
 		go func() {
 			<-batchC
@@ -520,12 +751,29 @@ func (s *LDBStore) Put(chunk *Chunk) {
 	} else {
 		log.Trace("ldbstore.put: chunk already exists, only update access", "key", chunk.Key)
 		decodeIndex(idata, &index)
+		index.Hits = s.decayHits(&index) + 1
+		expires = mergeExpiry(index.Expires, expires)
+		// the node becomes responsible for a cached chunk put again
+		if index.Cached > 0 && !chunk.Cached {
+			index.Cached = 0
+			if s.cachedCnt > 0 {
+				s.cachedCnt--
+			}
+		}
 		chunk.markAsStored()
 	}
+	if expires != 0 && expires != index.Expires {
+		s.batch.Put(getExpiryKey(expires, chunk.Key), nil)
+	}
+	index.Expires = expires
 	index.Access = s.accessCnt
 	s.accessCnt++
 	idata = encodeIndex(&index)
 	s.batch.Put(ikey, idata)
+}
+
+// signals the batch writer that the current batch has entries
+func (s *LDBStore) triggerBatch() {
 	select {
 	case s.batchesC <- struct{}{}:
 	default:
@@ -533,10 +781,15 @@ func (s *LDBStore) Put(chunk *Chunk) {
 }
 
 // force putting into db, does not check access index
-func (s *LDBStore) doPut(chunk *Chunk, index *dpaDBIndex, po uint8) {
-	data := s.encodeDataFunc(chunk)
+func (s *LDBStore) doPut(chunk *Chunk, index *dpaDBIndex, po uint8) error {
+	data, err := s.sealData(s.encodeDataFunc(chunk))
+	if err != nil {
+		return err
+	}
+	metrics.GetOrRegisterCounter("ldbstore.put.bytes", nil).Inc(int64(len(data)))
 	dkey := getDataKey(s.dataIdx, po)
 	s.batch.Put(dkey, data)
+	s.keyFilter.add(chunk.Key)
 	index.Idx = s.dataIdx
 	s.bucketCnt[po] = s.dataIdx
 	s.entryCnt++
@@ -546,35 +799,52 @@ func (s *LDBStore) doPut(chunk *Chunk, index *dpaDBIndex, po uint8) {
 	cntKey[0] = keyDistanceCnt
 	cntKey[1] = po
 	s.batch.Put(cntKey, U64ToBytes(s.bucketCnt[po]))
+	return nil
 }
 
 func (s *LDBStore) writeBatches() {
 	for range s.batchesC {
 		s.lock.Lock()
-		b := s.batch
-		e := s.entryCnt
-		d := s.dataIdx
-		a := s.accessCnt
-		c := s.batchC
-		s.batchC = make(chan bool)
-		s.batch = new(leveldb.Batch)
-		err := s.writeBatch(b, e, d, a)
-		// TODO: set this error on the batch, then tell the chunk
-		if err != nil {
-			log.Error(fmt.Sprintf("spawn batch write (%d entries): %v", b.Len(), err))
-		}
-		close(c)
-		for e > s.capacity {
-			s.collectGarbage(gcArrayFreeRatio)
-			e = s.entryCnt
+		e := s.flushBatch()
+		if !s.gcPaused {
+			if e > s.capacity {
+				// the background collection did not keep up, the capacity is a hard limit
+				s.collectGarbage(gcArrayFreeRatio)
+			}
+			if s.entryCnt > s.highWatermark() {
+				s.triggerGC()
+			} else if s.cachedCapacity > 0 && s.cachedCnt > s.cachedCapacity {
+				s.collect(gcArrayFreeRatio, 0, true)
+			}
 		}
 		s.lock.Unlock()
 	}
 	log.Trace(fmt.Sprintf("DbStore: quit batch write loop"))
 }
 
+// writes the current batch and returns the entry count written with it,
+// the caller must hold the lock
+func (s *LDBStore) flushBatch() uint64 {
+	b := s.batch
+	e := s.entryCnt
+	d := s.dataIdx
+	a := s.accessCnt
+	c := s.batchC
+	s.batchC = make(chan bool)
+	s.batch = s.db.NewBatch()
+	b.Put(keyCachedCnt, U64ToBytes(s.cachedCnt))
+	err := s.writeBatch(b, e, d, a)
+	// TODO: set this error on the batch, then tell the chunk
+	if err != nil {
+		log.Error(fmt.Sprintf("spawn batch write (%d entries): %v", b.Len(), err))
+	}
+	metrics.GetOrRegisterGauge("ldbstore.entrycnt", nil).Update(int64(e))
+	close(c)
+	return e
+}
+
 // must be called non concurrently
-func (s *LDBStore) writeBatch(b *leveldb.Batch, entryCnt, dataIdx, accessCnt uint64) error {
+func (s *LDBStore) writeBatch(b KVBatch, entryCnt, dataIdx, accessCnt uint64) error {
 	b.Put(keyEntryCnt, U64ToBytes(entryCnt))
 	b.Put(keyDataIdx, U64ToBytes(dataIdx))
 	b.Put(keyAccessCnt, U64ToBytes(accessCnt))
@@ -601,14 +871,20 @@ func newMockEncodeDataFunc(mockStore *mock.NodeStore) func(chunk *Chunk) []byte
 
 // try to find index; if found, update access cnt and return true
 func (s *LDBStore) tryAccessIdx(ikey []byte, index *dpaDBIndex) bool {
-	idata, err := s.db.Get(ikey)
+	idata, err := s.getIndexData(ikey)
 	if err != nil {
 		return false
 	}
 	decodeIndex(idata, index)
+	// expired chunks are not served, they wait for the sweeper
+	if isExpired(index, uint64(time.Now().Unix())) {
+		return false
+	}
 	s.batch.Put(keyAccessCnt, U64ToBytes(s.accessCnt))
 	s.accessCnt++
+	index.Hits = s.decayHits(index) + 1
 	index.Access = s.accessCnt
+	index.Served = uint64(time.Now().Unix())
 	idata = encodeIndex(index)
 	s.batch.Put(ikey, idata)
 	select {
@@ -618,8 +894,25 @@ func (s *LDBStore) tryAccessIdx(ikey []byte, index *dpaDBIndex) bool {
 	return true
 }
 
+// Has tells if the chunk is stored and not expired, unlike Get it does not
+// count as an access of the chunk
+func (s *LDBStore) Has(key Key) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	idata, err := s.getIndexData(getIndexKey(key))
+	if err != nil {
+		return false
+	}
+	var index dpaDBIndex
+	if err := decodeIndex(idata, &index); err != nil {
+		return false
+	}
+	return !isExpired(&index, uint64(time.Now().Unix()))
+}
+
 func (s *LDBStore) Get(key Key) (chunk *Chunk, err error) {
 	metrics.GetOrRegisterCounter("ldbstore.get", nil).Inc(1)
+	defer metrics.GetOrRegisterTimer("ldbstore.get.time", nil).UpdateSince(time.Now())
 	log.Trace("ldbstore.get", "key", key)
 
 	s.lock.Lock()
@@ -643,11 +936,11 @@ func (s *LDBStore) get(key Key) (chunk *Chunk, err error) {
 			// default DbStore functionality to retrieve chunk data
 			proximity := s.po(key)
 			datakey := getDataKey(indx.Idx, proximity)
-			data, err = s.db.Get(datakey)
+			data, err = s.getData(datakey)
 			log.Trace("ldbstore.get retrieve", "key", key, "indexkey", indx.Idx, "datakey", fmt.Sprintf("%x", datakey), "proximity", proximity)
 			if err != nil {
 				log.Trace("ldbstore.get chunk found but could not be accessed", "key", key, "err", err)
-				s.delete(indx.Idx, getIndexKey(key), s.po(key))
+				s.delete(indx.Idx, getIndexKey(key), s.po(key), indx.Cached > 0)
 				return
 			}
 		}
@@ -655,6 +948,10 @@ func (s *LDBStore) get(key Key) (chunk *Chunk, err error) {
 		chunk = NewChunk(key, nil)
 		chunk.markAsStored()
 		decodeData(data, chunk)
+		if indx.Expires != 0 {
+			chunk.Expires = time.Unix(int64(indx.Expires), 0)
+		}
+		chunk.Cached = indx.Cached > 0
 	} else {
 		err = ErrChunkNotFound
 	}
@@ -691,6 +988,7 @@ func (s *LDBStore) setCapacity(c uint64) {
 	defer s.lock.Unlock()
 
 	s.capacity = c
+	metrics.GetOrRegisterGauge("ldbstore.capacity", nil).Update(int64(c))
 
 	if s.entryCnt > c {
 		ratio := float32(1.01) - float32(c)/float32(s.entryCnt)
@@ -700,13 +998,35 @@ func (s *LDBStore) setCapacity(c uint64) {
 		if ratio > 1 {
 			ratio = 1
 		}
+		s.flushBatch()
 		for s.entryCnt > c {
 			s.collectGarbage(ratio)
 		}
 	}
 }
 
+// SetCapacity changes the capacity of the store in chunks
+//
+// Unlike on construction, the chunks over the new capacity are garbage collected
+// gradually in the background, down to the low watermark in rounds between which
+// the store is released, so that it remains available while it shrinks.
+func (s *LDBStore) SetCapacity(c uint64) {
+	s.lock.Lock()
+	s.capacity = c
+	s.lock.Unlock()
+	metrics.GetOrRegisterGauge("ldbstore.capacity", nil).Update(int64(c))
+	s.triggerGC()
+}
+
+// Capacity returns the capacity of the store in chunks
+func (s *LDBStore) Capacity() uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.capacity
+}
+
 func (s *LDBStore) Close() {
+	s.quitOnce.Do(func() { close(s.quit) })
 	s.db.Close()
 }
 
@@ -714,28 +1034,82 @@ func (s *LDBStore) Close() {
 func (s *LDBStore) SyncIterator(since uint64, until uint64, po uint8, f func(Key, uint64) bool) error {
 	metrics.GetOrRegisterCounter("ldbstore.synciterator", nil).Inc(1)
 
-	sincekey := getDataKey(since, po)
-	untilkey := getDataKey(until, po)
-	it := s.db.NewIterator()
+	it := s.BinIterator(po, since)
 	defer it.Release()
 
-	for ok := it.Seek(sincekey); ok; ok = it.Next() {
+	for it.Next() {
 		metrics.GetOrRegisterCounter("ldbstore.synciterator.seek", nil).Inc(1)
 
-		dbkey := it.Key()
-		if dbkey[0] != keyData || dbkey[1] != po || bytes.Compare(untilkey, dbkey) < 0 {
-			break
-		}
-		key := make([]byte, 32)
-		val := it.Value()
-		copy(key, val[:32])
-		if !f(Key(key), binary.BigEndian.Uint64(dbkey[2:])) {
+		if it.Index() > until || !f(it.Key(), it.Index()) {
 			break
 		}
 	}
 	return it.Error()
 }
 
+// BinIterator walks the keys of the chunks in a proximity order bin in the
+// order they were stored in
+type BinIterator struct {
+	it      KVIterator
+	po      uint8
+	pending bool // the iterator is positioned at an entry not returned yet
+	key     Key
+	idx     uint64
+}
+
+// BinIterator returns an iterator over the chunks of bin po, starting with the
+// chunk at storage index since. The iterator reads a snapshot of the database,
+// so it is not affected by later puts and garbage collection. It must be
+// released after use.
+func (s *LDBStore) BinIterator(po uint8, since uint64) *BinIterator {
+	it := s.db.NewIterator()
+	return &BinIterator{
+		it:      it,
+		po:      po,
+		pending: it.Seek(getDataKey(since, po)),
+	}
+}
+
+// Next moves the iterator to the next chunk of the bin and reports whether
+// there is one
+func (self *BinIterator) Next() bool {
+	if self.pending {
+		self.pending = false
+	} else if !self.it.Next() {
+		return false
+	}
+	dbkey := self.it.Key()
+	if len(dbkey) != 10 || dbkey[0] != keyData || dbkey[1] != self.po {
+		return false
+	}
+	// the data of a chunk starts with its key, which is all that is stored for mock stores
+	key := make([]byte, 32)
+	copy(key, self.it.Value())
+	self.key = Key(key)
+	self.idx = binary.BigEndian.Uint64(dbkey[2:])
+	return true
+}
+
+// Key returns the key of the current chunk
+func (self *BinIterator) Key() Key {
+	return self.key
+}
+
+// Index returns the storage index of the current chunk
+func (self *BinIterator) Index() uint64 {
+	return self.idx
+}
+
+// Error returns the error the iteration stopped with, if any
+func (self *BinIterator) Error() error {
+	return self.it.Error()
+}
+
+// Release releases the database snapshot of the iterator
+func (self *BinIterator) Release() {
+	self.it.Release()
+}
+
 func databaseExists(path string) bool {
 	o := &opt.Options{
 		ErrorIfMissing: true,
@@ -0,0 +1,107 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/ethereum/go-ethereum/swarm/storage/encryption"
+)
+
+// The chunk data can be encrypted at rest with a node-local key
+//
+// Each chunk is encrypted with the hash of the node-local key and the chunk key,
+// which stays in plaintext, so chunks can still be addressed and iterated. The
+// database records whether it is encrypted, and the hash of the node-local key if
+// it is, when it is first opened. It is an error to open it with another key or
+// without one, or with a key if it was first opened without one.
+
+var (
+	ErrDataEncryptionKey = errors.New("chunk database is encrypted with a different key")
+	ErrDataEncrypted     = errors.New("chunk database is encrypted, but no encryption key is given")
+	ErrDataNotEncrypted  = errors.New("chunk database already holds unencrypted chunks")
+)
+
+// recorded encryption flag of a database which is not encrypted, the hash of the
+// node-local key is recorded for an encrypted one
+var dataNotEncrypted = []byte{0}
+
+// checks the encryption key against the one recorded in the database, and
+// records whether a new database is encrypted
+func (s *LDBStore) initDataEncryption(key []byte) error {
+	recorded, _ := s.db.Get(keyEncryption)
+	if recorded == nil {
+		// databases created before the flag was recorded are not encrypted,
+		// those with no chunk ever stored are new
+		if _, err := s.db.Get(keyDataIdx); err == nil || key == nil {
+			recorded = dataNotEncrypted
+		} else {
+			recorded = crypto.Keccak256(key)
+		}
+		s.db.Put(keyEncryption, recorded)
+	}
+	switch {
+	case bytes.Equal(recorded, dataNotEncrypted):
+		if key != nil {
+			return ErrDataNotEncrypted
+		}
+		return nil
+	case key == nil:
+		return ErrDataEncrypted
+	case !bytes.Equal(recorded, crypto.Keccak256(key)):
+		return ErrDataEncryptionKey
+	}
+	s.dataKey = key
+	s.dataEncryption = encryption.New(0, 0, sha3.NewKeccak256)
+	return nil
+}
+
+// encrypts the chunk data of a database value, the key prefix stays in plaintext
+func (s *LDBStore) sealData(data []byte) ([]byte, error) {
+	if s.dataKey == nil || len(data) <= 32 {
+		return data, nil
+	}
+	enc, err := s.dataEncryption.Encrypt(data[32:], crypto.Keccak256(s.dataKey, data[:32]))
+	if err != nil {
+		return nil, err
+	}
+	return append(data[:32:32], enc...), nil
+}
+
+// decrypts the chunk data of a database value
+func (s *LDBStore) openData(data []byte) ([]byte, error) {
+	if s.dataKey == nil || len(data) <= 32 {
+		return data, nil
+	}
+	dec, err := s.dataEncryption.Decrypt(data[32:], crypto.Keccak256(s.dataKey, data[:32]))
+	if err != nil {
+		return nil, err
+	}
+	return append(data[:32:32], dec...), nil
+}
+
+// reads and decrypts the chunk data stored under the database key
+func (s *LDBStore) getData(dkey []byte) ([]byte, error) {
+	data, err := s.db.Get(dkey)
+	if err != nil {
+		return nil, err
+	}
+	return s.openData(data)
+}
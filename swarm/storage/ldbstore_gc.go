@@ -0,0 +1,165 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// The LDBStore is garbage collected by a background goroutine, which is
+// triggered when the store grows over the high watermark and collects the
+// least valuable chunks down to the low watermark, in rounds between which the
+// store is released. The watermarks are shares of the capacity. The capacity
+// remains a hard limit, the chunks over it are collected before more chunks are
+// stored if the background collection does not keep up.
+
+const (
+	defaultGCHighWatermark = 1.0
+	defaultGCLowWatermark  = 0.9
+	defaultGCBatchSize     = maxGCitems / 10
+)
+
+// sets the watermarks and the batch size of the garbage collection, or their
+// defaults if they are not set
+func (s *LDBStore) initGC(params *StoreParams) {
+	s.gcHigh = params.GCHighWatermark
+	if s.gcHigh <= 0 || s.gcHigh > 1 {
+		s.gcHigh = defaultGCHighWatermark
+	}
+	s.gcLow = params.GCLowWatermark
+	if s.gcLow <= 0 || s.gcLow > s.gcHigh {
+		s.gcLow = s.gcHigh * defaultGCLowWatermark
+	}
+	s.gcBatchSize = params.GCBatchSize
+	if s.gcBatchSize <= 0 {
+		s.gcBatchSize = defaultGCBatchSize
+	}
+}
+
+// returns the number of entries above which the background garbage collection
+// starts, the caller must hold the lock
+func (s *LDBStore) highWatermark() uint64 {
+	return uint64(float64(s.capacity) * s.gcHigh)
+}
+
+// returns the number of entries the background garbage collection collects
+// down to, the caller must hold the lock
+func (s *LDBStore) lowWatermark() uint64 {
+	return uint64(float64(s.capacity) * s.gcLow)
+}
+
+func (s *LDBStore) triggerGC() {
+	select {
+	case s.gcC <- struct{}{}:
+	default:
+	}
+}
+
+// garbage collects the chunks over the low watermark one round at a time
+// whenever it is triggered, until the store is closed
+//
+// A large excess, as after shrinking the capacity, takes many rounds, so the
+// store being closed is also checked between the rounds.
+func (s *LDBStore) collectInBackground() {
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-s.gcC:
+		}
+		for {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			s.lock.Lock()
+			low := s.lowWatermark()
+			if s.gcPaused || s.entryCnt <= low {
+				s.lock.Unlock()
+				break
+			}
+			limit := s.gcBatchSize
+			if excess := s.entryCnt - low; excess < uint64(limit) {
+				limit = int(excess)
+			}
+			collected := s.collectRound(limit)
+			entryCnt := s.entryCnt
+			s.lock.Unlock()
+			// nothing is left to collect if the store is closed
+			if collected == 0 {
+				break
+			}
+			metrics.GetOrRegisterCounter("ldbstore.gc.background", nil).Inc(1)
+			log.Trace("ldbstore.gc", "collected", collected, "entrycnt", entryCnt)
+		}
+	}
+}
+
+// CollectGarbage runs a round of garbage collection regardless of the
+// watermarks and whether the collection is paused, and returns the number of
+// chunks collected
+func (s *LDBStore) CollectGarbage() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	collected := s.collectRound(s.gcBatchSize)
+	log.Debug("ldbstore: garbage collected", "collected", collected, "entrycnt", s.entryCnt)
+	return collected
+}
+
+// collects up to limit of the least valuable chunks and returns the number
+// collected, the caller must hold the lock
+//
+// The pending batch is written first, otherwise the access and index updates
+// it holds would write back the index of chunks collected in the meantime.
+func (s *LDBStore) collectRound(limit int) int {
+	s.flushBatch()
+	return s.collect(1, limit, false)
+}
+
+// PauseGC stops the garbage collection, the store may grow over its capacity
+// until it is resumed
+func (s *LDBStore) PauseGC() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.gcPaused = true
+	log.Info("ldbstore: garbage collection paused")
+}
+
+// ResumeGC restarts the garbage collection stopped by PauseGC, the chunks over
+// the high watermark are collected in the background
+func (s *LDBStore) ResumeGC() {
+	s.lock.Lock()
+	s.gcPaused = false
+	over := s.entryCnt > s.highWatermark()
+	s.lock.Unlock()
+
+	log.Info("ldbstore: garbage collection resumed")
+	if over {
+		s.triggerGC()
+	}
+}
+
+// GCPaused reports whether the garbage collection is paused
+func (s *LDBStore) GCPaused() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.gcPaused
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// The database records the version of its schema, the layout of its keys and
+// values
+//
+// Databases written before the version was recorded have schema 0. When a store
+// is opened, the migrations from the recorded schema to the current one are run
+// in order, and the new version is recorded after each of them, so an
+// interrupted upgrade resumes with the first migration not done. A new database
+// starts with the current schema.
+
+// a migration upgrades the database from the schema at its position in
+// migrations to the next one
+type migration struct {
+	name    string
+	migrate func(s *LDBStore) error
+}
+
+// to change the schema, append a migration
+var migrations = []migration{
+	{"rewrite index entries with all fields", migrateIndexFields},
+}
+
+// the number of index entries written in one batch by migrations
+const migrationBatchSize = 1000
+
+// SchemaVersion returns the version of the schema the store writes
+func SchemaVersion() uint64 {
+	return uint64(len(migrations))
+}
+
+// returns the schema version recorded in the database
+func (s *LDBStore) schemaVersion() uint64 {
+	data, err := s.db.Get(keySchema)
+	if err != nil {
+		if s.entryCnt > 1 {
+			return 0
+		}
+		return SchemaVersion()
+	}
+	return BytesToU64(data)
+}
+
+// runs the migrations from the recorded schema to the current one
+func (s *LDBStore) migrate() error {
+	version := s.schemaVersion()
+	if version > SchemaVersion() {
+		return fmt.Errorf("chunk database schema %d is newer than the supported schema %d", version, SchemaVersion())
+	}
+	for ; version < SchemaVersion(); version++ {
+		m := migrations[version]
+		log.Info("ldbstore: migrating database", "from", version, "to", version+1, "migration", m.name)
+		if err := m.migrate(s); err != nil {
+			return fmt.Errorf("chunk database migration to schema %d failed: %v", version+1, err)
+		}
+		s.db.Put(keySchema, U64ToBytes(version+1))
+	}
+	s.db.Put(keySchema, U64ToBytes(version))
+	return nil
+}
+
+// migrateIndexFields re-encodes the index entries written before the hits,
+// expiry and last retrieval fields were added
+func migrateIndexFields(s *LDBStore) error {
+	it := s.db.NewIterator()
+	defer it.Release()
+
+	batch := s.db.NewBatch()
+	var count int
+	for it.Seek([]byte{keyIndex}); it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) == 0 || key[0] != keyIndex {
+			break
+		}
+		var index dpaDBIndex
+		if err := decodeIndex(it.Value(), &index); err != nil {
+			log.Warn("ldbstore: skipping invalid index entry", "key", fmt.Sprintf("%x", key), "err", err)
+			continue
+		}
+		batch.Put(append([]byte{}, key...), encodeIndex(&index))
+		count++
+		if batch.Len() >= migrationBatchSize {
+			if err := s.db.Write(batch); err != nil {
+				return err
+			}
+			batch = s.db.NewBatch()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := s.db.Write(batch); err != nil {
+		return err
+	}
+	log.Info("ldbstore: rewrote index entries", "count", count)
+	return nil
+}
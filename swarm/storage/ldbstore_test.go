@@ -27,6 +27,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/swarm/storage/mock/mem"
 
 	ldberrors "github.com/syndtr/goleveldb/leveldb/errors"
@@ -221,6 +222,123 @@ func TestMockIterator(t *testing.T) {
 	testIterator(t, true)
 }
 
+func testExportImport(t *testing.T, mock bool) {
+	chunkcount := 32
+
+	db, err := newTestDbStore(mock, false)
+	if err != nil {
+		t.Fatalf("init dbStore failed: %v", err)
+	}
+	defer db.close()
+
+	chunks := GenerateRandomChunks(DefaultChunkSize, chunkcount)
+	for _, chunk := range chunks {
+		db.Put(chunk)
+	}
+	for _, chunk := range chunks {
+		<-chunk.dbStoredC
+	}
+
+	var buf bytes.Buffer
+	count, err := db.Export(&buf)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if count != int64(chunkcount) {
+		t.Fatalf("expected %d exported chunks, got %d", chunkcount, count)
+	}
+
+	db2, err := newTestDbStore(false, false)
+	if err != nil {
+		t.Fatalf("init dbStore failed: %v", err)
+	}
+	defer db2.close()
+	count, err = db2.Import(&buf)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if count != int64(chunkcount) {
+		t.Fatalf("expected %d imported chunks, got %d", chunkcount, count)
+	}
+	for i, chunk := range chunks {
+		ret, err := db2.Get(chunk.Key)
+		if err != nil {
+			t.Fatalf("chunk #%d not imported: %v", i, err)
+		}
+		if !bytes.Equal(ret.SData, chunk.SData) {
+			t.Fatalf("chunk #%d imported with different data", i)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	testExportImport(t, false)
+}
+func TestMockExportImport(t *testing.T) {
+	testExportImport(t, true)
+}
+
+func testBinIterator(t *testing.T, mock bool) {
+	chunkcount := 64
+
+	db, err := newTestDbStore(mock, false)
+	if err != nil {
+		t.Fatalf("init dbStore failed: %v", err)
+	}
+	defer db.close()
+
+	chunks := GenerateRandomChunks(DefaultChunkSize, chunkcount)
+	bins := make(map[uint8][]Key)
+	for _, chunk := range chunks {
+		db.Put(chunk)
+		<-chunk.dbStoredC
+		po := db.po(chunk.Key)
+		bins[po] = append(bins[po], chunk.Key)
+	}
+
+	for po, keys := range bins {
+		var got []Key
+		var last uint64
+		it := db.BinIterator(po, 0)
+		for it.Next() {
+			if it.Index() <= last && len(got) > 0 {
+				t.Fatalf("bin %d: storage index %d follows %d", po, it.Index(), last)
+			}
+			last = it.Index()
+			got = append(got, it.Key())
+		}
+		if err := it.Error(); err != nil {
+			t.Fatal(err)
+		}
+		it.Release()
+		if len(got) != len(keys) {
+			t.Fatalf("bin %d: expected %d keys, got %d", po, len(keys), len(got))
+		}
+		for i := range keys {
+			if !bytes.Equal(got[i], keys[i]) {
+				t.Fatalf("bin %d: expected key #%d to be %v, got %v", po, i, keys[i], got[i])
+			}
+		}
+
+		// iteration starts at the given storage index
+		it = db.BinIterator(po, last)
+		if !it.Next() || it.Index() != last || !bytes.Equal(it.Key(), keys[len(keys)-1]) {
+			t.Fatalf("bin %d: expected iteration from index %d to start with the last key", po, last)
+		}
+		if it.Next() {
+			t.Fatalf("bin %d: expected iteration to end after the last key", po)
+		}
+		it.Release()
+	}
+}
+
+func TestBinIterator(t *testing.T) {
+	testBinIterator(t, false)
+}
+func TestMockBinIterator(t *testing.T) {
+	testBinIterator(t, true)
+}
+
 func benchmarkDbStorePut(n int, processors int, chunksize int64, mock bool, b *testing.B) {
 	db, err := newTestDbStore(mock, true)
 	if err != nil {
@@ -413,7 +531,7 @@ func TestLDBStoreAddRemove(t *testing.T) {
 			var indx dpaDBIndex
 			ldb.tryAccessIdx(ikey, &indx)
 
-			ldb.delete(indx.Idx, ikey, ldb.po(key))
+			ldb.delete(indx.Idx, ikey, ldb.po(key), false)
 		}
 	}
 
@@ -473,7 +591,7 @@ func TestLDBStoreRemoveThenCollectGarbage(t *testing.T) {
 		var indx dpaDBIndex
 		ldb.tryAccessIdx(ikey, &indx)
 
-		ldb.delete(indx.Idx, ikey, ldb.po(key))
+		ldb.delete(indx.Idx, ikey, ldb.po(key), false)
 	}
 
 	log.Info("ldbstore", "entrycnt", ldb.entryCnt, "accesscnt", ldb.accessCnt)
@@ -512,3 +630,924 @@ func TestLDBStoreRemoveThenCollectGarbage(t *testing.T) {
 		t.Fatal("expected to get the same data back, but got smth else")
 	}
 }
+
+// TestLDBStoreGCWatermarks tests that the store is garbage collected in the
+// background from the high down to the low watermark, and that the collection
+// can be paused, resumed and run manually
+func TestLDBStoreGCWatermarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeparams := NewDefaultStoreParams()
+	storeparams.DbCapacity = 100
+	storeparams.GCHighWatermark = 0.8
+	storeparams.GCLowWatermark = 0.5
+	storeparams.GCBatchSize = 10
+	ldb, err := NewLDBStore(NewLDBStoreParams(storeparams, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+
+	put := func(n int) {
+		for i := 0; i < n; i++ {
+			c := NewRandomChunk(chunkSize)
+			ldb.Put(c)
+			<-c.dbStoredC
+		}
+	}
+	waitSize := func(size uint64) {
+		for i := 0; ldb.Size() > size; i++ {
+			if i == 100 {
+				t.Fatalf("expected store to shrink to %d chunks, has %d", size, ldb.Size())
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	put(70)
+	if size := ldb.Size(); size < 70 {
+		t.Fatalf("expected no chunks collected under the high watermark, has %d", size)
+	}
+	// the entry count starts at 1, so the high watermark is crossed by the 80th chunk
+	put(10)
+	waitSize(50)
+
+	ldb.PauseGC()
+	put(100)
+	size := ldb.Size()
+	if size <= 100 {
+		t.Fatalf("expected store to grow over its capacity while paused, has %d", size)
+	}
+	if collected := ldb.CollectGarbage(); collected != 10 {
+		t.Fatalf("expected a manual round to collect 10 chunks, got %d", collected)
+	}
+	if ldb.Size() != size-10 {
+		t.Fatalf("expected %d chunks after a manual round, has %d", size-10, ldb.Size())
+	}
+	ldb.ResumeGC()
+	waitSize(50)
+}
+
+// TestLDBStoreGCPendingAccess tests that a round of garbage collection does not
+// leave the index of collected chunks behind when accesses are still pending
+func TestLDBStoreGCPendingAccess(t *testing.T) {
+	n := 20
+	ldb, chunks, cleanup := newGCPolicyTestStore(t, n, nil, nil)
+	defer cleanup()
+
+	ldb.lock.Lock()
+	for _, c := range chunks {
+		var index dpaDBIndex
+		if !ldb.tryAccessIdx(getIndexKey(c.Key), &index) {
+			ldb.lock.Unlock()
+			t.Fatalf("expected chunk %v to be stored", c.Key)
+		}
+	}
+	collected := ldb.collectRound(5)
+	ldb.flushBatch()
+	entryCnt := ldb.entryCnt
+	ldb.lock.Unlock()
+	if collected != 5 {
+		t.Fatalf("expected 5 chunks collected, got %d", collected)
+	}
+
+	var indexed int
+	for _, c := range chunks {
+		_, ierr := ldb.db.Get(getIndexKey(c.Key))
+		_, err := ldb.Get(c.Key)
+		if (ierr == nil) != (err == nil) {
+			t.Fatalf("index and data of chunk %v disagree: %v, %v", c.Key, ierr, err)
+		}
+		if ierr == nil {
+			indexed++
+		}
+	}
+	if indexed != n-collected {
+		t.Fatalf("expected %d chunks left, got %d", n-collected, indexed)
+	}
+	// the entry count starts at 1
+	if entryCnt != uint64(indexed+1) {
+		t.Fatalf("expected entry count %d, got %d", indexed+1, entryCnt)
+	}
+}
+
+// newGCPolicyTestStore creates an LDBStore with the given garbage collection policy
+// and proximity function, stores n random chunks and waits until they are written
+func newGCPolicyTestStore(t *testing.T, n int, policy GCPolicy, po func(Key) uint8) (*LDBStore, []*Chunk, func()) {
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := NewLDBStoreParams(NewDefaultStoreParams(), dir)
+	params.GCPolicy = policy
+	if po != nil {
+		params.Po = po
+	}
+	ldb, err := NewLDBStore(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		ldb.Close()
+		os.RemoveAll(dir)
+	}
+
+	chunks := []*Chunk{}
+	for i := 0; i < n; i++ {
+		chunks = append(chunks, NewRandomChunk(chunkSize))
+	}
+	for _, c := range chunks {
+		ldb.Put(c)
+	}
+	for _, c := range chunks {
+		<-c.dbStoredC
+	}
+	return ldb, chunks, cleanup
+}
+
+// flush waits until the pending index updates of the store are written, by storing one more chunk
+func flushLDBStore(ldb *LDBStore) {
+	c := NewRandomChunk(chunkSize)
+	ldb.Put(c)
+	<-c.dbStoredC
+}
+
+// TestLDBStoreMostAccessedGCPolicy tests that the most accessed chunks are retained,
+// even if the other chunks were accessed more recently
+func TestLDBStoreMostAccessedGCPolicy(t *testing.T) {
+	n := 20
+	ldb, chunks, cleanup := newGCPolicyTestStore(t, n, MostAccessedGCPolicy{}, nil)
+	defer cleanup()
+
+	for j := 0; j < 3; j++ {
+		for i := 0; i < n/2; i++ {
+			if _, err := ldb.Get(chunks[i].Key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		flushLDBStore(ldb)
+	}
+	for i := n / 2; i < n; i++ {
+		if _, err := ldb.Get(chunks[i].Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flushLDBStore(ldb)
+	ldb.setCapacity(uint64(n / 2))
+
+	// collection overshoots the capacity, but the less accessed chunks must go first
+	var retained int
+	for i := 0; i < n; i++ {
+		_, err := ldb.Get(chunks[i].Key)
+		if i >= n/2 && err == nil {
+			t.Fatalf("expected less accessed chunk %d to be collected", i)
+		} else if err == nil {
+			retained++
+		}
+	}
+	if retained == 0 {
+		t.Fatal("expected most accessed chunks to be retained")
+	}
+}
+
+// TestLDBStoreMostAccessedGCPolicyDecay tests that the chunks accessed most long
+// ago are collected before the ones accessed often lately, as their hits decay
+func TestLDBStoreMostAccessedGCPolicyDecay(t *testing.T) {
+	defer func(h uint64) { hitsHalfLife = h }(hitsHalfLife)
+	hitsHalfLife = 10
+
+	n := 20
+	ldb, chunks, cleanup := newGCPolicyTestStore(t, n, MostAccessedGCPolicy{}, nil)
+	defer cleanup()
+
+	get := func(from, to, times int) {
+		for j := 0; j < times; j++ {
+			for i := from; i < to; i++ {
+				if _, err := ldb.Get(chunks[i].Key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			flushLDBStore(ldb)
+		}
+	}
+	get(0, n/2, 10)
+	get(n/2, n, 3)
+	ldb.setCapacity(uint64(n / 2))
+
+	var retained int
+	for i := 0; i < n; i++ {
+		_, err := ldb.Get(chunks[i].Key)
+		if i < n/2 && err == nil {
+			t.Fatalf("expected chunk %d accessed long ago to be collected", i)
+		} else if err == nil {
+			retained++
+		}
+	}
+	if retained == 0 {
+		t.Fatal("expected chunks accessed lately to be retained")
+	}
+}
+
+// TestLDBStoreLRSGCPolicy tests that the chunks retrieved are retained, even if the
+// other chunks were stored again more recently
+func TestLDBStoreLRSGCPolicy(t *testing.T) {
+	n := 20
+	ldb, chunks, cleanup := newGCPolicyTestStore(t, n, LRSGCPolicy{}, nil)
+	defer cleanup()
+
+	for i := 0; i < n/2; i++ {
+		if _, err := ldb.Get(chunks[i].Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flushLDBStore(ldb)
+	for i := n / 2; i < n; i++ {
+		c := NewChunk(chunks[i].Key, nil)
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+	flushLDBStore(ldb)
+	ldb.setCapacity(uint64(n / 2))
+
+	// collection overshoots the capacity, but the chunks never retrieved must go first
+	var retained int
+	for i := 0; i < n; i++ {
+		_, err := ldb.Get(chunks[i].Key)
+		if i >= n/2 && err == nil {
+			t.Fatalf("expected chunk %d never retrieved to be collected", i)
+		} else if err == nil {
+			retained++
+		}
+	}
+	if retained == 0 {
+		t.Fatal("expected retrieved chunks to be retained")
+	}
+}
+
+// TestLDBStoreProximityGCPolicy tests that the chunks nearest to the base key are retained,
+// even if the other chunks were stored more recently
+func TestLDBStoreProximityGCPolicy(t *testing.T) {
+	n := 20
+	var lock sync.Mutex
+	near := make(map[string]bool)
+	po := func(k Key) uint8 {
+		lock.Lock()
+		defer lock.Unlock()
+		if near[string(k)] {
+			return 8
+		}
+		return 0
+	}
+	ldb, chunks, cleanup := newGCPolicyTestStore(t, 0, ProximityGCPolicy{Weight: 1000}, po)
+	defer cleanup()
+
+	for i := 0; i < n; i++ {
+		c := NewRandomChunk(chunkSize)
+		if i < n/2 {
+			lock.Lock()
+			near[string(c.Key)] = true
+			lock.Unlock()
+		}
+		chunks = append(chunks, c)
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+	ldb.setCapacity(uint64(n / 2))
+
+	// collection overshoots the capacity, but the far chunks must go first
+	var retained int
+	for i := 0; i < n; i++ {
+		_, err := ldb.Get(chunks[i].Key)
+		if i >= n/2 && err == nil {
+			t.Fatalf("expected far chunk %d to be collected", i)
+		} else if err == nil {
+			retained++
+		}
+	}
+	if retained == 0 {
+		t.Fatal("expected near chunks to be retained")
+	}
+}
+
+// TestLDBStoreLegacyIndex tests that index entries written before fields were
+// added are decoded with the missing fields set to zero
+func TestLDBStoreLegacyIndex(t *testing.T) {
+	for _, fields := range [][]uint64{{42, 7}, {42, 7, 3}, {42, 7, 3, 1000}} {
+		data, err := rlp.EncodeToBytes(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index dpaDBIndex
+		if err := decodeIndex(data, &index); err != nil {
+			t.Fatal(err)
+		}
+		expected := dpaDBIndex{Idx: 42, Access: 7}
+		if len(fields) > 2 {
+			expected.Hits = 3
+		}
+		if len(fields) > 3 {
+			expected.Expires = 1000
+		}
+		if index != expected {
+			t.Fatalf("expected %+v decoded from %v, got %+v", expected, fields, index)
+		}
+	}
+}
+
+// TestLDBStoreSetCapacity tests that the store shrinks to the capacity set at runtime
+// and that the capacity in bytes is converted to chunks
+func TestLDBStoreSetCapacity(t *testing.T) {
+	n := 100
+	ldb, chunks, cleanup := newGCPolicyTestStore(t, n, nil, nil)
+	defer cleanup()
+
+	capacity := uint64(n / 4)
+	ldb.SetCapacity(capacity)
+	if ldb.Capacity() != capacity {
+		t.Fatalf("expected capacity %d, got %d", capacity, ldb.Capacity())
+	}
+	for i := 0; ldb.Size() > capacity; i++ {
+		if i == 100 {
+			t.Fatalf("expected store to shrink to %d chunks, has %d", capacity, ldb.Size())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// the most recently stored chunk is retained
+	if _, err := ldb.Get(chunks[n-1].Key); err != nil {
+		t.Fatal(err)
+	}
+
+	params := NewDefaultStoreParams()
+	params.DbSize = 10 * uint64(storedChunkSize)
+	if params.ChunkDbCapacity() != 10 {
+		t.Fatalf("expected capacity of 10 chunks, got %d", params.ChunkDbCapacity())
+	}
+}
+
+func TestLDBStoreMemDatabase(t *testing.T) {
+	db, err := NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := NewLDBStoreParams(NewDefaultStoreParams(), "")
+	params.Po = testPoFunc
+	params.Db = db
+	ldb, err := NewLDBStore(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+
+	testStoreCorrect(ldb, 1, 100, 4096, t)
+
+	it := ldb.BinIterator(0, 0)
+	defer it.Release()
+	if !it.Next() {
+		t.Fatalf("expected chunks in bin 0, iteration stopped with %v", it.Error())
+	}
+}
+
+// TestLDBStoreShardedDatabase tests that a store on a sharded database spreads
+// the chunks over the shards, and that shards opened in another order are refused
+func TestLDBStoreShardedDatabase(t *testing.T) {
+	var shards []KVStore
+	for i := 0; i < 3; i++ {
+		db, err := NewMemDatabase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, db)
+	}
+	db, err := newShardedDatabase(shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := NewLDBStoreParams(NewDefaultStoreParams(), "")
+	params.Po = testPoFunc
+	params.Db = db
+	ldb, err := NewLDBStore(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+
+	testStoreCorrect(ldb, 1, 100, 4096, t)
+
+	for i, shard := range shards {
+		it := shard.NewIterator()
+		if !it.Seek([]byte{keyData}) || it.Key()[0] != keyData {
+			t.Fatalf("expected chunk data in shard %d", i)
+		}
+		it.Release()
+	}
+	// the merged iterator returns the keys in order
+	it := db.NewIterator()
+	defer it.Release()
+	var prev []byte
+	var count int
+	for it.Seek([]byte{keyIndex}); it.Valid() && it.Key()[0] == keyIndex; it.Next() {
+		if bytes.Compare(prev, it.Key()) >= 0 {
+			t.Fatalf("key %x after %x", it.Key(), prev)
+		}
+		prev = common.CopyBytes(it.Key())
+		count++
+	}
+	if count != 100 {
+		t.Fatalf("expected 100 index entries, got %d", count)
+	}
+
+	other, err := NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newShardedDatabase([]KVStore{first, other}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newShardedDatabase([]KVStore{other, first}); err == nil {
+		t.Fatal("expected error for shards in another order")
+	}
+}
+
+// TestLDBStoreChunkExpiry tests that expired chunks are not served and that
+// the sweeper removes them
+func TestLDBStoreChunkExpiry(t *testing.T) {
+	ldb, _, cleanup := newGCPolicyTestStore(t, 0, nil, nil)
+	defer cleanup()
+
+	now := time.Now()
+	expiring := NewRandomChunk(chunkSize)
+	expiring.Expires = now.Add(time.Hour)
+	expired := NewRandomChunk(chunkSize)
+	expired.Expires = now.Add(-time.Second)
+	permanent := NewRandomChunk(chunkSize)
+	// a chunk put again without expiry does not expire
+	renewed := NewRandomChunk(chunkSize)
+	renewed.Expires = now.Add(time.Hour)
+	for _, c := range []*Chunk{expiring, expired, permanent, renewed} {
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+	ldb.Put(NewChunk(renewed.Key, nil))
+	flushLDBStore(ldb)
+
+	if _, err := ldb.Get(expired.Key); err != ErrChunkNotFound {
+		t.Fatalf("expected expired chunk not to be found, got %v", err)
+	}
+	chunk, err := ldb.Get(expiring.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk.Expires.Unix() != expiring.Expires.Unix() {
+		t.Fatalf("expected expiry %v, got %v", expiring.Expires, chunk.Expires)
+	}
+
+	size := ldb.Size()
+	ldb.sweepExpired(now.Add(2 * time.Hour))
+	if removed := size - ldb.Size(); removed != 2 {
+		t.Fatalf("expected 2 chunks to be removed, got %d", removed)
+	}
+	if _, err := ldb.Get(expiring.Key); err != ErrChunkNotFound {
+		t.Fatalf("expected swept chunk not to be found, got %v", err)
+	}
+	for _, c := range []*Chunk{permanent, renewed} {
+		if _, err := ldb.Get(c.Key); err != nil {
+			t.Fatalf("expected chunk %v to be retained: %v", c.Key, err)
+		}
+	}
+}
+
+// TestLDBStoreCachedCapacity tests that chunks cached after retrieval are
+// collected once they exceed their own capacity, without evicting the chunks
+// the node is responsible for, and that a cached chunk put again is kept
+func TestLDBStoreCachedCapacity(t *testing.T) {
+	ldb, cleanup := newLDBStore(t)
+	defer cleanup()
+	ldb.cachedCapacity = 5
+
+	var responsible, cached []*Chunk
+	for i := 0; i < 10; i++ {
+		c := NewRandomChunk(chunkSize)
+		responsible = append(responsible, c)
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+	for i := 0; i < 20; i++ {
+		c := NewRandomChunk(chunkSize)
+		c.Cached = true
+		cached = append(cached, c)
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+
+	if size := ldb.CachedSize(); size > 5 {
+		t.Fatalf("expected at most 5 cached chunks, got %d", size)
+	}
+	for i, c := range responsible {
+		if _, err := ldb.Get(c.Key); err != nil {
+			t.Fatalf("expected responsible chunk %d to be retained: %v", i, err)
+		}
+	}
+	last := cached[len(cached)-1]
+	got, err := ldb.Get(last.Key)
+	if err != nil {
+		t.Fatalf("expected the last cached chunk to be retained: %v", err)
+	}
+	if !got.Cached {
+		t.Fatal("expected the retrieved chunk to be marked cached")
+	}
+
+	size := ldb.CachedSize()
+	promoted := NewChunk(last.Key, nil)
+	promoted.SData = last.SData
+	ldb.Put(promoted)
+	// the index of an existing chunk is written with the next batch
+	flushed := NewRandomChunk(chunkSize)
+	ldb.Put(flushed)
+	<-flushed.dbStoredC
+	if got := ldb.CachedSize(); got != size-1 {
+		t.Fatalf("expected %d cached chunks after promotion, got %d", size-1, got)
+	}
+	got, err = ldb.Get(last.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Cached {
+		t.Fatal("expected the chunk put again not to be marked cached")
+	}
+}
+
+// TestLDBStoreCachedCollectedByPolicy tests that chunks cached after retrieval
+// are valued by the gc policy if there is no separate cached capacity, and
+// collected first otherwise
+func TestLDBStoreCachedCollectedByPolicy(t *testing.T) {
+	ldb, cleanup := newLDBStore(t)
+	defer cleanup()
+
+	var chunks []*Chunk
+	for i := 0; i < 5; i++ {
+		c := NewRandomChunk(chunkSize)
+		chunks = append(chunks, c)
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+	cached := NewRandomChunk(chunkSize)
+	cached.Cached = true
+	ldb.Put(cached)
+	<-cached.dbStoredC
+
+	ldb.lock.Lock()
+	ldb.collect(0, 1, false)
+	ldb.lock.Unlock()
+	if _, err := ldb.db.Get(getIndexKey(chunks[0].Key)); err == nil {
+		t.Fatal("expected the least recently accessed chunk to be collected")
+	}
+	if _, err := ldb.db.Get(getIndexKey(cached.Key)); err != nil {
+		t.Fatalf("expected the recently cached chunk to be retained: %v", err)
+	}
+
+	ldb.lock.Lock()
+	ldb.cachedCapacity = 5
+	ldb.collect(0, 1, false)
+	ldb.lock.Unlock()
+	if _, err := ldb.db.Get(getIndexKey(cached.Key)); err == nil {
+		t.Fatal("expected the cached chunk to be collected first")
+	}
+	if _, err := ldb.db.Get(getIndexKey(chunks[1].Key)); err != nil {
+		t.Fatalf("expected the chunk the node is responsible for to be retained: %v", err)
+	}
+}
+
+// TestLDBStoreExpiredCollectedFirst tests that garbage collection prefers expired
+// chunks, even if they were stored more recently
+func TestLDBStoreExpiredCollectedFirst(t *testing.T) {
+	n := 20
+	ldb, chunks, cleanup := newGCPolicyTestStore(t, n/2, nil, nil)
+	defer cleanup()
+
+	for i := 0; i < n/2; i++ {
+		c := NewRandomChunk(chunkSize)
+		c.Expires = time.Now().Add(-time.Second)
+		chunks = append(chunks, c)
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+	ldb.setCapacity(uint64(n / 2))
+
+	var retained int
+	for i, c := range chunks {
+		_, err := ldb.db.Get(getIndexKey(c.Key))
+		if i >= n/2 && err == nil {
+			t.Fatalf("expected expired chunk %d to be collected", i)
+		} else if err == nil {
+			retained++
+		}
+	}
+	if retained == 0 {
+		t.Fatal("expected chunks without expiry to be retained")
+	}
+}
+
+// TestLDBStoreDataEncryption tests that the chunk data is stored encrypted and
+// the database can only be opened with the key it was encrypted with
+func TestLDBStoreDataEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func(key []byte) (*LDBStore, error) {
+		storeparams := NewDefaultStoreParams()
+		storeparams.EncryptionKey = key
+		params := NewLDBStoreParams(storeparams, dir)
+		params.Po = testPoFunc
+		return NewLDBStore(params)
+	}
+	key := []byte("node-local key")
+	ldb, err := open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := NewRandomChunk(chunkSize)
+	ldb.Put(chunk)
+	<-chunk.dbStoredC
+
+	var index dpaDBIndex
+	idata, err := ldb.db.Get(getIndexKey(chunk.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeIndex(idata, &index)
+	data, err := ldb.db.Get(getDataKey(index.Idx, ldb.po(chunk.Key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:32], chunk.Key) {
+		t.Fatal("expected the chunk key to be stored in plaintext")
+	}
+	if bytes.Equal(data[32:], chunk.SData) {
+		t.Fatal("expected the chunk data to be stored encrypted")
+	}
+	ldb.Close()
+
+	if _, err := open(nil); err != ErrDataEncrypted {
+		t.Fatalf("expected ErrDataEncrypted, got %v", err)
+	}
+	if _, err := open([]byte("other key")); err != ErrDataEncryptionKey {
+		t.Fatalf("expected ErrDataEncryptionKey, got %v", err)
+	}
+	ldb, err = open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	ret, err := ldb.Get(chunk.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret.SData, chunk.SData) {
+		t.Fatal("expected the chunk data to be decrypted")
+	}
+}
+
+func TestLDBStoreDataEncryptionExisting(t *testing.T) {
+	db, err := newTestDbStore(false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(db.dir)
+	chunk := NewRandomChunk(chunkSize)
+	db.Put(chunk)
+	<-chunk.dbStoredC
+	db.Close()
+
+	storeparams := NewDefaultStoreParams()
+	storeparams.EncryptionKey = []byte("node-local key")
+	if _, err := NewLDBStore(NewLDBStoreParams(storeparams, db.dir)); err != ErrDataNotEncrypted {
+		t.Fatalf("expected ErrDataNotEncrypted, got %v", err)
+	}
+
+	// databases created before the encryption flag was recorded are not encrypted
+	ldb, err := NewLDBStore(NewLDBStoreParams(NewDefaultStoreParams(), db.dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb.db.Delete(keyEncryption)
+	ldb.Close()
+	if _, err := NewLDBStore(NewLDBStoreParams(storeparams, db.dir)); err != ErrDataNotEncrypted {
+		t.Fatalf("expected ErrDataNotEncrypted for a database without the flag, got %v", err)
+	}
+
+	// a database first opened without a key is not encrypted, even if it is empty
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ldb, err = NewLDBStore(NewLDBStoreParams(NewDefaultStoreParams(), dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb.Close()
+	if _, err := NewLDBStore(NewLDBStoreParams(storeparams, dir)); err != ErrDataNotEncrypted {
+		t.Fatalf("expected ErrDataNotEncrypted for an empty database, got %v", err)
+	}
+}
+
+func TestLDBStoreCompact(t *testing.T) {
+	ldb, chunks, cleanup := newGCPolicyTestStore(t, 100, nil, nil)
+	defer cleanup()
+	db := ldb.db.(*LDBDatabase)
+
+	// write the chunks to the database tables
+	if err := ldb.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := db.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ldb.setCapacity(50)
+	if err := ldb.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := db.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after >= before {
+		t.Fatalf("expected the database to shrink after compaction, got %d bytes before and %d after", before, after)
+	}
+
+	var retained int
+	for _, c := range chunks {
+		ret, err := ldb.Get(c.Key)
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(ret.SData, c.SData) {
+			t.Fatalf("chunk %v data mismatch after compaction", c.Key)
+		}
+		retained++
+	}
+	if retained == 0 || uint64(retained) > ldb.Capacity() {
+		t.Fatalf("expected up to %d chunks retained after compaction, got %d", ldb.Capacity(), retained)
+	}
+}
+
+// TestLDBStoreMigration tests that the index entries of a database without
+// schema version are upgraded when it is opened, and that a database with a
+// newer schema is refused
+func TestLDBStoreMigration(t *testing.T) {
+	db, err := newTestDbStore(false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(db.dir)
+	chunks := GenerateRandomChunks(chunkSize, 3)
+	for _, chunk := range chunks {
+		db.Put(chunk)
+		<-chunk.dbStoredC
+	}
+	if version := db.schemaVersion(); version != SchemaVersion() {
+		t.Fatalf("expected schema %d of new database, got %d", SchemaVersion(), version)
+	}
+	// write the index entries as they were before the schema was recorded
+	for _, chunk := range chunks {
+		idata, err := db.db.Get(getIndexKey(chunk.Key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index dpaDBIndex
+		if err := decodeIndex(idata, &index); err != nil {
+			t.Fatal(err)
+		}
+		data, err := rlp.EncodeToBytes([]uint64{index.Idx, index.Access})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.db.Put(getIndexKey(chunk.Key), data)
+	}
+	db.db.Delete(keySchema)
+	db.Close()
+
+	params := NewLDBStoreParams(NewDefaultStoreParams(), db.dir)
+	params.Po = testPoFunc
+	ldb, err := NewLDBStore(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := ldb.schemaVersion(); version != SchemaVersion() {
+		t.Fatalf("expected schema %d after migration, got %d", SchemaVersion(), version)
+	}
+	for _, chunk := range chunks {
+		data, err := ldb.db.Get(getIndexKey(chunk.Key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields []uint64
+		if err := rlp.DecodeBytes(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if len(fields) != 7 {
+			t.Fatalf("expected index entry with 7 fields, got %v", fields)
+		}
+		if _, err := ldb.Get(chunk.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ldb.db.Put(keySchema, U64ToBytes(SchemaVersion()+1))
+	ldb.Close()
+
+	if _, err := NewLDBStore(params); err == nil {
+		t.Fatal("expected error opening database with newer schema")
+	}
+}
+
+// TestLDBStoreList tests that the chunks listed can be filtered and paged
+func TestLDBStoreList(t *testing.T) {
+	ldb, cleanup := newLDBStore(t)
+	defer cleanup()
+
+	n := 10
+	chunks := GenerateRandomChunks(chunkSize, n)
+	for i, c := range chunks {
+		c.Cached = i%2 == 1
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+
+	res, err := ldb.List(&ListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Chunks) != n || res.Next != nil {
+		t.Fatalf("expected %d chunks on a single page, got %d, next %v", n, len(res.Chunks), res.Next)
+	}
+	for i, info := range res.Chunks {
+		if i > 0 && bytes.Compare(res.Chunks[i-1].Key, info.Key) >= 0 {
+			t.Fatal("expected chunks in key order")
+		}
+		if info.Size != int(chunkSize)+8 || info.Stored == 0 || info.Bin != ldb.po(info.Key) {
+			t.Fatalf("unexpected chunk info %+v", info)
+		}
+	}
+
+	var listed int
+	params := &ListParams{Limit: 3}
+	for {
+		page, err := ldb.List(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed += len(page.Chunks)
+		if page.Next == nil {
+			break
+		}
+		params.Start = page.Next
+	}
+	if listed != n {
+		t.Fatalf("expected %d chunks on all pages, got %d", n, listed)
+	}
+
+	cached := true
+	res, err = ldb.List(&ListParams{Cached: &cached})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Chunks) != n/2 {
+		t.Fatalf("expected %d cached chunks, got %d", n/2, len(res.Chunks))
+	}
+	bin := ldb.po(chunks[0].Key)
+	res, err = ldb.List(&ListParams{Bin: &bin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range res.Chunks {
+		if info.Bin != bin {
+			t.Fatalf("expected chunks in bin %d, got %d", bin, info.Bin)
+		}
+	}
+	for _, params := range []*ListParams{
+		{MinSize: int(chunkSize) + 9},
+		{StoredFrom: time.Now().Add(time.Hour).Unix()},
+		{StoredTo: time.Now().Add(-time.Hour).Unix()},
+	} {
+		res, err = ldb.List(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Chunks) != 0 {
+			t.Fatalf("expected no chunks listed with %+v, got %d", params, len(res.Chunks))
+		}
+	}
+}
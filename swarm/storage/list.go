@@ -0,0 +1,151 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	listDefaultLimit = 100   // chunks listed if the limit is not set
+	listMaxLimit     = 10000 // largest number of chunks listed at once
+)
+
+// ListParams selects the chunks listed by List, the zero value lists the first
+// page of all chunks
+type ListParams struct {
+	Bin        *uint8 `json:"bin"`        // proximity order bin of the chunks, all bins if nil
+	Cached     *bool  `json:"cached"`     // only cached (true) or only responsible (false) chunks, both if nil
+	MinSize    int    `json:"minSize"`    // minimum length of the chunk data
+	MaxSize    int    `json:"maxSize"`    // maximum length of the chunk data, no limit if 0
+	StoredFrom int64  `json:"storedFrom"` // unix time the chunks were stored at or after
+	StoredTo   int64  `json:"storedTo"`   // unix time the chunks were stored before, no limit if 0
+	Start      Key    `json:"start"`      // key to list from, the Next key of the previous page
+	Limit      int    `json:"limit"`      // maximum number of chunks listed
+}
+
+// ChunkInfo describes a chunk in the local store
+type ChunkInfo struct {
+	Key     Key    `json:"key"`
+	Bin     uint8  `json:"bin"`     // proximity order of the chunk key
+	Size    int    `json:"size"`    // length of the chunk data
+	Stored  int64  `json:"stored"`  // unix time the chunk was stored, 0 if it is unknown
+	Expires int64  `json:"expires"` // unix time the chunk may be removed after, 0 if it does not expire
+	Hits    uint64 `json:"hits"`    // accesses since the chunk was stored
+	Cached  bool   `json:"cached"`  // only cached after retrieval
+}
+
+// ListResult is a page of the chunks listed by List
+type ListResult struct {
+	Chunks []*ChunkInfo `json:"chunks"`
+	Next   Key          `json:"next,omitempty"` // key to list the next page from, empty after the last page
+}
+
+// List returns the chunks of the store selected by params in key order
+//
+// The chunks are listed from the Start key, after Limit chunks the key of the
+// next chunk is returned to list the following page from. Chunks stored or
+// deleted while listing may or may not be listed.
+func (s *LDBStore) List(params *ListParams) (*ListResult, error) {
+	limit := params.Limit
+	if limit <= 0 {
+		limit = listDefaultLimit
+	} else if limit > listMaxLimit {
+		limit = listMaxLimit
+	}
+
+	it := s.db.NewIterator()
+	defer it.Release()
+
+	res := &ListResult{Chunks: []*ChunkInfo{}}
+	for it.Seek(getIndexKey(params.Start)); it.Valid(); it.Next() {
+		ikey := it.Key()
+		if len(ikey) == 0 || ikey[0] != keyIndex {
+			break
+		}
+		key := Key(common.CopyBytes(ikey[1:]))
+		if len(res.Chunks) == limit {
+			res.Next = key
+			break
+		}
+		var index dpaDBIndex
+		if err := decodeIndex(it.Value(), &index); err != nil {
+			log.Warn("ldbstore: cannot decode chunk index", "key", key, "err", err)
+			continue
+		}
+		info := &ChunkInfo{
+			Key:     key,
+			Bin:     s.po(key),
+			Stored:  int64(index.Stored),
+			Expires: int64(index.Expires),
+			Hits:    index.Hits,
+			Cached:  index.Cached > 0,
+		}
+		if !params.matchIndex(info) {
+			continue
+		}
+		size, ok := s.dataSize(key, &index)
+		if !ok {
+			// deleted since the listing started
+			continue
+		}
+		info.Size = size
+		if size < params.MinSize || (params.MaxSize > 0 && size > params.MaxSize) {
+			continue
+		}
+		res.Chunks = append(res.Chunks, info)
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// reports whether the chunk is selected by the filters of the params that do
+// not depend on its data
+func (params *ListParams) matchIndex(info *ChunkInfo) bool {
+	if params.Bin != nil && info.Bin != *params.Bin {
+		return false
+	}
+	if params.Cached != nil && info.Cached != *params.Cached {
+		return false
+	}
+	if info.Stored < params.StoredFrom {
+		return false
+	}
+	return params.StoredTo == 0 || info.Stored < params.StoredTo
+}
+
+// returns the length of the data of the chunk, it reports false if the data
+// cannot be read
+func (s *LDBStore) dataSize(key Key, index *dpaDBIndex) (int, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var data []byte
+	var err error
+	if s.getDataFunc != nil {
+		data, err = s.getDataFunc(key)
+	} else {
+		data, err = s.getData(getDataKey(index.Idx, s.po(key)))
+	}
+	if err != nil || len(data) < 32 {
+		return 0, false
+	}
+	return len(data) - 32, true
+}
@@ -17,11 +17,14 @@
 package storage
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/swarm/storage/mock"
@@ -31,6 +34,10 @@ type LocalStoreParams struct {
 	*StoreParams
 	ChunkDbPath string
 	Validators  []ChunkValidator `toml:"-"`
+
+	// ChunkDbShards are the paths the chunk database is sharded across, the
+	// ChunkDbPath is not used if set
+	ChunkDbShards []string
 }
 
 func NewDefaultLocalStoreParams() *LocalStoreParams {
@@ -54,20 +61,33 @@ type LocalStore struct {
 	memStore   *MemStore
 	DbStore    *LDBStore
 	mu         sync.Mutex
+	cacheStats CacheStats // protected by mu
+	storedFeed event.Feed // keys of the chunks stored in the LDBStore
 }
 
 // This constructor uses MemStore and DbStore as components
 func NewLocalStore(params *LocalStoreParams, mockStore *mock.NodeStore) (*LocalStore, error) {
 	ldbparams := NewLDBStoreParams(params.StoreParams, params.ChunkDbPath)
+	if len(params.ChunkDbShards) > 0 {
+		db, err := NewShardedDatabase(params.ChunkDbShards)
+		if err != nil {
+			return nil, err
+		}
+		ldbparams.Db = db
+	}
 	dbStore, err := NewMockDbStore(ldbparams, mockStore)
 	if err != nil {
 		return nil, err
 	}
-	return &LocalStore{
+	store := &LocalStore{
 		memStore:   NewMemStore(params.StoreParams, dbStore),
 		DbStore:    dbStore,
 		Validators: params.Validators,
-	}, nil
+	}
+	if params.ScrubInterval > 0 {
+		go store.scrubPeriodically(params.ScrubInterval)
+	}
+	return store, nil
 }
 
 func NewTestLocalStoreForAddr(params *LocalStoreParams) (*LocalStore, error) {
@@ -97,48 +117,110 @@ func NewTestLocalStoreForAddr(params *LocalStoreParams) (*LocalStore, error) {
 // After the LDBStore.Put, it is ensured that the MemStore
 // contains the chunk with the same data, but nil ReqC channel.
 func (self *LocalStore) Put(chunk *Chunk) {
-	valid := true
-	for _, v := range self.Validators {
-		if valid = v.Validate(chunk.Key, chunk.SData); valid {
-			break
+	if !self.validate(chunk) {
+		return
+	}
+
+	log.Trace("localstore.put", "key", chunk.Key)
+	defer metrics.GetOrRegisterTimer("localstore.put.time", nil).UpdateSince(time.Now())
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if !self.putMem(chunk) {
+		return
+	}
+	self.DbStore.Put(chunk)
+	self.cacheStored(chunk)
+}
+
+// PutBatch stores the chunks like Put, but writes the chunks that are new
+// to the LDBStore in a single database batch
+func (self *LocalStore) PutBatch(chunks []*Chunk) {
+	var valid []*Chunk
+	for _, chunk := range chunks {
+		if self.validate(chunk) {
+			valid = append(valid, chunk)
 		}
 	}
+
+	log.Trace("localstore.putbatch", "chunks", len(chunks), "valid", len(valid))
+	defer metrics.GetOrRegisterTimer("localstore.putbatch.time", nil).UpdateSince(time.Now())
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	var store []*Chunk
+	for _, chunk := range valid {
+		if self.putMem(chunk) {
+			store = append(store, chunk)
+		}
+	}
+	self.DbStore.PutBatch(store)
+	for _, chunk := range store {
+		self.cacheStored(chunk)
+	}
+}
+
+// checks the chunk with the validators, an invalid chunk is marked as stored with ErrChunkInvalid
+func (self *LocalStore) validate(chunk *Chunk) bool {
+	valid := self.valid(chunk.Key, chunk.SData)
 	if !valid {
+		metrics.GetOrRegisterCounter("localstore.put.invalid", nil).Inc(1)
+		log.Warn("rejected invalid chunk", "key", chunk.Key)
 		chunk.SetErrored(ErrChunkInvalid)
 		chunk.markAsStored()
-		return
 	}
+	return valid
+}
 
-	log.Trace("localstore.put", "key", chunk.Key)
-	self.mu.Lock()
-	defer self.mu.Unlock()
+// reports whether any of the validators accepts the chunk data, or there are no validators
+func (self *LocalStore) valid(key Key, data []byte) bool {
+	valid := true
+	for _, v := range self.Validators {
+		if valid = v.Validate(key, data); valid {
+			break
+		}
+	}
+	return valid
+}
 
+// puts the chunk in the MemStore and reports whether it must be stored in the LDBStore
+//
+// The caller must hold the lock of the store.
+func (self *LocalStore) putMem(chunk *Chunk) bool {
 	chunk.Size = int64(binary.LittleEndian.Uint64(chunk.SData[0:8]))
 
 	memChunk, err := self.memStore.Get(chunk.Key)
 	switch err {
 	case nil:
-		if memChunk.ReqC == nil {
+		// a stored chunk is put again only if its expiry changes, or if it was
+		// only cached and the node becomes responsible for it
+		if memChunk.ReqC == nil && memChunk.Expires.Equal(chunk.Expires) && (chunk.Cached || !memChunk.Cached) {
 			chunk.markAsStored()
-			return
+			return false
 		}
 	case ErrChunkNotFound:
 	default:
 		chunk.SetErrored(err)
-		return
+		return false
 	}
 
 	self.memStore.Put(chunk)
 
 	if memChunk != nil && memChunk.ReqC != nil {
+		// the chunk is delivered for the request
+		chunk.Cached = memChunk.Cached
 		close(memChunk.ReqC)
 	}
+	return true
+}
 
-	self.DbStore.Put(chunk)
-
+// replaces the chunk in the MemStore by a copy without ReqC once it is stored in the LDBStore
+func (self *LocalStore) cacheStored(chunk *Chunk) {
 	newc := NewChunk(chunk.Key, nil)
 	newc.SData = chunk.SData
 	newc.Size = chunk.Size
+	newc.Expires = chunk.Expires
+	newc.Cached = chunk.Cached
 	//newc.dbStored = chunk.dbStored
 	newc.dbStoredC = chunk.dbStoredC
 	//newc.dbStoredMu = chunk.dbStoredMu
@@ -146,25 +228,75 @@ func (self *LocalStore) Put(chunk *Chunk) {
 		<-chunk.dbStoredC
 
 		self.mu.Lock()
-		defer self.mu.Unlock()
-
 		self.memStore.Put(newc)
+		self.mu.Unlock()
+
+		if chunk.GetErrored() == nil {
+			self.storedFeed.Send(chunk.Key)
+		}
 	}()
 }
 
+// SubscribeStored notifies the channel of the key of every chunk stored in
+// the local store, whether uploaded, synced or retrieved from the network
+func (self *LocalStore) SubscribeStored(ch chan<- Key) event.Subscription {
+	return self.storedFeed.Subscribe(ch)
+}
+
 // Get(chunk *Chunk) looks up a chunk in the local stores
 // This method is blocking until the chunk is retrieved
 // so additional timeout may be needed to wrap this call if
 // ChunkStores are remote and can have long latency
 func (self *LocalStore) Get(key Key) (chunk *Chunk, err error) {
+	defer metrics.GetOrRegisterTimer("localstore.get.time", nil).UpdateSince(time.Now())
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
 	return self.get(key)
 }
 
+// Has tells if the chunk is stored locally, chunks being retrieved from the
+// network are not
+func (self *LocalStore) Has(key Key) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	chunk, err := self.memStore.Get(key)
+	if err == nil && !chunk.Expired(time.Now()) {
+		if chunk.ReqC == nil {
+			return true
+		}
+		select {
+		case <-chunk.ReqC:
+			return true
+		default:
+		}
+	}
+	return self.DbStore.Has(key)
+}
+
+// GetWithContext returns the chunk like Get, but if the chunk is being
+// retrieved from the network it waits for the retrieval until the context is
+// done
+func (self *LocalStore) GetWithContext(ctx context.Context, key Key) (*Chunk, error) {
+	chunk, err := self.Get(key)
+	if err != ErrFetching {
+		return chunk, err
+	}
+	select {
+	case <-chunk.ReqC:
+		return chunk, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (self *LocalStore) get(key Key) (chunk *Chunk, err error) {
 	chunk, err = self.memStore.Get(key)
+	// the cached copy may have expired earlier than the stored one
+	if err == nil && chunk.Expired(time.Now()) {
+		err = ErrChunkNotFound
+	}
 	if err == nil {
 		if chunk.ReqC != nil {
 			select {
@@ -175,21 +307,40 @@ func (self *LocalStore) get(key Key) (chunk *Chunk, err error) {
 			}
 		}
 		metrics.GetOrRegisterCounter("localstore.get.cachehit", nil).Inc(1)
+		self.cacheStats.MemHits++
 		return
 	}
 	metrics.GetOrRegisterCounter("localstore.get.cachemiss", nil).Inc(1)
 	chunk, err = self.DbStore.Get(key)
 	if err != nil {
 		metrics.GetOrRegisterCounter("localstore.get.error", nil).Inc(1)
+		self.cacheStats.DbMisses++
 		return
 	}
+	self.cacheStats.DbHits++
 	chunk.Size = int64(binary.LittleEndian.Uint64(chunk.SData[0:8]))
 	self.memStore.Put(chunk)
 	return
 }
 
 // retrieve logic common for local and network chunk retrieval requests
+//
+// The chunk delivered for a new request is only cached, it is evicted from the
+// LDBStore before the chunks the node is responsible for.
 func (self *LocalStore) GetOrCreateRequest(key Key) (chunk *Chunk, created bool) {
+	return self.getOrCreateRequest(key, true)
+}
+
+// GetOrCreateSyncRequest is GetOrCreateRequest for chunks synced to the node
+// because it is responsible for them
+//
+// The delivered chunk is not only cached, and neither is a cached chunk found
+// locally from then on.
+func (self *LocalStore) GetOrCreateSyncRequest(key Key) (chunk *Chunk, created bool) {
+	return self.getOrCreateRequest(key, false)
+}
+
+func (self *LocalStore) getOrCreateRequest(key Key, cached bool) (chunk *Chunk, created bool) {
 	metrics.GetOrRegisterCounter("localstore.getorcreaterequest", nil).Inc(1)
 
 	self.mu.Lock()
@@ -200,26 +351,163 @@ func (self *LocalStore) GetOrCreateRequest(key Key) (chunk *Chunk, created bool)
 	if err == nil && chunk.GetErrored() == nil {
 		metrics.GetOrRegisterCounter("localstore.getorcreaterequest.hit", nil).Inc(1)
 		log.Trace(fmt.Sprintf("LocalStore.GetOrRetrieve: %v found locally", key))
+		if chunk.Cached && !cached {
+			self.keep(chunk)
+		}
 		return chunk, false
 	}
 	if err == ErrFetching && chunk.GetErrored() == nil {
 		metrics.GetOrRegisterCounter("localstore.getorcreaterequest.errfetching", nil).Inc(1)
 		log.Trace(fmt.Sprintf("LocalStore.GetOrRetrieve: %v hit on an existing request %v", key, chunk.ReqC))
+		chunk.Cached = chunk.Cached && cached
 		return chunk, false
 	}
 	// no data and no request status
 	metrics.GetOrRegisterCounter("localstore.getorcreaterequest.miss", nil).Inc(1)
 	log.Trace(fmt.Sprintf("LocalStore.GetOrRetrieve: %v not found locally. open new request", key))
+	self.cacheStats.NetworkRequests++
 	chunk = NewChunk(key, make(chan bool))
+	chunk.Cached = cached
 	self.memStore.Put(chunk)
 	return chunk, true
 }
 
+// makes the node responsible for a chunk it only cached by putting it again,
+// the caller must hold the lock
+func (self *LocalStore) keep(chunk *Chunk) {
+	chunk.Cached = false
+	kept := NewChunk(chunk.Key, nil)
+	kept.SData = chunk.SData
+	kept.Size = chunk.Size
+	kept.Expires = chunk.Expires
+	self.DbStore.Put(kept)
+}
+
 // RequestsCacheLen returns the current number of outgoing requests stored in the cache
 func (self *LocalStore) RequestsCacheLen() int {
 	return self.memStore.requests.Len()
 }
 
+// Delete removes the chunk from both the LDBStore and the MemStore, it returns
+// ErrChunkNotFound if the chunk is not in the LDBStore
+//
+// The chunk is evicted from the MemStore after it is deleted from the LDBStore
+// under the lock of the store, so that a concurrent Get can not cache it again.
+func (self *LocalStore) Delete(key Key) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	err := self.DbStore.Delete(key)
+	self.memStore.Delete(key)
+	return err
+}
+
+// SetDbCapacity changes the number of chunks kept in the LDBStore, the chunks
+// over the new capacity are garbage collected gradually
+func (self *LocalStore) SetDbCapacity(capacity uint64) {
+	self.DbStore.SetCapacity(capacity)
+}
+
+// SetCacheCapacity changes the number of chunks cached in the MemStore
+func (self *LocalStore) SetCacheCapacity(capacity uint) {
+	self.memStore.SetCapacity(capacity)
+}
+
+// StoreStats is a snapshot of the state of the local store
+//
+// The counters and latencies are only collected if metrics are enabled.
+type StoreStats struct {
+	Entries        uint64        `json:"entries"`        // chunks in the LDBStore
+	Capacity       uint64        `json:"capacity"`       // capacity of the LDBStore in chunks
+	CachedEntries  uint64        `json:"cachedEntries"`  // chunks in the LDBStore only cached after retrieval
+	Size           uint64        `json:"size"`           // approximate size of the database files in bytes
+	PutBytes       int64         `json:"putBytes"`       // bytes of chunk data written since start
+	GCRuns         int64         `json:"gcRuns"`         // garbage collection rounds since start
+	GCDeleted      int64         `json:"gcDeleted"`      // chunks garbage collected since start
+	GCPaused       bool          `json:"gcPaused"`       // garbage collection of the LDBStore is paused
+	CacheEntries   int           `json:"cacheEntries"`   // chunks cached in memory
+	CacheCapacity  uint          `json:"cacheCapacity"`  // capacity of the MemStore in chunks
+	CacheBytes     uint64        `json:"cacheBytes"`     // approximate memory used by the cached chunks
+	CacheEvictions int64         `json:"cacheEvictions"` // chunks evicted from the MemStore since start
+	CacheHits      int64         `json:"cacheHits"`
+	CacheMisses    int64         `json:"cacheMisses"`
+	Requests       int           `json:"requests"`      // outgoing chunk requests
+	RequestsBytes  uint64        `json:"requestsBytes"` // approximate memory used by the outgoing requests
+	PutTime        time.Duration `json:"putTime"`       // mean duration of a put
+	GetTime        time.Duration `json:"getTime"`       // mean duration of a get
+	DbPutTime      time.Duration `json:"dbPutTime"`     // mean duration of an LDBStore put
+	DbGetTime      time.Duration `json:"dbGetTime"`     // mean duration of an LDBStore get
+}
+
+// Stats returns a snapshot of the state of the local store
+func (self *LocalStore) Stats() (*StoreStats, error) {
+	size, err := self.DbStore.db.Size()
+	if err != nil {
+		return nil, err
+	}
+	stats := &StoreStats{
+		Entries:        self.DbStore.Size(),
+		Capacity:       self.DbStore.Capacity(),
+		Size:           size,
+		PutBytes:       metrics.GetOrRegisterCounter("ldbstore.put.bytes", nil).Count(),
+		GCRuns:         metrics.GetOrRegisterCounter("ldbstore.collectgarbage", nil).Count(),
+		GCDeleted:      metrics.GetOrRegisterCounter("ldbstore.collectgarbage.delete", nil).Count(),
+		CacheEvictions: metrics.GetOrRegisterCounter("memstore.evict", nil).Count(),
+		CacheHits:      metrics.GetOrRegisterCounter("localstore.get.cachehit", nil).Count(),
+		CacheMisses:    metrics.GetOrRegisterCounter("localstore.get.cachemiss", nil).Count(),
+		PutTime:        meanTime("localstore.put.time"),
+		GetTime:        meanTime("localstore.get.time"),
+		DbPutTime:      meanTime("ldbstore.put.time"),
+		DbGetTime:      meanTime("ldbstore.get.time"),
+	}
+	stats.CachedEntries = self.DbStore.CachedSize()
+	stats.GCPaused = self.DbStore.GCPaused()
+	stats.CacheEntries, stats.Requests = self.memStore.Len()
+	stats.CacheCapacity = self.memStore.Capacity()
+	stats.CacheBytes, stats.RequestsBytes = self.memStore.Size()
+	return stats, nil
+}
+
+// CacheStats counts where the chunks looked up in the local store were found
+//
+// Unlike StoreStats the counters are collected even if metrics are disabled,
+// so they can be used to tune the cache sizes for a workload.
+type CacheStats struct {
+	MemHits         uint64  `json:"memHits"`         // lookups served from the MemStore
+	DbHits          uint64  `json:"dbHits"`          // lookups served from the LDBStore
+	DbMisses        uint64  `json:"dbMisses"`        // lookups found in neither store
+	NetworkRequests uint64  `json:"networkRequests"` // misses turned into requests to the network
+	MemHitRate      float64 `json:"memHitRate"`      // share of all lookups served from the MemStore
+	DbHitRate       float64 `json:"dbHitRate"`       // share of the MemStore misses served from the LDBStore
+}
+
+// CacheStats returns the lookup counters since the start or the last reset
+func (self *LocalStore) CacheStats() *CacheStats {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	stats := self.cacheStats
+	if gets := stats.MemHits + stats.DbHits + stats.DbMisses; gets > 0 {
+		stats.MemHitRate = float64(stats.MemHits) / float64(gets)
+	}
+	if memMisses := stats.DbHits + stats.DbMisses; memMisses > 0 {
+		stats.DbHitRate = float64(stats.DbHits) / float64(memMisses)
+	}
+	return &stats
+}
+
+// ResetCacheStats zeroes the lookup counters
+func (self *LocalStore) ResetCacheStats() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.cacheStats = CacheStats{}
+}
+
+func meanTime(name string) time.Duration {
+	return time.Duration(metrics.GetOrRegisterTimer(name, nil).Mean())
+}
+
 // Close the local store
 func (self *LocalStore) Close() {
 	self.DbStore.Close()
@@ -1,12 +1,15 @@
 package storage
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/contracts/ens"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 var (
@@ -125,3 +128,329 @@ func TestValidator(t *testing.T) {
 		t.Fatalf("expected no error on resource update chunk with resource validator only, but got: %s", err)
 	}
 }
+
+// tests that chunks too short to be content addressed are rejected, and that
+// a validator function accepts the chunks it recognises
+func TestValidatorFunc(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "storage-testvalidatorfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	store, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Validators = append(store.Validators, NewContentAddressValidator(hashfunc))
+
+	shortChunk := NewChunk(GenerateRandomChunk(DefaultChunkSize).Key, nil)
+	shortChunk.SData = []byte{1, 2, 3}
+	putChunks(store, shortChunk)
+	if err := shortChunk.GetErrored(); err != ErrChunkInvalid {
+		t.Fatalf("expected ErrChunkInvalid on short chunk, got %v", err)
+	}
+
+	data := make([]byte, 8+3)
+	copy(data[8:], "foo")
+	key := Key(crypto.Keccak256(data))
+	store.Validators = append(store.Validators, ChunkValidatorFunc(func(k Key, d []byte) bool {
+		return bytes.Equal(k, crypto.Keccak256(d))
+	}))
+	hookChunk := NewChunk(key, nil)
+	hookChunk.SData = data
+	putChunks(store, hookChunk)
+	if err := hookChunk.GetErrored(); err != nil {
+		t.Fatalf("expected no error on chunk accepted by the validator function, got %v", err)
+	}
+}
+
+// tests that the stats of the local store reflect the stored chunks
+func TestLocalStoreStats(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "storage-teststats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	params.CacheCapacity = 5
+	store, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	putChunks(store, GenerateRandomChunks(DefaultChunkSize, 10)...)
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries < 10 {
+		t.Fatalf("expected at least 10 entries, got %d", stats.Entries)
+	}
+	if stats.Capacity != params.DbCapacity {
+		t.Fatalf("expected capacity %d, got %d", params.DbCapacity, stats.Capacity)
+	}
+	if stats.CacheCapacity != 5 || stats.CacheEntries != 5 {
+		t.Fatalf("expected 5 of 5 chunks cached, got %d of %d", stats.CacheEntries, stats.CacheCapacity)
+	}
+}
+
+func TestLocalStoreCacheStats(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "storage-testcachestats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	store, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// the chunk is only in the db, the first get caches it
+	chunk := GenerateRandomChunks(DefaultChunkSize, 1)[0]
+	store.DbStore.Put(chunk)
+	if err := chunk.WaitToStore(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := store.Get(chunk.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := GenerateRandomChunks(DefaultChunkSize, 1)[0].Key
+	if _, err := store.Get(missing); err != ErrChunkNotFound {
+		t.Fatalf("expected ErrChunkNotFound, got %v", err)
+	}
+	if _, created := store.GetOrCreateRequest(missing); !created {
+		t.Fatal("expected request to be created")
+	}
+
+	stats := store.CacheStats()
+	if stats.MemHits != 2 || stats.DbHits != 1 || stats.DbMisses != 2 || stats.NetworkRequests != 1 {
+		t.Fatalf("unexpected counters %+v", stats)
+	}
+	if stats.MemHitRate != 0.4 || stats.DbHitRate != 1.0/3 {
+		t.Fatalf("unexpected hit rates %+v", stats)
+	}
+
+	store.ResetCacheStats()
+	if stats := store.CacheStats(); *stats != (CacheStats{}) {
+		t.Fatalf("expected zero counters after reset, got %+v", stats)
+	}
+}
+
+// tests that the integrity scan deletes the chunks with missing or invalid data
+func TestLocalStoreScrub(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "storage-testscrub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	store, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Validators = append(store.Validators, NewContentAddressValidator(hashfunc))
+
+	chunks := GenerateRandomChunks(DefaultChunkSize, 10)
+	putChunks(store, chunks...)
+
+	dataKey := func(key Key) []byte {
+		idata, err := store.DbStore.db.Get(getIndexKey(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index dpaDBIndex
+		if err := decodeIndex(idata, &index); err != nil {
+			t.Fatal(err)
+		}
+		return getDataKey(index.Idx, store.DbStore.po(key))
+	}
+	// the data of the first chunk is lost, the second one is replaced by the third
+	if err := store.DbStore.db.Delete(dataKey(chunks[0].Key)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.DbStore.db.Get(dataKey(chunks[2].Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.DbStore.db.Put(dataKey(chunks[1].Key), data)
+
+	size := store.DbStore.Size()
+	res, err := store.Scrub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Checked != 10 {
+		t.Fatalf("expected 10 chunks checked, got %d", res.Checked)
+	}
+	corrupted := make(map[string]bool)
+	for _, key := range res.Corrupted {
+		corrupted[key.Hex()] = true
+	}
+	if len(res.Corrupted) != 2 || !corrupted[chunks[0].Key.Hex()] || !corrupted[chunks[1].Key.Hex()] {
+		t.Fatalf("expected chunks %v and %v corrupted, got %v", chunks[0].Key, chunks[1].Key, res.Corrupted)
+	}
+	for i, chunk := range chunks {
+		_, err := store.Get(chunk.Key)
+		if i < 2 && err != ErrChunkNotFound {
+			t.Fatalf("expected ErrChunkNotFound for corrupted chunk %d, got %v", i, err)
+		}
+		if i >= 2 && err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+	}
+	if left := store.DbStore.Size(); left != size-2 {
+		t.Fatalf("expected %d entries left, got %d", size-2, left)
+	}
+}
+
+// tests that a deleted chunk is removed from both the memory cache and the
+// database, and that deleting it again fails
+func TestLocalStoreDelete(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "storage-testdelete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	store, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	chunks := GenerateRandomChunks(DefaultChunkSize, 3)
+	chunks[0].Expires = time.Now().Add(time.Hour)
+	putChunks(store, chunks...)
+
+	size := store.DbStore.Size()
+	if err := store.Delete(chunks[0].Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.memStore.Get(chunks[0].Key); err != ErrChunkNotFound {
+		t.Fatalf("expected deleted chunk not to be cached, got %v", err)
+	}
+	if _, err := store.Get(chunks[0].Key); err != ErrChunkNotFound {
+		t.Fatalf("expected ErrChunkNotFound for deleted chunk, got %v", err)
+	}
+	if _, err := store.DbStore.db.Get(getExpiryKey(expiryOf(chunks[0]), chunks[0].Key)); err == nil {
+		t.Fatal("expected expiry entry of deleted chunk to be removed")
+	}
+	if err := store.Delete(chunks[0].Key); err != ErrChunkNotFound {
+		t.Fatalf("expected ErrChunkNotFound deleting chunk again, got %v", err)
+	}
+	for _, chunk := range chunks[1:] {
+		if _, err := store.Get(chunk.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if left := store.DbStore.Size(); left != size-1 {
+		t.Fatalf("expected %d entries left, got %d", size-1, left)
+	}
+}
+
+// tests that a batch put stores the valid chunks and rejects the invalid ones
+func TestLocalStorePutBatch(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "storage-testputbatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	store, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Validators = append(store.Validators, NewContentAddressValidator(hashfunc))
+
+	chunks := GenerateRandomChunks(DefaultChunkSize, 10)
+	// the first chunk is already stored, the last one has bad content
+	putChunks(store, chunks[0])
+	copy(chunks[9].SData, chunks[8].SData)
+
+	store.PutBatch(chunks)
+	for i, chunk := range chunks {
+		err := chunk.WaitToStore()
+		if i == 9 {
+			if err != ErrChunkInvalid {
+				t.Fatalf("expected ErrChunkInvalid on bad chunk, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("chunk %v: %v", chunk.Key, err)
+		}
+		ret, err := store.DbStore.Get(chunk.Key)
+		if err != nil {
+			t.Fatalf("chunk %v not in db: %v", chunk.Key, err)
+		}
+		if !bytes.Equal(ret.SData, chunk.SData) {
+			t.Fatalf("chunk %v: data mismatch", chunk.Key)
+		}
+	}
+	if _, err := store.DbStore.Get(chunks[9].Key); err != ErrChunkNotFound {
+		t.Fatalf("expected bad chunk not to be stored, got %v", err)
+	}
+}
+
+// tests that Has tells the stored chunks apart from the missing ones, be they
+// cached or only in the database, without retrieving them
+func TestLocalStoreHas(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "storage-testhas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	store, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	chunks := GenerateRandomChunks(DefaultChunkSize, 3)
+	putChunks(store, chunks[:2]...)
+
+	for _, chunk := range chunks[:2] {
+		if !store.Has(chunk.Key) {
+			t.Fatal("expected the stored chunk to be found")
+		}
+		if !store.DbStore.Has(chunk.Key) {
+			t.Fatal("expected the stored chunk to be found in the database")
+		}
+	}
+	if store.Has(chunks[2].Key) || store.DbStore.Has(chunks[2].Key) {
+		t.Fatal("expected the missing chunk not to be found")
+	}
+
+	// a chunk being retrieved is not stored yet
+	if _, created := store.GetOrCreateRequest(chunks[2].Key); !created {
+		t.Fatal("expected a request to be created")
+	}
+	if store.Has(chunks[2].Key) {
+		t.Fatal("expected the requested chunk not to be found")
+	}
+}
@@ -21,14 +21,37 @@ package storage
 import (
 	"sync"
 
+	"github.com/ethereum/go-ethereum/metrics"
 	lru "github.com/hashicorp/golang-lru"
 )
 
+// approximate memory used by an entry of the MemStore besides the key and data
+// of its chunk, for the chunk struct, its channels and the LRU element
+const memEntryOverhead = 256
+
+// returns the approximate memory used by a chunk in the MemStore
+func memEntrySize(c *Chunk) uint64 {
+	return uint64(len(c.Key)+len(c.SData)) + memEntryOverhead
+}
+
+// memEntry is a chunk held by the MemStore with the memory it used when it
+// was added, as the data of a request chunk is only filled on delivery
+type memEntry struct {
+	chunk *Chunk
+	size  uint64
+}
+
 type MemStore struct {
 	cache    *lru.Cache
 	requests *lru.Cache
 	mu       sync.RWMutex
 	disabled bool
+	capacity uint
+
+	cacheSize       uint64 // resident bytes of the cached chunks
+	requestsSize    uint64 // resident bytes of the outgoing requests
+	maxCacheSize    uint64 // limit of cacheSize, no limit if 0
+	maxRequestsSize uint64 // limit of requestsSize, no limit if 0
 }
 
 //NewMemStore is instantiating a MemStore cache. We are keeping a record of all outgoing requests for chunks, that
@@ -38,48 +61,94 @@ type MemStore struct {
 //`requests` LRU cache capacity should ideally never be reached, this is why for the time being it should be initialised
 //with the same value as the LDBStore capacity.
 func NewMemStore(params *StoreParams, _ *LDBStore) (m *MemStore) {
-	if params.CacheCapacity == 0 {
+	capacity := params.ChunkCacheCapacity()
+	if capacity == 0 {
 		return &MemStore{
 			disabled: true,
 		}
 	}
 
+	m = &MemStore{
+		capacity:        capacity,
+		maxCacheSize:    params.CacheSize,
+		maxRequestsSize: params.RequestsCacheSize,
+	}
+	m.cache = m.newCache(capacity)
+	m.requests = m.newRequests(params.ChunkRequestsCacheCapacity)
+	return m
+}
+
+func (m *MemStore) newCache(capacity uint) *lru.Cache {
 	onEvicted := func(key interface{}, value interface{}) {
-		v := value.(*Chunk)
-		<-v.dbStoredC
+		metrics.GetOrRegisterCounter("memstore.evict", nil).Inc(1)
+		e := value.(*memEntry)
+		m.cacheSize -= e.size
+		<-e.chunk.dbStoredC
 	}
-	c, err := lru.NewWithEvict(int(params.CacheCapacity), onEvicted)
+	c, err := lru.NewWithEvict(int(capacity), onEvicted)
 	if err != nil {
 		panic(err)
 	}
+	return c
+}
 
+func (m *MemStore) newRequests(capacity uint) *lru.Cache {
 	requestEvicted := func(key interface{}, value interface{}) {
 		// temporary remove of the error log, until we figure out the problem, as it is too spamy
 		//log.Error("evict called on outgoing request")
+		m.requestsSize -= value.(*memEntry).size
 	}
-	r, err := lru.NewWithEvict(int(params.ChunkRequestsCacheCapacity), requestEvicted)
+	r, err := lru.NewWithEvict(int(capacity), requestEvicted)
 	if err != nil {
 		panic(err)
 	}
+	return r
+}
+
+// adds the chunk to the cache and evicts the least recently accessed chunks
+// over the byte limit, the caller must hold the lock
+func (m *MemStore) addCache(c *Chunk) {
+	addSized(m.cache, c, &m.cacheSize, m.maxCacheSize)
+	metrics.GetOrRegisterGauge("memstore.cache.bytes", nil).Update(int64(m.cacheSize))
+}
+
+// adds the outgoing request, the caller must hold the lock
+func (m *MemStore) addRequest(c *Chunk) {
+	addSized(m.requests, c, &m.requestsSize, m.maxRequestsSize)
+	metrics.GetOrRegisterGauge("memstore.requests.bytes", nil).Update(int64(m.requestsSize))
+}
 
-	return &MemStore{
-		cache:    c,
-		requests: r,
+// adds the chunk to an LRU cache holding size bytes, and evicts the oldest
+// entries while it holds more than max bytes, but never the chunk added
+//
+// The eviction callback of the cache must subtract the size of the evicted
+// entries as recorded in them.
+func addSized(cache *lru.Cache, c *Chunk, size *uint64, max uint64) {
+	key := string(c.Key)
+	if old, ok := cache.Peek(key); ok {
+		// replacing an entry does not call the eviction callback
+		*size -= old.(*memEntry).size
+	}
+	e := &memEntry{chunk: c, size: memEntrySize(c)}
+	cache.Add(key, e)
+	*size += e.size
+	for max > 0 && *size > max && cache.Len() > 1 {
+		cache.RemoveOldest()
 	}
 }
 
 func (m *MemStore) Get(key Key) (*Chunk, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if m.disabled {
 		return nil, ErrChunkNotFound
 	}
 
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
 	r, ok := m.requests.Get(string(key))
 	// it is a request
 	if ok {
-		return r.(*Chunk), nil
+		return r.(*memEntry).chunk, nil
 	}
 
 	// it is not a request
@@ -87,17 +156,17 @@ func (m *MemStore) Get(key Key) (*Chunk, error) {
 	if !ok {
 		return nil, ErrChunkNotFound
 	}
-	return c.(*Chunk), nil
+	return c.(*memEntry).chunk, nil
 }
 
 func (m *MemStore) Put(c *Chunk) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if m.disabled {
 		return
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	// it is a request
 	if c.ReqC != nil {
 		select {
@@ -106,42 +175,92 @@ func (m *MemStore) Put(c *Chunk) {
 				m.requests.Remove(string(c.Key))
 				return
 			}
-			m.cache.Add(string(c.Key), c)
+			m.addCache(c)
 			m.requests.Remove(string(c.Key))
 		default:
-			m.requests.Add(string(c.Key), c)
+			m.addRequest(c)
 		}
 		return
 	}
 
 	// it is not a request
-	m.cache.Add(string(c.Key), c)
+	m.addCache(c)
 	m.requests.Remove(string(c.Key))
 }
 
-func (m *MemStore) setCapacity(n int) {
-	if n <= 0 {
+// Delete removes the chunk from the cache
+func (m *MemStore) Delete(key Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cache == nil {
+		return
+	}
+	m.cache.Remove(string(key))
+}
+
+// SetCapacity changes the number of chunks cached in memory
+//
+// The cached chunks are kept in order of their last access, the least recently
+// accessed ones are evicted if the capacity shrinks. A capacity of 0 disables
+// the cache.
+func (m *MemStore) SetCapacity(n uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n == 0 {
 		m.disabled = true
-	} else {
-		onEvicted := func(key interface{}, value interface{}) {
-			v := value.(*Chunk)
-			<-v.dbStoredC
-		}
-		c, err := lru.NewWithEvict(n, onEvicted)
-		if err != nil {
-			panic(err)
+		m.cache = nil
+		m.cacheSize = 0
+		m.capacity = 0
+		return
+	}
+	old := m.cache
+	m.cache = m.newCache(n)
+	m.cacheSize = 0
+	if old != nil {
+		// keys are ordered from the least to the most recently accessed
+		for _, key := range old.Keys() {
+			if value, ok := old.Peek(key); ok {
+				m.addCache(value.(*memEntry).chunk)
+			}
 		}
+	}
+	if m.requests == nil {
+		m.requests = m.newRequests(defaultChunkRequestsCacheCapacity)
+	}
+	m.capacity = n
+	m.disabled = false
+}
 
-		r, err := lru.New(defaultChunkRequestsCacheCapacity)
-		if err != nil {
-			panic(err)
-		}
+// Len returns the number of chunks cached in memory and the number of outgoing requests
+func (m *MemStore) Len() (int, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-		m = &MemStore{
-			cache:    c,
-			requests: r,
-		}
+	var cached, requests int
+	if m.cache != nil {
+		cached = m.cache.Len()
 	}
+	if m.requests != nil {
+		requests = m.requests.Len()
+	}
+	return cached, requests
+}
+
+// Size returns the approximate memory used by the cached chunks and by the
+// outgoing requests in bytes
+func (m *MemStore) Size() (uint64, uint64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cacheSize, m.requestsSize
+}
+
+// Capacity returns the number of chunks the MemStore caches
+func (m *MemStore) Capacity() uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.capacity
 }
 
 func (s *MemStore) Close() {}
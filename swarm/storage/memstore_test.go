@@ -246,3 +246,150 @@ func NewRandomRequestChunk(chunkSize uint64) *Chunk {
 
 	return c
 }
+
+// TestMemStoreSetCapacity tests that shrinking the cache evicts the least recently
+// accessed chunks, and that the cache can be disabled and enabled again
+func TestMemStoreSetCapacity(t *testing.T) {
+	memStore := NewMemStore(NewStoreParams(4000, 10, 10, nil, nil), nil)
+
+	var chunks []*Chunk
+	for i := 0; i < 10; i++ {
+		c := NewRandomChunk(chunkSize)
+		c.markAsStored()
+		chunks = append(chunks, c)
+		memStore.Put(c)
+	}
+	// access the first chunk so that it is the most recent one
+	if _, err := memStore.Get(chunks[0].Key); err != nil {
+		t.Fatal(err)
+	}
+
+	memStore.SetCapacity(5)
+	for i, c := range chunks {
+		_, err := memStore.Get(c.Key)
+		if (i == 0 || i >= 6) && err != nil {
+			t.Fatalf("expected recent chunk %d to be cached, got %v", i, err)
+		} else if i > 0 && i < 6 && err == nil {
+			t.Fatalf("expected chunk %d to be evicted", i)
+		}
+	}
+
+	memStore.SetCapacity(0)
+	if _, err := memStore.Get(chunks[0].Key); err != ErrChunkNotFound {
+		t.Fatalf("expected disabled cache, got %v", err)
+	}
+	memStore.SetCapacity(5)
+	memStore.Put(chunks[1])
+	if _, err := memStore.Get(chunks[1].Key); err != nil {
+		t.Fatalf("expected chunk to be cached after enabling the cache, got %v", err)
+	}
+}
+
+// TestMemStoreDeleteDisabled tests that deleting from a MemStore created or
+// set with a capacity of 0 is a no-op
+func TestMemStoreDeleteDisabled(t *testing.T) {
+	memStore := NewMemStore(NewStoreParams(4000, 0, 10, nil, nil), nil)
+	c := NewRandomChunk(chunkSize)
+	memStore.Put(c)
+	memStore.Delete(c.Key)
+
+	memStore = NewMemStore(NewStoreParams(4000, 10, 10, nil, nil), nil)
+	c.markAsStored()
+	memStore.Put(c)
+	memStore.SetCapacity(0)
+	memStore.Delete(c.Key)
+	if _, err := memStore.Get(c.Key); err != ErrChunkNotFound {
+		t.Fatalf("expected disabled cache, got %v", err)
+	}
+}
+
+// TestMemStoreByteLimit tests that the cached chunks and the outgoing requests
+// are evicted by the memory they use
+func TestMemStoreByteLimit(t *testing.T) {
+	params := NewStoreParams(4000, 0, 10, nil, nil)
+	full := NewRandomChunk(chunkSize)
+	params.CacheSize = 3*memEntrySize(full) + memEntrySize(full)/2
+	params.RequestsCacheSize = 2 * memEntrySize(NewRandomRequestChunk(chunkSize))
+	memStore := NewMemStore(params, nil)
+
+	chunks := []*Chunk{full}
+	for i := 1; i < 5; i++ {
+		chunks = append(chunks, NewRandomChunk(chunkSize))
+	}
+	for _, c := range chunks {
+		c.markAsStored()
+		memStore.Put(c)
+	}
+	for i, c := range chunks {
+		_, err := memStore.Get(c.Key)
+		if i < 2 && err == nil {
+			t.Fatalf("expected chunk %d to be evicted", i)
+		} else if i >= 2 && err != nil {
+			t.Fatalf("expected chunk %d to be cached, got %v", i, err)
+		}
+	}
+	cacheSize, _ := memStore.Size()
+	if cacheSize != 3*memEntrySize(full) {
+		t.Fatalf("expected %d cached bytes, got %d", 3*memEntrySize(full), cacheSize)
+	}
+
+	// smaller chunks take less of the limit
+	for i := 0; i < 10; i++ {
+		c := NewRandomChunk(100)
+		c.markAsStored()
+		memStore.Put(c)
+	}
+	cached, _ := memStore.Len()
+	if cached <= 3 {
+		t.Fatalf("expected more small chunks to be cached, got %d", cached)
+	}
+
+	var requests []*Chunk
+	for i := 0; i < 3; i++ {
+		c := NewRandomRequestChunk(chunkSize)
+		requests = append(requests, c)
+		memStore.Put(c)
+	}
+	if _, err := memStore.Get(requests[0].Key); err == nil {
+		t.Fatal("expected oldest request to be evicted")
+	}
+	_, n := memStore.Len()
+	if n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+}
+
+// TestMemStoreRequestDelivery tests that the bytes accounted for a request are
+// released when it is delivered, even though its data is only filled then
+func TestMemStoreRequestDelivery(t *testing.T) {
+	memStore := NewMemStore(NewStoreParams(4000, 10, 10, nil, nil), nil)
+
+	full := NewRandomChunk(chunkSize)
+	c := NewChunk(full.Key, make(chan bool))
+	memStore.Put(c)
+	_, requestsSize := memStore.Size()
+	if requestsSize != memEntrySize(c) {
+		t.Fatalf("expected %d request bytes, got %d", memEntrySize(c), requestsSize)
+	}
+
+	// deliver the request
+	c.SData = full.SData
+	c.Size = full.Size
+	c.markAsStored()
+	close(c.ReqC)
+	memStore.Put(c)
+
+	cacheSize, requestsSize := memStore.Size()
+	if requestsSize != 0 {
+		t.Fatalf("expected no request bytes, got %d", requestsSize)
+	}
+	if cacheSize != memEntrySize(full) {
+		t.Fatalf("expected %d cached bytes, got %d", memEntrySize(full), cacheSize)
+	}
+	if _, n := memStore.Len(); n != 0 {
+		t.Fatalf("expected no requests, got %d", n)
+	}
+	if _, err := memStore.Get(c.Key); err != nil {
+		t.Fatalf("expected delivered chunk to be cached, got %v", err)
+	}
+}
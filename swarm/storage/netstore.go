@@ -17,6 +17,8 @@
 package storage
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
@@ -25,12 +27,15 @@ import (
 var (
 	// NetStore.Get timeout for get and get retries
 	// This is the maximum period that the Get will block.
-	// If it is reached, Get will return ErrChunkNotFound.
+	// If it is reached, Get will return a RetrievalError.
 	netStoreRetryTimeout = 30 * time.Second
-	// Minimal period between calling get method on NetStore
-	// on retry. It protects calling get very frequently if
-	// it returns ErrChunkNotFound very fast.
+	// Default delay before calling get method on NetStore
+	// again after it returned ErrChunkNotFound. The delay is
+	// doubled for every further failed attempt.
 	netStoreMinRetryDelay = 3 * time.Second
+	// Number of failed retrievals NetStore keeps the backoff
+	// state for before the expired ones are forgotten.
+	netStoreMaxFailed = 10000
 	// Timeout interval before retrieval is timed out.
 	// It is used in NetStore.get on waiting for ReqC to be
 	// closed on a single retrieve request.
@@ -43,11 +48,46 @@ var (
 // access by calling network is blocking with a timeout
 type NetStore struct {
 	localStore *LocalStore
-	retrieve   func(chunk *Chunk) error
+	retrieve   func(ctx context.Context, chunk *Chunk) error
+	backoff    *RetryBackoff
+
+	failedMu sync.Mutex
+	failed   map[string]*failedRetrieval // backoff state of failed retrievals by chunk key
+
+	fetchesMu sync.Mutex
+	fetches   map[string]*netFetch // outstanding network requests by chunk key
+}
+
+// netFetch is an outstanding network request of a chunk shared by all
+// concurrent local callers requesting the chunk
+type netFetch struct {
+	chunk   *Chunk
+	done    chan struct{} // closed when the request completes
+	err     error         // result of the request, set before done is closed
+	waiters int           // number of callers waiting for the request
+	cancel  func()        // abandons the request once no caller waits for it
+}
+
+// failedRetrieval is the number of consecutive failed retrieval attempts of
+// a chunk and the time before which it is not requested again
+type failedRetrieval struct {
+	attempts int
+	next     time.Time
+}
+
+func NewNetStore(localStore *LocalStore, retrieve func(ctx context.Context, chunk *Chunk) error) *NetStore {
+	return &NetStore{
+		localStore: localStore,
+		retrieve:   retrieve,
+		backoff:    NewRetryBackoff(),
+		failed:     make(map[string]*failedRetrieval),
+		fetches:    make(map[string]*netFetch),
+	}
 }
 
-func NewNetStore(localStore *LocalStore, retrieve func(chunk *Chunk) error) *NetStore {
-	return &NetStore{localStore, retrieve}
+// SetRetryBackoff sets the backoff between the retrieval attempts of a chunk
+func (self *NetStore) SetRetryBackoff(backoff *RetryBackoff) {
+	self.backoff = backoff
 }
 
 // Get is the entrypoint for local retrieve requests
@@ -55,100 +95,204 @@ func NewNetStore(localStore *LocalStore, retrieve func(chunk *Chunk) error) *Net
 //
 // Get uses get method to retrieve request, but retries if the
 // ErrChunkNotFound is returned by get, until the netStoreRetryTimeout
-// is reached.
+// is reached. Retries of a chunk are delayed with exponential backoff.
 func (self *NetStore) Get(key Key) (chunk *Chunk, err error) {
-	timer := time.NewTimer(netStoreRetryTimeout)
-	defer timer.Stop()
-
-	// result and resultC provide results from the goroutine
-	// where NetStore.get is called.
-	type result struct {
-		chunk *Chunk
-		err   error
-	}
-	resultC := make(chan result)
-
-	// quitC ensures that retring goroutine is terminated
-	// when this function returns.
-	quitC := make(chan struct{})
-	defer close(quitC)
-
-	// do retries in a goroutine so that the timer can
-	// force this method to return after the netStoreRetryTimeout.
-	go func() {
-		// limiter ensures that NetStore.get is not called more frequently
-		// then netStoreMinRetryDelay. If NetStore.get takes longer
-		// then netStoreMinRetryDelay, the next retry call will be
-		// without a delay.
-		limiter := time.NewTimer(netStoreMinRetryDelay)
-		defer limiter.Stop()
-
-		for {
-			chunk, err := self.get(key, 0)
-			if err != ErrChunkNotFound {
-				// break retry only if the error is nil
-				// or other error then ErrChunkNotFound
-				select {
-				case <-quitC:
-					// Maybe NetStore.Get function has returned
-					// by the timer.C while we were waiting for the
-					// results. Terminate this goroutine.
-				case resultC <- result{chunk: chunk, err: err}:
-					// Send the result to the parrent goroutine.
-				}
-				return
+	return self.GetWithContext(context.Background(), key)
+}
 
-			}
+// GetWithContext retrieves the chunk like Get, but returns as soon as the
+// context is done, the outstanding retrieve request is then marked as failed
+//
+// The netStoreRetryTimeout only applies if the context has no deadline. It
+// returns a RetrievalError with ErrChunkNotFound if the deadline is reached,
+// and the error of the context if it is cancelled.
+func (self *NetStore) GetWithContext(ctx context.Context, key Key) (chunk *Chunk, err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, netStoreRetryTimeout)
+		defer cancel()
+	}
+	var attempts int
+	defer func() {
+		if err == context.DeadlineExceeded {
+			err = &RetrievalError{Key: key, Attempts: attempts, Err: ErrChunkNotFound}
+		}
+	}()
+
+	for {
+		// the chunk may have been stored since the last attempt, eg.
+		// delivered by syncing, in which case there is no need to wait
+		if chunk, err := self.localStore.Get(key); err == nil {
+			self.retrieved(key)
+			return chunk, nil
+		}
+		// wait until the backoff of an earlier failed retrieval
+		// of the chunk is over
+		if delay := self.retryDelay(key); delay > 0 {
+			log.Debug("NetStore.Get retry chunk", "key", key, "delay", delay)
+			t := time.NewTimer(delay)
 			select {
-			case <-quitC:
-				// NetStore.Get function has returned, possibly
-				// by the timer.C, which makes this goroutine
-				// not needed.
-				return
-			case <-limiter.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			case <-t.C:
 			}
-			// Reset the limiter for the next iteration.
-			limiter.Reset(netStoreMinRetryDelay)
-			log.Debug("NetStore.Get retry chunk", "key", key)
 		}
-	}()
+		attempts++
+		// get returns when the context is done, after marking the
+		// outstanding request as failed
+		chunk, err := self.get(ctx, key, 0)
+		if err != ErrChunkNotFound {
+			// break retry only if the error is nil
+			// or other error then ErrChunkNotFound
+			return chunk, err
+		}
+	}
+}
 
-	select {
-	case r := <-resultC:
-		return r.chunk, r.err
-	case <-timer.C:
-		return nil, ErrChunkNotFound
+// retryDelay returns the time left until the chunk may be requested again
+func (self *NetStore) retryDelay(key Key) time.Duration {
+	self.failedMu.Lock()
+	defer self.failedMu.Unlock()
+	f, ok := self.failed[string(key)]
+	if !ok {
+		return 0
+	}
+	return time.Until(f.next)
+}
+
+// retrievalFailed records a failed retrieval attempt of the chunk and
+// schedules the next one after the backoff
+func (self *NetStore) retrievalFailed(key Key) {
+	self.failedMu.Lock()
+	defer self.failedMu.Unlock()
+	now := time.Now()
+	if len(self.failed) >= netStoreMaxFailed {
+		// forget the chunks that were not retried in time
+		for k, f := range self.failed {
+			if now.Sub(f.next) > self.backoff.Max {
+				delete(self.failed, k)
+			}
+		}
 	}
+	f, ok := self.failed[string(key)]
+	if !ok || now.Sub(f.next) > self.backoff.Max {
+		f = &failedRetrieval{}
+		self.failed[string(key)] = f
+	}
+	f.attempts++
+	f.next = now.Add(self.backoff.Delay(f.attempts))
+}
+
+// retrieved forgets the failed retrieval attempts of the chunk
+func (self *NetStore) retrieved(key Key) {
+	self.failedMu.Lock()
+	defer self.failedMu.Unlock()
+	delete(self.failed, string(key))
 }
 
-func (self *NetStore) get(key Key, timeout time.Duration) (chunk *Chunk, err error) {
+// get retrieves the chunk with a single network request. Concurrent callers
+// of the same chunk share the request, which is only abandoned if all of
+// them are gone.
+func (self *NetStore) get(ctx context.Context, key Key, timeout time.Duration) (chunk *Chunk, err error) {
 	if timeout == 0 {
 		timeout = searchTimeout
 	}
 	if self.retrieve == nil {
-		chunk, err = self.localStore.Get(key)
-		if err == nil {
-			return chunk, nil
-		}
-		if err != ErrFetching {
-			return nil, err
-		}
-	} else {
+		return self.getLocal(ctx, key, timeout)
+	}
+
+	self.fetchesMu.Lock()
+	f, ok := self.fetches[string(key)]
+	if !ok {
 		var created bool
 		chunk, created = self.localStore.GetOrCreateRequest(key)
-
 		if chunk.ReqC == nil {
+			self.fetchesMu.Unlock()
 			return chunk, nil
 		}
+		fctx, cancel := context.WithTimeout(context.Background(), timeout)
+		f = &netFetch{
+			chunk:  chunk,
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		self.fetches[string(key)] = f
+		go self.fetch(fctx, key, f, created)
+	}
+	f.waiters++
+	self.fetchesMu.Unlock()
 
-		if created {
-			err := self.retrieve(chunk)
-			if err != nil {
-				// mark chunk request as failed so that we can retry it later
-				chunk.SetErrored(ErrChunkUnavailable)
-				return nil, err
-			}
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.chunk, nil
+	case <-ctx.Done():
+	}
+
+	self.fetchesMu.Lock()
+	defer self.fetchesMu.Unlock()
+	f.waiters--
+	if f.waiters == 0 {
+		// the request is abandoned, a later get creates a new one
+		if self.fetches[string(key)] == f {
+			delete(self.fetches, string(key))
+		}
+		f.chunk.SetErrored(ErrChunkNotFound)
+		f.cancel()
+	}
+	return nil, ctx.Err()
+}
+
+// fetch requests the chunk from the network if the request was created by
+// the caller, and waits for the delivery until the context is done
+func (self *NetStore) fetch(ctx context.Context, key Key, f *netFetch, created bool) {
+	defer func() {
+		self.fetchesMu.Lock()
+		if self.fetches[string(key)] == f {
+			delete(self.fetches, string(key))
 		}
+		self.fetchesMu.Unlock()
+		f.cancel()
+		close(f.done)
+	}()
+
+	chunk := f.chunk
+	if created {
+		if err := self.retrieve(ctx, chunk); err != nil {
+			// mark chunk request as failed so that we can retry it later
+			chunk.SetErrored(ErrChunkUnavailable)
+			f.err = err
+			return
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		f.err = ErrChunkNotFound
+		if ctx.Err() == context.DeadlineExceeded {
+			// mark chunk request as failed so that we can retry
+			chunk.SetErrored(ErrChunkNotFound)
+			self.retrievalFailed(key)
+		}
+		return
+	case <-chunk.ReqC:
+	}
+	chunk.SetErrored(nil)
+	self.retrieved(key)
+}
+
+// getLocal waits for the chunk to be delivered to the local store if there
+// is no network to retrieve it from
+func (self *NetStore) getLocal(ctx context.Context, key Key, timeout time.Duration) (chunk *Chunk, err error) {
+	chunk, err = self.localStore.Get(key)
+	if err == nil {
+		return chunk, nil
+	}
+	if err != ErrFetching {
+		return nil, err
 	}
 
 	t := time.NewTicker(timeout)
@@ -158,16 +302,28 @@ func (self *NetStore) get(key Key, timeout time.Duration) (chunk *Chunk, err err
 	case <-t.C:
 		// mark chunk request as failed so that we can retry
 		chunk.SetErrored(ErrChunkNotFound)
+		self.retrievalFailed(key)
 		return nil, ErrChunkNotFound
+	case <-ctx.Done():
+		// the request is abandoned, a later get creates a new one
+		chunk.SetErrored(ErrChunkNotFound)
+		return nil, ctx.Err()
 	case <-chunk.ReqC:
 	}
 	chunk.SetErrored(nil)
+	self.retrieved(key)
 	return chunk, nil
 }
 
 // Put is the entrypoint for local store requests coming from storeLoop
 func (self *NetStore) Put(chunk *Chunk) {
 	self.localStore.Put(chunk)
+	self.retrieved(chunk.Key)
+}
+
+// Has tells if the chunk is stored in the local store, it is not retrieved
+func (self *NetStore) Has(key Key) bool {
+	return self.localStore.Has(key)
 }
 
 // Close chunk store
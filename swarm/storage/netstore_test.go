@@ -17,9 +17,12 @@
 package storage
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"io/ioutil"
+	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -46,7 +49,7 @@ func newDummyChunk(key Key) *Chunk {
 	return chunk
 }
 
-func (m *mockRetrieve) retrieve(chunk *Chunk) error {
+func (m *mockRetrieve) retrieve(ctx context.Context, chunk *Chunk) error {
 	hkey := hex.EncodeToString(chunk.Key)
 	m.requests[hkey] += 1
 
@@ -120,3 +123,205 @@ func TestNetstoreFailedRequest(t *testing.T) {
 		t.Fatalf("expected to get a chunk with size 3, but got: %v", chunk.SData)
 	}
 }
+
+// TestNetStoreGetWithContext tests that a retrieval returns when its context
+// is done, and that the abandoned retrieve request is requested again
+func TestNetStoreGetWithContext(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "netstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	localStore, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	var requests int
+	netStore := NewNetStore(localStore, func(ctx context.Context, chunk *Chunk) error {
+		requests++
+		return nil
+	})
+	key := Key(make([]byte, 32))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = netStore.GetWithContext(ctx, key)
+	if rerr, ok := err.(*RetrievalError); !ok || rerr.Err != ErrChunkNotFound || rerr.Attempts != 1 {
+		t.Fatalf("expected ErrChunkNotFound after one attempt at the deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > searchTimeout {
+		t.Fatalf("expected to return at the deadline, returned after %v", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := netStore.GetWithContext(ctx, key); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected the abandoned request to be requested again, got %d requests", requests)
+	}
+
+	if _, err := netStore.GetWithContext(ctx, key); err != context.Canceled {
+		t.Fatalf("expected context.Canceled for a cancelled context, got %v", err)
+	}
+}
+
+// TestNetStoreRetryBackoff tests that failed retrievals of a chunk are
+// retried with exponentially growing delays and that the error reports
+// the number of attempts
+func TestNetStoreRetryBackoff(t *testing.T) {
+	defer func(t time.Duration) { searchTimeout = t }(searchTimeout)
+	searchTimeout = 50 * time.Millisecond
+
+	datadir, err := ioutil.TempDir("", "netstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	localStore, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	var requests []time.Time
+	netStore := NewNetStore(localStore, func(ctx context.Context, chunk *Chunk) error {
+		requests = append(requests, time.Now())
+		return nil
+	})
+	backoff := &RetryBackoff{Base: 50 * time.Millisecond, Max: 200 * time.Millisecond}
+	netStore.SetRetryBackoff(backoff)
+	key := Key(make([]byte, 32))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = netStore.GetWithContext(ctx, key)
+	rerr, ok := err.(*RetrievalError)
+	if !ok || rerr.Err != ErrChunkNotFound {
+		t.Fatalf("expected ErrChunkNotFound, got %v", err)
+	}
+	if rerr.Attempts != len(requests) {
+		t.Fatalf("expected %d attempts in the error, got %d", len(requests), rerr.Attempts)
+	}
+	if len(requests) < 3 {
+		t.Fatalf("expected at least 3 requests, got %d", len(requests))
+	}
+	for i := 1; i < len(requests); i++ {
+		min := searchTimeout + backoff.Delay(i)
+		if gap := requests[i].Sub(requests[i-1]); gap < min {
+			t.Fatalf("expected request %d at least %v after the previous one, got %v", i, min, gap)
+		}
+	}
+
+	// the backoff of a chunk applies to a new retrieval too
+	netStore.SetRetryBackoff(&RetryBackoff{Base: time.Second, Max: time.Second})
+	key = Key(make([]byte, 32))
+	key[0] = 1
+	ctx, cancel = context.WithTimeout(context.Background(), searchTimeout+200*time.Millisecond)
+	defer cancel()
+	n := len(requests)
+	netStore.GetWithContext(ctx, key)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	netStore.GetWithContext(ctx, key)
+	if len(requests) != n+1 {
+		t.Fatalf("expected no request during the backoff, got %d", len(requests)-n-1)
+	}
+
+	// a chunk stored during the backoff is returned without waiting
+	chunk := NewRandomChunk(chunkSize)
+	ctx, cancel = context.WithTimeout(context.Background(), searchTimeout+200*time.Millisecond)
+	defer cancel()
+	n = len(requests)
+	netStore.GetWithContext(ctx, chunk.Key)
+	netStore.Put(chunk)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := netStore.GetWithContext(ctx, chunk.Key); err != nil {
+		t.Fatalf("expected the stored chunk, got %v", err)
+	}
+	if len(requests) != n+1 {
+		t.Fatalf("expected no request for the stored chunk, got %d", len(requests)-n-1)
+	}
+	if delay := netStore.retryDelay(chunk.Key); delay != 0 {
+		t.Fatalf("expected the backoff to be cleared, got %v", delay)
+	}
+}
+
+// TestNetStoreSharedRequest tests that concurrent retrievals of a chunk share
+// a single network request, which is not abandoned while any caller waits
+func TestNetStoreSharedRequest(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "netstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	params := NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	localStore, err := NewLocalStore(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localStore.Close()
+
+	var mu sync.Mutex
+	var requests int
+	netStore := NewNetStore(localStore, func(ctx context.Context, chunk *Chunk) error {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		return nil
+	})
+	key := Key(make([]byte, 32))
+
+	// one of the callers gives up early
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+	go func() {
+		_, err := netStore.GetWithContext(ctx, key)
+		errC <- err
+	}()
+	const callers = 5
+	chunkC := make(chan *Chunk, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			chunk, err := netStore.Get(key)
+			if err != nil {
+				t.Error(err)
+			}
+			chunkC <- chunk
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-errC; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	chunk := NewChunk(key, nil)
+	chunk.SData = []byte{3, 0, 0, 0, 0, 0, 0, 0, 3, 4, 5}
+	localStore.Put(chunk)
+	for i := 0; i < callers; i++ {
+		select {
+		case <-chunkC:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for the callers to be released")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 1 {
+		t.Fatalf("expected a single network request, got %d", requests)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"runtime"
 	"sync"
 	"time"
 
@@ -62,6 +63,10 @@ import (
 var (
 	errLoadingTreeRootChunk = errors.New("LoadTree Error: Could not load root chunk")
 	errLoadingTreeChunk     = errors.New("LoadTree Error: Could not load chunk")
+	errAppendEmptyTree      = errors.New("Append Error: No chunk in the tree")
+
+	// the data size after which a resumable upload reports a checkpoint
+	checkpointInterval = uint64(DefaultChunkSize * 128)
 )
 
 const (
@@ -74,9 +79,20 @@ const (
 	TreeChunk = 1
 )
 
+// defaultWorkers returns the default number of workers hashing or storing
+// the chunks of an upload, ChunkProcessors or the number of CPUs usable by
+// the process if it is more
+func defaultWorkers() int {
+	if n := runtime.GOMAXPROCS(0); n > ChunkProcessors {
+		return n
+	}
+	return ChunkProcessors
+}
+
 type PyramidSplitterParams struct {
 	SplitterParams
-	getter Getter
+	getter     Getter
+	checkpoint func(Key, int64)
 }
 
 func NewPyramidSplitterParams(key Key, reader io.Reader, putter Putter, getter Getter, chunkSize int64) *PyramidSplitterParams {
@@ -87,9 +103,10 @@ func NewPyramidSplitterParams(key Key, reader io.Reader, putter Putter, getter G
 				chunkSize: chunkSize,
 				hashSize:  hashSize,
 			},
-			reader: reader,
-			putter: putter,
-			key:    key,
+			reader:  reader,
+			putter:  putter,
+			key:     key,
+			workers: int64(defaultWorkers()),
 		},
 		getter: getter,
 	}
@@ -109,24 +126,20 @@ func PyramidAppend(key Key, reader io.Reader, putter Putter, getter Getter) (Key
 
 // Entry to create a tree node
 type TreeEntry struct {
-	level         int
-	branchCount   int64
-	subtreeSize   uint64
-	chunk         []byte
-	key           []byte
-	index         int  // used in append to indicate the index of existing tree entry
-	updatePending bool // indicates if the entry is loaded from existing tree
+	level       int
+	branchCount int64
+	subtreeSize uint64
+	chunk       []byte
+	key         []byte
 }
 
 func NewTreeEntry(pyramid *PyramidChunker) *TreeEntry {
 	return &TreeEntry{
-		level:         0,
-		branchCount:   0,
-		subtreeSize:   0,
-		chunk:         make([]byte, pyramid.chunkSize+8),
-		key:           make([]byte, pyramid.hashSize),
-		index:         0,
-		updatePending: false,
+		level:       0,
+		branchCount: 0,
+		subtreeSize: 0,
+		chunk:       make([]byte, pyramid.chunkSize+8),
+		key:         make([]byte, pyramid.hashSize),
 	}
 }
 
@@ -145,6 +158,7 @@ type PyramidChunker struct {
 	putter      Putter
 	getter      Getter
 	key         Key
+	workers     int64
 	workerCount int64
 	workerLock  sync.RWMutex
 	jobC        chan *chunkJob
@@ -153,6 +167,7 @@ type PyramidChunker struct {
 	quitC       chan bool
 	rootKey     []byte
 	chunkLevel  [][]*TreeEntry
+	checkpoint  func(Key, int64) // called with the key and size of the content appended so far
 }
 
 func NewPyramidSplitter(params *PyramidSplitterParams) (self *PyramidChunker) {
@@ -164,13 +179,15 @@ func NewPyramidSplitter(params *PyramidSplitterParams) (self *PyramidChunker) {
 	self.putter = params.putter
 	self.getter = params.getter
 	self.key = params.key
+	self.workers = params.workers
 	self.workerCount = 0
-	self.jobC = make(chan *chunkJob, 2*ChunkProcessors)
+	self.jobC = make(chan *chunkJob, 2*self.workers)
 	self.wg = &sync.WaitGroup{}
 	self.errC = make(chan error)
 	self.quitC = make(chan bool)
 	self.rootKey = make([]byte, self.hashSize)
 	self.chunkLevel = make([][]*TreeEntry, self.branches)
+	self.checkpoint = params.checkpoint
 	return
 }
 
@@ -207,7 +224,7 @@ func (self *PyramidChunker) Split() (k Key, wait func(), err error) {
 	log.Debug("pyramid.chunker: Split()")
 
 	self.wg.Add(1)
-	self.prepareChunks(false)
+	prepareErr := self.prepareChunks()
 
 	// closes internal error channel if all subprocesses in the workgroup finished
 	go func() {
@@ -224,6 +241,11 @@ func (self *PyramidChunker) Split() (k Key, wait func(), err error) {
 	defer close(self.quitC)
 	defer self.putter.Close()
 
+	// the data could not be read
+	if prepareErr != nil {
+		return nil, nil, prepareErr
+	}
+
 	select {
 	case err := <-self.errC:
 		if err != nil {
@@ -235,36 +257,251 @@ func (self *PyramidChunker) Split() (k Key, wait func(), err error) {
 
 }
 
+// Append appends the data of the reader to the content stored under the key
+// of the chunker. Only the chunks on the right edge of the tree of the
+// existing content are loaded, they are recomputed together with the chunks
+// of the appended data. Without a key the data is stored as new content.
+//
+// If the chunker has a checkpoint function, it is called with the key of the
+// content read so far every checkpointInterval bytes, so that an interrupted
+// upload can be continued by appending to it.
 func (self *PyramidChunker) Append() (k Key, wait func(), err error) {
 	log.Debug("pyramid.chunker: Append()")
-	// Load the right most unfinished tree chunks in every level
-	self.loadTree()
+	defer func() {
+		self.putter.Close()
+		// the chunks of the checkpoints are stored before a failed upload returns
+		if err != nil && self.checkpoint != nil {
+			self.putter.Wait()
+		}
+	}()
 
-	self.wg.Add(1)
-	self.prepareChunks(true)
+	edge := &treeEdge{}
+	if self.key != nil {
+		if err := self.loadEdge(edge); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	// closes internal error channel if all subprocesses in the workgroup finished
-	go func() {
+	buf := make([]byte, self.chunkSize)
+	checkpointed := edge.size
+	for {
+		n, err := io.ReadFull(self.reader, buf[:self.chunkSize-int64(len(edge.data))])
+		edge.data = append(edge.data, buf[:n]...)
+		edge.size += uint64(n)
+		if int64(len(edge.data)) == self.chunkSize {
+			if err := self.flushData(edge); err != nil {
+				return nil, nil, err
+			}
+			if self.checkpoint != nil && edge.size-checkpointed >= checkpointInterval {
+				if err := self.checkpointEdge(edge); err != nil {
+					return nil, nil, err
+				}
+				checkpointed = edge.size
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
-		// waiting for all chunks to finish
-		self.wg.Wait()
+	ref, err := self.finishEdge(edge)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Key(ref), self.putter.Wait, nil
+}
 
-		close(self.errC)
-	}()
+// treeEdge is the right edge of a chunk tree under construction: the
+// references of the finished subtrees on every level that are not referenced
+// by a tree chunk yet, and the data of the unfinished data chunk
+type treeEdge struct {
+	levels [][]treeRef
+	data   []byte
+	size   uint64 // data size of the whole tree
+}
 
-	defer close(self.quitC)
-	defer self.putter.Close()
+// treeRef is the reference and the data size of a subtree
+type treeRef struct {
+	ref  Reference
+	size uint64
+}
 
-	select {
-	case err := <-self.errC:
+// loadEdge loads the right edge of the tree under the key of the chunker.
+// All subtrees but the right most one of a tree chunk are complete, so only
+// one chunk is retrieved on every level. The depth of the subtrees is derived
+// from their size the same way as the joiner does.
+func (self *PyramidChunker) loadEdge(edge *treeEdge) error {
+	ref := Reference(self.key)
+	chunkData, err := self.getter.Get(ref)
+	if err != nil {
+		return errLoadingTreeRootChunk
+	}
+	size := uint64(chunkData.Size())
+	edge.size = size
+
+	// the level of the root chunk above the data chunks and the size of its subtrees
+	var depth int
+	treeSize := uint64(self.chunkSize)
+	for ; treeSize < size; treeSize *= uint64(self.branches) {
+		depth++
+	}
+	treeSize /= uint64(self.branches)
+
+	for {
+		// a small right most subtree is referenced from a higher level
+		for ; depth > 0 && size < treeSize; depth-- {
+			treeSize /= uint64(self.branches)
+		}
+		if depth == 0 {
+			break
+		}
+		refs := chunkData.Data()
+		last := int64((size - 1) / treeSize)
+		for i := int64(0); i < last; i++ {
+			if err := self.pushRef(edge, depth-1, Reference(refs[i*self.hashSize:(i+1)*self.hashSize]), treeSize); err != nil {
+				return err
+			}
+		}
+		size -= uint64(last) * treeSize
+		ref = Reference(refs[last*self.hashSize : (last+1)*self.hashSize])
+		chunkData, err = self.getter.Get(ref)
 		if err != nil {
-			return nil, nil, err
+			return errLoadingTreeChunk
 		}
-	case <-time.NewTimer(splitTimeout).C:
+		depth--
+		treeSize /= uint64(self.branches)
 	}
 
-	return self.rootKey, self.putter.Wait, nil
+	// the right most data chunk is continued unless it is full
+	if size < uint64(self.chunkSize) {
+		edge.data = append([]byte{}, chunkData.Data()[:size]...)
+		return nil
+	}
+	return self.pushRef(edge, 0, ref, size)
+}
 
+// pushRef adds the reference of a finished subtree to the level, once the
+// level is full it is referenced by a new tree chunk on the level above
+func (self *PyramidChunker) pushRef(edge *treeEdge, level int, ref Reference, size uint64) error {
+	for len(edge.levels) <= level {
+		edge.levels = append(edge.levels, nil)
+	}
+	edge.levels[level] = append(edge.levels[level], treeRef{ref, size})
+	if int64(len(edge.levels[level])) < self.branches {
+		return nil
+	}
+	ref, size, err := self.putTreeChunk(edge.levels[level])
+	if err != nil {
+		return err
+	}
+	edge.levels[level] = nil
+	return self.pushRef(edge, level+1, ref, size)
+}
+
+// flushData stores the unfinished data chunk of the edge
+func (self *PyramidChunker) flushData(edge *treeEdge) error {
+	chunkData := make(ChunkData, len(edge.data)+8)
+	binary.LittleEndian.PutUint64(chunkData[:8], uint64(len(edge.data)))
+	copy(chunkData[8:], edge.data)
+	ref, err := self.putter.Put(chunkData)
+	if err != nil {
+		return err
+	}
+	size := uint64(len(edge.data))
+	edge.data = edge.data[:0]
+	return self.pushRef(edge, 0, ref, size)
+}
+
+// putTreeChunk stores the tree chunk referencing the subtrees
+func (self *PyramidChunker) putTreeChunk(refs []treeRef) (Reference, uint64, error) {
+	chunkData := make(ChunkData, 8, int64(len(refs))*self.hashSize+8)
+	var size uint64
+	for _, r := range refs {
+		chunkData = append(chunkData, r.ref...)
+		size += r.size
+	}
+	binary.LittleEndian.PutUint64(chunkData[:8], size)
+	ref, err := self.putter.Put(chunkData)
+	return ref, size, err
+}
+
+// finishEdge stores the unfinished chunks of the edge from the bottom up and
+// returns the reference of the root chunk
+func (self *PyramidChunker) finishEdge(edge *treeEdge) (Reference, error) {
+	// the data chunk of empty content is stored too
+	if len(edge.data) > 0 || len(edge.levels) == 0 {
+		if err := self.flushData(edge); err != nil {
+			return nil, err
+		}
+	}
+	for level := 0; level < len(edge.levels); level++ {
+		refs := edge.levels[level]
+		if len(refs) == 0 {
+			continue
+		}
+		if len(refs) == 1 && level == len(edge.levels)-1 {
+			return refs[0].ref, nil
+		}
+		edge.levels[level] = nil
+		// a single subtree which is not full is referenced directly from the
+		// level above, the joiner would not expect a tree chunk on top of it
+		if len(refs) == 1 && refs[0].size < self.levelSize(level) {
+			if err := self.pushRef(edge, level+1, refs[0].ref, refs[0].size); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ref, size, err := self.putTreeChunk(refs)
+		if err != nil {
+			return nil, err
+		}
+		if err := self.pushRef(edge, level+1, ref, size); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errAppendEmptyTree
+}
+
+// checkpointEdge stores the tree chunks of the edge up to a root without
+// changing the edge and reports the key of the content read so far
+func (self *PyramidChunker) checkpointEdge(edge *treeEdge) error {
+	partial := &treeEdge{
+		levels: make([][]treeRef, len(edge.levels)),
+		data:   append([]byte{}, edge.data...),
+		size:   edge.size,
+	}
+	for level, refs := range edge.levels {
+		partial.levels[level] = append([]treeRef{}, refs...)
+	}
+	ref, err := self.finishEdge(partial)
+	if err != nil {
+		return err
+	}
+	log.Trace("pyramid.chunker: checkpoint", "key", Key(ref), "size", edge.size)
+	self.checkpoint(Key(ref), int64(edge.size))
+	return nil
+}
+
+// isTopLevel tells if there are no tree entries above the level
+func (self *PyramidChunker) isTopLevel(lvl int64) bool {
+	for l := lvl + 1; l < int64(len(self.chunkLevel)); l++ {
+		if len(self.chunkLevel[l]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// levelSize returns the data size of a full subtree on the level
+func (self *PyramidChunker) levelSize(level int) uint64 {
+	size := uint64(self.chunkSize)
+	for ; level > 0; level-- {
+		size *= uint64(self.branches)
+	}
+	return size
 }
 
 func (self *PyramidChunker) processor(id int64) {
@@ -298,93 +535,8 @@ func (self *PyramidChunker) processChunk(id int64, job *chunkJob) {
 	job.parentWg.Done()
 }
 
-func (self *PyramidChunker) loadTree() error {
-	log.Debug("pyramid.chunker: loadTree()")
-	// Get the root chunk to get the total size
-	chunkData, err := self.getter.Get(Reference(self.key))
-	if err != nil {
-		return errLoadingTreeRootChunk
-	}
-	chunkSize := chunkData.Size()
-	log.Trace("pyramid.chunker: root chunk", "chunk.Size", chunkSize, "self.chunkSize", self.chunkSize)
-
-	//if data size is less than a chunk... add a parent with update as pending
-	if chunkSize <= self.chunkSize {
-		newEntry := &TreeEntry{
-			level:         0,
-			branchCount:   1,
-			subtreeSize:   uint64(chunkSize),
-			chunk:         make([]byte, self.chunkSize+8),
-			key:           make([]byte, self.hashSize),
-			index:         0,
-			updatePending: true,
-		}
-		copy(newEntry.chunk[8:], self.key)
-		self.chunkLevel[0] = append(self.chunkLevel[0], newEntry)
-		return nil
-	}
-
-	var treeSize int64
-	var depth int
-	treeSize = self.chunkSize
-	for ; treeSize < chunkSize; treeSize *= self.branches {
-		depth++
-	}
-	log.Trace("pyramid.chunker", "depth", depth)
-
-	// Add the root chunk entry
-	branchCount := int64(len(chunkData)-8) / self.hashSize
-	newEntry := &TreeEntry{
-		level:         depth - 1,
-		branchCount:   branchCount,
-		subtreeSize:   uint64(chunkSize),
-		chunk:         chunkData,
-		key:           self.key,
-		index:         0,
-		updatePending: true,
-	}
-	self.chunkLevel[depth-1] = append(self.chunkLevel[depth-1], newEntry)
-
-	// Add the rest of the tree
-	for lvl := depth - 1; lvl >= 1; lvl-- {
-
-		//TODO(jmozah): instead of loading finished branches and then trim in the end,
-		//avoid loading them in the first place
-		for _, ent := range self.chunkLevel[lvl] {
-			branchCount = int64(len(ent.chunk)-8) / self.hashSize
-			for i := int64(0); i < branchCount; i++ {
-				key := ent.chunk[8+(i*self.hashSize) : 8+((i+1)*self.hashSize)]
-				newChunkData, err := self.getter.Get(Reference(key))
-				if err != nil {
-					return errLoadingTreeChunk
-				}
-				newChunkSize := newChunkData.Size()
-				bewBranchCount := int64(len(newChunkData)-8) / self.hashSize
-				newEntry := &TreeEntry{
-					level:         lvl - 1,
-					branchCount:   bewBranchCount,
-					subtreeSize:   uint64(newChunkSize),
-					chunk:         newChunkData,
-					key:           key,
-					index:         0,
-					updatePending: true,
-				}
-				self.chunkLevel[lvl-1] = append(self.chunkLevel[lvl-1], newEntry)
-
-			}
-
-			// We need to get only the right most unfinished branch.. so trim all finished branches
-			if int64(len(self.chunkLevel[lvl-1])) >= self.branches {
-				self.chunkLevel[lvl-1] = nil
-			}
-		}
-	}
-
-	return nil
-}
-
-func (self *PyramidChunker) prepareChunks(isAppend bool) {
-	log.Debug("pyramid.chunker: prepareChunks", "isAppend", isAppend)
+func (self *PyramidChunker) prepareChunks() error {
+	log.Debug("pyramid.chunker: prepareChunks")
 	defer self.wg.Done()
 
 	chunkWG := &sync.WaitGroup{}
@@ -394,41 +546,6 @@ func (self *PyramidChunker) prepareChunks(isAppend bool) {
 	go self.processor(self.workerCount)
 
 	parent := NewTreeEntry(self)
-	var unfinishedChunkData ChunkData
-	var unfinishedChunkSize int64
-
-	if isAppend && len(self.chunkLevel[0]) != 0 {
-		lastIndex := len(self.chunkLevel[0]) - 1
-		ent := self.chunkLevel[0][lastIndex]
-
-		if ent.branchCount < self.branches {
-			parent = &TreeEntry{
-				level:         0,
-				branchCount:   ent.branchCount,
-				subtreeSize:   ent.subtreeSize,
-				chunk:         ent.chunk,
-				key:           ent.key,
-				index:         lastIndex,
-				updatePending: true,
-			}
-
-			lastBranch := parent.branchCount - 1
-			lastKey := parent.chunk[8+lastBranch*self.hashSize : 8+(lastBranch+1)*self.hashSize]
-
-			var err error
-			unfinishedChunkData, err = self.getter.Get(lastKey)
-			if err != nil {
-				self.errC <- err
-			}
-			unfinishedChunkSize = unfinishedChunkData.Size()
-			if unfinishedChunkSize < self.chunkSize {
-				parent.subtreeSize = parent.subtreeSize - uint64(unfinishedChunkSize)
-				parent.branchCount = parent.branchCount - 1
-			} else {
-				unfinishedChunkData = nil
-			}
-		}
-	}
 
 	for index := 0; ; index++ {
 		var err error
@@ -436,13 +553,6 @@ func (self *PyramidChunker) prepareChunks(isAppend bool) {
 
 		var readBytes int
 
-		if unfinishedChunkData != nil {
-			copy(chunkData, unfinishedChunkData)
-			readBytes += int(unfinishedChunkSize)
-			unfinishedChunkData = nil
-			log.Trace("pyramid.chunker: found unfinished chunk", "readBytes", readBytes)
-		}
-
 		var res []byte
 		res, err = ioutil.ReadAll(io.LimitReader(self.reader, int64(len(chunkData)-(8+readBytes))))
 
@@ -459,7 +569,7 @@ func (self *PyramidChunker) prepareChunks(isAppend bool) {
 
 		if err != nil {
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				if parent.branchCount == 1 {
+				if parent.branchCount == 1 && index == 1 {
 					// Data is exactly one chunk.. pick the last chunk key as root
 					chunkWG.Wait()
 					lastChunksKey := parent.chunk[8 : 8+self.hashSize]
@@ -467,14 +577,20 @@ func (self *PyramidChunker) prepareChunks(isAppend bool) {
 					break
 				}
 			} else {
-				close(self.quitC)
-				break
+				return err
 			}
 		}
 
 		// Data ended in chunk boundary.. just signal to start bulding tree
 		if readBytes == 0 {
-			self.buildTree(isAppend, parent, chunkWG, true)
+			if index == 0 {
+				// no data at all, the root is the empty data chunk
+				pkey := self.enqueueDataChunk(chunkData, 0, parent, chunkWG)
+				chunkWG.Wait()
+				copy(self.rootKey, pkey)
+				break
+			}
+			self.buildTree(parent, chunkWG, true)
 			break
 		} else {
 			pkey := self.enqueueDataChunk(chunkData, uint64(readBytes), parent, chunkWG)
@@ -489,32 +605,39 @@ func (self *PyramidChunker) prepareChunks(isAppend bool) {
 				// only one data chunk .. so dont add any parent chunk
 				if parent.branchCount <= 1 {
 					chunkWG.Wait()
-					copy(self.rootKey, pkey)
+					if index == 0 {
+						copy(self.rootKey, pkey)
+						break
+					}
+					// the lonely data chunk on the last branch is referenced
+					// directly by the tree chunk above
+					copy(parent.key, pkey)
+					self.buildTree(parent, chunkWG, true)
 					break
 				}
 
-				self.buildTree(isAppend, parent, chunkWG, true)
+				self.buildTree(parent, chunkWG, true)
 				break
 			}
 
 			if parent.branchCount == self.branches {
-				self.buildTree(isAppend, parent, chunkWG, false)
+				self.buildTree(parent, chunkWG, false)
 				parent = NewTreeEntry(self)
 			}
 
 		}
 
 		workers := self.getWorkerCount()
-		if int64(len(self.jobC)) > workers && workers < ChunkProcessors {
+		if int64(len(self.jobC)) > workers && workers < self.workers {
 			self.incrementWorkerCount()
 			go self.processor(self.workerCount)
 		}
 
 	}
-
+	return nil
 }
 
-func (self *PyramidChunker) buildTree(isAppend bool, ent *TreeEntry, chunkWG *sync.WaitGroup, last bool) {
+func (self *PyramidChunker) buildTree(ent *TreeEntry, chunkWG *sync.WaitGroup, last bool) {
 	chunkWG.Wait()
 	self.enqueueTreeChunk(ent, chunkWG, last)
 
@@ -532,6 +655,10 @@ func (self *PyramidChunker) buildTree(isAppend bool, ent *TreeEntry, chunkWG *sy
 	if !compress && !last {
 		return
 	}
+	// the last chunk might fill up levels, the tree is built up to the root
+	if last {
+		endLvl = self.branches
+	}
 
 	// Wait for all the keys to be processed before compressing the tree
 	chunkWG.Wait()
@@ -539,7 +666,7 @@ func (self *PyramidChunker) buildTree(isAppend bool, ent *TreeEntry, chunkWG *sy
 	for lvl := int64(ent.level); lvl < endLvl; lvl++ {
 
 		lvlCount := int64(len(self.chunkLevel[lvl]))
-		if lvlCount == 1 && last {
+		if lvlCount == 1 && last && self.isTopLevel(lvl) {
 			copy(self.rootKey, self.chunkLevel[lvl][0].key)
 			return
 		}
@@ -551,68 +678,50 @@ func (self *PyramidChunker) buildTree(isAppend bool, ent *TreeEntry, chunkWG *sy
 				endCount = lvlCount
 			}
 
-			var nextLvlCount int64
-			var tempEntry *TreeEntry
-			if len(self.chunkLevel[lvl+1]) > 0 {
-				nextLvlCount = int64(len(self.chunkLevel[lvl+1]) - 1)
-				tempEntry = self.chunkLevel[lvl+1][nextLvlCount]
-			}
-			if isAppend && tempEntry != nil && tempEntry.updatePending {
-				updateEntry := &TreeEntry{
-					level:         int(lvl + 1),
-					branchCount:   0,
-					subtreeSize:   0,
-					chunk:         make([]byte, self.chunkSize+8),
-					key:           make([]byte, self.hashSize),
-					index:         int(nextLvlCount),
-					updatePending: true,
-				}
-				for index := int64(0); index < lvlCount; index++ {
-					updateEntry.branchCount++
-					updateEntry.subtreeSize += self.chunkLevel[lvl][index].subtreeSize
-					copy(updateEntry.chunk[8+(index*self.hashSize):8+((index+1)*self.hashSize)], self.chunkLevel[lvl][index].key[:self.hashSize])
-				}
-
-				self.enqueueTreeChunk(updateEntry, chunkWG, last)
-
-			} else {
-
-				noOfBranches := endCount - startCount
-				newEntry := &TreeEntry{
-					level:         int(lvl + 1),
-					branchCount:   noOfBranches,
-					subtreeSize:   0,
-					chunk:         make([]byte, (noOfBranches*self.hashSize)+8),
-					key:           make([]byte, self.hashSize),
-					index:         int(nextLvlCount),
-					updatePending: false,
-				}
+			noOfBranches := endCount - startCount
 
-				index := int64(0)
-				for i := startCount; i < endCount; i++ {
-					entry := self.chunkLevel[lvl][i]
-					newEntry.subtreeSize += entry.subtreeSize
-					copy(newEntry.chunk[8+(index*self.hashSize):8+((index+1)*self.hashSize)], entry.key[:self.hashSize])
-					index++
-				}
+			// a single subtree which is not full is referenced directly from
+			// the level above, the joiner would not expect a tree chunk on top of it
+			if entry := self.chunkLevel[lvl][startCount]; last && noOfBranches == 1 && entry.subtreeSize < self.levelSize(int(lvl+1)) {
+				self.chunkLevel[lvl+1] = append(self.chunkLevel[lvl+1], entry)
+				continue
+			}
 
-				self.enqueueTreeChunk(newEntry, chunkWG, last)
+			newEntry := &TreeEntry{
+				level:       int(lvl + 1),
+				branchCount: noOfBranches,
+				subtreeSize: 0,
+				chunk:       make([]byte, (noOfBranches*self.hashSize)+8),
+				key:         make([]byte, self.hashSize),
+			}
 
+			index := int64(0)
+			for i := startCount; i < endCount; i++ {
+				entry := self.chunkLevel[lvl][i]
+				newEntry.subtreeSize += entry.subtreeSize
+				copy(newEntry.chunk[8+(index*self.hashSize):8+((index+1)*self.hashSize)], entry.key[:self.hashSize])
+				index++
 			}
 
+			self.enqueueTreeChunk(newEntry, chunkWG, last)
+
 		}
 
-		if !isAppend {
-			chunkWG.Wait()
-			if compress {
-				self.chunkLevel[lvl] = nil
-			}
+		chunkWG.Wait()
+		if compress {
+			self.chunkLevel[lvl] = nil
 		}
 	}
 
 }
 
 func (self *PyramidChunker) enqueueTreeChunk(ent *TreeEntry, chunkWG *sync.WaitGroup, last bool) {
+	if ent != nil && ent.branchCount == 1 && ent.subtreeSize < uint64(self.chunkSize) {
+		// a lonely data chunk which is not full is not wrapped in a tree
+		// chunk, the entry has the key of the data chunk
+		self.chunkLevel[ent.level] = append(self.chunkLevel[ent.level], ent)
+		return
+	}
 	if ent != nil && ent.branchCount > 0 {
 
 		// wait for data chunks to get over before processing the tree chunk
@@ -628,13 +737,7 @@ func (self *PyramidChunker) enqueueTreeChunk(ent *TreeEntry, chunkWG *sync.WaitG
 		case <-self.quitC:
 		}
 
-		// Update or append based on weather it is a new entry or being reused
-		if ent.updatePending {
-			chunkWG.Wait()
-			self.chunkLevel[ent.level][ent.index] = ent
-		} else {
-			self.chunkLevel[ent.level] = append(self.chunkLevel[ent.level], ent)
-		}
+		self.chunkLevel[ent.level] = append(self.chunkLevel[ent.level], ent)
 
 	}
 }
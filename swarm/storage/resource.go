@@ -6,7 +6,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"math/big"
+	"io"
 	"path/filepath"
 	"sync"
 	"time"
@@ -14,10 +14,10 @@ import (
 	"golang.org/x/net/idna"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/contracts/ens"
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/multihash"
 )
 
 const (
@@ -29,8 +29,13 @@ const (
 	hasherCount             = 8
 	resourceHash            = SHA3Hash
 	defaultRetrieveTimeout  = 100 * time.Millisecond
+	resourceFlagTimeBased   = 1 // metadata flag for resources with wall-clock periods
 )
 
+// maxResolvedContentSize is the size of the content referenced by a multihash
+// update that GetResolvedContent reads at most
+var maxResolvedContentSize int64 = 16 * 1024 * 1024
+
 type blockEstimator struct {
 	Start   time.Time
 	Average time.Duration
@@ -51,10 +56,8 @@ func NewBlockEstimator() *blockEstimator {
 	}
 }
 
-func (b *blockEstimator) HeaderByNumber(context.Context, string, *big.Int) (*types.Header, error) {
-	return &types.Header{
-		Number: big.NewInt(time.Since(b.Start).Nanoseconds() / b.Average.Nanoseconds()),
-	}, nil
+func (b *blockEstimator) BlockNumber(context.Context, string) (uint64, error) {
+	return uint64(time.Since(b.Start).Nanoseconds() / b.Average.Nanoseconds()), nil
 }
 
 type ResourceError struct {
@@ -78,7 +81,7 @@ func NewResourceError(code int, s string) error {
 		err: s,
 	}
 	switch code {
-	case ErrNotFound, ErrIO, ErrUnauthorized, ErrInvalidValue, ErrDataOverflow, ErrNothingToReturn, ErrInvalidSignature, ErrNotSynced, ErrPeriodDepth, ErrCorruptData:
+	case ErrNotFound, ErrIO, ErrUnauthorized, ErrInvalidValue, ErrDataOverflow, ErrNothingToReturn, ErrInvalidSignature, ErrNotSynced, ErrPeriodDepth, ErrCorruptData, ErrResourceRevoked:
 		r.code = code
 	}
 	return r
@@ -86,25 +89,55 @@ func NewResourceError(code int, s string) error {
 
 type Signature [signatureLength]byte
 
+// Bounds and strategy of resource update lookups
+//
+// If Limit is set, a lookup fails with ErrPeriodDepth after checking Max periods.
+// Strategy selects the periods to check, if nil periods are checked one by one
+// backwards from the latest possible one.
 type ResourceLookupParams struct {
-	Limit bool
-	Max   uint32
+	Limit    bool
+	Max      uint32
+	Strategy ResourceLookupStrategy
 }
 
 // Encapsulates an specific resource update. When synced it contains the most recent
 // version of the resource update data.
+//
+// Lookups and updates of a resource are serialized by its lock, so that different
+// resources can be used concurrently. The authorization state is guarded separately,
+// since the chunk validator reads it while an update of the resource stores its chunk.
 type resource struct {
+	lock     sync.Mutex
+	authLock sync.RWMutex // protects updaters, updaterChanges and revoked
 	*bytes.Reader
-	Multihash  bool
-	name       string
-	nameHash   common.Hash
-	startBlock uint64
-	lastPeriod uint32
-	lastKey    Key
-	frequency  uint64
-	version    uint32
-	data       []byte
-	updated    time.Time
+	Multihash      bool
+	name           string
+	nameHash       common.Hash
+	startBlock     uint64
+	lastPeriod     uint32
+	lastKey        Key
+	frequency      uint64
+	epochs         []resourceEpoch // frequency changes after the start block
+	updaters       []common.Address
+	updaterChanges []resourceUpdaters
+	controlVersion uint32 // number of control updates in the index
+	controlPeriod  uint32 // period in which the last control update was published
+	revoked        bool
+	timeBased      bool // start block and frequency are unix time and seconds
+	version        uint32
+	data           []byte
+	updated        time.Time
+	cachedAt       uint32 // period in which the index entry was last synced to the latest update
+}
+
+// ResourceUpdate is posted to subscribers when an update is published
+type ResourceUpdate struct {
+	Name      string
+	NameHash  common.Hash
+	Period    uint32
+	Version   uint32
+	Key       Key
+	Multihash bool
 }
 
 // TODO Expire content after a defined period (to force resync)
@@ -127,29 +160,106 @@ func (self *resource) Name() string {
 	return self.name
 }
 
+func (self *resource) StartBlock() uint64 {
+	return self.startBlock
+}
+
+// Frequency returns the most recently set update frequency
+func (self *resource) Frequency() uint64 {
+	if len(self.epochs) > 0 {
+		return self.epochs[len(self.epochs)-1].frequency
+	}
+	return self.frequency
+}
+
+func (self *resource) LastPeriod() uint32 {
+	return self.lastPeriod
+}
+
+func (self *resource) Version() uint32 {
+	return self.version
+}
+
+// TimeBased reports whether the periods of the resource are measured in seconds
+// since its start time instead of blocks
+func (self *resource) TimeBased() bool {
+	return self.timeBased
+}
+
 func (self *resource) UnmarshalBinary(data []byte) error {
 	self.startBlock = binary.LittleEndian.Uint64(data[:8])
 	self.frequency = binary.LittleEndian.Uint64(data[8:16])
-	self.name = string(data[16:])
+	name := data[16:]
+	// the flags and updaters follow the name after a zero byte, which is not valid in a name
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		if len(name) < i+2 || (len(name)-i-2)%common.AddressLength != 0 {
+			return NewResourceError(ErrCorruptData, "Invalid updaters length in metadata")
+		}
+		self.timeBased = name[i+1]&resourceFlagTimeBased != 0
+		self.updaters = decodeUpdaters(name[i+2:])
+		name = name[:i]
+	}
+	self.name = string(name)
 	return nil
 }
 
 func (self *resource) MarshalBinary() ([]byte, error) {
-	b := make([]byte, 16+len(self.name))
+	length := 16 + len(self.name)
+	extended := len(self.updaters) > 0 || self.timeBased
+	if extended {
+		length += 2 + len(self.updaters)*common.AddressLength
+	}
+	b := make([]byte, length)
 	binary.LittleEndian.PutUint64(b, self.startBlock)
 	binary.LittleEndian.PutUint64(b[8:], self.frequency)
-	copy(b[16:], []byte(self.name))
+	cursor := 16 + copy(b[16:], []byte(self.name))
+	if extended {
+		if self.timeBased {
+			b[cursor+1] |= resourceFlagTimeBased
+		}
+		cursor += 2
+		for _, addr := range self.updaters {
+			cursor += copy(b[cursor:], addr[:])
+		}
+	}
 	return b, nil
 }
 
+// Source of the current block height used for resource periods
+//
+// It can be backed by a full node, a light client, an external rpc endpoint
+// or an estimate. The name of the resource is passed so that implementations
+// can select the chain the name is registered on.
 type headerGetter interface {
-	HeaderByNumber(context.Context, string, *big.Int) (*types.Header, error)
+	BlockNumber(ctx context.Context, name string) (uint64, error)
+}
+
+// BlockNumberFunc is an adapter to allow the use of ordinary functions as block height sources
+type BlockNumberFunc func(ctx context.Context, name string) (uint64, error)
+
+func (f BlockNumberFunc) BlockNumber(ctx context.Context, name string) (uint64, error) {
+	return f(ctx, name)
 }
 
 type ownerValidator interface {
 	ValidateOwner(name string, address common.Address) (bool, error)
 }
 
+// implemented by owner validators that can tell the owner of a name
+type ownerGetter interface {
+	OwnerOf(name string) (common.Address, error)
+}
+
+// Parameters of a new mutable resource
+//
+// If TimeBased is set, Frequency is in seconds and periods are counted from the
+// creation time of the resource instead of its start block.
+type ResourceParams struct {
+	Frequency uint64
+	Updaters  []common.Address
+	TimeBased bool
+}
+
 // Mutable resource is an entity which allows updates to a resource
 // without resorting to ENS on each update.
 // The update scheme is built on swarm chunks with chunk keys following
@@ -173,6 +283,15 @@ type ownerValidator interface {
 // (The two first zero-value bytes are used for disambiguation by the chunk validator,
 // and update chunk will always have a value > 0 there.)
 //
+// Resources can be extended with flags and the addresses authorized to update them,
+// which follow the identifier after a zero byte:
+//
+// (0x0000|startblock|frequency|identifier|0x00|flags|address|address|...)
+//
+// If no updaters are listed, updates are authorized by the owner validator (typically
+// the ENS owner). If the time based flag is set, the start block and frequency are
+// a unix time and a number of seconds, and periods are independent of the chain.
+//
 // The root entry tells the requester from when the mutable resource was
 // first added (block number) and in which block number to look for the
 // actual updates. Thus, a resource update for identifier "føø.bar"
@@ -206,6 +325,7 @@ type ownerValidator interface {
 // TODO: Include modtime in chunk data + signature
 type ResourceHandler struct {
 	chunkStore      *NetStore
+	dpa             *DPA
 	HashSize        int
 	signer          ResourceSigner
 	headerGetter    headerGetter
@@ -215,6 +335,10 @@ type ResourceHandler struct {
 	resourceLock    sync.RWMutex
 	storeTimeout    time.Duration
 	queryMaxPeriods *ResourceLookupParams
+	updateFeed      event.Feed
+	timeNow         func() time.Time // clock of time based resources
+	indexPath       string
+	indexLock       sync.Mutex
 }
 
 type ResourceHandlerParams struct {
@@ -222,6 +346,7 @@ type ResourceHandlerParams struct {
 	Signer          ResourceSigner
 	HeaderGetter    headerGetter
 	OwnerValidator  ownerValidator
+	IndexPath       string // file the resource index is persisted to, empty to keep it in memory only
 }
 
 // Create or open resource update chunk store
@@ -243,6 +368,8 @@ func NewResourceHandler(params *ResourceHandlerParams) (*ResourceHandler, error)
 			},
 		},
 		queryMaxPeriods: params.QueryMaxPeriods,
+		timeNow:         time.Now,
+		indexPath:       params.IndexPath,
 	}
 
 	for i := 0; i < hasherCount; i++ {
@@ -253,17 +380,24 @@ func NewResourceHandler(params *ResourceHandlerParams) (*ResourceHandler, error)
 		rh.hashPool.Put(hashfunc)
 	}
 
+	if rh.indexPath != "" {
+		if err := rh.loadIndex(); err != nil {
+			return nil, fmt.Errorf("resource index load fail, path %s: %v", rh.indexPath, err)
+		}
+	}
 	return rh, nil
 }
 
 // Sets the store backend for resource updates
 func (self *ResourceHandler) SetStore(store *NetStore) {
 	self.chunkStore = store
+	self.dpa = NewDPA(store, NewDPAParams())
 }
 
 // Chunk Validation method (matches ChunkValidatorFunc signature)
 //
-// If resource update, owner is checked against ENS record of resource name inferred from chunk data
+// If resource update, owner is checked against ENS record of resource name inferred from chunk data,
+// or against the updaters of the resource if it is in the index
 // If parsed signature is nil, validates automatically
 // If not resource update, it validates are metadata chunk if length is metadataChunkOffsetSize and first two bytes are 0
 func (self *ResourceHandler) Validate(key Key, data []byte) bool {
@@ -277,15 +411,37 @@ func (self *ResourceHandler) Validate(key Key, data []byte) bool {
 		log.Error("Invalid resource chunk")
 		return false
 	} else if signature == nil {
-		return bytes.Equal(self.resourceHash(period, version, ens.EnsNode(name)), key)
+		return bytes.Equal(self.resourceHash(period, version, ResourceNameHash(name)), key)
 	}
 
+	if !bytes.Equal(self.resourceHash(period, version, ResourceNameHash(name)), key) {
+		log.Error("Resource update chunk key does not match its period and version")
+		return false
+	}
+	authperiod, control, err := authPeriod(period, parseddata)
+	if err != nil {
+		log.Error("Invalid resource control update chunk")
+		return false
+	} else if control {
+		if _, index, _, _, _ := parseControlData(parseddata); index != version {
+			log.Error("Resource control update chunk index does not match its version")
+			return false
+		}
+	}
 	digest := self.keyDataHash(key, parseddata)
 	addr, err := getAddressFromDataSig(digest, *signature)
 	if err != nil {
 		log.Error("Invalid signature on resource chunk")
 		return false
 	}
+	if rsrc := self.getResource(ResourceNameHash(name).Hex()); rsrc != nil {
+		if rsrc.isRevoked() {
+			log.Debug("Update chunk for revoked resource", "name", name)
+			return false
+		}
+		ok, _ := self.checkUpdater(rsrc, authperiod, control, addr)
+		return ok
+	}
 	ok, _ := self.checkAccess(name, addr)
 	return ok
 }
@@ -299,6 +455,10 @@ func (self *ResourceHandler) IsValidated() bool {
 func (self *ResourceHandler) keyDataHash(key Key, data []byte) common.Hash {
 	hasher := self.hashPool.Get().(SwarmHash)
 	defer self.hashPool.Put(hasher)
+	return updateDigest(hasher, key, data)
+}
+
+func updateDigest(hasher SwarmHash, key Key, data []byte) common.Hash {
 	hasher.Reset()
 	hasher.Write(key[:])
 	hasher.Write(data)
@@ -306,7 +466,12 @@ func (self *ResourceHandler) keyDataHash(key Key, data []byte) common.Hash {
 }
 
 // Checks if current address matches owner address of ENS
+//
+// The owner of a topic resource is the address of its public key.
 func (self *ResourceHandler) checkAccess(name string, address common.Address) (bool, error) {
+	if owner, ok := topicOwner(name); ok {
+		return owner == address, nil
+	}
 	if self.ownerValidator == nil {
 		return true, nil
 	}
@@ -315,13 +480,21 @@ func (self *ResourceHandler) checkAccess(name string, address common.Address) (b
 
 // Get the currently loaded data from the resource
 func (self *ResourceHandler) GetContent(nameHash string) (string, []byte, error) {
+	name, data, _, err := self.getContent(nameHash)
+	return name, data, err
+}
+
+func (self *ResourceHandler) getContent(nameHash string) (string, []byte, bool, error) {
 	rsrc := self.getResource(nameHash)
 	if rsrc == nil {
-		return "", nil, NewResourceError(ErrNotFound, "Resource does not exist")
-	} else if !rsrc.isSynced() {
-		return "", nil, NewResourceError(ErrNotSynced, "Resource is not synced")
+		return "", nil, false, NewResourceError(ErrNotFound, "Resource does not exist")
 	}
-	return rsrc.name, rsrc.data, nil
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	if !rsrc.isSynced() {
+		return "", nil, false, NewResourceError(ErrNotSynced, "Resource is not synced")
+	}
+	return rsrc.name, rsrc.data, rsrc.Multihash, nil
 }
 
 // Gets the period of the current data loaded in the resource
@@ -329,7 +502,10 @@ func (self *ResourceHandler) GetLastPeriod(nameHash string) (uint32, error) {
 	rsrc := self.getResource(nameHash)
 	if rsrc == nil {
 		return 0, NewResourceError(ErrNotFound, "Resource does not exist")
-	} else if !rsrc.isSynced() {
+	}
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	if !rsrc.isSynced() {
 		return 0, NewResourceError(ErrNotSynced, "Resource is not synced")
 	}
 	return rsrc.lastPeriod, nil
@@ -340,12 +516,52 @@ func (self *ResourceHandler) GetVersion(nameHash string) (uint32, error) {
 	rsrc := self.getResource(nameHash)
 	if rsrc == nil {
 		return 0, NewResourceError(ErrNotFound, "Resource does not exist")
-	} else if !rsrc.isSynced() {
+	}
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	if !rsrc.isSynced() {
 		return 0, NewResourceError(ErrNotSynced, "Resource is not synced")
 	}
 	return rsrc.version, nil
 }
 
+// Gets the full content of the current data loaded in the resource
+//
+// If the update is a multihash of a swarm hash, the content it references is
+// retrieved and returned instead of the multihash itself.
+func (self *ResourceHandler) GetResolvedContent(nameHash string) (string, []byte, error) {
+	name, data, ismultihash, err := self.getContent(nameHash)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ismultihash {
+		return name, data, nil
+	}
+	if self.dpa == nil {
+		return "", nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before resolving content")
+	}
+	decoded, err := multihash.Decode(data)
+	if err != nil {
+		return "", nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid multihash: %v", err))
+	} else if decoded.Code != multihash.KECCAK_256 {
+		return "", nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Multihash is not a swarm hash: %x", decoded.Code))
+	}
+	reader, _ := self.dpa.Retrieve(Key(decoded.Digest))
+	size, err := reader.Size(nil)
+	if err != nil {
+		return "", nil, NewResourceError(ErrNotFound, fmt.Sprintf("Referenced content not found: %v", err))
+	}
+	// the size is read from the root chunk of the content, which may come from any peer
+	if size < 0 || size > maxResolvedContentSize {
+		return "", nil, NewResourceError(ErrDataOverflow, fmt.Sprintf("Referenced content size %d exceeds %d bytes", size, maxResolvedContentSize))
+	}
+	content := make([]byte, size)
+	if _, err := reader.ReadAt(content, 0); err != nil && err != io.EOF {
+		return "", nil, NewResourceError(ErrIO, fmt.Sprintf("Referenced content read fail: %v", err))
+	}
+	return name, content, nil
+}
+
 // \TODO should be hashsize * branches from the chosen chunker, implement with dpa
 func (self *ResourceHandler) chunkSize() int64 {
 	return chunkSize
@@ -357,6 +573,25 @@ func (self *ResourceHandler) chunkSize() int64 {
 //
 // The start block of the resource update will be the actual current block height of the connected network.
 func (self *ResourceHandler) NewResource(ctx context.Context, name string, frequency uint64) (Key, *resource, error) {
+	return self.NewResourceWithParams(ctx, name, &ResourceParams{
+		Frequency: frequency,
+	})
+}
+
+// Creates a new mutable resource which can be updated by any of the given addresses
+//
+// The updaters are stored in the metadata chunk, and can later be replaced with UpdateUpdaters.
+// If no updaters are given, updates are authorized by the owner validator.
+func (self *ResourceHandler) NewResourceWithUpdaters(ctx context.Context, name string, frequency uint64, updaters []common.Address) (Key, *resource, error) {
+	return self.NewResourceWithParams(ctx, name, &ResourceParams{
+		Frequency: frequency,
+		Updaters:  updaters,
+	})
+}
+
+// Creates a new mutable resource with the given parameters
+func (self *ResourceHandler) NewResourceWithParams(ctx context.Context, name string, params *ResourceParams) (Key, *resource, error) {
+	frequency := params.Frequency
 
 	// frequency 0 is invalid
 	if frequency == 0 {
@@ -364,11 +599,11 @@ func (self *ResourceHandler) NewResource(ctx context.Context, name string, frequ
 	}
 
 	// make sure name only contains ascii values
-	if !isSafeName(name) {
+	if !isResourceName(name) {
 		return nil, nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid name: '%s'", name))
 	}
 
-	nameHash := ens.EnsNode(name)
+	nameHash := ResourceNameHash(name)
 
 	// if the signer function is set, validate that the key of the signer has access to modify this ENS name
 	if self.signer != nil {
@@ -388,43 +623,47 @@ func (self *ResourceHandler) NewResource(ctx context.Context, name string, frequ
 		}
 	}
 
-	// get our blockheight at this time
-	currentblock, err := self.getBlock(ctx, name)
+	// create the internal index for the resource and populate it with the data of the first version
+	rsrc := &resource{
+		frequency: frequency,
+		name:      name,
+		nameHash:  nameHash,
+		updaters:  params.Updaters,
+		timeBased: params.TimeBased,
+	}
+
+	// get our blockheight (or time) at this time
+	currentblock, err := self.getClock(ctx, rsrc)
 	if err != nil {
 		return nil, nil, err
 	}
+	rsrc.startBlock = currentblock
 
-	chunk := self.newMetaChunk(name, currentblock, frequency)
+	chunk := self.newMetaChunk(rsrc)
 
 	self.chunkStore.Put(chunk)
-	log.Debug("new resource", "name", name, "key", nameHash, "startBlock", currentblock, "frequency", frequency)
+	log.Debug("new resource", "name", name, "key", nameHash, "startBlock", currentblock, "frequency", frequency, "updaters", len(rsrc.updaters), "timebased", rsrc.timeBased)
 
-	// create the internal index for the resource and populate it with the data of the first version
-	rsrc := &resource{
-		startBlock: currentblock,
-		frequency:  frequency,
-		name:       name,
-		nameHash:   nameHash,
-		updated:    time.Now(),
-	}
+	rsrc.updated = time.Now()
 	self.setResource(nameHash.Hex(), rsrc)
 
+	// the publisher must not lose track of its resources if the node is not stopped cleanly
+	if err := self.SaveIndex(); err != nil {
+		log.Warn("resource index save fail", "path", self.indexPath, "err", err)
+	}
+
 	return chunk.Key, rsrc, nil
 }
 
-func (self *ResourceHandler) newMetaChunk(name string, startBlock uint64, frequency uint64) *Chunk {
+func (self *ResourceHandler) newMetaChunk(rsrc *resource) *Chunk {
 	// the metadata chunk points to data of first blockheight + update frequency
 	// from this we know from what blockheight we should look for updates, and how often
 	// it also contains the name of the resource, so we know what resource we are working with
-	data := make([]byte, metadataChunkOffsetSize+len(name))
+	metadata, _ := rsrc.MarshalBinary()
 
 	// root block has first two bytes both set to 0, which distinguishes from update bytes
-	val := make([]byte, 8)
-	binary.LittleEndian.PutUint64(val, startBlock)
-	copy(data[2:10], val)
-	binary.LittleEndian.PutUint64(val, frequency)
-	copy(data[10:18], val)
-	copy(data[18:], []byte(name))
+	data := make([]byte, 2+len(metadata))
+	copy(data[2:], metadata)
 
 	// the key of the metadata chunk is content-addressed
 	// if it wasn't we couldn't replace it later
@@ -437,8 +676,7 @@ func (self *ResourceHandler) newMetaChunk(name string, startBlock uint64, freque
 
 	// make the chunk and send it to swarm
 	chunk := NewChunk(key, nil)
-	chunk.SData = make([]byte, metadataChunkOffsetSize+len(name))
-	copy(chunk.SData, data)
+	chunk.SData = data
 	return chunk
 }
 
@@ -450,7 +688,7 @@ func (self *ResourceHandler) newMetaChunk(name string, startBlock uint64, freque
 // It is the callers responsibility to make sure that this chunk exists (if the resource
 // update root data was retrieved externally, it typically doesn't)
 func (self *ResourceHandler) LookupVersionByName(ctx context.Context, name string, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
-	return self.LookupVersion(ctx, ens.EnsNode(name), period, version, refresh, maxLookup)
+	return self.LookupVersion(ctx, ResourceNameHash(name), period, version, refresh, maxLookup)
 }
 
 func (self *ResourceHandler) LookupVersion(ctx context.Context, nameHash common.Hash, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
@@ -458,7 +696,9 @@ func (self *ResourceHandler) LookupVersion(ctx context.Context, nameHash common.
 	if rsrc == nil {
 		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
 	}
-	return self.lookup(rsrc, period, version, refresh, maxLookup)
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	return self.lookup(ctx, rsrc, period, version, refresh, maxLookup)
 }
 
 // Retrieves the latest version of the resource update identified by `name`
@@ -470,7 +710,7 @@ func (self *ResourceHandler) LookupVersion(ctx context.Context, nameHash common.
 //
 // See also (*ResourceHandler).LookupVersion
 func (self *ResourceHandler) LookupHistoricalByName(ctx context.Context, name string, period uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
-	return self.LookupHistorical(ctx, ens.EnsNode(name), period, refresh, maxLookup)
+	return self.LookupHistorical(ctx, ResourceNameHash(name), period, refresh, maxLookup)
 }
 
 func (self *ResourceHandler) LookupHistorical(ctx context.Context, nameHash common.Hash, period uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
@@ -478,7 +718,9 @@ func (self *ResourceHandler) LookupHistorical(ctx context.Context, nameHash comm
 	if rsrc == nil {
 		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
 	}
-	return self.lookup(rsrc, period, 0, refresh, maxLookup)
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	return self.lookup(ctx, rsrc, period, 0, refresh, maxLookup)
 }
 
 // Retrieves the latest version of the resource update identified by `name`
@@ -490,9 +732,13 @@ func (self *ResourceHandler) LookupHistorical(ctx context.Context, nameHash comm
 //
 // Version iteration is done as in (*ResourceHandler).LookupHistorical
 //
+// The resource index acts as a cache of the latest update: repeated lookups
+// within the same period return the indexed update without retrieving chunks,
+// unless refresh is set.
+//
 // See also (*ResourceHandler).LookupHistorical
 func (self *ResourceHandler) LookupLatestByName(ctx context.Context, name string, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
-	return self.LookupLatest(ctx, ens.EnsNode(name), refresh, maxLookup)
+	return self.LookupLatest(ctx, ResourceNameHash(name), refresh, maxLookup)
 }
 
 func (self *ResourceHandler) LookupLatest(ctx context.Context, nameHash common.Hash, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
@@ -502,15 +748,26 @@ func (self *ResourceHandler) LookupLatest(ctx context.Context, nameHash common.H
 	if rsrc == nil {
 		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
 	}
-	currentblock, err := self.getBlock(ctx, rsrc.name)
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	currentblock, err := self.getClock(ctx, rsrc)
+	if err != nil {
+		return nil, err
+	}
+	nextperiod, err := rsrc.blockToPeriod(currentblock)
 	if err != nil {
 		return nil, err
 	}
-	nextperiod, err := getNextPeriod(rsrc.startBlock, currentblock, rsrc.frequency)
+	if !refresh && rsrc.isSynced() && rsrc.cachedAt == nextperiod && !rsrc.isRevoked() {
+		log.Trace("resource lookup cache hit", "name", rsrc.name, "period", nextperiod)
+		return rsrc, nil
+	}
+	rsrc, err = self.lookup(ctx, rsrc, nextperiod, 0, refresh, maxLookup)
 	if err != nil {
 		return nil, err
 	}
-	return self.lookup(rsrc, nextperiod, 0, refresh, maxLookup)
+	rsrc.cachedAt = nextperiod
+	return rsrc, nil
 }
 
 // Returns the resource before the one currently loaded in the resource index
@@ -520,7 +777,7 @@ func (self *ResourceHandler) LookupLatest(ctx context.Context, nameHash common.H
 //
 // Requires a synced resource object
 func (self *ResourceHandler) LookupPreviousByName(ctx context.Context, name string, maxLookup *ResourceLookupParams) (*resource, error) {
-	return self.LookupPrevious(ctx, ens.EnsNode(name), maxLookup)
+	return self.LookupPrevious(ctx, ResourceNameHash(name), maxLookup)
 }
 
 func (self *ResourceHandler) LookupPrevious(ctx context.Context, nameHash common.Hash, maxLookup *ResourceLookupParams) (*resource, error) {
@@ -528,6 +785,8 @@ func (self *ResourceHandler) LookupPrevious(ctx context.Context, nameHash common
 	if rsrc == nil {
 		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
 	}
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
 	if !rsrc.isSynced() {
 		return nil, NewResourceError(ErrNotSynced, "LookupPrevious requires synced resource.")
 	} else if rsrc.lastPeriod == 0 {
@@ -541,11 +800,11 @@ func (self *ResourceHandler) LookupPrevious(ctx context.Context, nameHash common
 		rsrc.version = 0
 		rsrc.lastPeriod--
 	}
-	return self.lookup(rsrc, rsrc.lastPeriod, rsrc.version, false, maxLookup)
+	return self.lookup(ctx, rsrc, rsrc.lastPeriod, rsrc.version, false, maxLookup)
 }
 
 // base code for public lookup methods
-func (self *ResourceHandler) lookup(rsrc *resource, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
+func (self *ResourceHandler) lookup(ctx context.Context, rsrc *resource, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
 
 	// we can't look for anything without a store
 	if self.chunkStore == nil {
@@ -557,7 +816,17 @@ func (self *ResourceHandler) lookup(rsrc *resource, period uint32, version uint3
 		return nil, NewResourceError(ErrInvalidValue, "period must be >0")
 	}
 
-	// start from the last possible block period, and iterate previous ones until we find a match
+	// pick up control updates published since the resource was loaded
+	if refresh {
+		if err := self.loadControlUpdates(ctx, rsrc); err != nil {
+			return nil, err
+		}
+	}
+	if rsrc.isRevoked() {
+		return nil, NewResourceError(ErrResourceRevoked, fmt.Sprintf("Resource '%s' is revoked", rsrc.name))
+	}
+
+	// search from the last possible block period for the latest period with a match
 	// if we hit startBlock we're out of options
 	var specificversion bool
 	if version > 0 {
@@ -570,42 +839,72 @@ func (self *ResourceHandler) lookup(rsrc *resource, period uint32, version uint3
 	if maxLookup == nil {
 		maxLookup = self.queryMaxPeriods
 	}
+	strategy := maxLookup.Strategy
+	if strategy == nil {
+		strategy = LinearResourceLookup{}
+	}
 	log.Trace("resource lookup", "period", period, "version", version, "limit", maxLookup.Limit, "max", maxLookup.Max)
-	for period > 0 {
+	search := strategy.Search(period)
+	var chunk *Chunk
+	var found bool
+	var foundperiod uint32
+	for {
+		nextperiod, ok := search.Next(found)
+		if !ok {
+			break
+		}
 		if maxLookup.Limit && hops > maxLookup.Max {
 			return nil, NewResourceError(ErrPeriodDepth, fmt.Sprintf("Lookup exceeded max period hops (%d)", maxLookup.Max))
 		}
-		key := self.resourceHash(period, version, rsrc.nameHash)
-		chunk, err := self.chunkStore.get(key, defaultRetrieveTimeout)
-		if err == nil {
-			if specificversion {
-				return self.updateResourceIndex(rsrc, chunk)
-			}
-			// check if we have versions > 1. If a version fails, the previous version is used and returned.
-			log.Trace("rsrc update version 1 found, checking for version updates", "period", period, "key", key)
-			for {
-				newversion := version + 1
-				key := self.resourceHash(period, newversion, rsrc.nameHash)
-				newchunk, err := self.chunkStore.get(key, defaultRetrieveTimeout)
-				if err != nil {
-					return self.updateResourceIndex(rsrc, chunk)
-				}
-				chunk = newchunk
-				version = newversion
-				log.Trace("version update found, checking next", "version", version, "period", period, "key", key)
-			}
+		key := self.resourceHash(nextperiod, version, rsrc.nameHash)
+		newchunk, err := self.chunkStore.get(ctx, key, defaultRetrieveTimeout)
+		if err != nil && ctx.Err() != nil {
+			return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Lookup aborted: %v", ctx.Err()))
+		}
+		found = err == nil
+		if found && nextperiod > foundperiod {
+			chunk = newchunk
+			foundperiod = nextperiod
 		}
-		log.Trace("rsrc update not found, checking previous period", "period", period, "key", key)
-		period--
+		log.Trace("rsrc update lookup", "period", nextperiod, "key", key, "found", found)
 		hops++
 	}
-	return nil, NewResourceError(ErrNotFound, "no updates found")
+	if chunk == nil {
+		return nil, NewResourceError(ErrNotFound, "no updates found")
+	}
+	if specificversion {
+		return self.updateResourceIndex(rsrc, chunk)
+	}
+	// check if we have versions > 1.
+	// versions in a period are consecutive, so we double the version until one is missing,
+	// and then binary search between the latest found and the first missing version
+	log.Trace("rsrc update version 1 found, checking for version updates", "period", foundperiod, "key", chunk.Key)
+	var missing uint32
+	for missing == 0 || missing-version > 1 {
+		newversion := version * 2
+		if missing != 0 {
+			newversion = version + (missing-version)/2
+		}
+		key := self.resourceHash(foundperiod, newversion, rsrc.nameHash)
+		newchunk, err := self.chunkStore.get(ctx, key, defaultRetrieveTimeout)
+		if err != nil && ctx.Err() != nil {
+			return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Lookup aborted: %v", ctx.Err()))
+		}
+		if err != nil {
+			missing = newversion
+			continue
+		}
+		chunk = newchunk
+		version = newversion
+		log.Trace("version update found, checking next", "version", version, "period", foundperiod, "key", key)
+	}
+	return self.updateResourceIndex(rsrc, chunk)
 }
 
 // Retrieves a resource metadata chunk and creates/updates the index entry for it
 // with the resulting metadata
-func (self *ResourceHandler) LoadResource(key Key) (*resource, error) {
-	chunk, err := self.chunkStore.get(key, defaultRetrieveTimeout)
+func (self *ResourceHandler) LoadResource(ctx context.Context, key Key) (*resource, error) {
+	chunk, err := self.chunkStore.get(ctx, key, defaultRetrieveTimeout)
 	if err != nil {
 		return nil, NewResourceError(ErrNotFound, err.Error())
 	}
@@ -620,10 +919,15 @@ func (self *ResourceHandler) LoadResource(key Key) (*resource, error) {
 
 	// create the index entry
 	rsrc := &resource{}
-	rsrc.UnmarshalBinary(chunk.SData[2:])
-	rsrc.nameHash = ens.EnsNode(rsrc.name)
+	if err := rsrc.UnmarshalBinary(chunk.SData[2:]); err != nil {
+		return nil, err
+	}
+	rsrc.nameHash = ResourceNameHash(rsrc.name)
+	if err := self.loadControlUpdates(ctx, rsrc); err != nil {
+		return nil, err
+	}
 	self.setResource(rsrc.nameHash.Hex(), rsrc)
-	log.Trace("resource index load", "rootkey", key, "name", rsrc.name, "namehash", rsrc.nameHash, "startblock", rsrc.startBlock, "frequency", rsrc.frequency)
+	log.Trace("resource index load", "rootkey", key, "name", rsrc.name, "namehash", rsrc.nameHash, "startblock", rsrc.startBlock, "frequency", rsrc.frequency, "epochs", len(rsrc.epochs), "updaters", len(rsrc.Updaters()))
 	return rsrc, nil
 }
 
@@ -632,23 +936,24 @@ func (self *ResourceHandler) updateResourceIndex(rsrc *resource, chunk *Chunk) (
 
 	// retrieve metadata from chunk data and check that it matches this mutable resource
 	signature, period, version, name, data, multihash, err := self.parseUpdate(chunk.SData)
+	if err != nil {
+		return nil, err
+	}
 	if rsrc.name != name {
 		return nil, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Update belongs to '%s', but have '%s'", name, rsrc.name))
 	}
 	log.Trace("resource index update", "name", rsrc.name, "namehash", rsrc.nameHash, "updatekey", chunk.Key, "period", period, "version", version)
 
 	// check signature (if signer algorithm is present)
-	// \TODO maybe this check is redundant if also checked upon retrieval of chunk
-	if signature != nil {
-		digest := self.keyDataHash(chunk.Key, data)
-		_, err = getAddressFromDataSig(digest, *signature)
-		if err != nil {
-			return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Invalid signature: %v", err))
-		}
+	// and that the signer is allowed to update the resource
+	if err := self.checkUpdateSignature(rsrc, period, chunk.Key, data, signature); err != nil {
+		return nil, err
 	}
 
 	// update our rsrcs entry map
+	// the entry is only known to be the latest update if set by LookupLatest
 	rsrc.lastKey = chunk.Key
+	rsrc.cachedAt = 0
 	rsrc.lastPeriod = period
 	rsrc.version = version
 	rsrc.updated = time.Now()
@@ -664,6 +969,11 @@ func (self *ResourceHandler) updateResourceIndex(rsrc *resource, chunk *Chunk) (
 // retrieve update metadata from chunk data
 // mirrors newUpdateChunk()
 func (self *ResourceHandler) parseUpdate(chunkdata []byte) (*Signature, uint32, uint32, string, []byte, bool, error) {
+	return parseUpdateChunk(chunkdata, self.signer != nil)
+}
+
+// parses update chunk data, which includes a signature if signed is set
+func parseUpdateChunk(chunkdata []byte, signed bool) (*Signature, uint32, uint32, string, []byte, bool, error) {
 	// absolute minimum an update chunk can contain:
 	// 14 = header + one byte of name + one byte of data
 	if len(chunkdata) < 14 {
@@ -674,6 +984,9 @@ func (self *ResourceHandler) parseUpdate(chunkdata []byte) (*Signature, uint32,
 	cursor += 2
 	datalength := binary.LittleEndian.Uint16(chunkdata[cursor : cursor+2])
 	cursor += 2
+	if headerlength <= 8 || int(headerlength)+4 > len(chunkdata) {
+		return nil, 0, 0, "", nil, false, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Invalid headerlength %d for chunk data length %d", headerlength, len(chunkdata)))
+	}
 	var exclsignlength int
 	// we need extra magic if it's a multihash, since we used datalength 0 in header as an indicator of multihash content
 	// retrieve the second varint and set this as the data length
@@ -741,12 +1054,9 @@ func (self *ResourceHandler) parseUpdate(chunkdata []byte) (*Signature, uint32,
 	// omit signatures if we have no validator
 	var signature *Signature
 	cursor += intdatalength
-	if self.signer != nil {
-		sigdata := chunkdata[cursor : cursor+signatureLength]
-		if len(sigdata) > 0 {
-			signature = &Signature{}
-			copy(signature[:], sigdata)
-		}
+	if signed && len(chunkdata) >= cursor+signatureLength {
+		signature = &Signature{}
+		copy(signature[:], chunkdata[cursor:cursor+signatureLength])
 	}
 
 	return signature, period, version, name, data, multihash, nil
@@ -770,6 +1080,31 @@ func (self *ResourceHandler) Update(ctx context.Context, name string, data []byt
 	return self.update(ctx, name, data, false)
 }
 
+// Adds a data update of arbitrary size
+//
+// Data that does not fit in a single update chunk is stored with the DPA,
+// and the update is the multihash of the resulting swarm hash.
+// GetResolvedContent returns the original data for such updates.
+func (self *ResourceHandler) UpdateContent(ctx context.Context, name string, data []byte) (Key, error) {
+	key, err := self.update(ctx, name, data, false)
+	if rerr, ok := err.(*ResourceError); !ok || rerr.Code() != ErrDataOverflow {
+		return key, err
+	}
+	if self.dpa == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before updating large content")
+	}
+	contentKey, wait, err := self.dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		return nil, NewResourceError(ErrIO, fmt.Sprintf("Content store fail: %v", err))
+	}
+	wait()
+	mh, err := multihash.Encode(contentKey, multihash.KECCAK_256)
+	if err != nil {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Multihash encode fail: %v", err))
+	}
+	return self.update(ctx, name, mh, true)
+}
+
 // create and commit an update
 func (self *ResourceHandler) update(ctx context.Context, name string, data []byte, multihash bool) (Key, error) {
 
@@ -790,13 +1125,17 @@ func (self *ResourceHandler) update(ctx context.Context, name string, data []byt
 	}
 
 	// get the cached information
-	nameHash := ens.EnsNode(name)
-	nameHashHex := nameHash.Hex()
-	rsrc := self.getResource(nameHashHex)
+	nameHash := ResourceNameHash(name)
+	rsrc := self.getResource(nameHash.Hex())
 	if rsrc == nil {
 		return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Resource object '%s' not in index", name))
-	} else if !rsrc.isSynced() {
+	}
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	if !rsrc.isSynced() {
 		return nil, NewResourceError(ErrNotSynced, "Resource object not in sync")
+	} else if rsrc.isRevoked() {
+		return nil, NewResourceError(ErrResourceRevoked, fmt.Sprintf("Resource '%s' is revoked", name))
 	}
 
 	// an update can be only one chunk long; data length less header and signature data
@@ -807,11 +1146,11 @@ func (self *ResourceHandler) update(ctx context.Context, name string, data []byt
 	}
 
 	// get our blockheight at this time and the next block of the update period
-	currentblock, err := self.getBlock(ctx, name)
+	currentblock, err := self.getClock(ctx, rsrc)
 	if err != nil {
 		return nil, NewResourceError(ErrIO, fmt.Sprintf("Could not get block height: %v", err))
 	}
-	nextperiod, err := getNextPeriod(rsrc.startBlock, currentblock, rsrc.frequency)
+	nextperiod, err := rsrc.blockToPeriod(currentblock)
 	if err != nil {
 		return nil, err
 	}
@@ -819,7 +1158,7 @@ func (self *ResourceHandler) update(ctx context.Context, name string, data []byt
 	// if we already have an update for this block then increment version
 	// resource object MUST be in sync for version to be correct, but we checked this earlier in the method already
 	var version uint32
-	if self.hasUpdate(nameHashHex, nextperiod) {
+	if rsrc.lastPeriod == nextperiod {
 		version = rsrc.version
 	}
 	version++
@@ -828,31 +1167,9 @@ func (self *ResourceHandler) update(ctx context.Context, name string, data []byt
 	key := self.resourceHash(nextperiod, version, rsrc.nameHash)
 
 	// if we have a signing function, sign the update
-	// \TODO this code should probably be consolidated with corresponding code in NewResource()
-	var signature *Signature
-	if self.signer != nil {
-		// sign the data hash with the key
-		digest := self.keyDataHash(key, data)
-		sig, err := self.signer.Sign(digest)
-		if err != nil {
-			return nil, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Sign fail: %v", err))
-		}
-		signature = &sig
-
-		// get the address of the signer (which also checks that it's a valid signature)
-		addr, err := getAddressFromDataSig(digest, *signature)
-		if err != nil {
-			return nil, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Invalid data/signature: %v", err))
-		}
-		if self.signer != nil {
-			// check if the signer has access to update
-			ok, err := self.checkAccess(name, addr)
-			if err != nil {
-				return nil, NewResourceError(ErrIO, fmt.Sprintf("Access check fail: %v", err))
-			} else if !ok {
-				return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x does not have access to update %s", addr, name))
-			}
-		}
+	signature, err := self.signUpdate(rsrc, nextperiod, key, data)
+	if err != nil {
+		return nil, err
 	}
 
 	// a datalength field set to 0 means the content is a multihash
@@ -863,49 +1180,145 @@ func (self *ResourceHandler) update(ctx context.Context, name string, data []byt
 	chunk := newUpdateChunk(key, signature, nextperiod, version, name, data, datalength)
 
 	// send the chunk
-	self.chunkStore.Put(chunk)
-	timeout := time.NewTimer(self.storeTimeout)
-	select {
-	case <-chunk.dbStoredC:
-		if err := chunk.GetErrored(); err != nil {
-			return nil, NewResourceError(ErrIO, fmt.Sprintf("chunk not stored: %v", err))
-		}
-	case <-timeout.C:
-		return nil, NewResourceError(ErrIO, "chunk store timeout")
+	if err := self.putChunk(chunk); err != nil {
+		return nil, err
 	}
 	log.Trace("resource update", "name", name, "key", key, "currentblock", currentblock, "lastperiod", nextperiod, "version", version, "data", chunk.SData, "multihash", multihash)
 
 	// update our resources map entry and return the new key
+	rsrc.lastKey = key
 	rsrc.lastPeriod = nextperiod
+	rsrc.cachedAt = nextperiod
 	rsrc.version = version
+	rsrc.Multihash = multihash
 	rsrc.data = make([]byte, len(data))
 	copy(rsrc.data, data)
+	self.updateFeed.Send(&ResourceUpdate{
+		Name:      rsrc.name,
+		NameHash:  rsrc.nameHash,
+		Period:    nextperiod,
+		Version:   version,
+		Key:       key,
+		Multihash: multihash,
+	})
 	return key, nil
 }
 
+// signs the update data if a signer is set, and checks that the signer has access to update the resource
+// \TODO this code should probably be consolidated with corresponding code in NewResource()
+func (self *ResourceHandler) signUpdate(rsrc *resource, period uint32, key Key, data []byte) (*Signature, error) {
+	if self.signer == nil {
+		return nil, nil
+	}
+	authperiod, control, err := authPeriod(period, data)
+	if err != nil {
+		return nil, err
+	}
+	// sign the data hash with the key
+	digest := self.keyDataHash(key, data)
+	signature, err := self.signer.Sign(digest)
+	if err != nil {
+		return nil, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Sign fail: %v", err))
+	}
+
+	// get the address of the signer (which also checks that it's a valid signature)
+	addr, err := getAddressFromDataSig(digest, signature)
+	if err != nil {
+		return nil, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Invalid data/signature: %v", err))
+	}
+	// check if the signer has access to update
+	ok, err := self.checkUpdater(rsrc, authperiod, control, addr)
+	if err != nil {
+		return nil, NewResourceError(ErrIO, fmt.Sprintf("Access check fail: %v", err))
+	} else if !ok {
+		return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x does not have access to update %s", addr, rsrc.name))
+	}
+	return &signature, nil
+}
+
+// checks that a retrieved update is signed by an address that has access to update the resource
+func (self *ResourceHandler) checkUpdateSignature(rsrc *resource, period uint32, key Key, data []byte, signature *Signature) error {
+	if signature == nil {
+		return nil
+	}
+	authperiod, control, err := authPeriod(period, data)
+	if err != nil {
+		return err
+	}
+	digest := self.keyDataHash(key, data)
+	addr, err := getAddressFromDataSig(digest, *signature)
+	if err != nil {
+		return NewResourceError(ErrUnauthorized, fmt.Sprintf("Invalid signature: %v", err))
+	}
+	ok, err := self.checkUpdater(rsrc, authperiod, control, addr)
+	if err != nil {
+		return NewResourceError(ErrIO, fmt.Sprintf("Access check fail: %v", err))
+	} else if !ok {
+		return NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x does not have access to update %s", addr, rsrc.name))
+	}
+	return nil
+}
+
+// puts the chunk in the store and waits until it is stored
+func (self *ResourceHandler) putChunk(chunk *Chunk) error {
+	self.chunkStore.Put(chunk)
+	timeout := time.NewTimer(self.storeTimeout)
+	defer timeout.Stop()
+	select {
+	case <-chunk.dbStoredC:
+		if err := chunk.GetErrored(); err != nil {
+			return NewResourceError(ErrIO, fmt.Sprintf("chunk not stored: %v", err))
+		}
+	case <-timeout.C:
+		return NewResourceError(ErrIO, "chunk store timeout")
+	}
+	return nil
+}
+
+// SubscribeUpdates notifies the channel of every update published through this handler
+func (self *ResourceHandler) SubscribeUpdates(ch chan<- *ResourceUpdate) event.Subscription {
+	return self.updateFeed.Subscribe(ch)
+}
+
 // Closes the datastore.
 // Always call this at shutdown to avoid data corruption.
 func (self *ResourceHandler) Close() {
+	if err := self.SaveIndex(); err != nil {
+		log.Error("resource index save fail", "path", self.indexPath, "err", err)
+	}
 	self.chunkStore.Close()
 }
 
 // gets the current block height
 func (self *ResourceHandler) getBlock(ctx context.Context, name string) (uint64, error) {
-	blockheader, err := self.headerGetter.HeaderByNumber(ctx, name, nil)
-	if err != nil {
-		return 0, err
+	return self.headerGetter.BlockNumber(ctx, name)
+}
+
+// returns the current block height, or the current unix time for time based resources
+func (self *ResourceHandler) getClock(ctx context.Context, rsrc *resource) (uint64, error) {
+	if rsrc.timeBased {
+		return uint64(self.timeNow().Unix()), nil
 	}
-	return blockheader.Number.Uint64(), nil
+	return self.getBlock(ctx, rsrc.name)
 }
 
 // Calculate the period index (aka major version number) from a given block number
 func (self *ResourceHandler) BlockToPeriod(name string, blocknumber uint64) (uint32, error) {
-	return getNextPeriod(self.resources[name].startBlock, blocknumber, self.resources[name].frequency)
+	rsrc := self.getResource(name)
+	if rsrc == nil {
+		return 0, NewResourceError(ErrNotFound, "Resource does not exist")
+	}
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	return rsrc.blockToPeriod(blocknumber)
 }
 
 // Calculate the block number from a given period index (aka major version number)
 func (self *ResourceHandler) PeriodToBlock(name string, period uint32) uint64 {
-	return self.resources[name].startBlock + (uint64(period) * self.resources[name].frequency)
+	rsrc := self.getResource(name)
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	return rsrc.periodToBlock(period + 1)
 }
 
 // Retrieves the resource index value for the given nameHash
@@ -928,6 +1341,10 @@ func (self *ResourceHandler) setResource(nameHash string, rsrc *resource) {
 func (self *ResourceHandler) resourceHash(period uint32, version uint32, namehash common.Hash) Key {
 	hasher := self.hashPool.Get().(SwarmHash)
 	defer self.hashPool.Put(hasher)
+	return updateKey(hasher, period, version, namehash)
+}
+
+func updateKey(hasher SwarmHash, period uint32, version uint32, namehash common.Hash) Key {
 	hasher.Reset()
 	b := make([]byte, 4)
 	binary.LittleEndian.PutUint32(b, period)
@@ -938,11 +1355,6 @@ func (self *ResourceHandler) resourceHash(period uint32, version uint32, namehas
 	return hasher.Sum(nil)
 }
 
-// Checks if we already have an update on this resource, according to the value in the current state of the resource index
-func (self *ResourceHandler) hasUpdate(nameHash string, period uint32) bool {
-	return self.resources[nameHash].lastPeriod == period
-}
-
 func getAddressFromDataSig(datahash common.Hash, signature Signature) (common.Address, error) {
 	pub, err := crypto.SigToPub(datahash.Bytes(), signature[:])
 	if err != nil {
@@ -1066,6 +1478,7 @@ func NewTestResourceHandler(datadir string, params *ResourceHandlerParams) (*Res
 		return nil, fmt.Errorf("localstore create fail, path %s: %v", path, err)
 	}
 	localStore.Validators = append(localStore.Validators, NewContentAddressValidator(MakeHashFunc(resourceHash)))
+	localStore.Validators = append(localStore.Validators, NewContentAddressValidator(MakeHashFunc(DefaultHash)))
 	localStore.Validators = append(localStore.Validators, rh)
 	dpaStore := NewNetStore(localStore, nil)
 	rh.SetStore(dpaStore)
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// types of control updates, stored in the first byte of the update data
+const (
+	controlFrequency = iota + 1 // change of the update frequency
+	controlUpdaters             // change of the authorized updaters
+	controlRevoke               // termination of the resource
+)
+
+// length of the header of the control update data: type|index|period
+const controlHeaderLength = 9
+
+// Control updates change the properties of a resource after it was created
+//
+// They are published as signed update chunks with period 0 and the index of the
+// change as version, which are never used by data updates. The data starts with
+// the type of the change, the index and the period in which it was published, so
+// that the signature covers them. Lookups on other nodes pick up the changes when
+// the resource is loaded with LoadResource.
+//
+// Control updates must be signed by the updaters of the resource in the period they
+// are published in, or by the owner of the resource name as reported by the owner
+// validator. The update is applied to the resource once it is stored.
+//
+// The caller must hold the lock of the resource.
+func (self *ResourceHandler) putControlUpdate(ctx context.Context, rsrc *resource, controltype byte, payload []byte) (Key, error) {
+
+	// we can't update anything without a store
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before updating")
+	}
+	if rsrc.isRevoked() {
+		return nil, NewResourceError(ErrResourceRevoked, fmt.Sprintf("Resource '%s' is revoked", rsrc.name))
+	}
+
+	currentblock, err := self.getClock(ctx, rsrc)
+	if err != nil {
+		return nil, NewResourceError(ErrIO, fmt.Sprintf("Could not get block height: %v", err))
+	}
+	period, err := rsrc.blockToPeriod(currentblock)
+	if err != nil {
+		return nil, err
+	}
+	if period < rsrc.controlPeriod {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Period %d precedes the last control update of '%s'", period, rsrc.name))
+	}
+
+	index := rsrc.controlVersion + 1
+	data := newControlData(controltype, index, period, payload)
+	key := self.resourceHash(0, index, rsrc.nameHash)
+	signature, err := self.signUpdate(rsrc, 0, key, data)
+	if err != nil {
+		return nil, err
+	}
+	chunk := newUpdateChunk(key, signature, 0, index, rsrc.name, data, len(data))
+	if err := self.putChunk(chunk); err != nil {
+		return nil, err
+	}
+	log.Debug("resource control update", "name", rsrc.name, "key", key, "type", controltype, "index", index, "period", period)
+	if err := rsrc.applyControlUpdate(index, data); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// returns the data of a control update: type|index|period|payload
+func newControlData(controltype byte, index uint32, period uint32, payload []byte) []byte {
+	data := make([]byte, controlHeaderLength+len(payload))
+	data[0] = controltype
+	binary.LittleEndian.PutUint32(data[1:], index)
+	binary.LittleEndian.PutUint32(data[5:], period)
+	copy(data[controlHeaderLength:], payload)
+	return data
+}
+
+// splits the data of a control update into its header fields and payload
+func parseControlData(data []byte) (byte, uint32, uint32, []byte, error) {
+	if len(data) < controlHeaderLength {
+		return 0, 0, 0, nil, NewResourceError(ErrCorruptData, "Control update too short")
+	}
+	period := binary.LittleEndian.Uint32(data[5:])
+	if period == 0 {
+		return 0, 0, 0, nil, NewResourceError(ErrCorruptData, "Control update without period")
+	}
+	return data[0], binary.LittleEndian.Uint32(data[1:]), period, data[controlHeaderLength:], nil
+}
+
+// returns the period whose updaters authorize an update, and whether it is a control update
+//
+// Update chunks have period 0 only for control updates, which are authorized in the
+// period stored in their data.
+func authPeriod(period uint32, data []byte) (uint32, bool, error) {
+	if period != 0 {
+		return period, false, nil
+	}
+	_, _, period, _, err := parseControlData(data)
+	if err != nil {
+		return 0, false, err
+	}
+	return period, true, nil
+}
+
+// retrieves the control updates of the resource that are not yet in the index
+func (self *ResourceHandler) loadControlUpdates(ctx context.Context, rsrc *resource) error {
+	for index := rsrc.controlVersion + 1; ; index++ {
+		key := self.resourceHash(0, index, rsrc.nameHash)
+		chunk, err := self.chunkStore.get(ctx, key, defaultRetrieveTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return NewResourceError(ErrNotFound, fmt.Sprintf("Control update lookup aborted: %v", ctx.Err()))
+			}
+			return nil
+		}
+		signature, period, version, name, data, _, err := self.parseUpdate(chunk.SData)
+		if err != nil {
+			return err
+		}
+		if period != 0 || version != index || name != rsrc.name {
+			return NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid control update %d of '%s'", index, rsrc.name))
+		}
+		if err := self.checkUpdateSignature(rsrc, 0, key, data, signature); err != nil {
+			return err
+		}
+		if err := rsrc.applyControlUpdate(index, data); err != nil {
+			return err
+		}
+	}
+}
+
+// applies the data of the control update with the given index to the resource
+//
+// The index and period in the data must follow the previous control update, so
+// that a signed control update can't be replayed at another position.
+func (self *resource) applyControlUpdate(index uint32, data []byte) error {
+	controltype, dataindex, period, payload, err := parseControlData(data)
+	if err != nil {
+		return err
+	}
+	if index != self.controlVersion+1 || dataindex != index {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Control update %d of '%s' has index %d", index, self.name, dataindex))
+	} else if period < self.controlPeriod {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Control update %d of '%s' precedes the previous one", index, self.name))
+	}
+	switch controltype {
+	case controlFrequency:
+		err = self.applyFrequencyUpdate(payload)
+	case controlUpdaters:
+		err = self.applyUpdatersUpdate(period, payload)
+	case controlRevoke:
+		self.revoke()
+	default:
+		err = NewResourceError(ErrCorruptData, fmt.Sprintf("Unknown control update type %d", controltype))
+	}
+	if err != nil {
+		return err
+	}
+	self.controlVersion = index
+	self.controlPeriod = period
+	return nil
+}
+
+// Terminates a resource by publishing a tombstone control update
+//
+// Lookups of a revoked resource fail with ErrResourceRevoked, and no further
+// updates are accepted for it. Other nodes pick up the revocation when the
+// resource is loaded, or on the next lookup with refresh set.
+func (self *ResourceHandler) Revoke(ctx context.Context, name string) (Key, error) {
+	rsrc, err := self.lockResource(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rsrc.lock.Unlock()
+	key, err := self.putControlUpdate(ctx, rsrc, controlRevoke, nil)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("resource revoked", "name", name, "key", key)
+	return key, nil
+}
+
+func (self *resource) revoke() {
+	self.authLock.Lock()
+	defer self.authLock.Unlock()
+	self.revoked = true
+}
+
+func (self *resource) isRevoked() bool {
+	self.authLock.RLock()
+	defer self.authLock.RUnlock()
+	return self.revoked
+}
+
+// returns the index entry of the resource with its lock held
+func (self *ResourceHandler) lockResource(name string) (*resource, error) {
+	rsrc := self.getResource(ResourceNameHash(name).Hex())
+	if rsrc == nil {
+		return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Resource object '%s' not in index", name))
+	}
+	rsrc.lock.Lock()
+	return rsrc, nil
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/contracts/ens"
+)
+
+// ENSOwnerValidator validates resource updaters against ENS
+//
+// An address may update a resource if it owns the ENS node of the
+// resource name, or if the owner approved it by setting it as the
+// addr record of the name in its resolver.
+type ENSOwnerValidator struct {
+	*ens.ENS
+}
+
+// NewENSOwnerValidator creates an ENSOwnerValidator using the ENS registry
+// at contractAddr on the given chain backend
+func NewENSOwnerValidator(contractAddr common.Address, backend bind.ContractBackend) (*ENSOwnerValidator, error) {
+	client, err := ens.NewENS(&bind.TransactOpts{}, contractAddr, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &ENSOwnerValidator{client}, nil
+}
+
+// OwnerOf returns the owner of the ENS node of the name
+func (self *ENSOwnerValidator) OwnerOf(name string) (common.Address, error) {
+	return self.Owner(ens.EnsNode(name))
+}
+
+// ValidateOwner implements the ownerValidator interface
+func (self *ENSOwnerValidator) ValidateOwner(name string, address common.Address) (bool, error) {
+	owner, err := self.OwnerOf(name)
+	if err != nil {
+		return false, err
+	}
+	if owner == address {
+		return true, nil
+	}
+	resolver, err := self.Resolver(ens.EnsNode(name))
+	if err != nil {
+		return false, err
+	}
+	// names without a resolver have no approved address
+	if resolver == (common.Address{}) {
+		return false, nil
+	}
+	approved, err := self.Addr(name)
+	if err != nil {
+		return false, err
+	}
+	return approved == address && approved != (common.Address{}), nil
+}
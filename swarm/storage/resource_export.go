@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// exported state of a resource index entry
+type resourceExport struct {
+	Name           string           `json:"name"`
+	StartBlock     uint64           `json:"startBlock"`
+	Frequency      uint64           `json:"frequency"`
+	TimeBased      bool             `json:"timeBased,omitempty"`
+	Updaters       []common.Address `json:"updaters,omitempty"`
+	Epochs         []epochExport    `json:"epochs,omitempty"`
+	UpdaterChanges []updatersExport `json:"updaterChanges,omitempty"`
+	ControlVersion uint32           `json:"controlVersion,omitempty"`
+	ControlPeriod  uint32           `json:"controlPeriod,omitempty"`
+	Revoked        bool             `json:"revoked,omitempty"`
+	LastPeriod     uint32           `json:"lastPeriod"`
+	Version        uint32           `json:"version"`
+	LastKey        Key              `json:"lastKey,omitempty"`
+}
+
+type epochExport struct {
+	StartBlock uint64 `json:"startBlock"`
+	Frequency  uint64 `json:"frequency"`
+}
+
+type updatersExport struct {
+	StartPeriod uint32           `json:"startPeriod"`
+	Addresses   []common.Address `json:"addresses"`
+}
+
+// Writes the metadata of all resources in the index to out, one JSON object per line
+//
+// The update data is not exported, it is retrieved again by the first lookup
+// after the import.
+func (self *ResourceHandler) ExportResources(out io.Writer) (int, error) {
+	self.resourceLock.RLock()
+	rsrcs := make([]*resource, 0, len(self.resources))
+	for _, rsrc := range self.resources {
+		rsrcs = append(rsrcs, rsrc)
+	}
+	self.resourceLock.RUnlock()
+
+	enc := json.NewEncoder(out)
+	for i, rsrc := range rsrcs {
+		if err := enc.Encode(rsrc.export()); err != nil {
+			return i, err
+		}
+	}
+	return len(rsrcs), nil
+}
+
+func (self *resource) export() *resourceExport {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.authLock.RLock()
+	defer self.authLock.RUnlock()
+	exp := &resourceExport{
+		Name:           self.name,
+		StartBlock:     self.startBlock,
+		Frequency:      self.frequency,
+		TimeBased:      self.timeBased,
+		Updaters:       self.updaters,
+		ControlVersion: self.controlVersion,
+		ControlPeriod:  self.controlPeriod,
+		Revoked:        self.revoked,
+		LastPeriod:     self.lastPeriod,
+		Version:        self.version,
+		LastKey:        self.lastKey,
+	}
+	for _, epoch := range self.epochs {
+		exp.Epochs = append(exp.Epochs, epochExport{
+			StartBlock: epoch.startBlock,
+			Frequency:  epoch.frequency,
+		})
+	}
+	for _, change := range self.updaterChanges {
+		exp.UpdaterChanges = append(exp.UpdaterChanges, updatersExport{
+			StartPeriod: change.startPeriod,
+			Addresses:   change.addresses,
+		})
+	}
+	return exp
+}
+
+// Reads resources written by ExportResources and adds them to the index
+//
+// Resources that are already in the index are left unchanged. The imported resources
+// are not synced, so they must be looked up before they can be updated.
+func (self *ResourceHandler) ImportResources(in io.Reader) (int, error) {
+	dec := json.NewDecoder(in)
+	var count int
+	for {
+		var exp resourceExport
+		if err := dec.Decode(&exp); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid resource export: %v", err))
+		}
+		rsrc, err := exp.resource()
+		if err != nil {
+			return count, err
+		}
+		if self.getResource(rsrc.nameHash.Hex()) != nil {
+			log.Debug("resource import skipped, already in index", "name", rsrc.name)
+			continue
+		}
+		self.setResource(rsrc.nameHash.Hex(), rsrc)
+		log.Trace("resource import", "name", rsrc.name, "namehash", rsrc.nameHash, "period", rsrc.lastPeriod, "version", rsrc.version)
+		count++
+	}
+}
+
+func (self *resourceExport) resource() (*resource, error) {
+	if !isResourceName(self.Name) {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid name: '%s'", self.Name))
+	} else if self.Frequency == 0 {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid frequency of '%s'", self.Name))
+	}
+	rsrc := &resource{
+		name:           self.Name,
+		nameHash:       ResourceNameHash(self.Name),
+		startBlock:     self.StartBlock,
+		frequency:      self.Frequency,
+		timeBased:      self.TimeBased,
+		updaters:       self.Updaters,
+		controlVersion: self.ControlVersion,
+		controlPeriod:  self.ControlPeriod,
+		revoked:        self.Revoked,
+		lastPeriod:     self.LastPeriod,
+		version:        self.Version,
+		lastKey:        self.LastKey,
+	}
+	// the epoch periods are recalculated, which also checks the order of the changes
+	for _, epoch := range self.Epochs {
+		e, err := rsrc.newEpoch(epoch.StartBlock, epoch.Frequency)
+		if err != nil {
+			return nil, err
+		}
+		rsrc.epochs = append(rsrc.epochs, e)
+	}
+	for _, change := range self.UpdaterChanges {
+		rsrc.updaterChanges = append(rsrc.updaterChanges, resourceUpdaters{
+			startPeriod: change.StartPeriod,
+			addresses:   change.Addresses,
+		})
+	}
+	return rsrc, nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// length of the data of a frequency update chunk: block|frequency
+const frequencyUpdateDataLength = 16
+
+// A resource epoch is a range of blocks in which the resource
+// is updated with the same frequency
+//
+// The period numbering continues across epochs. The first period of an epoch
+// starts at the epoch start block, even if the last period of the previous
+// epoch is shorter than its frequency.
+type resourceEpoch struct {
+	startBlock  uint64
+	frequency   uint64
+	startPeriod uint32
+}
+
+// returns the epoch the given block belongs to
+func (self *resource) epoch(block uint64) resourceEpoch {
+	e := resourceEpoch{
+		startBlock:  self.startBlock,
+		frequency:   self.frequency,
+		startPeriod: 1,
+	}
+	for _, next := range self.epochs {
+		if block < next.startBlock {
+			break
+		}
+		e = next
+	}
+	return e
+}
+
+// calculates the period of the given block, taking frequency changes into account
+func (self *resource) blockToPeriod(block uint64) (uint32, error) {
+	e := self.epoch(block)
+	period, err := getNextPeriod(e.startBlock, block, e.frequency)
+	if err != nil {
+		return 0, err
+	}
+	return e.startPeriod + period - 1, nil
+}
+
+// calculates the first block of the given period, taking frequency changes into account
+func (self *resource) periodToBlock(period uint32) uint64 {
+	e := resourceEpoch{
+		startBlock:  self.startBlock,
+		frequency:   self.frequency,
+		startPeriod: 1,
+	}
+	for _, next := range self.epochs {
+		if period < next.startPeriod {
+			break
+		}
+		e = next
+	}
+	return e.startBlock + uint64(period-e.startPeriod)*e.frequency
+}
+
+// checks a frequency change taking effect from the given block
+// and returns the epoch it starts
+func (self *resource) newEpoch(block uint64, frequency uint64) (resourceEpoch, error) {
+	if frequency == 0 {
+		return resourceEpoch{}, NewResourceError(ErrInvalidValue, "Frequency cannot be 0")
+	}
+	last := self.startBlock
+	if len(self.epochs) > 0 {
+		last = self.epochs[len(self.epochs)-1].startBlock
+	}
+	if block <= last {
+		return resourceEpoch{}, NewResourceError(ErrInvalidValue, fmt.Sprintf("Frequency change at block %d does not follow the previous change", block))
+	}
+	period, err := self.blockToPeriod(block - 1)
+	if err != nil {
+		return resourceEpoch{}, err
+	}
+	return resourceEpoch{
+		startBlock:  block,
+		frequency:   frequency,
+		startPeriod: period + 1,
+	}, nil
+}
+
+// Changes the update frequency of a resource from the given block on
+//
+// The change is published as a control update. The block must be in the future,
+// so that the periods of existing updates do not change. For time based resources
+// the block is a unix time and the frequency is in seconds.
+func (self *ResourceHandler) UpdateFrequency(ctx context.Context, name string, frequency uint64, block uint64) (Key, error) {
+	rsrc, err := self.lockResource(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rsrc.lock.Unlock()
+
+	currentblock, err := self.getClock(ctx, rsrc)
+	if err != nil {
+		return nil, NewResourceError(ErrIO, fmt.Sprintf("Could not get block height: %v", err))
+	}
+	if block <= currentblock {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Frequency change at block %d must be after current block %d", block, currentblock))
+	}
+
+	epoch, err := rsrc.newEpoch(block, frequency)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, frequencyUpdateDataLength)
+	binary.LittleEndian.PutUint64(data, block)
+	binary.LittleEndian.PutUint64(data[8:], frequency)
+	key, err := self.putControlUpdate(ctx, rsrc, controlFrequency, data)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("resource frequency update", "name", name, "key", key, "block", block, "frequency", frequency, "period", epoch.startPeriod)
+	return key, nil
+}
+
+// applies a frequency change retrieved from a control update
+func (self *resource) applyFrequencyUpdate(data []byte) error {
+	if len(data) != frequencyUpdateDataLength {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid frequency update of '%s'", self.name))
+	}
+	epoch, err := self.newEpoch(binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:]))
+	if err != nil {
+		return err
+	}
+	self.epochs = append(self.epochs, epoch)
+	return nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ResourceIndexFileName is the name of the file the resource index is persisted to
+const ResourceIndexFileName = "resources.json"
+
+// Loads the resource index persisted by SaveIndex
+//
+// The loaded resources are not synced, the first lookup retrieves their latest
+// updates. A missing index file is not an error.
+func (self *ResourceHandler) loadIndex() error {
+	f, err := os.Open(self.indexPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	count, err := self.ImportResources(f)
+	if err != nil {
+		return err
+	}
+	log.Debug("resource index loaded", "path", self.indexPath, "resources", count)
+	return nil
+}
+
+// SaveIndex writes the resource index to the index path of the handler
+//
+// The file is replaced atomically, so an interrupted save leaves the previous
+// index intact. It is a no-op if the handler was created without an index path.
+func (self *ResourceHandler) SaveIndex() error {
+	if self.indexPath == "" {
+		return nil
+	}
+	self.indexLock.Lock()
+	defer self.indexLock.Unlock()
+	f, err := ioutil.TempFile(filepath.Dir(self.indexPath), ResourceIndexFileName)
+	if err != nil {
+		return err
+	}
+	count, err := self.ExportResources(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), self.indexPath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	log.Debug("resource index saved", "path", self.indexPath, "resources", count)
+	return nil
+}
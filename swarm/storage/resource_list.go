@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ResourceListEntry describes a resource tracked by the resource handler
+type ResourceListEntry struct {
+	Name       string           `json:"name"`
+	NameHash   common.Hash      `json:"nameHash"`
+	Owner      common.Address   `json:"owner"` // zero if the owner validator can't tell the owner
+	Updaters   []common.Address `json:"updaters,omitempty"`
+	StartBlock uint64           `json:"startBlock"`
+	Frequency  uint64           `json:"frequency"`
+	TimeBased  bool             `json:"timeBased,omitempty"`
+	LastPeriod uint32           `json:"lastPeriod"`
+	LastBlock  uint64           `json:"lastBlock"` // first block of the period of the latest known update
+	Version    uint32           `json:"version"`
+	Synced     bool             `json:"synced"`
+	Revoked    bool             `json:"revoked,omitempty"`
+}
+
+// Returns all resources in the resource index, sorted by name
+//
+// The entries reflect the index, the resources are not looked up.
+func (self *ResourceHandler) List() []*ResourceListEntry {
+	self.resourceLock.RLock()
+	rsrcs := make([]*resource, 0, len(self.resources))
+	for _, rsrc := range self.resources {
+		rsrcs = append(rsrcs, rsrc)
+	}
+	self.resourceLock.RUnlock()
+
+	getter, _ := self.ownerValidator.(ownerGetter)
+	entries := make([]*ResourceListEntry, 0, len(rsrcs))
+	for _, rsrc := range rsrcs {
+		entry := rsrc.listEntry()
+		if owner, ok := topicOwner(entry.Name); ok {
+			entry.Owner = owner
+		} else if getter != nil {
+			owner, err := getter.OwnerOf(entry.Name)
+			if err != nil {
+				log.Debug("resource owner lookup fail", "name", entry.Name, "err", err)
+			}
+			entry.Owner = owner
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+func (self *resource) listEntry() *ResourceListEntry {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	entry := &ResourceListEntry{
+		Name:       self.name,
+		NameHash:   self.nameHash,
+		Updaters:   self.Updaters(),
+		StartBlock: self.startBlock,
+		Frequency:  self.Frequency(),
+		TimeBased:  self.timeBased,
+		LastPeriod: self.lastPeriod,
+		Version:    self.version,
+		Synced:     self.isSynced(),
+		Revoked:    self.isRevoked(),
+	}
+	if self.lastPeriod > 0 {
+		entry.LastBlock = self.periodToBlock(self.lastPeriod)
+	}
+	return entry
+}
@@ -0,0 +1,97 @@
+package storage
+
+// ResourceLookupStrategy selects the periods a resource lookup checks for updates
+type ResourceLookupStrategy interface {
+	// Search starts a search for the latest period with an update,
+	// at or before the given period
+	Search(period uint32) ResourcePeriodSearch
+}
+
+// ResourcePeriodSearch is the state of a single resource lookup
+type ResourcePeriodSearch interface {
+	// Next returns the next period to check, or false if the search is done.
+	// found reports whether an update was found in the period returned by
+	// the previous call, and is false on the first call.
+	//
+	// The lookup result is the latest period in which an update was found.
+	Next(found bool) (uint32, bool)
+}
+
+// LinearResourceLookup checks periods one by one backwards from the latest possible period
+// until an update is found
+//
+// The number of periods checked grows with the time since the last update,
+// so it should be bounded with ResourceLookupParams.Max for resources that may be abandoned.
+type LinearResourceLookup struct{}
+
+func (LinearResourceLookup) Search(period uint32) ResourcePeriodSearch {
+	return &linearSearch{
+		period: period + 1,
+	}
+}
+
+type linearSearch struct {
+	period uint32
+}
+
+func (self *linearSearch) Next(found bool) (uint32, bool) {
+	if found || self.period <= 1 {
+		return 0, false
+	}
+	self.period--
+	return self.period, true
+}
+
+// ExponentialResourceLookup checks periods backwards from the latest possible period at
+// doubling distances until an update is found, and then binary searches the latest update
+// between it and the period checked before it
+//
+// It assumes that a resource is updated in every period up to its latest update, and finds
+// that update with O(log n) checks for n periods since it. Updates in the periods skipped
+// by the doubling and the binary search are not checked, so the latest of sparse updates
+// can be missed, in which case an earlier update or none at all is found.
+type ExponentialResourceLookup struct{}
+
+func (ExponentialResourceLookup) Search(period uint32) ResourcePeriodSearch {
+	return &exponentialSearch{
+		top:  period,
+		miss: period + 1,
+	}
+}
+
+type exponentialSearch struct {
+	top    uint32 // latest possible period
+	last   uint32 // period returned by the previous call
+	dist   uint32 // distance from the latest possible period of the next doubling check
+	hit    uint32 // latest period with an update found
+	miss   uint32 // earliest period above hit checked without an update
+	bisect bool   // an update was found, binary searching between hit and miss
+}
+
+func (self *exponentialSearch) Next(found bool) (uint32, bool) {
+	if self.last != 0 {
+		if found {
+			self.hit = self.last
+			self.bisect = true
+		} else {
+			self.miss = self.last
+		}
+	}
+	if !self.bisect {
+		if self.miss == 1 {
+			// the first period was checked, there are no updates
+			return 0, false
+		}
+		self.last = 1
+		if self.dist < self.top {
+			self.last = self.top - self.dist
+		}
+		self.dist = self.dist*2 + 1
+		return self.last, true
+	}
+	if self.miss-self.hit <= 1 {
+		return 0, false
+	}
+	self.last = self.hit + (self.miss-self.hit)/2
+	return self.last, true
+}
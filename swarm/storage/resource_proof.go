@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ResourceProof contains the chunks needed to verify a resource update
+// without access to a swarm store
+//
+// The metadata and control updates provide the inputs of the period calculation
+// and the authorized updaters, the update chunk carries the data and its signature.
+type ResourceProof struct {
+	Metadata hexutil.Bytes   `json:"metadata"` // data of the metadata chunk
+	Controls []hexutil.Bytes `json:"controls"` // data of the control update chunks, in order
+	Update   hexutil.Bytes   `json:"update"`   // data of the update chunk
+}
+
+// VerifiedResourceUpdate is the content of a resource update proven by a ResourceProof
+type VerifiedResourceUpdate struct {
+	MetadataKey Key // content address of the metadata chunk, as referenced by ENS
+	Name        string
+	Period      uint32
+	Version     uint32
+	Block       uint64 // first block (or unix time) of the period of the update
+	Data        []byte
+	Multihash   bool
+	Signer      common.Address
+
+	// Signers that are only authorized if they own the name of the resource.
+	// Proofs can't include ENS state, so the verifier must check these against
+	// the owner of the name. Always empty for topic resources, whose owner is
+	// part of the name.
+	OwnerSigners []common.Address
+}
+
+// Returns a proof of the update currently loaded in the resource index
+//
+// The proof can be verified with VerifyResourceUpdate. Proofs are only available for
+// signed resources.
+func (self *ResourceHandler) Proof(ctx context.Context, nameHash common.Hash) (*ResourceProof, error) {
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before creating proofs")
+	} else if self.signer == nil {
+		return nil, NewResourceError(ErrInit, "Proofs require signed resource updates")
+	}
+	rsrc := self.getResource(nameHash.Hex())
+	if rsrc == nil {
+		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
+	}
+	rsrc.lock.Lock()
+	defer rsrc.lock.Unlock()
+	if !rsrc.isSynced() || rsrc.lastKey == nil {
+		return nil, NewResourceError(ErrNotSynced, "Proof requires synced resource")
+	}
+
+	proof := &ResourceProof{
+		Metadata: self.newMetaChunk(rsrc).SData,
+	}
+	for index := uint32(1); index <= rsrc.controlVersion; index++ {
+		chunk, err := self.chunkStore.get(ctx, self.resourceHash(0, index, nameHash), defaultRetrieveTimeout)
+		if err != nil {
+			return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Control update %d not found: %v", index, err))
+		}
+		proof.Controls = append(proof.Controls, chunk.SData)
+	}
+	chunk, err := self.chunkStore.get(ctx, rsrc.lastKey, defaultRetrieveTimeout)
+	if err != nil {
+		return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Update not found: %v", err))
+	}
+	proof.Update = chunk.SData
+	return proof, nil
+}
+
+// Verifies a proof of an update of the resource with the given name and returns the proven update
+//
+// It checks the chunk keys and signatures, that the signers are authorized by the
+// updaters of the resource, and calculates the block of the update period taking
+// frequency changes into account. It does not prove that the update is the latest one.
+func VerifyResourceUpdate(name string, proof *ResourceProof) (*VerifiedResourceUpdate, error) {
+	hasher := MakeHashFunc(resourceHash)()
+
+	// the resource metadata
+	if len(proof.Metadata) <= metadataChunkOffsetSize || !bytes.Equal(proof.Metadata[:2], []byte{0, 0}) {
+		return nil, NewResourceError(ErrCorruptData, "Invalid metadata chunk")
+	}
+	rsrc := &resource{}
+	if err := rsrc.UnmarshalBinary(proof.Metadata[2:]); err != nil {
+		return nil, err
+	}
+	if rsrc.name != name {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Proof is for '%s', not '%s'", rsrc.name, name))
+	}
+	rsrc.nameHash = ResourceNameHash(rsrc.name)
+	hasher.Reset()
+	hasher.Write(proof.Metadata)
+	result := &VerifiedResourceUpdate{
+		MetadataKey: hasher.Sum(nil),
+		Name:        rsrc.name,
+	}
+
+	// checks the key and signature of an update chunk and returns its contents
+	verify := func(chunkdata []byte) (uint32, uint32, []byte, bool, common.Address, error) {
+		signature, period, version, updatename, data, multihash, err := parseUpdateChunk(chunkdata, true)
+		if err != nil {
+			return 0, 0, nil, false, common.Address{}, err
+		} else if updatename != rsrc.name {
+			return 0, 0, nil, false, common.Address{}, NewResourceError(ErrCorruptData, fmt.Sprintf("Update belongs to '%s', but have '%s'", updatename, rsrc.name))
+		} else if signature == nil {
+			return 0, 0, nil, false, common.Address{}, NewResourceError(ErrInvalidSignature, "Update is not signed")
+		}
+		authperiod, control, err := authPeriod(period, data)
+		if err != nil {
+			return 0, 0, nil, false, common.Address{}, err
+		}
+		key := updateKey(hasher, period, version, rsrc.nameHash)
+		addr, err := getAddressFromDataSig(updateDigest(hasher, key, data), *signature)
+		if err != nil {
+			return 0, 0, nil, false, common.Address{}, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Invalid signature: %v", err))
+		}
+		updaters := rsrc.updatersAt(authperiod)
+		for _, updater := range updaters {
+			if updater == addr {
+				return period, version, data, multihash, addr, nil
+			}
+		}
+		owner, isTopic := topicOwner(rsrc.name)
+		if (len(updaters) > 0 && !control) || (isTopic && addr != owner) {
+			return 0, 0, nil, false, common.Address{}, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x does not have access to update %s", addr, rsrc.name))
+		}
+		if !isTopic {
+			result.addOwnerSigner(addr)
+		}
+		return period, version, data, multihash, addr, nil
+	}
+
+	// the control updates changing the period calculation and the updaters
+	for i, chunkdata := range proof.Controls {
+		period, version, data, _, _, err := verify(chunkdata)
+		if err != nil {
+			return nil, err
+		}
+		if period != 0 || version != uint32(i+1) {
+			return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid control update %d of '%s'", i+1, rsrc.name))
+		}
+		if err := rsrc.applyControlUpdate(version, data); err != nil {
+			return nil, err
+		}
+	}
+	if rsrc.isRevoked() {
+		return nil, NewResourceError(ErrResourceRevoked, fmt.Sprintf("Resource '%s' is revoked", rsrc.name))
+	}
+
+	// the update itself
+	period, version, data, multihash, signer, err := verify(proof.Update)
+	if err != nil {
+		return nil, err
+	} else if period == 0 {
+		return nil, NewResourceError(ErrCorruptData, "Update is a control update")
+	}
+	result.Period = period
+	result.Version = version
+	result.Block = rsrc.periodToBlock(period)
+	result.Data = data
+	result.Multihash = multihash
+	result.Signer = signer
+	return result, nil
+}
+
+func (self *VerifiedResourceUpdate) addOwnerSigner(addr common.Address) {
+	for _, signer := range self.OwnerSigners {
+		if signer == addr {
+			return
+		}
+	}
+	self.OwnerSigners = append(self.OwnerSigners, addr)
+}
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"math/big"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -20,7 +21,6 @@ import (
 	"github.com/ethereum/go-ethereum/contracts/ens"
 	"github.com/ethereum/go-ethereum/contracts/ens/contract"
 	"github.com/ethereum/go-ethereum/core"
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/swarm/multihash"
@@ -62,12 +62,9 @@ func (f *fakeBackend) Commit() {
 	f.blocknumber++
 }
 
-func (f *fakeBackend) HeaderByNumber(context context.Context, name string, bigblock *big.Int) (*types.Header, error) {
+func (f *fakeBackend) BlockNumber(context context.Context, name string) (uint64, error) {
 	f.blocknumber++
-	biggie := big.NewInt(f.blocknumber)
-	return &types.Header{
-		Number: biggie,
-	}, nil
+	return uint64(f.blocknumber), nil
 }
 
 // check that signature address matches update signer address
@@ -239,7 +236,7 @@ func TestResourceHandler(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	rsrc2, err := rh2.LoadResource(rootChunkKey)
+	rsrc2, err := rh2.LoadResource(ctx, rootChunkKey)
 	_, err = rh2.LookupLatest(ctx, nameHash, true, nil)
 	if err != nil {
 		t.Fatal(err)
@@ -359,220 +356,1403 @@ func TestResourceENSOwner(t *testing.T) {
 	}
 }
 
-func TestResourceMultihash(t *testing.T) {
+// create and update a resource addressed by public key and topic, with an ENS owner validator
+// that doesn't know the name
+func TestResourceTopic(t *testing.T) {
 
-	// signer containing private key
 	signer, err := newTestSigner()
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// make fake backend, set up rpc and create resourcehandler
-	backend := &fakeBackend{
-		blocknumber: int64(startBlock),
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	transactOpts := bind.NewKeyedTransactor(signer.PrivKey)
+	domainparts := strings.Split(safeName, ".")
+	contractAddr, contractbackend, err := setupENS(addr, transactOpts, domainparts[0], domainparts[1])
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	// set up rpc and create resourcehandler
-	rh, datadir, teardownTest, err := setupTest(backend, nil, nil)
+	ensClient, err := ens.NewENS(transactOpts, contractAddr, contractbackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, _, teardownTest, err := setupTest(contractbackend, ensClient, signer)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer teardownTest()
 
-	// create a new resource
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	_, _, err = rh.NewResource(ctx, safeName, resourceFrequency)
+	topicName, err := NewTopicResourceName(&signer.PrivKey.PublicKey, "weather")
 	if err != nil {
 		t.Fatal(err)
 	}
+	topicHash := crypto.Keccak256Hash(crypto.CompressPubkey(&signer.PrivKey.PublicKey), []byte("weather"))
+	if ResourceNameHash(topicName) != topicHash {
+		t.Fatalf("expected root key %x, got %x", topicHash, ResourceNameHash(topicName))
+	}
+	if ResourceNameHash(safeName) != nameHash {
+		t.Fatalf("expected ENS namehash %x for '%s', got %x", nameHash, safeName, ResourceNameHash(safeName))
+	}
 
-	// we're naïvely assuming keccak256 for swarm hashes
-	// if it ever changes this test should also change
-	swarmhashbytes := ens.EnsNode("foo")
-	swarmhashmulti, err := multihash.Encode(swarmhashbytes.Bytes(), multihash.KECCAK_256)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rsrc, err := rh.NewResource(ctx, topicName, resourceFrequency)
 	if err != nil {
+		t.Fatalf("Create topic resource fail: %v", err)
+	}
+	if rsrc.NameHash() != topicHash {
+		t.Fatalf("expected resource root key %x, got %x", topicHash, rsrc.NameHash())
+	}
+	if _, err := rh.Update(ctx, topicName, []byte("sunny")); err != nil {
+		t.Fatalf("Update topic resource fail: %v", err)
+	}
+	if _, err := rh.LookupLatestByName(ctx, topicName, true, nil); err != nil {
 		t.Fatal(err)
 	}
-	swarmhashkey, err := rh.UpdateMultihash(ctx, safeName, swarmhashmulti)
+	_, data, err := rh.GetContent(topicHash.Hex())
 	if err != nil {
 		t.Fatal(err)
+	} else if !bytes.Equal(data, []byte("sunny")) {
+		t.Fatalf("expected data 'sunny', got '%s'", data)
+	}
+	entries := rh.List()
+	if len(entries) != 1 || entries[0].Owner != addr {
+		t.Fatalf("expected list entry owned by %x, got %v", addr, entries)
 	}
 
-	sha1bytes := make([]byte, multihash.DefaultLengths[multihash.SHA1])
-	sha1multi, err := multihash.Encode(sha1bytes, multihash.SHA1)
+	// another key can neither update the resource nor create one under the topic name
+	signertwo, err := newTestSigner()
 	if err != nil {
 		t.Fatal(err)
 	}
-	sha1key, err := rh.UpdateMultihash(ctx, safeName, sha1multi)
-	if err != nil {
-		t.Fatal(err)
+	rh.signer = signertwo
+	if _, err := rh.Update(ctx, topicName, []byte("rainy")); err == nil {
+		t.Fatal("Expected topic resource update fail due to owner mismatch")
 	}
-
-	// invalid multihashes
-	_, err = rh.UpdateMultihash(ctx, safeName, swarmhashmulti[1:])
-	if err == nil {
-		t.Fatalf("Expected update to fail with first byte skipped")
+	if _, _, err := rh.NewResource(ctx, topicName, resourceFrequency); err == nil {
+		t.Fatal("Expected topic resource creation fail due to owner mismatch")
 	}
-	_, err = rh.UpdateMultihash(ctx, safeName, swarmhashmulti[:len(swarmhashmulti)-2])
-	if err == nil {
-		t.Fatalf("Expected update to fail with last byte skipped")
+	if _, err := NewTopicResourceName(&signertwo.PrivKey.PublicKey, ""); err == nil {
+		t.Fatal("Expected invalid topic to fail")
 	}
+}
 
-	data, err := getUpdateDirect(rh, swarmhashkey)
+// change the frequency of a resource and check that periods are calculated from the new frequency
+func TestResourceFrequencyChange(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	signer, err := newTestSigner()
 	if err != nil {
 		t.Fatal(err)
 	}
-	swarmhashdecode, err := multihash.Decode(data)
+	rh, datadir, teardownTest, err := setupTest(backend, nil, signer)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(swarmhashdecode.Digest, swarmhashbytes.Bytes()) {
-		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", swarmhashdecode.Digest, swarmhashbytes.Bytes())
-	}
-	data, err = getUpdateDirect(rh, sha1key)
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootChunkKey, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
 	if err != nil {
 		t.Fatal(err)
 	}
-	sha1decode, err := multihash.Decode(data)
-	if err != nil {
+
+	// changes must be in the future
+	if _, err := rh.UpdateFrequency(ctx, safeName, resourceFrequency/2, rsrc.startBlock); err == nil {
+		t.Fatal("expected error for frequency change in the past")
+	}
+	// halve the frequency from the middle of the third period
+	changeBlock := rsrc.startBlock + resourceFrequency*2 + resourceFrequency/2
+	if _, err := rh.UpdateFrequency(ctx, safeName, resourceFrequency/2, changeBlock); err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(sha1decode.Digest, sha1bytes) {
-		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", sha1decode.Digest, sha1bytes)
+	if _, err := rh.UpdateFrequency(ctx, safeName, resourceFrequency, changeBlock); err == nil {
+		t.Fatal("expected error for frequency change not following the previous change")
+	}
+
+	checkPeriods := func(rsrc *resource) {
+		for _, c := range []struct {
+			block  uint64
+			period uint32
+		}{
+			{rsrc.startBlock, 1},
+			{changeBlock - 1, 3},
+			{changeBlock, 4},
+			{changeBlock + resourceFrequency/2 - 1, 4},
+			{changeBlock + resourceFrequency/2, 5},
+		} {
+			period, err := rsrc.blockToPeriod(c.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if period != c.period {
+				t.Fatalf("block %d: expected period %d, got %d", c.block, c.period, period)
+			}
+		}
+		if block := rsrc.periodToBlock(5); block != changeBlock+resourceFrequency/2 {
+			t.Fatalf("expected period 5 to start at block %d, got %d", changeBlock+resourceFrequency/2, block)
+		}
 	}
-	rh.Close()
+	checkPeriods(rsrc)
 
-	rhparams := &ResourceHandlerParams{
-		QueryMaxPeriods: &ResourceLookupParams{
-			Limit: false,
-		},
-		Signer:         signer,
-		HeaderGetter:   rh.headerGetter,
-		OwnerValidator: rh.ownerValidator,
+	// update in the second period of the new frequency
+	backend.blocknumber = int64(changeBlock + resourceFrequency/2)
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
 	}
-	// test with signed data
+	if rsrc.lastPeriod != 5 {
+		t.Fatalf("expected update in period 5, got %d", rsrc.lastPeriod)
+	}
+
+	// the frequency change is loaded with the resource
+	rh.Close()
 	rh.chunkStore.localStore.Close()
+	rhparams := &ResourceHandlerParams{
+		Signer:       signer,
+		HeaderGetter: rh.headerGetter,
+	}
 	rh2, err := NewTestResourceHandler(datadir, rhparams)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, _, err = rh2.NewResource(ctx, safeName, resourceFrequency)
+	defer rh2.Close()
+	rsrc2, err := rh2.LoadResource(ctx, rootChunkKey)
 	if err != nil {
 		t.Fatal(err)
 	}
-	swarmhashsignedkey, err := rh2.UpdateMultihash(ctx, safeName, swarmhashmulti)
-	if err != nil {
+	if len(rsrc2.epochs) != 1 || rsrc2.Frequency() != resourceFrequency/2 {
+		t.Fatalf("expected one frequency change to %d, got %v", resourceFrequency/2, rsrc2.epochs)
+	}
+	checkPeriods(rsrc2)
+	if _, err := rh2.LookupLatest(ctx, nameHash, true, nil); err != nil {
 		t.Fatal(err)
 	}
-	sha1signedkey, err := rh2.UpdateMultihash(ctx, safeName, sha1multi)
+	if rsrc2.lastPeriod != 5 || !bytes.Equal(rsrc2.data, []byte("foo")) {
+		t.Fatalf("expected update 'foo' in period 5, got '%s' in period %d", rsrc2.data, rsrc2.lastPeriod)
+	}
+}
+
+// co-managed resource with a rotation of the updaters
+func TestResourceUpdaters(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	var signers []*GenericResourceSigner
+	var addrs []common.Address
+	for i := 0; i < 3; i++ {
+		signer, err := newTestSigner()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+		addrs = append(addrs, crypto.PubkeyToAddress(signer.PrivKey.PublicKey))
+	}
+	rh, datadir, teardownTest, err := setupTest(backend, nil, signers[0])
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer teardownTest()
 
-	data, err = getUpdateDirect(rh2, swarmhashsignedkey)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootChunkKey, rsrc, err := rh.NewResourceWithUpdaters(ctx, safeName, resourceFrequency, addrs[:2])
 	if err != nil {
 		t.Fatal(err)
 	}
-	swarmhashdecode, err = multihash.Decode(data)
-	if err != nil {
+
+	// both updaters can update, others can't
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(swarmhashdecode.Digest, swarmhashbytes.Bytes()) {
-		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", swarmhashdecode.Digest, swarmhashbytes.Bytes())
+	rh.signer = signers[1]
+	if _, err := rh.Update(ctx, safeName, []byte("bar")); err != nil {
+		t.Fatal(err)
 	}
-	data, err = getUpdateDirect(rh2, sha1signedkey)
+	rh.signer = signers[2]
+	if _, err := rh.Update(ctx, safeName, []byte("baz")); err == nil {
+		t.Fatal("expected error for update by unauthorized address")
+	}
+	if _, err := rh.UpdateUpdaters(ctx, safeName, addrs[2:]); err == nil {
+		t.Fatal("expected error for updaters change by unauthorized address")
+	}
+
+	// replace the updaters from the next period on
+	rh.signer = signers[1]
+	if _, err := rh.UpdateUpdaters(ctx, safeName, addrs[2:]); err != nil {
+		t.Fatal(err)
+	}
+	rh.signer = signers[2]
+	if _, err := rh.Update(ctx, safeName, []byte("baz")); err == nil {
+		t.Fatal("expected error for update by new updater in the current period")
+	}
+	if _, err := rh.UpdateUpdaters(ctx, safeName, addrs[:1]); err == nil {
+		t.Fatal("expected error for updaters change by new updater in the current period")
+	}
+
+	// a control update is only valid at the index in its data
+	rh.signer = signers[1]
+	index := rsrc.controlVersion + 1
+	key := rh.resourceHash(0, index, rsrc.nameHash)
+	for _, dataindex := range []uint32{index, index + 1} {
+		data := newControlData(controlRevoke, dataindex, rsrc.controlPeriod, nil)
+		signature, err := rh.signUpdate(rsrc, 0, key, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk := newUpdateChunk(key, signature, 0, index, rsrc.name, data, len(data))
+		if valid := rh.Validate(chunk.Key, chunk.SData); valid != (dataindex == index) {
+			t.Fatalf("expected control update with index %d at %d to be valid: %v, got %v", dataindex, index, dataindex == index, valid)
+		}
+	}
+	if err := rsrc.applyControlUpdate(index, newControlData(controlRevoke, index+1, rsrc.controlPeriod, nil)); err == nil {
+		t.Fatal("expected error for control update with mismatched index")
+	}
+
+	if _, err := rh.Update(ctx, safeName, []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.Update(ctx, safeName, []byte("baz")); err == nil {
+		t.Fatal("expected error for update by replaced updater")
+	}
+	rh.signer = signers[2]
+	if _, err := rh.Update(ctx, safeName, []byte("xyzzy")); err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.lastPeriod != 2 {
+		t.Fatalf("expected update in period 2, got %d", rsrc.lastPeriod)
+	}
+
+	// the updaters are loaded with the resource
+	rh.Close()
+	rh.chunkStore.localStore.Close()
+	rh2, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		Signer:       signers[2],
+		HeaderGetter: rh.headerGetter,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	sha1decode, err = multihash.Decode(data)
+	defer rh2.Close()
+	rsrc2, err := rh2.LoadResource(ctx, rootChunkKey)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(sha1decode.Digest, sha1bytes) {
-		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", sha1decode.Digest, sha1bytes)
+	if len(rsrc2.updaters) != 2 || rsrc2.updaters[0] != addrs[0] || rsrc2.updaters[1] != addrs[1] {
+		t.Fatalf("expected initial updaters %v, got %v", addrs[:2], rsrc2.updaters)
+	}
+	if updaters := rsrc2.Updaters(); len(updaters) != 1 || updaters[0] != addrs[2] {
+		t.Fatalf("expected updaters %v, got %v", addrs[2:], updaters)
+	}
+	if _, err := rh2.LookupLatest(ctx, nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc2.data, []byte("xyzzy")) {
+		t.Fatalf("expected latest update 'xyzzy', got '%s'", rsrc2.data)
+	}
+	if _, err := rh2.LookupVersion(ctx, nameHash, 1, 3, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc2.data, []byte("baz")) {
+		t.Fatalf("expected update 'baz' in period 1, got '%s'", rsrc2.data)
 	}
 }
 
-func TestResourceChunkValidator(t *testing.T) {
-	// signer containing private key
+func TestResourceRevoke(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
 	signer, err := newTestSigner()
 	if err != nil {
 		t.Fatal(err)
 	}
+	rh, datadir, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
 
-	// ens address and transact options
-	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
-	transactOpts := bind.NewKeyedTransactor(signer.PrivKey)
-
-	// set up ENS sim
-	domainparts := strings.Split(safeName, ".")
-	contractAddr, contractbackend, err := setupENS(addr, transactOpts, domainparts[0], domainparts[1])
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootChunkKey, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Revoke(ctx, safeName); err != nil {
+		t.Fatal(err)
+	}
+	isRevoked := func(err error) bool {
+		rerr, ok := err.(*ResourceError)
+		return ok && rerr.Code() == ErrResourceRevoked
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("bar")); !isRevoked(err) {
+		t.Fatalf("expected revoked error on update, got %v", err)
+	}
+	if _, err := rh.Revoke(ctx, safeName); !isRevoked(err) {
+		t.Fatalf("expected revoked error on second revocation, got %v", err)
+	}
+	if _, err := rh.LookupLatest(ctx, nameHash, false, nil); !isRevoked(err) {
+		t.Fatalf("expected revoked error on lookup, got %v", err)
+	}
 
-	ensClient, err := ens.NewENS(transactOpts, contractAddr, contractbackend)
+	// a refreshing lookup picks up the revocation
+	rsrc.revoked = false
+	rsrc.controlVersion = 0
+	if _, err := rh.LookupVersion(ctx, nameHash, 1, 1, true, nil); !isRevoked(err) {
+		t.Fatalf("expected revoked error on refreshed lookup, got %v", err)
+	}
+
+	// the revocation is loaded with the resource
+	rh.Close()
+	rh.chunkStore.localStore.Close()
+	rh2, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		Signer:       signer,
+		HeaderGetter: rh.headerGetter,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer rh2.Close()
+	if _, err := rh2.LoadResource(ctx, rootChunkKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LookupHistorical(ctx, nameHash, 1, true, nil); !isRevoked(err) {
+		t.Fatalf("expected revoked error on lookup after reload, got %v", err)
+	}
+}
 
-	// set up rpc and create resourcehandler with ENS sim backend
-	rh, _, teardownTest, err := setupTest(contractbackend, ensClient, signer)
+// resource with periods measured in seconds
+func TestResourceTimeBased(t *testing.T) {
+
+	// the block number must not be used for time based resources
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, datadir, teardownTest, err := setupTest(backend, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer teardownTest()
+	now := time.Unix(1500000000, 0)
+	clock := func() time.Time {
+		return now
+	}
+	rh.timeNow = clock
 
-	// create new resource when we are owner = ok
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	key, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	updaters := []common.Address{common.HexToAddress("0x2a")}
+	rootChunkKey, rsrc, err := rh.NewResourceWithParams(ctx, safeName, &ResourceParams{
+		Frequency: 60,
+		Updaters:  updaters,
+		TimeBased: true,
+	})
 	if err != nil {
-		t.Fatalf("Create resource fail: %v", err)
+		t.Fatal(err)
+	}
+	if rsrc.startBlock != uint64(now.Unix()) {
+		t.Fatalf("expected start time %d, got %d", now.Unix(), rsrc.startBlock)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(90 * time.Second)
+	fwdBlocks(int(resourceFrequency)*3, backend)
+	if _, err := rh.Update(ctx, safeName, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.lastPeriod != 2 || rsrc.version != 1 {
+		t.Fatalf("expected update in period 2 version 1, got period %d version %d", rsrc.lastPeriod, rsrc.version)
 	}
 
-	data := []byte("foo")
-	key = rh.resourceHash(1, 1, rsrc.nameHash)
-	digest := rh.keyDataHash(key, data)
-	sig, err := rh.signer.Sign(digest)
+	// the mode is loaded with the resource
+	rh.Close()
+	rh.chunkStore.localStore.Close()
+	rh2, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		HeaderGetter: rh.headerGetter,
+	})
 	if err != nil {
-		t.Fatalf("sign fail: %v", err)
+		t.Fatal(err)
 	}
-	chunk := newUpdateChunk(key, &sig, 1, 1, safeName, data, len(data))
-	if !rh.Validate(chunk.Key, chunk.SData) {
-		t.Fatal("Chunk validator fail on update chunk")
+	defer rh2.Close()
+	rh2.timeNow = clock
+	rsrc2, err := rh2.LoadResource(ctx, rootChunkKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsrc2.TimeBased() || rsrc2.Frequency() != 60 || len(rsrc2.updaters) != 1 || rsrc2.updaters[0] != updaters[0] {
+		t.Fatalf("unexpected resource metadata %v", rsrc2)
+	}
+	if _, err := rh2.LookupLatest(ctx, nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rsrc2.lastPeriod != 2 || !bytes.Equal(rsrc2.data, []byte("bar")) {
+		t.Fatalf("expected update 'bar' in period 2, got '%s' in period %d", rsrc2.data, rsrc2.lastPeriod)
 	}
+}
 
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	startBlock, err := rh.getBlock(ctx, safeName)
+// concurrent updates and lookups of different resources
+func TestResourceConcurrency(t *testing.T) {
+
+	// the fake backend is not safe for concurrent use
+	backend := BlockNumberFunc(func(context.Context, string) (uint64, error) {
+		return startBlock, nil
+	})
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	chunk = rh.newMetaChunk(safeName, startBlock, resourceFrequency)
-	if !rh.Validate(chunk.Key, chunk.SData) {
-		t.Fatal("Chunk validator fail on metadata chunk")
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var names []string
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("concurrent%d.eth", i)
+		if _, _, err := rh.NewResource(ctx, name, resourceFrequency); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	updates := 8
+	errC := make(chan error)
+	for _, name := range names {
+		go func(name string) {
+			for i := 0; i < updates; i++ {
+				if _, err := rh.Update(ctx, name, []byte(fmt.Sprintf("%s %d", name, i))); err != nil {
+					errC <- err
+					return
+				}
+			}
+			errC <- nil
+		}(name)
+		go func(name string) {
+			for i := 0; i < updates; i++ {
+				rh.LookupLatestByName(ctx, name, true, nil)
+				rh.GetContent(ens.EnsNode(name).Hex())
+			}
+			errC <- nil
+		}(name)
+	}
+	for i := 0; i < len(names)*2; i++ {
+		if err := <-errC; err != nil {
+			t.Fatal(err)
+		}
 	}
-}
 
-// fast-forward blockheight
-func fwdBlocks(count int, backend *fakeBackend) {
-	for i := 0; i < count; i++ {
-		backend.Commit()
+	for _, name := range names {
+		rsrc, err := rh.LookupLatestByName(ctx, name, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := fmt.Sprintf("%s %d", name, updates-1)
+		if rsrc.version != uint32(updates) || !bytes.Equal(rsrc.data, []byte(expected)) {
+			t.Fatalf("expected '%s' as version %d, got '%s' as version %d", expected, updates, rsrc.data, rsrc.version)
+		}
 	}
 }
 
-type ensOwnerValidator struct {
-	*ens.ENS
+func TestResourceProof(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootChunkKey, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeBlock := rsrc.startBlock + resourceFrequency*2
+	if _, err := rh.UpdateFrequency(ctx, safeName, resourceFrequency/2, changeBlock); err != nil {
+		t.Fatal(err)
+	}
+	backend.blocknumber = int64(changeBlock + resourceFrequency/2)
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := rh.Proof(ctx, nameHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := VerifyResourceUpdate(safeName, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(verified.MetadataKey, rootChunkKey) {
+		t.Fatalf("expected metadata key %v, got %v", rootChunkKey, verified.MetadataKey)
+	}
+	if verified.Name != safeName || verified.Period != 4 || verified.Version != 2 || !bytes.Equal(verified.Data, []byte("bar")) {
+		t.Fatalf("unexpected verified update %+v", verified)
+	}
+	if verified.Block != changeBlock+resourceFrequency/2 {
+		t.Fatalf("expected update period to start at block %d, got %d", changeBlock+resourceFrequency/2, verified.Block)
+	}
+	if verified.Signer != addr || len(verified.OwnerSigners) != 1 || verified.OwnerSigners[0] != addr {
+		t.Fatalf("expected signer %x to be checked against the owner, got %x %v", addr, verified.Signer, verified.OwnerSigners)
+	}
+
+	// tampered data is not signed by the owner
+	proof.Update[len(proof.Update)-signatureLength-1] ^= 0xff
+	if verified, err := VerifyResourceUpdate(safeName, proof); err == nil && verified.Signer == addr {
+		t.Fatal("expected tampered update to fail verification")
+	}
+	proof.Update[len(proof.Update)-signatureLength-1] ^= 0xff
+	if _, err := VerifyResourceUpdate("proof.eth", proof); err == nil {
+		t.Fatal("expected error for proof of other resource")
+	}
+
+	// without the frequency change the period math fails
+	proof.Controls = nil
+	if verified, err := VerifyResourceUpdate(safeName, proof); err != nil {
+		t.Fatal(err)
+	} else if verified.Block == changeBlock+resourceFrequency/2 {
+		t.Fatal("expected different period block without frequency change")
+	}
+
+	// updates of resources with updaters are verified against them
+	otherName := "proof.eth"
+	if _, _, err := rh.NewResourceWithUpdaters(ctx, otherName, resourceFrequency, []common.Address{addr}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, otherName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	proof, err = rh.Proof(ctx, ens.EnsNode(otherName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := VerifyResourceUpdate(otherName, proof); err != nil {
+		t.Fatal(err)
+	} else if len(verified.OwnerSigners) != 0 {
+		t.Fatalf("expected no owner signers, got %v", verified.OwnerSigners)
+	}
+	proof.Update[len(proof.Update)-signatureLength-1] ^= 0xff
+	if _, err := VerifyResourceUpdate(otherName, proof); err == nil {
+		t.Fatal("expected tampered update to fail verification")
+	}
+}
+
+func TestResourceExportImport(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	rh, datadir, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rsrc, err := rh.NewResourceWithUpdaters(ctx, safeName, resourceFrequency, []common.Address{addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeBlock := rsrc.startBlock + resourceFrequency*2
+	if _, err := rh.UpdateFrequency(ctx, safeName, resourceFrequency/2, changeBlock); err != nil {
+		t.Fatal(err)
+	}
+	backend.blocknumber = int64(changeBlock + resourceFrequency/2)
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rh.NewResource(ctx, "other.eth", resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	count, err := rh.ExportResources(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 exported resources, got %d", count)
+	}
+	export := buf.Bytes()
+
+	// existing resources are not overwritten
+	if count, err := rh.ImportResources(bytes.NewReader(export)); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("expected no imported resources, got %d", count)
+	}
+
+	// the resources can be looked up after the import without loading them
+	rh.Close()
+	rh.chunkStore.localStore.Close()
+	rh2, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		Signer:       signer,
+		HeaderGetter: rh.headerGetter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh2.Close()
+	if count, err := rh2.ImportResources(bytes.NewReader(export)); err != nil {
+		t.Fatal(err)
+	} else if count != 2 {
+		t.Fatalf("expected 2 imported resources, got %d", count)
+	}
+	rsrc2, err := rh2.LookupLatest(ctx, nameHash, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc2.lastPeriod != rsrc.lastPeriod || rsrc2.version != 1 || !bytes.Equal(rsrc2.data, []byte("foo")) {
+		t.Fatalf("expected update 'foo' in period %d, got '%s' in period %d", rsrc.lastPeriod, rsrc2.data, rsrc2.lastPeriod)
+	}
+	if rsrc2.Frequency() != resourceFrequency/2 || len(rsrc2.Updaters()) != 1 || rsrc2.Updaters()[0] != addr {
+		t.Fatalf("expected frequency %d and updater %x, got %d and %v", resourceFrequency/2, addr, rsrc2.Frequency(), rsrc2.Updaters())
+	}
+	if _, err := rh2.Update(ctx, safeName, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rh2.ImportResources(strings.NewReader("{\"name\": \"\"}")); err == nil {
+		t.Fatal("expected error for invalid import")
+	}
+}
+
+type testOwnerGetter struct {
+	owner common.Address
+}
+
+func (self *testOwnerGetter) ValidateOwner(name string, address common.Address) (bool, error) {
+	return address == self.owner, nil
+}
+
+func (self *testOwnerGetter) OwnerOf(name string) (common.Address, error) {
+	return self.owner, nil
+}
+
+// the resource index is saved on close and loaded by the next handler with the same index path
+func TestResourceIndexPersistence(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, datadir, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	// reopen the handler of the test with an index path
+	rh.Close()
+	rh.chunkStore.localStore.Close()
+	indexPath := filepath.Join(datadir, ResourceIndexFileName)
+	params := &ResourceHandlerParams{
+		Signer:       signer,
+		HeaderGetter: backend,
+		IndexPath:    indexPath,
+	}
+	rh, err = NewTestResourceHandler(datadir, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(indexPath); err != nil {
+		t.Fatalf("expected index to be saved on resource creation: %v", err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	rh.Close()
+	rh.chunkStore.localStore.Close()
+
+	rh2, err := NewTestResourceHandler(datadir, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh2.Close()
+	entries := rh2.List()
+	if len(entries) != 1 || entries[0].Name != safeName || entries[0].Version != 1 || entries[0].Synced {
+		t.Fatalf("expected unsynced '%s' with version 1 in loaded index, got %v", safeName, entries)
+	}
+	rsrc, err := rh2.LookupLatestByName(ctx, safeName, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(rsrc.data, []byte("foo")) {
+		t.Fatalf("expected data 'foo', got '%s'", rsrc.data)
+	}
+
+	// a corrupt index fails the handler creation
+	rh2.Close()
+	rh2.chunkStore.localStore.Close()
+	if err := ioutil.WriteFile(indexPath, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTestResourceHandler(datadir, params); err == nil {
+		t.Fatal("expected error for corrupt index")
+	}
+}
+
+func TestResourceList(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	rh.ownerValidator = &testOwnerGetter{addr}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, "b.eth", resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	_, rsrc, err := rh.NewResource(ctx, "a.eth", resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, "a.eth", []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, "a.eth", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	list := rh.List()
+	if len(list) != 2 || list[0].Name != "a.eth" || list[1].Name != "b.eth" {
+		t.Fatalf("expected resources a.eth and b.eth, got %v", list)
+	}
+	entry := list[0]
+	if entry.Owner != addr || entry.Frequency != resourceFrequency || entry.StartBlock != rsrc.startBlock {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	if entry.LastPeriod != 1 || entry.LastBlock != rsrc.startBlock || entry.Version != 2 || !entry.Synced {
+		t.Fatalf("expected synced entry with version 2 in period 1, got %+v", entry)
+	}
+	if list[1].LastPeriod != 0 || list[1].LastBlock != 0 {
+		t.Fatalf("expected entry without updates, got %+v", list[1])
+	}
+}
+
+// checks only the latest possible period
+type latestPeriodLookup struct{}
+
+func (latestPeriodLookup) Search(period uint32) ResourcePeriodSearch {
+	return &latestPeriodSearch{period: period}
+}
+
+type latestPeriodSearch struct {
+	period uint32
+	done   bool
+}
+
+func (self *latestPeriodSearch) Next(found bool) (uint32, bool) {
+	if self.done {
+		return 0, false
+	}
+	self.done = true
+	return self.period, true
+}
+
+// bound lookups and use custom lookup strategies
+func TestResourceLookupStrategy(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	// leave the resource silent for a few periods
+	fwdBlocks(int(resourceFrequency*4), backend)
+
+	_, err = rh.LookupLatest(ctx, nameHash, true, &ResourceLookupParams{Limit: true, Max: 2})
+	if rerr, ok := err.(*ResourceError); !ok || rerr.Code() != ErrPeriodDepth {
+		t.Fatalf("expected period depth error, got %v", err)
+	}
+	_, err = rh.LookupLatest(ctx, nameHash, true, &ResourceLookupParams{Strategy: latestPeriodLookup{}})
+	if rerr, ok := err.(*ResourceError); !ok || rerr.Code() != ErrNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, &ResourceLookupParams{Strategy: LinearResourceLookup{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.lastPeriod != 1 || !bytes.Equal(rsrc.data, []byte("foo")) {
+		t.Fatalf("expected update 'foo' in period 1, got '%s' in period %d", rsrc.data, rsrc.lastPeriod)
+	}
+}
+
+// run a lookup strategy against the given set of periods with updates
+// and return the latest period found and the number of periods checked
+func runLookupStrategy(strategy ResourceLookupStrategy, period uint32, updated map[uint32]bool) (uint32, int) {
+	var latest uint32
+	var found bool
+	var checks int
+	search := strategy.Search(period)
+	for {
+		next, ok := search.Next(found)
+		if !ok {
+			return latest, checks
+		}
+		checks++
+		found = updated[next]
+		if found && next > latest {
+			latest = next
+		}
+	}
+}
+
+func TestResourceExponentialLookup(t *testing.T) {
+	// updated every period up to 900, lookup from period 1000
+	updated := make(map[uint32]bool)
+	for i := uint32(1); i <= 900; i++ {
+		updated[i] = true
+	}
+	latest, linearChecks := runLookupStrategy(LinearResourceLookup{}, 1000, updated)
+	if latest != 900 {
+		t.Fatalf("linear: expected period 900, got %d", latest)
+	}
+	latest, exponentialChecks := runLookupStrategy(ExponentialResourceLookup{}, 1000, updated)
+	if latest != 900 {
+		t.Fatalf("exponential: expected period 900, got %d", latest)
+	}
+	if exponentialChecks >= linearChecks || exponentialChecks > 2*10 {
+		t.Fatalf("expected exponential lookup to need fewer checks than linear (%d) and at most %d, got %d", linearChecks, 2*10, exponentialChecks)
+	}
+
+	// any latest update of a resource updated every period is found, with fewer checks
+	// than the linear lookup once it is a few periods old
+	for latest := uint32(1); latest <= 200; latest++ {
+		updated := make(map[uint32]bool)
+		for i := uint32(1); i <= latest; i++ {
+			updated[i] = true
+		}
+		found, exponentialChecks := runLookupStrategy(ExponentialResourceLookup{}, 200, updated)
+		if found != latest {
+			t.Fatalf("updates up to period %d: expected period %d, got %d", latest, latest, found)
+		}
+		_, linearChecks := runLookupStrategy(LinearResourceLookup{}, 200, updated)
+		if 200-latest >= 8 && exponentialChecks >= linearChecks {
+			t.Fatalf("updates up to period %d: expected fewer than %d checks, got %d", latest, linearChecks, exponentialChecks)
+		}
+	}
+
+	// sparse updates may be missed, but only periods with updates are found
+	for _, c := range []struct {
+		updated []uint32
+		latest  uint32
+	}{
+		{[]uint32{999}, 999},
+		{[]uint32{998, 999}, 999},
+		{[]uint32{1}, 1},
+		{[]uint32{995}, 0},
+		{[]uint32{10, 50}, 0},
+	} {
+		updated := make(map[uint32]bool)
+		for _, p := range c.updated {
+			updated[p] = true
+		}
+		latest, _ := runLookupStrategy(ExponentialResourceLookup{}, 1000, updated)
+		if latest != c.latest {
+			t.Fatalf("updates in periods %v: expected period %d, got %d", c.updated, c.latest, latest)
+		}
+	}
+
+	// no updates at all
+	latest, _ = runLookupStrategy(ExponentialResourceLookup{}, 1000, nil)
+	if latest != 0 {
+		t.Fatalf("expected no period found, got %d", latest)
+	}
+	// update in the latest period only
+	latest, checks := runLookupStrategy(ExponentialResourceLookup{}, 1000, map[uint32]bool{1000: true})
+	if latest != 1000 || checks != 1 {
+		t.Fatalf("expected period 1000 found with one check, got %d with %d checks", latest, checks)
+	}
+
+	// the latest version of a period is found with doubling and binary search
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if _, err := rh.Update(ctx, safeName, []byte(fmt.Sprintf("foo%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fwdBlocks(int(resourceFrequency*3), backend)
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, &ResourceLookupParams{Strategy: ExponentialResourceLookup{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.lastPeriod != 1 || rsrc.version != 5 || !bytes.Equal(rsrc.data, []byte("foo5")) {
+		t.Fatalf("expected update 'foo5' period 1 version 5, got '%s' period %d version %d", rsrc.data, rsrc.lastPeriod, rsrc.version)
+	}
+}
+
+// lookups with a cancelled context are aborted instead of checking every period
+func TestResourceLookupContext(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency*3), backend)
+
+	cancelledCtx, cancelLookup := context.WithCancel(context.Background())
+	cancelLookup()
+	if _, err := rh.LookupLatest(cancelledCtx, nameHash, true, nil); err == nil {
+		t.Fatal("expected lookup with a cancelled context to fail")
+	}
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc.data, []byte("foo")) {
+		t.Fatalf("expected update 'foo', got '%s'", rsrc.data)
+	}
+}
+
+// repeated latest lookups within a period are served from the resource index
+func TestResourceLookupCache(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	rsrc, err := rh.LookupLatest(ctx, nameHash, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tamper with the indexed data, the cache is used unless refresh is set
+	rsrc.data = []byte("bar")
+	if rsrc, err = rh.LookupLatest(ctx, nameHash, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc.data, []byte("bar")) {
+		t.Fatalf("expected cached data 'bar', got '%s'", rsrc.data)
+	}
+	if rsrc, err = rh.LookupLatest(ctx, nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc.data, []byte("foo")) {
+		t.Fatalf("expected refreshed data 'foo', got '%s'", rsrc.data)
+	}
+
+	// the cache expires in the next period
+	rsrc.data = []byte("bar")
+	fwdBlocks(int(resourceFrequency), backend)
+	if rsrc, err = rh.LookupLatest(ctx, nameHash, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc.data, []byte("foo")) {
+		t.Fatalf("expected data 'foo' after period change, got '%s'", rsrc.data)
+	}
+}
+
+// update resource with content larger than a chunk, and read it back
+func TestResourceLargeContent(t *testing.T) {
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _, err = rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// small content is stored in the update chunk itself
+	small := []byte("foo")
+	if _, err := rh.UpdateContent(ctx, safeName, small); err != nil {
+		t.Fatal(err)
+	}
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.Multihash {
+		t.Fatal("Expected small content not to be stored as multihash")
+	}
+
+	// large content is stored with the dpa and referenced by multihash
+	fwdBlocks(int(resourceFrequency), backend)
+	large := make([]byte, chunkSize*3+42)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.UpdateContent(ctx, safeName, large); err != nil {
+		t.Fatal(err)
+	}
+	rsrc, err = rh.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsrc.Multihash {
+		t.Fatal("Expected large content to be stored as multihash")
+	}
+	_, content, err := rh.GetResolvedContent(nameHash.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, large) {
+		t.Fatalf("Resolved content mismatch, got %d bytes, expected %d", len(content), len(large))
+	}
+
+	// the content is resolved from the local update without a lookup, and small content
+	// updated locally is not taken for a multihash
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.UpdateContent(ctx, safeName, large); err != nil {
+		t.Fatal(err)
+	}
+	_, content, err = rh.GetResolvedContent(nameHash.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, large) {
+		t.Fatalf("Resolved content mismatch, got %d bytes, expected %d", len(content), len(large))
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.UpdateContent(ctx, safeName, small); err != nil {
+		t.Fatal(err)
+	}
+	_, content, err = rh.GetResolvedContent(nameHash.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, small) {
+		t.Fatalf("Resolved content mismatch, got '%s', expected '%s'", content, small)
+	}
+
+	// referenced content over the size limit is not read
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.UpdateContent(ctx, safeName, large); err != nil {
+		t.Fatal(err)
+	}
+	defer func(size int64) { maxResolvedContentSize = size }(maxResolvedContentSize)
+	maxResolvedContentSize = int64(len(large) - 1)
+	_, _, err = rh.GetResolvedContent(nameHash.Hex())
+	if rerr, ok := err.(*ResourceError); !ok || rerr.Code() != ErrDataOverflow {
+		t.Fatalf("Expected a data overflow error for content over the size limit, got %v", err)
+	}
+
+	// large content cannot be stored without the dpa
+	fwdBlocks(int(resourceFrequency), backend)
+	rh.dpa = nil
+	_, err = rh.UpdateContent(ctx, safeName, large)
+	if rerr, ok := err.(*ResourceError); !ok || rerr.Code() != ErrInit {
+		t.Fatalf("Expected an init error without the dpa, got %v", err)
+	}
+}
+
+func TestResourceMultihash(t *testing.T) {
+
+	// signer containing private key
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+
+	// set up rpc and create resourcehandler
+	rh, datadir, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	// create a new resource
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _, err = rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// we're naïvely assuming keccak256 for swarm hashes
+	// if it ever changes this test should also change
+	swarmhashbytes := ens.EnsNode("foo")
+	swarmhashmulti, err := multihash.Encode(swarmhashbytes.Bytes(), multihash.KECCAK_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmhashkey, err := rh.UpdateMultihash(ctx, safeName, swarmhashmulti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha1bytes := make([]byte, multihash.DefaultLengths[multihash.SHA1])
+	sha1multi, err := multihash.Encode(sha1bytes, multihash.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha1key, err := rh.UpdateMultihash(ctx, safeName, sha1multi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid multihashes
+	_, err = rh.UpdateMultihash(ctx, safeName, swarmhashmulti[1:])
+	if err == nil {
+		t.Fatalf("Expected update to fail with first byte skipped")
+	}
+	_, err = rh.UpdateMultihash(ctx, safeName, swarmhashmulti[:len(swarmhashmulti)-2])
+	if err == nil {
+		t.Fatalf("Expected update to fail with last byte skipped")
+	}
+
+	data, err := getUpdateDirect(rh, swarmhashkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmhashdecode, err := multihash.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(swarmhashdecode.Digest, swarmhashbytes.Bytes()) {
+		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", swarmhashdecode.Digest, swarmhashbytes.Bytes())
+	}
+	data, err = getUpdateDirect(rh, sha1key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha1decode, err := multihash.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sha1decode.Digest, sha1bytes) {
+		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", sha1decode.Digest, sha1bytes)
+	}
+	rh.Close()
+
+	rhparams := &ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{
+			Limit: false,
+		},
+		Signer:         signer,
+		HeaderGetter:   rh.headerGetter,
+		OwnerValidator: rh.ownerValidator,
+	}
+	// test with signed data
+	rh.chunkStore.localStore.Close()
+	rh2, err := NewTestResourceHandler(datadir, rhparams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rh2.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmhashsignedkey, err := rh2.UpdateMultihash(ctx, safeName, swarmhashmulti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha1signedkey, err := rh2.UpdateMultihash(ctx, safeName, sha1multi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = getUpdateDirect(rh2, swarmhashsignedkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmhashdecode, err = multihash.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(swarmhashdecode.Digest, swarmhashbytes.Bytes()) {
+		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", swarmhashdecode.Digest, swarmhashbytes.Bytes())
+	}
+	data, err = getUpdateDirect(rh2, sha1signedkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha1decode, err = multihash.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sha1decode.Digest, sha1bytes) {
+		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", sha1decode.Digest, sha1bytes)
+	}
 }
 
-func (e ensOwnerValidator) ValidateOwner(name string, address common.Address) (bool, error) {
-	addr, err := e.Owner(ens.EnsNode(name))
+func TestResourceChunkValidator(t *testing.T) {
+	// signer containing private key
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ens address and transact options
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	transactOpts := bind.NewKeyedTransactor(signer.PrivKey)
+
+	// set up ENS sim
+	domainparts := strings.Split(safeName, ".")
+	contractAddr, contractbackend, err := setupENS(addr, transactOpts, domainparts[0], domainparts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensClient, err := ens.NewENS(transactOpts, contractAddr, contractbackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// set up rpc and create resourcehandler with ENS sim backend
+	rh, _, teardownTest, err := setupTest(contractbackend, ensClient, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	// create new resource when we are owner = ok
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatalf("Create resource fail: %v", err)
+	}
+
+	data := []byte("foo")
+	key = rh.resourceHash(1, 1, rsrc.nameHash)
+	digest := rh.keyDataHash(key, data)
+	sig, err := rh.signer.Sign(digest)
+	if err != nil {
+		t.Fatalf("sign fail: %v", err)
+	}
+	chunk := newUpdateChunk(key, &sig, 1, 1, safeName, data, len(data))
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("Chunk validator fail on update chunk")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	startBlock, err := rh.getBlock(ctx, safeName)
 	if err != nil {
-		return false, err
+		t.Fatal(err)
+	}
+	chunk = rh.newMetaChunk(&resource{name: safeName, startBlock: startBlock, frequency: resourceFrequency})
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("Chunk validator fail on metadata chunk")
+	}
+}
+
+// fast-forward blockheight
+func fwdBlocks(count int, backend *fakeBackend) {
+	for i := 0; i < count; i++ {
+		backend.Commit()
 	}
-	return address == addr, nil
 }
 
 // create rpc and resourcehandler
@@ -600,7 +1780,7 @@ func setupTest(backend headerGetter, ensBackend *ens.ENS, signer ResourceSigner)
 
 	var ov ownerValidator
 	if ensBackend != nil {
-		ov = ensOwnerValidator{ensBackend}
+		ov = &ENSOwnerValidator{ensBackend}
 	}
 
 	rhparams := &ResourceHandlerParams{
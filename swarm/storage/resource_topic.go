@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/contracts/ens"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Resources can be published without an ENS name by addressing them with the
+// public key of their owner and a topic
+//
+// The name of such a resource is the hex encoded compressed public key and the
+// topic, separated by a slash. Its root key is H(pubkey|topic) instead of the ENS
+// namehash, and the owner is the address of the public key, so no owner validator
+// is needed to authorize its updates.
+const topicNameSeparator = "/"
+
+// NewTopicResourceName returns the name of the resource with the given topic owned by pubkey
+func NewTopicResourceName(pubkey *ecdsa.PublicKey, topic string) (string, error) {
+	if !isSafeName(topic) {
+		return "", NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid topic: '%s'", topic))
+	}
+	return hexutil.Encode(crypto.CompressPubkey(pubkey)) + topicNameSeparator + topic, nil
+}
+
+// ResourceNameHash returns the root key of the resource with the given name
+//
+// Names created with NewTopicResourceName hash to H(pubkey|topic), any other name
+// to its ENS namehash.
+func ResourceNameHash(name string) common.Hash {
+	pubkey, topic, ok := parseTopicName(name)
+	if !ok {
+		return ens.EnsNode(name)
+	}
+	return crypto.Keccak256Hash(pubkey, []byte(topic))
+}
+
+// splits a topic resource name into the compressed public key and the topic
+func parseTopicName(name string) ([]byte, string, bool) {
+	i := strings.Index(name, topicNameSeparator)
+	if i < 0 {
+		return nil, "", false
+	}
+	pubkey, err := hexutil.Decode(name[:i])
+	if err != nil {
+		return nil, "", false
+	}
+	if _, err := crypto.DecompressPubkey(pubkey); err != nil {
+		return nil, "", false
+	}
+	topic := name[i+len(topicNameSeparator):]
+	if !isSafeName(topic) {
+		return nil, "", false
+	}
+	return pubkey, topic, true
+}
+
+// returns the address of the owner of a topic resource
+func topicOwner(name string) (common.Address, bool) {
+	pubkey, _, ok := parseTopicName(name)
+	if !ok {
+		return common.Address{}, false
+	}
+	key, err := crypto.DecompressPubkey(pubkey)
+	if err != nil {
+		return common.Address{}, false
+	}
+	return crypto.PubkeyToAddress(*key), true
+}
+
+// checks that the name is either a valid ENS name or a topic resource name
+func isResourceName(name string) bool {
+	if _, _, ok := parseTopicName(name); ok {
+		return true
+	}
+	return isSafeName(name)
+}
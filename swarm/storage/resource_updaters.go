@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// A set of addresses authorized to update a resource from the given period on
+type resourceUpdaters struct {
+	startPeriod uint32
+	addresses   []common.Address
+}
+
+// returns the addresses authorized to update the resource in the given period
+//
+// Period 0 returns the latest set, including changes that are not yet in effect.
+// An empty set means that updates are authorized by the owner validator.
+func (self *resource) updatersAt(period uint32) []common.Address {
+	self.authLock.RLock()
+	defer self.authLock.RUnlock()
+	addresses := self.updaters
+	for _, next := range self.updaterChanges {
+		if period != 0 && period < next.startPeriod {
+			break
+		}
+		addresses = next.addresses
+	}
+	return addresses
+}
+
+// Updaters returns the addresses currently authorized to update the resource
+func (self *resource) Updaters() []common.Address {
+	return self.updatersAt(0)
+}
+
+// Replaces the addresses authorized to update a resource
+//
+// The change takes effect from the period after the current one, so that the
+// updates already published in the current period remain valid. An empty list
+// hands the authorization back to the owner validator.
+//
+// The change must be signed by one of the current updaters, or by the owner of the
+// resource name, which allows recovering a resource whose updater key is compromised.
+func (self *ResourceHandler) UpdateUpdaters(ctx context.Context, name string, updaters []common.Address) (Key, error) {
+	rsrc, err := self.lockResource(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rsrc.lock.Unlock()
+
+	data := make([]byte, len(updaters)*common.AddressLength)
+	for i, addr := range updaters {
+		copy(data[i*common.AddressLength:], addr[:])
+	}
+	key, err := self.putControlUpdate(ctx, rsrc, controlUpdaters, data)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("resource updaters update", "name", name, "key", key, "period", rsrc.controlPeriod+1, "updaters", len(updaters))
+	return key, nil
+}
+
+// applies a change of updaters published in the given period
+//
+// The change takes effect from the next period, whatever the updaters
+// were in the period it was published in.
+func (self *resource) applyUpdatersUpdate(period uint32, data []byte) error {
+	if len(data)%common.AddressLength != 0 {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid updaters update of '%s'", self.name))
+	}
+	self.authLock.Lock()
+	defer self.authLock.Unlock()
+	if len(self.updaterChanges) > 0 && period+1 < self.updaterChanges[len(self.updaterChanges)-1].startPeriod {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Updaters update of '%s' precedes the previous change", self.name))
+	}
+	self.updaterChanges = append(self.updaterChanges, resourceUpdaters{
+		startPeriod: period + 1,
+		addresses:   decodeUpdaters(data),
+	})
+	return nil
+}
+
+func decodeUpdaters(data []byte) []common.Address {
+	var addresses []common.Address
+	for i := 0; i+common.AddressLength <= len(data); i += common.AddressLength {
+		addresses = append(addresses, common.BytesToAddress(data[i:i+common.AddressLength]))
+	}
+	return addresses
+}
+
+// checks that the address may sign an update of the resource in the given period
+//
+// Control updates are checked against the updaters of the period they were published in.
+func (self *ResourceHandler) checkUpdater(rsrc *resource, period uint32, control bool, address common.Address) (bool, error) {
+	updaters := rsrc.updatersAt(period)
+	for _, addr := range updaters {
+		if addr == address {
+			return true, nil
+		}
+	}
+	// the owner may always change the updaters
+	if len(updaters) > 0 && (!control || !self.hasOwner(rsrc.name)) {
+		return false, nil
+	}
+	return self.checkAccess(rsrc.name, address)
+}
+
+// reports whether the owner of the resource name can be determined
+func (self *ResourceHandler) hasOwner(name string) bool {
+	if _, ok := topicOwner(name); ok {
+		return true
+	}
+	return self.ownerValidator != nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// maximum number of deleted chunks retrieved from the network at the same time
+const scrubRefetchConcurrency = 8
+
+// ScrubResult is the summary of an integrity scan of the local store
+type ScrubResult struct {
+	Checked   int           `json:"checked"`   // chunks checked
+	Corrupted []Key         `json:"corrupted"` // chunks deleted because their data was unreadable or invalid
+	Refetched int           `json:"refetched"` // deleted chunks retrieved again from the network
+	Duration  time.Duration `json:"duration"`  // duration of the scan of the LDBStore
+}
+
+// Scrub reads the data of all chunks in the database and deletes the chunks
+// whose data is missing, cannot be decrypted or is rejected by validate
+//
+// The store remains available while it is scanned.
+func (s *LDBStore) Scrub(validate func(key Key, data []byte) bool) (*ScrubResult, error) {
+	metrics.GetOrRegisterCounter("ldbstore.scrub", nil).Inc(1)
+	start := time.Now()
+	log.Info("ldbstore: integrity scan started")
+
+	res := &ScrubResult{}
+	it := s.db.NewIterator()
+	defer it.Release()
+	for it.Seek([]byte{keyIndex}); it.Valid(); it.Next() {
+		ikey := it.Key()
+		if len(ikey) == 0 || ikey[0] != keyIndex {
+			break
+		}
+		key := Key(common.CopyBytes(ikey[1:]))
+		res.Checked++
+		if !s.scrubChunk(key, validate) {
+			res.Corrupted = append(res.Corrupted, key)
+		}
+	}
+	if err := it.Error(); err != nil {
+		log.Error("ldbstore: integrity scan failed", "err", err)
+		return nil, err
+	}
+	res.Duration = time.Since(start)
+	metrics.GetOrRegisterCounter("ldbstore.scrub.corrupted", nil).Inc(int64(len(res.Corrupted)))
+	log.Info("ldbstore: integrity scan done", "checked", res.Checked, "corrupted", len(res.Corrupted), "elapsed", res.Duration)
+	return res, nil
+}
+
+// checks the data of the chunk and deletes the chunk if it is corrupted,
+// it reports whether the chunk is intact
+func (s *LDBStore) scrubChunk(key Key, validate func(Key, []byte) bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var index dpaDBIndex
+	idata, err := s.db.Get(getIndexKey(key))
+	if err != nil {
+		// deleted since the scan started
+		return true
+	}
+	if err := decodeIndex(idata, &index); err != nil {
+		log.Warn("ldbstore: cannot decode chunk index", "key", key, "err", err)
+		return true
+	}
+	var data []byte
+	if s.getDataFunc != nil {
+		data, err = s.getDataFunc(key)
+	} else {
+		data, err = s.getData(getDataKey(index.Idx, s.po(key)))
+	}
+	if err == nil && len(data) >= 40 && validate(key, data[32:]) {
+		return true
+	}
+	log.Warn("ldbstore: deleting corrupted chunk", "key", key, "err", err)
+	s.delete(index.Idx, getIndexKey(key), s.po(key), index.Cached > 0)
+	return false
+}
+
+// Scrub checks the chunks in the LDBStore with the validators of the store,
+// and deletes the corrupted ones from both the LDBStore and the MemStore
+func (self *LocalStore) Scrub() (*ScrubResult, error) {
+	res, err := self.DbStore.Scrub(self.valid)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range res.Corrupted {
+		self.memStore.Delete(key)
+	}
+	return res, nil
+}
+
+// scrubs the local store every interval until the store is closed
+func (self *LocalStore) scrubPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.DbStore.quit:
+			return
+		case <-ticker.C:
+			self.Scrub()
+		}
+	}
+}
+
+// Scrub deletes the corrupted chunks from the local store and retrieves them
+// again from the network
+//
+// The chunks that cannot be retrieved within the timeout of Get stay deleted.
+func (self *NetStore) Scrub() (*ScrubResult, error) {
+	res, err := self.localStore.Scrub()
+	if err != nil || self.retrieve == nil {
+		return res, err
+	}
+	var wg sync.WaitGroup
+	var refetched int64
+	sem := make(chan struct{}, scrubRefetchConcurrency)
+	for _, key := range res.Corrupted {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key Key) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if _, err := self.Get(key); err != nil {
+				log.Warn("cannot retrieve corrupted chunk", "key", key, "err", err)
+				return
+			}
+			atomic.AddInt64(&refetched, 1)
+		}(key)
+	}
+	wg.Wait()
+	res.Refetched = int(refetched)
+	return res, nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ShardedDatabase is a KVStore that spreads its entries over several
+// databases, so that the chunk store of a node can use several disks
+//
+// An entry is kept in the shard selected by the last byte of its key, which
+// is a byte of the chunk key or of the storage index for the chunk data and
+// index entries, so they are spread evenly. The iterators merge the shards in
+// key order. Batches are written to each shard atomically, but not to all of
+// them at once.
+//
+// Every shard records its position and the number of shards, it is an error to
+// open them in another order or with another number of shards.
+type ShardedDatabase struct {
+	shards []KVStore
+}
+
+// key of the position and number of shards recorded in each shard, it sorts
+// after all keys of the LDBStore
+var keyShard = []byte{0xff, 's', 'h', 'a', 'r', 'd'}
+
+// NewShardedDatabase opens a leveldb database in each of the paths and returns
+// them as a single sharded database
+func NewShardedDatabase(paths []string) (*ShardedDatabase, error) {
+	var shards []KVStore
+	for _, path := range paths {
+		db, err := NewLDBDatabase(path)
+		if err != nil {
+			closeShards(shards)
+			return nil, err
+		}
+		shards = append(shards, db)
+	}
+	return newShardedDatabase(shards)
+}
+
+func newShardedDatabase(shards []KVStore) (*ShardedDatabase, error) {
+	if len(shards) == 0 || len(shards) > 0x100 {
+		closeShards(shards)
+		return nil, fmt.Errorf("invalid number of shards %d", len(shards))
+	}
+	for i, shard := range shards {
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint16(value, uint16(i))
+		binary.BigEndian.PutUint16(value[2:], uint16(len(shards)))
+		recorded, err := shard.Get(keyShard)
+		if err != nil {
+			shard.Put(keyShard, value)
+			continue
+		}
+		if !bytes.Equal(recorded, value) {
+			closeShards(shards)
+			return nil, fmt.Errorf("shard %d of %d was created at another position or with another number of shards", i, len(shards))
+		}
+	}
+	return &ShardedDatabase{shards: shards}, nil
+}
+
+func closeShards(shards []KVStore) {
+	for _, shard := range shards {
+		shard.Close()
+	}
+}
+
+// returns the position of the shard the entry with the key is kept in
+func (self *ShardedDatabase) shardIndex(key []byte) int {
+	if len(key) == 0 {
+		return 0
+	}
+	return int(key[len(key)-1]) % len(self.shards)
+}
+
+func (self *ShardedDatabase) Get(key []byte) ([]byte, error) {
+	return self.shards[self.shardIndex(key)].Get(key)
+}
+
+func (self *ShardedDatabase) Put(key []byte, value []byte) {
+	self.shards[self.shardIndex(key)].Put(key, value)
+}
+
+func (self *ShardedDatabase) Delete(key []byte) error {
+	return self.shards[self.shardIndex(key)].Delete(key)
+}
+
+func (self *ShardedDatabase) NewIterator() KVIterator {
+	it := &shardedIterator{cur: -1}
+	for _, shard := range self.shards {
+		it.its = append(it.its, shard.NewIterator())
+	}
+	return it
+}
+
+func (self *ShardedDatabase) NewBatch() KVBatch {
+	b := &shardedBatch{db: self}
+	for _, shard := range self.shards {
+		b.batches = append(b.batches, shard.NewBatch())
+	}
+	return b
+}
+
+// Write writes the batch of each shard, it stops at the first shard that fails
+func (self *ShardedDatabase) Write(batch KVBatch) error {
+	b, ok := batch.(*shardedBatch)
+	if !ok || b.db != self {
+		return fmt.Errorf("unsupported batch type %T", batch)
+	}
+	for i, shard := range self.shards {
+		if b.batches[i].Len() == 0 {
+			continue
+		}
+		if err := shard.Write(b.batches[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Size returns the sum of the sizes of the shards
+func (self *ShardedDatabase) Size() (uint64, error) {
+	var size uint64
+	for _, shard := range self.shards {
+		s, err := shard.Size()
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	return size, nil
+}
+
+func (self *ShardedDatabase) Compact(start, limit []byte) error {
+	for _, shard := range self.shards {
+		if err := shard.Compact(start, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *ShardedDatabase) Close() {
+	closeShards(self.shards)
+}
+
+// shardedBatch collects the operations of a batch for each shard
+type shardedBatch struct {
+	db      *ShardedDatabase
+	batches []KVBatch
+}
+
+func (b *shardedBatch) Put(key []byte, value []byte) {
+	b.batches[b.db.shardIndex(key)].Put(key, value)
+}
+
+func (b *shardedBatch) Delete(key []byte) {
+	b.batches[b.db.shardIndex(key)].Delete(key)
+}
+
+func (b *shardedBatch) Len() int {
+	var n int
+	for _, batch := range b.batches {
+		n += batch.Len()
+	}
+	return n
+}
+
+// shardedIterator merges the iterators of the shards, it is positioned at the
+// iterator with the smallest key
+type shardedIterator struct {
+	its     []KVIterator
+	cur     int // position of the current iterator, -1 if none is valid
+	started bool
+}
+
+func (it *shardedIterator) Seek(key []byte) bool {
+	for _, i := range it.its {
+		i.Seek(key)
+	}
+	it.started = true
+	return it.next()
+}
+
+func (it *shardedIterator) Next() bool {
+	if !it.started {
+		// like a leveldb iterator, the first move goes to the first entry
+		for _, i := range it.its {
+			i.Next()
+		}
+		it.started = true
+	} else if it.cur >= 0 {
+		it.its[it.cur].Next()
+	}
+	return it.next()
+}
+
+// positions the iterator at the smallest key of the shard iterators
+func (it *shardedIterator) next() bool {
+	it.cur = -1
+	for n, i := range it.its {
+		if !i.Valid() {
+			continue
+		}
+		if it.cur < 0 || bytes.Compare(i.Key(), it.its[it.cur].Key()) < 0 {
+			it.cur = n
+		}
+	}
+	return it.cur >= 0
+}
+
+func (it *shardedIterator) Valid() bool {
+	return it.cur >= 0
+}
+
+func (it *shardedIterator) Key() []byte {
+	if it.cur < 0 {
+		return nil
+	}
+	return it.its[it.cur].Key()
+}
+
+func (it *shardedIterator) Value() []byte {
+	if it.cur < 0 {
+		return nil
+	}
+	return it.its[it.cur].Value()
+}
+
+func (it *shardedIterator) Error() error {
+	for _, i := range it.its {
+		if err := i.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *shardedIterator) Release() {
+	for _, i := range it.its {
+		i.Release()
+	}
+}
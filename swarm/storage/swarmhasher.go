@@ -18,6 +18,9 @@ package storage
 
 import (
 	"hash"
+
+	"github.com/ethereum/go-ethereum/bmt"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
 )
 
 const (
@@ -39,3 +42,22 @@ func (self *HashWithLength) ResetWithLength(length []byte) {
 	self.Reset()
 	self.Write(length)
 }
+
+// ChunkProof returns the inclusion proof of the segment at the index of the
+// chunk data in the BMT hash of the chunk
+func ChunkProof(chunkData ChunkData, index int) (*bmt.Proof, error) {
+	if len(chunkData) < 8 {
+		return nil, ErrChunkInvalid
+	}
+	return newRefHasher().Proof(chunkData.Data(), index, chunkData[:8])
+}
+
+// VerifyChunkProof tells if the proof proves the inclusion of its segment in
+// the chunk under the key
+func VerifyChunkProof(key Key, proof *bmt.Proof) bool {
+	return newRefHasher().VerifyProof(key, proof)
+}
+
+func newRefHasher() *bmt.RefHasher {
+	return bmt.NewRefHasher(sha3.NewKeccak256, bmt.DefaultSegmentCount)
+}
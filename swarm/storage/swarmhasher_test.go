@@ -0,0 +1,49 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"testing"
+)
+
+// TestChunkProof tests that the segments of a chunk are proven to be included
+// in the chunk under its key
+func TestChunkProof(t *testing.T) {
+	chunk := GenerateRandomChunk(DefaultChunkSize - 100)
+	segments := int(DefaultChunkSize-100+31) / 32
+	for i := 0; i < segments; i++ {
+		proof, err := ChunkProof(chunk.SData, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyChunkProof(chunk.Key, proof) {
+			t.Fatalf("proof of segment %d not verified", i)
+		}
+	}
+	if _, err := ChunkProof(chunk.SData, segments); err == nil {
+		t.Fatal("expected error for a segment beyond the chunk")
+	}
+
+	other := GenerateRandomChunk(DefaultChunkSize - 100)
+	proof, err := ChunkProof(other.SData, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyChunkProof(chunk.Key, proof) {
+		t.Fatal("proof of another chunk verified")
+	}
+}
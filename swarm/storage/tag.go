@@ -0,0 +1,288 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrUnknownTag = errors.New("unknown tag")
+
+var (
+	// Time after which a tag is forgotten even if its upload is not
+	// complete, so that abandoned uploads do not leak their tags
+	tagTTL = 24 * time.Hour
+	// Time a tag is kept for after all its chunks are synced, so that the
+	// progress of the upload can still be queried
+	completedTagTTL = 10 * time.Minute
+)
+
+// State is a state of the chunks of an upload counted by a Tag
+type State uint32
+
+const (
+	StateSplit  State = iota // chunk has been processed by the chunker
+	StateStored              // chunk has been stored in the local store
+	StateSent                // chunk has been sent to a peer
+	StateSynced              // chunk has been stored by a peer
+)
+
+// Tag tracks the progress of an upload by counting its chunks in each
+// state, it is safe for concurrent use
+//
+// A chunk is counted as synced once the peer it was synced to confirmed
+// storing it with a receipt, or if receipts are not used, once it was
+// delivered to or was already stored by the peer.
+type Tag struct {
+	Uid       uint32    // unique identifier of the tag
+	Name      string    // name of the upload, eg. the file name
+	StartedAt time.Time // time the tag was created
+
+	split   uint32
+	stored  uint32
+	sent    uint32
+	synced  uint32
+	tracked uint32 // distinct chunks tracked until synced, duplicates are split but not tracked
+
+	tags        *Tags     // registry tracking the chunks of the tag
+	completedAt time.Time // time the last tracked chunk was synced, guarded by the lock of tags
+}
+
+// TagStatus is a snapshot of the counters of a Tag
+type TagStatus struct {
+	Uid       uint32    `json:"uid"`
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"startedAt"`
+	Split     uint32    `json:"split"`
+	Stored    uint32    `json:"stored"`
+	Sent      uint32    `json:"sent"`
+	Synced    uint32    `json:"synced"`
+	Tracked   uint32    `json:"tracked"`
+}
+
+func (t *Tag) counter(s State) *uint32 {
+	switch s {
+	case StateSplit:
+		return &t.split
+	case StateStored:
+		return &t.stored
+	case StateSent:
+		return &t.sent
+	case StateSynced:
+		return &t.synced
+	}
+	return nil
+}
+
+// Inc increments the count of chunks in the state
+func (t *Tag) Inc(s State) {
+	if c := t.counter(s); c != nil {
+		atomic.AddUint32(c, 1)
+	}
+}
+
+// Get returns the count of chunks in the state
+func (t *Tag) Get(s State) uint32 {
+	if c := t.counter(s); c != nil {
+		return atomic.LoadUint32(c)
+	}
+	return 0
+}
+
+// Status returns a snapshot of the counters of the tag
+func (t *Tag) Status() *TagStatus {
+	return &TagStatus{
+		Uid:       t.Uid,
+		Name:      t.Name,
+		StartedAt: t.StartedAt,
+		Split:     t.Get(StateSplit),
+		Stored:    t.Get(StateStored),
+		Sent:      t.Get(StateSent),
+		Synced:    t.Get(StateSynced),
+		Tracked:   atomic.LoadUint32(&t.tracked),
+	}
+}
+
+// Done tells if all chunks split so far are in the state
+//
+// Chunks are only sent and synced once, so all chunks are sent or synced
+// if the distinct chunks tracked are, even if some chunks of the upload are
+// duplicates.
+func (t *Tag) Done(s State) bool {
+	total := t.Get(StateSplit)
+	if s == StateSent || s == StateSynced {
+		total = atomic.LoadUint32(&t.tracked)
+	}
+	return total > 0 && t.Get(s) == total
+}
+
+// chunkSplit counts the chunk split and tracks it until it is synced
+func (t *Tag) chunkSplit(key Key) {
+	t.Inc(StateSplit)
+	if t.tags != nil {
+		t.tags.track(key, t)
+	}
+}
+
+// tagChunk is a stored chunk of a tag not yet synced
+type tagChunk struct {
+	tag  *Tag
+	sent bool
+}
+
+// Tags is the registry of the tags of the uploads, it maps the chunks stored
+// locally to their tags so that the network layer can count them as sent and
+// synced, it is safe for concurrent use
+//
+// The tags are only kept in memory, they are forgotten completedTagTTL after
+// all their chunks are synced, or tagTTL after they are created.
+type Tags struct {
+	mu     sync.Mutex
+	uid    uint32
+	tags   map[uint32]*Tag
+	chunks map[string]*tagChunk
+}
+
+// NewTags returns an empty tag registry
+func NewTags() *Tags {
+	return &Tags{
+		tags:   make(map[uint32]*Tag),
+		chunks: make(map[string]*tagChunk),
+	}
+}
+
+// New creates a tag with the name and a new uid
+func (ts *Tags) New(name string) *Tag {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.expire(time.Now())
+	ts.uid++
+	t := &Tag{
+		Uid:       ts.uid,
+		Name:      name,
+		StartedAt: time.Now(),
+		tags:      ts,
+	}
+	ts.tags[t.Uid] = t
+	return t
+}
+
+// Get returns the tag with the uid
+func (ts *Tags) Get(uid uint32) (*Tag, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.expire(time.Now())
+	t, ok := ts.tags[uid]
+	if !ok {
+		return nil, ErrUnknownTag
+	}
+	return t, nil
+}
+
+// All returns all tags ordered by uid
+func (ts *Tags) All() []*Tag {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.expire(time.Now())
+	tags := make([]*Tag, 0, len(ts.tags))
+	for uid := uint32(1); uid <= ts.uid; uid++ {
+		if t, ok := ts.tags[uid]; ok {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// Delete removes the tag and stops tracking its chunks
+func (ts *Tags) Delete(uid uint32) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.delete(uid)
+}
+
+// delete removes the tag; must be called with the lock held
+func (ts *Tags) delete(uid uint32) {
+	delete(ts.tags, uid)
+	for k, c := range ts.chunks {
+		if c.tag.Uid == uid {
+			delete(ts.chunks, k)
+		}
+	}
+}
+
+// expire removes the tags completed for longer than completedTagTTL and
+// the tags older than tagTTL; must be called with the lock held
+func (ts *Tags) expire(now time.Time) {
+	for uid, t := range ts.tags {
+		completed := !t.completedAt.IsZero() && t.Done(StateSynced) && now.Sub(t.completedAt) > completedTagTTL
+		if completed || now.Sub(t.StartedAt) > tagTTL {
+			ts.delete(uid)
+		}
+	}
+}
+
+// track tracks the chunk of the tag until it is synced, unless it is
+// already tracked as a duplicate chunk of this or another upload
+func (ts *Tags) track(key Key, t *Tag) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.chunks[string(key)]; !ok {
+		ts.chunks[string(key)] = &tagChunk{tag: t}
+		atomic.AddUint32(&t.tracked, 1)
+	}
+}
+
+// Sent counts the chunk as sent by the tag of the chunk, chunks sent to
+// several peers are counted once, it is a noop for untracked chunks
+func (ts *Tags) Sent(key Key) {
+	if ts == nil {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if c, ok := ts.chunks[string(key)]; ok && !c.sent {
+		c.sent = true
+		c.tag.Inc(StateSent)
+	}
+}
+
+// Synced counts the chunk as synced by the tag of the chunk and stops
+// tracking it, it is a noop for untracked chunks
+func (ts *Tags) Synced(key Key) {
+	if ts == nil {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if c, ok := ts.chunks[string(key)]; ok {
+		delete(ts.chunks, string(key))
+		c.tag.Inc(StateSynced)
+		if c.tag.Done(StateSynced) {
+			c.tag.completedAt = time.Now()
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestTagDPA tests that the chunks stored by a DPA with a tag are counted
+func TestTagDPA(t *testing.T) {
+	tags := NewTags()
+	tag := tags.New("test")
+	dpa := NewDPA(NewMapChunkStore(), NewDPAParams()).WithTag(tag)
+
+	// 130 data chunks, 2 tree chunks on the first level and the root
+	_, data := generateRandomData(130 * 4096)
+	_, wait, err := dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	if split := tag.Get(StateSplit); split != 133 {
+		t.Fatalf("expected 133 chunks split, got %d", split)
+	}
+	if !tag.Done(StateStored) {
+		t.Fatalf("expected all chunks stored, got %+v", tag.Status())
+	}
+	if n := len(tags.chunks); n != 133 {
+		t.Fatalf("expected 133 chunks tracked, got %d", n)
+	}
+}
+
+// TestTags tests that chunks are counted as sent and synced once
+func TestTags(t *testing.T) {
+	tags := NewTags()
+	tag1 := tags.New("1")
+	tag2 := tags.New("2")
+	if tag1.Uid == tag2.Uid {
+		t.Fatalf("expected unique uids, got %d twice", tag1.Uid)
+	}
+	if tag, err := tags.Get(tag2.Uid); err != nil || tag != tag2 {
+		t.Fatalf("expected tag %d, got %v (%v)", tag2.Uid, tag, err)
+	}
+	if _, err := tags.Get(tag2.Uid + 1); err != ErrUnknownTag {
+		t.Fatalf("expected %v, got %v", ErrUnknownTag, err)
+	}
+
+	key1, key2 := Key{1}, Key{2}
+	tag1.chunkSplit(key1)
+	tag2.chunkSplit(key2)
+
+	tags.Sent(key1)
+	tags.Sent(key1)
+	tags.Sent(Key{3})
+	if sent := tag1.Get(StateSent); sent != 1 {
+		t.Fatalf("expected 1 chunk sent, got %d", sent)
+	}
+	tags.Synced(key1)
+	tags.Synced(key1)
+	if synced := tag1.Get(StateSynced); synced != 1 || !tag1.Done(StateSynced) {
+		t.Fatalf("expected 1 chunk synced, got %d", synced)
+	}
+	if sent, synced := tag2.Get(StateSent), tag2.Get(StateSynced); sent != 0 || synced != 0 {
+		t.Fatalf("expected no chunks of tag 2 sent or synced, got %d and %d", sent, synced)
+	}
+
+	tags.Delete(tag2.Uid)
+	if all := tags.All(); len(all) != 1 || all[0] != tag1 {
+		t.Fatalf("expected only tag %d, got %v", tag1.Uid, all)
+	}
+	tags.Synced(key2)
+	if synced := tag2.Get(StateSynced); synced != 0 {
+		t.Fatalf("expected chunks of deleted tag not synced, got %d", synced)
+	}
+}
+
+// TestTagDuplicateChunks tests that an upload with duplicate chunks is synced
+// once its distinct chunks are
+func TestTagDuplicateChunks(t *testing.T) {
+	tags := NewTags()
+	tag := tags.New("test")
+
+	key1, key2 := Key{1}, Key{2}
+	tag.chunkSplit(key1)
+	tag.chunkSplit(key1)
+	tag.chunkSplit(key2)
+	if split, tracked := tag.Get(StateSplit), tag.Status().Tracked; split != 3 || tracked != 2 {
+		t.Fatalf("expected 3 chunks split and 2 tracked, got %d and %d", split, tracked)
+	}
+	tags.Synced(key1)
+	if tag.Done(StateSynced) {
+		t.Fatal("expected upload not synced")
+	}
+	tags.Synced(key2)
+	if !tag.Done(StateSynced) {
+		t.Fatalf("expected upload synced, got %+v", tag.Status())
+	}
+}
+
+// TestTagsExpiry tests that tags are forgotten some time after their upload
+// is synced, or after the time to live of the tags if it is not
+func TestTagsExpiry(t *testing.T) {
+	defer func(ttl, completed time.Duration) {
+		tagTTL, completedTagTTL = ttl, completed
+	}(tagTTL, completedTagTTL)
+	tagTTL, completedTagTTL = time.Hour, 0
+
+	tags := NewTags()
+	synced := tags.New("synced")
+	pending := tags.New("pending")
+	synced.chunkSplit(Key{1})
+	pending.chunkSplit(Key{2})
+	tags.Synced(Key{1})
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := tags.Get(synced.Uid); err != ErrUnknownTag {
+		t.Fatalf("expected synced tag to expire, got %v", err)
+	}
+	if _, err := tags.Get(pending.Uid); err != nil {
+		t.Fatalf("expected pending tag, got %v", err)
+	}
+	if len(tags.chunks) != 1 {
+		t.Fatalf("expected 1 chunk tracked, got %d", len(tags.chunks))
+	}
+
+	tagTTL = 0
+	if all := tags.All(); len(all) != 0 {
+		t.Fatalf("expected all tags to expire, got %d", len(all))
+	}
+	if len(tags.chunks) != 0 {
+		t.Fatalf("expected no chunks tracked, got %d", len(tags.chunks))
+	}
+}
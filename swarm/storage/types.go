@@ -25,6 +25,7 @@ import (
 	"hash"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/bmt"
 	"github.com/ethereum/go-ethereum/common"
@@ -183,6 +184,13 @@ type Chunk struct {
 	dbStoredMu *sync.Mutex
 	errored    error // flag which is set when the chunk request has errored or timeouted
 	erroredMu  sync.Mutex
+	Expires    time.Time // the chunk may be removed from the store after this time, zero if it does not expire
+	Cached     bool      // the chunk was retrieved for a request and is only cached, the node is not responsible for it
+}
+
+// Expired reports whether the chunk has expired by now
+func (c *Chunk) Expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !now.Before(c.Expires)
 }
 
 func (c *Chunk) SetErrored(err error) {
@@ -270,6 +278,45 @@ type StoreParams struct {
 	CacheCapacity              uint
 	ChunkRequestsCacheCapacity uint
 	BaseKey                    []byte
+	GCPolicy                   GCPolicy      `toml:"-"` // garbage collection policy of the LDBStore, LRUGCPolicy if nil
+	DbSize                     uint64        // capacity of the LDBStore in bytes, overrides DbCapacity if set
+	CacheSize                  uint64        // capacity of the MemStore in bytes, overrides CacheCapacity if set
+	RequestsCacheSize          uint64        // memory limit of the outgoing requests in the MemStore in bytes, no limit if 0
+	CompactionInterval         time.Duration // interval of the background compactions of the LDBStore, none if 0
+	ScrubInterval              time.Duration // interval of the background integrity scans of the LocalStore, none if 0
+	CachedCapacity             uint64        // number of chunks only cached after retrieval kept in the LDBStore, no separate limit if 0
+	GCHighWatermark            float64       // share of the capacity of the LDBStore above which the background garbage collection starts, 1 if 0
+	GCLowWatermark             float64       // share of the capacity of the LDBStore the background garbage collection collects down to, 0.9 of the high watermark if 0
+	GCBatchSize                int           // chunks collected in one round of garbage collection of the LDBStore, 500 if 0
+	EncryptData                bool          // encrypt the chunk data in the LDBStore with a key derived from the node key
+	EncryptionKey              []byte        `toml:"-"` // key the chunk data in the LDBStore is encrypted with, no encryption if nil
+}
+
+// size of a full chunk in the store, its data and the 8 byte length prefix
+const storedChunkSize = DefaultChunkSize + 8
+
+// ChunkDbCapacity returns the capacity of the LDBStore in chunks
+func (self *StoreParams) ChunkDbCapacity() uint64 {
+	if self.DbSize > 0 {
+		return SizeToChunks(self.DbSize)
+	}
+	return self.DbCapacity
+}
+
+// ChunkCacheCapacity returns the capacity of the MemStore in chunks
+//
+// If the capacity is given in bytes, it is the number of the smallest chunks
+// that fit, the MemStore then evicts chunks by the memory they use.
+func (self *StoreParams) ChunkCacheCapacity() uint {
+	if self.CacheSize > 0 {
+		return uint(self.CacheSize / (memEntryOverhead + KeyLength))
+	}
+	return self.CacheCapacity
+}
+
+// SizeToChunks returns the number of full chunks that fit in size bytes
+func SizeToChunks(size uint64) uint64 {
+	return size / uint64(storedChunkSize)
 }
 
 func NewDefaultStoreParams() *StoreParams {
@@ -321,10 +368,23 @@ func (c ChunkData) Data() []byte {
 	return c[8:]
 }
 
+// ChunkValidator checks that the data of a chunk belongs to its key
+//
+// The LocalStore accepts a chunk if any of its validators accepts it, so each
+// validator only needs to recognise the kind of chunks it is responsible for,
+// like the content addressed chunks or the resource updates.
 type ChunkValidator interface {
 	Validate(key Key, data []byte) bool
 }
 
+// ChunkValidatorFunc is an adapter to use a function as a ChunkValidator
+type ChunkValidatorFunc func(key Key, data []byte) bool
+
+// Validate calls f(key, data)
+func (f ChunkValidatorFunc) Validate(key Key, data []byte) bool {
+	return f(key, data)
+}
+
 // Provides method for validation of content address in chunks
 // Holds the corresponding hasher to create the address
 type ContentAddressValidator struct {
@@ -340,13 +400,17 @@ func NewContentAddressValidator(hasher SwarmHasher) *ContentAddressValidator {
 
 // Validate that the given key is a valid content address for the given data
 func (self *ContentAddressValidator) Validate(key Key, data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
 	hasher := self.Hasher()
 	hasher.ResetWithLength(data[:8])
 	hasher.Write(data[8:])
 	hash := hasher.Sum(nil)
 
 	if !bytes.Equal(hash, key[:]) {
-		log.Error("invalid content address", "expected", fmt.Sprintf("%x", hash), "have", key)
+		// not an error, the chunk may be accepted by another validator
+		log.Trace("invalid content address", "expected", fmt.Sprintf("%x", hash), "have", key)
 		return false
 	}
 	return true
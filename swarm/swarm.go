@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"math/big"
 	"net"
+	"net/http"
 	"path/filepath"
 	"strings"
 	"time"
@@ -68,6 +69,7 @@ type Swarm struct {
 	dpa         *storage.DPA // distributed preimage archive, the local API to the storage with document level storage/retrieval support
 	streamer    *stream.Registry
 	bzz         *network.Bzz       // the logistic manager
+	kademlia    *network.Kademlia  // the overlay topology, notifies the peer events
 	backend     chequebook.Backend // simple blockchain Backend
 	privateKey  *ecdsa.PrivateKey
 	corsString  string
@@ -75,6 +77,11 @@ type Swarm struct {
 	lstore      *storage.LocalStore // local store, needs to store for releasing resources after node stopped
 	sfs         *fuse.SwarmFS       // need this to cleanup all the active mounts on node exit
 	ps          *pss.Pss
+	rn          *pss.ResourceNotifier    // pushes resource update notifications over pss
+	resource    *storage.ResourceHandler // mutable resources, needs to save its index after node stopped
+	tags        *storage.Tags            // progress of the uploads
+	stateStore  state.Store              // persists the state of the node, eg. the usage of the upload quotas
+	debugServer *http.Server             // serves the debug endpoints if a debug address is configured
 }
 
 type SwarmAPI struct {
@@ -121,6 +128,9 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	log.Debug(fmt.Sprintf("Setting up Swarm service components"))
 
 	config.HiveParams.Discovery = true
+	config.HiveParams.BootstrapNodes = ParseNodes(config.BootNodes, "bootnode")
+	config.HiveParams.StaticNodes = ParseNodes(config.StaticNodes, "static node")
+	config.HiveParams.TrustedNodes = ParseNodes(config.TrustedNodes, "trusted node")
 
 	log.Debug(fmt.Sprintf("-> swarm net store shared access layer to Swarm Chunk Store"))
 
@@ -138,12 +148,14 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 		OverlayAddr:  addr.OAddr,
 		UnderlayAddr: addr.UAddr,
 		HiveParams:   config.HiveParams,
+		Capabilities: network.DefaultCapabilities,
 	}
 
 	stateStore, err := state.NewDBStore(filepath.Join(config.Path, "state-store.db"))
 	if err != nil {
 		return
 	}
+	self.stateStore = stateStore
 
 	// set up high level api
 	var resolver *api.MultiResolver
@@ -172,17 +184,32 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 		common.FromHex(config.BzzKey),
 		network.NewKadParams(),
 	)
+	self.kademlia = to
 	delivery := stream.NewDelivery(to, db)
 
-	self.streamer = stream.NewRegistry(addr, delivery, db, stateStore, &stream.RegistryOptions{
+	streamerParams := stream.NewStreamerParams()
+	if config.RetrieveBackoff != nil {
+		streamerParams.RetrieveBackoff = config.RetrieveBackoff
+	}
+	self.tags = storage.NewTags()
+	registryOptions := &stream.RegistryOptions{
+		StreamerParams:  streamerParams,
 		SkipCheck:       config.DeliverySkipCheck,
 		DoSync:          config.SyncEnabled,
 		DoRetrieve:      true,
 		SyncUpdateDelay: config.SyncUpdateDelay,
-	})
+		Tags:            self.tags,
+	}
+	if config.SyncReceipts {
+		registryOptions.ReceiptKey = self.privateKey
+	}
+	self.streamer = stream.NewRegistry(addr, delivery, db, stateStore, registryOptions)
 
 	// set up DPA, the cloud storage local access layer
 	dpaChunkStore := storage.NewNetStore(self.lstore, self.streamer.Retrieve)
+	if config.RetrieveBackoff != nil {
+		dpaChunkStore.SetRetryBackoff(config.RetrieveBackoff)
+	}
 	// Swarm Hash Merklised Chunking for Arbitrary-length Document/File storage
 	self.dpa = storage.NewDPA(dpaChunkStore, self.config.DPAParams)
 
@@ -195,11 +222,13 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 		Signer: &storage.GenericResourceSigner{
 			PrivKey: self.privateKey,
 		},
-		HeaderGetter:   resolver,
-		OwnerValidator: resolver,
+		IndexPath: filepath.Join(filepath.Dir(config.LocalStoreParams.ChunkDbPath), storage.ResourceIndexFileName),
 	}
 	if resolver != nil {
 		resolver.SetNameHash(ens.EnsNode)
+		// a nil resolver must not be set as the interfaces would not be nil
+		rhparams.HeaderGetter = resolver
+		rhparams.OwnerValidator = resolver
 	} else {
 		log.Warn("No ETH API specified, resource updates will use block height approximation")
 		// TODO: blockestimator should use saved values derived from last time ethclient was connected
@@ -231,7 +260,9 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	if pss.IsActiveHandshake {
 		pss.SetHandshakeController(self.ps, pss.NewHandshakeParams())
 	}
+	self.rn = pss.NewResourceNotifier(self.ps, resourceHandler)
 
+	self.resource = resourceHandler
 	self.api = api.NewApi(self.dpa, self.dns, resourceHandler)
 	// Manifests for Smart Hosting
 	log.Debug(fmt.Sprintf("-> Web3 virtual server API"))
@@ -274,6 +305,12 @@ type ensClient struct {
 	*ethclient.Client
 }
 
+// ValidateOwner allows the owner of the name and the address the owner
+// approved in its resolver to update the resources of the name
+func (self *ensClient) ValidateOwner(name string, address common.Address) (bool, error) {
+	return (&storage.ENSOwnerValidator{ENS: self.ENS}).ValidateOwner(name, address)
+}
+
 // newEnsClient creates a new ENS client for that is a consumer of
 // a ENS API on a specific endpoint. It is used as a helper function
 // for creating multiple resolvers in NewSwarm function.
@@ -340,6 +377,24 @@ func detectEnsAddr(client *rpc.Client) (common.Address, error) {
 	}
 }
 
+// ParseNodes parses the comma separated enode URLs, the invalid ones are
+// logged and skipped
+func ParseNodes(urls string, kind string) []*discover.Node {
+	if urls == "" {
+		return nil
+	}
+	var nodes []*discover.Node
+	for _, url := range strings.Split(urls, ",") {
+		n, err := discover.ParseNode(url)
+		if err != nil {
+			log.Error(fmt.Sprintf("Invalid swarm %s", kind), "url", url, "err", err)
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
 /*
 Start is called when the stack is started
 * starts the network kademlia hive peer management
@@ -380,15 +435,39 @@ func (self *Swarm) Start(srv *p2p.Server) error {
 
 	if self.ps != nil {
 		self.ps.Start(srv)
+		self.rn.Start()
 		log.Info("Pss started")
 	}
 
 	// start swarm http proxy server
 	if self.config.Port != "" {
 		addr := net.JoinHostPort(self.config.ListenAddr, self.config.Port)
+		var quotas *api.UploadQuotas
+		if self.config.UploadQuota > 0 || len(self.config.UploadQuotas) > 0 {
+			quotas, err = api.NewUploadQuotas(self.config.UploadQuota, self.config.UploadQuotas, self.stateStore)
+			if err != nil {
+				return err
+			}
+		}
+		var auth *api.WriteAuth
+		if len(self.config.WriteTokens) > 0 || len(self.config.WriteUsers) > 0 {
+			auth = api.NewWriteAuth(self.config.WriteTokens, self.config.WriteUsers)
+		}
 		go httpapi.StartHttpServer(self.api, &httpapi.ServerConfig{
 			Addr:       addr,
 			CorsString: self.config.Cors,
+			Quotas:     quotas,
+			Tags:       self.tags,
+			Auth:       auth,
+			Immutable:  self.config.Immutable,
+
+			FrameOptions: self.config.FrameOptions,
+			CSP:          self.config.CSP,
+
+			RateLimit:     self.config.RateLimit,
+			RateBurst:     self.config.RateBurst,
+			MaxUploadSize: self.config.MaxUploadSize,
+			MaxRequests:   self.config.MaxRequests,
 		})
 	}
 
@@ -398,6 +477,12 @@ func (self *Swarm) Start(srv *p2p.Server) error {
 		log.Debug(fmt.Sprintf("Swarm http proxy started with corsdomain: %v", self.config.Cors))
 	}
 
+	if self.config.DebugAddr != "" {
+		if err := self.startDebugServer(); err != nil {
+			return err
+		}
+	}
+
 	self.periodicallyUpdateGauges()
 
 	startCounter.Inc(1)
@@ -405,6 +490,22 @@ func (self *Swarm) Start(srv *p2p.Server) error {
 	return nil
 }
 
+// startDebugServer starts the debug listener on the configured address
+func (self *Swarm) startDebugServer() error {
+	listener, err := net.Listen("tcp", self.config.DebugAddr)
+	if err != nil {
+		return fmt.Errorf("unable to start debug listener: %v", err)
+	}
+	self.debugServer = &http.Server{Handler: self.debugHandler()}
+	log.Info("Starting swarm debug server", "addr", fmt.Sprintf("http://%s/debug/swarm/", listener.Addr()))
+	go func() {
+		if err := self.debugServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("Failure in running swarm debug server", "err", err)
+		}
+	}()
+	return nil
+}
+
 func (self *Swarm) periodicallyUpdateGauges() {
 	ticker := time.NewTicker(updateGaugesPeriod)
 
@@ -424,6 +525,7 @@ func (self *Swarm) updateGauges() {
 // stops all component services.
 func (self *Swarm) Stop() error {
 	if self.ps != nil {
+		self.rn.Stop()
 		self.ps.Stop()
 	}
 	if ch := self.config.Swap.Chequebook(); ch != nil {
@@ -431,10 +533,18 @@ func (self *Swarm) Stop() error {
 		ch.Save()
 	}
 
+	if self.resource != nil {
+		if err := self.resource.SaveIndex(); err != nil {
+			log.Error("resource index save fail", "err", err)
+		}
+	}
 	if self.lstore != nil {
 		self.lstore.DbStore.Close()
 	}
 	self.sfs.Stop()
+	if self.debugServer != nil {
+		self.debugServer.Close()
+	}
 	stopCounter.Inc(1)
 	self.streamer.Stop()
 	return self.bzz.Stop()
@@ -474,7 +584,37 @@ func (self *Swarm) APIs() []rpc.API {
 		{
 			Namespace: "bzz",
 			Version:   "3.0",
-			Service:   api.NewControl(self.api, self.bzz.Hive),
+			Service:   api.NewControl(self.api, self.bzz.Hive, self.lstore),
+			Public:    false,
+		},
+		{
+			Namespace: "bzz",
+			Version:   "3.0",
+			Service:   api.NewResource(self.api),
+			Public:    false,
+		},
+		{
+			Namespace: "bzz",
+			Version:   "3.0",
+			Service:   api.NewTags(self.tags),
+			Public:    false,
+		},
+		{
+			Namespace: "bzz",
+			Version:   "3.0",
+			Service:   api.NewManifests(self.api),
+			Public:    false,
+		},
+		{
+			Namespace: "bzz",
+			Version:   "3.0",
+			Service:   self.streamer.RetrievalTraces(),
+			Public:    false,
+		},
+		{
+			Namespace: "bzz",
+			Version:   "3.0",
+			Service:   api.NewEvents(self.lstore, self.kademlia, self.streamer, self.resource),
 			Public:    false,
 		},
 		{
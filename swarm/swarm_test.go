@@ -17,9 +17,13 @@
 package swarm
 
 import (
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -179,6 +183,95 @@ func TestNewSwarm(t *testing.T) {
 	}
 }
 
+// TestDebugHandler checks that the debug listener serves its index, the
+// profiles and the state of the node.
+func TestDebugHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := api.NewConfig()
+	config.Path = dir
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Init(privkey)
+
+	s, err := NewSwarm(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.lstore.DbStore.Close()
+
+	srv := httptest.NewServer(s.debugHandler())
+	defer srv.Close()
+
+	get := func(path string) []byte {
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", path, http.StatusOK, res.StatusCode, body)
+		}
+		return body
+	}
+
+	index := string(get("/debug/swarm/"))
+	for _, path := range debugIndex {
+		if !strings.Contains(index, path) {
+			t.Fatalf("expected %s in debug index, got %s", path, index)
+		}
+	}
+	res, err := http.Get(srv.URL + "/debug/swarm/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status %d for unknown endpoint, got %d", http.StatusNotFound, res.StatusCode)
+	}
+	if body := get("/debug/pprof/goroutine?debug=1"); !strings.Contains(string(body), "goroutine profile") {
+		t.Fatalf("unexpected goroutine profile: %s", body)
+	}
+	if body := get("/debug/vars"); !strings.Contains(string(body), "memstats") {
+		t.Fatalf("expected memstats in runtime stats, got %s", body)
+	}
+	if body := get("/debug/swarm/kademlia"); !strings.Contains(string(body), "KΛÐΞMLIΛ") {
+		t.Fatalf("unexpected kademlia table: %s", body)
+	}
+
+	var streams []interface{}
+	if err := json.Unmarshal(get("/debug/swarm/streams"), &streams); err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 0 {
+		t.Fatalf("expected no streams, got %v", streams)
+	}
+
+	var store struct {
+		Store map[string]interface{} `json:"store"`
+		Cache map[string]interface{} `json:"cache"`
+	}
+	if err := json.Unmarshal(get("/debug/swarm/store"), &store); err != nil {
+		t.Fatal(err)
+	}
+	if store.Store["capacity"] != float64(config.DbCapacity) {
+		t.Fatalf("expected store capacity %d, got %v", config.DbCapacity, store.Store["capacity"])
+	}
+	if store.Cache == nil {
+		t.Fatal("expected cache stats")
+	}
+}
+
 func TestParseEnsAPIAddress(t *testing.T) {
 	for _, x := range []struct {
 		description string